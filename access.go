@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+// Subdirectories can be restricted to certain users
+// (Basic Auth usernames or token names)
+// with serve -access,
+// whose value is a semicolon-separated list of SUBDIR=USER,USER,...
+// as in "kids=alice,bob,kodi-kids;private=alice".
+// A restriction on a subdirectory applies also to the subdirectories beneath it.
+//
+// Other users do not see a restricted subdirectory,
+// or its titles in any other listing,
+// and get 403 Forbidden when requesting it or its titles' files directly.
+
+// parseAccess parses the value of serve -access
+// into a map from subdirectory to the users allowed in it.
+func parseAccess(s string) (map[string]set.Of[string], error) {
+	if s == "" {
+		return nil, nil
+	}
+	result := make(map[string]set.Of[string])
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		subdir, users, ok := strings.Cut(entry, "=")
+		subdir = strings.Trim(strings.TrimSpace(subdir), "/")
+		if !ok || subdir == "" {
+			return nil, fmt.Errorf("bad access entry %q, want SUBDIR=USER,USER,...", entry)
+		}
+		if _, ok := result[subdir]; ok {
+			return nil, fmt.Errorf("duplicate access entry for %s", subdir)
+		}
+		allowed := set.New[string]()
+		for _, user := range strings.Split(users, ",") {
+			if user = strings.TrimSpace(user); user != "" {
+				allowed.Add(user)
+			}
+		}
+		result[subdir] = allowed
+	}
+	return result, nil
+}
+
+// accessName is the user whose access rights apply to the given principal.
+// The links that the server issues for sharing, casting, and playlists
+// act on behalf of the user they were issued to.
+func accessName(who string) string {
+	for _, prefix := range []string{"share:", "cast:", "playlist:"} {
+		if rest, ok := strings.CutPrefix(who, prefix); ok {
+			return rest
+		}
+	}
+	return who
+}
+
+// mayAccess tells whether the principal who may see the given subdirectory.
+func (s *server) mayAccess(who, subdir string) bool {
+	access := s.accessRules()
+	if len(access) == 0 {
+		return true
+	}
+	who = accessName(who)
+	for sd, allowed := range access {
+		if (subdir == sd || strings.HasPrefix(subdir, sd+"/")) && !allowed.Has(who) {
+			return false
+		}
+	}
+	return true
+}
+
+// restricted tells whether some titles are not visible to all requesters,
+// because of -access or -pin.
+func (s *server) restricted() bool {
+	return len(s.accessRules()) > 0 || s.pin != ""
+}
+
+// accessRules returns the server's subdirectory restrictions,
+// which serve -config can change (see reload.go).
+func (s *server) accessRules() map[string]set.Of[string] {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.access
+}
+
+// mayView tells whether the requester may see the title with the given info:
+// whether its subdirectory is open to them
+// and, if it is flagged as adult, whether they have given the PIN (see pin.go).
+func (s *server) mayView(ctx context.Context, info movieInfo) bool {
+	return s.mayAccess(principal(ctx), info.subdir) && (!info.adult || s.pin == "" || pinUnlocked(ctx))
+}
+
+// viewableInfoMap returns the entries of s.infoMap for the titles the requester may see,
+// leaving out hidden ones.
+// The caller must hold s.mu.
+func (s *server) viewableInfoMap(ctx context.Context) map[string]movieInfo {
+	result := make(map[string]movieInfo)
+	for rootName, info := range s.infoMap {
+		if !info.hidden && s.mayView(ctx, info) {
+			result[rootName] = info
+		}
+	}
+	return result
+}
+
+// accessible wraps an include function for titleItems and titleGroups
+// so that it also excludes the titles the requester may not see.
+func (s *server) accessible(ctx context.Context, include func(info movieInfo, ok bool) bool) func(info movieInfo, ok bool) bool {
+	if !s.restricted() {
+		return include
+	}
+	return func(info movieInfo, ok bool) bool {
+		return s.mayView(ctx, info) && include(info, ok)
+	}
+}
+
+// checkAccess returns a 403 error if the named object
+// belongs to a title that the requester may not see,
+// either as its media (or NFO, poster, etc.)
+// or as one of its parts.
+func (s *server) checkAccess(ctx context.Context, objName string) error {
+	if !s.restricted() {
+		return nil
+	}
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rootName := range itemRoots(objName) {
+		if err := s.titleAccessErr(ctx, rootName); err != nil {
+			return err
+		}
+	}
+	for rootName, info := range s.infoMap {
+		if info.parts == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(info.parts, objName); ok {
+			if err := s.titleAccessErr(ctx, rootName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// titleAccessErr returns a 403 error if the requester may not see the title with the given root name.
+// The caller must hold s.mu.
+func (s *server) titleAccessErr(ctx context.Context, rootName string) error {
+	info := s.infoMap[rootName]
+	if s.mayView(ctx, info) {
+		return nil
+	}
+	if !s.mayAccess(principal(ctx), info.subdir) {
+		return mid.CodeErr{
+			C:   http.StatusForbidden,
+			Err: fmt.Errorf("%s may not access %s", principal(ctx), rootName),
+		}
+	}
+	return mid.CodeErr{
+		C:   http.StatusForbidden,
+		Err: fmt.Errorf("PIN required for %s", rootName),
+	}
+}
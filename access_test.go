@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+func TestAccess(t *testing.T) {
+	access, err := parseAccess("Kids=alice, kodi-kids; Private/ = alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(access) != 2 || !access["Kids"].Has("kodi-kids") || !access["Private"].Has("alice") {
+		t.Fatalf("got %v", access)
+	}
+	if _, err := parseAccess("Kids"); err == nil {
+		t.Error("parsed an entry with no users")
+	}
+
+	now := time.Now()
+	s := &server{
+		objNames:     set.New("Toy Story.mp4", "Top Hat.mp4"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"Toy Story": {Title: "Toy Story", subdir: "Kids/Animated"},
+			"Top Hat":   {Title: "Top Hat", subdir: "Musicals"},
+		},
+		infoMapTime: now,
+		dirTemplate: template.Must(template.New("").Parse(dirTemplate)),
+		subdirs:     true,
+		access:      access,
+	}
+
+	get := func(who, path string) (int, string) {
+		req := httptest.NewRequest("GET", path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), principalKey, who))
+		rec := httptest.NewRecorder()
+		mid.Err(s.handle).ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	cases := []struct {
+		who      string
+		wantKids bool
+	}{
+		{who: "alice", wantKids: true},
+		{who: "playlist:alice", wantKids: true},
+		{who: "bob"},
+		{who: ""},
+	}
+	for _, c := range cases {
+		t.Run(c.who, func(t *testing.T) {
+			code, body := get(c.who, "/")
+			if code != http.StatusOK {
+				t.Fatalf("got status %d for the root", code)
+			}
+			if !strings.Contains(body, "Musicals/") {
+				t.Error("root lacks Musicals/")
+			}
+			if got := strings.Contains(body, "Kids/Animated/"); got != c.wantKids {
+				t.Errorf("got %v for whether the root lists Kids/Animated/, want %v", got, c.wantKids)
+			}
+
+			if code, _ := get(c.who, "/Kids/Animated/"); (code == http.StatusForbidden) == c.wantKids {
+				t.Errorf("got status %d for Kids/Animated/", code)
+			}
+
+			nfo := "/Kids/Animated/" + url.PathEscape(rootNamePrefix("Toy Story")+"Toy Story.nfo")
+			if code, _ := get(c.who, nfo); (code == http.StatusForbidden) == c.wantKids {
+				t.Errorf("got status %d for the NFO", code)
+			}
+		})
+	}
+}
+
+func TestAccessListings(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		objNames:     set.New("Toy Story.mp4", "Toy Story.jpg", "Top Hat.mp4", "Top Hat.jpg", "Lost.mp4", "Late Show.mp4"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"Toy Story": {Title: "Toy Story", subdir: "Kids"},
+			"Top Hat":   {Title: "Top Hat"},
+			"Lost":      {Title: "Lost", hidden: true},
+			"Late Show": {Title: "Late Show", adult: true},
+		},
+		infoMapTime: now,
+		access:      map[string]set.Of[string]{"Kids": set.New("alice")},
+		pin:         "1234",
+	}
+
+	get := func(who, path string) (int, string) {
+		req := httptest.NewRequest("GET", path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), principalKey, who))
+		rec := httptest.NewRecorder()
+		mux := http.NewServeMux()
+		mux.Handle("/thumbs/", mid.Err(s.handleThumb))
+		mux.Handle("/export", mid.Err(s.handleExport))
+		mux.Handle("/", mid.Err(s.handle))
+		mux.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	for _, path := range []string{"/infomap", "/export", "/export?format=csv"} {
+		for _, who := range []string{"alice", "bob"} {
+			code, body := get(who, path)
+			if code != http.StatusOK {
+				t.Fatalf("%s for %s: got status %d", path, who, code)
+			}
+			if !strings.Contains(body, "Top Hat") {
+				t.Errorf("%s for %s lacks Top Hat", path, who)
+			}
+			if got, want := strings.Contains(body, "Toy Story"), who == "alice"; got != want {
+				t.Errorf("%s for %s: got %v for whether it has Toy Story, want %v", path, who, got, want)
+			}
+			if strings.Contains(body, "Lost") {
+				t.Errorf("%s for %s has the hidden title", path, who)
+			}
+			if strings.Contains(body, "Late Show") {
+				t.Errorf("%s for %s has the adult title without the PIN", path, who)
+			}
+		}
+	}
+
+	if code, _ := get("bob", "/thumbs/Toy%20Story.jpg"); code != http.StatusForbidden {
+		t.Errorf("got status %d for a restricted title's thumb", code)
+	}
+	if code, _ := get("bob", "/thumbs/Toy%20Story.mp4"); code != http.StatusForbidden {
+		t.Errorf("got status %d for a restricted title's media as a thumb", code)
+	}
+	if code, _ := get("bob", "/thumbs/Top%20Hat.mp4"); code != http.StatusNotFound {
+		t.Errorf("got status %d for a title's media as a thumb", code)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// With serve -acme-domain,
+// the server obtains and renews its own TLS certificate from Let's Encrypt
+// (instead of getting certificates from -certcmd).
+// The certificate authority verifies control of the domain
+// with a TLS-ALPN-01 challenge on the server's own listener,
+// which must then be reachable on port 443,
+// or, with -redirect,
+// with an HTTP-01 challenge on the plain-HTTP listener (see redirect.go),
+// which must then be reachable on port 80.
+// (DNS-01 challenges, which need the API of a DNS provider,
+// are left to -certcmd.)
+
+// The prefix of the bucket objects holding the ACME account key and certificates,
+// when they're not kept in a local directory.
+const acmeCachePrefix = "kodigcs/acme/"
+
+// newACMEManager returns an autocert.Manager for the comma-separated domains.
+// Its account key and certificates are kept in cacheDir,
+// or in the bucket if cacheDir is "".
+func newACMEManager(domains, email, cacheDir string, bucket *storage.BucketHandle) (*autocert.Manager, error) {
+	var hosts []string
+	for _, d := range strings.Split(domains, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			hosts = append(hosts, d)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no domains in -acme-domain")
+	}
+
+	var cache autocert.Cache = bucketCertCache{bucket: bucket}
+	if cacheDir != "" {
+		cache = autocert.DirCache(cacheDir)
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      cache,
+		Email:      email,
+	}, nil
+}
+
+// bucketCertCache is an autocert.Cache keeping its data in bucket objects under acmeCachePrefix.
+// Those objects include private keys.
+// The server must never serve objects under kodigcs/:
+// thumbSource refuses them,
+// and otherwise it serves only titles' media and artwork.
+type bucketCertCache struct {
+	bucket *storage.BucketHandle
+}
+
+func (c bucketCertCache) Get(ctx context.Context, name string) ([]byte, error) {
+	r, err := c.bucket.Object(acmeCachePrefix + name).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, autocert.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", name)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	return data, errors.Wrapf(err, "reading %s", name)
+}
+
+func (c bucketCertCache) Put(ctx context.Context, name string, data []byte) error {
+	w := c.bucket.Object(acmeCachePrefix + name).NewWriter(ctx)
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		w.Close()
+		return errors.Wrapf(err, "writing %s", name)
+	}
+	return errors.Wrapf(w.Close(), "writing %s", name)
+}
+
+func (c bucketCertCache) Delete(ctx context.Context, name string) error {
+	err := c.bucket.Object(acmeCachePrefix + name).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	return errors.Wrapf(err, "deleting %s", name)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestACMEManager(t *testing.T) {
+	if _, err := newACMEManager(" , ", "", "", nil); err == nil {
+		t.Error("made a manager with no domains")
+	}
+
+	m, err := newACMEManager("media.example.com, kodi.example.com", "me@example.com", t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Cache.(autocert.DirCache); !ok {
+		t.Errorf("got cache %T, want autocert.DirCache", m.Cache)
+	}
+
+	ctx := context.Background()
+	for _, host := range []string{"media.example.com", "kodi.example.com"} {
+		if err := m.HostPolicy(ctx, host); err != nil {
+			t.Errorf("host %s: %s", host, err)
+		}
+	}
+	if err := m.HostPolicy(ctx, "evil.example.com"); err == nil {
+		t.Error("allowed evil.example.com")
+	}
+
+	m, err = newACMEManager("media.example.com", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Cache.(bucketCertCache); !ok {
+		t.Errorf("got cache %T, want bucketCertCache", m.Cache)
+	}
+}
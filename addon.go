@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/subcmd/v2"
+)
+
+// The ID of the Kodi addon built by the addon build subcommand.
+const addonID = "plugin.video.kodigcs"
+
+//go:embed addon
+var addonFS embed.FS
+
+var addonTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"json": func(s string) (string, error) {
+		b, err := json.Marshal(s)
+		return string(b), err
+	},
+	"xml": func(s string) (string, error) {
+		buf := new(bytes.Buffer)
+		err := xml.EscapeText(buf, []byte(s))
+		return buf.String(), err
+	},
+}).ParseFS(addonFS, "addon/*"))
+
+type addoncmd struct {
+	maincmd
+}
+
+func (c maincmd) addon(ctx context.Context, args []string) error {
+	return subcmd.Run(ctx, addoncmd{c}, args)
+}
+
+func (c addoncmd) Subcmds() subcmd.Map {
+	return subcmd.Commands(
+		"build", c.build, "build a Kodi addon for browsing and playing titles from the server", subcmd.Params(
+			"-url", subcmd.String, "", "URL of the server, as reachable from Kodi",
+			"-name", subcmd.String, "kodi", "name of the device the addon is for, as shown at /admin/grants",
+			"-ttl", subcmd.Duration, time.Duration(0), "lifetime of the addon's token (0 means no expiry)",
+			"-o", subcmd.String, addonID+".zip", "output file",
+		),
+	)
+}
+
+// build issues a token for a Kodi device
+// and writes a Kodi addon zip file that uses it to reach the server.
+func (c addoncmd) build(ctx context.Context, serverURL, name string, ttl time.Duration, outfile string, _ []string) error {
+	if serverURL == "" {
+		return fmt.Errorf("must specify -url")
+	}
+	if !strings.HasSuffix(serverURL, "/") {
+		serverURL += "/"
+	}
+
+	grants := newGrantStore(c.bucket)
+	if err := grants.sync(ctx); err != nil {
+		return errors.Wrap(err, "loading grants")
+	}
+	token, g, err := grants.issue(grantToken, "addon:"+name, "Kodi addon", ttl)
+	if err != nil {
+		return errors.Wrap(err, "issuing token")
+	}
+	if err := grants.sync(ctx); err != nil {
+		return errors.Wrap(err, "saving grants")
+	}
+
+	data := struct {
+		Server, Token, Version string
+	}{
+		Server:  serverURL,
+		Token:   token,
+		Version: "1.0." + time.Now().Format("20060102150405"),
+	}
+
+	f, err := os.Create(outfile)
+	if err != nil {
+		return errors.Wrapf(err, "creating %s", outfile)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, tmpl := range []string{"addon.xml", "client.py", "main.py", "service.py"} {
+		w, err := zw.Create(addonID + "/" + tmpl)
+		if err != nil {
+			return errors.Wrapf(err, "adding %s to zip file", tmpl)
+		}
+		if err := addonTemplates.ExecuteTemplate(w, tmpl, data); err != nil {
+			return errors.Wrapf(err, "writing %s", tmpl)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return errors.Wrap(err, "finishing zip file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "closing %s", outfile)
+	}
+
+	log.Printf("Wrote %s with token %s (revoke it at /admin/grants)", outfile, g.ID)
+	return nil
+}
@@ -0,0 +1,52 @@
+# Generated by "kodigcs addon build".
+# Requests to the server, shared by the plugin (main.py) and the player monitor (service.py).
+
+import json
+import urllib.parse
+import urllib.request
+
+SERVER = {{ json .Server }}
+TOKEN = {{ json .Token }}
+
+# The home window property in which the plugin leaves a JSON object
+# mapping the media URL of each title it lists (see media_key) to the title's root name,
+# so that the player monitor can tell which title is playing.
+TITLES_PROPERTY = "kodigcs.titles"
+
+
+def server_url(path):
+    return urllib.parse.urljoin(SERVER, path)
+
+
+def request(method, path, body=None):
+    headers = {"Authorization": "Bearer " + TOKEN}
+    data = None
+    if body is not None:
+        headers["Content-Type"] = "application/json"
+        data = json.dumps(body).encode("utf-8")
+    req = urllib.request.Request(server_url(path), data=data, headers=headers, method=method)
+    with urllib.request.urlopen(req) as resp:
+        return json.load(resp)
+
+
+def fetch(path):
+    return request("GET", path)
+
+
+def put(path, body):
+    return request("PUT", path, body)
+
+
+def media_url(path):
+    # Kodi sends headers given after "|" with its requests for the media.
+    return server_url(path) + "|Authorization=" + urllib.parse.quote("Bearer " + TOKEN)
+
+
+def media_key(url):
+    # A media URL without the headers, and unescaped,
+    # to compare the URL of the playing file with those the plugin listed.
+    return urllib.parse.unquote(url.split("|", 1)[0])
+
+
+def watched_path(root):
+    return "api/watched/" + urllib.parse.quote(root)
@@ -0,0 +1,69 @@
+# Generated by "kodigcs addon build".
+
+import json
+import sys
+
+import xbmc
+import xbmcgui
+import xbmcplugin
+
+from client import TITLES_PROPERTY, fetch, media_key, media_url
+
+HANDLE = int(sys.argv[1])
+
+
+def list_titles():
+    xbmcplugin.setContent(HANDLE, "movies")
+
+    # The user's playcounts and resume points, keyed by root name,
+    # kept up to date by the player monitor in service.py.
+    watched = fetch("api/watched")
+    roots = {}
+
+    for t in fetch("api/titles"):
+        li = xbmcgui.ListItem(label=t["title"])
+        li.setProperty("IsPlayable", "true")
+
+        tag = li.getVideoInfoTag()
+        tag.setMediaType("movie")
+        tag.setTitle(t["title"])
+        tag.setOriginalTitle(t.get("original_title", ""))
+        tag.setSortTitle(t.get("sort_title", ""))
+        tag.setYear(t.get("year", 0))
+        tag.setPremiered(t.get("premiered", ""))
+        tag.setDuration(60 * t.get("runtime", 0))
+        tag.setMpaa(t.get("mpaa", ""))
+        tag.setTagLine(t.get("tagline", ""))
+        tag.setPlotOutline(t.get("outline", ""))
+        tag.setPlot(t.get("plot", ""))
+        tag.setDirectors(t.get("directors", []))
+        tag.setSet(t.get("set", ""))
+        tag.setTrailer(t.get("trailer", ""))
+        if t.get("genre"):
+            tag.setGenres([t["genre"]])
+        tag.setCast([xbmc.Actor(name=name, order=i) for i, name in enumerate(t.get("actors", []))])
+
+        st = watched.get(t["root"], {})
+        tag.setPlaycount(st.get("playcount", 0))
+        if st.get("resume"):
+            tag.setResumePoint(st["resume"], st.get("total", 0))
+
+        poster = t.get("poster")
+        if poster:
+            if "://" not in poster:
+                poster = media_url(poster)
+            li.setArt({"poster": poster, "thumb": poster})
+
+        url = media_url(t["path"])
+        roots[media_key(url)] = t["root"]
+        xbmcplugin.addDirectoryItem(HANDLE, url, li, isFolder=False)
+
+    xbmcgui.Window(10000).setProperty(TITLES_PROPERTY, json.dumps(roots))
+
+    xbmcplugin.addSortMethod(HANDLE, xbmcplugin.SORT_METHOD_VIDEO_SORT_TITLE)
+    xbmcplugin.addSortMethod(HANDLE, xbmcplugin.SORT_METHOD_VIDEO_YEAR)
+    xbmcplugin.addSortMethod(HANDLE, xbmcplugin.SORT_METHOD_DATEADDED)
+    xbmcplugin.endOfDirectory(HANDLE)
+
+
+list_titles()
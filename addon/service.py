@@ -0,0 +1,77 @@
+# Generated by "kodigcs addon build".
+# The player monitor.
+# When a title listed by the plugin stops,
+# it sends the server the point at which to resume it;
+# when it ends (or stops near the end),
+# it counts a play instead.
+# So resume points and playcounts are shared by the devices using the same token name.
+
+import json
+
+import xbmc
+import xbmcgui
+
+from client import TITLES_PROPERTY, fetch, media_key, put, watched_path
+
+# The fraction of a title after which stopping counts as watching it, as in Kodi.
+WATCHED_FRACTION = 0.9
+
+
+class Player(xbmc.Player):
+    def __init__(self):
+        super().__init__()
+        self.root = None
+        self.position = 0
+        self.total = 0
+
+    def onAVStarted(self):
+        self.root, self.position, self.total = None, 0, 0
+        if not self.isPlayingVideo():
+            return
+        titles = json.loads(xbmcgui.Window(10000).getProperty(TITLES_PROPERTY) or "{}")
+        self.root = titles.get(media_key(self.getPlayingFile()))
+        self.update()
+
+    def update(self):
+        # The position is unavailable once playback stops,
+        # so it is recorded as playback goes.
+        if self.root is None or not self.isPlayingVideo():
+            return
+        try:
+            self.position, self.total = self.getTime(), self.getTotalTime()
+        except RuntimeError:
+            pass
+
+    def onPlayBackStopped(self):
+        self.finish(False)
+
+    def onPlayBackEnded(self):
+        self.finish(True)
+
+    def finish(self, ended):
+        root, self.root = self.root, None
+        if root is None:
+            return
+        path = watched_path(root)
+        try:
+            st = fetch(path)
+            playcount = st.get("playcount", 0)
+            if ended or (self.total > 0 and self.position >= WATCHED_FRACTION * self.total):
+                # A zero lastplayed is set by the server to the current time.
+                st = {"playcount": playcount + 1}
+            else:
+                st = {
+                    "playcount": playcount,
+                    "lastplayed": st.get("lastplayed"),
+                    "resume": self.position,
+                    "total": self.total,
+                }
+            put(path, st)
+        except Exception as e:
+            xbmc.log("kodigcs: updating the watched state of %s: %s" % (root, e), xbmc.LOGWARNING)
+
+
+monitor = xbmc.Monitor()
+player = Player()
+while not monitor.waitForAbort(1):
+    player.update()
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAddonBuild(t *testing.T) {
+	ctx := context.Background()
+	_, bucket := newFakeGCS(t)
+
+	// The ampersand must be escaped in addon.xml but not in client.py.
+	const serverURL = "https://media.example.com:1549/kodi&den"
+
+	outfile := filepath.Join(t.TempDir(), addonID+".zip")
+	c := addoncmd{maincmd{bucket: bucket}}
+	if err := c.build(ctx, serverURL, "den", 0, outfile, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(b)
+	}
+	for _, name := range []string{"addon.xml", "client.py", "main.py", "service.py"} {
+		if _, ok := files[addonID+"/"+name]; !ok {
+			t.Errorf("zip file lacks %s", name)
+		}
+	}
+
+	var manifest struct {
+		ID      string `xml:"id,attr"`
+		Version string `xml:"version,attr"`
+		Summary string `xml:"extension>summary"`
+	}
+	if err := xml.Unmarshal([]byte(files[addonID+"/addon.xml"]), &manifest); err != nil {
+		t.Fatalf("parsing addon.xml: %s", err)
+	}
+	if manifest.ID != addonID {
+		t.Errorf("got addon id %q, want %q", manifest.ID, addonID)
+	}
+	if !regexp.MustCompile(`^1\.0\.\d{14}$`).MatchString(manifest.Version) {
+		t.Errorf("got addon version %q", manifest.Version)
+	}
+	if want := "Titles served by kodigcs at " + serverURL + "/"; manifest.Summary != want {
+		t.Errorf("got summary %q, want %q", manifest.Summary, want)
+	}
+
+	client := files[addonID+"/client.py"]
+	// A JSON string is also a Python string literal.
+	quoted, err := json.Marshal(serverURL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SERVER = " + string(quoted); !strings.Contains(client, want) {
+		t.Errorf("client.py lacks %s", want)
+	}
+	m := regexp.MustCompile(`(?m)^TOKEN = "([^"]+)"$`).FindStringSubmatch(client)
+	if m == nil {
+		t.Fatal("client.py lacks a token")
+	}
+
+	// The token is saved in the bucket, where the server will find it.
+	grants := newGrantStore(bucket)
+	if err := grants.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	g, ok := grants.lookup(grantToken, m[1])
+	if !ok {
+		t.Fatal("addon token not found in the bucket's grants")
+	}
+	if g.Name != "addon:den" {
+		t.Errorf("got grant name %q, want %q", g.Name, "addon:den")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+// Some requests are for admins only:
+// the /admin/grants page,
+// and changing titles' metadata with PATCH /api/titles/ROOTNAME.
+// The admins are the users named in serve -admins,
+// by default just the -username user,
+// when signed in with their own credentials
+// (a password, a login session, single sign-on, or a client certificate),
+// not with a token or link that the server issued.
+// Without authentication there are no admins.
+
+// parseAdmins parses the value of serve -admins,
+// a comma-separated list of users,
+// defaulting to username.
+func parseAdmins(admins, username string) set.Of[string] {
+	result := set.New[string]()
+	for _, a := range strings.Split(admins, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			result.Add(a)
+		}
+	}
+	if result.Len() == 0 && username != "" {
+		result.Add(username)
+	}
+	return result
+}
+
+type viaGrantKeyType struct{}
+
+var viaGrantKey viaGrantKeyType
+
+// viaGrant tells whether the requester authenticated with a grant issued by the server
+// (a token, share link, or playlist link)
+// rather than with their own credentials.
+func viaGrant(ctx context.Context) bool {
+	v, _ := ctx.Value(viaGrantKey).(bool)
+	return v
+}
+
+// isGrantAuth tells whether an authenticator accepts grants issued by the server
+// in place of a user's own credentials.
+func isGrantAuth(a authenticator) bool {
+	switch a.(type) {
+	case tokenAuth, shareAuth, playlistAuth:
+		return true
+	}
+	return false
+}
+
+// isAdmin tells whether the requester is an admin.
+func (s *server) isAdmin(ctx context.Context) bool {
+	who := principal(ctx)
+	return who != "" && !viaGrant(ctx) && s.admins.Has(who)
+}
+
+// checkAdmin returns a 403 error if the requester is not an admin.
+func (s *server) checkAdmin(ctx context.Context) error {
+	if s.isAdmin(ctx) {
+		return nil
+	}
+	return mid.CodeErr{
+		C:   http.StatusForbidden,
+		Err: fmt.Errorf("%q is not an admin", principal(ctx)),
+	}
+}
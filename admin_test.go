@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestAdmin(t *testing.T) {
+	if got := parseAdmins("", "nora"); !got.Equal(set.New("nora")) {
+		t.Errorf("got admins %v by default", got.Slice())
+	}
+	if got := parseAdmins(" alice, bob ", "nora"); !got.Equal(set.New("alice", "bob")) {
+		t.Errorf("got admins %v", got.Slice())
+	}
+
+	grants := &grantStore{
+		grants:  make(map[string]*grant),
+		known:   set.New[string](),
+		deleted: set.New[string](),
+	}
+	token, _, err := grants.issue(grantToken, "nora", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{
+		auth:   anyAuth{basicAuth{username: "nora", password: "secret"}, tokenAuth{grants: grants}},
+		admins: parseAdmins("", "nora"),
+	}
+
+	try := func(req *http.Request) int {
+		var admin bool
+		h := s.authed(func(w http.ResponseWriter, req *http.Request) error {
+			admin = s.isAdmin(req.Context())
+			return s.checkAdmin(req.Context())
+		})
+		if err := h(httptest.NewRecorder(), req); err != nil {
+			return errorCode(err)
+		}
+		if !admin {
+			t.Error("checkAdmin passed a non-admin")
+		}
+		return http.StatusOK
+	}
+
+	req := httptest.NewRequest("GET", "/admin/grants", nil)
+	req.SetBasicAuth("nora", "secret")
+	if code := try(req); code != http.StatusOK {
+		t.Errorf("got status %d for the admin's password", code)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/grants", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if code := try(req); code != http.StatusForbidden {
+		t.Errorf("got status %d for a token named for the admin", code)
+	}
+
+	s.auth = noAuth{}
+	if code := try(httptest.NewRequest("GET", "/admin/grants", nil)); code != http.StatusForbidden {
+		t.Errorf("got status %d without authentication", code)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bobg/errors"
+	"golang.org/x/time/rate"
+)
+
+const airtableAPI = "https://api.airtable.com/v0"
+
+// airtableSource is a metadataSource for a table in Airtable,
+// given to -sheet as airtable://BASEID/TABLE,
+// where TABLE is the table's name or ID.
+// The API key (a personal access token) comes from $AIRTABLE_API_KEY.
+// Each record of the table is a title,
+// with fields named like the columns of the metadata spreadsheet.
+type airtableSource struct {
+	apiURL string
+	key    string
+	base   string
+	table  string
+	cl     *http.Client
+
+	mu      sync.Mutex
+	records map[string]string // filename -> record ID, as of the last call to rows
+	fields  map[string]string // lowercase heading -> field name, likewise
+}
+
+func newAirtableSource(spec string) (*airtableSource, error) {
+	rest := strings.TrimPrefix(spec, "airtable://")
+	base, table, ok := strings.Cut(rest, "/")
+	if !ok || base == "" || table == "" {
+		return nil, fmt.Errorf("malformed Airtable source %s, want airtable://BASEID/TABLE", spec)
+	}
+	if t, err := url.PathUnescape(table); err == nil {
+		table = t
+	}
+
+	key := os.Getenv("AIRTABLE_API_KEY")
+	if key == "" {
+		return nil, fmt.Errorf("must set AIRTABLE_API_KEY for %s", spec)
+	}
+	logRedactor.addSecret(key)
+
+	return &airtableSource{
+		apiURL: airtableAPI,
+		key:    key,
+		base:   base,
+		table:  table,
+		cl: &http.Client{
+			// Airtable allows 5 requests per second per base.
+			Transport: &limitedTransport{
+				limiter:   rate.NewLimiter(rate.Every(time.Second/5), 1),
+				transport: http.DefaultTransport,
+			},
+		},
+	}, nil
+}
+
+type airtableRecord struct {
+	ID     string         `json:"id,omitempty"`
+	Fields map[string]any `json:"fields"`
+}
+
+// do sends a request to the Airtable API and decodes the JSON response into result.
+// The path is relative to the table's URL.
+func (s *airtableSource) do(ctx context.Context, method, path string, body, result any) error {
+	u := s.apiURL + "/" + url.PathEscape(s.base) + "/" + url.PathEscape(s.table) + path
+
+	var r io.Reader
+	if body != nil {
+		j, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "encoding request")
+		}
+		r = bytes.NewReader(j)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return errors.Wrapf(err, "building request to %s %s", method, u)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.key)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.cl.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s", method, u)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var e struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("status %d (%s) from %s %s: %s %s", resp.StatusCode, http.StatusText(resp.StatusCode), method, u, e.Error.Type, e.Error.Message)
+	}
+	return errors.Wrapf(json.NewDecoder(resp.Body).Decode(result), "decoding response to %s %s", method, u)
+}
+
+func (s *airtableSource) rows(ctx context.Context) ([][]interface{}, error) {
+	var (
+		titles  []map[string]any
+		records = make(map[string]string)
+		fields  = make(map[string]string)
+		offset  string
+	)
+	for {
+		path := "?pageSize=100"
+		if offset != "" {
+			path += "&offset=" + url.QueryEscape(offset)
+		}
+		var page struct {
+			Records []airtableRecord `json:"records"`
+			Offset  string           `json:"offset"`
+		}
+		if err := s.do(ctx, "GET", path, nil, &page); err != nil {
+			return nil, errors.Wrap(err, "listing Airtable records")
+		}
+
+		for _, rec := range page.Records {
+			t := make(map[string]any)
+			for name, v := range rec.Fields {
+				heading := headingAliases().canonical(name)
+				fields[heading] = name
+				t[heading] = airtableValue(v)
+			}
+			filename, _ := t["filename"].(string)
+			if filename == "" {
+				continue
+			}
+			records[filename] = rec.ID
+			titles = append(titles, t)
+		}
+
+		if page.Offset == "" {
+			break
+		}
+		offset = page.Offset
+	}
+
+	s.mu.Lock()
+	s.records, s.fields = records, fields
+	s.mu.Unlock()
+
+	return manifestRows(titles), nil
+}
+
+// airtableValue renders the value of an Airtable field as a metadata spreadsheet cell.
+// Attachments (such as a poster image) become their URLs.
+func airtableValue(v any) string {
+	switch v := v.(type) {
+	case []any:
+		var items []string
+		for _, item := range v {
+			items = append(items, airtableValue(item))
+		}
+		return strings.Join(items, "; ")
+	case map[string]any:
+		if u, ok := v["url"].(string); ok {
+			return u
+		}
+	}
+	return manifestValue(v)
+}
+
+func (s *airtableSource) getCells(ctx context.Context, filename string) (map[string]string, error) {
+	s.mu.Lock()
+	id := s.records[filename]
+	s.mu.Unlock()
+
+	if id == "" {
+		// Perhaps the record is new since the last call to rows.
+		if _, err := s.rows(ctx); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		id = s.records[filename]
+		s.mu.Unlock()
+		if id == "" {
+			return nil, nil
+		}
+	}
+
+	var rec airtableRecord
+	if err := s.do(ctx, "GET", "/"+url.PathEscape(id), nil, &rec); err != nil {
+		return nil, errors.Wrapf(err, "getting Airtable record for %s", filename)
+	}
+	cells := make(map[string]string)
+	for name, v := range rec.Fields {
+		if val := airtableValue(v); val != "" {
+			cells[headingAliases().canonical(name)] = val
+		}
+	}
+	return cells, nil
+}
+
+func (s *airtableSource) setCells(ctx context.Context, filename string, cells map[string]string) error {
+	s.mu.Lock()
+	loaded := s.records != nil
+	s.mu.Unlock()
+	if !loaded {
+		if _, err := s.rows(ctx); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	id := s.records[filename]
+	rec := airtableRecord{Fields: make(map[string]any)}
+	for heading, val := range cells {
+		name := s.fields[heading]
+		if name == "" {
+			name = displayHeading(heading)
+		}
+		if val == "" {
+			rec.Fields[name] = nil
+		} else {
+			rec.Fields[name] = val
+		}
+	}
+	if id == "" {
+		name := s.fields["filename"]
+		if name == "" {
+			name = displayHeading("filename")
+		}
+		rec.Fields[name] = filename
+	}
+	s.mu.Unlock()
+
+	// Typecasting lets Airtable convert strings to numbers, select options, and so on.
+	body := map[string]any{"fields": rec.Fields, "typecast": true}
+
+	if id != "" {
+		var result airtableRecord
+		return errors.Wrapf(s.do(ctx, "PATCH", "/"+url.PathEscape(id), body, &result), "updating Airtable record for %s", filename)
+	}
+
+	var result airtableRecord
+	if err := s.do(ctx, "POST", "", body, &result); err != nil {
+		return errors.Wrapf(err, "creating Airtable record for %s", filename)
+	}
+	s.mu.Lock()
+	s.records[filename] = result.ID
+	s.mu.Unlock()
+	return nil
+}
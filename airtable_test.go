@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAirtableSource(t *testing.T) {
+	var (
+		pages = []string{
+			`{"records": [{"id": "rec1", "fields": {"Filename": "The Thin Man.iso", "Year": 1934, "Poster": [{"url": "https://example.com/thinman.jpg", "filename": "thinman.jpg"}]}}], "offset": "next"}`,
+			`{"records": [{"id": "rec2", "fields": {"Filename": "After the Thin Man.iso", "Genre": ["Comedy", "Mystery"]}}]}`,
+		}
+		gotRequests []string
+		gotBodies   []map[string]any
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		gotRequests = append(gotRequests, req.Method+" "+req.URL.Path)
+
+		switch req.Method {
+		case "GET":
+			if req.URL.Query().Get("offset") == "next" {
+				w.Write([]byte(pages[1]))
+			} else {
+				w.Write([]byte(pages[0]))
+			}
+
+		case "PATCH", "POST":
+			var body map[string]any
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			gotBodies = append(gotBodies, body)
+			w.Write([]byte(`{"id": "rec3", "fields": {}}`))
+		}
+	}))
+	defer srv.Close()
+
+	src := &airtableSource{apiURL: srv.URL, key: "key", base: "app1", table: "Movies", cl: srv.Client()}
+	ctx := context.Background()
+
+	rows, err := src.rows(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRows := [][]interface{}{
+		{"filename", "poster", "year", "genre"},
+		{"The Thin Man.iso", "https://example.com/thinman.jpg", "1934", ""},
+		{"After the Thin Man.iso", "", "", "Comedy; Mystery"},
+	}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("got rows %v, want %v", rows, wantRows)
+	}
+
+	if err := src.setCells(ctx, "The Thin Man.iso", map[string]string{"year": "1935", "genre": ""}); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.setCells(ctx, "Song of the Thin Man.iso", map[string]string{"title": "Song of the Thin Man"}); err != nil {
+		t.Fatal(err)
+	}
+
+	wantRequests := []string{
+		"GET /app1/Movies",
+		"GET /app1/Movies",
+		"PATCH /app1/Movies/rec1",
+		"POST /app1/Movies",
+	}
+	if !reflect.DeepEqual(gotRequests, wantRequests) {
+		t.Errorf("got requests %v, want %v", gotRequests, wantRequests)
+	}
+
+	wantBodies := []map[string]any{
+		{"fields": map[string]any{"Year": "1935", "Genre": nil}, "typecast": true},
+		{"fields": map[string]any{"Title": "Song of the Thin Man", "Filename": "Song of the Thin Man.iso"}, "typecast": true},
+	}
+	if !reflect.DeepEqual(gotBodies, wantBodies) {
+		t.Errorf("got bodies %v, want %v", gotBodies, wantBodies)
+	}
+}
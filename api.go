@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+// apiTitle describes a title for JSON clients of the server,
+// such as the Kodi addon built by the addon subcommand.
+// Paths are relative to the server's root.
+type apiTitle struct {
+	Root      string   `json:"root"`
+	Path      string   `json:"path"` // the media object, the playlist of a multi-part title, or the folder of a disc
+	NFO       string   `json:"nfo"`
+	Title     string   `json:"title"`
+	OrigTitle string   `json:"original_title,omitempty"`
+	SortTitle string   `json:"sort_title,omitempty"`
+	Year      int      `json:"year,omitempty"`
+	Premiered string   `json:"premiered,omitempty"`
+	Runtime   int      `json:"runtime,omitempty"`
+	Genre     string   `json:"genre,omitempty"`
+	MPAA      string   `json:"mpaa,omitempty"`
+	Tagline   string   `json:"tagline,omitempty"`
+	Outline   string   `json:"outline,omitempty"`
+	Plot      string   `json:"plot,omitempty"`
+	Directors []string `json:"directors,omitempty"`
+	Actors    []string `json:"actors,omitempty"`
+	Set       string   `json:"set,omitempty"`
+	Subdir    string   `json:"subdir,omitempty"`
+	Poster    string   `json:"poster,omitempty"` // a path, or an absolute URL for a poster not in the bucket
+	Trailer   string   `json:"trailer,omitempty"`
+	Top250    int      `json:"top250,omitempty"`
+	Awards    string   `json:"awards,omitempty"`
+}
+
+// handleAPITitles serves the list of all titles as JSON.
+func (s *server) handleAPITitles(w http.ResponseWriter, req *http.Request) error {
+	ctx := req.Context()
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	parts := s.indexParts()
+	grouped := parts.grouped()
+
+	var titles []apiTitle
+
+	add := func(rootName, name string) {
+		info, ok := s.infoMap[rootName]
+		if !ok {
+			info = movieInfo{Title: rootName}
+		}
+		if info.hidden || !s.mayView(ctx, info) {
+			return
+		}
+		prefix := rootNamePrefix(rootName)
+		t := apiTitle{
+			Root:      rootName,
+			Path:      escapeItem(prefix + name),
+			NFO:       url.PathEscape(prefix + rootName + ".nfo"),
+			Title:     info.Title,
+			OrigTitle: info.OrigTitle,
+			SortTitle: info.SortTitle,
+			Year:      info.Year,
+			Premiered: info.Premiered,
+			Runtime:   info.Runtime,
+			Genre:     info.Genre,
+			MPAA:      info.MPAA,
+			Tagline:   info.Tagline,
+			Outline:   info.Outline,
+			Plot:      info.Plot,
+			Directors: info.Directors,
+			Subdir:    info.subdir,
+			Trailer:   info.Trailer,
+			Top250:    info.Top250,
+			Awards:    info.awards,
+		}
+		for _, a := range info.Actors {
+			t.Actors = append(t.Actors, a.Name)
+		}
+		if info.Set != nil {
+			t.Set = info.Set.Name
+		}
+		if len(info.Thumbs) > 0 {
+			orig := info.Thumbs[0].origVal
+			if thumbName := rootName + filepath.Ext(orig); s.objNames.Has(thumbName) {
+				t.Poster = "thumbs/" + url.PathEscape(thumbName)
+			} else {
+				t.Poster = orig
+			}
+		}
+		titles = append(titles, t)
+	}
+
+	s.objNames.Each(func(objName string) {
+		if grouped.Has(objName) || s.isEpisode(objName) || s.isExtra(objName) || s.isDiscObj(objName) {
+			return
+		}
+		ext := filepath.Ext(objName)
+		if !isMediaExt(ext) {
+			return
+		}
+		add(strings.TrimSuffix(objName, ext), objName)
+	})
+	for rootName := range parts {
+		add(rootName, rootName+".m3u")
+	}
+	for _, rootName := range s.discTitles() {
+		add(rootName, rootName+"/")
+	}
+
+	sort.Slice(titles, func(i, j int) bool {
+		ki, kj := titles[i].SortTitle, titles[j].SortTitle
+		if ki == "" {
+			ki = strings.ToLower(titles[i].Title)
+		}
+		if kj == "" {
+			kj = strings.ToLower(titles[j].Title)
+		}
+		return ki < kj
+	})
+
+	return mid.RespondJSON(w, titles)
+}
+
+// apiDir describes a directory of the server's virtual tree for JSON clients.
+type apiDir struct {
+	Path    string        `json:"path"`
+	Entries []apiDirEntry `json:"entries"`
+	Page    int           `json:"page,omitempty"`  // with ?page=N
+	Pages   int           `json:"pages,omitempty"` // likewise
+}
+
+// apiDirEntry is an item in an apiDir.
+// Its Path is relative to the server's root;
+// Root, Title, and Year are for the media object or playlist of a title.
+type apiDirEntry struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Dir   bool   `json:"dir,omitempty"`
+	Root  string `json:"root,omitempty"`
+	Title string `json:"title,omitempty"`
+	Year  int    `json:"year,omitempty"`
+}
+
+type apiDirKeyType struct{}
+
+var apiDirKey apiDirKeyType
+
+// isAPIDir tells whether a request for a directory came through /api/dir/,
+// and so wants JSON instead of HTML.
+func isAPIDir(req *http.Request) bool {
+	ok, _ := req.Context().Value(apiDirKey).(bool)
+	return ok
+}
+
+// handleAPIDir serves the directory at the rest of the path
+// (as in /api/dir/sets/The%20Thin%20Man%20Collection/)
+// as JSON.
+func (s *server) handleAPIDir(w http.ResponseWriter, req *http.Request) error {
+	p := strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/dir"), "/")
+
+	req = req.WithContext(context.WithValue(req.Context(), apiDirKey, true))
+	req.URL = &url.URL{Path: "/" + p, RawQuery: req.URL.RawQuery}
+
+	if s.tv && (p == tvDir || strings.HasPrefix(p, tvDir+"/")) {
+		return s.handleTV(w, req)
+	}
+	if rootName, rest, ok := parseDiscPath(p); ok {
+		return s.handleDisc(req.Context(), w, req, rootName, rest)
+	}
+	subdir, objName := parsePath(p)
+	if objName != "" {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("%s is not a directory", p),
+		}
+	}
+	return s.handleDir(w, req, subdir)
+}
+
+// respondDirJSON serves a directory listing
+// (or one page of it, if page is not nil)
+// to a client of /api/dir/.
+// The objName function maps a file item to the name of the object it stands for,
+// as in propfindDir.
+// The caller must hold s.mu.
+func (s *server) respondDirJSON(w http.ResponseWriter, req *http.Request, items []template.URL, page *dirPage, objName func(string) string) error {
+	dir := strings.Trim(req.URL.Path, "/")
+	if dir != "" {
+		dir += "/"
+	}
+	result := apiDir{Path: dir, Entries: []apiDirEntry{}}
+	if page != nil {
+		result.Page, result.Pages = page.Page, page.Pages
+	}
+	for _, item := range items {
+		name := string(item)
+		if d, ok := strings.CutSuffix(name, "/"); ok {
+			// Some directory items are escaped and some are not.
+			if u, err := url.PathUnescape(d); err == nil {
+				d = u
+			}
+			result.Entries = append(result.Entries, apiDirEntry{
+				Name: d,
+				Path: dir + url.PathEscape(d) + "/",
+				Dir:  true,
+			})
+			continue
+		}
+		entry := apiDirEntry{Name: name, Path: dir + url.PathEscape(name)}
+		if obj := objName(name); filepath.Ext(obj) == ".m3u" || isMediaExt(filepath.Ext(obj)) {
+			rootName := strings.TrimSuffix(obj, filepath.Ext(obj))
+			info := s.infoMap[rootName]
+			entry.Root = rootName
+			entry.Title = displayTitle(rootName, info)
+			entry.Year = info.Year
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	return mid.RespondJSON(w, result)
+}
+
+// accessHeadings are the metadata headings that control who may see a title,
+// which PATCH /api/titles/ROOTNAME may not change.
+var accessHeadings = set.New("subdir", "hidden", "enabled", "adult", "pin")
+
+// handleAPITitlePatch updates fields of the metadata of the title with the given root name,
+// writing them through to the metadata source.
+// The request body is a JSON object mapping metadata spreadsheet headings to values,
+// as in {"title": "The Thin Man", "year": 1934}.
+// An empty value clears a field.
+// The response is the title's updated metadata, in the same form.
+// Only admins may do this (see admin.go),
+// and not for the fields that control who may see a title.
+func (s *server) handleAPITitlePatch(w http.ResponseWriter, req *http.Request) error {
+	ctx := req.Context()
+	rootName := req.PathValue("rootname")
+
+	if err := s.checkAdmin(ctx); err != nil {
+		return err
+	}
+
+	src, ok := s.meta.(writableSource)
+	if !ok || s.sheetID == "" {
+		return mid.CodeErr{
+			C:   http.StatusMethodNotAllowed,
+			Err: fmt.Errorf("metadata source is read-only"),
+		}
+	}
+
+	var fields map[string]any
+	if err := json.NewDecoder(req.Body).Decode(&fields); err != nil {
+		return mid.CodeErr{
+			C:   http.StatusBadRequest,
+			Err: errors.Wrap(err, "decoding request body"),
+		}
+	}
+	cells := make(map[string]string)
+	for k, v := range fields {
+		heading := strings.ToLower(k)
+		if !knownHeadings.Has(heading) {
+			return mid.CodeErr{
+				C:   http.StatusBadRequest,
+				Err: fmt.Errorf("unknown field %s", k),
+			}
+		}
+		if accessHeadings.Has(heading) {
+			return mid.CodeErr{
+				C:   http.StatusForbidden,
+				Err: fmt.Errorf("field %s may be changed only in the metadata source", k),
+			}
+		}
+		cells[heading] = manifestValue(v)
+	}
+	if len(cells) == 0 {
+		return mid.CodeErr{
+			C:   http.StatusBadRequest,
+			Err: fmt.Errorf("no fields to update"),
+		}
+	}
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	// The title's row is named by its filename,
+	// which comes from its existing row
+	// or else from its object in the bucket.
+	s.mu.RLock()
+	filename := s.infoMap[rootName].filename
+	if filename == "" {
+		for objName := range s.objNames {
+			if ext := filepath.Ext(objName); isMediaExt(ext) && strings.TrimSuffix(objName, ext) == rootName {
+				filename = objName
+				break
+			}
+		}
+	}
+	s.mu.RUnlock()
+	if filename == "" {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no title %s", rootName),
+		}
+	}
+	if err := s.checkAccess(ctx, filename); err != nil {
+		return err
+	}
+
+	log.Printf("Updating %v for %s", slices.Sorted(maps.Keys(cells)), filename)
+
+	if err := src.setCells(ctx, filename, cells); err != nil {
+		return errors.Wrapf(err, "updating %s", filename)
+	}
+
+	// Reload, so that the change takes effect now.
+	s.mu.Lock()
+	s.infoMapTime = time.Time{}
+	s.mu.Unlock()
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "reloading info map")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return mid.RespondJSON(w, s.infoMap[rootName].cells())
+}
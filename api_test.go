@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+func TestHandleAPIDir(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		dirTemplate:  template.Must(template.New("").Parse(dirTemplate)),
+		objNames:     set.New("The Thin Man.iso", "Top Hat.mp4"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"The Thin Man": {Title: "The Thin Man", SortTitle: "thin man", Year: 1934},
+			"Top Hat":      {Title: "Top Hat", SortTitle: "top hat", subdir: "Musicals"},
+		},
+		infoMapTime: now,
+		subdirs:     true,
+	}
+
+	rec := httptest.NewRecorder()
+	if err := s.handleAPIDir(rec, httptest.NewRequest("GET", "/api/dir/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	var got apiDir
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := rootNamePrefix("The Thin Man")
+	want := []apiDirEntry{
+		{Name: prefix + "The Thin Man.iso", Path: prefix + "The%20Thin%20Man.iso", Root: "The Thin Man", Title: "The Thin Man", Year: 1934},
+		{Name: prefix + "The Thin Man.nfo", Path: prefix + "The%20Thin%20Man.nfo"},
+		{Name: "Musicals", Path: "Musicals/", Dir: true},
+	}
+	if len(got.Entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(got.Entries), len(want), got.Entries)
+	}
+	for i, w := range want {
+		if got.Entries[i] != w {
+			t.Errorf("entry %d: got %+v, want %+v", i, got.Entries[i], w)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	err := s.handleAPIDir(rec, httptest.NewRequest("GET", "/api/dir/"+prefix+"The%20Thin%20Man.iso", nil))
+	if err == nil {
+		t.Error("got no error for a file")
+	}
+}
+
+// memSource is a writableSource held in memory.
+type memSource struct {
+	headings []string
+	titles   map[string]map[string]string // filename -> heading -> value
+}
+
+func (m *memSource) rows(context.Context) ([][]interface{}, error) {
+	result := [][]interface{}{make([]interface{}, len(m.headings))}
+	for i, h := range m.headings {
+		result[0][i] = h
+	}
+	for _, filename := range slices.Sorted(maps.Keys(m.titles)) {
+		row := make([]interface{}, len(m.headings))
+		for i, h := range m.headings {
+			row[i] = m.titles[filename][h]
+		}
+		row[0] = filename
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+func (m *memSource) getCells(_ context.Context, filename string) (map[string]string, error) {
+	return m.titles[filename], nil
+}
+
+func (m *memSource) setCells(_ context.Context, filename string, cells map[string]string) error {
+	if m.titles[filename] == nil {
+		m.titles[filename] = make(map[string]string)
+	}
+	for h, v := range cells {
+		m.titles[filename][h] = v
+	}
+	return nil
+}
+
+func TestHandleAPITitlePatch(t *testing.T) {
+	src := &memSource{
+		headings: []string{"filename", "title", "year", "subdir"},
+		titles: map[string]map[string]string{
+			"Top Hat.mp4":   {"title": "Top Hat", "year": "1935"},
+			"Toy Story.mp4": {"title": "Toy Story", "subdir": "Kids"},
+		},
+	}
+	s := &server{
+		objNames:     set.New("Top Hat.mp4", "Toy Story.mp4"),
+		objNamesTime: time.Now(),
+		meta:         src,
+		sheetID:      "mem",
+		health:       newHealthCounters(),
+		admins:       parseAdmins("", "nora"),
+		access:       map[string]set.Of[string]{"Kids": set.New("alice")},
+	}
+
+	patch := func(who string, viaGrant bool, rootName, body string) int {
+		req := httptest.NewRequest("PATCH", "/api/titles/"+url.PathEscape(rootName), strings.NewReader(body))
+		req.SetPathValue("rootname", rootName)
+		ctx := context.WithValue(req.Context(), principalKey, who)
+		ctx = context.WithValue(ctx, viaGrantKey, viaGrant)
+		rec := httptest.NewRecorder()
+		mid.Err(s.handleAPITitlePatch).ServeHTTP(rec, req.WithContext(ctx))
+		return rec.Code
+	}
+
+	cases := []struct {
+		name     string
+		who      string
+		viaGrant bool
+		rootName string
+		body     string
+		want     int
+	}{
+		{name: "admin", who: "nora", rootName: "Top Hat", body: `{"year": 1936}`, want: http.StatusOK},
+		{name: "anonymous", rootName: "Top Hat", body: `{"year": 1937}`, want: http.StatusForbidden},
+		{name: "other user", who: "alice", rootName: "Top Hat", body: `{"year": 1937}`, want: http.StatusForbidden},
+		{name: "admin's token", who: "nora", viaGrant: true, rootName: "Top Hat", body: `{"year": 1937}`, want: http.StatusForbidden},
+		{name: "access field", who: "nora", rootName: "Top Hat", body: `{"Subdir": "Kids"}`, want: http.StatusForbidden},
+		{name: "pin field", who: "nora", rootName: "Top Hat", body: `{"adult": ""}`, want: http.StatusForbidden},
+		{name: "restricted title", who: "nora", rootName: "Toy Story", body: `{"subdir": ""}`, want: http.StatusForbidden},
+		{name: "restricted title, ordinary field", who: "nora", rootName: "Toy Story", body: `{"year": 1995}`, want: http.StatusForbidden},
+		{name: "unknown field", who: "nora", rootName: "Top Hat", body: `{"color": "red"}`, want: http.StatusBadRequest},
+		{name: "no such title", who: "nora", rootName: "Swing Time", body: `{"year": 1936}`, want: http.StatusNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := patch(c.who, c.viaGrant, c.rootName, c.body); got != c.want {
+				t.Errorf("got status %d, want %d", got, c.want)
+			}
+		})
+	}
+
+	if got := src.titles["Top Hat.mp4"]["year"]; got != "1936" {
+		t.Errorf("got year %q, want 1936", got)
+	}
+	if got := src.titles["Toy Story.mp4"]["subdir"]; got != "Kids" {
+		t.Errorf("got subdir %q, want Kids", got)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// Kodi looks for a title's artwork in files beside its media object
+// named ROOT-KIND.EXT,
+// as in "The Thin Man-poster.jpg" and "The Thin Man-fanart.jpg".
+// The server lists such an entry for each kind of artwork a title has,
+// with the title's hash prefix,
+// for clients that ignore the thumbs in .nfo files.
+// The artwork comes from an object of that name in the bucket
+// or else from the title's metadata
+// (e.g. the Poster column),
+// in which case the server serves the image it already has in the bucket
+// or redirects to its URL.
+
+var (
+	artworkKinds = []string{"poster", "fanart", "banner", "clearart", "clearlogo", "discart", "landscape"}
+	artworkExts  = []string{".jpg", ".png"}
+)
+
+// artwork is an item of artwork for a title.
+// Its name is ROOT-KIND.EXT,
+// and it comes from objName in the bucket,
+// or else from url.
+type artwork struct {
+	name, objName, url string
+}
+
+// parseArtworkName parses the name of an artwork item, ROOT-KIND.EXT.
+func parseArtworkName(name string) (rootName, kind string, ok bool) {
+	ext := filepath.Ext(name)
+	if !slices.Contains(artworkExts, strings.ToLower(ext)) {
+		return "", "", false
+	}
+	base := strings.TrimSuffix(name, ext)
+	i := strings.LastIndex(base, "-")
+	if i < 1 {
+		return "", "", false
+	}
+	if kind = base[i+1:]; !slices.Contains(artworkKinds, kind) {
+		return "", "", false
+	}
+	return base[:i], kind, true
+}
+
+// artworks returns the items of artwork for a title, in the order of artworkKinds.
+// The caller must hold s.mu.
+func (s *server) artworks(rootName string, info movieInfo) []artwork {
+	var result []artwork
+	for _, kind := range artworkKinds {
+		if a, ok := s.artworkOf(rootName, info, kind); ok {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// artworkOf returns the given kind of artwork for a title, if it has any.
+// The caller must hold s.mu.
+func (s *server) artworkOf(rootName string, info movieInfo, kind string) (artwork, bool) {
+	for _, ext := range artworkExts {
+		if objName := rootName + "-" + kind + ext; s.objNames.Has(objName) {
+			return artwork{name: objName, objName: objName}, true
+		}
+	}
+	for i, th := range info.Thumbs {
+		if th.Aspect != kind || th.origVal == "" {
+			continue
+		}
+		ext := filepath.Ext(th.origVal)
+		if !slices.Contains(artworkExts, strings.ToLower(ext)) {
+			ext = ".jpg"
+		}
+		a := artwork{name: rootName + "-" + kind + ext, url: th.origVal}
+		if i == 0 {
+			// As in handleThumb, the first thumb may be in the bucket.
+			if objName := rootName + filepath.Ext(th.origVal); s.objNames.Has(objName) {
+				a.objName = objName
+			}
+		}
+		return a, true
+	}
+	return artwork{}, false
+}
+
+// handleArtwork serves the artwork item with the given name
+// (without its hash prefix).
+func (s *server) handleArtwork(ctx context.Context, w http.ResponseWriter, req *http.Request, name, path string) error {
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.artworkItem(name)
+	if !ok {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no artwork %s", name),
+		}
+	}
+	if a.objName != "" {
+		return errors.Wrap(s.serveObj(ctx, w, req, a.objName, path, false), "serving artwork")
+	}
+	http.Redirect(w, req, a.url, http.StatusFound)
+	return nil
+}
+
+// artworkItem returns the artwork item with the given name, if there is one.
+// The caller must hold s.mu.
+func (s *server) artworkItem(name string) (artwork, bool) {
+	rootName, kind, ok := parseArtworkName(name)
+	if !ok {
+		return artwork{}, false
+	}
+	a, ok := s.artworkOf(rootName, s.infoMap[rootName], kind)
+	if !ok || a.name != name {
+		return artwork{}, false
+	}
+	return a, true
+}
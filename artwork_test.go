@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestArtworks(t *testing.T) {
+	s := &server{
+		objNames: set.New("The Thin Man.iso", "The Thin Man-fanart.jpg", "Top Hat.mp4", "Top Hat.jpg"),
+		infoMap: map[string]movieInfo{
+			"The Thin Man": {Thumbs: []thumb{
+				{Aspect: "poster", origVal: "https://example.com/thin.jpg"},
+				{Aspect: "clearlogo", origVal: "https://example.com/logo"},
+			}},
+			"Top Hat": {Thumbs: []thumb{{Aspect: "poster", origVal: "tophat.jpg"}}},
+		},
+	}
+
+	got := s.artworks("The Thin Man", s.infoMap["The Thin Man"])
+	want := []artwork{
+		{name: "The Thin Man-poster.jpg", url: "https://example.com/thin.jpg"},
+		{name: "The Thin Man-fanart.jpg", objName: "The Thin Man-fanart.jpg"},
+		{name: "The Thin Man-clearlogo.jpg", url: "https://example.com/logo"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("artwork %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// The first thumb may be in the bucket, as for /thumbs/.
+	if a, ok := s.artworkItem("Top Hat-poster.jpg"); !ok || a.objName != "Top Hat.jpg" {
+		t.Errorf("got %+v, %v for Top Hat's poster", a, ok)
+	}
+
+	item := rootNamePrefix("The Thin Man") + "The Thin Man-poster.jpg"
+	if _, objName := parsePath(item); objName != item {
+		t.Errorf("parsePath(%q) gives object %q", item, objName)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"google.golang.org/api/iterator"
+)
+
+// With serve -audit,
+// the server records who streamed which media object, when, and from where,
+// in an append-only log:
+// a local file (with -audit-file),
+// or else new bucket objects under auditPrefix,
+// written every auditFlushInterval
+// (since bucket objects can't be appended to).
+// Players fetch a title in many requests,
+// so a stream is recorded again only after a pause of auditRepeatWindow.
+// The audit subcommand queries the log.
+
+const (
+	auditPrefix         = "kodigcs/audit/"
+	auditObjTimeFormat  = "20060102T150405.000000000Z"
+	auditFlushInterval  = time.Minute
+	auditRepeatWindow   = 30 * time.Minute
+	auditTimeTextFormat = "2006-01-02 15:04:05"
+)
+
+// auditEntry is one record in the audit log.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"` // the principal, such as a username, token name, or share:USERNAME
+	Addr   string    `json:"addr,omitempty"`
+	Object string    `json:"object"`
+	Agent  string    `json:"agent,omitempty"`
+}
+
+type auditKey struct {
+	user, addr, object string
+}
+
+// auditLog records streams.
+type auditLog struct {
+	file   string                // local file, or ""
+	bucket *storage.BucketHandle // used if file is ""
+
+	mu      sync.Mutex
+	pending []auditEntry // not yet written to the bucket
+	recent  map[auditKey]time.Time
+}
+
+func newAuditLog(file string, bucket *storage.BucketHandle) *auditLog {
+	return &auditLog{
+		file:   file,
+		bucket: bucket,
+		recent: make(map[auditKey]time.Time),
+	}
+}
+
+// auditStream records a request for a media object in the audit log, if there is one.
+// HEAD requests, and requests that continue a recent stream, are not recorded.
+func (s *server) auditStream(req *http.Request, objName string) {
+	if s.audit == nil || req.Method == "HEAD" || !isMediaExt(filepath.Ext(objName)) {
+		return
+	}
+	e := auditEntry{
+		Time:   time.Now(),
+		User:   principal(req.Context()),
+		Object: objName,
+		Agent:  req.UserAgent(),
+	}
+	if addr, ok := remoteAddr(req); ok {
+		e.Addr = addr.String()
+	}
+	if err := s.audit.record(e); err != nil {
+		log.Printf("Error writing audit log: %s", err)
+	}
+}
+
+func (a *auditLog) record(e auditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := auditKey{user: e.User, addr: e.Addr, object: e.Object}
+	last, ok := a.recent[key]
+	a.recent[key] = e.Time
+	if ok && e.Time.Sub(last) < auditRepeatWindow {
+		return nil
+	}
+
+	if a.file == "" {
+		a.pending = append(a.pending, e)
+		return nil
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "encoding audit entry")
+	}
+	f, err := os.OpenFile(a.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "opening %s", a.file)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "writing %s", a.file)
+	}
+	return errors.Wrapf(f.Close(), "closing %s", a.file)
+}
+
+// flush writes the pending entries, if any, to a new bucket object.
+func (a *auditLog) flush(ctx context.Context) error {
+	a.mu.Lock()
+	pending := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	objName := auditPrefix + time.Now().UTC().Format(auditObjTimeFormat) + ".jsonl"
+	w := a.bucket.Object(objName).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	w.ContentType = "application/jsonl"
+	enc := json.NewEncoder(w)
+	for _, e := range pending {
+		if err := enc.Encode(e); err != nil {
+			w.Close()
+			a.requeue(pending)
+			return errors.Wrapf(err, "writing %s", objName)
+		}
+	}
+	if err := w.Close(); err != nil {
+		a.requeue(pending)
+		return errors.Wrapf(err, "writing %s", objName)
+	}
+	return nil
+}
+
+// requeue puts entries that could not be written back in the queue for the next flush.
+func (a *auditLog) requeue(entries []auditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pending = append(entries, a.pending...)
+}
+
+// run periodically flushes entries to the bucket
+// and forgets streams that are no longer recent,
+// until the context is canceled.
+func (a *auditLog) run(ctx context.Context) {
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := a.flush(ctx); err != nil {
+				log.Printf("Error flushing audit log: %s", err)
+			}
+
+			a.mu.Lock()
+			for k, t := range a.recent {
+				if now.Sub(t) >= auditRepeatWindow {
+					delete(a.recent, k)
+				}
+			}
+			a.mu.Unlock()
+		}
+	}
+}
+
+// auditFilter selects entries from the audit log.
+type auditFilter struct {
+	since, until time.Time // zero means unbounded
+	user         string
+	object       string // case-insensitive substring
+	addr         string
+}
+
+func (f auditFilter) match(e auditEntry) bool {
+	switch {
+	case !f.since.IsZero() && e.Time.Before(f.since):
+		return false
+	case !f.until.IsZero() && !e.Time.Before(f.until):
+		return false
+	case f.user != "" && e.User != f.user:
+		return false
+	case f.addr != "" && e.Addr != f.addr:
+		return false
+	case f.object != "" && !strings.Contains(strings.ToLower(e.Object), strings.ToLower(f.object)):
+		return false
+	}
+	return true
+}
+
+// readAuditEntries calls fn for each entry in r that matches the filter.
+func readAuditEntries(r io.Reader, filt auditFilter, fn func(auditEntry)) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var e auditEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return errors.Wrap(err, "decoding audit entry")
+		}
+		if filt.match(e) {
+			fn(e)
+		}
+	}
+	return sc.Err()
+}
+
+// queryAudit returns the entries matching the filter,
+// from the local file if it's not "" or else from the bucket,
+// oldest first.
+func queryAudit(ctx context.Context, file string, bucket *storage.BucketHandle, filt auditFilter) ([]auditEntry, error) {
+	var entries []auditEntry
+	add := func(e auditEntry) { entries = append(entries, e) }
+
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening %s", file)
+		}
+		defer f.Close()
+		if err := readAuditEntries(f, filt, add); err != nil {
+			return nil, errors.Wrapf(err, "reading %s", file)
+		}
+		return entries, nil
+	}
+
+	it := bucket.Objects(ctx, &storage.Query{Prefix: auditPrefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "listing audit objects")
+		}
+
+		// An object holds entries from before the time in its name.
+		written, err := time.Parse(auditObjTimeFormat, strings.TrimSuffix(strings.TrimPrefix(attrs.Name, auditPrefix), ".jsonl"))
+		if err == nil && !filt.since.IsZero() && written.Before(filt.since) {
+			continue
+		}
+
+		r, err := bucket.Object(attrs.Name).NewReader(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", attrs.Name)
+		}
+		err = readAuditEntries(r, filt, add)
+		r.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", attrs.Name)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
+
+// parseAuditTime parses the -since and -until arguments of the audit subcommand:
+// a duration before now (such as 24h),
+// a date (2006-01-02, in local time),
+// or an RFC 3339 time.
+func parseAuditTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("bad time %q, want a duration (e.g. 24h), a date (2006-01-02), or an RFC 3339 time", s)
+}
+
+// audit prints the entries of the audit log that match the given criteria.
+func (c maincmd) audit(ctx context.Context, file, since, until, user, object, addr string, asJSON bool, _ []string) error {
+	var (
+		filt = auditFilter{user: user, object: object, addr: addr}
+		now  = time.Now()
+		err  error
+	)
+	if filt.since, err = parseAuditTime(since, now); err != nil {
+		return errors.Wrap(err, "in -since")
+	}
+	if filt.until, err = parseAuditTime(until, now); err != nil {
+		return errors.Wrap(err, "in -until")
+	}
+
+	entries, err := queryAudit(ctx, file, c.bucket, filt)
+	if err != nil {
+		return err
+	}
+	return writeAuditEntries(os.Stdout, entries, asJSON)
+}
+
+// writeAuditEntries writes entries as JSON lines or as a table.
+func writeAuditEntries(w io.Writer, entries []auditEntry, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return errors.Wrap(err, "writing entry")
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, e := range entries {
+		user := e.User
+		if user == "" {
+			user = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.Time.Local().Format(auditTimeTextFormat), user, e.Addr, e.Object)
+	}
+	return errors.Wrap(tw.Flush(), "writing entries")
+}
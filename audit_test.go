@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	s := &server{audit: newAuditLog(file, nil)}
+
+	stream := func(who, addr, path, objName string) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = addr + ":5555"
+		req = req.WithContext(context.WithValue(req.Context(), principalKey, who))
+		s.auditStream(req, objName)
+	}
+
+	stream("alice", "192.168.1.5", "/The%20Thin%20Man.mp4", "The Thin Man.mp4")
+	stream("alice", "192.168.1.5", "/The%20Thin%20Man.mp4", "The Thin Man.mp4")     // continues the stream
+	stream("alice", "192.168.1.5", "/x-The%20Thin%20Man.nfo", "x-The Thin Man.nfo") // not media
+	stream("kodi-den", "192.168.1.7", "/Top%20Hat.mp4", "Top Hat.mp4")
+	stream("alice", "203.0.113.9", "/Top%20Hat.mp4", "Top Hat.mp4")
+
+	ctx := context.Background()
+	entries, err := queryAudit(ctx, file, nil, auditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3: %v", len(entries), entries)
+	}
+	if e := entries[0]; e.User != "alice" || e.Addr != "192.168.1.5" || e.Object != "The Thin Man.mp4" {
+		t.Errorf("got first entry %+v", e)
+	}
+
+	cases := []struct {
+		filt auditFilter
+		want int
+	}{
+		{filt: auditFilter{user: "alice"}, want: 2},
+		{filt: auditFilter{object: "top hat"}, want: 2},
+		{filt: auditFilter{addr: "203.0.113.9"}, want: 1},
+		{filt: auditFilter{since: time.Now().Add(time.Hour)}, want: 0},
+		{filt: auditFilter{until: time.Now().Add(-time.Hour)}, want: 0},
+	}
+	for _, c := range cases {
+		got, err := queryAudit(ctx, file, nil, c.filt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != c.want {
+			t.Errorf("got %d entries for %+v, want %d", len(got), c.filt, c.want)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := writeAuditEntries(buf, entries, false); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 3 || !strings.Contains(lines[1], "kodi-den") {
+		t.Errorf("got table %s", buf)
+	}
+}
+
+func TestParseAuditTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	got, err := parseAuditTime("24h", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := now.Add(-24 * time.Hour); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, err = parseAuditTime("2024-04-01T08:00:00Z", now); err != nil || got.Hour() != 8 {
+		t.Errorf("got %s, %v", got, err)
+	}
+	if _, err := parseAuditTime("2024-04-01", now); err != nil {
+		t.Error(err)
+	}
+	if _, err := parseAuditTime("last week", now); err == nil {
+		t.Error("parsed last week")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// An authenticator decides whether a request may proceed.
+//
+// On success it returns the name of the authenticated principal,
+// which is "" for anonymous access.
+// On failure it returns an error,
+// normally a mid.CodeErr with status 401 or 403,
+// and may add headers (such as WWW-Authenticate) to the response.
+//
+// Every route served by the server goes through an authenticator
+// (see server.route),
+// so a new authentication scheme needs only a new implementation of this interface.
+type authenticator interface {
+	authenticate(w http.ResponseWriter, req *http.Request) (string, error)
+}
+
+// noAuth allows all requests.
+type noAuth struct{}
+
+func (noAuth) authenticate(http.ResponseWriter, *http.Request) (string, error) {
+	return "", nil
+}
+
+// basicRealm is the WWW-Authenticate header for HTTP Basic Auth.
+const basicRealm = `Basic realm="Access to list and stream titles"`
+
+// basicAuth requires HTTP Basic Auth with a single username and password.
+type basicAuth struct {
+	username, password string
+}
+
+func (a basicAuth) authenticate(w http.ResponseWriter, req *http.Request) (string, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", basicRealm)
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	if !a.checkPassword(username, password) {
+		log.Printf("Unauthorized access attempt from %s (username %s)", req.RemoteAddr, username)
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+
+	return username, nil
+}
+
+func (a basicAuth) checkPassword(username, password string) bool {
+	usernameOK := subtle.ConstantTimeCompare([]byte(username), []byte(a.username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) == 1
+	return usernameOK && passwordOK
+}
+
+func (a basicAuth) hasAccount(username string) bool {
+	return username == a.username
+}
+
+// tokenAuth accepts tokens issued as grants
+// (by the token issue and addon build subcommands),
+// as bearer tokens
+// or in the token query parameter.
+type tokenAuth struct {
+	grants *grantStore
+}
+
+func (a tokenAuth) authenticate(w http.ResponseWriter, req *http.Request) (string, error) {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		// (The logs redact token parameters.)
+		if token = req.URL.Query().Get("token"); token == "" {
+			return "", mid.CodeErr{C: http.StatusUnauthorized}
+		}
+	}
+	g, ok := a.grants.lookup(grantToken, token)
+	if !ok {
+		log.Printf("Unauthorized access attempt from %s (bad token)", req.RemoteAddr)
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	return g.Name, nil
+}
+
+// shareAuth accepts share links:
+// URLs carrying the secret of a share grant in the "share" query parameter.
+// The grant's subject is the root name of the one title it gives access to.
+type shareAuth struct {
+	grants *grantStore
+}
+
+func (a shareAuth) authenticate(w http.ResponseWriter, req *http.Request) (string, error) {
+	secret := req.URL.Query().Get("share")
+	if secret == "" {
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	g, ok := a.grants.lookup(grantShare, secret)
+	if !ok {
+		log.Printf("Unauthorized access attempt from %s (bad share link)", req.RemoteAddr)
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	if shareSubject(req.URL.Path) != g.Subject {
+		return "", mid.CodeErr{
+			C:   http.StatusForbidden,
+			Err: fmt.Errorf("share link for %s used for %s", g.Subject, req.URL.Path),
+		}
+	}
+	return g.Name, nil
+}
+
+// playlistAuth accepts the stream URLs of a library playlist (see handleLibraryPlaylist):
+// URLs for titles carrying the secret of a playlist grant in the "playlist" query parameter.
+type playlistAuth struct {
+	grants *grantStore
+}
+
+func (a playlistAuth) authenticate(w http.ResponseWriter, req *http.Request) (string, error) {
+	secret := req.URL.Query().Get("playlist")
+	if secret == "" {
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	g, ok := a.grants.lookup(grantPlaylist, secret)
+	if !ok {
+		log.Printf("Unauthorized access attempt from %s (bad playlist link)", req.RemoteAddr)
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	if shareSubject(req.URL.Path) == "" {
+		return "", mid.CodeErr{
+			C:   http.StatusForbidden,
+			Err: fmt.Errorf("playlist link used for %s", req.URL.Path),
+		}
+	}
+	return g.Name, nil
+}
+
+// shareSubject is the root name of the title that a request path is for,
+// or "" if it is not for a title.
+// A title's files (media, NFO, playlist), its poster, and its player page all have the same subject.
+func shareSubject(path string) string {
+	path = strings.Trim(path, "/")
+	if rest, ok := strings.CutPrefix(path, "thumbs/"); ok {
+		return strings.TrimSuffix(rest, filepath.Ext(rest))
+	}
+	if rest, ok := strings.CutPrefix(path, "watch/"); ok {
+		return rest
+	}
+	_, objname := parsePath(path)
+	if objname == "" {
+		return ""
+	}
+	// The hash prefix is that of the title's root name,
+	// even for a file named with tags after it, like a subtitle file.
+	name := objname[8:]
+	for _, rootName := range itemRoots(name) {
+		if objname[:8] == rootNamePrefix(rootName) {
+			return rootName
+		}
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// anyAuth tries each of several authenticators in turn,
+// succeeding with the first one that succeeds.
+// If all fail, the error from the first one is returned.
+// On success, any WWW-Authenticate header added by an earlier failure is removed.
+type anyAuth []authenticator
+
+func (a anyAuth) authenticate(w http.ResponseWriter, req *http.Request) (string, error) {
+	name, _, err := a.authenticateVia(w, req)
+	return name, err
+}
+
+// authenticateVia is like authenticate
+// but also returns the authenticator that succeeded.
+func (a anyAuth) authenticateVia(w http.ResponseWriter, req *http.Request) (string, authenticator, error) {
+	var firstErr error
+	for _, auth := range a {
+		name, err := auth.authenticate(w, req)
+		if err == nil {
+			w.Header().Del("WWW-Authenticate")
+			return name, auth, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		return "", nil, mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	return "", nil, firstErr
+}
+
+type principalKeyType struct{}
+
+var principalKey principalKeyType
+
+// principal returns the name of the authenticated principal for a request,
+// as determined by the server's authenticator.
+func principal(ctx context.Context) string {
+	name, _ := ctx.Value(principalKey).(string)
+	return name
+}
+
+// authed wraps a handler function so that it runs only for requests that pass the server's authenticator.
+func (s *server) authed(f func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		auth := s.auth
+		if auth == nil {
+			auth = noAuth{}
+		}
+		var (
+			name string
+			via  = auth
+			err  error
+		)
+		if multi, ok := auth.(anyAuth); ok {
+			name, via, err = multi.authenticateVia(w, req)
+		} else {
+			name, err = auth.authenticate(w, req)
+		}
+		if err != nil {
+			var codeErr mid.CodeErr
+			if !errors.As(err, &codeErr) {
+				err = mid.CodeErr{C: http.StatusUnauthorized, Err: err}
+			} else if s.login && codeErr.C == http.StatusUnauthorized && wantsLoginForm(req) {
+				redirectToLogin(w, req)
+				return nil
+			}
+			return err
+		}
+		unlocked, err := s.checkPIN(req, name)
+		if err != nil {
+			return err
+		}
+		ctx := context.WithValue(req.Context(), principalKey, name)
+		ctx = context.WithValue(ctx, pinKey, unlocked)
+		ctx = context.WithValue(ctx, viaGrantKey, isGrantAuth(via))
+		return f(w, req.WithContext(ctx))
+	}
+}
+
+// observed wraps a handler to count its requests and errors in s.health
+// and to report them to s.watchdog, if any.
+func (s *server) observed(f func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		start := time.Now()
+		err := f(w, req)
+		s.health.observe(err)
+		if s.watchdog != nil {
+			s.watchdog.observe(requestKind(req), time.Since(start), err != nil && errorCode(err) >= 500)
+		}
+		return err
+	}
+}
+
+// route adds an authenticated handler function to mux,
+// refusing requests from addresses outside the server's ipFilter
+// and from clients that are over the rate limit or locked out.
+func (s *server) route(mux *http.ServeMux, pattern string, f func(http.ResponseWriter, *http.Request) error) {
+	h := mid.Err(s.observed(s.filtered(s.drained(s.throttled(s.authed(s.reported(f)))))))
+	mux.Handle(pattern, s.traced(pattern, s.logged(h)))
+}
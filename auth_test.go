@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestAnyAuth(t *testing.T) {
+	grants := &grantStore{
+		grants:  make(map[string]*grant),
+		known:   set.New[string](),
+		deleted: set.New[string](),
+	}
+	token, _, err := grants.issue(grantToken, "phone", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	share, _, err := grants.issue(grantShare, "friend", "The Thin Man", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{auth: anyAuth{basicAuth{username: "nora", password: "secret"}, tokenAuth{grants: grants}, shareAuth{grants: grants}}}
+
+	cases := []struct {
+		name           string
+		target, bearer string
+		basic          bool
+		wantCode       int
+		wantName       string
+		wantGrant      bool
+		wantChallenge  bool
+	}{{
+		name:          "none",
+		target:        "/",
+		wantCode:      http.StatusUnauthorized,
+		wantChallenge: true,
+	}, {
+		name:     "password",
+		target:   "/",
+		basic:    true,
+		wantCode: http.StatusOK,
+		wantName: "nora",
+	}, {
+		name:      "bearer token",
+		target:    "/",
+		bearer:    token,
+		wantCode:  http.StatusOK,
+		wantName:  "phone",
+		wantGrant: true,
+	}, {
+		name:      "token parameter",
+		target:    "/?token=" + token,
+		wantCode:  http.StatusOK,
+		wantName:  "phone",
+		wantGrant: true,
+	}, {
+		name:      "share link",
+		target:    "/watch/The%20Thin%20Man?share=" + share,
+		wantCode:  http.StatusOK,
+		wantName:  "friend",
+		wantGrant: true,
+	}, {
+		name:          "bad token",
+		target:        "/?token=bogus",
+		wantCode:      http.StatusUnauthorized,
+		wantChallenge: true,
+	}, {
+		// The error is the first authenticator's (basicAuth's), not shareAuth's 403.
+		name:          "share link for another title",
+		target:        "/watch/After%20the%20Thin%20Man?share=" + share,
+		wantCode:      http.StatusUnauthorized,
+		wantChallenge: true,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", c.target, nil)
+			if c.basic {
+				req.SetBasicAuth("nora", "secret")
+			}
+			if c.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+c.bearer)
+			}
+			var (
+				gotName  string
+				gotGrant bool
+			)
+			h := s.authed(func(w http.ResponseWriter, req *http.Request) error {
+				gotName, gotGrant = principal(req.Context()), viaGrant(req.Context())
+				return nil
+			})
+			rec := httptest.NewRecorder()
+			code := http.StatusOK
+			if err := h(rec, req); err != nil {
+				code = errorCode(err)
+			}
+			if code != c.wantCode {
+				t.Fatalf("got status %d, want %d", code, c.wantCode)
+			}
+			if gotName != c.wantName || gotGrant != c.wantGrant {
+				t.Errorf("got principal %q (via grant %v), want %q (via grant %v)", gotName, gotGrant, c.wantName, c.wantGrant)
+			}
+			if got := rec.Header().Get("WWW-Authenticate") != ""; got != c.wantChallenge {
+				t.Errorf("got WWW-Authenticate %q", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
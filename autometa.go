@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"golang.org/x/time/rate"
+)
+
+// In the sheet-free mode (serve -auto),
+// each title's metadata comes from its filename
+// and from scraper lookups run in the background,
+// whose results are kept in a local file.
+
+const (
+	autoInterval   = 10 * time.Minute   // how often to look for new objects in the bucket
+	autoRetryAfter = 7 * 24 * time.Hour // how long before looking up an unidentified title again
+)
+
+// autoMeta is the metadata database of the sheet-free mode.
+type autoMeta struct {
+	path     string
+	scrapers Scraper
+
+	mu      sync.Mutex
+	entries map[string]*autoEntry // keyed by root name
+}
+
+type autoEntry struct {
+	ID      string            `json:"id,omitempty"`
+	Cells   map[string]string `json:"cells,omitempty"` // as in a row of the metadata spreadsheet
+	Checked time.Time         `json:"checked"`
+}
+
+// newAutoMeta loads the database in the given file, if it exists.
+// Lookups use the omdb scraper if $OMDB_API_KEY is set,
+// and the imdb scraper otherwise.
+func newAutoMeta(path string, bucket *storage.BucketHandle) (*autoMeta, error) {
+	a := &autoMeta{
+		path:    path,
+		entries: make(map[string]*autoEntry),
+	}
+
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// ok
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", path)
+	} else if err := json.Unmarshal(buf, &a.entries); err != nil {
+		return nil, errors.Wrapf(err, "decoding %s", path)
+	}
+
+	transport := &retryTransport{retries: scrapeRetries, backoff: scrapeBackoff, transport: http.DefaultTransport}
+	conf := scraperConfig{
+		cl: &http.Client{
+			Transport: &limitedTransport{
+				limiter:   rate.NewLimiter(rate.Every(10*time.Second), 1),
+				transport: transport,
+			},
+		},
+		bucket:    bucket,
+		omdbKey:   os.Getenv("OMDB_API_KEY"),
+		transport: transport,
+	}
+	names := "imdb"
+	if conf.omdbKey != "" {
+		logRedactor.addSecret(conf.omdbKey)
+		names = "omdb"
+	}
+	a.scrapers, err = newScrapers(names, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// save writes the database to its file.
+func (a *autoMeta) save() error {
+	a.mu.Lock()
+	buf, err := json.MarshalIndent(a.entries, "", "  ")
+	a.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "encoding automatic metadata")
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "creating temp file")
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(buf)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "writing %s", f.Name())
+	}
+	return errors.Wrapf(os.Rename(f.Name(), a.path), "renaming %s to %s", f.Name(), a.path)
+}
+
+// due tells whether the title with the given root name needs looking up.
+func (a *autoMeta) due(rootName string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry, ok := a.entries[rootName]
+	if !ok {
+		return true
+	}
+	return entry.ID == "" && time.Since(entry.Checked) > autoRetryAfter
+}
+
+// row synthesizes a row of the metadata spreadsheet for the given object,
+// suitable for server.parseInfoRow.
+func (a *autoMeta) row(objName string) ([]string, string, []interface{}) {
+	rootName := strings.TrimSuffix(objName, filepath.Ext(objName))
+
+	cells := make(map[string]string)
+	title, year := parseSceneName(rootName)
+	cells["title"] = title
+	if year > 0 {
+		cells["year"] = strconv.Itoa(year)
+	}
+
+	a.mu.Lock()
+	if entry, ok := a.entries[rootName]; ok {
+		for heading, val := range entry.Cells {
+			cells[heading] = val
+		}
+	}
+	a.mu.Unlock()
+
+	headings := []string{"filename"}
+	for heading := range cells {
+		headings = append(headings, heading)
+	}
+	sort.Strings(headings[1:])
+
+	row := []interface{}{objName}
+	for _, heading := range headings[1:] {
+		row = append(row, cells[heading])
+	}
+	return headings, objName, row
+}
+
+// lookup identifies the title in the given object by searching for its name and year,
+// looks up its metadata,
+// and records the result.
+func (a *autoMeta) lookup(ctx context.Context, objName string) error {
+	var (
+		rootName    = strings.TrimSuffix(objName, filepath.Ext(objName))
+		title, year = parseSceneName(rootName)
+		entry       = &autoEntry{Checked: time.Now()}
+	)
+
+	id, err := guessID(ctx, a.scrapers, objName, title, year)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		entry.ID = id
+
+		info, err := a.scrapers.LookupByID(ctx, id)
+		if errors.Is(err, errNotFound) {
+			log.Printf("No info found for %s (id %s)", objName, id)
+		} else if err != nil {
+			return errors.Wrapf(err, "getting info for %s (id %s)", objName, id)
+		} else {
+			entry.Cells = info.cells()
+			if info.Name != "" {
+				entry.Cells["title"] = info.Name
+			}
+			entry.Cells["imdbid"] = id
+		}
+	}
+
+	a.mu.Lock()
+	a.entries[rootName] = entry
+	a.mu.Unlock()
+
+	return a.save()
+}
+
+// runAuto keeps the info map up to date in the sheet-free mode,
+// until the context is canceled.
+func (s *server) runAuto(ctx context.Context) {
+	for {
+		if err := s.autoPass(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Error updating automatic metadata: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(autoInterval):
+		}
+	}
+}
+
+// autoPass rebuilds the info map from filenames and the automatic metadata database,
+// then looks up any titles that need it,
+// updating the info map as it goes.
+func (s *server) autoPass(ctx context.Context) error {
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+
+	s.mu.RLock()
+	var (
+		allNames = s.objNames // replaced, not modified, when reloaded
+		objNames []string
+	)
+	for objName := range allNames {
+		if isMediaExt(filepath.Ext(objName)) && !s.isExtra(objName) && !s.isDiscObj(objName) {
+			objNames = append(objNames, objName)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Strings(objNames)
+
+	sidecars := readSidecars(ctx, s.bucket, allNames)
+
+	parse := func(objName string) (string, movieInfo) {
+		headings, name, row := s.auto.row(objName)
+		if fields, ok := sidecars[strings.TrimSuffix(objName, filepath.Ext(objName))]; ok {
+			headings, row = applySidecar(headings, row, fields)
+		}
+		return s.parseInfoRow(headings, name, row)
+	}
+
+	infoMap := make(map[string]movieInfo)
+	for _, objName := range objNames {
+		rootName, info := parse(objName)
+		infoMap[rootName] = info
+	}
+
+	s.mu.Lock()
+	s.infoMap = infoMap
+	s.infoMapTime = time.Now()
+	s.mu.Unlock()
+
+	for _, objName := range objNames {
+		rootName := strings.TrimSuffix(objName, filepath.Ext(objName))
+		if !s.auto.due(rootName) {
+			continue
+		}
+		if err := s.auto.lookup(ctx, objName); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Error looking up %s: %s", objName, err)
+			continue
+		}
+
+		_, info := parse(objName)
+
+		s.mu.Lock()
+		s.infoMap[rootName] = info
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+var sceneSepRE = regexp.MustCompile(`[\s()\[\]{}]+`)
+
+// Filename words that follow the title and year in scene-style names.
+var sceneJunk = set.New(
+	"480p", "576p", "720p", "1080p", "1080i", "2160p", "4k", "uhd",
+	"bluray", "blu", "bdrip", "brrip", "dvdrip", "dvd", "dvdr", "webrip", "web", "webdl", "hdtv", "hdrip", "remux",
+	"x264", "x265", "h264", "h265", "hevc", "avc", "xvid", "divx",
+	"aac", "ac3", "dts", "ddp5", "dd5", "truehd", "atmos",
+	"hdr", "hdr10", "10bit", "sdr",
+	"proper", "repack", "extended", "unrated", "remastered", "internal", "limited", "criterion",
+)
+
+// parseSceneName infers a title and year (or zero) from a filename without its extension,
+// such as "The.Thin.Man.1934.1080p.BluRay.x264-GROUP" or "The Thin Man (1934)".
+// The version of a title, as in "The Thin Man (1934) {4K}", is ignored.
+func parseSceneName(rootName string) (string, int) {
+	name := rootName
+	if base, _, ok := parseVersion(name); ok {
+		name = base
+	}
+	if !strings.Contains(name, " ") {
+		name = strings.NewReplacer(".", " ", "_", " ").Replace(name)
+	}
+	words := sceneSepRE.Split(strings.TrimSpace(name), -1)
+
+	// The title ends at the first junk word,
+	// or at the last plausible year before it
+	// (so that "Blade Runner 2049 2017" has the title "Blade Runner 2049").
+	end, year := len(words), 0
+	maxYear := time.Now().Year() + 1
+	for i, w := range words {
+		if i == 0 {
+			continue
+		}
+		lw := strings.ToLower(w)
+		if first, _, _ := strings.Cut(lw, "-"); sceneJunk.Has(lw) || sceneJunk.Has(first) {
+			if year == 0 {
+				end = i
+			}
+			break
+		}
+		if y, err := strconv.Atoi(w); err == nil && len(w) == 4 && y >= 1888 && y <= maxYear {
+			end, year = i, y
+		}
+	}
+
+	title := strings.Join(words[:end], " ")
+	if title == "" {
+		return rootName, 0
+	}
+	return title, year
+}
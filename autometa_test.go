@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestParseSceneName(t *testing.T) {
+	cases := []struct {
+		inp       string
+		wantTitle string
+		wantYear  int
+	}{
+		{inp: "The.Thin.Man.1934.1080p.BluRay.x264-GROUP", wantTitle: "The Thin Man", wantYear: 1934},
+		{inp: "The Thin Man (1934)", wantTitle: "The Thin Man", wantYear: 1934},
+		{inp: "The Thin Man", wantTitle: "The Thin Man"},
+		{inp: "Mr. Smith Goes to Washington", wantTitle: "Mr. Smith Goes to Washington"},
+		{inp: "Blade.Runner.2049.2017.2160p.WEB-DL", wantTitle: "Blade Runner 2049", wantYear: 2017},
+		{inp: "2001.A.Space.Odyssey.1968", wantTitle: "2001 A Space Odyssey", wantYear: 1968},
+		{inp: "1917.2019.720p", wantTitle: "1917", wantYear: 2019},
+		{inp: "Casablanca.DVDRip.XviD", wantTitle: "Casablanca"},
+		{inp: "His_Girl_Friday_1940", wantTitle: "His Girl Friday", wantYear: 1940},
+	}
+	for _, c := range cases {
+		t.Run(c.inp, func(t *testing.T) {
+			title, year := parseSceneName(c.inp)
+			if title != c.wantTitle || year != c.wantYear {
+				t.Errorf("got %q, %d; want %q, %d", title, year, c.wantTitle, c.wantYear)
+			}
+		})
+	}
+}
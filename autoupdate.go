@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+)
+
+// With serve -ssupdate-interval,
+// the server does what the ssupdate subcommand does
+// (with its default settings)
+// a minute after it starts and then periodically,
+// so that titles newly added to the metadata get their details filled in.
+//
+// An ssupdate run,
+// whether by the server or the subcommand,
+// holds a lock object in the bucket,
+// so that no two runs at once write to the same metadata.
+// The lock expires if its holder stops refreshing it,
+// as when the holder crashes.
+// A run that finds it has lost the lock
+// (because it could not refresh it in time and another run took it over)
+// stops.
+
+const (
+	updateLockObjName = "kodigcs/ssupdate.lock"
+
+	// How long a lock lasts unless refreshed,
+	// and how often its holder refreshes it.
+	updateLockTTL     = 10 * time.Minute
+	updateLockRefresh = 3 * time.Minute
+
+	// How long after starting the server begins its first scheduled ssupdate.
+	autoUpdateDelay = time.Minute
+)
+
+// updateLockedError means another ssupdate run holds the lock.
+type updateLockedError struct {
+	holder  string
+	expires time.Time
+}
+
+func (e updateLockedError) Error() string {
+	if e.holder == "" {
+		return "another ssupdate is running"
+	}
+	return fmt.Sprintf("another ssupdate is running (%s, lock expires %s unless refreshed)", e.holder, e.expires.Format(time.RFC3339))
+}
+
+// errUpdateLockLost is the cause of the cancellation of an ssupdate run that has lost its lock.
+var errUpdateLockLost = errors.New("lost the ssupdate lock to another run")
+
+// updateLock is the content of the lock object.
+type updateLock struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// acquireUpdateLock takes the ssupdate lock in the bucket,
+// taking over an expired one,
+// and refreshes it until release is called.
+// The run must use the returned context,
+// which is canceled with cause errUpdateLockLost if the lock is lost.
+func acquireUpdateLock(ctx context.Context, bucket *storage.BucketHandle) (lockCtx context.Context, release func(), err error) {
+	return lockUpdates(ctx, bucket, updateLockRefresh)
+}
+
+// lockUpdates is acquireUpdateLock with the refresh interval as a parameter.
+func lockUpdates(ctx context.Context, bucket *storage.BucketHandle, refresh time.Duration) (lockCtx context.Context, release func(), err error) {
+	obj := bucket.Object(updateLockObjName)
+
+	host, _ := os.Hostname()
+	holder := fmt.Sprintf("%s:%d", host, os.Getpid())
+
+	write := func(cond storage.Conditions) (int64, error) {
+		w := obj.If(cond).NewWriter(ctx)
+		w.ContentType = "application/json"
+		if err := json.NewEncoder(w).Encode(updateLock{Holder: holder, Expires: time.Now().Add(updateLockTTL)}); err != nil {
+			w.Close()
+			return 0, err
+		}
+		if err := w.Close(); err != nil {
+			return 0, err
+		}
+		return w.Attrs().Generation, nil
+	}
+
+	written := time.Now()
+	gen, err := write(storage.Conditions{DoesNotExist: true})
+	if isPreconditionFailed(err) {
+		gen, err = takeExpiredLock(ctx, obj, write)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	refreshCtx, stopRefresh := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+	lost := false
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-refreshCtx.Done():
+				return
+			case <-ticker.C:
+				now := time.Now()
+				newGen, err := write(storage.Conditions{GenerationMatch: gen})
+				if err == nil {
+					gen, written = newGen, now
+					continue
+				}
+				if !isPreconditionFailed(err) && now.Before(written.Add(updateLockTTL)) {
+					// Still ours until it expires.
+					log.Printf("Error refreshing the ssupdate lock: %s", err)
+					continue
+				}
+				log.Printf("Lost the ssupdate lock: %s", err)
+				lost = true
+				cancel(errUpdateLockLost)
+				return
+			}
+		}
+	}()
+
+	release = func() {
+		stopRefresh()
+		<-done
+		cancel(nil)
+		if lost {
+			return
+		}
+		if err := obj.If(storage.Conditions{GenerationMatch: gen}).Delete(context.Background()); err != nil {
+			log.Printf("Error releasing the ssupdate lock: %s", err)
+		}
+	}
+	return lockCtx, release, nil
+}
+
+// takeExpiredLock takes over the ssupdate lock if it has expired,
+// and otherwise returns an updateLockedError.
+func takeExpiredLock(ctx context.Context, obj *storage.ObjectHandle, write func(storage.Conditions) (int64, error)) (int64, error) {
+	r, err := obj.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		// Released meanwhile.
+		return write(storage.Conditions{DoesNotExist: true})
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "reading %s", updateLockObjName)
+	}
+	defer r.Close()
+
+	var lock updateLock
+	if err := json.NewDecoder(r).Decode(&lock); err != nil {
+		return 0, errors.Wrapf(err, "parsing %s", updateLockObjName)
+	}
+	if time.Now().Before(lock.Expires) {
+		return 0, updateLockedError{holder: lock.Holder, expires: lock.Expires}
+	}
+
+	gen, err := write(storage.Conditions{GenerationMatch: r.Attrs.Generation})
+	if isPreconditionFailed(err) {
+		return 0, updateLockedError{}
+	}
+	return gen, err
+}
+
+// autoUpdate runs an ssupdate pass on src after autoUpdateDelay and then every interval,
+// until the context is canceled.
+func (s *server) autoUpdate(ctx context.Context, src writableSource, interval time.Duration) {
+	transport, err := newOutboundTransport("", "")
+	if err != nil {
+		log.Printf("Error in scheduled ssupdate: %s", err)
+		return
+	}
+	omdbKey := os.Getenv("OMDB_API_KEY")
+	logRedactor.addSecret(omdbKey)
+
+	timer := time.NewTimer(autoUpdateDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		lockCtx, release, err := acquireUpdateLock(ctx, s.bucket)
+		if errors.As(err, new(updateLockedError)) {
+			log.Printf("Skipping scheduled ssupdate: %s", err)
+		} else if err != nil {
+			log.Printf("Error locking for scheduled ssupdate: %s", err)
+		} else {
+			log.Print("Starting scheduled ssupdate")
+			summary, err := updateSpreadsheet(lockCtx, src, s.bucket, "", "imdb", omdbKey, transport, defaultScrapeInterval, false, false, false, 0)
+			if err != nil && errors.Is(context.Cause(lockCtx), errUpdateLockLost) {
+				err = errUpdateLockLost
+			}
+			release()
+
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil:
+				log.Printf("Error in scheduled ssupdate: %s", err)
+			default:
+				log.Printf("Finished scheduled ssupdate: filled in %d of %d row(s)", summary.updated, summary.rows)
+			}
+			if summary.updated > 0 {
+				// Reload the metadata on the next request that needs it.
+				s.mu.Lock()
+				s.infoMapTime = time.Time{}
+				s.mu.Unlock()
+			}
+			if len(s.webhooks) > 0 && (summary.updated > 0 || err != nil) {
+				sendWebhooks(ctx, s.webhooks, ssupdateEvent(s.bucketName, summary, err))
+			}
+		}
+
+		timer.Reset(interval)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+func TestUpdateLock(t *testing.T) {
+	// A fake GCS holding just the lock object.
+	var (
+		mu      sync.Mutex
+		content []byte
+		gen     int64
+	)
+	precondition := func(req *http.Request) bool {
+		if s := req.URL.Query().Get("ifGenerationMatch"); s != "" {
+			want, _ := strconv.ParseInt(s, 10, 64)
+			return want == gen
+		}
+		return true
+	}
+	gcs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case req.Method == "POST" && strings.HasPrefix(req.URL.Path, "/upload/"):
+			if !precondition(req) {
+				http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+				return
+			}
+			_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mr := multipart.NewReader(req.Body, params["boundary"])
+			var parts [][]byte
+			for {
+				p, err := mr.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				b, _ := io.ReadAll(p)
+				parts = append(parts, b)
+			}
+			content, gen = parts[1], gen+1
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"bucket": "media", "name": updateLockObjName, "generation": strconv.FormatInt(gen, 10)})
+
+		case req.Method == "DELETE":
+			if gen == 0 || !precondition(req) {
+				http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+				return
+			}
+			content, gen = nil, 0
+			w.WriteHeader(http.StatusNoContent)
+
+		case req.Method == "GET" && gen > 0:
+			w.Header().Set("X-Goog-Generation", strconv.FormatInt(gen, 10))
+			w.Write(content)
+
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer gcs.Close()
+
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(gcs.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	bucket := client.Bucket("media")
+
+	_, release, err := acquireUpdateLock(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Held.
+	_, _, err = acquireUpdateLock(ctx, bucket)
+	var locked updateLockedError
+	if !errors.As(err, &locked) || locked.holder == "" {
+		t.Fatalf("got error %v taking a held lock", err)
+	}
+
+	release()
+	mu.Lock()
+	if gen != 0 {
+		t.Fatal("lock not released")
+	}
+
+	// Expired.
+	content, _ = json.Marshal(updateLock{Holder: "elsewhere:1", Expires: time.Now().Add(-time.Minute)})
+	gen = 7
+	mu.Unlock()
+
+	_, release, err = acquireUpdateLock(ctx, bucket)
+	if err != nil {
+		t.Fatalf("got error %v taking an expired lock", err)
+	}
+	release()
+
+	// Lost to another run, which the refresh discovers.
+	lockCtx, release, err := lockUpdates(ctx, bucket, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	content, _ = json.Marshal(updateLock{Holder: "elsewhere:2", Expires: time.Now().Add(updateLockTTL)})
+	gen += 10
+	taken := gen
+	mu.Unlock()
+
+	select {
+	case <-lockCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("run not canceled after losing the lock")
+	}
+	if cause := context.Cause(lockCtx); !errors.Is(cause, errUpdateLockLost) {
+		t.Errorf("got cause %v", cause)
+	}
+	release()
+	mu.Lock()
+	defer mu.Unlock()
+	if gen != taken {
+		t.Error("released another run's lock")
+	}
+}
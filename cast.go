@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// Content types of the media objects that a Chromecast can play directly.
+// Others (such as .iso) would need transcoding first.
+var castTypes = map[string]string{
+	".m4v": "video/mp4",
+	".mp4": "video/mp4",
+}
+
+// How long the share link given to a Chromecast remains valid.
+const castTTL = 12 * time.Hour
+
+// castManifest tells the Cast sender what to load on the receiver.
+type castManifest struct {
+	ContentURL  string `json:"contentUrl"`
+	ContentType string `json:"contentType"`
+	Title       string `json:"title"`
+	Year        int    `json:"year,omitempty"`
+	Poster      string `json:"poster,omitempty"`
+}
+
+// handleCast serves a page for casting a title to a Chromecast,
+// at /cast/ROOTNAME.
+// The Chromecast fetches the media itself, without the user's credentials,
+// so the page gives it a short-lived share link.
+func (s *server) handleCast(w http.ResponseWriter, req *http.Request) error {
+	rootName := strings.TrimPrefix(strings.Trim(req.URL.Path, "/"), "cast/")
+
+	ctx := req.Context()
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	var (
+		objName string
+		info    = s.infoMap[rootName]
+	)
+	// Prefer a version of the title that can be cast.
+	for ext := range castTypes {
+		if s.objNames.Has(rootName + ext) {
+			objName = rootName + ext
+		}
+	}
+	if objName == "" {
+		objName, _ = s.mediaObjName(rootName)
+	}
+	s.mu.RUnlock()
+
+	if objName == "" || info.hidden {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no title %s", rootName),
+		}
+	}
+	if info.Title == "" {
+		info.Title = rootName
+	}
+
+	data := struct {
+		Title    string
+		Manifest *castManifest
+	}{
+		Title: info.Title,
+	}
+
+	if contentType, ok := castTypes[filepath.Ext(objName)]; ok {
+		secret, _, err := s.grants.issue(grantShare, "cast:"+principal(ctx), rootName, castTTL)
+		if err != nil {
+			return errors.Wrap(err, "issuing share link")
+		}
+
+		base := s.baseURL(req)
+		share := url.Values{"share": {secret}}.Encode()
+
+		data.Manifest = &castManifest{
+			ContentURL:  base.JoinPath(rootNamePrefix(rootName)+objName).String() + "?" + share,
+			ContentType: contentType,
+			Title:       info.Title,
+			Year:        info.Year,
+		}
+		if len(info.Thumbs) > 0 {
+			data.Manifest.Poster = base.JoinPath("thumbs", rootName+filepath.Ext(info.Thumbs[0].origVal)).String() + "?" + share
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return castTemplate.Execute(w, data)
+}
+
+var castTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+ <head>
+  <title>Cast {{ .Title }}</title>
+  {{ if .Manifest }}
+   <script>
+    const manifest = {{ .Manifest }};
+
+    window.__onGCastApiAvailable = function(isAvailable) {
+      if (!isAvailable) {
+        return;
+      }
+      const ctx = cast.framework.CastContext.getInstance();
+      ctx.setOptions({
+        receiverApplicationId: chrome.cast.media.DEFAULT_MEDIA_RECEIVER_APP_ID,
+        autoJoinPolicy: chrome.cast.AutoJoinPolicy.ORIGIN_SCOPED,
+      });
+      ctx.addEventListener(cast.framework.CastContextEventType.SESSION_STATE_CHANGED, function(event) {
+        switch (event.sessionState) {
+          case cast.framework.SessionState.SESSION_STARTED:
+          case cast.framework.SessionState.SESSION_RESUMED:
+            load(ctx.getCurrentSession());
+        }
+      });
+    };
+
+    function load(session) {
+      const info = new chrome.cast.media.MediaInfo(manifest.contentUrl, manifest.contentType);
+      info.metadata = new chrome.cast.media.MovieMediaMetadata();
+      info.metadata.title = manifest.title;
+      if (manifest.year) {
+        info.metadata.releaseDate = String(manifest.year);
+      }
+      if (manifest.poster) {
+        info.metadata.images = [new chrome.cast.Image(manifest.poster)];
+      }
+      session.loadMedia(new chrome.cast.media.LoadRequest(info)).then(
+        function() { document.getElementById("status").textContent = "Playing on " + session.getCastDevice().friendlyName; },
+        function(err) { document.getElementById("status").textContent = "Error: " + err; },
+      );
+    }
+   </script>
+   <script src="https://www.gstatic.com/cv/js/sender/v1/cast_sender.js?loadCastFramework=1"></script>
+  {{ end }}
+ </head>
+ <body>
+  <h1>{{ .Title }}</h1>
+  {{ if .Manifest }}
+   <div style="width: 48px; height: 48px;"><google-cast-launcher></google-cast-launcher></div>
+   <p id="status">Choose a Cast device.</p>
+  {{ else }}
+   <p>This title is not in a format that a Chromecast can play.</p>
+  {{ end }}
+ </body>
+</html>
+`))
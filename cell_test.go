@@ -24,3 +24,22 @@ func TestCellName(t *testing.T) {
 		})
 	}
 }
+
+func TestColIndex(t *testing.T) {
+	for col := 0; col < 1000; col++ {
+		if got := colIndex(colName(col)); got != col {
+			t.Errorf("got %d for %s, want %d", got, colName(col), col)
+		}
+	}
+}
+
+func TestUnfilledCells(t *testing.T) {
+	var (
+		cells   = map[string]string{"title": "The Thin Man", "year": "1934", "plot": "A detective..."}
+		current = map[string]string{"title": "Thin Man", "year": "1934", "genre": "Mystery"}
+	)
+	got := unfilledCells("thin man.iso", cells, current)
+	if len(got) != 1 || got["plot"] != "A detective..." {
+		t.Errorf("got %v, want only plot", got)
+	}
+}
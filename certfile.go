@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bobg/errors"
+)
+
+// With serve -cert and -key,
+// the server presents the certificate in the given PEM files,
+// as maintained by e.g. certbot.
+// It rereads them when they change,
+// and when the process gets SIGHUP,
+// so a renewed certificate is picked up without a restart
+// (which would interrupt streams in progress).
+
+// How often to check the certificate files for changes.
+const certFilePollInterval = time.Minute
+
+// loadCertFiles reads and installs the certificate in certFile and its key in keyFile.
+func (s *server) loadCertFiles(ctx context.Context, certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return errors.Wrapf(err, "loading %s and %s", certFile, keyFile)
+	}
+	return errors.Wrapf(s.setCert(ctx, cert), "installing certificate from %s", certFile)
+}
+
+// watchCertFiles reloads the certificate files when they change or the process gets SIGHUP,
+// until the context is canceled.
+// A failed reload
+// (as when the certificate has been replaced but not yet its key)
+// leaves the previous certificate in place
+// and is tried again on the next change or poll.
+func (s *server) watchCertFiles(ctx context.Context, certFile, keyFile string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(certFilePollInterval)
+	defer ticker.Stop()
+
+	var (
+		last   = certFilesVersion(certFile, keyFile)
+		failed bool
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hup:
+
+		case <-ticker.C:
+			v := certFilesVersion(certFile, keyFile)
+			if v == last && !failed {
+				continue
+			}
+			last = v
+		}
+
+		if err := s.loadCertFiles(ctx, certFile, keyFile); err != nil {
+			log.Printf("Error reloading certificate: %s", err)
+			failed = true
+			continue
+		}
+		failed = false
+		log.Printf("Reloaded certificate from %s", certFile)
+	}
+}
+
+// fileVersion identifies the contents of a file by its modification time and size.
+type fileVersion struct {
+	modTime, size int64
+}
+
+// certFilesVersion is the fileVersion of each of the certificate files,
+// following symlinks (as in certbot's live directory).
+func certFilesVersion(certFile, keyFile string) [2]fileVersion {
+	var result [2]fileVersion
+	for i, f := range []string{certFile, keyFile} {
+		if info, err := os.Stat(f); err == nil {
+			result[i] = fileVersion{modTime: info.ModTime().UnixNano(), size: info.Size()}
+		}
+	}
+	return result
+}
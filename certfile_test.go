@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertFiles(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		certFile = filepath.Join(dir, "fullchain.pem")
+		keyFile  = filepath.Join(dir, "privkey.pem")
+		ctx      = context.Background()
+		s        = &server{}
+	)
+
+	writeCert := func(serial int64) []byte {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "media.example.com"},
+			DNSNames:     []string{"media.example.com"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+
+	first := writeCert(1)
+	if err := s.loadCertFiles(ctx, certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.cert.Certificate[0], first) {
+		t.Fatal("first certificate not installed")
+	}
+	v := certFilesVersion(certFile, keyFile)
+
+	// A renewal that has replaced the certificate but not yet the key is rejected.
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeCert(2)
+	if err := os.WriteFile(keyFile, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.loadCertFiles(ctx, certFile, keyFile); err == nil {
+		t.Error("loaded a certificate with the wrong key")
+	}
+	if !bytes.Equal(s.cert.Certificate[0], first) {
+		t.Error("first certificate replaced by a bad one")
+	}
+
+	second := writeCert(3)
+	if certFilesVersion(certFile, keyFile) == v {
+		t.Error("version unchanged after renewal")
+	}
+	if err := s.loadCertFiles(ctx, certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.cert.Certificate[0], second) {
+		t.Error("renewed certificate not installed")
+	}
+}
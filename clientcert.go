@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// With serve -client-ca,
+// the server requires each client to present a certificate signed by one of the given CAs
+// during the TLS handshake,
+// so a device without one can't even begin an HTTP request.
+// This is in addition to any other authentication;
+// if there is none,
+// a request's principal is the common name of its client certificate.
+
+// loadClientCAs reads a file of PEM-encoded CA certificates.
+func loadClientCAs(file string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading client CA file %s", file)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no PEM-encoded certificates in %s", file)
+	}
+	return pool, nil
+}
+
+// clientCertAuth accepts requests made with a verified client certificate
+// (as all requests are when the server has client CAs),
+// authenticating them as the certificate's common name.
+type clientCertAuth struct{}
+
+func (clientCertAuth) authenticate(w http.ResponseWriter, req *http.Request) (string, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	return req.TLS.VerifiedChains[0][0].Subject.CommonName, nil
+}
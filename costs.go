@@ -0,0 +1,229 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+	"google.golang.org/api/iterator"
+)
+
+// The /stats/costs endpoint and the costs subcommand estimate what the bucket costs per month:
+// storing its objects,
+// and the egress of reading them,
+// extrapolated from the bytes read in the session summaries of the last 30 days
+// (see history.go).
+// Prices are in dollars per GiB (per month, for storage),
+// and default to those of Standard storage in a region
+// and of internet egress.
+
+const (
+	defaultEgressPrice  = 0.12
+	defaultStoragePrice = 0.02
+
+	// The history considered, and the length of a month.
+	costWindow = 30 * 24 * time.Hour
+
+	// Less history than this is extrapolated as if it were this much,
+	// so that an hour with a movie in it doesn't look like a month of them.
+	minCostPeriod = 24 * time.Hour
+
+	// The number of titles listed in a cost report.
+	costTopTitles = 20
+
+	gib = 1 << 30
+)
+
+type costPrices struct {
+	egress  float64 // dollars per GiB
+	storage float64 // dollars per GiB-month
+}
+
+// costReport is an estimate of the bucket's monthly costs.
+type costReport struct {
+	EgressPrice  float64 `json:"egress_price"`
+	StoragePrice float64 `json:"storage_price"`
+
+	StorageBytes   int64   `json:"storage_bytes"`
+	StorageMonthly float64 `json:"storage_monthly"`
+
+	// Bytes read from the bucket over the period
+	// (from the first session in the last 30 days until now),
+	// and the same extrapolated to a month.
+	EgressBytes        int64   `json:"egress_bytes"`
+	Period             string  `json:"period"`
+	EgressMonthlyBytes int64   `json:"egress_monthly_bytes"`
+	EgressMonthly      float64 `json:"egress_monthly"`
+
+	TotalMonthly float64 `json:"total_monthly"`
+
+	// The objects costing the most in egress.
+	Titles []titleCost `json:"titles,omitempty"`
+}
+
+type titleCost struct {
+	Object  string  `json:"object"`
+	Bytes   int64   `json:"bytes"`   // over the period
+	Monthly float64 `json:"monthly"` // egress cost, extrapolated
+}
+
+// estimateCosts estimates the monthly costs of a bucket of storageBytes
+// from the sessions ending in the costWindow before now.
+// A session that began before then counts in proportion to its time after.
+func estimateCosts(sessions []sessionSummary, storageBytes int64, prices costPrices, now time.Time) costReport {
+	r := costReport{
+		EgressPrice:    prices.egress,
+		StoragePrice:   prices.storage,
+		StorageBytes:   storageBytes,
+		StorageMonthly: float64(storageBytes) / gib * prices.storage,
+	}
+
+	var (
+		cutoff   = now.Add(-costWindow)
+		earliest = now
+		egress   float64
+		objBytes = make(map[string]float64)
+	)
+	for _, sess := range sessions {
+		if !sess.End.After(cutoff) {
+			continue
+		}
+		frac, start := 1.0, sess.Start
+		if start.Before(cutoff) {
+			frac = float64(sess.End.Sub(cutoff)) / float64(sess.End.Sub(start))
+			start = cutoff
+		}
+		if start.Before(earliest) {
+			earliest = start
+		}
+
+		egress += frac * float64(sess.BytesServed)
+		for obj, n := range sess.ObjectBytes {
+			objBytes[obj] += frac * float64(n)
+		}
+	}
+
+	period := max(now.Sub(earliest), minCostPeriod)
+	scale := float64(costWindow) / float64(period)
+
+	r.EgressBytes = int64(egress)
+	r.Period = now.Sub(earliest).Round(time.Minute).String()
+	r.EgressMonthlyBytes = int64(egress * scale)
+	r.EgressMonthly = egress * scale / gib * prices.egress
+	r.TotalMonthly = r.StorageMonthly + r.EgressMonthly
+
+	objs := slices.SortedFunc(maps.Keys(objBytes), func(a, b string) int {
+		return cmp.Or(cmp.Compare(objBytes[b], objBytes[a]), cmp.Compare(a, b))
+	})
+	for _, obj := range objs[:min(len(objs), costTopTitles)] {
+		r.Titles = append(r.Titles, titleCost{
+			Object:  obj,
+			Bytes:   int64(objBytes[obj]),
+			Monthly: objBytes[obj] * scale / gib * prices.egress,
+		})
+	}
+
+	return r
+}
+
+func (r costReport) write(w io.Writer) error {
+	fmt.Fprintf(w, "Storage: %.1f GiB at $%.3f per GiB-month: $%.2f/month\n", float64(r.StorageBytes)/gib, r.StoragePrice, r.StorageMonthly)
+	fmt.Fprintf(w, "Egress:  %.1f GiB in %s, or %.1f GiB/month, at $%.3f per GiB: $%.2f/month\n", float64(r.EgressBytes)/gib, r.Period, float64(r.EgressMonthlyBytes)/gib, r.EgressPrice, r.EgressMonthly)
+	fmt.Fprintf(w, "Total:   $%.2f/month\n", r.TotalMonthly)
+	if len(r.Titles) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "\nEgress by object:\n")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	for _, t := range r.Titles {
+		fmt.Fprintf(tw, "%.1f GiB\t$%.2f/month\t  %s\n", float64(t.Bytes)/gib, t.Monthly, t.Object)
+	}
+	return errors.Wrap(tw.Flush(), "writing objects")
+}
+
+// parseCostPrices gets the prices from the egress and storage query parameters,
+// with defaults.
+func parseCostPrices(q url.Values) (costPrices, error) {
+	prices := costPrices{egress: defaultEgressPrice, storage: defaultStoragePrice}
+	for name, p := range map[string]*float64{"egress": &prices.egress, "storage": &prices.storage} {
+		if !q.Has(name) {
+			continue
+		}
+		v, err := strconv.ParseFloat(q.Get(name), 64)
+		if err != nil || v < 0 {
+			return prices, mid.CodeErr{
+				C:   http.StatusBadRequest,
+				Err: fmt.Errorf("bad %s price %q", name, q.Get(name)),
+			}
+		}
+		*p = v
+	}
+	return prices, nil
+}
+
+// handleCosts serves /stats/costs,
+// counting the current session as well as those in the history.
+func (s *server) handleCosts(w http.ResponseWriter, req *http.Request) error {
+	prices, err := parseCostPrices(req.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	ctx := req.Context()
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	sessions, err := readHistory(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+	sessions = append(sessions, s.sessionSummary())
+
+	s.mu.RLock()
+	storageBytes := s.storageBytes
+	s.mu.RUnlock()
+
+	return mid.RespondJSON(w, estimateCosts(sessions, storageBytes, prices, time.Now()))
+}
+
+func (c maincmd) costs(ctx context.Context, egressPrice, storagePrice float64, asJSON bool, _ []string) error {
+	sessions, err := readHistory(ctx, c.bucket)
+	if err != nil {
+		return err
+	}
+
+	var storageBytes int64
+	it := c.bucket.Objects(ctx, &storage.Query{Projection: storage.ProjectionNoACL})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "iterating over bucket")
+		}
+		storageBytes += attrs.Size
+	}
+
+	r := estimateCosts(sessions, storageBytes, costPrices{egress: egressPrice, storage: storagePrice}, time.Now())
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return errors.Wrap(enc.Encode(r), "writing report")
+	}
+	return r.write(os.Stdout)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateCosts(t *testing.T) {
+	var (
+		now = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		day = 24 * time.Hour
+	)
+	sessions := []sessionSummary{{
+		// Too old.
+		Start:       now.Add(-60 * day),
+		End:         now.Add(-40 * day),
+		BytesServed: 100 * gib,
+	}, {
+		// Half of it in the last 30 days.
+		Start:       now.Add(-32 * day),
+		End:         now.Add(-28 * day),
+		BytesServed: 4 * gib,
+		ObjectBytes: map[string]int64{"Top Hat.mkv": 4 * gib},
+	}, {
+		Start:       now.Add(-10 * day),
+		End:         now,
+		BytesServed: 8 * gib,
+		ObjectBytes: map[string]int64{"Swing Time.mkv": 3 * gib, "Top Hat.mkv": 5 * gib},
+	}}
+
+	r := estimateCosts(sessions, 500*gib, costPrices{egress: 0.1, storage: 0.02}, now)
+
+	near := func(a, b float64) bool { return math.Abs(a-b) < 0.001 }
+	if r.EgressBytes != 10*gib {
+		t.Errorf("got egress %d bytes, want %d", r.EgressBytes, 10*gib)
+	}
+	if !near(r.EgressMonthly, 1.0) || !near(r.StorageMonthly, 10.0) || !near(r.TotalMonthly, 11.0) {
+		t.Errorf("got egress $%f, storage $%f, total $%f per month", r.EgressMonthly, r.StorageMonthly, r.TotalMonthly)
+	}
+	if len(r.Titles) != 2 || r.Titles[0].Object != "Top Hat.mkv" || r.Titles[0].Bytes != 7*gib {
+		t.Errorf("got titles %+v", r.Titles)
+	}
+
+	// One short session is extrapolated from a whole day.
+	r = estimateCosts([]sessionSummary{{Start: now.Add(-time.Hour), End: now, BytesServed: gib}}, 0, costPrices{egress: 0.1}, now)
+	if !near(r.EgressMonthly, 3.0) {
+		t.Errorf("got egress $%f per month from an hour's session, want $3", r.EgressMonthly)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := r.write(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Total:   $3.00/month") {
+		t.Errorf("got report:\n%s", buf)
+	}
+
+	if _, err := parseCostPrices(url.Values{"egress": {"cheap"}}); err == nil {
+		t.Error("no error for a bad price")
+	}
+}
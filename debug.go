@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// With serve -debug-listen,
+// the server also listens at a second, loopback-only address
+// for the Go profiler (net/http/pprof, under /debug/pprof/),
+// the runtime variables (under /debug/vars),
+// the certificate report (/debug/tls),
+// and the operating statistics (/stats and /stats/costs),
+// without authentication,
+// so that it can be profiled (e.g. with go tool pprof)
+// from the same host, or through an SSH tunnel or kubectl port-forward,
+// without exposing the profiler on the main listener.
+
+// checkDebugAddr checks that addr is a loopback address.
+func checkDebugAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s", addr)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%s is not a loopback address (such as localhost:6060)", addr)
+}
+
+// debugHandler serves the debug endpoints.
+// None of them is on the main listener.
+func (s *server) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", mid.Err(s.handleVars))
+	mux.Handle("/debug/tls", mid.Err(s.handleTLS))
+	mux.Handle("/stats", mid.Err(s.handleStats))
+	mux.Handle("/stats/costs", mid.Err(s.handleCosts))
+	return mux
+}
+
+// runDebug serves the debug listener at addr until the context is canceled.
+func (s *server) runDebug(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s.debugHandler(),
+	}
+
+	ln, err := s.listen(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Serving debug endpoints on %s", addr)
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(srv.Shutdown(context.WithoutCancel(ctx)), "in Shutdown")
+	case err := <-errCh:
+		return errors.Wrap(err, "in Serve")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckDebugAddr(t *testing.T) {
+	cases := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{addr: "localhost:6060"},
+		{addr: "127.0.0.1:6060"},
+		{addr: "[::1]:6060"},
+		{addr: ":6060", wantErr: true},
+		{addr: "0.0.0.0:6060", wantErr: true},
+		{addr: "192.168.1.10:6060", wantErr: true},
+		{addr: "localhost", wantErr: true},
+	}
+	for _, tc := range cases {
+		err := checkDebugAddr(tc.addr)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("checkDebugAddr(%q) = %v, want error %v", tc.addr, err, tc.wantErr)
+		}
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	h := (&server{}).debugHandler()
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/heap?debug=1", "/debug/vars"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("got status %d for %s", rec.Code, path)
+		}
+	}
+	for _, path := range []string{"/debug/tls", "/stats", "/stats/costs"} {
+		if _, pattern := h.(*http.ServeMux).Handler(httptest.NewRequest("GET", path, nil)); pattern != path {
+			t.Errorf("%s not served on the debug listener (pattern %s)", path, pattern)
+		}
+	}
+}
+
+func TestDebugNotPublic(t *testing.T) {
+	mux := (&server{}).publicMux()
+	for _, path := range []string{"/debug/vars", "/debug/pprof/", "/debug/tls", "/stats", "/stats/costs"} {
+		// Falls through to the catch-all handler for titles.
+		if _, pattern := mux.Handler(httptest.NewRequest("GET", path, nil)); pattern != "/" {
+			t.Errorf("%s is served on the main listener (pattern %s)", path, pattern)
+		}
+	}
+}
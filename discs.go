@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+// A title may be stored not as a single object (such as an ISO image)
+// but as the folder structure of a disc,
+// objects named ROOT/BDMV/... (for a Blu-ray)
+// or ROOT/VIDEO_TS/... (for a DVD).
+// Such a title is listed as one folder,
+// named PREFIX-ROOT/ (with the title's hash prefix),
+// holding the disc's folder,
+// whose objects are served at stable paths beneath it,
+// and a movie.nfo file,
+// which is where Kodi looks for the .nfo file of a disc.
+// Kodi plays the whole folder as one title.
+// The disc's objects themselves are not listed as titles.
+//
+// Such folders are recognized only at the top level of the bucket,
+// and only if they have the disc's index file,
+// BDMV/index.bdmv or VIDEO_TS/VIDEO_TS.IFO.
+
+// The folders of disc structures and the index file within each.
+var discIndexes = map[string]string{
+	"BDMV":     "index.bdmv",
+	"VIDEO_TS": "VIDEO_TS.IFO",
+}
+
+// The name of the .nfo file in a disc title's folder.
+const discNFOName = "movie.nfo"
+
+// discObjName returns the name of the index object of the disc stored under rootName/,
+// if there is one.
+// The caller must hold s.mu.
+func (s *server) discObjName(rootName string) (string, bool) {
+	if rootName == "" || strings.Contains(rootName, "/") {
+		return "", false
+	}
+	for dir, index := range discIndexes {
+		if objName := rootName + "/" + dir + "/" + index; s.objNames.Has(objName) {
+			return objName, true
+		}
+	}
+	return "", false
+}
+
+// discTitles returns the root names of the titles stored as disc structures.
+// The caller must hold s.mu.
+func (s *server) discTitles() []string {
+	roots := set.New[string]()
+	for dir, index := range discIndexes {
+		suffix := "/" + dir + "/" + index
+		for objName := range s.objNames {
+			if rootName, ok := strings.CutSuffix(objName, suffix); ok && rootName != "" && !strings.Contains(rootName, "/") {
+				roots.Add(rootName)
+			}
+		}
+	}
+	result := roots.Slice()
+	sort.Strings(result)
+	return result
+}
+
+// isDiscObj tells whether the named object is part of a disc structure.
+// The caller must hold s.mu.
+func (s *server) isDiscObj(objName string) bool {
+	rootName, rest, ok := strings.Cut(objName, "/")
+	if !ok {
+		return false
+	}
+	dir, _, _ := strings.Cut(rest, "/")
+	if _, ok := discIndexes[dir]; !ok {
+		return false
+	}
+	_, ok = s.discObjName(rootName)
+	return ok
+}
+
+// parseDiscPath parses a path in a disc title's folder,
+// [SUBDIR/]PREFIX-ROOT[/REST],
+// returning the title's root name and REST
+// (which is empty for the folder itself).
+// REST must be movie.nfo or lie within the disc's folder.
+func parseDiscPath(p string) (rootName, rest string, ok bool) {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if len(part) <= 8 || part[:8] != rootNamePrefix(part[8:]) {
+			continue
+		}
+		rest := strings.Join(parts[i+1:], "/")
+		if rest == "" || rest == discNFOName {
+			return part[8:], rest, true
+		}
+		if _, ok := discIndexes[parts[i+1]]; ok {
+			return part[8:], rest, true
+		}
+	}
+	return "", "", false
+}
+
+// handleDisc serves a disc title's folder,
+// or (if rest is not empty) something in it:
+// its .nfo file,
+// or a folder or object of the disc.
+func (s *server) handleDisc(ctx context.Context, w http.ResponseWriter, req *http.Request, rootName, rest string) error {
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+
+	indexObj, ok := s.discObjName(rootName)
+	if !ok || s.infoMap[rootName].hidden {
+		s.mu.RUnlock()
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no disc title %s", rootName),
+		}
+	}
+	if err := s.titleAccessErr(ctx, rootName); err != nil {
+		s.mu.RUnlock()
+		return err
+	}
+
+	switch rest {
+	case "":
+		defer s.mu.RUnlock()
+		discDir := path.Dir(strings.TrimPrefix(indexObj, rootName+"/"))
+		return s.writeObjDir(w, req, rootName, []template.URL{discNFOName, template.URL(discDir + "/")})
+
+	case discNFOName:
+		s.mu.RUnlock()
+		if isAPIDir(req) {
+			return mid.CodeErr{C: http.StatusNotFound, Err: fmt.Errorf("%s is not a directory", req.URL.Path)}
+		}
+		return s.handleNFO(w, req, rootName+".nfo")
+	}
+
+	objName := rootName + "/" + rest
+	if s.objNames.Has(objName) && isAPIDir(req) {
+		s.mu.RUnlock()
+		return mid.CodeErr{C: http.StatusNotFound, Err: fmt.Errorf("%s is not a directory", req.URL.Path)}
+	}
+	if !s.objNames.Has(objName) {
+		defer s.mu.RUnlock()
+		items := discDirItems(s.objNames, objName+"/")
+		if len(items) == 0 {
+			return mid.CodeErr{
+				C:   http.StatusNotFound,
+				Err: fmt.Errorf("no %s in disc title %s", rest, rootName),
+			}
+		}
+		return s.writeObjDir(w, req, objName, items)
+	}
+	if req.Method == "PROPFIND" {
+		defer s.mu.RUnlock()
+		return writeMultistatus(w, davMultistatus{
+			Responses: []davResponse{s.davFileResponse(req.URL.EscapedPath(), path.Base(objName), objName)},
+		})
+	}
+	s.mu.RUnlock()
+
+	s.auditStream(req, objName)
+	err := s.serveObj(ctx, w, req, objName, req.URL.Path, s.isVerbose())
+	if isStreamFailure(err) {
+		s.health.streamFailures.Add(1)
+	}
+	return errors.Wrap(err, "serving disc object")
+}
+
+// escapeItem escapes a directory item for use in a URL path,
+// keeping the slash at the end of a folder.
+func escapeItem(item string) string {
+	if dir, ok := strings.CutSuffix(item, "/"); ok {
+		return url.PathEscape(dir) + "/"
+	}
+	return url.PathEscape(item)
+}
+
+// discDirItems lists the folder of a disc structure whose objects are named with the given prefix:
+// the objects directly inside it
+// and (ending in /) the folders inside it,
+// in order.
+func discDirItems(objNames set.Of[string], prefix string) []template.URL {
+	var (
+		files = set.New[string]()
+		dirs  = set.New[string]()
+	)
+	for objName := range objNames {
+		name, ok := strings.CutPrefix(objName, prefix)
+		if !ok || name == "" {
+			continue
+		}
+		if dir, _, isDir := strings.Cut(name, "/"); isDir {
+			dirs.Add(dir + "/")
+		} else {
+			files.Add(name)
+		}
+	}
+	names := append(dirs.Slice(), files.Slice()...)
+	sort.Strings(names)
+	items := make([]template.URL, 0, len(names))
+	for _, name := range names {
+		items = append(items, template.URL(name))
+	}
+	return items
+}
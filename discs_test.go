@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestDiscs(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		dirTemplate: template.Must(template.New("").Parse(dirTemplate)),
+		objNames: set.New(
+			"Top Hat.mp4",
+			"Metropolis/BDMV/index.bdmv",
+			"Metropolis/BDMV/MovieObject.bdmv",
+			"Metropolis/BDMV/STREAM/00000.m2ts",
+			"Metropolis/BDMV/STREAM/00001.m2ts",
+			"Metropolis/CERTIFICATE/id.bdmv",
+			"Casablanca/VIDEO_TS/VIDEO_TS.IFO",
+			"Casablanca/VIDEO_TS/VTS_01_1.VOB",
+		),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"Metropolis": {Title: "Metropolis", Year: 1927},
+		},
+		infoMapTime: now,
+	}
+
+	dir := func(p string) []string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := s.handleAPIDir(rec, httptest.NewRequest("GET", "/api/dir/"+p, nil)); err != nil {
+			t.Fatalf("%s: %s", p, err)
+		}
+		var got apiDir
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range got.Entries {
+			names = append(names, e.Name)
+		}
+		return names
+	}
+	check := func(p string, want ...string) {
+		t.Helper()
+		if got := dir(p); strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("%s: got %v, want %v", p, got, want)
+		}
+	}
+
+	var (
+		casablanca = rootNamePrefix("Casablanca") + "Casablanca"
+		metropolis = rootNamePrefix("Metropolis") + "Metropolis"
+		topHat     = rootNamePrefix("Top Hat")
+	)
+	check("", casablanca, metropolis, topHat+"Top Hat.mp4", topHat+"Top Hat.nfo")
+	check(metropolis+"/", "movie.nfo", "BDMV")
+	check(metropolis+"/BDMV/", "MovieObject.bdmv", "STREAM", "index.bdmv")
+	check(casablanca+"/VIDEO_TS/", "VIDEO_TS.IFO", "VTS_01_1.VOB")
+
+	rec := httptest.NewRecorder()
+	if err := s.handle(rec, httptest.NewRequest("GET", "/"+url.PathEscape(metropolis)+"/movie.nfo", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "<title>Metropolis</title>") {
+		t.Errorf("got NFO:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := s.handle(rec, httptest.NewRequest("GET", "/"+url.PathEscape(metropolis)+"/CERTIFICATE/id.bdmv", nil)); err == nil {
+		t.Error("got no error for an object outside the disc's folder")
+	}
+}
@@ -0,0 +1,861 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+// With serve -dlna ADDR,
+// the server is also a UPnP (DLNA) media server,
+// so that smart TVs and other renderers that cannot run Kodi
+// can browse and play the library.
+// It announces itself on the local network with SSDP
+// and serves a ContentDirectory over plain HTTP at ADDR.
+// Renderers cannot authenticate,
+// so nothing there requires it;
+// instead, only clients on a local network
+// (private, loopback, or link-local addresses)
+// are answered, whatever -allow-cidr says.
+// Even so, use DLNA only on a trusted network.
+//
+// The ContentDirectory is flat:
+// the root holds an item for each title,
+// and a folder for each multi-part title holding its parts.
+
+const (
+	ssdpAddr           = "239.255.255.250:1900"
+	ssdpMaxAge         = 1800 // seconds
+	ssdpNotifyInterval = 10 * time.Minute
+
+	dlnaDeviceType = "urn:schemas-upnp-org:device:MediaServer:1"
+	dlnaCDSType    = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	dlnaCMSType    = "urn:schemas-upnp-org:service:ConnectionManager:1"
+
+	dlnaServerHeader = "Linux/1.0 UPnP/1.0 kodigcs/1.0"
+)
+
+// dlnaImageExts are the extensions of posters that DLNA clients may fetch from the bucket.
+var dlnaImageExts = set.New(".jpg", ".jpeg", ".png")
+
+type dlnaServer struct {
+	s          *server
+	bucketName string
+	uuid       string
+	port       int
+}
+
+// runDLNA serves the DLNA media server at addr until ctx is canceled.
+func (s *server) runDLNA(ctx context.Context, addr, bucketName string) error {
+	ln, err := s.listen(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	d := &dlnaServer{
+		s:          s,
+		bucketName: bucketName,
+		uuid:       dlnaUUID(bucketName),
+		port:       ln.Addr().(*net.TCPAddr).Port,
+	}
+
+	mux := http.NewServeMux()
+	handle := func(pattern string, f func(http.ResponseWriter, *http.Request) error) {
+		mux.Handle(pattern, s.logged(mid.Err(s.observed(s.filtered(localOnly(s.drained(s.reported(f))))))))
+	}
+	handle("GET /dlna/device.xml", d.handleDevice)
+	handle("GET /dlna/ContentDirectory.xml", dlnaStatic(dlnaCDSDescription))
+	handle("GET /dlna/ConnectionManager.xml", dlnaStatic(dlnaCMSDescription))
+	handle("POST /dlna/control/ContentDirectory", d.handleCDS)
+	handle("POST /dlna/control/ConnectionManager", d.handleCMS)
+	handle("/dlna/media/{name...}", d.handleMedia)
+
+	h := &http.Server{Handler: mux}
+
+	errCh := make(chan error, 2)
+	go func() {
+		log.Printf("DLNA listening on %s", ln.Addr())
+		errCh <- h.Serve(ln)
+	}()
+	go func() {
+		errCh <- d.runSSDP(ctx)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(s.drain(h), "shutting down DLNA server")
+
+	case err := <-errCh:
+		h.Close()
+		return err
+	}
+}
+
+// localOnly wraps a DLNA handler so that it refuses requests from outside the local network.
+func localOnly(f func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		addr, ok := remoteAddr(req)
+		if !ok || !isLocalAddr(addr) {
+			log.Printf("Refused DLNA request from non-local address %s", req.RemoteAddr)
+			return mid.CodeErr{
+				C:   http.StatusForbidden,
+				Err: fmt.Errorf("address %s not on the local network", req.RemoteAddr),
+			}
+		}
+		return f(w, req)
+	}
+}
+
+// isLocalAddr tells whether addr is a private, loopback, or link-local address.
+func isLocalAddr(addr netip.Addr) bool {
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast()
+}
+
+// dlnaUUID is the device's UUID,
+// derived from the bucket name so that it is the same from one run to the next.
+func dlnaUUID(bucketName string) string {
+	h := sha256.Sum256([]byte("kodigcs:" + bucketName))
+	h[6] = (h[6] & 0x0f) | 0x50 // version 5-style
+	h[8] = (h[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// ssdpTargets are the search targets to which the server answers,
+// and which it announces.
+func (d *dlnaServer) ssdpTargets() []string {
+	return []string{"upnp:rootdevice", "uuid:" + d.uuid, dlnaDeviceType, dlnaCDSType, dlnaCMSType}
+}
+
+func (d *dlnaServer) usn(target string) string {
+	if target == "uuid:"+d.uuid {
+		return target
+	}
+	return "uuid:" + d.uuid + "::" + target
+}
+
+// location is the URL of the device description,
+// at the address of this host that can reach the given one.
+func (d *dlnaServer) location(remote *net.UDPAddr) (string, error) {
+	conn, err := net.DialUDP("udp4", nil, remote)
+	if err != nil {
+		return "", errors.Wrapf(err, "finding local address for %s", remote)
+	}
+	defer conn.Close()
+	ip := conn.LocalAddr().(*net.UDPAddr).IP
+	return fmt.Sprintf("http://%s/dlna/device.xml", net.JoinHostPort(ip.String(), strconv.Itoa(d.port))), nil
+}
+
+// runSSDP answers SSDP searches for the server
+// and announces it periodically,
+// until ctx is canceled.
+func (d *dlnaServer) runSSDP(ctx context.Context) error {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return errors.Wrapf(err, "resolving %s", ssdpAddr)
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return errors.Wrap(err, "joining SSDP multicast group")
+	}
+
+	go func() {
+		ticker := time.NewTicker(ssdpNotifyInterval)
+		defer ticker.Stop()
+
+		for {
+			d.notify(conn, group, "ssdp:alive")
+			select {
+			case <-ctx.Done():
+				d.notify(conn, group, "ssdp:byebye")
+				conn.Close()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	buf := make([]byte, 8192)
+	for {
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return errors.Wrap(err, "reading SSDP")
+		}
+
+		if !isLocalAddr(remote.AddrPort().Addr().Unmap()) {
+			continue
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		st := req.Header.Get("ST")
+
+		loc, err := d.location(remote)
+		if err != nil {
+			log.Printf("Error answering SSDP search: %s", err)
+			continue
+		}
+		for _, target := range d.ssdpTargets() {
+			if st != "ssdp:all" && st != target {
+				continue
+			}
+			msg := fmt.Sprintf("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=%d\r\nDATE: %s\r\nEXT:\r\nLOCATION: %s\r\nSERVER: %s\r\nST: %s\r\nUSN: %s\r\n\r\n",
+				ssdpMaxAge, time.Now().UTC().Format(http.TimeFormat), loc, dlnaServerHeader, target, d.usn(target))
+			if _, err := conn.WriteToUDP([]byte(msg), remote); err != nil {
+				log.Printf("Error answering SSDP search from %s: %s", remote, err)
+			}
+		}
+	}
+}
+
+// notify multicasts an SSDP announcement of the given kind (ssdp:alive or ssdp:byebye).
+func (d *dlnaServer) notify(conn *net.UDPConn, group *net.UDPAddr, nts string) {
+	loc, err := d.location(group)
+	if err != nil {
+		log.Printf("Error announcing DLNA server: %s", err)
+		return
+	}
+	for _, target := range d.ssdpTargets() {
+		msg := fmt.Sprintf("NOTIFY * HTTP/1.1\r\nHOST: %s\r\nCACHE-CONTROL: max-age=%d\r\nLOCATION: %s\r\nNT: %s\r\nNTS: %s\r\nSERVER: %s\r\nUSN: %s\r\n\r\n",
+			ssdpAddr, ssdpMaxAge, loc, target, nts, dlnaServerHeader, d.usn(target))
+		if _, err := conn.WriteToUDP([]byte(msg), group); err != nil {
+			log.Printf("Error announcing DLNA server: %s", err)
+			return
+		}
+	}
+}
+
+func (d *dlnaServer) handleDevice(w http.ResponseWriter, req *http.Request) error {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte("kodigcs ("+d.bucketName+")"))
+	_, err := fmt.Fprintf(w, dlnaDeviceDescription, buf.String(), d.uuid)
+	return errors.Wrap(err, "writing device description")
+}
+
+func dlnaStatic(content string) func(http.ResponseWriter, *http.Request) error {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		_, err := w.Write([]byte(content))
+		return errors.Wrap(err, "writing service description")
+	}
+}
+
+// soapAction returns the service type and action of a SOAP request,
+// from its SOAPACTION header ("urn:...:ContentDirectory:1#Browse").
+func soapAction(req *http.Request) (string, string) {
+	action := strings.Trim(req.Header.Get("SOAPACTION"), `"`)
+	serviceType, name, _ := strings.Cut(action, "#")
+	return serviceType, name
+}
+
+// writeSOAP writes a successful SOAP response to an action,
+// with the given output arguments, in order.
+func writeSOAP(w http.ResponseWriter, serviceType, action string, args ...string) error {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	fmt.Fprintf(&buf, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><u:%sResponse xmlns:u="%s">`, action, serviceType)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&buf, "<%s>", args[i])
+		xml.EscapeText(&buf, []byte(args[i+1]))
+		fmt.Fprintf(&buf, "</%s>", args[i])
+	}
+	fmt.Fprintf(&buf, "</u:%sResponse></s:Body></s:Envelope>", action)
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("EXT", "")
+	w.Header().Set("Server", dlnaServerHeader)
+	_, err := w.Write(buf.Bytes())
+	return errors.Wrap(err, "writing SOAP response")
+}
+
+// writeSOAPFault writes a UPnP error response.
+func writeSOAPFault(w http.ResponseWriter, code int, desc string) error {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	_, err := fmt.Fprintf(w, `%s<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`, xml.Header, code, desc)
+	return errors.Wrap(err, "writing SOAP fault")
+}
+
+func (d *dlnaServer) handleCDS(w http.ResponseWriter, req *http.Request) error {
+	serviceType, action := soapAction(req)
+	if serviceType != dlnaCDSType {
+		return writeSOAPFault(w, 401, "Invalid Action")
+	}
+
+	switch action {
+	case "GetSearchCapabilities":
+		return writeSOAP(w, serviceType, action, "SearchCaps", "")
+
+	case "GetSortCapabilities":
+		return writeSOAP(w, serviceType, action, "SortCaps", "")
+
+	case "GetSystemUpdateID":
+		d.s.mu.RLock()
+		id := d.s.systemUpdateID()
+		d.s.mu.RUnlock()
+		return writeSOAP(w, serviceType, action, "Id", strconv.FormatUint(uint64(id), 10))
+
+	case "Browse":
+		var env struct {
+			Browse struct {
+				ObjectID       string `xml:"ObjectID"`
+				BrowseFlag     string `xml:"BrowseFlag"`
+				StartingIndex  int    `xml:"StartingIndex"`
+				RequestedCount int    `xml:"RequestedCount"`
+			} `xml:"Body>Browse"`
+		}
+		if err := xml.NewDecoder(req.Body).Decode(&env); err != nil {
+			return writeSOAPFault(w, 402, "Invalid Args")
+		}
+		b := env.Browse
+
+		ctx := req.Context()
+		if err := d.s.ensureObjNames(ctx); err != nil {
+			return errors.Wrap(err, "getting obj names")
+		}
+		if err := d.s.ensureInfoMap(ctx); err != nil {
+			return errors.Wrap(err, "getting info map")
+		}
+
+		base := "http://" + req.Host + "/dlna/media/"
+
+		d.s.mu.RLock()
+		result, returned, total, err := d.s.dlnaBrowse(ctx, b.ObjectID, b.BrowseFlag, b.StartingIndex, b.RequestedCount, base)
+		id := d.s.systemUpdateID()
+		d.s.mu.RUnlock()
+
+		if errors.Is(err, errNoSuchObject) {
+			return writeSOAPFault(w, 701, "No such object")
+		}
+		if err != nil {
+			return writeSOAPFault(w, 402, "Invalid Args")
+		}
+		return writeSOAP(w, serviceType, action,
+			"Result", result,
+			"NumberReturned", strconv.Itoa(returned),
+			"TotalMatches", strconv.Itoa(total),
+			"UpdateID", strconv.FormatUint(uint64(id), 10),
+		)
+
+	default:
+		return writeSOAPFault(w, 401, "Invalid Action")
+	}
+}
+
+func (d *dlnaServer) handleCMS(w http.ResponseWriter, req *http.Request) error {
+	serviceType, action := soapAction(req)
+	if serviceType != dlnaCMSType {
+		return writeSOAPFault(w, 401, "Invalid Action")
+	}
+
+	switch action {
+	case "GetProtocolInfo":
+		var source []string
+		for ext := range mediaExts() {
+			source = append(source, "http-get:*:"+dlnaMIMEType(ext)+":*")
+		}
+		sort.Strings(source)
+		return writeSOAP(w, serviceType, action, "Source", strings.Join(source, ","), "Sink", "")
+
+	case "GetCurrentConnectionIDs":
+		return writeSOAP(w, serviceType, action, "ConnectionIDs", "0")
+
+	case "GetCurrentConnectionInfo":
+		return writeSOAP(w, serviceType, action,
+			"RcsID", "-1",
+			"AVTransportID", "-1",
+			"ProtocolInfo", "",
+			"PeerConnectionManager", "",
+			"PeerConnectionID", "-1",
+			"Direction", "Output",
+			"Status", "OK",
+		)
+
+	default:
+		return writeSOAPFault(w, 401, "Invalid Action")
+	}
+}
+
+// handleMedia serves a title's media object, or its poster, to a DLNA client.
+// No other objects in the bucket are available this way.
+func (d *dlnaServer) handleMedia(w http.ResponseWriter, req *http.Request) error {
+	objName := req.PathValue("name")
+
+	ctx := req.Context()
+	if err := d.s.checkHidden(ctx, objName); err != nil {
+		return err
+	}
+	if err := d.s.checkAccess(ctx, objName); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(objName)
+
+	d.s.mu.RLock()
+	ok := d.s.objNames.Has(objName)
+	if ok && !isMediaExt(ext) {
+		_, isTitle := d.s.mediaObjName(strings.TrimSuffix(objName, ext))
+		ok = dlnaImageExts.Has(strings.ToLower(ext)) && isTitle
+	}
+	d.s.mu.RUnlock()
+
+	if !ok {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no DLNA media %s", objName),
+		}
+	}
+
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	w.Header().Set("contentFeatures.dlna.org", "DLNA.ORG_OP=01;DLNA.ORG_CI=0")
+	w.Header().Set("Content-Type", dlnaMIMEType(ext))
+
+	d.s.auditStream(req, objName)
+	err := d.s.serveObj(ctx, w, req, objName, objName, d.s.isVerbose())
+	if isStreamFailure(err) {
+		d.s.health.streamFailures.Add(1)
+	}
+	return errors.Wrap(err, "serving object")
+}
+
+// dlnaMIMEType is the MIME type that DLNA clients expect for files with the given extension.
+func dlnaMIMEType(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".m2ts":
+		return "video/vnd.dlna.mpeg-tts"
+	case ".mkv":
+		return "video/x-matroska"
+	case ".iso":
+		return "application/x-iso9660-image"
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		t, _, _ = strings.Cut(t, ";")
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// systemUpdateID changes whenever the ContentDirectory might have.
+// The caller must hold s.mu.
+func (s *server) systemUpdateID() uint32 {
+	return uint32(s.loadedTime().Unix())
+}
+
+var errNoSuchObject = errors.New("no such object")
+
+type (
+	didlLite struct {
+		XMLName    xml.Name        `xml:"DIDL-Lite"`
+		XMLNS      string          `xml:"xmlns,attr"`
+		DC         string          `xml:"xmlns:dc,attr"`
+		UPnP       string          `xml:"xmlns:upnp,attr"`
+		Containers []didlContainer `xml:"container"`
+		Items      []didlItem      `xml:"item"`
+	}
+
+	didlContainer struct {
+		ID         string `xml:"id,attr"`
+		ParentID   string `xml:"parentID,attr"`
+		Restricted string `xml:"restricted,attr"`
+		ChildCount int    `xml:"childCount,attr"`
+		Title      string `xml:"dc:title"`
+		Class      string `xml:"upnp:class"`
+	}
+
+	didlItem struct {
+		ID          string  `xml:"id,attr"`
+		ParentID    string  `xml:"parentID,attr"`
+		Restricted  string  `xml:"restricted,attr"`
+		Title       string  `xml:"dc:title"`
+		Class       string  `xml:"upnp:class"`
+		Date        string  `xml:"dc:date,omitempty"`
+		Genre       string  `xml:"upnp:genre,omitempty"`
+		Description string  `xml:"dc:description,omitempty"`
+		AlbumArtURI string  `xml:"upnp:albumArtURI,omitempty"`
+		Res         didlRes `xml:"res"`
+	}
+
+	didlRes struct {
+		ProtocolInfo string `xml:"protocolInfo,attr"`
+		Size         int64  `xml:"size,attr,omitempty"`
+		URL          string `xml:",chardata"`
+	}
+)
+
+// dlnaEntry is a child of a ContentDirectory container.
+type dlnaEntry struct {
+	container *didlContainer
+	item      *didlItem
+	sortKey   string
+}
+
+// dlnaBrowse answers a ContentDirectory Browse action,
+// returning DIDL-Lite XML,
+// the number of entries in it,
+// and the total number of matching entries.
+// Object IDs are "0" for the root,
+// "p/ROOTNAME" for the folder of a multi-part title,
+// and "o/OBJNAME" for a media object.
+// Media URLs start with base.
+// The caller must hold s.mu.
+func (s *server) dlnaBrowse(ctx context.Context, objectID, flag string, start, count int, base string) (string, int, int, error) {
+	var entries []dlnaEntry
+
+	switch flag {
+	case "BrowseDirectChildren":
+		children, ok := s.dlnaChildren(ctx, objectID, base)
+		if !ok {
+			return "", 0, 0, errNoSuchObject
+		}
+		entries = children
+
+	case "BrowseMetadata":
+		entry, ok := s.dlnaMetadata(ctx, objectID, base)
+		if !ok {
+			return "", 0, 0, errNoSuchObject
+		}
+		entries = []dlnaEntry{entry}
+
+	default:
+		return "", 0, 0, fmt.Errorf("unknown browse flag %s", flag)
+	}
+
+	total := len(entries)
+	if start > 0 {
+		entries = entries[min(start, len(entries)):]
+	}
+	if count > 0 && count < len(entries) {
+		entries = entries[:count]
+	}
+
+	didl := didlLite{
+		XMLNS: "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/",
+		DC:    "http://purl.org/dc/elements/1.1/",
+		UPnP:  "urn:schemas-upnp-org:metadata-1-0/upnp/",
+	}
+	for _, e := range entries {
+		if e.container != nil {
+			didl.Containers = append(didl.Containers, *e.container)
+		} else {
+			didl.Items = append(didl.Items, *e.item)
+		}
+	}
+
+	out, err := xml.Marshal(didl)
+	if err != nil {
+		return "", 0, 0, errors.Wrap(err, "encoding DIDL-Lite")
+	}
+	return string(out), len(entries), total, nil
+}
+
+// dlnaChildren returns the entries in the given container, sorted by title,
+// and false if there is no such container.
+// The caller must hold s.mu.
+func (s *server) dlnaChildren(ctx context.Context, objectID, base string) ([]dlnaEntry, bool) {
+	var entries []dlnaEntry
+
+	if objectID == "0" {
+		parts := s.indexParts()
+		grouped := parts.grouped()
+		for objName := range s.objNames {
+			if grouped.Has(objName) || !isMediaExt(filepath.Ext(objName)) || s.isEpisode(objName) || s.isExtra(objName) || s.isDiscObj(objName) || s.hiddenObj(objName) {
+				continue
+			}
+			rootName := strings.TrimSuffix(objName, filepath.Ext(objName))
+			info := s.infoMap[rootName]
+			if !s.mayView(ctx, info) {
+				continue
+			}
+			entries = append(entries, s.dlnaItem(objName, "0", rootName, info, 0, base))
+		}
+		for rootName := range parts {
+			if info := s.infoMap[rootName]; !info.hidden && s.mayView(ctx, info) {
+				entries = append(entries, s.dlnaPartsContainer(rootName, info))
+			}
+		}
+	} else if rootName, ok := strings.CutPrefix(objectID, "p/"); ok {
+		info, ok := s.infoMap[rootName]
+		if !ok || info.parts == "" || info.hidden || !s.mayView(ctx, info) {
+			return nil, false
+		}
+		parts := s.partsOf(info)
+		if len(parts) == 0 {
+			return nil, false
+		}
+		for i, part := range parts {
+			e := s.dlnaItem(part, objectID, rootName, info, i+1, base)
+			e.sortKey = fmt.Sprintf("%06d", i)
+			entries = append(entries, e)
+		}
+	} else {
+		return nil, false
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].sortKey < entries[j].sortKey })
+	return entries, true
+}
+
+// dlnaMetadata returns the entry for the given object ID itself,
+// and false if there is no such object.
+// The caller must hold s.mu.
+func (s *server) dlnaMetadata(ctx context.Context, objectID, base string) (dlnaEntry, bool) {
+	if objectID == "0" {
+		children, _ := s.dlnaChildren(ctx, "0", base)
+		return dlnaEntry{container: &didlContainer{
+			ID:         "0",
+			ParentID:   "-1",
+			Restricted: "1",
+			ChildCount: len(children),
+			Title:      "kodigcs",
+			Class:      "object.container.storageFolder",
+		}}, true
+	}
+	if rootName, ok := strings.CutPrefix(objectID, "p/"); ok {
+		info, ok := s.infoMap[rootName]
+		if !ok || info.parts == "" || info.hidden || !s.mayView(ctx, info) {
+			return dlnaEntry{}, false
+		}
+		return s.dlnaPartsContainer(rootName, info), true
+	}
+	if objName, ok := strings.CutPrefix(objectID, "o/"); ok {
+		if !s.objNames.Has(objName) || !isMediaExt(filepath.Ext(objName)) || s.hiddenObj(objName) {
+			return dlnaEntry{}, false
+		}
+		for rootName, info := range s.infoMap {
+			if i := slices.Index(s.partsOf(info), objName); i >= 0 {
+				if !s.mayView(ctx, info) {
+					return dlnaEntry{}, false
+				}
+				return s.dlnaItem(objName, "p/"+rootName, rootName, info, i+1, base), true
+			}
+		}
+		rootName := strings.TrimSuffix(objName, filepath.Ext(objName))
+		info := s.infoMap[rootName]
+		if !s.mayView(ctx, info) {
+			return dlnaEntry{}, false
+		}
+		return s.dlnaItem(objName, "0", rootName, info, 0, base), true
+	}
+	return dlnaEntry{}, false
+}
+
+// dlnaPartsContainer returns the folder entry for a multi-part title.
+// The caller must hold s.mu.
+func (s *server) dlnaPartsContainer(rootName string, info movieInfo) dlnaEntry {
+	title := displayTitle(rootName, info)
+	return dlnaEntry{
+		container: &didlContainer{
+			ID:         "p/" + rootName,
+			ParentID:   "0",
+			Restricted: "1",
+			ChildCount: len(s.partsOf(info)),
+			Title:      title,
+			Class:      "object.container.storageFolder",
+		},
+		sortKey: titleSortKey(title, info),
+	}
+}
+
+// dlnaItem returns the item entry for a media object
+// of the title with the given root name and info.
+// For a part of a multi-part title,
+// partNum is its (one-based) position among the parts.
+// The caller must hold s.mu.
+func (s *server) dlnaItem(objName, parentID, rootName string, info movieInfo, partNum int, base string) dlnaEntry {
+	ext := filepath.Ext(objName)
+
+	title := displayTitle(rootName, info)
+	if partNum > 0 {
+		title = fmt.Sprintf("%s (%d)", title, partNum)
+	}
+
+	item := &didlItem{
+		ID:          "o/" + objName,
+		ParentID:    parentID,
+		Restricted:  "1",
+		Title:       title,
+		Class:       "object.item.videoItem.movie",
+		Genre:       info.Genre,
+		Description: info.Plot,
+		Res: didlRes{
+			ProtocolInfo: "http-get:*:" + dlnaMIMEType(ext) + ":DLNA.ORG_OP=01;DLNA.ORG_CI=0",
+			Size:         s.objSize[objName],
+			URL:          base + url.PathEscape(objName),
+		},
+	}
+	if info.Premiered != "" {
+		item.Date = info.Premiered
+	} else if info.Year > 0 {
+		item.Date = fmt.Sprintf("%04d-01-01", info.Year)
+	}
+	if len(info.Thumbs) > 0 {
+		orig := info.Thumbs[0].origVal
+		if thumbName := rootName + filepath.Ext(orig); s.objNames.Has(thumbName) {
+			item.AlbumArtURI = base + url.PathEscape(thumbName)
+		} else {
+			item.AlbumArtURI = orig
+		}
+	}
+
+	return dlnaEntry{item: item, sortKey: titleSortKey(title, info)}
+}
+
+const dlnaDeviceDescription = `<?xml version="1.0" encoding="utf-8"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:MediaServer:1</deviceType>
+    <friendlyName>%s</friendlyName>
+    <manufacturer>kodigcs</manufacturer>
+    <manufacturerURL>https://github.com/bobg/kodigcs</manufacturerURL>
+    <modelName>kodigcs</modelName>
+    <UDN>uuid:%s</UDN>
+    <serviceList>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:ContentDirectory:1</serviceType>
+        <serviceId>urn:upnp-org:serviceId:ContentDirectory</serviceId>
+        <SCPDURL>/dlna/ContentDirectory.xml</SCPDURL>
+        <controlURL>/dlna/control/ContentDirectory</controlURL>
+        <eventSubURL>/dlna/event/ContentDirectory</eventSubURL>
+      </service>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:ConnectionManager:1</serviceType>
+        <serviceId>urn:upnp-org:serviceId:ConnectionManager</serviceId>
+        <SCPDURL>/dlna/ConnectionManager.xml</SCPDURL>
+        <controlURL>/dlna/control/ConnectionManager</controlURL>
+        <eventSubURL>/dlna/event/ConnectionManager</eventSubURL>
+      </service>
+    </serviceList>
+  </device>
+</root>
+`
+
+const dlnaCDSDescription = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action>
+      <name>Browse</name>
+      <argumentList>
+        <argument><name>ObjectID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ObjectID</relatedStateVariable></argument>
+        <argument><name>BrowseFlag</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_BrowseFlag</relatedStateVariable></argument>
+        <argument><name>Filter</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Filter</relatedStateVariable></argument>
+        <argument><name>StartingIndex</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Index</relatedStateVariable></argument>
+        <argument><name>RequestedCount</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+        <argument><name>SortCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SortCriteria</relatedStateVariable></argument>
+        <argument><name>Result</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable></argument>
+        <argument><name>NumberReturned</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+        <argument><name>TotalMatches</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+        <argument><name>UpdateID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_UpdateID</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetSearchCapabilities</name>
+      <argumentList>
+        <argument><name>SearchCaps</name><direction>out</direction><relatedStateVariable>SearchCapabilities</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetSortCapabilities</name>
+      <argumentList>
+        <argument><name>SortCaps</name><direction>out</direction><relatedStateVariable>SortCapabilities</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetSystemUpdateID</name>
+      <argumentList>
+        <argument><name>Id</name><direction>out</direction><relatedStateVariable>SystemUpdateID</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ObjectID</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Result</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_BrowseFlag</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>BrowseMetadata</allowedValue><allowedValue>BrowseDirectChildren</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Filter</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_SortCriteria</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Index</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Count</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_UpdateID</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SearchCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SortCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SystemUpdateID</name><dataType>ui4</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>
+`
+
+const dlnaCMSDescription = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action>
+      <name>GetProtocolInfo</name>
+      <argumentList>
+        <argument><name>Source</name><direction>out</direction><relatedStateVariable>SourceProtocolInfo</relatedStateVariable></argument>
+        <argument><name>Sink</name><direction>out</direction><relatedStateVariable>SinkProtocolInfo</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetCurrentConnectionIDs</name>
+      <argumentList>
+        <argument><name>ConnectionIDs</name><direction>out</direction><relatedStateVariable>CurrentConnectionIDs</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetCurrentConnectionInfo</name>
+      <argumentList>
+        <argument><name>ConnectionID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable></argument>
+        <argument><name>RcsID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_RcsID</relatedStateVariable></argument>
+        <argument><name>AVTransportID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_AVTransportID</relatedStateVariable></argument>
+        <argument><name>ProtocolInfo</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ProtocolInfo</relatedStateVariable></argument>
+        <argument><name>PeerConnectionManager</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionManager</relatedStateVariable></argument>
+        <argument><name>PeerConnectionID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable></argument>
+        <argument><name>Direction</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Direction</relatedStateVariable></argument>
+        <argument><name>Status</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_ConnectionStatus</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="yes"><name>SourceProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SinkProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>CurrentConnectionIDs</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionStatus</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>OK</allowedValue><allowedValue>ContentFormatMismatch</allowedValue><allowedValue>InsufficientBandwidth</allowedValue><allowedValue>UnreliableChannel</allowedValue><allowedValue>Unknown</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionManager</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Direction</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>Input</allowedValue><allowedValue>Output</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ConnectionID</name><dataType>i4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_AVTransportID</name><dataType>i4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_RcsID</name><dataType>i4</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>
+`
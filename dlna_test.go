@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestDLNABrowse(t *testing.T) {
+	s := &server{
+		objNames: set.New(
+			"The Thin Man.iso",
+			"After the Thin Man.mp4",
+			"Retired.mp4",
+			"Box, Disc 1.iso",
+			"Box, Disc 2.iso",
+		),
+		objSize: map[string]int64{"After the Thin Man.mp4": 100},
+		infoMap: map[string]movieInfo{
+			"The Thin Man":       {Title: "The Thin Man", Year: 1934},
+			"After the Thin Man": {Title: "After the Thin Man"},
+			"Retired":            {hidden: true},
+			"Box":                {Title: "Box Set", parts: "Box, Disc *.iso"},
+		},
+	}
+	const base = "http://host:1550/dlna/media/"
+
+	type didl struct {
+		Containers []struct {
+			ID         string `xml:"id,attr"`
+			ChildCount int    `xml:"childCount,attr"`
+		} `xml:"container"`
+		Items []struct {
+			ID     string `xml:"id,attr"`
+			Parent string `xml:"parentID,attr"`
+			Title  string `xml:"title"`
+			Date   string `xml:"date"`
+			Res    string `xml:"res"`
+		} `xml:"item"`
+	}
+
+	result, returned, total, err := s.dlnaBrowse(context.Background(), "0", "BrowseDirectChildren", 0, 0, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if returned != 3 || total != 3 {
+		t.Errorf("got %d returned, %d total, want 3 and 3", returned, total)
+	}
+	var got didl
+	if err := xml.Unmarshal([]byte(result), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Containers) != 1 || got.Containers[0].ID != "p/Box" || got.Containers[0].ChildCount != 2 {
+		t.Errorf("got containers %+v, want p/Box with 2 children", got.Containers)
+	}
+	if len(got.Items) != 2 || got.Items[0].Title != "After the Thin Man" || got.Items[1].Title != "The Thin Man" {
+		t.Fatalf("got items %+v", got.Items)
+	}
+	if got.Items[0].Res != base+"After%20the%20Thin%20Man.mp4" {
+		t.Errorf("got res %s", got.Items[0].Res)
+	}
+	if got.Items[1].Date != "1934-01-01" {
+		t.Errorf("got date %s, want 1934-01-01", got.Items[1].Date)
+	}
+
+	result, _, total, err = s.dlnaBrowse(context.Background(), "0", "BrowseDirectChildren", 1, 1, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = didl{}
+	if err := xml.Unmarshal([]byte(result), &got); err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(got.Containers)+len(got.Items) != 1 {
+		t.Errorf("got %d total and %+v, want 3 total and one entry", total, got)
+	}
+
+	result, _, _, err = s.dlnaBrowse(context.Background(), "o/Box, Disc 2.iso", "BrowseMetadata", 0, 0, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = didl{}
+	if err := xml.Unmarshal([]byte(result), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Items) != 1 || got.Items[0].Parent != "p/Box" || got.Items[0].Title != "Box Set (2)" {
+		t.Errorf("got %+v, want part 2 of Box Set", got.Items)
+	}
+
+	if _, _, _, err := s.dlnaBrowse(context.Background(), "o/Retired.mp4", "BrowseMetadata", 0, 0, base); err == nil {
+		t.Error("got no error browsing a hidden title")
+	}
+}
+
+func TestLocalOnly(t *testing.T) {
+	h := localOnly(func(w http.ResponseWriter, req *http.Request) error { return nil })
+
+	cases := []struct {
+		remote string
+		want   int
+	}{
+		{remote: "192.168.1.2:5000", want: http.StatusOK},
+		{remote: "10.0.0.7:5000", want: http.StatusOK},
+		{remote: "127.0.0.1:5000", want: http.StatusOK},
+		{remote: "[::ffff:192.168.1.2]:5000", want: http.StatusOK},
+		{remote: "[fe80::1]:5000", want: http.StatusOK},
+		{remote: "[fd00::1]:5000", want: http.StatusOK},
+		{remote: "203.0.113.5:5000", want: http.StatusForbidden},
+		{remote: "[2001:db8::1]:5000", want: http.StatusForbidden},
+		{remote: "bogus", want: http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.remote, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/dlna/device.xml", nil)
+			req.RemoteAddr = c.remote
+			code := http.StatusOK
+			if err := h(httptest.NewRecorder(), req); err != nil {
+				code = errorCode(err)
+			}
+			if code != c.want {
+				t.Errorf("got status %d, want %d", code, c.want)
+			}
+		})
+	}
+}
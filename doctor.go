@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/certs"
+	"github.com/bobg/errors"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+)
+
+// The doctor subcommand checks the things that most often keep the server from working:
+// the credentials file,
+// access to the bucket,
+// access to the metadata,
+// the TLS certificate,
+// and the listen address.
+// For each problem it finds,
+// it says what to do about it.
+
+// doctorCertTimeout is how long to wait for a cert command's first certificate.
+const doctorCertTimeout = time.Minute
+
+// The permissions on the bucket that the server needs.
+var bucketPermissions = []string{
+	"storage.objects.list",
+	"storage.objects.get",
+	"storage.objects.create",
+	"storage.objects.delete",
+}
+
+// A checkup is the outcome of one of the doctor subcommand's checks.
+type checkup struct {
+	name    string
+	detail  string // what was found, if the check passed
+	err     error  // nil if the check passed
+	hint    string // what to do about err
+	skipped bool
+}
+
+func (c checkup) write(w io.Writer) {
+	switch {
+	case c.skipped:
+		fmt.Fprintf(w, "-     %s: %s\n", c.name, c.detail)
+	case c.err == nil:
+		fmt.Fprintf(w, "ok    %s: %s\n", c.name, c.detail)
+	default:
+		fmt.Fprintf(w, "FAIL  %s: %s\n", c.name, c.err)
+		if c.hint != "" {
+			fmt.Fprintf(w, "      %s\n", c.hint)
+		}
+	}
+}
+
+// serviceAccount is what the doctor subcommand needs from a credentials file.
+type serviceAccount struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	ProjectID   string `json:"project_id"`
+}
+
+// checkCredsFile checks that the credentials file exists and is a service-account key,
+// with advice if not.
+func checkCredsFile(credsFile string) (serviceAccount, error) {
+	var sa serviceAccount
+
+	content, err := os.ReadFile(credsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return sa, fmt.Errorf("credentials file %s does not exist; give -creds the path to a service-account key file (create one with gcloud iam service-accounts keys create)", credsFile)
+	}
+	if err != nil {
+		return sa, errors.Wrapf(err, "reading credentials file %s", credsFile)
+	}
+	if err := json.Unmarshal(content, &sa); err != nil {
+		return sa, fmt.Errorf("credentials file %s is not JSON (%s); give -creds the path to a service-account key file", credsFile, err)
+	}
+	if sa.Type != "service_account" || sa.ClientEmail == "" {
+		return sa, fmt.Errorf("credentials file %s is not a service-account key (its type is %q); create one with gcloud iam service-accounts keys create", credsFile, sa.Type)
+	}
+	return sa, nil
+}
+
+func (c maincmd) doctor(ctx context.Context, sheetID, listenAddr, certcmd, certFile, keyFile string, _ []string) error {
+	sa, _ := checkCredsFile(c.credsFile) // main has already checked it
+
+	checkups := []checkup{
+		checkCredentials(ctx, c.credsFile, sa),
+		checkBucket(ctx, c.bucket, c.bucketName, sa.ClientEmail),
+	}
+	checkups = append(checkups, c.checkMetadata(ctx, sheetID, sa.ClientEmail)...)
+	checkups = append(checkups, checkCert(ctx, certcmd, certFile, keyFile), checkListen(listenAddr))
+
+	var failed int
+	for _, cu := range checkups {
+		cu.write(os.Stdout)
+		if !cu.skipped && cu.err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+// checkCredentials checks that the credentials can get an access token.
+func checkCredentials(ctx context.Context, credsFile string, sa serviceAccount) checkup {
+	cu := checkup{name: "credentials"}
+
+	content, err := os.ReadFile(credsFile)
+	if err != nil {
+		cu.err = err
+		return cu
+	}
+	creds, err := google.CredentialsFromJSON(ctx, content, storage.ScopeReadWrite)
+	if err != nil {
+		cu.err = errors.Wrapf(err, "parsing %s", credsFile)
+		cu.hint = "Download a new key for the service account with gcloud iam service-accounts keys create."
+		return cu
+	}
+	if _, err := creds.TokenSource.Token(); err != nil {
+		cu.err = errors.Wrap(err, "getting an access token")
+		cu.hint = "The key may have been deleted or disabled (create a new one with gcloud iam service-accounts keys create), or this machine's clock may be wrong."
+		return cu
+	}
+
+	cu.detail = "service account " + sa.ClientEmail
+	if sa.ProjectID != "" {
+		cu.detail += " in project " + sa.ProjectID
+	}
+	return cu
+}
+
+// checkBucket checks that the service account has the permissions it needs on the bucket.
+func checkBucket(ctx context.Context, bucket *storage.BucketHandle, bucketName, email string) checkup {
+	cu := checkup{name: "bucket"}
+	grant := fmt.Sprintf("Grant the service account access with: gcloud storage buckets add-iam-policy-binding gs://%s --member=serviceAccount:%s --role=roles/storage.objectAdmin", bucketName, email)
+
+	granted, err := bucket.IAM().TestPermissions(ctx, bucketPermissions)
+	if err != nil {
+		cu.err = errors.Wrapf(err, "checking access to gs://%s", bucketName)
+		switch googleErrCode(err) {
+		case http.StatusNotFound:
+			cu.hint = fmt.Sprintf("There is no bucket named %s; check -bucket.", bucketName)
+		case http.StatusForbidden:
+			cu.hint = grant
+		}
+		return cu
+	}
+
+	var missing []string
+	for _, p := range bucketPermissions {
+		if !slices.Contains(granted, p) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		cu.err = fmt.Errorf("missing permissions on gs://%s: %s", bucketName, strings.Join(missing, ", "))
+		cu.hint = grant
+		return cu
+	}
+
+	cu.detail = fmt.Sprintf("read and write access to gs://%s", bucketName)
+	return cu
+}
+
+// checkMetadata checks that the metadata can be read
+// and, if it's a Google spreadsheet, written.
+func (c maincmd) checkMetadata(ctx context.Context, sheetID, email string) []checkup {
+	cu := checkup{name: "metadata"}
+	if sheetID == "" {
+		cu.skipped, cu.detail = true, "no -sheet given"
+		return []checkup{cu}
+	}
+
+	meta, err := newMetadataSource(sheetID, c.ssvc, c.dsvc, c.gcs)
+	if err != nil {
+		cu.err = err
+		return []checkup{cu}
+	}
+	src, isSheet := meta.(sheetsSource)
+
+	rows, err := meta.rows(ctx)
+	if err != nil {
+		cu.err = errors.Wrap(err, "reading metadata")
+		if isSheet {
+			cu.hint = googleAPIHint(err, "sheets.googleapis.com", fmt.Sprintf("Share the spreadsheet with %s (as an editor, to let ssupdate and the server write to it).", email))
+		}
+		return []checkup{cu}
+	}
+	cu.detail = fmt.Sprintf("read %d rows", len(rows))
+	if !isSheet {
+		return []checkup{cu}
+	}
+
+	wcu := checkup{name: "spreadsheet write"}
+	f, err := src.dsvc.Get(src.sheetID).Fields("capabilities(canEdit)").Context(ctx).Do()
+	switch {
+	case err != nil:
+		wcu.err = errors.Wrap(err, "checking spreadsheet permissions")
+		wcu.hint = googleAPIHint(err, "drive.googleapis.com", "")
+	case f.Capabilities == nil || !f.Capabilities.CanEdit:
+		wcu.err = fmt.Errorf("%s can read but not edit the spreadsheet", email)
+		wcu.hint = fmt.Sprintf("Share the spreadsheet with %s as an editor (needed by ssupdate and for editing metadata through the server).", email)
+	default:
+		wcu.detail = "can edit the spreadsheet"
+	}
+	return []checkup{cu, wcu}
+}
+
+// checkCert checks the certificate from -certcmd or -cert and -key, if any.
+func checkCert(ctx context.Context, certcmd, certFile, keyFile string) checkup {
+	cu := checkup{name: "certificate"}
+
+	var (
+		cert tls.Certificate
+		err  error
+	)
+	switch {
+	case certcmd != "":
+		cu.hint = "Run the command by hand: it must print a JSON object with the PEM-encoded certificate chain and key (see github.com/bobg/certs)."
+		cert, err = firstCert(ctx, certcmd)
+		if err != nil {
+			cu.err = errors.Wrap(err, "in -certcmd")
+			return cu
+		}
+
+	case certFile != "":
+		cu.hint = "-cert must be a PEM-encoded certificate chain and -key its PEM-encoded private key."
+		if cert, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			cu.err = errors.Wrapf(err, "loading %s and %s", certFile, keyFile)
+			return cu
+		}
+
+	default:
+		cu.skipped, cu.detail = true, "no -certcmd or -cert given"
+		return cu
+	}
+
+	status, err := prepareCert(ctx, &cert)
+	if err != nil {
+		cu.err = err
+		return cu
+	}
+	if now := time.Now(); now.After(status.NotAfter) || now.Before(status.NotBefore) {
+		cu.err = fmt.Errorf("certificate is valid only from %s to %s", status.NotBefore, status.NotAfter)
+		cu.hint = "Renew the certificate, or check this machine's clock."
+		return cu
+	}
+	if len(status.Problems) > 0 {
+		cu.err = fmt.Errorf("certificate problems: %s", strings.Join(status.Problems, "; "))
+		cu.hint = "Clients may reject this certificate."
+		return cu
+	}
+
+	cu.detail = fmt.Sprintf("%s, expires in %s", status.Subject, status.ExpiresIn)
+	return cu
+}
+
+// firstCert runs a cert command until it produces its first certificate.
+func firstCert(ctx context.Context, certcmd string) (tls.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, doctorCertTimeout)
+	defer cancel()
+
+	certCh, wait, err := certs.FromCommand(ctx, certcmd)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "launching cert command")
+	}
+	cert, ok := <-certCh
+	cancel()
+	for range certCh {
+	}
+	waitErr := wait()
+	if !ok {
+		if waitErr == nil {
+			waitErr = fmt.Errorf("no certificate within %s", doctorCertTimeout)
+		}
+		return tls.Certificate{}, waitErr
+	}
+	return cert, nil
+}
+
+// checkListen checks that the server could listen at listenAddr.
+func checkListen(listenAddr string) checkup {
+	cu := checkup{name: "listen"}
+
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		cu.err = err
+		switch {
+		case errors.Is(err, syscall.EADDRINUSE):
+			cu.hint = "Something (perhaps another kodigcs) is already listening there; stop it or choose another -listen address."
+		case errors.Is(err, syscall.EACCES):
+			cu.hint = "Ports below 1024 need privileges; use a higher port, or grant kodigcs the capability with setcap cap_net_bind_service=+ep."
+		case errors.Is(err, syscall.EADDRNOTAVAIL):
+			cu.hint = "That address isn't one of this machine's; check -listen."
+		}
+		return cu
+	}
+	ln.Close()
+
+	cu.detail = fmt.Sprintf("%s is available", listenAddr)
+	return cu
+}
+
+// googleErrCode is the HTTP status of a Google API error,
+// or 0.
+func googleErrCode(err error) int {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return 0
+}
+
+// googleAPIHint is advice for an error from the Google API at service:
+// to enable the API if that's the problem,
+// or else the given advice for a permission problem.
+func googleAPIHint(err error, service, forbidden string) string {
+	if strings.Contains(err.Error(), "SERVICE_DISABLED") || strings.Contains(err.Error(), "has not been used in project") {
+		return fmt.Sprintf("Enable the API with: gcloud services enable %s", service)
+	}
+	switch googleErrCode(err) {
+	case http.StatusForbidden:
+		return forbidden
+	case http.StatusNotFound:
+		return "Check the ID in -sheet."
+	}
+	return ""
+}
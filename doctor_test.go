@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckCredsFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cases := []struct {
+		path    string
+		wantErr string
+	}{{
+		path:    filepath.Join(dir, "missing.json"),
+		wantErr: "does not exist",
+	}, {
+		path:    write("notjson.json", "hello"),
+		wantErr: "not JSON",
+	}, {
+		path:    write("user.json", `{"type": "authorized_user"}`),
+		wantErr: "not a service-account key",
+	}, {
+		path: write("sa.json", `{"type": "service_account", "client_email": "kodigcs@proj.iam.gserviceaccount.com", "project_id": "proj"}`),
+	}}
+	for _, tc := range cases {
+		sa, err := checkCredsFile(tc.path)
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %s", tc.path, err)
+			} else if sa.ClientEmail != "kodigcs@proj.iam.gserviceaccount.com" {
+				t.Errorf("%s: got client email %q", tc.path, sa.ClientEmail)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: got error %v, want one containing %q", tc.path, err, tc.wantErr)
+		}
+	}
+}
+
+func TestCheckListen(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	cu := checkListen(addr)
+	if cu.err == nil || !strings.Contains(cu.hint, "already listening") {
+		t.Errorf("got error %v, hint %q for an address in use", cu.err, cu.hint)
+	}
+
+	ln.Close()
+	if cu := checkListen(addr); cu.err != nil {
+		t.Errorf("got error %v for a free address", cu.err)
+	}
+}
+
+func TestCheckupWrite(t *testing.T) {
+	buf := new(bytes.Buffer)
+	checkup{name: "bucket", detail: "read and write access to gs://media"}.write(buf)
+	checkup{name: "certificate", skipped: true, detail: "no -certcmd or -cert given"}.write(buf)
+	checkup{name: "listen", err: errors.New("address in use"), hint: "Stop the other server."}.write(buf)
+
+	want := `ok    bucket: read and write access to gs://media
+-     certificate: no -certcmd or -cert given
+FAIL  listen: address in use
+      Stop the other server.
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// When the server is told to stop,
+// it first drains:
+// it keeps listening,
+// and answers everything as usual except new streams,
+// which get 503 (Service Unavailable),
+// as does /readyz,
+// so that load balancers and players go elsewhere
+// while the streams in progress finish.
+// When they have,
+// or when -drain-timeout has passed,
+// the server shuts down,
+// closing any connections that remain.
+
+const (
+	// How often to check whether the streams in progress have finished.
+	drainPollInterval = time.Second
+
+	// The Retry-After for a stream refused while draining.
+	drainRetryAfter = 30 * time.Second
+)
+
+// drained wraps a handler so that it refuses new streams while the server is draining,
+// and counts the streams in progress.
+// It goes outside throttled.
+func (s *server) drained(f func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	return func(w http.ResponseWriter, req *http.Request) error {
+		if !isStreamingRequest(req) {
+			return f(w, req)
+		}
+		if s.draining.Load() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(drainRetryAfter/time.Second)))
+			return mid.CodeErr{
+				C:   http.StatusServiceUnavailable,
+				Err: fmt.Errorf("server is shutting down"),
+			}
+		}
+
+		s.streams.Add(1)
+		defer s.streams.Add(-1)
+		return f(w, req)
+	}
+}
+
+// drain puts the server in the draining state,
+// waits for the streams in progress to finish,
+// and shuts h down,
+// closing its remaining connections after s.drainTimeout (if nonzero).
+func (s *server) drain(h *http.Server) error {
+	s.draining.Store(true)
+
+	ctx := context.Background()
+	if s.drainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.drainTimeout)
+		defer cancel()
+	}
+
+	// If another process can take over the socket,
+	// stop accepting connections now (see handoff.go).
+	if n := s.streams.Load(); n > 0 && !s.handoff() {
+		log.Printf("Draining %d stream(s)", n)
+
+		ticker := time.NewTicker(drainPollInterval)
+		defer ticker.Stop()
+
+		for s.streams.Load() > 0 && ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			}
+		}
+	}
+
+	err := h.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Closing connections, with %d stream(s) in progress, after -drain-timeout of %s", s.streams.Load(), s.drainTimeout)
+		return errors.Wrap(h.Close(), "closing server")
+	}
+	return errors.Wrap(err, "in Shutdown")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bobg/mid"
+)
+
+func TestDrain(t *testing.T) {
+	s := &server{drainTimeout: 200 * time.Millisecond}
+
+	started := make(chan struct{})
+	h := mid.Err(s.drained(func(w http.ResponseWriter, req *http.Request) error {
+		if isStreamingRequest(req) {
+			close(started)
+			<-req.Context().Done() // a stream that never ends
+		}
+		return nil
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if resp, err := http.Get(srv.URL + "/Top%20Hat.mkv"); err == nil {
+			resp.Body.Close()
+		} // else the connection was closed by drain, as expected
+	}()
+	<-started
+
+	s.draining.Store(true)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/Swing%20Time.mkv", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("got status %d, Retry-After %q for a new stream while draining", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/Top%20Hat.nfo", nil))
+	if rec.Code >= 300 {
+		t.Errorf("got status %d for a non-stream while draining", rec.Code)
+	}
+
+	start := time.Now()
+	if err := s.drain(srv.Config); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < s.drainTimeout || elapsed > 5*time.Second {
+		t.Errorf("drain took %s with a timeout of %s", elapsed, s.drainTimeout)
+	}
+	<-done
+	if n := s.streams.Load(); n != 0 {
+		t.Errorf("%d stream(s) still counted after drain", n)
+	}
+}
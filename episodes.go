@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/htree/v2"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/sheets/v4"
+)
+
+// The headings of the Series tab (see tv.go) when ssupdate creates it
+// to hold the episodes of series discs.
+var seriesTabHeadings = []interface{}{"Show", "Season", "Episode", "Title", "Aired", "Plot", "IMDbID"}
+
+type imdbEpisode struct {
+	ID      string
+	Season  int
+	Episode int
+	Title   string
+	Aired   string // YYYY-MM-DD, or as much of it as is known
+	Plot    string
+}
+
+func parseIMDbEpisodesPage(ctx context.Context, f *imdbFetcher, id string, season int) ([]imdbEpisode, error) {
+	episodesURL := fmt.Sprintf("https://www.imdb.com/title/%s/episodes/?season=%d", id, season)
+
+	page, err := f.get(ctx, episodesURL, fmt.Sprintf("%s-season%d", id, season))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseIMDbEpisodesHTML(bytes.NewReader(page))
+}
+
+// parseIMDbEpisodesHTML parses the episode list of an IMDb episodes page
+// from the page data embedded in it.
+func parseIMDbEpisodesHTML(r io.Reader) ([]imdbEpisode, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing HTML")
+	}
+
+	dataEl := htree.FindEl(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Script && htree.ElAttr(n, "id") == "__NEXT_DATA__"
+	})
+	if dataEl == nil {
+		return nil, fmt.Errorf("no page data in HTML")
+	}
+
+	var buf strings.Builder
+	for child := dataEl.FirstChild; child != nil; child = child.NextSibling {
+		buf.WriteString(child.Data)
+	}
+
+	var data struct {
+		Props struct {
+			PageProps struct {
+				ContentData struct {
+					Section struct {
+						Episodes struct {
+							Items []struct {
+								ID          string     `json:"id"`
+								Season      flexNumber `json:"season"`
+								Episode     flexNumber `json:"episode"`
+								TitleText   string     `json:"titleText"`
+								Plot        string     `json:"plot"`
+								ReleaseDate *struct {
+									Year  int `json:"year"`
+									Month int `json:"month"`
+									Day   int `json:"day"`
+								} `json:"releaseDate"`
+							} `json:"items"`
+						} `json:"episodes"`
+					} `json:"section"`
+				} `json:"contentData"`
+			} `json:"pageProps"`
+		} `json:"props"`
+	}
+	if err := json.Unmarshal([]byte(buf.String()), &data); err != nil {
+		return nil, errors.Wrap(err, "unmarshaling page data")
+	}
+
+	var result []imdbEpisode
+	for _, item := range data.Props.PageProps.ContentData.Section.Episodes.Items {
+		ep := imdbEpisode{
+			ID:      item.ID,
+			Season:  int(item.Season),
+			Episode: int(item.Episode),
+			Title:   html.UnescapeString(item.TitleText),
+			Plot:    html.UnescapeString(item.Plot),
+		}
+		if d := item.ReleaseDate; d != nil && d.Year > 0 {
+			switch {
+			case d.Month == 0:
+				ep.Aired = fmt.Sprintf("%04d", d.Year)
+			case d.Day == 0:
+				ep.Aired = fmt.Sprintf("%04d-%02d", d.Year, d.Month)
+			default:
+				ep.Aired = fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+			}
+		}
+		result = append(result, ep)
+	}
+	return result, nil
+}
+
+// flexNumber is a JSON number that may be encoded as a string.
+type flexNumber int
+
+func (n *flexNumber) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		// IMDb uses e.g. "Unknown" for unnumbered episodes.
+		*n = 0
+		return nil
+	}
+	*n = flexNumber(v)
+	return nil
+}
+
+// updateEpisodes writes the episodes of each series disc in the metadata spreadsheet to the Series tab,
+// where serve -tv finds them.
+// A series disc is a row with an IMDbID (of the series) and a Season.
+// Its episodes belong to the show with that IMDbID in the Series tab,
+// or else to the show named by the disc's Title,
+// which is then added to the tab with the IMDbID.
+// Discs whose show already has episodes of the disc's season are skipped.
+func updateEpisodes(ctx context.Context, src sheetsSource, fetcher *imdbFetcher, ssLimiter *rate.Limiter, htmldir string) error {
+	ssvc, sheetID := src.ssvc, src.sheetID
+
+	type seasonDisc struct {
+		name, id, title string
+		season          int
+	}
+
+	var discs []seasonDisc
+	err := handleSheet(ctx, src, func(_ int, headings []string, name string, row []interface{}) error {
+		disc := seasonDisc{name: name}
+		for j, heading := range headings {
+			if j >= len(row) {
+				break
+			}
+			val, ok := row[j].(string)
+			if !ok {
+				continue
+			}
+			val = strings.TrimSpace(val)
+			switch heading {
+			case "imdbid":
+				disc.id = parseIMDbID(val)
+			case "title":
+				disc.title = val
+			case "season":
+				disc.season, _ = strconv.Atoi(val)
+			}
+		}
+		if disc.id != "" && disc.season > 0 {
+			discs = append(discs, disc)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "finding series discs")
+	}
+	if len(discs) == 0 {
+		return nil
+	}
+
+	if err := ensureTab(ctx, ssvc, sheetID, seriesTab, seriesTabHeadings); err != nil {
+		return errors.Wrapf(err, "creating %s tab", seriesTab)
+	}
+
+	resp, err := ssvc.Values.Get(sheetID, quoteTab(seriesTab)).Context(ctx).Do()
+	if err != nil {
+		return errors.Wrapf(err, "reading %s tab", seriesTab)
+	}
+	if len(resp.Values) == 0 {
+		return fmt.Errorf("%s tab has no headings", seriesTab)
+	}
+	headings, err := seriesHeadings(resp.Values[0])
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(headings, "season") || !slices.Contains(headings, "episode") {
+		return fmt.Errorf("%s tab needs Season and Episode columns for episodes", seriesTab)
+	}
+	series, err := parseSeries(resp.Values)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s tab", seriesTab)
+	}
+
+	type showSeason struct {
+		show   string
+		season int
+	}
+	var (
+		showByID = make(map[string]string)
+		shows    = set.New[string]()
+		done     = set.New[showSeason]()
+	)
+	for name, ser := range series {
+		shows.Add(name)
+		if ser.show.imdbID != "" {
+			showByID[ser.show.imdbID] = name
+		}
+		for key := range ser.episodes {
+			done.Add(showSeason{show: name, season: key[0]})
+		}
+	}
+
+	for _, disc := range discs {
+		show := showByID[disc.id]
+		if show == "" {
+			show = disc.title
+		}
+		if show == "" {
+			show = disc.name
+		}
+		if done.Has(showSeason{show: show, season: disc.season}) {
+			continue
+		}
+
+		var episodes []imdbEpisode
+
+		if htmldir != "" {
+			filename := filepath.Join(htmldir, disc.name+".episodes.html")
+			f, err := os.Open(filename)
+			if errors.Is(err, fs.ErrNotExist) {
+				// ok
+			} else if err != nil {
+				return errors.Wrapf(err, "opening %s", filename)
+			} else {
+				log.Printf("Getting IMDb episodes for %s from %s...", disc.name, filename)
+				episodes, err = parseIMDbEpisodesHTML(f)
+				f.Close()
+				if err != nil {
+					return errors.Wrapf(err, "parsing %s", filename)
+				}
+			}
+		}
+
+		if episodes == nil {
+			log.Printf("Getting IMDb episodes for %s (season %d)...", disc.name, disc.season)
+			episodes, err = parseIMDbEpisodesPage(ctx, fetcher, disc.id, disc.season)
+			if err != nil {
+				return errors.Wrapf(err, "getting IMDb episodes for %s (id %s, season %d)", disc.name, disc.id, disc.season)
+			}
+		}
+		if len(episodes) == 0 {
+			log.Printf("No episodes found for %s", disc.name)
+			continue
+		}
+
+		var rows [][]interface{}
+		if !shows.Has(show) {
+			rows = append(rows, seriesRow(headings, map[string]interface{}{"show": show, "imdbid": disc.id}))
+		}
+		rows = append(rows, episodeRows(headings, show, disc.season, episodes)...)
+
+		if err := ssLimiter.Wait(ctx); err != nil {
+			return errors.Wrap(err, "waiting for ssLimiter")
+		}
+		_, err = ssvc.Values.Append(sheetID, quoteTab(seriesTab), &sheets.ValueRange{Values: rows}).
+			Context(ctx).
+			ValueInputOption("RAW").
+			InsertDataOption("INSERT_ROWS").
+			Do()
+		if err != nil {
+			return errors.Wrapf(err, "appending episodes of %s", disc.name)
+		}
+
+		shows.Add(show)
+		if _, ok := showByID[disc.id]; !ok {
+			showByID[disc.id] = show
+		}
+		done.Add(showSeason{show: show, season: disc.season})
+	}
+
+	return nil
+}
+
+// episodeRows lays out the episodes of a season of a show
+// as rows of the Series tab with the given (canonical) headings.
+// Episodes without a number are skipped,
+// since they cannot be matched with files.
+func episodeRows(headings []string, show string, season int, episodes []imdbEpisode) [][]interface{} {
+	var rows [][]interface{}
+	for _, ep := range episodes {
+		if ep.Episode == 0 {
+			log.Printf("Skipping unnumbered episode %s of %s", ep.ID, show)
+			continue
+		}
+		if ep.Season == 0 {
+			ep.Season = season
+		}
+		rows = append(rows, seriesRow(headings, map[string]interface{}{
+			"show":    show,
+			"season":  ep.Season,
+			"episode": ep.Episode,
+			"title":   ep.Title,
+			"aired":   ep.Aired,
+			"plot":    ep.Plot,
+			"imdbid":  ep.ID,
+		}))
+	}
+	return rows
+}
+
+// seriesRow lays out cells, keyed by canonical heading,
+// as a row of a tab with the given headings.
+// Cells with no column are dropped.
+func seriesRow(headings []string, cells map[string]interface{}) []interface{} {
+	row := make([]interface{}, len(headings))
+	for i, heading := range headings {
+		if val, ok := cells[heading]; ok {
+			row[i] = val
+		} else {
+			row[i] = ""
+		}
+	}
+	return row
+}
+
+// ensureTab adds a tab with the given title and headings to a spreadsheet,
+// if it does not already have one.
+func ensureTab(ctx context.Context, ssvc *sheets.SpreadsheetsService, sheetID, title string, headings []interface{}) error {
+	ok, err := hasTab(ctx, ssvc, sheetID, title)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	req := &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{
+			AddSheet: &sheets.AddSheetRequest{
+				Properties: &sheets.SheetProperties{Title: title},
+			},
+		}},
+	}
+	if _, err := ssvc.BatchUpdate(sheetID, req).Context(ctx).Do(); err != nil {
+		return errors.Wrap(err, "adding tab")
+	}
+
+	headingsRange := fmt.Sprintf("%s!A1:%s1", title, colName(len(headings)-1))
+	_, err = ssvc.Values.Update(sheetID, headingsRange, &sheets.ValueRange{Values: [][]interface{}{headings}}).
+		Context(ctx).
+		ValueInputOption("RAW").
+		Do()
+	return errors.Wrap(err, "writing headings")
+}
+
+// hasTab tells whether the spreadsheet has a tab with the given title.
+func hasTab(ctx context.Context, ssvc *sheets.SpreadsheetsService, sheetID, title string) (bool, error) {
+	ss, err := ssvc.Get(sheetID).Fields("sheets.properties.title").Context(ctx).Do()
+	if err != nil {
+		return false, errors.Wrap(err, "getting spreadsheet properties")
+	}
+	for _, sh := range ss.Sheets {
+		if sh.Properties != nil && sh.Properties.Title == title {
+			return true, nil
+		}
+	}
+	return false, nil
+}
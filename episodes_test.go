@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestEpisodeRowsNFO(t *testing.T) {
+	// An existing Series tab, with its columns in its own order.
+	values := [][]interface{}{
+		{"Title", "Show", "Episode", "Season", "IMDbID", "Plot", "Aired"},
+		{"The Thin Man Mysteries", "Thin Man", "", "", "tt0050065"},
+	}
+	headings, err := seriesHeadings(values[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	episodes := []imdbEpisode{
+		{ID: "tt0000001", Season: 1, Episode: 1, Title: "Asta Goes West", Aired: "1957-09-20", Plot: "Asta & Nick head west."},
+		{ID: "tt0000002", Episode: 2, Title: "The Dumb Witness"}, // season from the disc
+		{ID: "tt0000003", Season: 1, Title: "Unaired pilot"},     // unnumbered, skipped
+	}
+	rows := episodeRows(headings, "Thin Man", 1, episodes)
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+
+	// Sheets returns the cells as formatted strings.
+	for _, row := range rows {
+		strs := make([]interface{}, len(row))
+		for i, val := range row {
+			strs[i] = fmt.Sprint(val)
+		}
+		values = append(values, strs)
+	}
+	series, err := parseSeries(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	s := &server{
+		tv:           true,
+		objNames:     set.New("Thin Man/Season 01/S01E01.mkv", "Thin Man/Season 01/S01E02.mkv"),
+		objNamesTime: now,
+		infoMap:      map[string]movieInfo{},
+		infoMapTime:  now,
+		series:       series,
+	}
+
+	for _, c := range []struct {
+		path string
+		want tvEpisodeInfo
+	}{{
+		path: "/tv/Thin%20Man/Season%2001/S01E01.nfo",
+		want: tvEpisodeInfo{Title: "Asta Goes West", Show: "The Thin Man Mysteries", Season: 1, Episode: 1, Aired: "1957-09-20", Plot: "Asta & Nick head west."},
+	}, {
+		path: "/tv/Thin%20Man/Season%2001/S01E02.nfo",
+		want: tvEpisodeInfo{Title: "The Dumb Witness", Show: "The Thin Man Mysteries", Season: 1, Episode: 2},
+	}} {
+		rec := httptest.NewRecorder()
+		if err := s.handleTV(rec, httptest.NewRequest("GET", c.path, nil)); err != nil {
+			t.Fatalf("%s: %s", c.path, err)
+		}
+		var got tvEpisodeInfo
+		if err := xml.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %s", c.path, err)
+		}
+		got.XMLName = xml.Name{}
+		if got != c.want {
+			t.Errorf("%s: got %+v, want %+v", c.path, got, c.want)
+		}
+	}
+}
+
+func TestParseIMDbEpisodesHTML(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "imdb", "episodes.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got, err := parseIMDbEpisodesHTML(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []imdbEpisode{
+		{ID: "tt0640001", Season: 1, Episode: 1, Title: "Asta Goes West", Aired: "1957-09-20", Plot: "Nick and Nora head west."},
+		{ID: "tt0640002", Season: 1, Episode: 2, Title: "The Dumb Witness", Aired: "1957-09", Plot: "A parrot knows too much."},
+		{ID: "tt0640003", Season: 1, Episode: 3, Title: "Scene of the Crime & After", Aired: "1957"},
+		{ID: "tt0640004", Episode: 4, Title: "No Season"},
+		{ID: "tt0640005", Season: 1, Title: "Unaired Pilot"},
+		{ID: "tt0640006", Title: "Nothing Known"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{
+		`<html><body>No page data</body></html>`,
+		`<html><script id="__NEXT_DATA__">{"props":</script></html>`,
+	} {
+		if _, err := parseIMDbEpisodesHTML(strings.NewReader(bad)); err == nil {
+			t.Errorf("no error parsing %s", bad)
+		}
+	}
+}
+
+func TestFlexNumber(t *testing.T) {
+	cases := []struct {
+		in   string
+		want flexNumber
+	}{
+		{in: `3`, want: 3},
+		{in: `"12"`, want: 12},
+		{in: `""`},
+		{in: `null`},
+		{in: `"Unknown"`},
+		{in: `"1.5"`},
+	}
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			n := flexNumber(99)
+			if err := json.Unmarshal([]byte(c.in), &n); err != nil {
+				t.Fatal(err)
+			}
+			if n != c.want {
+				t.Errorf("got %d, want %d", n, c.want)
+			}
+		})
+	}
+}
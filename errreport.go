@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bobg/errors"
+	"google.golang.org/api/clouderrorreporting/v1beta1"
+	"google.golang.org/api/option"
+)
+
+// With serve -error-dsn,
+// the server reports the server errors (5xx) that its handlers return,
+// and the panics in them,
+// to Sentry or to Google Cloud Error Reporting,
+// with the request's method, path, client address, user agent, and user,
+// and the stack trace of the panic or of the error
+// (when the error was wrapped with github.com/bobg/errors).
+// The query string is left out,
+// since it may hold a token or a PIN.
+// Errors from requests whose clients have gone away are not reported.
+//
+// -error-dsn is a Sentry DSN (https://KEY@HOST/PROJECT)
+// or errorreporting://PROJECT for Cloud Error Reporting in that Google Cloud project,
+// reached with the service account in -creds
+// (which needs the Error Reporting Writer role).
+//
+// Reports are sent in the background,
+// and dropped if they back up.
+
+// errReportQueue is the number of reports waiting to be sent
+// beyond which new ones are dropped.
+const errReportQueue = 100
+
+// errorEvent is an error or panic to report.
+type errorEvent struct {
+	time    time.Time
+	message string
+	panic   bool
+	stack   errors.Frames // innermost first, or nil
+
+	method, path, remoteAddr, userAgent, referrer, user string
+	status                                              int
+}
+
+// newErrorEvent describes an error or panic in serving req.
+func newErrorEvent(req *http.Request, message string, status int, stack errors.Frames) errorEvent {
+	remote := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	return errorEvent{
+		time:       time.Now(),
+		message:    message,
+		stack:      stack,
+		method:     req.Method,
+		path:       req.URL.Path,
+		remoteAddr: remote,
+		userAgent:  req.UserAgent(),
+		referrer:   req.Referer(),
+		user:       principal(req.Context()),
+		status:     status,
+	}
+}
+
+// errorSink is where an errorReporter sends its reports.
+type errorSink interface {
+	send(context.Context, errorEvent) error
+}
+
+type errorReporter struct {
+	sink   errorSink
+	events chan errorEvent
+}
+
+// newErrorReporter makes an errorReporter from serve -error-dsn.
+func newErrorReporter(ctx context.Context, dsn, credsFile string) (*errorReporter, error) {
+	var sink errorSink
+
+	if project, ok := strings.CutPrefix(dsn, "errorreporting://"); ok {
+		if project == "" {
+			return nil, fmt.Errorf("no project in %s", dsn)
+		}
+		svc, err := clouderrorreporting.NewService(ctx, option.WithCredentialsFile(credsFile))
+		if err != nil {
+			return nil, errors.Wrap(err, "creating Error Reporting service")
+		}
+		sink = &cloudErrorSink{svc: svc, project: project}
+	} else {
+		sentry, err := parseSentryDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		sink = sentry
+	}
+
+	return &errorReporter{sink: sink, events: make(chan errorEvent, errReportQueue)}, nil
+}
+
+// report queues ev to be sent,
+// or drops it if too many reports are waiting.
+func (r *errorReporter) report(ev errorEvent) {
+	select {
+	case r.events <- ev:
+	default:
+		log.Printf("Too many error reports waiting, dropping: %s", ev.message)
+	}
+}
+
+// run sends the queued reports until the context is canceled.
+func (r *errorReporter) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-r.events:
+			sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			if err := r.sink.send(sendCtx, ev); err != nil {
+				log.Printf("Error reporting error: %s", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// reported wraps a handler function, if s.errReporter is set,
+// so that its server errors and panics are reported.
+// A panic becomes an error response.
+func (s *server) reported(f func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	if s.errReporter == nil {
+		return f
+	}
+	return func(w http.ResponseWriter, req *http.Request) (err error) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+			stack := panicStack()
+			log.Printf("Panic serving %s %s: %v\n%s", req.Method, req.URL.Path, r, stack)
+
+			ev := newErrorEvent(req, fmt.Sprintf("panic: %v", r), http.StatusInternalServerError, stack)
+			ev.panic = true
+			s.errReporter.report(ev)
+
+			err = fmt.Errorf("panic: %v", r)
+		}()
+
+		err = f(w, req)
+		if err == nil || req.Context().Err() != nil {
+			return err
+		}
+		if code := errorCode(err); code >= 500 {
+			s.errReporter.report(newErrorEvent(req, err.Error(), code, errors.Stack(err)))
+		}
+		return err
+	}
+}
+
+// panicStack is the stack of a panic,
+// for calling in the deferred function that recovers from it.
+func panicStack() errors.Frames {
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(3, pcs)]
+
+	var (
+		frames = runtime.CallersFrames(pcs)
+		result errors.Frames
+	)
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			result = append(result, errors.Frame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		}
+		if !more {
+			break
+		}
+	}
+	return result
+}
+
+// sentrySink sends reports to Sentry.
+type sentrySink struct {
+	dsn      string
+	key      string
+	endpoint string // the project's envelope endpoint
+	client   *http.Client
+}
+
+// parseSentryDSN parses a Sentry DSN,
+// https://KEY@HOST/PROJECT.
+func parseSentryDSN(dsn string) (*sentrySink, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing Sentry DSN")
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("Sentry DSN %s is not an http or https URL, nor errorreporting://PROJECT", u.Redacted())
+	}
+	key := u.User.Username()
+	if key == "" {
+		return nil, fmt.Errorf("no key in Sentry DSN %s", u.Host)
+	}
+	logRedactor.addSecret(key)
+
+	// The project ID is the last element of the path,
+	// and anything before it is a prefix for the API.
+	prefix, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return nil, fmt.Errorf("no project ID in Sentry DSN %s", u.Host)
+	}
+
+	return &sentrySink{
+		dsn:      dsn,
+		key:      key,
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+type (
+	sentryEvent struct {
+		EventID    string            `json:"event_id"`
+		Timestamp  string            `json:"timestamp"`
+		Platform   string            `json:"platform"`
+		Level      string            `json:"level"`
+		Logger     string            `json:"logger"`
+		ServerName string            `json:"server_name,omitempty"`
+		Exception  sentryExceptions  `json:"exception"`
+		Request    sentryRequest     `json:"request"`
+		User       *sentryUser       `json:"user,omitempty"`
+		Tags       map[string]string `json:"tags"`
+	}
+
+	sentryExceptions struct {
+		Values []sentryException `json:"values"`
+	}
+
+	sentryException struct {
+		Type       string            `json:"type"`
+		Value      string            `json:"value"`
+		Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+	}
+
+	sentryStacktrace struct {
+		Frames []sentryFrame `json:"frames"` // outermost first
+	}
+
+	sentryFrame struct {
+		Function string `json:"function"`
+		AbsPath  string `json:"abs_path"`
+		Lineno   int    `json:"lineno"`
+	}
+
+	sentryRequest struct {
+		Method  string            `json:"method"`
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers,omitempty"`
+	}
+
+	sentryUser struct {
+		Username  string `json:"username,omitempty"`
+		IPAddress string `json:"ip_address,omitempty"`
+	}
+)
+
+func (s *sentrySink) send(ctx context.Context, ev errorEvent) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return errors.Wrap(err, "making event ID")
+	}
+	host, _ := os.Hostname()
+
+	event := sentryEvent{
+		EventID:    hex.EncodeToString(id),
+		Timestamp:  ev.time.UTC().Format(time.RFC3339Nano),
+		Platform:   "go",
+		Level:      "error",
+		Logger:     "kodigcs",
+		ServerName: host,
+		Exception: sentryExceptions{Values: []sentryException{{
+			Type:  "error",
+			Value: ev.message,
+		}}},
+		Request: sentryRequest{
+			Method: ev.method,
+			URL:    ev.path,
+		},
+		Tags: map[string]string{"status": fmt.Sprint(ev.status)},
+	}
+	if ev.panic {
+		event.Level = "fatal"
+		event.Exception.Values[0].Type = "panic"
+	}
+	if len(ev.stack) > 0 {
+		st := &sentryStacktrace{}
+		for _, f := range slices.Backward(ev.stack) {
+			st.Frames = append(st.Frames, sentryFrame{Function: f.Function, AbsPath: f.File, Lineno: f.Line})
+		}
+		event.Exception.Values[0].Stacktrace = st
+	}
+	if ev.userAgent != "" || ev.referrer != "" {
+		event.Request.Headers = make(map[string]string)
+		if ev.userAgent != "" {
+			event.Request.Headers["User-Agent"] = ev.userAgent
+		}
+		if ev.referrer != "" {
+			event.Request.Headers["Referer"] = ev.referrer
+		}
+	}
+	if ev.user != "" || ev.remoteAddr != "" {
+		event.User = &sentryUser{Username: ev.user, IPAddress: ev.remoteAddr}
+	}
+
+	// An envelope is a header and then items,
+	// each a header and a payload,
+	// on separate lines.
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	if err := enc.Encode(map[string]string{"event_id": event.EventID, "dsn": s.dsn, "sent_at": time.Now().UTC().Format(time.RFC3339)}); err != nil {
+		return errors.Wrap(err, "encoding envelope header")
+	}
+	if err := enc.Encode(map[string]string{"type": "event"}); err != nil {
+		return errors.Wrap(err, "encoding item header")
+	}
+	if err := enc.Encode(event); err != nil {
+		return errors.Wrap(err, "encoding event")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.endpoint, buf)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=kodigcs, sentry_key=%s", s.key))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "sending to Sentry")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Sentry responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// cloudErrorSink sends reports to Google Cloud Error Reporting.
+type cloudErrorSink struct {
+	svc     *clouderrorreporting.Service
+	project string
+}
+
+func (c *cloudErrorSink) send(ctx context.Context, ev errorEvent) error {
+	// Error Reporting needs a stack trace in the message
+	// or, failing that, a location.
+	message := ev.message
+	if len(ev.stack) > 0 {
+		message += "\n\n" + ev.stack.String()
+	}
+	event := &clouderrorreporting.ReportedErrorEvent{
+		EventTime:      ev.time.UTC().Format(time.RFC3339Nano),
+		Message:        message,
+		ServiceContext: &clouderrorreporting.ServiceContext{Service: "kodigcs"},
+		Context: &clouderrorreporting.ErrorContext{
+			HttpRequest: &clouderrorreporting.HttpRequestContext{
+				Method:             ev.method,
+				Url:                ev.path,
+				UserAgent:          ev.userAgent,
+				Referrer:           ev.referrer,
+				RemoteIp:           ev.remoteAddr,
+				ResponseStatusCode: int64(ev.status),
+			},
+			User: ev.user,
+		},
+	}
+	loc := &clouderrorreporting.SourceLocation{FunctionName: "(unknown)"}
+	if len(ev.stack) > 0 {
+		loc = &clouderrorreporting.SourceLocation{FilePath: ev.stack[0].File, LineNumber: int64(ev.stack[0].Line), FunctionName: ev.stack[0].Function}
+	}
+	event.Context.ReportLocation = loc
+
+	_, err := c.svc.Projects.Events.Report("projects/"+c.project, event).Context(ctx).Do()
+	return errors.Wrap(err, "sending to Error Reporting")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+func TestParseSentryDSN(t *testing.T) {
+	sink, err := parseSentryDSN("https://abc123@o1.ingest.sentry.io/prefix/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sink.key != "abc123" || sink.endpoint != "https://o1.ingest.sentry.io/prefix/api/42/envelope/" {
+		t.Errorf("got key %s, endpoint %s", sink.key, sink.endpoint)
+	}
+
+	for _, bad := range []string{"https://o1.ingest.sentry.io/42", "https://abc123@o1.ingest.sentry.io/", "ftp://abc123@host/42"} {
+		if _, err := parseSentryDSN(bad); err == nil {
+			t.Errorf("no error for %s", bad)
+		}
+	}
+}
+
+func TestReported(t *testing.T) {
+	events := make(chan sentryEvent, 10)
+	sentry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.Contains(req.Header.Get("X-Sentry-Auth"), "sentry_key=key") {
+			http.Error(w, "bad key", http.StatusUnauthorized)
+			return
+		}
+		// The event is the third line of the envelope.
+		sc := bufio.NewScanner(req.Body)
+		for i := 0; i < 3 && sc.Scan(); i++ {
+		}
+		var ev sentryEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		events <- ev
+	}))
+	defer sentry.Close()
+
+	sink, err := parseSentryDSN(strings.Replace(sentry.URL, "//", "//key@", 1) + "/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &server{errReporter: &errorReporter{sink: sink, events: make(chan errorEvent, errReportQueue)}}
+	go s.errReporter.run(ctx)
+
+	serve := func(f func(http.ResponseWriter, *http.Request) error, path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), principalKey, "alice"))
+		rec := httptest.NewRecorder()
+		mid.Err(s.reported(f)).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	code := serve(func(http.ResponseWriter, *http.Request) error {
+		var m map[string]int
+		m["x"]++
+		return nil
+	}, "/Top%20Hat.mkv?token=secret")
+	if code != http.StatusInternalServerError {
+		t.Errorf("got status %d for a panic", code)
+	}
+	ev := <-events
+	if ev.Level != "fatal" || ev.Exception.Values[0].Type != "panic" || ev.Request.URL != "/Top Hat.mkv" || ev.User == nil || ev.User.Username != "alice" {
+		t.Errorf("got event %+v for a panic", ev)
+	}
+	if st := ev.Exception.Values[0].Stacktrace; st == nil || !strings.Contains(st.Frames[len(st.Frames)-1].Function, "TestReported") {
+		t.Errorf("got stack trace %+v for a panic", st)
+	}
+
+	// Client errors aren't reported; server errors are.
+	serve(func(http.ResponseWriter, *http.Request) error {
+		return mid.CodeErr{C: http.StatusNotFound}
+	}, "/missing.mkv")
+	serve(func(http.ResponseWriter, *http.Request) error {
+		return errors.Wrap(errors.New("bucket unavailable"), "reading object")
+	}, "/Swing%20Time.mkv")
+
+	ev = <-events
+	if ev.Level != "error" || ev.Request.URL != "/Swing Time.mkv" || ev.Exception.Values[0].Value != "reading object: bucket unavailable" || ev.Tags["status"] != "500" {
+		t.Errorf("got event %+v for an error", ev)
+	}
+	if ev.Exception.Values[0].Stacktrace == nil {
+		t.Error("no stack trace for a wrapped error")
+	}
+}
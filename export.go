@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// Exports of the info map are in the form of metadata:
+// a CSV file with the metadata spreadsheet's headings,
+// or a JSON manifest,
+// either of which can be given back to the server with -sheet.
+
+// exportHeadings are the columns of an export, in order.
+var exportHeadings = []string{
+	"filename", "title", "originaltitle", "sort", "year", "premiered",
+	"banner", "clearart", "clearlogo", "discart", "landscape", "poster",
+	"directors", "actors", "actorthumbs", "runtime", "trailer",
+	"outline", "plot", "tagline", "genre", "mpaa", "country", "studio", "language",
+	"set", "imdbrating", "rottentomatoes", "metacritic", "top250", "awards",
+	"subdir", "hidden", "adult", "parts", "imdbid",
+}
+
+// cells renders the info as the values of metadata spreadsheet columns,
+// keyed by lowercase column heading,
+// like titleInfo.cells.
+// Columns for which there is no info are absent.
+func (info movieInfo) cells() map[string]string {
+	cells := make(map[string]string)
+	put := func(heading, val string) {
+		if val != "" {
+			cells[heading] = val
+		}
+	}
+	putInt := func(heading string, val int) {
+		if val != 0 {
+			put(heading, strconv.Itoa(val))
+		}
+	}
+
+	put("filename", info.filename)
+	put("title", info.Title)
+	put("originaltitle", info.OrigTitle)
+	put("sort", info.SortTitle)
+	putInt("year", info.Year)
+	put("premiered", info.Premiered)
+	for _, t := range info.Thumbs {
+		put(t.Aspect, t.origVal)
+	}
+	put("directors", strings.Join(info.Directors, "; "))
+
+	var actors, pairs []string
+	for _, a := range info.Actors {
+		actors = append(actors, formatActor(a.Name, a.Role))
+		if a.Thumb != nil {
+			pairs = append(pairs, a.Name+"="+a.Thumb.origVal)
+		}
+	}
+	put("actors", strings.Join(actors, "; "))
+	put("actorthumbs", strings.Join(pairs, "; "))
+
+	putInt("runtime", info.Runtime)
+	if u, err := url.Parse(info.Trailer); err == nil {
+		if id := u.Query().Get("videoid"); id != "" {
+			put("trailer", "https://www.youtube.com/watch?v="+url.QueryEscape(id))
+		}
+	}
+	put("outline", info.Outline)
+	put("plot", info.Plot)
+	put("tagline", info.Tagline)
+	put("genre", info.Genre)
+	put("mpaa", info.MPAA)
+	put("country", strings.Join(info.Countries, "; "))
+	put("studio", strings.Join(info.Studios, "; "))
+	if info.FileInfo != nil {
+		var langs []string
+		for _, a := range info.FileInfo.Audio {
+			langs = append(langs, a.Language)
+		}
+		put("language", strings.Join(langs, "; "))
+	}
+	if info.Set != nil {
+		put("set", info.Set.Name)
+	}
+	for _, r := range info.Ratings {
+		for heading, name := range ratingColumns {
+			if name == r.Name {
+				put(heading, strconv.FormatFloat(r.Value, 'f', -1, 64))
+			}
+		}
+	}
+	putInt("top250", info.Top250)
+	put("awards", info.awards)
+	put("subdir", info.subdir)
+	if info.hidden {
+		put("hidden", "true")
+	}
+	if info.adult {
+		put("adult", "true")
+	}
+	put("parts", info.parts)
+	put("imdbid", info.imdbID)
+
+	return cells
+}
+
+// writeExport writes the titles in infoMap to w
+// in the given format, "csv" or "json".
+func writeExport(w io.Writer, format string, infoMap map[string]movieInfo) error {
+	var rootNames []string
+	for rootName := range infoMap {
+		rootNames = append(rootNames, rootName)
+	}
+	sort.Strings(rootNames)
+
+	var titles []map[string]string
+	for _, rootName := range rootNames {
+		titles = append(titles, infoMap[rootName].cells())
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return errors.Wrap(enc.Encode(titles), "encoding JSON")
+
+	case "csv":
+		// Only the columns that some title uses.
+		var headings []string
+		for _, heading := range exportHeadings {
+			for _, t := range titles {
+				if _, ok := t[heading]; ok {
+					headings = append(headings, heading)
+					break
+				}
+			}
+		}
+
+		cw := csv.NewWriter(w)
+		if err := cw.Write(headings); err != nil {
+			return errors.Wrap(err, "writing CSV")
+		}
+		for _, t := range titles {
+			rec := make([]string, 0, len(headings))
+			for _, heading := range headings {
+				rec = append(rec, t[heading])
+			}
+			if err := cw.Write(rec); err != nil {
+				return errors.Wrap(err, "writing CSV")
+			}
+		}
+		cw.Flush()
+		return errors.Wrap(cw.Error(), "writing CSV")
+
+	default:
+		return fmt.Errorf("unknown export format %s, want csv or json", format)
+	}
+}
+
+// handleExport serves the info map as CSV or JSON,
+// according to the format query parameter (default json).
+func (s *server) handleExport(w http.ResponseWriter, req *http.Request) error {
+	format := req.FormValue("format")
+	if format == "" {
+		format = "json"
+	}
+
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv"
+	case "json":
+		contentType = "application/json"
+	default:
+		return mid.CodeErr{
+			C:   http.StatusBadRequest,
+			Err: fmt.Errorf("unknown export format %s, want csv or json", format),
+		}
+	}
+
+	ctx := req.Context()
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="kodigcs-export.%s"`, format))
+	return writeExport(w, format, s.viewableInfoMap(ctx))
+}
+
+func (c maincmd) export(ctx context.Context, sheetID, format, outFile string, _ []string) error {
+	meta, err := newMetadataSource(sheetID, c.ssvc, c.dsvc, c.gcs)
+	if err != nil {
+		return err
+	}
+	if sheetID == "" {
+		manifest, name, err := findManifest(ctx, c.bucket)
+		if err != nil {
+			return err
+		}
+		if manifest != nil {
+			log.Printf("Using metadata from %s in the bucket", name)
+			meta, sheetID = manifest, name
+		}
+	}
+
+	s := &server{
+		bucket:  c.bucket,
+		sheetID: sheetID,
+		meta:    meta,
+		health:  newHealthCounters(),
+		ssvc:    c.ssvc,
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	if outFile == "" {
+		return writeExport(os.Stdout, format, s.infoMap)
+	}
+
+	f, err := os.Create(outFile)
+	if err != nil {
+		return errors.Wrapf(err, "creating %s", outFile)
+	}
+	defer f.Close()
+
+	if err := writeExport(f, format, s.infoMap); err != nil {
+		return err
+	}
+	return errors.Wrapf(f.Close(), "closing %s", outFile)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	var (
+		headings = []string{"filename", "title", "year", "actors", "actorthumbs", "poster", "trailer", "imdbrating", "language", "hidden"}
+		row      = []interface{}{
+			"The Thin Man.iso",
+			"The Thin Man",
+			"1934",
+			"William Powell (Nick Charles); Myrna Loy (Nora Charles)",
+			"William Powell=https://example.com/powell.jpg",
+			"https://example.com/thinman.jpg",
+			"https://youtu.be/abc",
+			"8",
+			"English",
+			"yes",
+		}
+		s = &server{listenAddr: "example.com"}
+	)
+
+	rootName, info := s.parseInfoRow(headings, "The Thin Man.iso", row)
+	want := info.cells()
+
+	for _, format := range []string{"csv", "json"} {
+		t.Run(format, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := writeExport(buf, format, map[string]movieInfo{rootName: info}); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), "export."+format)
+			if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			src, err := newMetadataSource(path, nil, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got map[string]string
+			err = handleSheet(context.Background(), src, func(_ int, headings []string, name string, row []interface{}) error {
+				_, info := s.parseInfoRow(headings, name, row)
+				got = info.cells()
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
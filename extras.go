@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// A title may have a trailer in the bucket,
+// an object named ROOT-trailer.EXT
+// (with one of the media extensions),
+// and extras (deleted scenes, featurettes, and so on),
+// the objects under ROOT-extras/.
+// Neither is listed as a title of its own.
+// The trailer is listed beside the title's media object,
+// and the .nfo file's trailer is its URL
+// instead of any YouTube trailer in the metadata.
+// The extras are listed in a subfolder beside the title's media object,
+// also named ROOT-extras/
+// (but with the title's hash prefix).
+
+const (
+	trailerSuffix = "-trailer"
+	extrasSuffix  = "-extras"
+)
+
+// isExtra tells whether the named object is the trailer or one of the extras of a title.
+// The caller must hold s.mu.
+func (s *server) isExtra(objName string) bool {
+	ext := filepath.Ext(objName)
+	if rootName, ok := strings.CutSuffix(strings.TrimSuffix(objName, ext), trailerSuffix); ok && isMediaExt(ext) {
+		if _, ok := s.mediaObjName(rootName); ok {
+			return true
+		}
+	}
+	if rootName, ok := strings.CutSuffix(path.Dir(objName), extrasSuffix); ok {
+		if _, ok := s.mediaObjName(rootName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// trailerObj returns the name of the object holding a title's trailer, if there is one.
+// The caller must hold s.mu.
+func (s *server) trailerObj(rootName string) (string, bool) {
+	objName, ok := s.mediaObjName(rootName + trailerSuffix)
+	if !ok || s.infoMap[rootName+trailerSuffix].parts != "" {
+		return "", false
+	}
+	return objName, true
+}
+
+// extras returns the names of the objects under a title's ROOT-extras/ folder,
+// without that prefix,
+// in natural order.
+// The caller must hold s.mu.
+func (s *server) extras(rootName string) []string {
+	var (
+		dir    = rootName + extrasSuffix + "/"
+		result []string
+	)
+	for objName := range s.objNames {
+		if name, ok := strings.CutPrefix(objName, dir); ok && name != "" && !strings.Contains(name, "/") {
+			result = append(result, name)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return naturalLess(result[i], result[j]) })
+	return result
+}
+
+// parseExtrasPath parses a path under a title's extras folder,
+// [SUBDIR/]PREFIX-ROOT-extras[/NAME],
+// returning the title's root name and NAME
+// (which is empty for the folder itself).
+func parseExtrasPath(p string) (rootName, name string, ok bool) {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if len(part) <= 8 {
+			continue
+		}
+		root, isExtras := strings.CutSuffix(part[8:], extrasSuffix)
+		if !isExtras || part[:8] != rootNamePrefix(root) {
+			continue
+		}
+		rest := parts[i+1:]
+		if len(rest) > 1 {
+			return "", "", false
+		}
+		return root, strings.Join(rest, ""), true
+	}
+	return "", "", false
+}
+
+// handleExtras serves a title's extras folder,
+// or (if name is not empty) one of the extras in it.
+func (s *server) handleExtras(ctx context.Context, w http.ResponseWriter, req *http.Request, rootName, name string) error {
+	if err := s.checkHidden(ctx, rootName); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	if err := s.titleAccessErr(ctx, rootName); err != nil {
+		s.mu.RUnlock()
+		return err
+	}
+	var (
+		_, isTitle = s.mediaObjName(rootName)
+		extras     = s.extras(rootName)
+	)
+
+	if !isTitle || len(extras) == 0 {
+		s.mu.RUnlock()
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no extras for %s", rootName),
+		}
+	}
+
+	if name == "" {
+		defer s.mu.RUnlock()
+
+		items := make([]template.URL, 0, len(extras))
+		for _, extra := range extras {
+			items = append(items, template.URL(extra))
+		}
+		return s.writeObjDir(w, req, rootName+extrasSuffix, items)
+	}
+
+	objName := rootName + extrasSuffix + "/" + name
+	if !s.objNames.Has(objName) {
+		s.mu.RUnlock()
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no extra %s for %s", name, rootName),
+		}
+	}
+	if req.Method == "PROPFIND" {
+		defer s.mu.RUnlock()
+		return writeMultistatus(w, davMultistatus{
+			Responses: []davResponse{s.davFileResponse(req.URL.EscapedPath(), name, objName)},
+		})
+	}
+	s.mu.RUnlock()
+
+	s.auditStream(req, objName)
+	err := s.serveObj(ctx, w, req, objName, req.URL.Path, s.isVerbose())
+	if isStreamFailure(err) {
+		s.health.streamFailures.Add(1)
+	}
+	return errors.Wrap(err, "serving extra")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestExtras(t *testing.T) {
+	s := &server{
+		objNames: set.New(
+			"The Thin Man.iso",
+			"The Thin Man-trailer.mp4",
+			"The Thin Man-extras/Making of.mp4",
+			"The Thin Man-extras/Stills.jpg",
+			"Orphan-trailer.mp4",
+		),
+	}
+
+	items := s.titleItems(func(movieInfo, bool) bool { return true })
+
+	var (
+		prefix = rootNamePrefix("The Thin Man")
+		orphan = rootNamePrefix("Orphan-trailer")
+		want   = []string{
+			orphan + "Orphan-trailer.mp4", // no title of its own, so it is one
+			orphan + "Orphan-trailer.nfo",
+			prefix + "The Thin Man.iso",
+			prefix + "The Thin Man.nfo",
+			prefix + "The Thin Man-trailer.mp4",
+			prefix + "The Thin Man-extras/",
+		}
+	)
+	if len(items) != len(want) {
+		t.Fatalf("got %v, want %v", items, want)
+	}
+	for i, item := range items {
+		if string(item) != want[i] {
+			t.Errorf("item %d: got %s, want %s", i, item, want[i])
+		}
+	}
+
+	if _, objName := parsePath(want[4]); objName != want[4] {
+		t.Errorf("parsePath(%q) gives object %q", want[4], objName)
+	}
+
+	if got := s.extras("The Thin Man"); len(got) != 2 || got[0] != "Making of.mp4" || got[1] != "Stills.jpg" {
+		t.Errorf("got extras %v", got)
+	}
+
+	for _, c := range []struct {
+		p, rootName, name string
+		ok                bool
+	}{
+		{p: prefix + "The Thin Man-extras", rootName: "The Thin Man", ok: true},
+		{p: "Comedies/" + prefix + "The Thin Man-extras/Making of.mp4", rootName: "The Thin Man", name: "Making of.mp4", ok: true},
+		{p: "The Thin Man-extras/Making of.mp4"},
+		{p: prefix + "The Thin Man.iso"},
+	} {
+		rootName, name, ok := parseExtrasPath(c.p)
+		if rootName != c.rootName || name != c.name || ok != c.ok {
+			t.Errorf("parseExtrasPath(%q) = %q, %q, %v; want %q, %q, %v", c.p, rootName, name, ok, c.rootName, c.name, c.ok)
+		}
+	}
+}
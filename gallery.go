@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// The width in pixels of the poster thumbnails in the gallery.
+const galleryPosterWidth = 300
+
+type galleryKeyType struct{}
+
+var galleryKey galleryKeyType
+
+// isGallery tells whether a request for a directory came through /gallery/,
+// and so wants the gallery rendering of its HTML listing.
+func isGallery(req *http.Request) bool {
+	ok, _ := req.Context().Value(galleryKey).(bool)
+	return ok
+}
+
+// handleGallery serves the directory at the rest of the path
+// (as in /gallery/sets/The%20Thin%20Man%20Collection/)
+// as a grid of posters,
+// for browsing the library in a web browser.
+func (s *server) handleGallery(w http.ResponseWriter, req *http.Request) error {
+	p := strings.Trim(strings.TrimPrefix(req.URL.Path, "/gallery"), "/")
+
+	req = req.WithContext(context.WithValue(req.Context(), galleryKey, true))
+	req.URL = &url.URL{Path: "/" + p, RawQuery: req.URL.RawQuery}
+
+	if s.tv && (p == tvDir || strings.HasPrefix(p, tvDir+"/")) {
+		return s.handleTV(w, req)
+	}
+	if _, rest, ok := parseDiscPath(p); ok && rest == "" {
+		// There is nothing to browse in a disc title's folder.
+		http.Redirect(w, req, (&url.URL{Path: "/" + p + "/"}).EscapedPath(), http.StatusFound)
+		return nil
+	}
+	subdir, objName := parsePath(p)
+	if objName != "" {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("%s is not a directory", p),
+		}
+	}
+	return s.handleDir(w, req, subdir)
+}
+
+// galleryCard is an item in the gallery rendering of a directory:
+// a title, with its poster, or a folder.
+type galleryCard struct {
+	Href   template.URL
+	Title  string
+	Year   int
+	Genres []string
+	Poster string
+	Dir    bool
+}
+
+// writeGallery writes the gallery rendering of a directory listing.
+// Titles link to their player pages,
+// other media objects to themselves,
+// and folders to their own gallery pages.
+// Other files, such as .nfo files and artwork, are left out.
+// The caller must hold s.mu.
+func (s *server) writeGallery(w http.ResponseWriter, req *http.Request, entries []dirEntry, page *dirPage) error {
+	dir := req.URL.EscapedPath()
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+
+	var cards []galleryCard
+	for _, entry := range entries {
+		item := string(entry.Href)
+		if entry.Root != "" {
+			info := s.infoMap[entry.Root]
+			cards = append(cards, galleryCard{
+				Href:   template.URL(watchPath(entry.Root)),
+				Title:  displayTitle(entry.Root, info),
+				Year:   info.Year,
+				Genres: genresOf(info),
+				Poster: s.galleryPoster(entry.Root, info),
+			})
+			continue
+		}
+		if isMediaExt(filepath.Ext(item)) {
+			// An episode in the tv/ folder, for instance.
+			cards = append(cards, galleryCard{
+				Href:  template.URL(dir + escapeItem(item)),
+				Title: strings.TrimSuffix(item, filepath.Ext(item)),
+			})
+			continue
+		}
+		if name, ok := strings.CutSuffix(item, "/"); ok {
+			// Some directory items are escaped and some are not.
+			if u, err := url.PathUnescape(name); err == nil {
+				name = u
+			}
+			cards = append(cards, galleryCard{
+				Href:  template.URL(url.PathEscape(name) + "/"),
+				Title: name,
+				Dir:   true,
+			})
+		}
+	}
+
+	data := struct {
+		Path  string
+		Cards []galleryCard
+		Page  *dirPage
+	}{
+		Path:  strings.Trim(req.URL.Path, "/"),
+		Cards: cards,
+		Page:  page,
+	}
+	buf := new(bytes.Buffer)
+	if err := galleryTemplate.Execute(buf, data); err != nil {
+		return errors.Wrap(err, "rendering gallery")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return s.serveGenerated(w, req, "index.html", buf.Bytes())
+}
+
+// galleryPoster is the URL of a title's poster thumbnail for the gallery,
+// or "" if it has none.
+// The poster is resized by the /thumbs/ handler when it is the title's first thumb;
+// otherwise it is linked as is.
+// The caller must hold s.mu.
+func (s *server) galleryPoster(rootName string, info movieInfo) string {
+	for i, th := range info.Thumbs {
+		if th.Aspect != "poster" {
+			continue
+		}
+		if i > 0 {
+			return th.origVal
+		}
+		return fmt.Sprintf("/thumbs/%s%s?w=%d", url.PathEscape(rootName), filepath.Ext(th.origVal), galleryPosterWidth)
+	}
+	return ""
+}
+
+var galleryTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+ <head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{ or .Path "Library" }}</title>
+  <style>
+   body { font-family: sans-serif; margin: 1em; background: #111; color: #eee; }
+   a { color: inherit; text-decoration: none; }
+   .grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(9em, 1fr)); gap: 1em; }
+   .card img, .card .noposter { width: 100%; aspect-ratio: 2 / 3; object-fit: cover; border-radius: 4px; background: #333; }
+   .card .noposter { display: flex; align-items: center; justify-content: center; font-size: 3em; }
+   .card .title { font-weight: bold; margin-top: 0.3em; }
+   .card .meta { font-size: 0.8em; color: #aaa; }
+   .pages { margin-top: 1em; text-align: center; }
+  </style>
+ </head>
+ <body>
+  <h1>{{ or .Path "Library" }}</h1>
+  {{ if .Path }}<p><a href="../">&larr; Up</a></p>{{ end }}
+  <div class="grid">
+   {{ range .Cards }}
+    <a class="card" href="{{ .Href }}">
+     {{ if .Poster }}
+      <img src="{{ .Poster }}" alt="" loading="lazy">
+     {{ else }}
+      <div class="noposter">{{ if .Dir }}&#128193;{{ else }}&#127916;{{ end }}</div>
+     {{ end }}
+     <div class="title">{{ .Title }}</div>
+     {{ with .Year }}<div class="meta">{{ . }}</div>{{ end }}
+     {{ with .Genres }}<div class="meta">{{ range $i, $g := . }}{{ if $i }}, {{ end }}{{ $g }}{{ end }}</div>{{ end }}
+    </a>
+   {{ end }}
+  </div>
+  {{ with .Page }}
+   <p class="pages">
+    {{ with .Prev }}<a href="?page={{ . }}">Previous page</a>{{ end }}
+    Page {{ .Page }} of {{ .Pages }}
+    {{ with .Next }}<a href="?page={{ . }}">Next page</a>{{ end }}
+   </p>
+  {{ end }}
+ </body>
+</html>
+`))
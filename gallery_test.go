@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestGallery(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		objNames: set.New(
+			"The Thin Man.mp4",
+			"Top Hat.mp4",
+			"Metropolis/BDMV/index.bdmv",
+		),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"The Thin Man": {
+				Title:  "The Thin Man",
+				Year:   1934,
+				Genre:  "Comedy; Mystery",
+				Thumbs: []thumb{{Aspect: "poster", Val: "http://x/thumbs/The%20Thin%20Man.jpg", origVal: "https://example.com/thinman.jpg"}},
+				Set:    &movieSet{Name: "The Thin Man Collection"},
+			},
+			"Metropolis": {Title: "Metropolis", Year: 1927},
+		},
+		infoMapTime: now,
+		sets:        true,
+	}
+
+	rec := httptest.NewRecorder()
+	if err := s.handleGallery(rec, httptest.NewRequest("GET", "/gallery/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`href="/watch/Metropolis"`,
+		`<div class="meta">1927</div>`,
+		`<div class="title">Top Hat</div>`,
+		`href="sets/"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("gallery lacks %s", want)
+		}
+	}
+	if strings.Contains(body, ".nfo") {
+		t.Error("gallery lists .nfo files")
+	}
+
+	rec = httptest.NewRecorder()
+	if err := s.handleGallery(rec, httptest.NewRequest("GET", "/gallery/sets/The%20Thin%20Man%20Collection/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	body = rec.Body.String()
+	for _, want := range []string{
+		`href="/watch/The%20Thin%20Man"`,
+		`<div class="title">The Thin Man</div>`,
+		`<div class="meta">1934</div>`,
+		`<div class="meta">Comedy, Mystery</div>`,
+		`src="/thumbs/The%20Thin%20Man.jpg?w=300"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("set gallery lacks %s", want)
+		}
+	}
+	if strings.Contains(body, "Top Hat") {
+		t.Error("set gallery lists Top Hat")
+	}
+}
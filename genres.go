@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// The virtual directory under which titles are listed by genre, in -genres mode.
+const genresDir = "genres"
+
+// genresOf returns the genres of a title,
+// which are separated by semicolons or commas in the genre column.
+func genresOf(info movieInfo) []string {
+	var result []string
+	for _, g := range strings.FieldsFunc(info.Genre, func(r rune) bool { return r == ';' || r == ',' }) {
+		if g = strings.TrimSpace(g); g != "" {
+			result = append(result, g)
+		}
+	}
+	return result
+}
+
+// hasGenre tells whether one of a title's genres is the given one, ignoring case.
+func hasGenre(info movieInfo, genre string) bool {
+	for _, g := range genresOf(info) {
+		if strings.EqualFold(g, genre) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasGenres tells whether any visible title has a genre.
+// The caller must hold s.mu.
+func (s *server) hasGenres() bool {
+	for _, info := range s.infoMap {
+		if !info.hidden && len(genresOf(info)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// handleGenreDir serves the list of genres (when genre is empty)
+// or the titles in one genre.
+// Unlike a movie set's titles,
+// these are also listed in their usual folders,
+// and a title with several genres is listed in each of them.
+func (s *server) handleGenreDir(w http.ResponseWriter, req *http.Request, genre string) error {
+	log.Printf("serving genre directory \"%s\"", genre)
+
+	ctx := req.Context()
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if genre == "" {
+		// Genres differing only in case share a folder,
+		// named for the first spelling in sorted order.
+		names := make(map[string]string)
+		for _, info := range s.infoMap {
+			if info.hidden {
+				continue
+			}
+			for _, g := range genresOf(info) {
+				name := virtualDirName(g)
+				key := strings.ToLower(name)
+				if other, ok := names[key]; !ok || name < other {
+					names[key] = name
+				}
+			}
+		}
+		var sorted []string
+		for _, name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+		var items []template.URL
+		for _, name := range sorted {
+			items = append(items, template.URL(url.PathEscape(name)+"/"))
+		}
+		return s.writeDir(w, req, items)
+	}
+
+	items := s.titleItems(s.accessible(ctx, func(info movieInfo, ok bool) bool {
+		if !ok {
+			return false
+		}
+		for _, g := range genresOf(info) {
+			if strings.EqualFold(virtualDirName(g), genre) {
+				return true
+			}
+		}
+		return false
+	}))
+	if len(items) == 0 {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no genre %s", genre),
+		}
+	}
+
+	return s.writeDir(w, req, items)
+}
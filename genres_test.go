@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestGenreDirs(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		dirTemplate:  template.Must(template.New("").Parse(dirTemplate)),
+		objNames:     set.New("The Thin Man.iso", "Top Hat.mp4", "Psycho.mp4"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"The Thin Man": {Title: "The Thin Man", Genre: "Comedy; Mystery"},
+			"Top Hat":      {Title: "Top Hat", Genre: "comedy, Musical"},
+			"Psycho":       {Title: "Psycho", Genre: "Horror/Thriller"},
+		},
+		infoMapTime: now,
+		genres:      true,
+	}
+
+	dir := func(path string) []string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := s.handleAPIDir(rec, httptest.NewRequest("GET", "/api/dir/"+path, nil)); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		var got apiDir
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range got.Entries {
+			names = append(names, e.Name)
+		}
+		return names
+	}
+
+	check := func(path string, want ...string) {
+		t.Helper()
+		got := dir(path)
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %v, want %v", path, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: entry %d is %s, want %s", path, i, got[i], want[i])
+			}
+		}
+	}
+
+	if got := dir(""); got[len(got)-1] != "genres" {
+		t.Errorf("no genres folder in %v", got)
+	}
+	check("genres/", "Comedy", "Horror-Thriller", "Musical", "Mystery")
+
+	var (
+		thinMan = rootNamePrefix("The Thin Man")
+		topHat  = rootNamePrefix("Top Hat")
+	)
+	check("genres/Comedy/", thinMan+"The Thin Man.iso", thinMan+"The Thin Man.nfo", topHat+"Top Hat.mp4", topHat+"Top Hat.nfo")
+	check("genres/Horror-Thriller/", rootNamePrefix("Psycho")+"Psycho.mp4", rootNamePrefix("Psycho")+"Psycho.nfo")
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+// Kinds of grant.
+const (
+	grantShare    = "share"    // a link to a single title
+	grantToken    = "token"    // an API or device token
+	grantSession  = "session"  // a browser session
+	grantPlaylist = "playlist" // the stream URLs in a library playlist
+)
+
+// The bucket object in which grants are persisted.
+const grantsObjName = "kodigcs/grants.json"
+
+const (
+	grantsCleanupInterval = time.Minute
+	grantsSyncInterval    = time.Minute
+
+	// A grant's LastUsed time is updated only this often,
+	// so that using a grant does not mean rewriting the bucket object every minute.
+	grantLastUsedGranularity = time.Hour
+)
+
+// A grant is a credential issued by the server:
+// a share link, a token, or a session.
+// Only a hash of the grant's secret is kept.
+type grant struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`              // the principal the grant authenticates as
+	Subject  string    `json:"subject,omitempty"` // what the grant is for, e.g. the title of a share link
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires,omitempty"`   // zero means never
+	LastUsed time.Time `json:"last_used,omitempty"` // to within grantLastUsedGranularity
+}
+
+func (g *grant) expired(now time.Time) bool {
+	return !g.Expires.IsZero() && now.After(g.Expires)
+}
+
+// grantStore holds the server's grants,
+// persisting them to the bucket
+// and discarding them when they expire.
+//
+// More than one process may use the bucket object at once
+// (e.g. the server, and a subcommand issuing a token),
+// so the store is synced with it,
+// merging changes from both sides.
+type grantStore struct {
+	obj *storage.ObjectHandle
+
+	mu      sync.Mutex
+	grants  map[string]*grant // keyed by secretHash
+	known   set.Of[string]    // hashes present in the bucket object as of the last sync
+	deleted set.Of[string]    // hashes revoked or expired since the last sync
+	dirty   bool
+}
+
+func newGrantStore(bucket *storage.BucketHandle) *grantStore {
+	return &grantStore{
+		obj:     bucket.Object(grantsObjName),
+		grants:  make(map[string]*grant),
+		known:   set.New[string](),
+		deleted: set.New[string](),
+	}
+}
+
+func secretHash(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// read reads the grants in the bucket object,
+// returning them and the precondition for replacing them.
+func (gs *grantStore) read(ctx context.Context) (map[string]*grant, storage.Conditions, error) {
+	r, err := gs.obj.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, storage.Conditions{DoesNotExist: true}, nil
+	}
+	if err != nil {
+		return nil, storage.Conditions{}, errors.Wrapf(err, "reading %s", grantsObjName)
+	}
+	defer r.Close()
+
+	grants := make(map[string]*grant)
+	if err := json.NewDecoder(r).Decode(&grants); err != nil {
+		return nil, storage.Conditions{}, errors.Wrapf(err, "decoding %s", grantsObjName)
+	}
+	return grants, storage.Conditions{GenerationMatch: r.Attrs.Generation}, nil
+}
+
+// sync merges the grants in the bucket object with those in memory
+// and writes the result back if it differs.
+// Grants added or removed by other processes since the last sync are added or removed here,
+// and vice versa.
+func (gs *grantStore) sync(ctx context.Context) error {
+	for tries := 0; ; tries++ {
+		remote, cond, err := gs.read(ctx)
+		if err != nil {
+			return err
+		}
+
+		// The merged grants are computed and encoded under the lock,
+		// but the lock is not held while writing them,
+		// so that lookups don't wait on the bucket.
+		// Changes made meanwhile are kept for the next sync.
+		gs.mu.Lock()
+
+		var (
+			merged  = make(map[string]*grant)
+			changed = gs.dirty || gs.deleted.Len() > 0
+			before  = set.Collect(maps.Keys(gs.grants))
+			deleted = set.Collect(gs.deleted.All())
+		)
+		for hash, g := range remote {
+			if gs.deleted.Has(hash) {
+				continue
+			}
+			if local, ok := gs.grants[hash]; ok {
+				if g.LastUsed.After(local.LastUsed) {
+					local.LastUsed = g.LastUsed
+				}
+				merged[hash] = local
+			} else if !gs.known.Has(hash) {
+				merged[hash] = g // added elsewhere
+			}
+		}
+		for hash, g := range gs.grants {
+			if _, ok := remote[hash]; ok {
+				continue
+			}
+			if gs.known.Has(hash) {
+				continue // removed elsewhere
+			}
+			merged[hash] = g
+			changed = true
+		}
+
+		var buf []byte
+		if changed {
+			if buf, err = json.Marshal(merged); err != nil {
+				gs.mu.Unlock()
+				return errors.Wrap(err, "encoding grants")
+			}
+		}
+		gs.dirty = false
+		gs.mu.Unlock()
+
+		if changed {
+			w := gs.obj.If(cond).NewWriter(ctx)
+			w.ContentType = "application/json"
+			_, err = w.Write(buf)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				gs.mu.Lock()
+				gs.dirty = true
+				gs.mu.Unlock()
+				if tries < 3 && isPreconditionFailed(err) {
+					continue
+				}
+				return errors.Wrapf(err, "writing %s", grantsObjName)
+			}
+		}
+
+		gs.mu.Lock()
+		for hash := range gs.grants {
+			if _, ok := merged[hash]; !ok && before.Has(hash) {
+				delete(gs.grants, hash) // removed elsewhere
+			}
+		}
+		for hash, g := range merged {
+			if _, ok := gs.grants[hash]; !ok && !before.Has(hash) && !gs.deleted.Has(hash) {
+				gs.grants[hash] = g // added elsewhere
+			}
+		}
+		gs.known = set.Collect(maps.Keys(merged))
+		gs.deleted.Del(deleted.Slice()...)
+		gs.mu.Unlock()
+
+		return nil
+	}
+}
+
+// issue creates a new grant and returns its secret.
+// A ttl of zero means the grant does not expire.
+func (gs *grantStore) issue(kind, name, subject string, ttl time.Duration) (string, *grant, error) {
+	var buf [32]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", nil, errors.Wrap(err, "generating secret")
+	}
+	secret := base64.RawURLEncoding.EncodeToString(buf[:])
+	hash := secretHash(secret)
+
+	now := time.Now()
+	g := &grant{
+		ID:      hash[:12],
+		Kind:    kind,
+		Name:    name,
+		Subject: subject,
+		Created: now,
+	}
+	if ttl > 0 {
+		g.Expires = now.Add(ttl)
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	gs.grants[hash] = g
+	gs.dirty = true
+
+	return secret, g, nil
+}
+
+// lookup finds the unexpired grant of the given kind with the given secret.
+func (gs *grantStore) lookup(kind, secret string) (grant, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	g, ok := gs.grants[secretHash(secret)]
+	if !ok || g.Kind != kind {
+		return grant{}, false
+	}
+	now := time.Now()
+	if g.expired(now) {
+		return grant{}, false
+	}
+	if now.Sub(g.LastUsed) >= grantLastUsedGranularity {
+		g.LastUsed = now
+		gs.dirty = true
+	}
+	return *g, true
+}
+
+// revoke removes the grant with the given ID.
+func (gs *grantStore) revoke(id string) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	for hash, g := range gs.grants {
+		if g.ID == id {
+			delete(gs.grants, hash)
+			gs.deleted.Add(hash)
+			return true
+		}
+	}
+	return false
+}
+
+// revokeNames revokes the grants of the named principals:
+// those that authenticate as one of them,
+// and the share links, cast links, and playlist grants that one of them created.
+// It returns the number revoked.
+func (gs *grantStore) revokeNames(names set.Of[string]) int {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	var n int
+	for hash, g := range gs.grants {
+		if names.Has(grantOwner(g.Name)) {
+			delete(gs.grants, hash)
+			gs.deleted.Add(hash)
+			n++
+		}
+	}
+	return n
+}
+
+// grantCreatorPrefixes are the prefixes of the names of grants that users create,
+// followed by a colon and the user's name (see e.g. share.go).
+var grantCreatorPrefixes = set.New("share", "cast", "playlist")
+
+// grantOwner is the principal a grant with the given name belongs to:
+// the user who created it, for a share link and the like,
+// or else the name itself.
+func grantOwner(name string) string {
+	if prefix, owner, ok := strings.Cut(name, ":"); ok && grantCreatorPrefixes.Has(prefix) {
+		return owner
+	}
+	return name
+}
+
+// list returns the unexpired grants, newest first.
+func (gs *grantStore) list() []grant {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	var (
+		result []grant
+		now    = time.Now()
+	)
+	for _, g := range gs.grants {
+		if !g.expired(now) {
+			result = append(result, *g)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Created.After(result[j].Created) })
+	return result
+}
+
+// cleanup discards expired grants.
+func (gs *grantStore) cleanup() int {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	var (
+		n   int
+		now = time.Now()
+	)
+	for hash, g := range gs.grants {
+		if g.expired(now) {
+			delete(gs.grants, hash)
+			gs.deleted.Add(hash)
+			n++
+		}
+	}
+	return n
+}
+
+// run periodically discards expired grants and syncs with the bucket,
+// until the context is canceled.
+// The caller should sync once more after that.
+func (gs *grantStore) run(ctx context.Context) {
+	var (
+		cleanupTicker = time.NewTicker(grantsCleanupInterval)
+		syncTicker    = time.NewTicker(grantsSyncInterval)
+	)
+	defer cleanupTicker.Stop()
+	defer syncTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-cleanupTicker.C:
+			if n := gs.cleanup(); n > 0 {
+				log.Printf("Discarded %d expired grant(s)", n)
+			}
+
+		case <-syncTicker.C:
+			if err := gs.sync(ctx); err != nil {
+				log.Printf("Error syncing grants: %s", err)
+			}
+		}
+	}
+}
+
+// vars reports the number of active grants of each kind for expvar.
+func (gs *grantStore) vars() any {
+	counts := map[string]int{grantShare: 0, grantToken: 0, grantSession: 0, grantPlaylist: 0}
+	for _, g := range gs.list() {
+		counts[g.Kind]++
+	}
+	return counts
+}
+
+// handleGrants serves the admin page listing active grants.
+// Only admins may see it (see admin.go).
+func (s *server) handleGrants(w http.ResponseWriter, req *http.Request) error {
+	if err := s.checkAdmin(req.Context()); err != nil {
+		return err
+	}
+
+	if req.Method == http.MethodPost {
+		if err := checkSameOrigin(req); err != nil {
+			return err
+		}
+		id := req.FormValue("revoke")
+		if !s.grants.revoke(id) {
+			return mid.CodeErr{
+				C:   http.StatusNotFound,
+				Err: fmt.Errorf("no grant %s", id),
+			}
+		}
+		log.Printf("%s revoked grant %s", principal(req.Context()), id)
+		http.Redirect(w, req, req.URL.Path, http.StatusSeeOther)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return grantsTemplate.Execute(w, s.grants.list())
+}
+
+// checkSameOrigin rejects state-changing browser requests that come from another site,
+// other than one listed in serve -cors-origins (see headers.go).
+// (Browsers send Basic Auth credentials with cross-site form posts.)
+func checkSameOrigin(req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if corsAllowed(req, origin) {
+		return nil
+	}
+	if origin == "" {
+		origin = req.Header.Get("Referer")
+	}
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != req.Host {
+		return mid.CodeErr{
+			C:   http.StatusForbidden,
+			Err: fmt.Errorf("cross-origin request from %s", origin),
+		}
+	}
+	return nil
+}
+
+var grantsTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+ <head>
+  <title>Grants</title>
+ </head>
+ <body>
+  <h1>Grants</h1>
+  {{ if . }}
+   <table>
+    <tr><th>ID</th><th>Kind</th><th>Name</th><th>Subject</th><th>Created</th><th>Expires</th><th>Last used</th><th></th></tr>
+    {{ range . }}
+     <tr>
+      <td>{{ .ID }}</td>
+      <td>{{ .Kind }}</td>
+      <td>{{ .Name }}</td>
+      <td>{{ .Subject }}</td>
+      <td>{{ .Created.Format "2006-01-02 15:04" }}</td>
+      <td>{{ if .Expires.IsZero }}never{{ else }}{{ .Expires.Format "2006-01-02 15:04" }}{{ end }}</td>
+      <td>{{ if .LastUsed.IsZero }}never{{ else }}{{ .LastUsed.Format "2006-01-02 15:04" }}{{ end }}</td>
+      <td>
+       <form method="POST">
+        <input type="hidden" name="revoke" value="{{ .ID }}">
+        <input type="submit" value="Revoke">
+       </form>
+      </td>
+     </tr>
+    {{ end }}
+   </table>
+  {{ else }}
+   <p>No active grants.</p>
+  {{ end }}
+ </body>
+</html>
+`))
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/go-generics/v4/set"
+	"google.golang.org/api/option"
+)
+
+// fakeGCS is a fake of the parts of the GCS API
+// used for kodigcs's own objects:
+// reading, writing, composing, and deleting objects,
+// with generation preconditions,
+// and getting their attributes.
+type fakeGCS struct {
+	mu   sync.Mutex
+	objs map[string]fakeObj
+	gen  int64
+
+	// If not nil, onWrite is called before each upload is handled.
+	onWrite func()
+
+	// If not zero, every request fails with this status.
+	status int
+
+	// The number of compose requests handled.
+	composes int
+}
+
+type fakeObj struct {
+	content  []byte
+	gen      int64
+	metadata map[string]string
+}
+
+// newFakeGCS starts a fakeGCS and returns a handle on its bucket, "media".
+func newFakeGCS(t *testing.T) (*fakeGCS, *storage.BucketHandle) {
+	f := &fakeGCS{objs: make(map[string]fakeObj)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return f, client.Bucket("media")
+}
+
+func (f *fakeGCS) get(name string) ([]byte, int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj := f.objs[name]
+	return obj.content, obj.gen
+}
+
+func (f *fakeGCS) fail(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+func (f *fakeGCS) put(name string, content []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gen++
+	f.objs[name] = fakeObj{content: content, gen: f.gen}
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if f.onWrite != nil && req.Method == "POST" {
+		f.onWrite()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.status != 0 {
+		http.Error(w, http.StatusText(f.status), f.status)
+		return
+	}
+
+	precondition := func(name string) bool {
+		s := req.URL.Query().Get("ifGenerationMatch")
+		if s == "" {
+			return true
+		}
+		want, _ := strconv.ParseInt(s, 10, 64)
+		return want == f.objs[name].gen
+	}
+
+	switch {
+	case req.Method == "POST" && strings.HasPrefix(req.URL.Path, "/upload/"):
+		_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var (
+			mr    = multipart.NewReader(req.Body, params["boundary"])
+			parts [][]byte
+		)
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			b, _ := io.ReadAll(p)
+			parts = append(parts, b)
+		}
+		var attrs struct {
+			Name     string
+			Metadata map[string]string
+		}
+		if len(parts) != 2 || json.Unmarshal(parts[0], &attrs) != nil {
+			http.Error(w, "bad upload", http.StatusBadRequest)
+			return
+		}
+		if !precondition(attrs.Name) {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		f.gen++
+		f.objs[attrs.Name] = fakeObj{content: parts[1], gen: f.gen, metadata: attrs.Metadata}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"bucket": "media", "name": attrs.Name, "generation": strconv.FormatInt(f.gen, 10)})
+
+	case req.Method == "POST" && strings.HasSuffix(req.URL.Path, "/compose"):
+		name := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/storage/v1/b/media/o/"), "/compose")
+		var compose struct {
+			Destination struct {
+				Metadata map[string]string
+			}
+			SourceObjects []struct {
+				Name       string
+				Generation int64 `json:",string"`
+			}
+		}
+		if err := json.NewDecoder(req.Body).Decode(&compose); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !precondition(name) {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		var content []byte
+		for _, src := range compose.SourceObjects {
+			obj, ok := f.objs[src.Name]
+			if !ok || (src.Generation != 0 && src.Generation != obj.gen) {
+				http.NotFound(w, req)
+				return
+			}
+			content = append(content, obj.content...)
+		}
+		f.composes++
+		f.gen++
+		f.objs[name] = fakeObj{content: content, gen: f.gen, metadata: compose.Destination.Metadata}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"bucket": "media", "name": name, "generation": strconv.FormatInt(f.gen, 10)})
+
+	case req.Method == "DELETE":
+		name, _ := url.PathUnescape(req.URL.EscapedPath()[strings.LastIndex(req.URL.EscapedPath(), "/o/")+3:])
+		if _, ok := f.objs[name]; !ok {
+			http.NotFound(w, req)
+			return
+		}
+		if !precondition(name) {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		delete(f.objs, name)
+		w.WriteHeader(http.StatusNoContent)
+
+	case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/storage/v1/b/media/o/"):
+		name := strings.TrimPrefix(req.URL.Path, "/storage/v1/b/media/o/")
+		obj, ok := f.objs[name]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"bucket":     "media",
+			"name":       name,
+			"size":       strconv.Itoa(len(obj.content)),
+			"generation": strconv.FormatInt(obj.gen, 10),
+			"metadata":   obj.metadata,
+		})
+
+	case req.Method == "GET":
+		name := strings.TrimPrefix(req.URL.Path, "/media/")
+		obj, ok := f.objs[name]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("X-Goog-Generation", strconv.FormatInt(obj.gen, 10))
+		w.Write(obj.content)
+
+	default:
+		http.Error(w, fmt.Sprintf("unexpected %s %s", req.Method, req.URL), http.StatusBadRequest)
+	}
+}
+
+func TestGrantsSync(t *testing.T) {
+	var (
+		now  = time.Now()
+		hour = time.Hour
+	)
+	g := func(id string) *grant {
+		return &grant{ID: id, Kind: grantToken, Name: id, Created: now}
+	}
+
+	cases := []struct {
+		name    string
+		remote  map[string]*grant // in the bucket
+		local   map[string]*grant // in memory
+		known   []string          // hashes in the bucket at the last sync
+		deleted []string          // hashes revoked here since the last sync
+		want    []string          // hashes after syncing, both here and in the bucket
+	}{{
+		name: "empty",
+	}, {
+		name:  "issued here",
+		local: map[string]*grant{"a": g("a")},
+		want:  []string{"a"},
+	}, {
+		name:   "issued elsewhere",
+		remote: map[string]*grant{"a": g("a")},
+		want:   []string{"a"},
+	}, {
+		name:   "unchanged",
+		remote: map[string]*grant{"a": g("a")},
+		local:  map[string]*grant{"a": g("a")},
+		known:  []string{"a"},
+		want:   []string{"a"},
+	}, {
+		name:    "revoked here",
+		remote:  map[string]*grant{"a": g("a"), "b": g("b")},
+		local:   map[string]*grant{"b": g("b")},
+		known:   []string{"a", "b"},
+		deleted: []string{"a"},
+		want:    []string{"b"},
+	}, {
+		name:   "revoked elsewhere",
+		remote: map[string]*grant{"b": g("b")},
+		local:  map[string]*grant{"a": g("a"), "b": g("b")},
+		known:  []string{"a", "b"},
+		want:   []string{"b"},
+	}, {
+		name:    "both",
+		remote:  map[string]*grant{"a": g("a"), "c": g("c")},
+		local:   map[string]*grant{"b": g("b"), "d": g("d")},
+		known:   []string{"a", "b"},
+		deleted: []string{"a"},
+		want:    []string{"c", "d"},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, bucket := newFakeGCS(t)
+			if c.remote != nil {
+				buf, err := json.Marshal(c.remote)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.put(grantsObjName, buf)
+			}
+
+			gs := newGrantStore(bucket)
+			if c.local != nil {
+				gs.grants = c.local
+				gs.dirty = true
+			}
+			gs.known = set.New(c.known...)
+			gs.deleted = set.New(c.deleted...)
+
+			if err := gs.sync(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			want := set.New(c.want...)
+			if got := set.Collect(maps.Keys(gs.grants)); !got.Equal(want) {
+				t.Errorf("got %v in memory, want %v", got.Slice(), c.want)
+			}
+			if !gs.known.Equal(want) {
+				t.Errorf("got %v known, want %v", gs.known.Slice(), c.want)
+			}
+			if gs.deleted.Len() != 0 || gs.dirty {
+				t.Error("still dirty after syncing")
+			}
+
+			buf, _ := f.get(grantsObjName)
+			var stored map[string]*grant
+			if len(buf) > 0 {
+				if err := json.Unmarshal(buf, &stored); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := set.Collect(maps.Keys(stored)); !got.Equal(want) {
+				t.Errorf("got %v in the bucket, want %v", got.Slice(), c.want)
+			}
+		})
+	}
+
+	// The latest LastUsed wins.
+	_, bucket := newFakeGCS(t)
+	gs := newGrantStore(bucket)
+	secret, issued, err := gs.issue(grantToken, "phone", "", hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	other := newGrantStore(bucket)
+	if err := other.sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := other.lookup(grantToken, secret); !ok {
+		t.Fatal("grant issued by another process not found")
+	}
+	if err := other.sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := gs.list(); len(got) != 1 || got[0].ID != issued.ID || got[0].LastUsed.IsZero() {
+		t.Errorf("got %+v, want the grant with its use elsewhere", got)
+	}
+}
+
+func TestGrantsSyncUnlocked(t *testing.T) {
+	ctx := context.Background()
+	f, bucket := newFakeGCS(t)
+	gs := newGrantStore(bucket)
+	if _, _, err := gs.issue(grantToken, "phone", "", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A grant issued and another revoked while the bucket object is being written
+	// (which would deadlock if sync held the lock)
+	// are kept for the next sync.
+	revoked, _, err := gs.issue(grantToken, "tablet", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var issued string
+	f.onWrite = func() {
+		f.onWrite = nil
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			g, _ := gs.lookup(grantToken, revoked)
+			gs.revoke(g.ID)
+			issued, _, _ = gs.issue(grantToken, "laptop", "", 0)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Error("grant store locked while writing")
+		}
+	}
+	if err := gs.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gs.lookup(grantToken, issued); !ok {
+		t.Fatal("grant issued during sync lost")
+	}
+	if !gs.dirty || gs.deleted.Len() != 1 {
+		t.Error("changes made during sync not kept for the next one")
+	}
+
+	if err := gs.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	other := newGrantStore(bucket)
+	if err := other.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, g := range other.list() {
+		names = append(names, g.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"laptop", "phone"}; !slices.Equal(names, want) {
+		t.Errorf("got %v in the bucket, want %v", names, want)
+	}
+}
+
+func TestGrantsLastUsed(t *testing.T) {
+	gs := &grantStore{
+		grants:  make(map[string]*grant),
+		known:   set.New[string](),
+		deleted: set.New[string](),
+	}
+	secret, g, err := gs.issue(grantToken, "phone", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		lastUsed  time.Duration // before now
+		wantDirty bool
+	}{
+		{name: "never used", lastUsed: -1, wantDirty: true},
+		{name: "just now", lastUsed: time.Minute},
+		{name: "a while ago", lastUsed: 2 * grantLastUsedGranularity, wantDirty: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.lastUsed < 0 {
+				g.LastUsed = time.Time{}
+			} else {
+				g.LastUsed = time.Now().Add(-c.lastUsed)
+			}
+			gs.dirty = false
+			before := g.LastUsed
+			if _, ok := gs.lookup(grantToken, secret); !ok {
+				t.Fatal("not found")
+			}
+			if gs.dirty != c.wantDirty || g.LastUsed.Equal(before) == c.wantDirty {
+				t.Errorf("got dirty %v and last used %s, was %s", gs.dirty, g.LastUsed, before)
+			}
+		})
+	}
+}
+
+func TestGrantsExpiry(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name        string
+		expires     time.Time
+		wantLookup  bool
+		wantCleanup int
+	}{
+		{name: "never", wantLookup: true},
+		{name: "later", expires: now.Add(time.Hour), wantLookup: true},
+		{name: "past", expires: now.Add(-time.Second), wantCleanup: 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gs := &grantStore{
+				grants:  make(map[string]*grant),
+				known:   set.New[string](),
+				deleted: set.New[string](),
+			}
+			secret, g, err := gs.issue(grantToken, "phone", "", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g.Expires = c.expires
+
+			if _, ok := gs.lookup(grantShare, secret); ok {
+				t.Error("found a token as a share link")
+			}
+			if _, ok := gs.lookup(grantToken, "wrong"); ok {
+				t.Error("found a grant with the wrong secret")
+			}
+			if _, ok := gs.lookup(grantToken, secret); ok != c.wantLookup {
+				t.Errorf("got %v for the lookup, want %v", ok, c.wantLookup)
+			}
+			if got := len(gs.list()); got != 1-c.wantCleanup {
+				t.Errorf("got %d listed, want %d", got, 1-c.wantCleanup)
+			}
+
+			if n := gs.cleanup(); n != c.wantCleanup {
+				t.Errorf("cleaned up %d, want %d", n, c.wantCleanup)
+			}
+			if got := gs.deleted.Len(); got != c.wantCleanup {
+				t.Errorf("got %d deleted, want %d", got, c.wantCleanup)
+			}
+		})
+	}
+}
+
+func TestGrantsRevoke(t *testing.T) {
+	gs := &grantStore{
+		grants:  make(map[string]*grant),
+		known:   set.New[string](),
+		deleted: set.New[string](),
+	}
+	secret, g, err := gs.issue(grantSession, "nora", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		id   string
+		want bool
+	}{
+		{id: "nonexistent", want: false},
+		{id: g.ID, want: true},
+		{id: g.ID, want: false}, // already revoked
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case_%02d", i+1), func(t *testing.T) {
+			if got := gs.revoke(c.id); got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+	if _, ok := gs.lookup(grantSession, secret); ok {
+		t.Error("found a revoked grant")
+	}
+	if !gs.deleted.Has(secretHash(secret)) {
+		t.Error("revoked grant not marked deleted")
+	}
+}
+
+func TestHandleGrants(t *testing.T) {
+	gs := &grantStore{
+		grants:  make(map[string]*grant),
+		known:   set.New[string](),
+		deleted: set.New[string](),
+	}
+	_, g, err := gs.issue(grantToken, "alice-phone", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{grants: gs, admins: parseAdmins("", "nora")}
+
+	do := func(who, method string) int {
+		var body io.Reader
+		if method == "POST" {
+			body = strings.NewReader(url.Values{"revoke": {g.ID}}.Encode())
+		}
+		req := httptest.NewRequest(method, "/admin/grants", body)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req = req.WithContext(context.WithValue(req.Context(), principalKey, who))
+		rec := httptest.NewRecorder()
+		if err := s.handleGrants(rec, req); err != nil {
+			return errorCode(err)
+		}
+		return rec.Code
+	}
+
+	for _, who := range []string{"", "alice"} {
+		if code := do(who, "GET"); code != http.StatusForbidden {
+			t.Errorf("got status %d listing grants as %q", code, who)
+		}
+		if code := do(who, "POST"); code != http.StatusForbidden {
+			t.Errorf("got status %d revoking a grant as %q", code, who)
+		}
+	}
+	if len(gs.list()) != 1 {
+		t.Fatal("grant revoked by a non-admin")
+	}
+
+	if code := do("nora", "GET"); code != http.StatusOK {
+		t.Errorf("got status %d listing grants as the admin", code)
+	}
+	if code := do("nora", "POST"); code != http.StatusSeeOther || len(gs.list()) != 0 {
+		t.Errorf("got status %d revoking a grant as the admin", code)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+)
+
+// guessThreshold is the confidence a title search result needs
+// for ssupdate -guess to accept it.
+const guessThreshold = 0.8
+
+// bestGuess picks the search result that best matches the given title and year
+// (which may be zero if unknown),
+// and says how confident it is in the choice, from 0 to 1.
+func bestGuess(results []searchResult, title string, year int) (searchResult, float64) {
+	var (
+		best      searchResult
+		bestScore float64
+		ties      int
+	)
+	for _, r := range results {
+		score := titleSimilarity(title, r.Title)
+		switch {
+		case year == 0 || r.Year == 0:
+			score *= 0.9
+		case r.Year == year:
+			// no penalty
+		case r.Year == year-1 || r.Year == year+1:
+			score *= 0.8 // release dates differ by country
+		default:
+			score *= 0.5
+		}
+
+		switch {
+		case score > bestScore:
+			best, bestScore, ties = r, score, 1
+		case score == bestScore && score > 0:
+			ties++
+		}
+	}
+	if ties > 1 {
+		// Several results (e.g. a film and its remake) match equally well.
+		bestScore /= float64(ties)
+	}
+	return best, bestScore
+}
+
+// titleSimilarity is the Jaccard similarity of the words in two titles,
+// ignoring case, punctuation, and leading articles.
+func titleSimilarity(a, b string) float64 {
+	aw, bw := titleWords(a), titleWords(b)
+	union := set.Union(aw, bw).Len()
+	if union == 0 {
+		return 0
+	}
+	return float64(set.Intersect(aw, bw).Len()) / float64(union)
+}
+
+func titleWords(title string) set.Of[string] {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > 1 {
+		switch words[0] {
+		case "the", "a", "an":
+			words = words[1:]
+		}
+	}
+	return set.New(words...)
+}
+
+// guessID searches for the IMDb ID of the title in the named spreadsheet row.
+// It returns the empty string if no result is a confident enough match.
+func guessID(ctx context.Context, scraper Scraper, name, title string, year int) (string, error) {
+	log.Printf("Searching for %s (%q, year %d)...", name, title, year)
+
+	results, err := scraper.SearchByTitle(ctx, title, year)
+	if err != nil {
+		return "", errors.Wrapf(err, "searching for %s", name)
+	}
+	if len(results) == 0 && year > 0 {
+		// The year in the sheet may be off by one.
+		results, err = scraper.SearchByTitle(ctx, title, 0)
+		if err != nil {
+			return "", errors.Wrapf(err, "searching for %s", name)
+		}
+	}
+
+	best, confidence := bestGuess(results, title, year)
+	if confidence < guessThreshold {
+		log.Printf("No confident match for %s (best was %s, %q (%d), confidence %.2f)", name, best.ID, best.Title, best.Year, confidence)
+		return "", nil
+	}
+
+	log.Printf("Guessed %s for %s: %q (%d), confidence %.2f; please review", best.ID, name, best.Title, best.Year, confidence)
+	return best.ID, nil
+}
@@ -1,29 +1,37 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/xml"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"cloud.google.com/go/storage"
 	"github.com/bobg/bib"
 	"github.com/bobg/errors"
 	"github.com/bobg/gcsobj"
 	"github.com/bobg/go-generics/v4/set"
-	"github.com/bobg/go-generics/v4/slices"
 	"github.com/bobg/mid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/api/iterator"
 )
 
 func (s *server) handle(w http.ResponseWriter, req *http.Request) error {
-	if err := s.checkAuth(w, req); err != nil {
+	if done, err := checkMethod(w, req); done || err != nil {
 		return err
 	}
 
@@ -43,48 +51,157 @@ func (s *server) handle(w http.ResponseWriter, req *http.Request) error {
 		s.mu.RLock()
 		defer s.mu.RUnlock()
 
-		return mid.RespondJSON(w, s.infoMap)
+		return mid.RespondJSON(w, s.viewableInfoMap(ctx))
 	}
 
-	subdir, objname, err := s.parsePath(ctx, path)
-	if err != nil {
-		return errors.Wrapf(err, "parsing path %s", path)
+	if rootName, name, ok := parseExtrasPath(path); ok {
+		return s.handleExtras(ctx, w, req, rootName, name)
+	}
+	if rootName, rest, ok := parseDiscPath(path); ok {
+		return s.handleDisc(ctx, w, req, rootName, rest)
 	}
 
+	subdir, objname := parsePath(path)
+
 	if objname == "" {
 		return s.handleDir(w, req, subdir)
 	}
 
 	objname = objname[8:] // remove 7-byte hash prefix plus "-"
 
-	if strings.HasSuffix(objname, ".nfo") {
+	if err := s.checkHidden(ctx, objname); err != nil {
+		return err
+	}
+	if err := s.checkAccess(ctx, objname); err != nil {
+		return err
+	}
+
+	if req.Method == "PROPFIND" {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.propfindFile(w, req, objname)
+	}
+
+	switch filepath.Ext(objname) {
+	case ".nfo":
 		return s.handleNFO(w, req, objname)
+	case ".m3u":
+		return s.handleM3U(w, req, objname)
+	}
+	if _, _, ok := parseArtworkName(objname); ok {
+		return s.handleArtwork(ctx, w, req, objname, path)
 	}
 
-	err = s.serveObj(ctx, w, req, objname, path, s.verbose)
+	s.auditStream(req, objname)
+	err := s.serveObj(ctx, w, req, objname, path, s.isVerbose())
+	if isStreamFailure(err) {
+		s.health.streamFailures.Add(1)
+	}
 	return errors.Wrap(err, "serving object")
 }
 
-func (s *server) checkAuth(w http.ResponseWriter, req *http.Request) error {
-	if s.username == "" || s.password == "" {
-		return nil
+// headOf is the objHead for an object with the given attributes.
+func headOf(attrs *storage.ObjectAttrs) objHead {
+	modTime := attrs.Created
+	if attrs.Updated.After(modTime) {
+		modTime = attrs.Updated
+	}
+	return objHead{size: attrs.Size, modTime: modTime, etag: attrs.Etag}
+}
+
+// objMIMEType is the Content-Type for the named object.
+// It's the same for GET and HEAD requests,
+// so it doesn't depend on the object's contents.
+func objMIMEType(objName string) string {
+	if t, ok := subtitleTypes[strings.ToLower(filepath.Ext(objName))]; ok {
+		return t
 	}
+	if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(objName))); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
 
-	username, password, ok := req.BasicAuth()
-	if !ok {
-		w.Header().Add("WWW-Authenticate", `Basic realm="Access to list and stream titles"`)
-		return mid.CodeErr{C: http.StatusUnauthorized}
+// headContent stands for the content of an object of the given size
+// in a response to a HEAD request,
+// which has no body.
+// It can seek but not read.
+type headContent struct {
+	size, off int64
+}
+
+func (h *headContent) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("cannot read content for a HEAD request")
+}
+
+func (h *headContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += h.off
+	case io.SeekEnd:
+		offset += h.size
+	default:
+		return 0, fmt.Errorf("bad whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
 	}
+	h.off = offset
+	return offset, nil
+}
 
-	if username != s.username || password != s.password {
-		log.Printf("Unauthorized access attempt from %s (username %s, password %s)", req.RemoteAddr, username, password)
-		return mid.CodeErr{C: http.StatusUnauthorized}
+// checkHidden returns a 404 error if the named object
+// (or the .nfo or .m3u file of that name)
+// belongs to a hidden title.
+func (s *server) checkHidden(ctx context.Context, objName string) error {
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
 	}
 
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.hiddenObj(objName) {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("%s is hidden", objName),
+		}
+	}
 	return nil
 }
 
+// hiddenObj tells whether the named object belongs to a hidden title,
+// either as its media (or poster, etc.)
+// or as one of its parts.
+// The caller must hold s.mu.
+func (s *server) hiddenObj(objName string) bool {
+	for _, rootName := range itemRoots(objName) {
+		if info, ok := s.infoMap[rootName]; ok && info.hidden {
+			return true
+		}
+	}
+	for _, info := range s.infoMap {
+		if !info.hidden || info.parts == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(info.parts, objName); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *server) serveObj(ctx context.Context, w http.ResponseWriter, req *http.Request, objname, path string, verbose bool) (err error) {
+	ctx, span := tracer.Start(ctx, "serveObj", trace.WithAttributes(
+		attribute.String("gcs.object", objname),
+		attribute.String("http.range", req.Header.Get("Range")),
+	))
+	defer func() { endSpan(span, err) }()
+
 	if verbose {
 		defer func() {
 			if err == nil {
@@ -94,14 +211,51 @@ func (s *server) serveObj(ctx context.Context, w http.ResponseWriter, req *http.
 		}()
 	}
 
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", objMIMEType(objname))
+	}
+
+	if req.Method == "HEAD" && req.Header.Get("Range") == "" {
+		if heads := s.objHeads.Load(); heads != nil {
+			if h, ok := (*heads)[objname]; ok {
+				// Answer from the cached attributes,
+				// without a round trip to GCS.
+				if h.etag != "" {
+					w.Header().Set("ETag", `"`+h.etag+`"`)
+				}
+				wrapper := &mid.ResponseWrapper{W: w}
+				http.ServeContent(wrapper, req, path, h.modTime, &headContent{size: h.size})
+				if wrapper.Code < 200 || wrapper.Code >= 400 {
+					return mid.CodeErr{C: wrapper.Code}
+				}
+				return nil
+			}
+		}
+	}
+
 	obj := s.bucket.Object(objname)
-	attrs, err := obj.Attrs(ctx)
+	attrsCtx, attrsSpan := tracer.Start(ctx, "gcs.attrs")
+	attrs, err := obj.Attrs(attrsCtx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		endSpan(attrsSpan, err)
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no object %s", objname),
+		}
+	}
 	if err != nil {
+		endSpan(attrsSpan, err)
 		return errors.Wrapf(err, "getting attrs for object %s", objname)
 	}
-	objtime := attrs.Created
-	if attrs.Updated.After(objtime) {
-		objtime = attrs.Updated
+	obj, attrs, err = resolveAlias(attrsCtx, s.bucket, obj, attrs)
+	endSpan(attrsSpan, err)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.Int64("gcs.size", attrs.Size))
+	head := headOf(attrs)
+	if head.etag != "" {
+		w.Header().Set("ETag", `"`+head.etag+`"`)
 	}
 
 	r := gcsobj.NewReaderWithSize(ctx, obj, attrs.Size)
@@ -131,8 +285,17 @@ func (s *server) serveObj(ctx context.Context, w http.ResponseWriter, req *http.
 		}()
 	}
 
+	gr := newTracedReader(r, span)
+	defer gr.Close()
+
+	tr := newTrackingReader(gr, objname, s.stats)
+	defer tr.Close()
+
 	wrapper := &mid.ResponseWrapper{W: w}
-	http.ServeContent(wrapper, req, path, objtime, r)
+	http.ServeContent(wrapper, req, path, head.modTime, tr)
+	if s.watchdog != nil && gr.first {
+		s.watchdog.gcsFirstByte(gr.toFirst)
+	}
 	if wrapper.Code < 200 || wrapper.Code >= 400 {
 		return mid.CodeErr{C: wrapper.Code}
 	}
@@ -140,10 +303,6 @@ func (s *server) serveObj(ctx context.Context, w http.ResponseWriter, req *http.
 }
 
 func (s *server) handleThumb(w http.ResponseWriter, req *http.Request) error {
-	if err := s.checkAuth(w, req); err != nil {
-		return err
-	}
-
 	path := strings.Trim(req.URL.Path, "/")
 	path = strings.TrimPrefix(path, "thumbs/")
 
@@ -162,47 +321,164 @@ func (s *server) handleThumb(w http.ResponseWriter, req *http.Request) error {
 		return errors.Wrap(err, "in ensureInfoMap")
 	}
 
+	if err := s.checkAccess(ctx, path); err != nil {
+		return err
+	}
+
+	q := req.URL.Query()
+	if q.Has("w") || q.Has("h") {
+		return s.handleResizedThumb(w, req, path)
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.objNames.Has(path) {
+	if s.hiddenObj(path) {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("/thumbs/%s is hidden", path),
+		}
+	}
+
+	objName, origURL, err := s.thumbSource(path)
+	if err != nil {
+		return err
+	}
+
+	if objName != "" {
 		// Serve this thumb from the bucket.
 
 		log.Printf("Serving local thumb %s", path)
 
-		err := s.serveObj(ctx, w, req, path, "/thumbs/"+path, false)
+		err := s.serveObj(ctx, w, req, objName, "/thumbs/"+path, false)
 		return errors.Wrap(err, "serving local thumb")
 	}
 
 	// Redirect to the thumb's actual URL.
 
+	log.Printf("Redirecting /thumbs/%s to %s", path, origURL)
+
+	http.Redirect(w, req, origURL, http.StatusFound)
+	return nil
+}
+
+// thumbExts are the extensions of the bucket objects served as thumbs.
+var thumbExts = set.New(".jpg", ".jpeg", ".png")
+
+// thumbSource returns where the thumb at /thumbs/PATH comes from:
+// an image object in the bucket belonging to a title,
+// or else the URL in the title's metadata.
+// No other objects in the bucket are available this way.
+// The caller must hold s.mu.
+func (s *server) thumbSource(path string) (objName, origURL string, err error) {
+	if strings.HasPrefix(path, "kodigcs/") || strings.HasPrefix(path, "imdb-cache/") {
+		return "", "", mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no thumb /thumbs/%s", path),
+		}
+	}
+
 	var (
 		ext  = filepath.Ext(path)
 		root = strings.TrimSuffix(path, ext)
 	)
 
+	if s.objNames.Has(path) && thumbExts.Has(strings.ToLower(ext)) {
+		_, isTitle := s.mediaObjName(root)
+		if _, ok := s.infoMap[root]; ok || isTitle {
+			return path, "", nil
+		}
+	}
+
 	entry, ok := s.infoMap[root]
 	if !ok {
-		return mid.CodeErr{
+		return "", "", mid.CodeErr{
 			C:   http.StatusNotFound,
 			Err: fmt.Errorf("no infoMap entry for /thumbs/%s", path),
 		}
 	}
-	matches := slices.Filter(entry.Thumbs, func(th thumb) bool { return th.Val == path })
-	if len(matches) == 0 {
-		return mid.CodeErr{
-			C:   http.StatusNotFound,
-			Err: fmt.Errorf("no redirect URL for /thumbs/%s", path),
+	for i, th := range entry.Thumbs {
+		// The first thumb is at /thumbs/ROOT.EXT (see parseInfoRow).
+		if th.Val == path || (i == 0 && filepath.Ext(th.origVal) == ext) {
+			return "", th.origVal, nil
 		}
 	}
+	return "", "", mid.CodeErr{
+		C:   http.StatusNotFound,
+		Err: fmt.Errorf("no redirect URL for /thumbs/%s", path),
+	}
+}
 
-	log.Printf("Redirecting /thumbs/%s to %s", path, matches[0].origVal)
+// handleHeadshot serves an actor's headshot,
+// either from the bucket (see the -headshots flag of ssupdate)
+// or by redirecting to the URL in the actorthumbs column.
+func (s *server) handleHeadshot(w http.ResponseWriter, req *http.Request) error {
+	path := strings.Trim(req.URL.Path, "/")
+	path = strings.TrimPrefix(path, "actors/")
 
-	http.Redirect(w, req, matches[0].origVal, http.StatusFound)
-	return nil
+	var err error
+	path, err = url.PathUnescape(path)
+	if err != nil {
+		return errors.Wrapf(err, "unescaping path %s", path)
+	}
+	objName := "actors/" + path
+
+	ctx := req.Context()
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "in ensureInfoMap")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.objNames.Has(objName) {
+		err := s.serveObj(ctx, w, req, objName, "/"+objName, false)
+		return errors.Wrap(err, "serving headshot")
+	}
+
+	for _, info := range s.infoMap {
+		for _, a := range info.Actors {
+			if a.Thumb == nil || a.Thumb.origVal == "" {
+				continue
+			}
+			if headshotObjName(a.Name, urlExt(a.Thumb.origVal)) != objName {
+				continue
+			}
+			http.Redirect(w, req, a.Thumb.origVal, http.StatusFound)
+			return nil
+		}
+	}
+
+	return mid.CodeErr{
+		C:   http.StatusNotFound,
+		Err: fmt.Errorf("no headshot for /%s", objName),
+	}
 }
 
 func (s *server) handleDir(w http.ResponseWriter, req *http.Request, subdir string) error {
+	if subdir == sectionsDir || strings.HasPrefix(subdir, sectionsDir+"/") {
+		return s.handleSectionDir(w, req, strings.TrimPrefix(strings.TrimPrefix(subdir, sectionsDir), "/"))
+	}
+	if s.sets && (subdir == setsDir || strings.HasPrefix(subdir, setsDir+"/")) {
+		return s.handleSetDir(w, req, strings.TrimPrefix(strings.TrimPrefix(subdir, setsDir), "/"))
+	}
+	if s.genres && (subdir == genresDir || strings.HasPrefix(subdir, genresDir+"/")) {
+		return s.handleGenreDir(w, req, strings.TrimPrefix(strings.TrimPrefix(subdir, genresDir), "/"))
+	}
+	if s.years && (subdir == yearsDir || strings.HasPrefix(subdir, yearsDir+"/")) {
+		return s.handleYearDir(w, req, strings.TrimPrefix(strings.TrimPrefix(subdir, yearsDir), "/"))
+	}
+
+	var letter string
+	if s.letters {
+		if parent, l, ok := cutLetterDir(subdir); ok {
+			subdir, letter = parent, l
+		}
+	}
+
 	if !s.subdirs && subdir != "" {
 		return mid.CodeErr{
 			C:   http.StatusBadRequest,
@@ -210,10 +486,17 @@ func (s *server) handleDir(w http.ResponseWriter, req *http.Request, subdir stri
 		}
 	}
 
-	log.Printf("serving directory \"%s\"", subdir)
-
 	ctx := req.Context()
 
+	if !s.mayAccess(principal(ctx), subdir) {
+		return mid.CodeErr{
+			C:   http.StatusForbidden,
+			Err: fmt.Errorf("%s may not access subdir \"%s\"", principal(ctx), subdir),
+		}
+	}
+
+	log.Printf("serving directory \"%s\"", subdir)
+
 	err := s.ensureObjNames(ctx)
 	if err != nil {
 		return errors.Wrap(err, "getting obj names")
@@ -227,26 +510,115 @@ func (s *server) handleDir(w http.ResponseWriter, req *http.Request, subdir stri
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	groups := s.titleGroups(s.accessible(ctx, func(info movieInfo, ok bool) bool {
+		if s.sets && ok && info.Set != nil {
+			// Listed in its set's folder instead.
+			return false
+		}
+		if ok && s.subdirs && info.subdir != subdir {
+			return false
+		}
+		if !ok && s.subdirs && subdir != "" {
+			return false
+		}
+		return true
+	}))
+
+	if letter != "" {
+		items := flattenTitleGroups(groupsWithLetter(groups, letter))
+		if len(items) == 0 {
+			return mid.CodeErr{
+				C:   http.StatusNotFound,
+				Err: fmt.Errorf("no titles under %s", letter),
+			}
+		}
+		return s.writeDir(w, req, items)
+	}
+
 	var items []template.URL
+	if s.letters {
+		items = letterDirItems(groups)
+	} else {
+		items = flattenTitleGroups(groups)
+	}
+
+	if s.sets && subdir == "" && s.hasSets() {
+		items = append(items, template.URL(setsDir+"/"))
+	}
+	if s.genres && subdir == "" && s.hasGenres() {
+		items = append(items, template.URL(genresDir+"/"))
+	}
+	if s.years && subdir == "" && s.hasYears() {
+		items = append(items, template.URL(yearsDir+"/"))
+	}
+
+	if subdir == "" && len(s.sections) > 0 {
+		// Homepage sections go at the top.
+		items = append([]template.URL{template.URL(sectionsDir + "/")}, items...)
+	}
+
+	if s.subdirs && subdir == "" {
+		subdirs := set.New[string]()
+		for _, info := range s.infoMap {
+			if info.subdir != "" && !info.hidden && s.mayView(ctx, info) {
+				subdirs.Add(info.subdir)
+			}
+		}
+		sorted := subdirs.Slice()
+		sort.Strings(sorted)
+		for _, sd := range sorted {
+			items = append(items, template.URL(sd+"/"))
+		}
+	}
+
+	return s.writeDir(w, req, items)
+}
+
+// titleItems returns the directory entries for the titles that the include function accepts.
+// The include function receives the title's info and whether it has any.
+// The caller must hold s.mu.
+func (s *server) titleItems(include func(info movieInfo, ok bool) bool) []template.URL {
+	return flattenTitleGroups(s.titleGroups(include))
+}
+
+// A titleGroup is the directory items for one title.
+type titleGroup struct {
+	sortKey, rootName string
+	items             []template.URL
+}
+
+// titleGroups is like titleItems
+// but keeps each title's items together in a titleGroup.
+// The caller must hold s.mu.
+func (s *server) titleGroups(include func(info movieInfo, ok bool) bool) []titleGroup {
+	var (
+		titles  []titleGroup
+		files   = s.indexTitleFiles()
+		parts   = s.indexParts()
+		grouped = parts.grouped()
+	)
+
+	add := func(rootName string, info movieInfo, items ...template.URL) {
+		titles = append(titles, titleGroup{
+			sortKey:  titleSortKey(displayTitle(rootName, info), info),
+			rootName: rootName,
+			items:    items,
+		})
+	}
+
 	s.objNames.Each(func(objName string) {
-		ext := filepath.Ext(objName)
-		switch ext {
-		case ".iso", ".m2ts", ".m4v", ".mp4":
-			// ok
-		default:
+		if grouped.Has(objName) || s.isEpisode(objName) || s.isExtra(objName) || s.isDiscObj(objName) {
 			return
 		}
 
-		if false && ext != ".iso" {
+		ext := filepath.Ext(objName)
+		if !isMediaExt(ext) {
 			return
 		}
 
 		rootName := strings.TrimSuffix(objName, ext)
 		info, ok := s.infoMap[rootName]
-		if ok && s.subdirs && info.subdir != subdir {
-			return
-		}
-		if !ok && s.subdirs && subdir != "" {
+		if info.hidden || !include(info, ok) {
 			return
 		}
 
@@ -256,27 +628,117 @@ func (s *server) handleDir(w http.ResponseWriter, req *http.Request, subdir stri
 		// E.g., "The Best of The Electric Company, Vol. 2, Disc 1" looks the same to Kodi as
 		// "The Best of The Electric Company, Vol. 2, Disc 2".
 		prefix := rootNamePrefix(rootName)
-		items = append(items, template.URL(prefix+objName), template.URL(prefix+rootName+".nfo"))
+		items := []template.URL{template.URL(prefix + objName), template.URL(prefix + rootName + ".nfo")}
+		add(rootName, info, append(items, s.titleExtras(rootName, info, files)...)...)
 	})
 
-	if s.subdirs && subdir == "" {
-		subdirs := make(map[string]struct{})
+	for rootName := range parts {
+		info := s.infoMap[rootName]
+		if info.hidden || !include(info, true) {
+			continue
+		}
+		prefix := rootNamePrefix(rootName)
+		items := []template.URL{template.URL(prefix + rootName + ".m3u"), template.URL(prefix + rootName + ".nfo")}
+		add(rootName, info, append(items, s.titleExtras(rootName, info, files)...)...)
+	}
+
+	for _, rootName := range s.discTitles() {
+		info, ok := s.infoMap[rootName]
+		if info.hidden || !include(info, ok) {
+			continue
+		}
+		items := []template.URL{template.URL(rootNamePrefix(rootName) + rootName + "/")}
+		add(rootName, info, append(items, s.titleExtras(rootName, info, files)...)...)
+	}
+
+	sort.Slice(titles, func(i, j int) bool {
+		if titles[i].sortKey != titles[j].sortKey {
+			return titles[i].sortKey < titles[j].sortKey
+		}
+		return titles[i].rootName < titles[j].rootName
+	})
+
+	return titles
+}
+
+func flattenTitleGroups(groups []titleGroup) []template.URL {
+	var items []template.URL
+	for _, g := range groups {
+		items = append(items, g.items...)
+	}
+	return items
+}
+
+// The virtual directory under which movie sets are listed, in -sets mode.
+const setsDir = "sets"
+
+// virtualDirName is the name of the virtual folder for the movie set or homepage section with the given name.
+func virtualDirName(name string) string {
+	return strings.ReplaceAll(name, "/", "-")
+}
+
+// hasSets tells whether any title belongs to a movie set.
+// The caller must hold s.mu.
+func (s *server) hasSets() bool {
+	for _, info := range s.infoMap {
+		if info.Set != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSetDir serves the list of movie sets (when setName is empty)
+// or the titles in one movie set.
+func (s *server) handleSetDir(w http.ResponseWriter, req *http.Request, setName string) error {
+	log.Printf("serving set directory \"%s\"", setName)
+
+	ctx := req.Context()
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if setName == "" {
+		names := set.New[string]()
 		for _, info := range s.infoMap {
-			if info.subdir != "" {
-				subdirs[info.subdir] = struct{}{}
+			if info.Set != nil && !info.hidden {
+				names.Add(virtualDirName(info.Set.Name))
 			}
 		}
-		for sd := range subdirs {
-			items = append(items, template.URL(sd+"/"))
+		var items []template.URL
+		for name := range names {
+			items = append(items, template.URL(url.PathEscape(name)+"/"))
+		}
+		return s.writeDir(w, req, items)
+	}
+
+	items := s.titleItems(s.accessible(ctx, func(info movieInfo, ok bool) bool {
+		return ok && info.Set != nil && virtualDirName(info.Set.Name) == setName
+	}))
+	if len(items) == 0 {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no movie set %s", setName),
 		}
 	}
 
-	return s.dirTemplate.Execute(w, items)
+	return s.writeDir(w, req, items)
 }
 
 func (s *server) handleNFO(w http.ResponseWriter, req *http.Request, path string) error {
 	ctx := req.Context()
-	err := s.ensureInfoMap(ctx)
+	err := s.ensureObjNames(ctx)
+	if err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	err = s.ensureInfoMap(ctx)
 	if err != nil {
 		return errors.Wrap(err, "getting info map")
 	}
@@ -292,61 +754,427 @@ func (s *server) handleNFO(w http.ResponseWriter, req *http.Request, path string
 		info = movieInfo{Title: path}
 	}
 
-	w.Header().Set("Content-Type", "application/xml")
-	w.Write([]byte(xml.Header))
-	enc := xml.NewEncoder(w)
-	enc.Indent("", "  ")
-	err = enc.Encode(info)
-	if err != nil {
-		return errors.Wrap(err, "writing XML")
+	if objName, ok := s.mediaObjName(path); ok {
+		info.DateAdded = s.objCreated[objName].Format(time.DateTime)
+	}
+	if trailer, ok := s.trailerObj(path); ok {
+		info.Trailer = s.relURL(rootNamePrefix(path) + trailer)
+	}
+	if s.watched != nil {
+		if st, ok := s.watched.get(principal(ctx), path); ok {
+			st.nfoFields(&info)
+		}
 	}
 
-	if info.imdbID != "" {
-		fmt.Fprintf(w, "\nhttps://www.imdb.com/title/%s\n", info.imdbID)
+	// Headshots mirrored into the bucket,
+	// for actors not listed in the actorthumbs column.
+	actors := make([]actor, 0, len(info.Actors))
+	for _, a := range info.Actors {
+		if a.Thumb == nil {
+			if objName := headshotObjName(a.Name, ".jpg"); s.objNames.Has(objName) {
+				a.Thumb = &thumb{Val: s.relURL("actors/" + url.PathEscape(strings.TrimPrefix(objName, "actors/")))}
+			}
+		}
+		actors = append(actors, a)
 	}
-	return nil
-}
+	info.Actors = actors
 
-func (s *server) parsePath(ctx context.Context, path string) (subdir, objname string, err error) {
-	err = s.ensureInfoMap(ctx)
+	b, err := nfoBytes(info, info.imdbID)
 	if err != nil {
-		return "", "", err
+		return err
 	}
+	w.Header().Set("Content-Type", "application/xml")
+	return s.serveGenerated(w, req, path+".nfo", b)
+}
 
-	ext := filepath.Ext(path)
-	pathRoot := strings.TrimSuffix(path, ext)
-
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// nfoBytes renders info as an .nfo file,
+// followed by the IMDb URL for imdbID if it's not empty.
+func nfoBytes(info any, imdbID string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(info); err != nil {
+		return nil, errors.Wrap(err, "writing XML")
+	}
+	if imdbID != "" {
+		fmt.Fprintf(buf, "\nhttps://www.imdb.com/title/%s\n", imdbID)
+	}
+	return buf.Bytes(), nil
+}
+
+// serveGenerated serves content that the server generates,
+// such as an .nfo file or a directory listing,
+// with an ETag from its hash,
+// answering conditional requests
+// (as from Kodi's repeated library scans)
+// with 304 Not Modified when it hasn't changed.
+// The caller must set the Content-Type
+// and must hold s.mu.
+func (s *server) serveGenerated(w http.ResponseWriter, req *http.Request, name string, content []byte) error {
+	sum := sha256.Sum256(content)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum[:16]))
+
+	wrapper := &mid.ResponseWrapper{W: w}
+	http.ServeContent(wrapper, req, name, s.loadedTime(), bytes.NewReader(content))
+	if wrapper.Code < 200 || wrapper.Code >= 400 {
+		return mid.CodeErr{C: wrapper.Code}
+	}
+	return nil
+}
+
+// loadedTime is when the server last loaded the bucket's object names or the metadata,
+// on which everything it generates depends.
+// The caller must hold s.mu.
+func (s *server) loadedTime() time.Time {
+	if s.infoMapTime.After(s.objNamesTime) {
+		return s.infoMapTime
+	}
+	return s.objNamesTime
+}
+
+// handleM3U serves a playlist of the parts of a multi-part title,
+// in order,
+// so that Kodi plays them through as a single item.
+// Entries are relative to the playlist's own location,
+// so Kodi fetches them with the same base URL and credentials.
+func (s *server) handleM3U(w http.ResponseWriter, req *http.Request, path string) error {
+	ctx := req.Context()
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	log.Printf("serving %s", path)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rootName := strings.TrimSuffix(path, ".m3u")
+	info, ok := s.infoMap[rootName]
+	if !ok || info.parts == "" {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no multi-part title %s", rootName),
+		}
+	}
+	parts := s.partsOf(info)
+	if len(parts) == 0 {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no parts for multi-part title %s", rootName),
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "#EXTM3U")
+	for i, part := range parts {
+		var (
+			ext      = filepath.Ext(part)
+			partRoot = strings.TrimSuffix(part, ext)
+		)
+		fmt.Fprintf(buf, "#EXTINF:-1,%s (%d)\n", info.Title, i+1)
+		fmt.Fprintln(buf, url.PathEscape(rootNamePrefix(partRoot)+part))
+	}
+
+	w.Header().Set("Content-Type", "audio/x-mpegurl")
+	return s.serveGenerated(w, req, path, buf.Bytes())
+}
 
+// defaultMediaExts is the default value of the -exts flag.
+const defaultMediaExts = ".iso,.m2ts,.m4v,.mkv,.mp4"
+
+// currentMediaExts holds the (lowercase) extensions of the objects that are listed as titles.
+// They come from the -exts flag,
+// or from serve -config (see reload.go).
+var currentMediaExts atomic.Pointer[set.Of[string]]
+
+func init() {
+	setMediaExts(parseMediaExts(defaultMediaExts))
+}
+
+// mediaExts returns the extensions in currentMediaExts.
+func mediaExts() set.Of[string] {
+	return *currentMediaExts.Load()
+}
+
+func setMediaExts(exts set.Of[string]) {
+	currentMediaExts.Store(&exts)
+}
+
+// parseMediaExts parses a comma-separated list of extensions,
+// with or without their leading dots.
+func parseMediaExts(s string) set.Of[string] {
+	result := set.New[string]()
+	for _, ext := range strings.Split(s, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		result.Add(ext)
+	}
+	return result
+}
+
+// isMediaExt tells whether ext, in any case, is one of mediaExts.
+func isMediaExt(ext string) bool {
+	return mediaExts().Has(strings.ToLower(ext))
+}
+
+// mediaObjName returns the name of the object holding the media for the given root name.
+// For a multi-part title this is the first part,
+// and for a title stored as a disc structure it's the disc's index file.
+// The caller must hold s.mu.
+func (s *server) mediaObjName(rootName string) (string, bool) {
+	if info, ok := s.infoMap[rootName]; ok {
+		if parts := s.partsOf(info); len(parts) > 0 {
+			return parts[0], true
+		}
+	}
+	for ext := range mediaExts() {
+		for _, e := range []string{ext, strings.ToUpper(ext)} {
+			if objName := rootName + e; s.objNames.Has(objName) {
+				return objName, true
+			}
+		}
+	}
+	return s.discObjName(rootName)
+}
+
+// titleExtras returns the directory entries for a title's subtitles, artwork, trailer, and extras,
+// which go beside its media object and .nfo file.
+// The caller must hold s.mu.
+func (s *server) titleExtras(rootName string, info movieInfo, files titleFiles) []template.URL {
+	var (
+		prefix = rootNamePrefix(rootName)
+		items  []template.URL
+	)
+	for _, sub := range files.subtitles[rootName] {
+		items = append(items, template.URL(prefix+sub))
+	}
+	for _, a := range s.artworks(rootName, info) {
+		items = append(items, template.URL(prefix+a.name))
+	}
+	if trailer, ok := s.trailerObj(rootName); ok {
+		items = append(items, template.URL(prefix+trailer))
+	}
+	if files.hasExtras.Has(rootName) {
+		items = append(items, template.URL(prefix+rootName+extrasSuffix+"/"))
+	}
+	return items
+}
+
+// displayTitle is the title to show for the given root name.
+func displayTitle(rootName string, info movieInfo) string {
+	if info.Title != "" {
+		return info.Title
+	}
+	return rootName
+}
+
+// titleSortKey is the key for sorting a title whose displayTitle is title.
+func titleSortKey(title string, info movieInfo) string {
+	if info.SortTitle != "" {
+		return info.SortTitle
+	}
+	return strings.ToLower(title)
+}
+
+// partsOf returns the names of the objects making up a multi-part title,
+// in natural order ("Disc 2" before "Disc 10"),
+// or nil if info does not describe a multi-part title
+// or its parts pattern matches no objects.
+// Listings, which need the parts of every title, use indexParts instead.
+// The caller must hold s.mu.
+func (s *server) partsOf(info movieInfo) []string {
+	if info.parts == "" {
+		return nil
+	}
+
+	var (
+		prefix = partsPrefix(info.parts)
+		result []string
+	)
+	s.objNames.Each(func(objName string) {
+		if !strings.HasPrefix(objName, prefix) {
+			return
+		}
+		if ok, _ := filepath.Match(info.parts, objName); ok {
+			result = append(result, objName)
+		}
+	})
+	sort.Slice(result, func(i, j int) bool { return naturalLess(result[i], result[j]) })
+	return result
+}
+
+// A partsIndex maps the root name of each multi-part title
+// whose parts pattern matches any objects
+// to its parts, as returned by partsOf.
+type partsIndex map[string][]string
+
+// indexParts returns the partsIndex for the server's titles.
+// Rather than match every title's pattern against every object,
+// it sorts the object names once
+// and looks for each title's parts only among the names beginning with the literal prefix of its pattern.
+// The caller must hold s.mu.
+func (s *server) indexParts() partsIndex {
+	var (
+		result = make(partsIndex)
+		sorted []string
+	)
 	for rootName, info := range s.infoMap {
-		if path == info.subdir {
-			return path, "", nil
+		if info.parts == "" {
+			continue
 		}
-		prefix := rootNamePrefix(rootName)
-		if pathRoot == info.subdir+"/"+prefix+rootName {
-			return info.subdir, strings.TrimPrefix(path, info.subdir+"/"), nil
+		if sorted == nil {
+			sorted = s.objNames.Slice()
+			sort.Strings(sorted)
+		}
+		prefix := partsPrefix(info.parts)
+		var parts []string
+		for _, objName := range sorted[sort.SearchStrings(sorted, prefix):] {
+			if !strings.HasPrefix(objName, prefix) {
+				break
+			}
+			if ok, _ := filepath.Match(info.parts, objName); ok {
+				parts = append(parts, objName)
+			}
 		}
-		if pathRoot == prefix+rootName {
-			return "", path, nil
+		if len(parts) == 0 {
+			continue
 		}
+		sort.Slice(parts, func(i, j int) bool { return naturalLess(parts[i], parts[j]) })
+		result[rootName] = parts
 	}
+	return result
+}
+
+// grouped returns the objects that are parts of multi-part titles.
+// They are listed via their titles' playlists,
+// not individually.
+func (idx partsIndex) grouped() set.Of[string] {
+	result := set.New[string]()
+	for _, parts := range idx {
+		result.Add(parts...)
+	}
+	return result
+}
+
+// partsPrefix is the part of a parts pattern before its first special character,
+// with which every name that the pattern matches begins.
+func partsPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// naturalLess compares strings,
+// treating runs of digits as numbers.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		aDigits, bDigits := leadingDigits(a), leadingDigits(b)
+		if aDigits != "" && bDigits != "" {
+			aNum, bNum := strings.TrimLeft(aDigits, "0"), strings.TrimLeft(bDigits, "0")
+			if len(aNum) != len(bNum) {
+				return len(aNum) < len(bNum)
+			}
+			if aNum != bNum {
+				return aNum < bNum
+			}
+			a, b = a[len(aDigits):], b[len(bDigits):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
 
-	return "", path, nil
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// parsePath splits a request path into a subdirectory and an object name.
+// The object name is the final path element,
+// if it carries the hash prefix added by handleDir.
+// Otherwise the whole path names a subdirectory.
+func parsePath(path string) (subdir, objname string) {
+	dir, base := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		dir, base = path[:i], path[i+1:]
+	}
+	if len(base) > 8 {
+		for _, rootName := range itemRoots(base[8:]) {
+			if base[:8] == rootNamePrefix(rootName) {
+				return dir, base
+			}
+		}
+	}
+	return path, ""
+}
+
+// itemRoots returns the root names of the titles
+// that the directory item with the given name
+// (without its hash prefix)
+// might belong to.
+// For most items that's the name without its extension,
+// but subtitles and artwork have other suffixes.
+func itemRoots(name string) []string {
+	result := []string{strings.TrimSuffix(name, filepath.Ext(name))}
+	result = append(result, subtitleRoots(name)...)
+	if rootName, _, ok := parseArtworkName(name); ok {
+		result = append(result, rootName)
+	}
+	if rootName, ok := strings.CutSuffix(result[0], trailerSuffix); ok {
+		result = append(result, rootName)
+	}
+	return result
 }
 
 func (s *server) ensureObjNames(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.loadObjNames(ctx)
+}
+
+// loadObjNames loads the names of the objects in the bucket,
+// unless they are already loaded and not stale.
+// The caller must hold s.mu.
+func (s *server) loadObjNames(ctx context.Context) (err error) {
 	if s.objNames != nil && s.objNames.Len() > 0 && !isStale(s.objNamesTime) {
 		return nil
 	}
 
 	log.Print("loading bucket")
 
+	ctx, span := tracer.Start(ctx, "loadObjNames")
+	defer func() {
+		span.SetAttributes(attribute.Int("gcs.objects", s.objNames.Len()))
+		endSpan(span, err)
+	}()
+
+	before := s.objNames
 	s.objNames = set.New[string]()
+	s.objCreated = make(map[string]time.Time)
+	s.objSize = make(map[string]int64)
+	s.storageBytes = 0
 
+	heads := make(map[string]objHead)
+	aliases := make(map[string]string) // alias -> target
 	iter := s.bucket.Objects(ctx, nil)
 	for {
 		attrs, err := iter.Next()
@@ -357,172 +1185,361 @@ func (s *server) ensureObjNames(ctx context.Context) error {
 			return errors.Wrap(err, "iterating over bucket")
 		}
 		s.objNames.Add(attrs.Name)
+		s.objCreated[attrs.Name] = attrs.Created
+		s.objSize[attrs.Name] = attrs.Size
+		s.storageBytes += attrs.Size
+		heads[attrs.Name] = headOf(attrs)
+		if target := attrs.Metadata[aliasMetadataKey]; target != "" {
+			aliases[attrs.Name] = target
+		}
+	}
+	for alias, target := range aliases {
+		s.objSize[alias] = s.objSize[target]
+		if h, ok := heads[target]; ok {
+			heads[alias] = h
+		} else {
+			delete(heads, alias)
+		}
 	}
+	s.objHeads.Store(&heads)
 	s.objNamesTime = time.Now()
+
+	if len(s.kodiHosts) > 0 && before != nil && before.Len() > 0 {
+		if added, removed := titleChanges(before, s.objNames); added || removed {
+			go s.notifyKodi(context.WithoutCancel(ctx), added, removed)
+		}
+	}
+	if len(s.webhooks) > 0 && before != nil && before.Len() > 0 {
+		if titles := newTitles(before, s.objNames); len(titles) > 0 {
+			go sendWebhooks(context.WithoutCancel(ctx), s.webhooks, titlesAddedEvent(s.bucketName, titles))
+		}
+	}
+
 	return nil
 }
 
-func (s *server) ensureInfoMap(ctx context.Context) error {
+func (s *server) ensureInfoMap(ctx context.Context) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.sheetID == "" {
+	if s.auto != nil {
+		// The sheet-free mode keeps the info map up to date in runAuto.
 		return nil
 	}
-	if len(s.infoMap) > 0 && !isStale(s.infoMapTime) {
+	stale := isStale(s.infoMapTime)
+	if s.sourceWatched {
+		stale = time.Since(s.infoMapTime) > watchedStaleTime
+	}
+	if len(s.infoMap) > 0 && !stale {
 		return nil
 	}
 
-	log.Print("loading spreadsheet")
+	ctx, span := tracer.Start(ctx, "ensureInfoMap")
+	defer func() { endSpan(span, err) }()
+
+	if err := s.loadObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+
+	sidecarCtx, sidecarSpan := tracer.Start(ctx, "readSidecars")
+	sidecars := readSidecars(sidecarCtx, s.bucket, s.objNames)
+	sidecarSpan.End()
 
 	s.infoMap = make(map[string]movieInfo)
 
-	err := handleSheet(s.ssvc, s.sheetID, func(_ int, headings []string, name string, row []interface{}) error {
-		var info movieInfo
+	if s.sheetID != "" {
+		log.Print("loading spreadsheet")
 
-		var (
-			ext      = filepath.Ext(name)
-			rootName = strings.TrimSuffix(name, ext)
-		)
+		sheetCtx, sheetSpan := tracer.Start(ctx, "loadMetadata")
+		err := handleSheet(sheetCtx, s.meta, func(_ int, headings []string, name string, row []interface{}) error {
+			rootName := strings.TrimSuffix(name, filepath.Ext(name))
+			if fields, ok := sidecars[rootName]; ok {
+				headings, row = applySidecar(headings, row, fields)
+			}
+			rootName, info := s.parseInfoRow(headings, name, row)
+			s.infoMap[rootName] = info
+			return nil
+		})
+		if src, ok := s.meta.(sheetsSource); ok && err == nil {
+			// Only a Google spreadsheet can have a Sections tab.
+			s.sections, err = readSections(sheetCtx, src.ssvc, src.sheetID)
+			if s.tv && err == nil {
+				s.series, err = readSeries(sheetCtx, src.ssvc, src.sheetID)
+			}
+		}
+		sheetSpan.SetAttributes(attribute.Int("kodigcs.titles", len(s.infoMap)))
+		endSpan(sheetSpan, err)
+		s.health.sheetLoaded(err)
+		if err != nil {
+			return errors.Wrap(err, "processing spreadsheet")
+		}
+	}
+
+	// Titles with sidecars but no spreadsheet rows.
+	for _, objName := range s.sidecarOnly(sidecars) {
+		rootName := strings.TrimSuffix(objName, filepath.Ext(objName))
+		headings, row := applySidecar([]string{"filename"}, []interface{}{objName}, sidecars[rootName])
+		rootName, info := s.parseInfoRow(headings, objName, row)
+		s.infoMap[rootName] = info
+	}
+
+	s.addVersionInfo()
+
+	s.infoMapTime = time.Now()
+	return nil
+}
+
+// parseInfoRow parses a row of the metadata spreadsheet
+// into the root name of the title and its info.
+func (s *server) parseInfoRow(headings []string, name string, row []interface{}) (string, movieInfo) {
+	var (
+		info      movieInfo
+		headshots = make(map[string]string) // actor name -> image URL
+	)
+
+	var (
+		ext      = filepath.Ext(name)
+		rootName = strings.TrimSuffix(name, ext)
+	)
+	info.filename = name
+
+	for j, rawval := range row {
+		if j == 0 {
+			continue
+		}
+		if j >= len(headings) {
+			break
+		}
+		val, ok := rawval.(string)
+		if !ok || val == "" {
+			continue
+		}
+
+		heading := headings[j]
+		switch heading {
+		case "title":
+			info.Title = val
+
+		case "originaltitle":
+			info.OrigTitle = val
+
+		case "sort":
+			info.SortTitle = strings.ToLower(val)
 
-		for j, rawval := range row {
-			if j == 0 {
+		case "year":
+			year, err := strconv.Atoi(val)
+			if err != nil {
+				log.Printf("Cannot parse year %s for %s: %s", val, name, err)
 				continue
 			}
-			if j >= len(headings) {
-				break
-			}
-			val, ok := rawval.(string)
-			if !ok || val == "" {
+			info.Year = year
+
+		case "premiered":
+			premiered, err := time.Parse(time.DateOnly, val)
+			if err != nil {
+				log.Printf("Cannot parse premiere date %s for %s: %s", val, name, err)
 				continue
 			}
+			info.Premiered = val
+			if info.Year == 0 {
+				info.Year = premiered.Year()
+			}
 
-			heading := headings[j]
-			switch heading {
-			case "title":
-				info.Title = val
+		case "banner", "clearart", "clearlogo", "discart", "landscape", "poster":
+			origVal := val
 
-			case "sort":
-				info.SortTitle = strings.ToLower(val)
+			if len(info.Thumbs) == 0 {
+				valExt := filepath.Ext(val)
+				val = s.relURL("thumbs/" + url.PathEscape(rootName) + valExt) // "foo bar.iso" -> http://host:port/thumbs/foo%20bar.jpg
+			}
+			info.Thumbs = append(info.Thumbs, thumb{
+				Aspect:  heading,
+				Val:     val,
+				origVal: origVal,
+			})
+
+		case "directors":
+			directors := splitsemi(val)
+			info.Directors = append(info.Directors, directors...)
+
+		case "actors":
+			actors := splitsemi(val)
+			for _, a := range actors {
+				name, role := parseActor(a)
+				info.Actors = append(info.Actors, actor{
+					Name:  name,
+					Role:  role,
+					Order: len(info.Actors),
+				})
+			}
 
-			case "year":
-				year, err := strconv.Atoi(val)
-				if err != nil {
-					log.Printf("Cannot parse year %s for %s: %s", val, name, err)
+		case "actorthumbs":
+			for _, pair := range splitsemi(val) {
+				actorName, u, ok := strings.Cut(pair, "=")
+				if !ok {
+					log.Printf("Cannot parse actor thumb %s for %s", pair, name)
 					continue
 				}
-				info.Year = year
+				headshots[strings.TrimSpace(actorName)] = strings.TrimSpace(u)
+			}
 
-			case "banner", "clearart", "clearlogo", "discart", "landscape", "poster":
-				origVal := val
+		case "runtime":
+			mins, err := strconv.Atoi(val)
+			if err != nil {
+				log.Printf("Cannot parse runtime %s for %s: %s", val, name, err)
+				continue
+			}
+			info.Runtime = mins
 
-				if len(info.Thumbs) == 0 {
-					valExt := filepath.Ext(val)
-					val = s.relURL("thumbs/" + url.PathEscape(rootName) + valExt) // "foo bar.iso" -> http://host:port/thumbs/foo%20bar.jpg
-				}
-				info.Thumbs = append(info.Thumbs, thumb{
-					Aspect:  heading,
-					Val:     val,
-					origVal: origVal,
-				})
+		case "trailer":
+			trailer, err := parseTrailer(val)
+			if err != nil {
+				log.Printf("Cannot parse trailer URL %s for %s: %s", val, name, err)
+				continue
+			}
+			info.Trailer = trailer
 
-			case "directors":
-				directors := splitsemi(val)
-				info.Directors = append(info.Directors, directors...)
-
-			case "actors":
-				actors := splitsemi(val)
-				for _, a := range actors {
-					info.Actors = append(info.Actors, actor{
-						Name:  a,
-						Order: len(info.Actors),
-					})
-				}
+		case "outline":
+			info.Outline = val
 
-			case "runtime":
-				mins, err := strconv.Atoi(val)
-				if err != nil {
-					log.Printf("Cannot parse runtime %s for %s: %s", val, name, err)
-					continue
-				}
-				info.Runtime = mins
+		case "plot":
+			info.Plot = val
 
-			case "trailer":
-				u, err := url.Parse(val)
-				if err != nil {
-					log.Printf("Cannot parse trailer URL %s for %s: %s", val, name, err)
-					continue
-				}
+		case "tagline":
+			info.Tagline = val
 
-				var ytid string
-				switch u.Host {
-				case "www.youtube.com": // /watch?v=...
-					path := strings.TrimPrefix(u.Path, "/")
-					if path != "watch" {
-						log.Printf("Cannot parse trailer URL %s for %s: not a watch link", val, name)
-						continue
-					}
-					qvals, err := url.ParseQuery(u.RawQuery)
-					if err != nil {
-						log.Printf("Cannot parse query in trailer URL %s for %s: %s", val, name, err)
-						continue
-					}
-					if v, ok := qvals["v"]; ok && len(v) > 0 {
-						ytid = v[0]
-					}
-
-				case "youtu.be": // /...
-					ytid = strings.TrimPrefix(u.Path, "/")
-
-				default:
-					log.Printf("Cannot parse trailer URL %s for %s: not a YouTube link", val, name)
-					continue
-				}
+		case "genre":
+			info.Genre = val
 
-				if ytid == "" {
-					log.Printf("Cannot parse YouTube ID out of trailer URL %s for %s", val, name)
-					continue
-				}
+		case "mpaa":
+			info.MPAA = val
 
-				info.Trailer = fmt.Sprintf("plugin://plugin.video.youtube/?action=play_video&videoid=%s", ytid)
+		case "country":
+			info.Countries = splitsemi(val)
 
-			case "outline":
-				info.Outline = val
+		case "studio":
+			info.Studios = splitsemi(val)
 
-			case "plot":
-				info.Plot = val
+		case "language":
+			langs := splitsemi(val)
+			if len(langs) == 0 {
+				continue
+			}
+			info.FileInfo = &fileInfo{}
+			for _, lang := range langs {
+				info.FileInfo.Audio = append(info.FileInfo.Audio, audioStream{Language: languageCode(lang)})
+			}
 
-			case "tagline":
-				info.Tagline = val
+		case "set":
+			if setName := strings.TrimSpace(val); setName != "" {
+				info.Set = &movieSet{Name: setName}
+			}
+
+		case "imdbrating", "rottentomatoes", "metacritic":
+			v, err := strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64)
+			if err != nil {
+				log.Printf("Cannot parse %s rating %q for %s", heading, val, rootName)
+				continue
+			}
+			name := ratingColumns[heading]
+			info.Ratings = append(info.Ratings, rating{
+				Name:    name,
+				Max:     ratingMax[name],
+				Default: name == ratingIMDb,
+				Value:   v,
+			})
+
+		case "top250":
+			top250, err := strconv.Atoi(val)
+			if err != nil {
+				log.Printf("Cannot parse Top 250 position %s for %s: %s", val, name, err)
+				continue
+			}
+			info.Top250 = top250
+
+		case "awards":
+			info.awards = val
+
+		case "subdir":
+			info.subdir = val
+
+		case "hidden":
+			info.hidden = isTrue(val)
 
-			case "genre":
-				info.Genre = val
+		case "enabled":
+			info.hidden = !isTrue(val)
 
-			case "subdir":
-				info.subdir = val
+		case "adult", "pin":
+			info.adult = isTrue(val)
 
-			case "imdbid":
-				info.imdbID = parseIMDbID(val)
+		case "parts":
+			info.parts = val
+
+		case "imdbid":
+			info.imdbID = parseIMDbID(val)
+		}
+	}
+
+	for i, a := range info.Actors {
+		if u := headshots[a.Name]; u != "" {
+			objName := headshotObjName(a.Name, urlExt(u))
+			info.Actors[i].Thumb = &thumb{
+				Val:     s.relURL("actors/" + url.PathEscape(strings.TrimPrefix(objName, "actors/"))),
+				origVal: u,
 			}
 		}
+	}
+
+	if info.Title == "" {
+		info.Title = rootName
+	}
+	if info.Outline == "" && info.Plot != "" {
+		info.Outline = firstSentence(info.Plot)
+	}
+	if info.SortTitle == "" {
+		// Sort by the localized title (the one users see),
+		// not the original one.
+		info.SortTitle = bib.Key(info.Title)
+	}
 
-		if info.Title == "" {
-			info.Title = rootName
+	return rootName, info
+}
+
+// parseTrailer parses a YouTube URL for a trailer
+// into the URL by which Kodi's YouTube plugin plays it.
+func parseTrailer(val string) (string, error) {
+	u, err := url.Parse(val)
+	if err != nil {
+		return "", err
+	}
+
+	var ytid string
+	switch u.Host {
+	case "www.youtube.com": // /watch?v=...
+		path := strings.TrimPrefix(u.Path, "/")
+		if path != "watch" {
+			return "", fmt.Errorf("not a watch link")
 		}
-		if info.SortTitle == "" {
-			info.SortTitle = bib.Key(info.Title)
+		qvals, err := url.ParseQuery(u.RawQuery)
+		if err != nil {
+			return "", errors.Wrap(err, "parsing query")
+		}
+		if v, ok := qvals["v"]; ok && len(v) > 0 {
+			ytid = v[0]
 		}
 
-		s.infoMap[rootName] = info
+	case "youtu.be": // /...
+		ytid = strings.TrimPrefix(u.Path, "/")
 
-		return nil
-	})
-	if err != nil {
-		return errors.Wrap(err, "processing spreadsheet")
+	default:
+		return "", fmt.Errorf("not a YouTube link")
 	}
 
-	s.infoMapTime = time.Now()
-	return nil
+	if ytid == "" {
+		return "", fmt.Errorf("no YouTube ID")
+	}
+
+	return fmt.Sprintf("plugin://plugin.video.youtube/?action=play_video&videoid=%s", ytid), nil
 }
 
 func (s *server) relURL(path string) string {
@@ -540,6 +1557,37 @@ func (s *server) relURL(path string) string {
 	return u.String()
 }
 
+// Abbreviations after which a period does not end a sentence.
+var abbrevs = set.New("dr", "mr", "mrs", "ms", "jr", "sr", "st", "mt", "vs", "no", "lt", "col", "gen", "capt", "sgt", "prof", "rev")
+
+// firstSentence returns the first sentence of s,
+// or all of s if it is a single sentence.
+func firstSentence(s string) string {
+	s = strings.TrimSpace(s)
+	for i := 0; i < len(s)-1; i++ {
+		switch s[i] {
+		case '.', '!', '?':
+		default:
+			continue
+		}
+		if s[i+1] != ' ' {
+			continue
+		}
+		if s[i] == '.' {
+			word := s[strings.LastIndexAny(s[:i], " (\"")+1 : i]
+			if abbrevs.Has(strings.ToLower(word)) {
+				continue
+			}
+			if len(word) == 1 && word[0] >= 'A' && word[0] <= 'Z' {
+				// An initial, as in "John Q. Public".
+				continue
+			}
+		}
+		return s[:i+1]
+	}
+	return s
+}
+
 func splitsemi(s string) []string {
 	fields := strings.Split(s, ";")
 	var result []string
@@ -553,12 +1601,115 @@ func splitsemi(s string) []string {
 	return result
 }
 
+// languageCode maps a language name,
+// as found in IMDb's details,
+// to the ISO 639-2 code that Kodi uses in stream details.
+// Values that are not recognized are returned unchanged.
+func languageCode(lang string) string {
+	if code, ok := languageCodes[strings.ToLower(lang)]; ok {
+		return code
+	}
+	return lang
+}
+
+var languageCodes = map[string]string{
+	"arabic":     "ara",
+	"cantonese":  "yue",
+	"chinese":    "chi",
+	"czech":      "cze",
+	"danish":     "dan",
+	"dutch":      "dut",
+	"english":    "eng",
+	"finnish":    "fin",
+	"french":     "fre",
+	"german":     "ger",
+	"greek":      "gre",
+	"hebrew":     "heb",
+	"hindi":      "hin",
+	"hungarian":  "hun",
+	"italian":    "ita",
+	"japanese":   "jpn",
+	"korean":     "kor",
+	"mandarin":   "chi",
+	"norwegian":  "nor",
+	"persian":    "per",
+	"polish":     "pol",
+	"portuguese": "por",
+	"russian":    "rus",
+	"spanish":    "spa",
+	"swedish":    "swe",
+	"thai":       "tha",
+	"turkish":    "tur",
+}
+
+// parseActor parses an entry in the actors column,
+// which is either "Name" or "Name (Role)".
+func parseActor(s string) (name, role string) {
+	if !strings.HasSuffix(s, ")") {
+		return s, ""
+	}
+	i := strings.Index(s, " (")
+	if i < 0 {
+		return s, ""
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+2 : len(s)-1])
+}
+
+// formatActor is the inverse of parseActor.
+func formatActor(name, role string) string {
+	if role == "" {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, role)
+}
+
 const staleTime = 5 * time.Minute
 
 func isStale(t time.Time) bool {
 	return t.Before(time.Now().Add(-staleTime))
 }
 
+// dirEntry is an item in the HTML listing of a directory.
+type dirEntry struct {
+	Href  template.URL
+	Label string // the title and year, for humans
+	Root  string // the root name of the title, if the item is one
+}
+
+// dirEntries pairs the given directory items with labels
+// naming the titles of their media objects and playlists.
+// The objName function maps an item to the name of the object it stands for,
+// as in propfindDir;
+// if it's nil, there are no labels.
+// The link text of each entry must remain the item itself,
+// since that's how Kodi recognizes it.
+// The caller must hold s.mu.
+func (s *server) dirEntries(items []template.URL, objName func(string) string) []dirEntry {
+	entries := make([]dirEntry, 0, len(items))
+	for _, item := range items {
+		entry := dirEntry{Href: item}
+		if objName != nil {
+			name := objName(string(item))
+			if ext := filepath.Ext(name); ext == ".m3u" || isMediaExt(ext) {
+				entry.Root = strings.TrimSuffix(name, ext)
+			} else if dir, ok := strings.CutSuffix(string(item), "/"); ok {
+				if rootName, rest, ok := parseDiscPath(dir); ok && rest == "" {
+					entry.Root = rootName // a disc title's folder
+				}
+			}
+		}
+		if entry.Root != "" {
+			info := s.infoMap[entry.Root]
+			entry.Label = displayTitle(entry.Root, info)
+			if info.Year > 0 {
+				entry.Label += fmt.Sprintf(" (%d)", info.Year)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
 const dirTemplate = `
 <!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 3.2 Final//EN">
 <html>
@@ -568,12 +1719,20 @@ const dirTemplate = `
  <body>
   <h1>Index</h1>
   <ul>
-   {{ range . }}
+   {{ range .Entries }}
     <li>
-     <a href="{{ . }}">{{ . }}</a>
+     {{ with .Label }}<b>{{ . }}</b>{{ end }}
+     <a href="{{ .Href }}">{{ .Href }}</a>
     </li>
    {{ end }}
   </ul>
+  {{ with .Page }}
+   <p>
+    {{ with .Prev }}<a href="?page={{ . }}">Previous page</a>{{ end }}
+    Page {{ .Page }} of {{ .Pages }}
+    {{ with .Next }}<a href="?page={{ . }}">Next page</a>{{ end }}
+   </p>
+  {{ end }}
  </body>
 </html>
 `
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+func TestParseActor(t *testing.T) {
+	cases := []struct {
+		inp, wantName, wantRole string
+	}{
+		{"William Powell", "William Powell", ""},
+		{"William Powell (Nick Charles)", "William Powell", "Nick Charles"},
+		{"Asta (Asta (uncredited))", "Asta", "Asta (uncredited)"},
+		{"Sam Jaffe(Moe)", "Sam Jaffe(Moe)", ""},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case_%02d", i+1), func(t *testing.T) {
+			name, role := parseActor(c.inp)
+			if name != c.wantName || role != c.wantRole {
+				t.Errorf("got (%s, %s), want (%s, %s)", name, role, c.wantName, c.wantRole)
+			}
+			if role != "" {
+				if got := formatActor(name, role); got != c.inp {
+					t.Errorf("formatActor: got %s, want %s", got, c.inp)
+				}
+			}
+		})
+	}
+}
+
+func TestParseActorCells(t *testing.T) {
+	type want struct {
+		name, role, headshot string
+	}
+	cases := []struct {
+		name                string
+		actors, actorThumbs string
+		want                []want
+	}{{
+		name:   "names only",
+		actors: "William Powell; Myrna Loy",
+		want:   []want{{name: "William Powell"}, {name: "Myrna Loy"}},
+	}, {
+		name:   "names and roles",
+		actors: "William Powell (Nick Charles);Myrna Loy (Nora Charles); Asta (Asta (uncredited))",
+		want: []want{
+			{name: "William Powell", role: "Nick Charles"},
+			{name: "Myrna Loy", role: "Nora Charles"},
+			{name: "Asta", role: "Asta (uncredited)"},
+		},
+	}, {
+		name:   "blank entries skipped",
+		actors: " ; William Powell (Nick Charles) ;; ",
+		want:   []want{{name: "William Powell", role: "Nick Charles"}},
+	}, {
+		name:        "headshots matched by name",
+		actors:      "William Powell (Nick Charles); Myrna Loy (Nora Charles)",
+		actorThumbs: "Myrna Loy = https://example.com/loy.jpg; Maureen O'Sullivan=https://example.com/osullivan.jpg; bogus",
+		want: []want{
+			{name: "William Powell", role: "Nick Charles"},
+			{name: "Myrna Loy", role: "Nora Charles", headshot: "https://example.com/loy.jpg"},
+		},
+	}}
+
+	var (
+		s        = &server{}
+		headings = []string{"filename", "actors", "actorthumbs"}
+	)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, info := s.parseInfoRow(headings, "The Thin Man.iso", []interface{}{"The Thin Man.iso", c.actors, c.actorThumbs})
+			if len(info.Actors) != len(c.want) {
+				t.Fatalf("got %d actors, want %d", len(info.Actors), len(c.want))
+			}
+			for i, a := range info.Actors {
+				var headshot string
+				if a.Thumb != nil {
+					headshot = a.Thumb.origVal
+				}
+				got := want{name: a.Name, role: a.Role, headshot: headshot}
+				if got != c.want[i] {
+					t.Errorf("actor %d: got %+v, want %+v", i, got, c.want[i])
+				}
+				if a.Order != i {
+					t.Errorf("actor %d: got order %d", i, a.Order)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleNFO(t *testing.T) {
+	cases := []struct {
+		name     string
+		headings []string
+		cells    []interface{} // after the filename
+		objs     []string      // in the bucket besides the title's media object
+		want     []string      // in the NFO
+		wantNot  []string
+	}{{
+		name:     "headshots from actorthumbs",
+		headings: []string{"actors", "actorthumbs"},
+		cells:    []interface{}{"William Powell (Nick <Charles>); Myrna Loy", "William Powell=https://example.com/powell.jpg?w=1&h=2"},
+		want: []string{
+			"<name>William Powell</name>\n    <role>Nick &lt;Charles&gt;</role>\n    <order>0</order>\n    <thumb>http://media.example.com:1549/actors/William%2520Powell.jpg</thumb>",
+			"<name>Myrna Loy</name>\n    <order>1</order>\n  </actor>",
+		},
+		wantNot: []string{"example.com/powell.jpg"},
+	}, {
+		// Mirrored by ssupdate -headshots,
+		// for actors not in the actorthumbs column.
+		name:     "headshots in the bucket",
+		headings: []string{"actors"},
+		cells:    []interface{}{"Asta & Co (Asta); Myrna Loy"},
+		objs:     []string{"actors/Asta & Co.jpg"},
+		want: []string{
+			"<name>Asta &amp; Co</name>\n    <role>Asta</role>\n    <order>0</order>\n    <thumb>http://media.example.com:1549/actors/Asta%2520&amp;%2520Co.jpg</thumb>",
+			"<name>Myrna Loy</name>\n    <order>1</order>\n  </actor>",
+		},
+	}, {
+		name:     "countries",
+		headings: []string{"country"},
+		cells:    []interface{}{"United States; ; Bosnia & Herzegovina"},
+		want:     []string{"<country>United States</country>\n  <country>Bosnia &amp; Herzegovina</country>"},
+		wantNot:  []string{"<country></country>"},
+	}, {
+		// Known languages become ISO 639-2 codes; others are passed through.
+		name:     "languages",
+		headings: []string{"language"},
+		cells:    []interface{}{"English; french;Serbo<Croatian>"},
+		want: []string{
+			"<fileinfo>\n    <streamdetails>\n      <audio>\n        <language>eng</language>\n      </audio>\n      <audio>\n        <language>fre</language>\n      </audio>\n      <audio>\n        <language>Serbo&lt;Croatian&gt;</language>\n      </audio>\n    </streamdetails>\n  </fileinfo>",
+		},
+	}, {
+		name:     "no languages",
+		headings: []string{"language"},
+		cells:    []interface{}{" ; "},
+		wantNot:  []string{"<fileinfo>"},
+	}, {
+		name:     "set",
+		headings: []string{"set"},
+		cells:    []interface{}{" The Thin Man <Collection> & Co "},
+		want:     []string{"<set>\n    <name>The Thin Man &lt;Collection&gt; &amp; Co</name>\n  </set>"},
+	}, {
+		name:     "blank set",
+		headings: []string{"set"},
+		cells:    []interface{}{"  "},
+		wantNot:  []string{"<set>"},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var (
+				now = time.Now()
+				s   = &server{
+					listenAddr:   "media.example.com:1549",
+					objNames:     set.New(append(c.objs, "Nick & Nora.mkv")...),
+					objNamesTime: now,
+					infoMapTime:  now,
+				}
+			)
+			// URLs are escaped once by url.PathEscape and again by relURL,
+			// and handleHeadshot unescapes them to match.
+			_, info := s.parseInfoRow(append([]string{"filename"}, c.headings...), "Nick & Nora.mkv", append([]interface{}{"Nick & Nora.mkv"}, c.cells...))
+			s.infoMap = map[string]movieInfo{"Nick & Nora": info}
+
+			rec := httptest.NewRecorder()
+			if err := s.handleNFO(rec, httptest.NewRequest("GET", "/"+url.PathEscape(rootNamePrefix("Nick & Nora")+"Nick & Nora.nfo"), nil), "Nick & Nora.nfo"); err != nil {
+				t.Fatal(err)
+			}
+			body := rec.Body.String()
+			for _, want := range c.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("NFO lacks %s; got:\n%s", want, body)
+				}
+			}
+			for _, wantNot := range c.wantNot {
+				if strings.Contains(body, wantNot) {
+					t.Errorf("NFO has %s; got:\n%s", wantNot, body)
+				}
+			}
+		})
+	}
+}
+
+func TestFirstSentence(t *testing.T) {
+	cases := []struct {
+		inp, want string
+	}{
+		{"", ""},
+		{"A single sentence.", "A single sentence."},
+		{"No period", "No period"},
+		{"Nick and Nora solve a murder. Asta helps.", "Nick and Nora solve a murder."},
+		{"Mr. Smith goes to Washington. He filibusters.", "Mr. Smith goes to Washington."},
+		{"John Q. Public wins! Then loses.", "John Q. Public wins!"},
+		{"Who killed Dr. Wynant? Nobody knows.", "Who killed Dr. Wynant?"},
+		{"It costs $3.50 to enter. Cheap.", "It costs $3.50 to enter."},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case_%02d", i+1), func(t *testing.T) {
+			if got := firstSentence(c.inp); got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestHiddenObj(t *testing.T) {
+	s := &server{
+		infoMap: map[string]movieInfo{
+			"The Thin Man":         {hidden: true},
+			"After the Thin Man":   {},
+			"The Electric Company": {hidden: true, parts: "The Electric Company, Disc *.iso"},
+			"Another Thin Man":     {parts: "Another Thin Man, Disc *.iso"},
+		},
+	}
+	cases := map[string]bool{
+		"The Thin Man.iso":                 true,
+		"The Thin Man.jpg":                 true,
+		"The Thin Man.nfo":                 true,
+		"After the Thin Man.iso":           false,
+		"The Electric Company, Disc 2.iso": true,
+		"Another Thin Man, Disc 1.iso":     false,
+		"Shadow of the Thin Man.iso":       false,
+	}
+	for objName, want := range cases {
+		if got := s.hiddenObj(objName); got != want {
+			t.Errorf("hiddenObj(%q) = %v, want %v", objName, got, want)
+		}
+	}
+}
+
+func TestMediaExts(t *testing.T) {
+	saved := mediaExts()
+	defer setMediaExts(saved)
+
+	setMediaExts(parseMediaExts(" mkv, .AVI,,ts "))
+	if got, want := mediaExts().Len(), 3; got != want {
+		t.Fatalf("got %d extensions, want %d", got, want)
+	}
+
+	s := &server{objNames: set.New("Alien.MKV", "Brazil.avi", "Clue.ts", "Clue.txt", "Dune.mp4")}
+	for rootName, want := range map[string]string{
+		"Alien":  "Alien.MKV",
+		"Brazil": "Brazil.avi",
+		"Clue":   "Clue.ts",
+		"Dune":   "",
+	} {
+		got, _ := s.mediaObjName(rootName)
+		if got != want {
+			t.Errorf("mediaObjName(%q) = %q, want %q", rootName, got, want)
+		}
+	}
+}
+
+func TestTitleItemsOrder(t *testing.T) {
+	s := &server{
+		objNames: set.New("b.iso", "a.iso", "c.mp4"),
+		infoMap: map[string]movieInfo{
+			"a": {Title: "The Zebra", SortTitle: "zebra", Year: 2001},
+			"b": {Title: "Aardvark", SortTitle: "aardvark"},
+		},
+	}
+	items := s.titleItems(func(movieInfo, bool) bool { return true })
+
+	var got []string
+	for _, e := range s.dirEntries(items, unprefixed) {
+		if e.Label != "" {
+			got = append(got, e.Label)
+		}
+	}
+	want := []string{"Aardvark", "c", "The Zebra (2001)"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestServeObjHead(t *testing.T) {
+	var (
+		s       = new(server) // no bucket: GCS must not be consulted
+		modTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		heads   = map[string]objHead{
+			"The Thin Man.mp4": {size: 12345, modTime: modTime, etag: "abc"},
+		}
+	)
+	s.objHeads.Store(&heads)
+
+	req := httptest.NewRequest("HEAD", "/x-The%20Thin%20Man.mp4", nil)
+	rec := httptest.NewRecorder()
+	if err := s.serveObj(req.Context(), rec, req, "The Thin Man.mp4", "/x-The Thin Man.mp4", false); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	for header, want := range map[string]string{
+		"Content-Length": "12345",
+		"Content-Type":   "video/mp4",
+		"ETag":           `"abc"`,
+		"Last-Modified":  modTime.Format(http.TimeFormat),
+		"Accept-Ranges":  "bytes",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("got %s %q, want %q", header, got, want)
+		}
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("got %d-byte body", rec.Body.Len())
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"Disc 2", "Disc 10", true},
+		{"Disc 10", "Disc 2", false},
+		{"Disc 2", "Disc 2", false},
+		{"Disc 02", "Disc 10", true},
+		{"Disc 002", "Disc 2", false},
+		{"Disc 2", "Disc 2a", true},
+		{"Part 9, Disc 10", "Part 10, Disc 1", true},
+		{"a", "b", true},
+		{"", "a", true},
+		{"9", "a", true},
+	}
+	for _, c := range cases {
+		if got := naturalLess(c.a, c.b); got != c.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	var (
+		topHat = rootNamePrefix("Top Hat") + "Top Hat.mp4"
+		nfo    = rootNamePrefix("Top Hat") + "Top Hat.nfo"
+		sub    = rootNamePrefix("Top Hat") + "Top Hat.en.srt"
+	)
+	cases := []struct {
+		path, wantSubdir, wantObjname string
+	}{
+		{path: "", wantSubdir: ""},
+		{path: "musicals", wantSubdir: "musicals"},
+		{path: topHat, wantObjname: topHat},
+		{path: "musicals/" + nfo, wantSubdir: "musicals", wantObjname: nfo},
+		{path: sub, wantObjname: sub},
+		{path: "musicals/0000000-Top Hat.mp4", wantSubdir: "musicals/0000000-Top Hat.mp4"}, // wrong prefix
+		{path: "short", wantSubdir: "short"},
+	}
+	for _, c := range cases {
+		subdir, objname := parsePath(c.path)
+		if subdir != c.wantSubdir || objname != c.wantObjname {
+			t.Errorf("parsePath(%q) = %q, %q; want %q, %q", c.path, subdir, objname, c.wantSubdir, c.wantObjname)
+		}
+	}
+}
+
+func TestPartsOf(t *testing.T) {
+	s := &server{
+		objNames: set.New(
+			"Shoah, Disc 10.iso",
+			"Shoah, Disc 2.iso",
+			"Shoah, Disc 1.iso",
+			"Shoah.nfo",
+			"Napoleon [1927], Part 2.mkv",
+			"Napoleon [1927], Part 1.mkv",
+			"Top Hat.mp4",
+		),
+		infoMap: map[string]movieInfo{
+			"Shoah":           {parts: "Shoah, Disc *.iso"},
+			"Napoleon [1927]": {parts: `Napoleon \[1927\], Part ?.mkv`},
+			"Greed":           {parts: "Greed, Part *.mkv"}, // no parts in the bucket
+			"Top Hat":         {},
+		},
+	}
+
+	want := partsIndex{
+		"Shoah":           {"Shoah, Disc 1.iso", "Shoah, Disc 2.iso", "Shoah, Disc 10.iso"},
+		"Napoleon [1927]": {"Napoleon [1927], Part 1.mkv", "Napoleon [1927], Part 2.mkv"},
+	}
+	idx := s.indexParts()
+	if fmt.Sprint(idx) != fmt.Sprint(want) {
+		t.Errorf("got index %v, want %v", idx, want)
+	}
+	for rootName, info := range s.infoMap {
+		if got := s.partsOf(info); fmt.Sprint(got) != fmt.Sprint(want[rootName]) {
+			t.Errorf("got parts %v for %s, want %v", got, rootName, want[rootName])
+		}
+	}
+	if got := idx.grouped(); got.Len() != 5 || got.Has("Top Hat.mp4") {
+		t.Errorf("got grouped objects %v", got.Slice())
+	}
+
+	// A title with no parts in the bucket is not listed.
+	var got []string
+	for _, g := range s.titleGroups(func(movieInfo, bool) bool { return true }) {
+		got = append(got, g.rootName)
+	}
+	sort.Strings(got)
+	if want := []string{"Napoleon [1927]", "Shoah", "Top Hat"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got titles %v, want %v", got, want)
+	}
+}
+
+func TestHandleM3U(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		objNames:     set.New("Shoah, Disc 2.iso", "Shoah, Disc 1.iso", "Top Hat.mp4"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"Shoah":   {Title: "Shoah", parts: "Shoah, Disc *.iso"},
+			"Greed":   {Title: "Greed", parts: "Greed, Part *.mkv"},
+			"Top Hat": {Title: "Top Hat"},
+		},
+		infoMapTime: now,
+	}
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h := mid.Err(func(w http.ResponseWriter, req *http.Request) error {
+			return s.handleM3U(w, req, path)
+		})
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/x-"+url.PathEscape(path), nil))
+		return rec
+	}
+
+	rec := serve("Shoah.m3u")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	want := strings.Join([]string{
+		"#EXTM3U",
+		"#EXTINF:-1,Shoah (1)",
+		url.PathEscape(rootNamePrefix("Shoah, Disc 1") + "Shoah, Disc 1.iso"),
+		"#EXTINF:-1,Shoah (2)",
+		url.PathEscape(rootNamePrefix("Shoah, Disc 2") + "Shoah, Disc 2.iso"),
+		"",
+	}, "\n")
+	if got := rec.Body.String(); got != want {
+		t.Errorf("got playlist:\n%s\nwant:\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "audio/x-mpegurl" {
+		t.Errorf("got Content-Type %s", ct)
+	}
+
+	for _, path := range []string{"Greed.m3u", "Top Hat.m3u", "Metropolis.m3u"} {
+		if rec := serve(path); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d, want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestStreamFailures(t *testing.T) {
+	cases := []struct {
+		name     string
+		obj      string // the object to put in the bucket, if any
+		rng      string // the Range header
+		gcs      int    // the status of every GCS response, if not zero
+		wantCode int
+		wantFail bool
+	}{{
+		name:     "ok",
+		obj:      "The Thin Man.mp4",
+		wantCode: http.StatusOK,
+	}, {
+		name:     "missing object",
+		wantCode: http.StatusNotFound,
+	}, {
+		name:     "unsatisfiable range",
+		obj:      "The Thin Man.mp4",
+		rng:      "bytes=1000-",
+		wantCode: http.StatusRequestedRangeNotSatisfiable,
+	}, {
+		// As when the server's credentials can't read the bucket.
+		name:     "GCS error",
+		obj:      "The Thin Man.mp4",
+		gcs:      http.StatusForbidden,
+		wantCode: http.StatusInternalServerError,
+		wantFail: true,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, bucket := newFakeGCS(t)
+			if c.obj != "" {
+				f.put(c.obj, []byte("Asta"))
+			}
+			f.fail(c.gcs)
+
+			now := time.Now()
+			s := &server{
+				bucket:       bucket,
+				objNames:     set.New("The Thin Man.mp4"),
+				objNamesTime: now,
+				infoMap:      map[string]movieInfo{"The Thin Man": {Title: "The Thin Man"}},
+				infoMapTime:  now,
+				health:       newHealthCounters(),
+				stats:        newAccessStats(),
+			}
+
+			req := httptest.NewRequest("GET", "/"+url.PathEscape(rootNamePrefix("The Thin Man")+"The Thin Man.mp4"), nil)
+			if c.rng != "" {
+				req.Header.Set("Range", c.rng)
+			}
+			code := http.StatusOK
+			if err := s.handle(httptest.NewRecorder(), req); err != nil {
+				code = errorCode(err)
+			}
+			if code != c.wantCode {
+				t.Errorf("got status %d, want %d", code, c.wantCode)
+			}
+			if got := s.health.streamFailures.Load() > 0; got != c.wantFail {
+				t.Errorf("got stream failure %v, want %v", got, c.wantFail)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/bobg/errors"
+)
+
+// With serve -reuseport,
+// the server listens with SO_REUSEPORT,
+// so that a new kodigcs can start listening at the same addresses
+// while the old one is still running.
+// And when systemd starts the server through a socket unit
+// (socket activation),
+// it serves on the socket that systemd passes it
+// instead of listening at -listen.
+//
+// Either way,
+// another process can take over the server's socket,
+// so when the server is told to stop,
+// it stops accepting connections at once,
+// leaving new ones to its successor
+// (or, with socket activation, to wait in the socket until systemd starts one),
+// and lets its streams in progress finish
+// (within -drain-timeout; see drain.go).
+// So to deploy a new binary without interrupting anyone's movie,
+// start it with -reuseport,
+// then send the old one SIGTERM.
+
+// The first file descriptor passed by systemd socket activation.
+const listenFDsStart = 3
+
+// activationListener returns the listener passed by systemd socket activation,
+// or nil if there is none.
+func activationListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n == 0 {
+		return nil, nil
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("systemd passed %d sockets, want 1", n)
+	}
+
+	// Child processes (such as ffmpeg) shouldn't think the socket is theirs.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(listenFDsStart, "systemd socket")
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	return ln, errors.Wrap(err, "using socket from systemd")
+}
+
+// listen listens for TCP connections at addr,
+// with SO_REUSEPORT if the server has -reuseport.
+func (s *server) listen(ctx context.Context, addr string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if s.reusePort {
+		lc.Control = reusePortControl
+	}
+	ln, err := lc.Listen(ctx, "tcp", addr)
+	return ln, errors.Wrapf(err, "listening on %s", addr)
+}
+
+// handoff tells whether another process can take over the server's socket.
+func (s *server) handoff() bool {
+	return s.reusePort || s.socket != nil
+}
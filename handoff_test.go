@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReusePort(t *testing.T) {
+	var (
+		ctx = context.Background()
+		s   = &server{reusePort: true}
+	)
+
+	old, err := s.listen(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	addr := old.Addr().String()
+
+	successor, err := s.listen(ctx, addr)
+	if err != nil {
+		t.Fatalf("listening a second time at %s with -reuseport: %s", addr, err)
+	}
+	successor.Close()
+
+	if ln, err := (&server{}).listen(ctx, addr); err == nil {
+		ln.Close()
+		t.Errorf("listening a second time at %s without -reuseport succeeded", addr)
+	}
+	if !s.handoff() {
+		t.Error("no handoff with -reuseport")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+// Every response carries some standard security headers,
+// plus Strict-Transport-Security when the client reached the server with TLS.
+//
+// With serve -cors-origins,
+// the JSON API (under /api/) also answers cross-origin requests from the given origins,
+// so that a web UI or dashboard served from elsewhere can use it.
+// An origin of * allows any origin,
+// but only for requests without ambient credentials
+// (i.e., with an explicit Authorization header, such as an API token),
+// and not for requests that change anything.
+
+const (
+	hstsHeader = "max-age=31536000"
+
+	corsAllowMethods = "GET, POST, PUT, PATCH, DELETE"
+	corsAllowHeaders = "Authorization, Content-Type, " + pinHeader
+	corsMaxAge       = "600"
+)
+
+type corsOriginKeyType struct{}
+
+var corsOriginKey corsOriginKeyType
+
+// parseCORSOrigins parses a comma-separated list of origins, such as https://dash.example.com.
+func parseCORSOrigins(s string) (set.Of[string], error) {
+	result := set.New[string]()
+	for _, o := range strings.Split(s, ",") {
+		o = strings.TrimRight(strings.TrimSpace(o), "/")
+		if o == "" {
+			continue
+		}
+		if o != "*" {
+			u, err := url.Parse(o)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+				return nil, fmt.Errorf("bad origin %s, want SCHEME://HOST[:PORT]", o)
+			}
+		}
+		result.Add(o)
+	}
+	return result, nil
+}
+
+// corsOrigin returns the origin of a cross-origin API request if it is one of the allowed origins,
+// and whether it is allowed at all (possibly because * is).
+func (s *server) corsOrigin(req *http.Request) (origin string, listed, ok bool) {
+	origin = req.Header.Get("Origin")
+	if origin == "" || !strings.HasPrefix(req.URL.Path, "/api/") {
+		return "", false, false
+	}
+	if s.corsOrigins.Has(origin) {
+		return origin, true, true
+	}
+	return origin, false, s.corsOrigins.Has("*")
+}
+
+// withHeaders wraps the server's handler to add the security headers and CORS headers to its responses,
+// and to answer CORS preflight requests
+// (which carry no credentials).
+func (s *server) withHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hdr := w.Header()
+		hdr.Set("X-Content-Type-Options", "nosniff")
+		hdr.Set("X-Frame-Options", "SAMEORIGIN")
+		hdr.Set("Referrer-Policy", "same-origin") // keep share and playlist secrets in URLs from leaking to other sites
+		if s.requestScheme(req) == "https" {
+			hdr.Set("Strict-Transport-Security", hstsHeader)
+		}
+
+		if len(s.corsOrigins) == 0 {
+			h.ServeHTTP(w, req)
+			return
+		}
+		hdr.Add("Vary", "Origin")
+
+		origin, listed, ok := s.corsOrigin(req)
+		if !ok {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		if listed {
+			hdr.Set("Access-Control-Allow-Origin", origin)
+			hdr.Set("Access-Control-Allow-Credentials", "true")
+		} else {
+			hdr.Set("Access-Control-Allow-Origin", "*")
+		}
+
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			hdr.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			hdr.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			hdr.Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if listed {
+			req = req.WithContext(context.WithValue(req.Context(), corsOriginKey, origin))
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// corsAllowed tells whether the request comes from one of the origins listed in -cors-origins,
+// which checkSameOrigin then accepts.
+func corsAllowed(req *http.Request, origin string) bool {
+	o, _ := req.Context().Value(corsOriginKey).(string)
+	return o != "" && o == origin
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bobg/mid"
+)
+
+func TestWithHeaders(t *testing.T) {
+	origins, err := parseCORSOrigins("https://dash.example.com/, *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !origins.Has("https://dash.example.com") || !origins.Has("*") {
+		t.Fatalf("got origins %v", origins.Slice())
+	}
+	if _, err := parseCORSOrigins("dash.example.com"); err == nil {
+		t.Error("parsed an origin without a scheme")
+	}
+
+	s := &server{tls: true, corsOrigins: origins}
+	var reached bool
+	h := s.withHeaders(mid.Err(func(w http.ResponseWriter, req *http.Request) error {
+		reached = true
+		return checkSameOrigin(req)
+	}))
+
+	do := func(method, path, origin string, prep func(*http.Request)) *httptest.ResponseRecorder {
+		reached = false
+		req := httptest.NewRequest(method, path, nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if prep != nil {
+			prep(req)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("GET", "/gallery/", "", nil)
+	if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("got X-Content-Type-Options %q", got)
+	}
+	if got := rec.Header().Get("Strict-Transport-Security"); got != hstsHeader {
+		t.Errorf("got Strict-Transport-Security %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("got Access-Control-Allow-Origin %q without an Origin", got)
+	}
+
+	rec = do("OPTIONS", "/api/share/Top%20Hat", "https://dash.example.com", func(req *http.Request) {
+		req.Header.Set("Access-Control-Request-Method", "POST")
+	})
+	if reached || rec.Code != http.StatusNoContent {
+		t.Errorf("preflight reached handler %v, got status %d", reached, rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://dash.example.com" {
+		t.Errorf("got Access-Control-Allow-Origin %q for preflight", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("got Access-Control-Allow-Credentials %q for preflight", got)
+	}
+
+	// A listed origin may make changes.
+	rec = do("POST", "/api/share/Top%20Hat", "https://dash.example.com", nil)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("got status %d for a POST from a listed origin", rec.Code)
+	}
+
+	// Other origins may read, because of *, but not make changes.
+	rec = do("POST", "/api/share/Top%20Hat", "https://evil.example.com", nil)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("got status %d for a POST from another origin", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("got Access-Control-Allow-Origin %q for another origin", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("got Access-Control-Allow-Credentials %q for another origin", got)
+	}
+
+	// Only the API is open to other origins.
+	rec = do("GET", "/gallery/", "https://dash.example.com", nil)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("got Access-Control-Allow-Origin %q outside the API", got)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// A headingMap translates the headings of metadata
+// whose columns are named differently
+// (in another language, say)
+// to the ones kodigcs understands.
+// It comes from a YAML or JSON file given with the -headings flag,
+// mapping each heading to the one it stands for:
+//
+//	Fájlnév: filename
+//	Cím: title
+//	Év: year
+//	Szereplők: actors
+type headingMap struct {
+	canon   map[string]string // lowercase heading -> kodigcs heading
+	display map[string]string // kodigcs heading -> heading as given in the file
+}
+
+// currentHeadings holds the headingMap from the -headings flag, if any.
+// It is replaced when the server reloads its configuration (see reload.go).
+var currentHeadings atomic.Pointer[headingMap]
+
+// headingAliases is the headingMap from the -headings flag, if any.
+func headingAliases() headingMap {
+	if h := currentHeadings.Load(); h != nil {
+		return *h
+	}
+	return headingMap{}
+}
+
+// loadHeadingMap reads a headingMap from the named file,
+// which may be a local path or a gs://BUCKET/OBJECT URL.
+func loadHeadingMap(ctx context.Context, name string, gcs *storage.Client) (headingMap, error) {
+	r, err := sourceFile{name: name, gcs: gcs}.open(ctx)
+	if err != nil {
+		return headingMap{}, err
+	}
+	defer r.Close()
+
+	var raw map[string]string
+	if err := yaml.NewDecoder(r).Decode(&raw); err != nil {
+		return headingMap{}, errors.Wrapf(err, "parsing %s", name)
+	}
+	return newHeadingMap(raw)
+}
+
+func newHeadingMap(raw map[string]string) (headingMap, error) {
+	m := headingMap{
+		canon:   make(map[string]string),
+		display: make(map[string]string),
+	}
+	for heading, target := range raw {
+		key := strings.ToLower(strings.TrimSpace(heading))
+		target = strings.ToLower(strings.TrimSpace(target))
+		if target != "filename" && !knownHeadings.Has(target) {
+			return headingMap{}, fmt.Errorf("unknown heading %q for %q", target, heading)
+		}
+		if key != target && (key == "filename" || knownHeadings.Has(key)) {
+			// This keeps canonical idempotent.
+			return headingMap{}, fmt.Errorf("cannot map heading %q, which kodigcs already understands, to %q", heading, target)
+		}
+		if other, ok := m.display[target]; ok {
+			return headingMap{}, fmt.Errorf("both %q and %q map to %q", other, heading, target)
+		}
+		m.canon[key] = target
+		m.display[target] = strings.TrimSpace(heading)
+	}
+	return m, nil
+}
+
+// canonical returns the lowercase kodigcs heading for the given heading of the metadata.
+func (m headingMap) canonical(heading string) string {
+	heading = strings.ToLower(heading)
+	if target, ok := m.canon[strings.TrimSpace(heading)]; ok {
+		return target
+	}
+	return heading
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestHeadingMap(t *testing.T) {
+	m, err := newHeadingMap(map[string]string{"Cím": "title", "Év": "Year", "Fájlnév": "filename"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"CÍM":       "title",
+		" év ":      "year",
+		"Fájlnév":   "filename",
+		"Title":     "title",
+		"Rendező":   "rendező",
+		"Directors": "directors",
+	}
+	for heading, want := range cases {
+		if got := m.canonical(heading); got != want {
+			t.Errorf("canonical(%q) = %q, want %q", heading, got, want)
+		}
+		if got := m.canonical(m.canonical(heading)); got != want {
+			t.Errorf("canonical(canonical(%q)) = %q, want %q", heading, got, want)
+		}
+	}
+	if got := m.display["year"]; got != "Év" {
+		t.Errorf("got display %q for year, want Év", got)
+	}
+
+	bad := []map[string]string{
+		{"Cím": "titel"},
+		{"Title": "sort"},
+		{"Cím": "title", "Név": "title"},
+	}
+	for _, raw := range bad {
+		if _, err := newHeadingMap(raw); err == nil {
+			t.Errorf("got no error for %v", raw)
+		}
+	}
+}
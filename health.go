@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// The /healthz and /readyz endpoints are for load balancers and orchestrators such as Kubernetes,
+// and need no authentication.
+// /healthz answers 200 whenever the server is running.
+// /readyz answers 200 if the server can reach the bucket and the metadata source,
+// and 503 if not or if the server is shutting down,
+// checking at most once every readyCheckInterval no matter how often it's asked.
+// Its JSON body reports the checks and the ages of the server's caches.
+
+const (
+	readyCheckInterval = 30 * time.Second
+	readyCheckTimeout  = 10 * time.Second
+)
+
+// readiness is the outcome of the last readiness check.
+type readiness struct {
+	mu      sync.Mutex
+	checked time.Time
+	checks  map[string]string // check name -> "ok" or "failing"
+	ready   bool
+}
+
+// readyReport is the body of a /readyz response.
+type readyReport struct {
+	Ready   bool              `json:"ready"`
+	Checked time.Time         `json:"checked"`
+	Checks  map[string]string `json:"checks"`
+
+	// Ages, in seconds, of the cached list of bucket objects and of the metadata,
+	// omitted if they have not been loaded.
+	ObjectsAge  *int64 `json:"objects_age,omitempty"`
+	MetadataAge *int64 `json:"metadata_age,omitempty"`
+
+	// How long, in seconds, reloading the metadata has been failing, if it has.
+	MetadataFailing int64 `json:"metadata_failing,omitempty"`
+
+	// Whether the server is shutting down (see drain.go).
+	Draining bool `json:"draining,omitempty"`
+}
+
+// handleHealthz serves /healthz.
+func (s *server) handleHealthz(w http.ResponseWriter, req *http.Request) error {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err := w.Write([]byte("ok\n"))
+	return err
+}
+
+// handleReadyz serves /readyz.
+func (s *server) handleReadyz(w http.ResponseWriter, req *http.Request) error {
+	report := s.checkReady(req.Context(), time.Now())
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(report), "encoding readiness report")
+}
+
+// checkReady reports whether the server is ready,
+// checking its connections again if the last check is more than readyCheckInterval old.
+func (s *server) checkReady(ctx context.Context, now time.Time) readyReport {
+	r := &s.readiness
+	r.mu.Lock()
+	if r.checks == nil || now.Sub(r.checked) >= readyCheckInterval {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), readyCheckTimeout)
+		r.checks, r.ready = s.runReadyChecks(ctx)
+		cancel()
+		r.checked = now
+	}
+	report := readyReport{
+		Ready:           r.ready,
+		Checked:         r.checked,
+		Checks:          r.checks,
+		MetadataFailing: int64(s.health.sheetStaleness().Seconds()),
+	}
+	r.mu.Unlock()
+
+	if s.draining.Load() {
+		report.Ready, report.Draining = false, true
+	}
+
+	s.mu.RLock()
+	report.ObjectsAge = ageSeconds(s.objNamesTime, now)
+	report.MetadataAge = ageSeconds(s.infoMapTime, now)
+	s.mu.RUnlock()
+
+	return report
+}
+
+// runReadyChecks checks that the server can reach the bucket and the metadata source.
+// Failures are logged rather than reported,
+// since /readyz is open to anyone.
+func (s *server) runReadyChecks(ctx context.Context) (map[string]string, bool) {
+	var (
+		checks = make(map[string]string)
+		ready  = true
+	)
+	check := func(name string, err error) {
+		if err != nil {
+			log.Printf("Readiness check %s failed: %s", name, err)
+			checks[name] = "failing"
+			ready = false
+			return
+		}
+		checks[name] = "ok"
+	}
+
+	_, err := s.bucket.Attrs(ctx)
+	check("bucket", errors.Wrap(err, "getting bucket attributes"))
+
+	switch src := s.meta.(type) {
+	case sheetsSource:
+		_, err := src.ssvc.Get(src.sheetID).Fields("spreadsheetId").Context(ctx).Do()
+		check("metadata", errors.Wrap(err, "getting spreadsheet"))
+	case versionedSource:
+		_, err := src.version(ctx)
+		check("metadata", err)
+	}
+
+	return checks, ready
+}
+
+// ageSeconds is the age of t in seconds,
+// or nil if t is zero.
+func ageSeconds(t, now time.Time) *int64 {
+	if t.IsZero() {
+		return nil
+	}
+	age := int64(now.Sub(t).Seconds())
+	return &age
+}
+
+// routeHealth adds the health endpoints to mux,
+// without authentication or any of the other protections of server.route,
+// so that load balancers and orchestrators can always reach them.
+func (s *server) routeHealth(mux *http.ServeMux) {
+	mux.Handle("GET /healthz", mid.Err(s.handleHealthz))
+	mux.Handle("GET /readyz", mid.Err(s.handleReadyz))
+}
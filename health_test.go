@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+func TestReadyz(t *testing.T) {
+	var (
+		calls int
+		up    = true
+	)
+	gcs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		if !strings.HasSuffix(req.URL.Path, "/b/media") {
+			http.NotFound(w, req)
+			return
+		}
+		if !up {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name": "media"}`))
+	}))
+	defer gcs.Close()
+
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(gcs.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	s := &server{
+		bucket:       client.Bucket("media"),
+		health:       newHealthCounters(),
+		objNamesTime: time.Now().Add(-time.Minute),
+	}
+	mux := http.NewServeMux()
+	s.routeHealth(mux)
+
+	get := func(path string) (*httptest.ResponseRecorder, readyReport) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var report readyReport
+		if path == "/readyz" {
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, report
+	}
+
+	if rec, _ := get("/healthz"); rec.Code != http.StatusOK {
+		t.Errorf("got /healthz status %d", rec.Code)
+	}
+
+	rec, report := get("/readyz")
+	if rec.Code != http.StatusOK || !report.Ready || report.Checks["bucket"] != "ok" {
+		t.Errorf("got status %d, report %+v", rec.Code, report)
+	}
+	if report.ObjectsAge == nil || *report.ObjectsAge != 60 {
+		t.Errorf("got objects age %v, want 60", report.ObjectsAge)
+	}
+	if report.MetadataAge != nil {
+		t.Errorf("got metadata age %d for unloaded metadata", *report.MetadataAge)
+	}
+
+	// The result is cached.
+	up = false
+	if rec, _ := get("/readyz"); rec.Code != http.StatusOK || calls != 1 {
+		t.Errorf("got status %d after %d calls, want cached result", rec.Code, calls)
+	}
+
+	s.readiness.checked = s.readiness.checked.Add(-readyCheckInterval)
+	rec, report = get("/readyz")
+	if rec.Code != http.StatusServiceUnavailable || report.Ready || report.Checks["bucket"] != "failing" {
+		t.Errorf("got status %d, report %+v with the bucket unreachable", rec.Code, report)
+	}
+}
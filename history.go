@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"google.golang.org/api/googleapi"
+)
+
+// The bucket object holding summaries of past server sessions,
+// one JSON object per line, oldest first.
+const historyObjName = "kodigcs/history.jsonl"
+
+// Number of session summaries to keep in the history object.
+const maxHistory = 1000
+
+// Number of the oldest session summaries to discard
+// when the history object is full.
+// Discarding them rewrites the object,
+// so it is done in batches rather than once per session.
+const historyTrim = 100
+
+// The metadata key on the history object recording the number of summaries it holds,
+// so that appending need not read it.
+const historyLinesKey = "lines"
+
+const historyContentType = "application/jsonl"
+
+// sessionSummary summarizes one run of the server.
+type sessionSummary struct {
+	Start          time.Time        `json:"start"`
+	End            time.Time        `json:"end"`
+	Uptime         string           `json:"uptime"`
+	Requests       int64            `json:"requests"`
+	BytesServed    int64            `json:"bytes_served"`
+	TitlesStreamed int              `json:"titles_streamed"` // distinct media objects read
+	Errors         map[string]int64 `json:"errors"`
+	ObjectBytes    map[string]int64 `json:"object_bytes,omitempty"` // bytes read from each object
+}
+
+func (s *server) sessionSummary() sessionSummary {
+	end := time.Now()
+	return sessionSummary{
+		Start:          s.health.start,
+		End:            end,
+		Uptime:         end.Sub(s.health.start).Round(time.Second).String(),
+		Requests:       s.health.requests.Load(),
+		BytesServed:    s.stats.bytesServed.Load(),
+		TitlesStreamed: s.stats.streamedCount(),
+		Errors: map[string]int64{
+			"client": s.health.clientErrors.Load(),
+			"server": s.health.errors.Load(),
+			"stream": s.health.streamFailures.Load(),
+			"quota":  s.health.quotaErrors.Load(),
+		},
+		ObjectBytes: s.stats.objectBytes(),
+	}
+}
+
+// readHistory reads the session summaries in the history object in the bucket,
+// oldest first.
+func readHistory(ctx context.Context, bucket *storage.BucketHandle) ([]sessionSummary, error) {
+	r, err := bucket.Object(historyObjName).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", historyObjName)
+	}
+	defer r.Close()
+
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", historyObjName)
+	}
+	result := make([]sessionSummary, 0, len(lines))
+	for i, line := range lines {
+		var summary sessionSummary
+		if err := json.Unmarshal(line, &summary); err != nil {
+			return nil, errors.Wrapf(err, "parsing line %d of %s", i+1, historyObjName)
+		}
+		result = append(result, summary)
+	}
+	return result, nil
+}
+
+// appendHistory appends a session summary to the history object in the bucket.
+// Usually it uploads the summary as a separate object
+// and composes it onto the end of the history object,
+// so the history is not rewritten.
+// Once the history object holds maxHistory summaries,
+// it is rewritten without the oldest historyTrim of them.
+// Either way, the history object is updated with a generation precondition,
+// and appendHistory retries if another server updates it concurrently.
+func appendHistory(ctx context.Context, bucket *storage.BucketHandle, summary sessionSummary) error {
+	line, err := json.Marshal(summary)
+	if err != nil {
+		return errors.Wrap(err, "encoding session summary")
+	}
+
+	for tries := 0; ; tries++ {
+		err := appendHistoryLine(ctx, bucket, line)
+		if err == nil {
+			return nil
+		}
+		if tries < 3 && isPreconditionFailed(err) {
+			continue
+		}
+		return err
+	}
+}
+
+// appendHistoryLine makes one attempt at appending a line to the history object.
+func appendHistoryLine(ctx context.Context, bucket *storage.BucketHandle, line []byte) error {
+	obj := bucket.Object(historyObjName)
+
+	attrs, err := obj.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return writeHistory(ctx, obj.If(storage.Conditions{DoesNotExist: true}), [][]byte{line})
+	}
+	if err != nil {
+		return errors.Wrapf(err, "getting attributes of %s", historyObjName)
+	}
+
+	// The count is missing from history objects written before it was recorded.
+	n, err := strconv.Atoi(attrs.Metadata[historyLinesKey])
+	if err != nil || n >= maxHistory {
+		return rewriteHistory(ctx, obj, line)
+	}
+
+	tmp := bucket.Object(fmt.Sprintf("%s.%d", historyObjName, time.Now().UnixNano()))
+	if err := writeHistory(ctx, tmp.If(storage.Conditions{DoesNotExist: true}), [][]byte{line}); err != nil {
+		return err
+	}
+	defer tmp.Delete(ctx)
+
+	c := obj.If(storage.Conditions{GenerationMatch: attrs.Generation}).ComposerFrom(obj.Generation(attrs.Generation), tmp)
+	c.ContentType = historyContentType
+	c.Metadata = map[string]string{historyLinesKey: strconv.Itoa(n + 1)}
+	if _, err := c.Run(ctx); err != nil {
+		return errors.Wrapf(err, "appending to %s", historyObjName)
+	}
+	return nil
+}
+
+// rewriteHistory rewrites the history object with line appended,
+// discarding the oldest summaries if it is full.
+func rewriteHistory(ctx context.Context, obj *storage.ObjectHandle, line []byte) error {
+	var (
+		lines [][]byte
+		cond  storage.Conditions
+	)
+
+	r, err := obj.NewReader(ctx)
+	switch {
+	case errors.Is(err, storage.ErrObjectNotExist):
+		cond.DoesNotExist = true
+
+	case err != nil:
+		return errors.Wrapf(err, "reading %s", historyObjName)
+
+	default:
+		cond.GenerationMatch = r.Attrs.Generation
+		lines, err = readLines(r)
+		r.Close()
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", historyObjName)
+		}
+	}
+
+	if len(lines) >= maxHistory {
+		lines = lines[len(lines)-maxHistory+historyTrim:]
+	}
+	lines = append(lines, line)
+
+	return writeHistory(ctx, obj.If(cond), lines)
+}
+
+// writeHistory writes lines of history to obj,
+// recording their number in its metadata.
+func writeHistory(ctx context.Context, obj *storage.ObjectHandle, lines [][]byte) error {
+	w := obj.NewWriter(ctx)
+	w.ContentType = historyContentType
+	w.Metadata = map[string]string{historyLinesKey: strconv.Itoa(len(lines))}
+	for _, l := range lines {
+		w.Write(l)
+		w.Write([]byte{'\n'})
+	}
+	return errors.Wrapf(w.Close(), "writing %s", obj.ObjectName())
+}
+
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
+
+func readLines(r io.Reader) ([][]byte, error) {
+	var lines [][]byte
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+			lines = append(lines, bytes.Clone(line))
+		}
+	}
+	return lines, sc.Err()
+}
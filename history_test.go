@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestReadLines(t *testing.T) {
+	cases := []struct {
+		name, inp string
+		want      []string
+	}{
+		{"empty", "", nil},
+		{"one line, no newline", `{"requests":1}`, []string{`{"requests":1}`}},
+		{"trimmed", "  {\"requests\":1}\t\r\n{\"requests\":2}  \n", []string{`{"requests":1}`, `{"requests":2}`}},
+		{"blank lines skipped", "\n{\"requests\":1}\n \n\n{\"requests\":2}\n\n", []string{`{"requests":1}`, `{"requests":2}`}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			lines, err := readLines(strings.NewReader(c.inp))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, l := range lines {
+				got = append(got, string(l))
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestAppendHistory(t *testing.T) {
+	ctx := context.Background()
+
+	// summaries returns the history lines "{"requests":from}" through "{"requests":to-1}".
+	summaries := func(from, to int) []string {
+		var result []string
+		for i := from; i < to; i++ {
+			result = append(result, fmt.Sprintf(`{"requests":%d}`, i))
+		}
+		return result
+	}
+	toBytes := func(lines []string) [][]byte {
+		var result [][]byte
+		for _, l := range lines {
+			result = append(result, []byte(l))
+		}
+		return result
+	}
+
+	cases := []struct {
+		name         string
+		setup        func(*testing.T, *fakeGCS, *storage.BucketHandle)
+		wantFirst    int64 // requests in the oldest summary after appending
+		wantLen      int
+		wantComposes int
+	}{{
+		name:    "new",
+		wantLen: 1,
+	}, {
+		name: "append",
+		setup: func(t *testing.T, f *fakeGCS, bucket *storage.BucketHandle) {
+			if err := writeHistory(ctx, bucket.Object(historyObjName), toBytes(summaries(0, 5))); err != nil {
+				t.Fatal(err)
+			}
+		},
+		wantLen:      6,
+		wantComposes: 1,
+	}, {
+		// Written before the count was recorded in the object's metadata.
+		name: "no count",
+		setup: func(t *testing.T, f *fakeGCS, bucket *storage.BucketHandle) {
+			f.put(historyObjName, []byte(strings.Join(summaries(0, 5), "\n")+"\n"))
+		},
+		wantLen: 6,
+	}, {
+		name: "full",
+		setup: func(t *testing.T, f *fakeGCS, bucket *storage.BucketHandle) {
+			if err := writeHistory(ctx, bucket.Object(historyObjName), toBytes(summaries(0, maxHistory))); err != nil {
+				t.Fatal(err)
+			}
+		},
+		wantFirst: historyTrim,
+		wantLen:   maxHistory - historyTrim + 1,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, bucket := newFakeGCS(t)
+			if c.setup != nil {
+				c.setup(t, f, bucket)
+			}
+
+			if err := appendHistory(ctx, bucket, sessionSummary{Requests: -1}); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readHistory(ctx, bucket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != c.wantLen {
+				t.Fatalf("got %d summaries, want %d", len(got), c.wantLen)
+			}
+			if c.wantLen > 1 && got[0].Requests != c.wantFirst {
+				t.Errorf("got oldest summary %d, want %d", got[0].Requests, c.wantFirst)
+			}
+			for i := 1; i < len(got)-1; i++ {
+				if got[i].Requests != got[i-1].Requests+1 {
+					t.Fatalf("summary %d is %d, following %d", i, got[i].Requests, got[i-1].Requests)
+				}
+			}
+			if last := got[len(got)-1]; last.Requests != -1 {
+				t.Errorf("got newest summary %d, want -1", last.Requests)
+			}
+
+			attrs, err := bucket.Object(historyObjName).Attrs(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprint(c.wantLen); attrs.Metadata[historyLinesKey] != want {
+				t.Errorf("got count %q in metadata, want %s", attrs.Metadata[historyLinesKey], want)
+			}
+
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if f.composes != c.wantComposes {
+				t.Errorf("got %d composes, want %d", f.composes, c.wantComposes)
+			}
+			if len(f.objs) != 1 {
+				t.Errorf("got %d objects in the bucket, want only the history", len(f.objs))
+			}
+		})
+	}
+}
+
+func TestAppendHistoryConcurrent(t *testing.T) {
+	ctx := context.Background()
+	f, bucket := newFakeGCS(t)
+
+	if err := appendHistory(ctx, bucket, sessionSummary{Requests: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another server appends its summary between this one's reading the history and composing onto it.
+	var once sync.Once
+	f.onWrite = func() {
+		once.Do(func() {
+			content, _ := f.get(historyObjName)
+			f.put(historyObjName, append(content, []byte(`{"requests":2}`+"\n")...))
+		})
+	}
+	if err := appendHistory(ctx, bucket, sessionSummary{Requests: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readHistory(ctx, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests []int64
+	for _, s := range got {
+		requests = append(requests, s.Requests)
+	}
+	if want := []int64{1, 2, 3}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("got summaries %v, want %v", requests, want)
+	}
+}
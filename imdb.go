@@ -2,15 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"cloud.google.com/go/storage"
 	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
 	"github.com/bobg/htree/v2"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
@@ -38,8 +43,10 @@ func parseIMDbID(inp string) string {
 	return inp
 }
 
-type imdbInfo struct {
+// imdbLD is the JSON-LD data embedded in an IMDb title page.
+type imdbLD struct {
 	Name          string          `json:"name"`
+	AlternateName string          `json:"alternateName"` // the original title, for titles IMDb localizes
 	Image         string          `json:"image"`
 	RawGenre      json.RawMessage `json:"genre"`    // string or []string
 	RawActor      json.RawMessage `json:"actor"`    // person or []person
@@ -47,37 +54,190 @@ type imdbInfo struct {
 	Description   string          `json:"description"`
 	DatePublished string          `json:"datePublished"`
 	Duration      string          `json:"duration"`
+	ContentRating string          `json:"contentRating"`
 
-	Genres    []string `json:"-"`
-	Actors    []string `json:"-"`
-	Directors []string `json:"-"`
+	AggregateRating *struct {
+		RatingValue float64 `json:"ratingValue"`
+	} `json:"aggregateRating"`
+}
 
-	RuntimeMins int    `json:"-"`
-	Summary     string `json:"-"`
+// imdbScraper is the Scraper for the Internet Movie Database.
+// Its IDs are IMDb's, such as tt0133093.
+type imdbScraper struct {
+	fetcher *imdbFetcher
 }
 
-func parseIMDbPage(cl *http.Client, id string) (*imdbInfo, error) {
-	titleURL := fmt.Sprintf("https://www.imdb.com/title/%s/", id)
+func init() {
+	registerScraper("imdb", func(conf scraperConfig) (Scraper, error) {
+		return &imdbScraper{fetcher: &imdbFetcher{cl: conf.cl, bucket: conf.bucket, refetch: conf.refetch}}, nil
+	})
+}
+
+func (*imdbScraper) Name() string { return "imdb" }
 
-	req, err := http.NewRequest("GET", titleURL, nil)
+var imdbIDRE = regexp.MustCompile(`^tt\d+$`)
+
+func (s *imdbScraper) LookupByID(ctx context.Context, id string) (*titleInfo, error) {
+	id = parseIMDbID(id)
+	if !imdbIDRE.MatchString(id) {
+		return nil, errNotFound
+	}
+	info, err := parseIMDbPage(ctx, s.fetcher, id)
 	if err != nil {
-		return nil, errors.Wrapf(err, "building request to GET %s", titleURL)
+		return nil, err
 	}
+	info.ID = id
+	return info, nil
+}
+
+var (
+	imdbTitleHrefRE = regexp.MustCompile(`^/title/(tt\d+)/`)
+	yearRE          = regexp.MustCompile(`(?:^|\D)(1[89]\d\d|2\d\d\d)(?:\D|$)`)
+)
+
+func (s *imdbScraper) SearchByTitle(ctx context.Context, title string, year int) ([]searchResult, error) {
+	q := url.Values{"q": {title}, "s": {"tt"}}
+	findURL := "https://www.imdb.com/find/?" + q.Encode()
 
-	resp, err := cl.Do(req)
+	page, err := s.fetcher.get(ctx, findURL, "")
+	if err != nil {
+		return nil, err
+	}
+	results, err := parseIMDbFindHTML(bytes.NewReader(page))
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting %s", titleURL)
+		return nil, errors.Wrapf(err, "parsing %s", findURL)
+	}
+	return filterByYear(results, year), nil
+}
+
+// parseIMDbFindHTML parses the title results of an IMDb search page.
+func parseIMDbFindHTML(r io.Reader) ([]searchResult, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing HTML")
+	}
+
+	var (
+		results []searchResult
+		seen    = set.New[string]()
+	)
+	for itemEl := range htree.FindAllEls(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Li && htree.ElClassContains(n, "find-title-result")
+	}) {
+		linkEl := htree.FindEl(itemEl, func(n *html.Node) bool {
+			return n.DataAtom == atom.A && imdbTitleHrefRE.MatchString(htree.ElAttr(n, "href"))
+		})
+		if linkEl == nil {
+			continue
+		}
+		id := imdbTitleHrefRE.FindStringSubmatch(htree.ElAttr(linkEl, "href"))[1]
+		if seen.Has(id) {
+			continue
+		}
+		seen.Add(id)
+
+		title, err := htree.Text(linkEl)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting result title")
+		}
+		title = strings.TrimSpace(title)
+		result := searchResult{ID: id, Title: title}
+
+		text, err := htree.Text(itemEl)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting result text")
+		}
+		if m := yearRE.FindStringSubmatch(strings.TrimPrefix(strings.TrimSpace(text), title)); m != nil {
+			result.Year, _ = strconv.Atoi(m[1])
+		}
+
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// The bucket "directory" in which ssupdate caches IMDb pages.
+const imdbCachePrefix = "imdb-cache/"
+
+// imdbFetcher gets IMDb pages,
+// caching them in the bucket
+// so that later runs of ssupdate need not fetch them again.
+type imdbFetcher struct {
+	cl      *http.Client
+	bucket  *storage.BucketHandle
+	refetch bool // ignore cached copies
+}
+
+// get returns the page at the given URL,
+// cached in the bucket as imdb-cache/NAME.html.
+// If name is "" or there is no bucket, the page is not cached.
+func (f *imdbFetcher) get(ctx context.Context, pageURL, name string) ([]byte, error) {
+	var obj *storage.ObjectHandle
+	if name != "" && f.bucket != nil {
+		obj = f.bucket.Object(imdbCachePrefix + name + ".html")
+	}
+
+	if obj != nil && !f.refetch {
+		r, err := obj.NewReader(ctx)
+		if err == nil {
+			defer r.Close()
+			log.Printf("Using cached copy of %s", pageURL)
+			return io.ReadAll(r)
+		}
+		if !errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, errors.Wrapf(err, "reading cached copy of %s", pageURL)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "building request to GET %s", pageURL)
+	}
+
+	resp, err := f.cl.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting %s", pageURL)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("status %d (%s) getting %s", resp.StatusCode, http.StatusText(resp.StatusCode), titleURL)
+		return nil, fmt.Errorf("status %d (%s) getting %s", resp.StatusCode, http.StatusText(resp.StatusCode), pageURL)
+	}
+
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", pageURL)
 	}
 
-	return parseIMDbHTML(resp.Body)
+	if obj == nil {
+		return page, nil
+	}
+
+	w := obj.NewWriter(ctx)
+	w.ContentType = "text/html"
+	if _, err := w.Write(page); err != nil {
+		w.Close()
+		return nil, errors.Wrapf(err, "caching %s", pageURL)
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrapf(err, "caching %s", pageURL)
+	}
+
+	return page, nil
 }
 
-func parseIMDbHTML(r io.Reader) (*imdbInfo, error) {
+func parseIMDbPage(ctx context.Context, f *imdbFetcher, id string) (*titleInfo, error) {
+	titleURL := fmt.Sprintf("https://www.imdb.com/title/%s/", id)
+
+	page, err := f.get(ctx, titleURL, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseIMDbHTML(bytes.NewReader(page))
+}
+
+func parseIMDbHTML(r io.Reader) (*titleInfo, error) {
 	doc, err := html.Parse(r)
 	if err != nil {
 		return nil, errors.Wrap(err, "parsing HTML")
@@ -101,27 +261,39 @@ func parseIMDbHTML(r io.Reader) (*imdbInfo, error) {
 		jsonBuf.WriteString(child.Data)
 	}
 
-	var result imdbInfo
-	err = json.Unmarshal(jsonBuf.Bytes(), &result)
+	var ld imdbLD
+	err = json.Unmarshal(jsonBuf.Bytes(), &ld)
 	if err != nil {
 		return nil, errors.Wrapf(err, "unmarshaling JSON in HTML")
 	}
 
-	result.Actors, err = parsePersons(result.RawActor)
+	result := titleInfo{
+		Name:          ld.Name,
+		AlternateName: ld.AlternateName,
+		Image:         ld.Image,
+		Description:   ld.Description,
+		DatePublished: ld.DatePublished,
+		ContentRating: ld.ContentRating,
+	}
+	if ld.AggregateRating != nil && ld.AggregateRating.RatingValue > 0 {
+		result.Ratings = map[string]float64{ratingIMDb: ld.AggregateRating.RatingValue}
+	}
+
+	result.Actors, err = parsePersons(ld.RawActor)
 	if err != nil {
 		return nil, errors.Wrap(err, "parsing actors")
 	}
-	result.Directors, err = parsePersons(result.RawDirector)
+	result.Directors, err = parsePersons(ld.RawDirector)
 	if err != nil {
 		return nil, errors.Wrap(err, "parsing directors")
 	}
 
 	var genre string
-	err = json.Unmarshal(result.RawGenre, &genre)
+	err = json.Unmarshal(ld.RawGenre, &genre)
 	if err != nil {
-		err = json.Unmarshal(result.RawGenre, &result.Genres)
+		err = json.Unmarshal(ld.RawGenre, &result.Genres)
 		if err != nil {
-			return nil, fmt.Errorf("could not parse genre %s", string(result.RawGenre))
+			return nil, fmt.Errorf("could not parse genre %s", string(ld.RawGenre))
 		}
 	} else {
 		result.Genres = []string{genre}
@@ -136,6 +308,35 @@ func parseIMDbHTML(r io.Reader) (*imdbInfo, error) {
 		result.Summary = result.Description
 	}
 
+	tagline, err := getTagline(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting tagline")
+	}
+	result.Tagline = strings.TrimSpace(tagline)
+
+	result.Top250, result.Awards, err = getAwards(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting awards")
+	}
+
+	result.Roles, result.Headshots, err = getCast(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cast")
+	}
+
+	result.Countries, err = getDetailsList(doc, "title-details-origin")
+	if err != nil {
+		return nil, errors.Wrap(err, "getting countries of origin")
+	}
+	result.Languages, err = getDetailsList(doc, "title-details-languages")
+	if err != nil {
+		return nil, errors.Wrap(err, "getting languages")
+	}
+	result.Studios, err = getDetailsList(doc, "title-details-companies")
+	if err != nil {
+		return nil, errors.Wrap(err, "getting production companies")
+	}
+
 	runtimeMins, err := getRuntimeMins(doc)
 	if err != nil {
 		return nil, errors.Wrap(err, "getting runtime")
@@ -165,6 +366,148 @@ func getSummary(doc *html.Node) (string, error) {
 	return "", nil
 }
 
+// getTagline gets the tagline in the "Storyline" section of an IMDb title page.
+// IMDb may have several taglines for a title
+// (see the title's taglines page),
+// but the title page shows just one,
+// which suits Kodi, which wants just one.
+func getTagline(doc *html.Node) (string, error) {
+	taglineEl := htree.FindEl(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Li && htree.ElAttr(n, "data-testid") == "storyline-taglines"
+	})
+	if taglineEl == nil {
+		return "", nil
+	}
+	itemEl := htree.FindEl(taglineEl, func(n *html.Node) bool {
+		return htree.ElClassContains(n, "ipc-metadata-list-item__list-content-item")
+	})
+	if itemEl == nil {
+		return "", nil
+	}
+	return htree.Text(itemEl)
+}
+
+var top250RE = regexp.MustCompile(`Top rated movie #(\d+)`)
+
+// getAwards parses an IMDb title page
+// for the title's position in the Top 250 chart (a link like "Top rated movie #61")
+// and its awards summary.
+func getAwards(doc *html.Node) (int, string, error) {
+	var top250 int
+	for el := range htree.FindAllEls(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.A && strings.HasPrefix(htree.ElAttr(n, "href"), "/chart/top")
+	}) {
+		text, err := htree.Text(el)
+		if err != nil {
+			return 0, "", err
+		}
+		if m := top250RE.FindStringSubmatch(text); m != nil {
+			top250, _ = strconv.Atoi(m[1])
+			break
+		}
+	}
+
+	awardsEl := htree.FindEl(doc, func(n *html.Node) bool {
+		return htree.ElAttr(n, "data-testid") == "award_information"
+	})
+	if awardsEl == nil {
+		return top250, "", nil
+	}
+
+	var parts []string
+	for el := range htree.FindAllEls(awardsEl, func(n *html.Node) bool {
+		return n.DataAtom == atom.A || htree.ElClassContains(n, "ipc-metadata-list-item__list-content-item")
+	}) {
+		text, err := htree.Text(el)
+		if err != nil {
+			return 0, "", err
+		}
+		text = strings.Join(strings.Fields(text), " ")
+		if text != "" && !top250RE.MatchString(text) {
+			parts = append(parts, text)
+		}
+	}
+
+	return top250, strings.Join(parts, ". "), nil
+}
+
+// getCast parses the cast list in an IMDb title page,
+// returning maps from actor name to character name
+// and from actor name to headshot image URL.
+func getCast(doc *html.Node) (roles, headshots map[string]string, err error) {
+	roles, headshots = make(map[string]string), make(map[string]string)
+
+	castItems := htree.FindAllEls(doc, func(n *html.Node) bool {
+		return htree.ElAttr(n, "data-testid") == "title-cast-item"
+	})
+	for castItem := range castItems {
+		actorEl := htree.FindEl(castItem, func(n *html.Node) bool {
+			return n.DataAtom == atom.A && htree.ElAttr(n, "data-testid") == "title-cast-item__actor"
+		})
+		if actorEl == nil {
+			continue
+		}
+		name, err := htree.Text(actorEl)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "getting actor name")
+		}
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		roleEl := htree.FindEl(castItem, func(n *html.Node) bool {
+			return n.DataAtom == atom.A && htree.ElAttr(n, "data-testid") == "cast-item-characters-link"
+		})
+		if roleEl != nil {
+			role, err := htree.Text(roleEl)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "getting role name")
+			}
+			if role = strings.TrimSpace(role); role != "" {
+				roles[name] = role
+			}
+		}
+
+		imgEl := htree.FindEl(castItem, func(n *html.Node) bool {
+			return n.DataAtom == atom.Img
+		})
+		if imgEl != nil {
+			if src := htree.ElAttr(imgEl, "src"); src != "" {
+				headshots[name] = src
+			}
+		}
+	}
+
+	return roles, headshots, nil
+}
+
+// getDetailsList gets the items in one of the lists in the "Details" section of an IMDb title page,
+// e.g. the countries of origin.
+// The list is identified by its data-testid attribute.
+func getDetailsList(doc *html.Node, testid string) ([]string, error) {
+	listEl := htree.FindEl(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Li && htree.ElAttr(n, "data-testid") == testid
+	})
+	if listEl == nil {
+		return nil, nil
+	}
+
+	var result []string
+	for itemEl := range htree.FindAllEls(listEl, func(n *html.Node) bool {
+		return n.DataAtom == atom.A && htree.ElClassContains(n, "ipc-metadata-list-item__list-content-item")
+	}) {
+		text, err := htree.Text(itemEl)
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting text of %s item", testid)
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			result = append(result, text)
+		}
+	}
+	return result, nil
+}
+
 func getRuntimeMins(doc *html.Node) (int, error) {
 	runtimeEl := htree.FindEl(doc, func(n *html.Node) bool {
 		return n.DataAtom == atom.Time
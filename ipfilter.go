@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// ipFilter restricts the addresses from which the server accepts requests,
+// as given by serve -allow-cidr and -deny-cidr.
+// A request is refused if its address is in a denied range,
+// or if there are allowed ranges and its address is in none of them.
+//
+// The address is that of the connection,
+// so behind a reverse proxy it is the proxy's,
+// unless the proxy is one of the -trusted-proxies,
+// in which case it is the client's (see proxy.go).
+type ipFilter struct {
+	allow, deny []netip.Prefix
+}
+
+// newIPFilter parses comma-separated lists of allowed and denied ranges,
+// each a CIDR prefix (as in 192.168.1.0/24 or fd00::/8) or a single address.
+// It returns nil if both lists are empty.
+func newIPFilter(allow, deny string) (*ipFilter, error) {
+	var (
+		f   ipFilter
+		err error
+	)
+	if f.allow, err = parsePrefixes(allow); err != nil {
+		return nil, errors.Wrap(err, "in -allow-cidr")
+	}
+	if f.deny, err = parsePrefixes(deny); err != nil {
+		return nil, errors.Wrap(err, "in -deny-cidr")
+	}
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var result []netip.Prefix
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing %s", item)
+			}
+			result = append(result, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", item)
+		}
+		result = append(result, prefix.Masked())
+	}
+	return result, nil
+}
+
+// permits tells whether the filter accepts requests from addr.
+func (f *ipFilter) permits(addr netip.Addr) bool {
+	contains := func(p netip.Prefix) bool { return p.Contains(addr) }
+	if slices.ContainsFunc(f.deny, contains) {
+		return false
+	}
+	return len(f.allow) == 0 || slices.ContainsFunc(f.allow, contains)
+}
+
+// remoteAddr is the address of the client that sent a request.
+func remoteAddr(req *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// filtered wraps a handler so that it refuses requests from addresses that s.ipFilter does not permit.
+// It runs before authentication,
+// so refused clients cannot even try credentials.
+func (s *server) filtered(f func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	if s.ipFilter == nil {
+		return f
+	}
+	return func(w http.ResponseWriter, req *http.Request) error {
+		addr, ok := remoteAddr(req)
+		if !ok || !s.ipFilter.permits(addr) {
+			log.Printf("Refused request from %s", req.RemoteAddr)
+			return mid.CodeErr{
+				C:   http.StatusForbidden,
+				Err: fmt.Errorf("address %s not permitted", req.RemoteAddr),
+			}
+		}
+		return f(w, req)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bobg/mid"
+)
+
+func TestIPFilter(t *testing.T) {
+	if f, err := newIPFilter("", ""); err != nil || f != nil {
+		t.Fatalf("got %v, %v with no ranges", f, err)
+	}
+	if _, err := newIPFilter("192.168.1.0/33", ""); err == nil {
+		t.Error("parsed a bad range")
+	}
+
+	f, err := newIPFilter("192.168.1.0/24, 10.8.0.0/16, fd00::/8", "192.168.1.13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{ipFilter: f}
+	h := mid.Err(s.filtered(func(http.ResponseWriter, *http.Request) error { return nil }))
+
+	cases := []struct {
+		remote string
+		want   int
+	}{
+		{remote: "192.168.1.20:5000", want: http.StatusNoContent},
+		{remote: "10.8.3.4:5000", want: http.StatusNoContent},
+		{remote: "[fd12::1]:5000", want: http.StatusNoContent},
+		{remote: "[::ffff:192.168.1.20]:5000", want: http.StatusNoContent},
+		{remote: "192.168.1.13:5000", want: http.StatusForbidden},
+		{remote: "203.0.113.9:5000", want: http.StatusForbidden},
+		{remote: "bogus", want: http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.remote, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = c.remote
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != c.want {
+				t.Errorf("got status %d, want %d", rec.Code, c.want)
+			}
+		})
+	}
+}
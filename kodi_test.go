@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestNotifyKodi(t *testing.T) {
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/jsonrpc" {
+			t.Errorf("got path %s", req.URL.Path)
+		}
+		if user, pw, ok := req.BasicAuth(); !ok || user != "kodi" || pw != "secret" {
+			t.Errorf("got credentials %q, %q, %v", user, pw, ok)
+		}
+		var call struct {
+			Method string `json:"method"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&call); err != nil {
+			t.Error(err)
+		}
+		methods = append(methods, call.Method)
+		w.Write([]byte(`{"id": 1, "jsonrpc": "2.0", "result": "OK"}`))
+	}))
+	defer srv.Close()
+
+	hosts, err := parseKodiHosts("http://kodi:secret@" + strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{kodiHosts: hosts}
+
+	before := set.New("The Thin Man.iso", "Top Hat.mp4", "Top Hat.jpg")
+	after := set.New("The Thin Man.iso", "Swing Time.mp4")
+	added, removed := titleChanges(before, after)
+	if !added || !removed {
+		t.Fatalf("got added %v, removed %v", added, removed)
+	}
+	if added, removed := titleChanges(before, set.New("The Thin Man.iso", "Top Hat.mp4")); added || removed {
+		t.Errorf("removing artwork counts as a change to the titles")
+	}
+
+	s.notifyKodi(context.Background(), added, removed)
+	if len(methods) != 2 || methods[0] != "VideoLibrary.Scan" || methods[1] != "VideoLibrary.Clean" {
+		t.Errorf("got calls %v", methods)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"html/template"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bobg/go-generics/v4/set"
+	"golang.org/x/text/unicode/norm"
+)
+
+// In -letters mode,
+// each folder lists its titles not all at once
+// but in virtual folders named for the first letters of their sort titles,
+// A/ through Z/,
+// plus 0-9/ for titles beginning with digits
+// and #/ for all others.
+
+const (
+	digitsLetterDir = "0-9"
+	otherLetterDir  = "#"
+)
+
+// letterOf is the name of the letter folder for a title with the given sort key.
+// Punctuation (as in 'Round Midnight) is skipped,
+// and accents are ignored.
+func letterOf(sortKey string) string {
+	i := strings.IndexFunc(sortKey, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) })
+	if i < 0 {
+		return otherLetterDir
+	}
+	r, _ := utf8.DecodeRuneInString(sortKey[i:])
+	first := []rune(norm.NFD.String(string(r)))[0] // É -> E
+	switch first = unicode.ToUpper(first); {
+	case first >= 'A' && first <= 'Z':
+		return string(first)
+	case first >= '0' && first <= '9':
+		return digitsLetterDir
+	default:
+		return otherLetterDir
+	}
+}
+
+// cutLetterDir splits off the letter folder at the end of subdir, if there is one.
+func cutLetterDir(subdir string) (parent, letter string, ok bool) {
+	parent, letter = "", subdir
+	if i := strings.LastIndex(subdir, "/"); i >= 0 {
+		parent, letter = subdir[:i], subdir[i+1:]
+	}
+	switch {
+	case letter == digitsLetterDir, letter == otherLetterDir:
+		return parent, letter, true
+	case len(letter) == 1 && letter[0] >= 'A' && letter[0] <= 'Z':
+		return parent, letter, true
+	}
+	return "", "", false
+}
+
+// groupsWithLetter returns the titles in the given letter folder.
+func groupsWithLetter(groups []titleGroup, letter string) []titleGroup {
+	var result []titleGroup
+	for _, g := range groups {
+		if letterOf(g.sortKey) == letter {
+			result = append(result, g)
+		}
+	}
+	return result
+}
+
+// letterDirItems returns the directory items for the letter folders holding the given titles.
+func letterDirItems(groups []titleGroup) []template.URL {
+	letters := set.New[string]()
+	for _, g := range groups {
+		letters.Add(letterOf(g.sortKey))
+	}
+	sorted := letters.Slice()
+	sort.Strings(sorted) // #, 0-9, A-Z
+	var items []template.URL
+	for _, l := range sorted {
+		items = append(items, template.URL(url.PathEscape(l)+"/"))
+	}
+	return items
+}
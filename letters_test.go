@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestLetterOf(t *testing.T) {
+	cases := map[string]string{
+		"thin man":         "T",
+		"'round midnight":  "R",
+		"élite squad":      "E",
+		"2001: a space...": "0-9",
+		"Ωmega":            "#",
+		"":                 "#",
+	}
+	for sortKey, want := range cases {
+		if got := letterOf(sortKey); got != want {
+			t.Errorf("letterOf(%q) = %s, want %s", sortKey, got, want)
+		}
+	}
+}
+
+func TestLettersAndPages(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		dirTemplate:  template.Must(template.New("").Parse(dirTemplate)),
+		objNames:     set.New("The Thin Man.iso", "Top Hat.mp4", "2001.mp4", "Alien.mp4"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"The Thin Man": {Title: "The Thin Man", SortTitle: "thin man"},
+			"2001":         {Title: "2001: A Space Odyssey"},
+		},
+		infoMapTime: now,
+		letters:     true,
+	}
+
+	get := func(path string) apiDir {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := s.handleAPIDir(rec, httptest.NewRequest("GET", "/api/dir/"+path, nil)); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		var got apiDir
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	names := func(d apiDir) string {
+		var result []string
+		for _, e := range d.Entries {
+			result = append(result, e.Name)
+		}
+		return strings.Join(result, " ")
+	}
+
+	if got := names(get("")); got != "0-9 A T" {
+		t.Errorf("got top-level folder %s", got)
+	}
+	var (
+		thinMan = rootNamePrefix("The Thin Man")
+		topHat  = rootNamePrefix("Top Hat")
+	)
+	if got, want := names(get("T/")), thinMan+"The Thin Man.iso "+thinMan+"The Thin Man.nfo "+topHat+"Top Hat.mp4 "+topHat+"Top Hat.nfo"; got != want {
+		t.Errorf("got T folder %s, want %s", got, want)
+	}
+
+	d := get("T/?page=2&pagesize=3")
+	if got := names(d); d.Page != 2 || d.Pages != 2 || got != topHat+"Top Hat.nfo" {
+		t.Errorf("got page %d of %d: %s", d.Page, d.Pages, got)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := s.handle(rec, httptest.NewRequest("GET", "/T/?page=1&pagesize=3", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `href="?page=2"`) || strings.Contains(body, "Top Hat.nfo") {
+		t.Errorf("got page 1:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := s.handle(rec, httptest.NewRequest("GET", "/T/?page=3&pagesize=3", nil)); err == nil {
+		t.Error("got no error for a page past the end")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// With serve -login (or -oidc-issuer, see oidc.go),
+// a browser can log in with a form at /login
+// instead of answering the browser's own Basic Auth prompt,
+// getting a session cookie that authenticates its requests
+// until it logs out (at the same page) or the session expires.
+// Unauthenticated requests for the server's HTML pages
+// (gallery, search, player, and admin pages)
+// are redirected to the form instead of getting a Basic Auth challenge.
+// Everything else, including Kodi's requests,
+// still uses Basic Auth (or tokens).
+
+const (
+	loginCookie     = "kodigcs_session"
+	loginSessionTTL = 30 * 24 * time.Hour
+
+	// The grant subject of a login session.
+	loginSubject = "login"
+)
+
+// The path prefixes of the HTML pages for which unauthenticated browsers are sent to the login form.
+var loginPagePrefixes = []string{"/gallery/", "/search", "/watch/", "/admin/", "/pin"}
+
+// A passwordChecker is an authenticator with accounts that can check a username and password
+// (for the login form).
+type passwordChecker interface {
+	checkPassword(username, password string) bool
+	hasAccount(username string) bool
+}
+
+// sessionAuth accepts the cookie of a login session.
+type sessionAuth struct {
+	grants *grantStore
+
+	// valid tells whether the principal of a session may still use the server,
+	// so that removing an account ends its sessions.
+	// Nil means any principal may.
+	valid func(name string) bool
+}
+
+func (a sessionAuth) authenticate(w http.ResponseWriter, req *http.Request) (string, error) {
+	cookie, err := req.Cookie(loginCookie)
+	if err != nil {
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	g, ok := a.grants.lookup(grantSession, cookie.Value)
+	if !ok || g.Subject != loginSubject {
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	if a.valid != nil && !a.valid(g.Name) {
+		log.Printf("Ending login session of %s, who may no longer use the server", g.Name)
+		a.grants.revoke(g.ID)
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	return g.Name, nil
+}
+
+// sessionValidator returns a function for sessionAuth.valid
+// telling whether name is still an account in auth
+// or, with single sign-on, still permitted by o.
+func sessionValidator(auth authenticator, o *oidcLogin) func(name string) bool {
+	accounts := accountsOf(auth)
+	return func(name string) bool {
+		for _, acct := range accounts {
+			if acct.hasAccount(name) {
+				return true
+			}
+		}
+		return o != nil && o.allowed(name)
+	}
+}
+
+// wantsLoginForm tells whether an unauthenticated request is a browser's request for an HTML page,
+// which should be redirected to the login form.
+func wantsLoginForm(req *http.Request) bool {
+	if req.Method != http.MethodGet || req.Header.Get("Authorization") != "" {
+		return false
+	}
+	if !strings.Contains(req.Header.Get("Accept"), "text/html") {
+		return false
+	}
+	for _, prefix := range loginPagePrefixes {
+		if strings.HasPrefix(req.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectToLogin sends the browser to the login form,
+// which returns it to the requested page afterward.
+func redirectToLogin(w http.ResponseWriter, req *http.Request) {
+	w.Header().Del("WWW-Authenticate")
+	http.Redirect(w, req, "/login?"+url.Values{"return": {req.URL.RequestURI()}}.Encode(), http.StatusSeeOther)
+}
+
+// accountsOf returns the authenticators in auth that can check passwords.
+func accountsOf(auth authenticator) []passwordChecker {
+	var result []passwordChecker
+	switch a := auth.(type) {
+	case passwordChecker:
+		result = append(result, a)
+	case anyAuth:
+		for _, inner := range a {
+			result = append(result, accountsOf(inner)...)
+		}
+	}
+	return result
+}
+
+// localReturn is the return parameter of a request if it's a path on this server,
+// or else def.
+func localReturn(req *http.Request, def string) string {
+	ret := req.FormValue("return")
+	if !strings.HasPrefix(ret, "/") || strings.HasPrefix(ret, "//") || strings.HasPrefix(ret, "/\\") {
+		return def
+	}
+	return ret
+}
+
+// handleLogin serves /login,
+// the login form (or, for a browser that's logged in, a logout button),
+// and handles its submission.
+// It is not behind authentication.
+func (s *server) handleLogin(w http.ResponseWriter, req *http.Request) error {
+	ret := localReturn(req, "/gallery/")
+
+	if req.Method != http.MethodPost {
+		page := loginPage{
+			Return:    ret,
+			Passwords: len(accountsOf(s.auth)) > 0,
+			SSO:       s.oidc != nil,
+		}
+		if name, err := (sessionAuth{grants: s.grants}).authenticate(w, req); err == nil {
+			page.LoggedIn, page.Username = true, name
+		} else if page.SSO && !page.Passwords {
+			http.Redirect(w, req, "/login/oidc?"+url.Values{"return": {ret}}.Encode(), http.StatusSeeOther)
+			return nil
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		return loginTemplate.Execute(w, page)
+	}
+
+	if err := checkSameOrigin(req); err != nil {
+		return err
+	}
+
+	if req.FormValue("action") == "logout" {
+		if cookie, err := req.Cookie(loginCookie); err == nil {
+			if g, ok := s.grants.lookup(grantSession, cookie.Value); ok && g.Subject == loginSubject {
+				s.grants.revoke(g.ID)
+			}
+		}
+		http.SetCookie(w, &http.Cookie{Name: loginCookie, Path: "/", MaxAge: -1})
+		http.SetCookie(w, &http.Cookie{Name: pinCookie, Path: "/", MaxAge: -1})
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return nil
+	}
+
+	username, password := req.FormValue("username"), req.FormValue("password")
+	var ok bool
+	for _, acct := range accountsOf(s.auth) {
+		if acct.checkPassword(username, password) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		log.Printf("Unauthorized access attempt from %s (login form, username %s)", req.RemoteAddr, username)
+		if addr, ok := remoteAddr(req); ok && s.throttle != nil {
+			if s.throttle.authFailed(addr, time.Now()) {
+				log.Printf("Locking out %s for %s after %d failed logins", addr, lockoutDuration, s.throttle.maxFailures)
+			}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		return loginTemplate.Execute(w, loginPage{Return: ret, Username: username, Passwords: true, SSO: s.oidc != nil, Failed: true})
+	}
+
+	if addr, ok := remoteAddr(req); ok && s.throttle != nil {
+		s.throttle.authSucceeded(addr)
+	}
+
+	if err := s.startSession(w, req, username); err != nil {
+		return err
+	}
+	http.Redirect(w, req, ret, http.StatusSeeOther)
+	return nil
+}
+
+// startSession issues a login session for the principal name
+// and sets its cookie.
+func (s *server) startSession(w http.ResponseWriter, req *http.Request, name string) error {
+	secret, g, err := s.grants.issue(grantSession, name, loginSubject, loginSessionTTL)
+	if err != nil {
+		return errors.Wrap(err, "issuing login session")
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Value:    secret,
+		Path:     "/",
+		Expires:  g.Expires,
+		Secure:   s.requestScheme(req) == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// routeLogin adds the login handlers to mux,
+// with the same protections as server.route except authentication.
+func (s *server) routeLogin(mux *http.ServeMux) {
+	handlers := map[string]func(http.ResponseWriter, *http.Request) error{
+		"/login": s.handleLogin,
+	}
+	if s.oidc != nil {
+		handlers["GET /login/oidc"] = s.handleOIDCLogin
+		handlers["GET /login/callback"] = s.handleOIDCCallback
+	}
+	for pattern, f := range handlers {
+		h := mid.Err(s.observed(s.filtered(s.throttled(f))))
+		mux.Handle(pattern, s.traced(pattern, s.logged(h)))
+	}
+}
+
+type loginPage struct {
+	Return    string
+	Username  string
+	LoggedIn  bool
+	Passwords bool // whether to offer a password login
+	SSO       bool // whether to offer a single sign-on login
+	Failed    bool
+}
+
+var loginTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+ <head>
+  <title>Log in</title>
+ </head>
+ <body>
+  <form method="POST" action="/login">
+   <input type="hidden" name="return" value="{{ .Return }}">
+   {{ if .LoggedIn }}
+    <p>Logged in as {{ .Username }}.</p>
+    <button type="submit" name="action" value="logout">Log out</button>
+   {{ else if .Passwords }}
+    {{ if .Failed }}<p>Wrong username or password.</p>{{ end }}
+    <p><label>Username <input type="text" name="username" value="{{ .Username }}" autocomplete="username" autofocus></label></p>
+    <p><label>Password <input type="password" name="password" autocomplete="current-password"></label></p>
+    <button type="submit">Log in</button>
+   {{ end }}
+  </form>
+  {{ if and .SSO (not .LoggedIn) }}
+   <p><a href="/login/oidc?return={{ .Return }}">Log in with single sign-on</a></p>
+  {{ end }}
+ </body>
+</html>
+`))
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLogin(t *testing.T) {
+	grants := &grantStore{grants: map[string]*grant{}, known: set.New[string](), deleted: set.New[string]()}
+	s := &server{
+		auth:   anyAuth{basicAuth{username: "alice", password: "secret"}, sessionAuth{grants: grants}},
+		grants: grants,
+		login:  true,
+	}
+	whoami := mid.Err(s.authed(func(w http.ResponseWriter, req *http.Request) error {
+		_, err := w.Write([]byte(principal(req.Context())))
+		return err
+	}))
+
+	get := func(path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		whoami.ServeHTTP(rec, req)
+		return rec
+	}
+	post := func(form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		mid.Err(s.handleLogin).ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/gallery/?page=2", nil)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login?return=%2Fgallery%2F%3Fpage%3D2" {
+		t.Errorf("got status %d and location %q for the gallery", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec.Header().Get("WWW-Authenticate") != "" {
+		t.Error("got a Basic Auth challenge along with the redirect")
+	}
+
+	// Kodi's requests still get a Basic Auth challenge.
+	rec = get("/Movies/", nil)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("got status %d and no challenge for a folder", rec.Code)
+	}
+
+	if rec := post(url.Values{"username": {"alice"}, "password": {"wrong"}}, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d for a wrong password", rec.Code)
+	}
+
+	rec = post(url.Values{"username": {"alice"}, "password": {"secret"}, "return": {"/gallery/"}}, nil)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/gallery/" {
+		t.Fatalf("got status %d and location %q for a login", rec.Code, rec.Header().Get("Location"))
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == loginCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("got session cookie %v", cookie)
+	}
+
+	for _, path := range []string{"/gallery/", "/Movies/x-Top%20Hat.mp4"} {
+		if rec := get(path, cookie); rec.Code != http.StatusOK || rec.Body.String() != "alice" {
+			t.Errorf("with the session cookie, got status %d and principal %q for %s", rec.Code, rec.Body.String(), path)
+		}
+	}
+
+	if rec := post(url.Values{"username": {"alice"}, "password": {"secret"}, "return": {"https://evil.example.com/"}}, nil); rec.Header().Get("Location") != "/gallery/" {
+		t.Errorf("got location %q for a login returning to another site", rec.Header().Get("Location"))
+	}
+
+	if rec := post(url.Values{"action": {"logout"}}, cookie); rec.Code != http.StatusSeeOther {
+		t.Errorf("got status %d for logging out", rec.Code)
+	}
+	if rec := get("/gallery/", cookie); rec.Code != http.StatusSeeOther {
+		t.Errorf("after logging out, got status %d for the gallery", rec.Code)
+	}
+}
+
+func TestSessionRemovedAccount(t *testing.T) {
+	grants := &grantStore{grants: map[string]*grant{}, known: set.New[string](), deleted: set.New[string]()}
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(file, []byte("alice:"+string(hash)+"\nbob:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := newUserStore(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts := anyAuth{usersAuth{users: users}}
+	a := sessionAuth{grants: grants, valid: sessionValidator(accounts, nil)}
+
+	authenticate := func(secret string) (string, error) {
+		req := httptest.NewRequest("GET", "/gallery/", nil)
+		req.AddCookie(&http.Cookie{Name: loginCookie, Value: secret})
+		return a.authenticate(httptest.NewRecorder(), req)
+	}
+
+	alice, _, err := grants.issue(grantSession, "alice", loginSubject, loginSessionTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := authenticate(alice); err != nil || name != "alice" {
+		t.Fatalf("got %q, %v for alice's session", name, err)
+	}
+
+	if err := os.WriteFile(file, []byte("bob:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticate(alice); errorCode(err) != http.StatusUnauthorized {
+		t.Errorf("got %v for the session of a removed account", err)
+	}
+	if len(grants.list()) != 0 {
+		t.Error("session of a removed account not revoked")
+	}
+}
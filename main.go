@@ -4,23 +4,31 @@ import (
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/xml"
+	"expvar"
 	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"syscall"
+	"time"
 
 	"cloud.google.com/go/storage"
-	"github.com/bobg/certs"
 	"github.com/bobg/errors"
-	"github.com/bobg/mid"
 	"github.com/bobg/subcmd/v2"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/time/rate"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/monitoring/v3"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
 )
@@ -29,29 +37,64 @@ func main() {
 	var (
 		credsFile = flag.String("creds", "creds.json", "path to service-account credentials JSON file")
 		bucket    = flag.String("bucket", "", "Google Cloud Storage bucket name")
+		headings  = flag.String("headings", "", "YAML or JSON file (local or gs://) mapping the metadata's column headings to kodigcs's")
+		exts      = flag.String("exts", defaultMediaExts, "comma-separated extensions of the media objects to list as titles")
 	)
 	flag.Parse()
 
+	setMediaExts(parseMediaExts(*exts))
+	if mediaExts().Len() == 0 {
+		log.Fatal("Must specify at least one extension with -exts")
+	}
+
+	log.SetOutput(logRedactor)
+
 	if *bucket == "" {
 		log.Fatal("Must specify -bucket")
 	}
 
 	ctx := context.Background()
 
+	if _, err := checkCredsFile(*credsFile); err != nil {
+		log.Fatal(err)
+	}
+
 	gcs, err := storage.NewClient(ctx, option.WithCredentialsFile(*credsFile))
 	if err != nil {
 		log.Fatalf("Error creating GCS client: %s", err)
 	}
 
+	if *headings != "" {
+		h, err := loadHeadingMap(ctx, *headings, gcs)
+		if err != nil {
+			log.Fatalf("Error loading heading map: %s", err)
+		}
+		currentHeadings.Store(&h)
+	}
+
 	// TODO: For the serve subcommand we only need sheets.SpreadsheetsReadonlyScope.
 	ssvc, err := sheets.NewService(ctx, option.WithCredentialsFile(*credsFile), option.WithScopes(sheets.SpreadsheetsScope))
 	if err != nil {
 		log.Fatalf("Error creating sheets service: %s", err)
 	}
 
+	dsvc, err := drive.NewService(ctx, option.WithCredentialsFile(*credsFile), option.WithScopes(drive.DriveMetadataReadonlyScope))
+	if err != nil {
+		log.Fatalf("Error creating drive service: %s", err)
+	}
+
 	c := maincmd{
-		ssvc:   ssvc.Spreadsheets,
-		bucket: gcs.Bucket(*bucket),
+		ssvc:         ssvc.Spreadsheets,
+		dsvc:         dsvc.Files,
+		gcs:          gcs,
+		bucket:       gcs.Bucket(*bucket),
+		bucketName:   *bucket,
+		credsFile:    *credsFile,
+		headingsFile: *headings,
+		secrets:      newSecretResolver(*credsFile),
+	}
+	if err := c.secrets.resolveEnv(ctx); err != nil {
+		log.Fatalf("Error resolving secret references: %s", err)
 	}
 	if err := subcmd.Run(ctx, c, flag.Args()); err != nil {
 		log.Fatal(err)
@@ -59,181 +102,749 @@ func main() {
 }
 
 type maincmd struct {
-	ssvc   *sheets.SpreadsheetsService
-	bucket *storage.BucketHandle
+	ssvc         *sheets.SpreadsheetsService
+	dsvc         *drive.FilesService
+	gcs          *storage.Client
+	bucket       *storage.BucketHandle
+	bucketName   string
+	credsFile    string
+	headingsFile string // from -headings
+	secrets      *secretResolver
 }
 
 func (c maincmd) Subcmds() map[string]subcmd.Subcmd {
 	return subcmd.Commands(
 		"serve", c.serve, "run the server", subcmd.Params(
-			"-sheet", subcmd.String, "", "ID of Google spreadsheet with title metadata",
+			"-sheet", subcmd.String, "", "title metadata: ID[!TAB[!COLUMNS]] of Google spreadsheet, CSV, TSV, YAML, or JSON file, postgres:// URL, or airtable://BASEID/TABLE",
 			"-listen", subcmd.String, ":1549", "listen address",
 			"-certcmd", subcmd.String, "", "command to produce a sequence of JSON-encoded TLS certificates",
+			"-cert", subcmd.String, "", "file of the PEM-encoded TLS certificate chain, instead of -certcmd (reread when it changes)",
+			"-key", subcmd.String, "", "file of the PEM-encoded private key for -cert",
 			"-username", subcmd.String, "", "HTTP Basic Auth username",
-			"-password", subcmd.String, "", "HTTP Basic Auth password", // TODO: move this to an env var so as not to reveal it via expvar
+			"-password", subcmd.String, "", "HTTP Basic Auth password, or a bcrypt or Argon2id hash of it (default $KODIGCS_PASSWORD)",
+			"-password-file", subcmd.String, "", "file containing the HTTP Basic Auth password or its hash, instead of -password",
+			"-users", subcmd.String, "", "file of HTTP Basic Auth usernames and bcrypt password hashes, in htpasswd or JSON format (reread on SIGHUP)",
 			"-subdirs", subcmd.Bool, true, "whether to serve subdirectories",
+			"-sets", subcmd.Bool, false, "list the titles of each movie set in a virtual sets/NAME/ folder",
+			"-genres", subcmd.Bool, false, "also list titles in a virtual genres/GENRE/ folder for each of their genres",
+			"-years", subcmd.Bool, false, "also list titles in virtual years/DECADE/ and years/YEAR/ folders",
+			"-letters", subcmd.Bool, false, "list titles in alphabetical folders (A/, B/, …) instead of all in one, for very large libraries",
+			"-pagesize", subcmd.Int, defaultPageSize, "number of entries per page of a directory listing requested with ?page=N",
 			"-verbose", subcmd.Bool, false, "log each chunk of content as it's served",
+			"-monitoring", subcmd.String, "", "ID of Google Cloud project to which to export health metrics",
+			"-auto", subcmd.String, "", "instead of -sheet, infer metadata from filenames and look it up automatically, keeping the results in this local file",
+			"-dlna", subcmd.String, "", "also serve the library to DLNA (UPnP) clients on the local network, without authentication, at this address (e.g. :1550)",
+			"-tv", subcmd.Bool, false, "serve episodes stored as SHOW/Season NN/S01E02.EXT as TV shows under a virtual tv/ folder",
+			"-kodi", subcmd.String, "", "comma-separated HOST:PORT of Kodi boxes whose libraries to update (via JSON-RPC) when titles are added or removed",
+			"-transcode", subcmd.Int, 0, "convert titles that browsers can't play to HLS with ffmpeg, running at most this many ffmpeg processes at once",
+			"-access", subcmd.String, "", "restrict subdirectories to certain users, as SUBDIR=USER,USER,...;SUBDIR=...",
+			"-allow-cidr", subcmd.String, "", "comma-separated address ranges (e.g. 192.168.1.0/24) from which to accept requests (default all)",
+			"-deny-cidr", subcmd.String, "", "comma-separated address ranges from which to refuse requests",
+			"-rate", subcmd.Float64, 20.0, "non-streaming requests per second allowed from each client address (0 for no limit)",
+			"-max-auth-failures", subcmd.Int, 10, "failed logins from a client address in 15 minutes before it is locked out for 15 minutes (0 for no lockouts)",
+			"-client-ca", subcmd.String, "", "file of PEM-encoded CA certificates; when serving TLS, require client certificates signed by one of them",
+			"-acme-domain", subcmd.String, "", "instead of -certcmd, get TLS certificates for these comma-separated domains from Let's Encrypt",
+			"-acme-email", subcmd.String, "", "contact email address for the Let's Encrypt account",
+			"-acme-cache", subcmd.String, "", "local directory in which to keep the Let's Encrypt account key and certificates (default the bucket)",
+			"-redirect", subcmd.String, "", "address (e.g. :80) at which to redirect plain-HTTP requests to HTTPS (and answer Let's Encrypt HTTP-01 challenges)",
+			"-pin", subcmd.String, "", "PIN required to list or play titles flagged in the adult column (default none; flagged titles are unrestricted)",
+			"-cors-origins", subcmd.String, "", "comma-separated origins (e.g. https://dash.example.com, or *) allowed to make cross-origin requests to /api/",
+			"-audit", subcmd.Bool, false, "record who streams what, when, and from where (see the audit subcommand)",
+			"-audit-file", subcmd.String, "", "local file for the -audit log (default the bucket)",
+			"-login", subcmd.Bool, false, "let browsers log in with a form and a session cookie instead of a Basic Auth prompt",
+			"-oidc-issuer", subcmd.String, "", "URL of an OpenID Connect provider through which browsers may log in (implies -login)",
+			"-oidc-client-id", subcmd.String, "", "client ID registered with the -oidc-issuer provider",
+			"-oidc-client-secret", subcmd.String, "", "client secret registered with the -oidc-issuer provider",
+			"-oidc-claim", subcmd.String, "email", "ID token claim naming the user who logs in with -oidc-issuer",
+			"-oidc-allow", subcmd.String, "", "comma-separated users, and @DOMAIN for email addresses in a domain, who may log in with -oidc-issuer (default anyone the provider authenticates)",
+			"-trusted-proxies", subcmd.String, "", "comma-separated addresses or CIDR ranges of reverse proxies whose X-Forwarded-For, -Proto, and -Host (or Forwarded) headers to believe",
+			"-debug-listen", subcmd.String, "", "loopback address (e.g. localhost:6060) at which to serve the Go profiler and runtime variables without authentication",
+			"-otlp-endpoint", subcmd.String, "", "URL of an OpenTelemetry collector (e.g. http://localhost:4318) to which to export traces over OTLP/HTTP",
+			"-config", subcmd.String, "", "YAML or JSON file (local or gs://) of settings (exts, access, verbose) to reread, with -headings and -users, on SIGHUP",
+			"-webhooks", subcmd.String, "", "comma-separated Slack, Discord, or other webhook URLs to notify of new titles and of streaming errors",
+			"-webhook-errors", subcmd.Int, 10, "streaming errors within 10 minutes that trigger a -webhooks alert (0 for no alerts)",
+			"-drain-timeout", subcmd.Duration, 30*time.Second, "on shutdown, how long to let streams in progress finish before closing their connections (0 to wait indefinitely)",
+			"-reuseport", subcmd.Bool, false, "listen with SO_REUSEPORT, so that a new kodigcs can start before this one stops",
+			"-alert-latency", subcmd.Duration, 3*time.Second, "median time over 5 minutes for thumbnail, .nfo, directory, or API responses, or for the first byte of a GCS read, above which to log an alert and send it to -webhooks (0 for none)",
+			"-alert-errors", subcmd.Float64, 0.2, "fraction of one kind of request (streams, thumbnails, .nfo files, directories, API calls) failing with server errors over 5 minutes above which to log an alert and send it to -webhooks (0 for none)",
+			"-ssupdate-interval", subcmd.Duration, time.Duration(0), "how often to fill in missing details in the metadata as ssupdate does (0 for never)",
+			"-error-dsn", subcmd.String, "", "Sentry DSN (https://KEY@HOST/PROJECT), or errorreporting://PROJECT for Google Cloud Error Reporting, to which to report server errors and panics",
+			"-admins", subcmd.String, "", "comma-separated users who may see and revoke grants at /admin/grants and change metadata with PATCH /api/titles/ROOTNAME (default the -username user)",
+		),
+		"upload", c.upload, "upload files to the bucket", subcmd.Params(
+			"-dups", subcmd.String, dupsAsk, "what to do with a file identical to an existing object: ask, alias, skip, or upload",
 		),
 		"ssupdate", c.ssupdate, "update the metadata spreadsheet", subcmd.Params(
 			"-htmldir", subcmd.String, "", "directory of IMDb *.iso.html files",
-			"-sheet", subcmd.String, "", "ID of Google spreadsheet with title metadata",
+			"-sheet", subcmd.String, "", "title metadata: ID[!TAB[!COLUMNS]] of Google spreadsheet, postgres:// URL, or airtable://BASEID/TABLE",
+			"-headshots", subcmd.Bool, false, "mirror actor headshots into the bucket under actors/",
+			"-refetch", subcmd.Bool, false, "fetch IMDb pages even if they are cached in the bucket under imdb-cache/",
+			"-scrapers", subcmd.String, "imdb", "comma-separated list of scrapers to consult, in order",
+			"-omdb-key", subcmd.String, "", "OMDb API key for the omdb scraper (default $OMDB_API_KEY)",
+			"-guess", subcmd.Bool, false, "for rows without an IMDb ID, search by title and year and record the best match",
+			"-scrape-interval", subcmd.Duration, defaultScrapeInterval, "minimum time between requests to IMDb",
+			"-user-agent", subcmd.String, "", "User-Agent header for outbound requests",
+			"-proxy", subcmd.String, "", "URL of an HTTP, HTTPS, or SOCKS5 proxy for outbound requests (default from $HTTPS_PROXY etc.)",
+			"-resume-from", subcmd.Int, 0, "skip spreadsheet rows before this one",
+			"-webhooks", subcmd.String, "", "comma-separated Slack, Discord, or other webhook URLs to send a summary when done",
+		),
+		"schema", c.schema, "check the metadata spreadsheet for problems", subcmd.Params(
+			"-sheet", subcmd.String, "", "title metadata: ID[!TAB[!COLUMNS]] of Google spreadsheet, CSV, TSV, YAML, or JSON file, postgres:// URL, or airtable://BASEID/TABLE",
+		),
+		"export", c.export, "write the merged title metadata as CSV or JSON", subcmd.Params(
+			"-sheet", subcmd.String, "", "title metadata: ID[!TAB[!COLUMNS]] of Google spreadsheet, CSV, TSV, YAML, or JSON file, postgres:// URL, or airtable://BASEID/TABLE",
+			"-format", subcmd.String, "json", "csv or json",
+			"-o", subcmd.String, "", "output file (default standard output)",
+		),
+		"dbload", c.dbload, "copy title metadata into a PostgreSQL database", subcmd.Params(
+			"-db", subcmd.String, "", "postgres:// URL of the database",
+			"-sheet", subcmd.String, "", "title metadata: ID[!TAB[!COLUMNS]] of Google spreadsheet, CSV, TSV, YAML, or JSON file, postgres:// URL, or airtable://BASEID/TABLE",
+		),
+		"addon", c.addon, "manage the Kodi addon", nil,
+		"token", c.token, "issue, list, and revoke tokens for devices and tools", nil,
+		"sources", c.sources, "write Kodi sources.xml and passwords.xml entries for the server", subcmd.Params(
+			"-url", subcmd.String, "", "URL of the server, as reachable from Kodi",
+			"-dav", subcmd.Bool, false, "use WebDAV (dav:// or davs://) instead of a web server directory",
+			"-username", subcmd.String, "", "HTTP Basic Auth username, as given to serve",
+			"-password", subcmd.String, "", "HTTP Basic Auth password, as given to serve",
+			"-subdirs", subcmd.String, "", "comma-separated subdirectories to add as sources of their own",
+			"-tv", subcmd.Bool, false, "add a source for the tv/ folder of serve -tv",
+			"-name", subcmd.String, "", "name of the source in Kodi (default \"kodigcs (BUCKETNAME)\")",
+		),
+		"audit", c.audit, "show who streamed what, when, and from where, as recorded by serve -audit", subcmd.Params(
+			"-file", subcmd.String, "", "local file of the log, as given to serve -audit-file (default the bucket)",
+			"-since", subcmd.String, "", "show streams since this time: a duration before now (e.g. 24h), a date, or an RFC 3339 time",
+			"-until", subcmd.String, "", "show streams before this time, in the same forms as -since",
+			"-user", subcmd.String, "", "show only streams by this user or token",
+			"-object", subcmd.String, "", "show only streams of objects whose names contain this",
+			"-addr", subcmd.String, "", "show only streams to this client address",
+			"-json", subcmd.Bool, false, "write JSON lines instead of a table",
+		),
+		"costs", c.costs, "estimate the monthly storage and egress costs of the bucket from the server's session history", subcmd.Params(
+			"-egress-price", subcmd.Float64, defaultEgressPrice, "dollars per GiB read from the bucket",
+			"-storage-price", subcmd.Float64, defaultStoragePrice, "dollars per GiB stored per month",
+			"-json", subcmd.Bool, false, "write JSON instead of text",
+		),
+		"doctor", c.doctor, "check the credentials, bucket, metadata, certificate, and listen address for problems", subcmd.Params(
+			"-sheet", subcmd.String, "", "title metadata, as given to serve",
+			"-listen", subcmd.String, ":1549", "listen address, as given to serve",
+			"-certcmd", subcmd.String, "", "cert command, as given to serve",
+			"-cert", subcmd.String, "", "certificate file, as given to serve",
+			"-key", subcmd.String, "", "private key file, as given to serve",
+		),
+		"alerts", c.alerts, "create Cloud Monitoring alert policies for the metrics exported by serve -monitoring", subcmd.Params(
+			"-project", subcmd.String, "", "ID of Google Cloud project",
+			"-channel", subcmd.String, "", "resource name of a notification channel for the policies (projects/PROJECT/notificationChannels/ID)",
 		),
 	)
 }
 
-func (c maincmd) serve(ctx context.Context, sheetID, listenAddr, certcmd, username, password string, subdirs, verbose bool, _ []string) error {
+func (c maincmd) serve(ctx context.Context, sheetID, listenAddr, certcmd, certFile, keyFile, username, password, passwordFile, usersFile string, subdirs, sets, genres, years, letters bool, pageSize int, verbose bool, monitoringProject, autoFile, dlnaAddr string, tv bool, kodi string, transcode int, access, allowCIDR, denyCIDR string, reqRate float64, maxAuthFailures int, clientCA, acmeDomain, acmeEmail, acmeCache, redirectAddr, pin, corsOrigins string, audit bool, auditFile string, login bool, oidcIssuer, oidcClientID, oidcClientSecret, oidcClaim, oidcAllow, trustedProxies, debugAddr, otlpEndpoint, config, webhooks string, webhookErrors int, drainTimeout time.Duration, reusePort bool, alertLatency time.Duration, alertErrors float64, ssupdateInterval time.Duration, errorDSN, admins string, _ []string) error {
 	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	s := &server{
-		bucket:      c.bucket,
-		dirTemplate: template.Must(template.New("").Parse(dirTemplate)),
-		listenAddr:  listenAddr,
-		password:    password,
-		sheetID:     sheetID,
-		ssvc:        c.ssvc,
-		subdirs:     subdirs,
-		tls:         certcmd != "",
-		username:    username,
-		verbose:     verbose,
-	}
-
-	return s.serveHelper(ctx, certcmd)
-}
+	password, err := servePassword(password, passwordFile)
+	if err != nil {
+		return err
+	}
+	if username, err = c.secrets.resolve(ctx, username); err != nil {
+		return errors.Wrap(err, "resolving -username")
+	}
+	if password, err = c.secrets.resolve(ctx, password); err != nil {
+		return errors.Wrap(err, "resolving -password")
+	}
+	if pin, err = c.secrets.resolve(ctx, pin); err != nil {
+		return errors.Wrap(err, "resolving -pin")
+	}
+	if oidcClientSecret, err = c.secrets.resolve(ctx, oidcClientSecret); err != nil {
+		return errors.Wrap(err, "resolving -oidc-client-secret")
+	}
+	logRedactor.addSecret(oidcClientSecret)
+	if !isPasswordHash(password) {
+		logRedactor.addSecret(password)
+	}
+	logRedactor.addSecret(pin)
 
-func (s *server) serveHelper(ctx context.Context, certcmd string) (err error) {
-	if certcmd == "" {
-		return s.serveWithCert(ctx, nil)
+	meta, err := newMetadataSource(sheetID, c.ssvc, c.dsvc, c.gcs)
+	if err != nil {
+		return err
+	}
+	if sheetID == "" && autoFile == "" {
+		manifest, name, err := findManifest(ctx, c.bucket)
+		if err != nil {
+			return err
+		}
+		if manifest != nil {
+			log.Printf("Using metadata from %s in the bucket", name)
+			meta, sheetID = manifest, name
+		}
 	}
 
-	certCh, wait, err := certs.FromCommand(ctx, certcmd)
+	kodiHosts, err := parseKodiHosts(kodi)
 	if err != nil {
-		return errors.Wrap(err, "launching cert command")
+		return err
 	}
-	defer func() {
-		err2 := wait()
-		err = errors.Join(err, err2)
-	}()
 
-	var (
-		cert tls.Certificate
-		ok   bool
-	)
+	if webhooks, err = c.secrets.resolve(ctx, webhooks); err != nil {
+		return errors.Wrap(err, "resolving -webhooks")
+	}
+	hooks, err := parseWebhooks(webhooks)
+	if err != nil {
+		return errors.Wrap(err, "in -webhooks")
+	}
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
+	var errReporter *errorReporter
+	if errorDSN != "" {
+		if errorDSN, err = c.secrets.resolve(ctx, errorDSN); err != nil {
+			return errors.Wrap(err, "resolving -error-dsn")
+		}
+		if errReporter, err = newErrorReporter(ctx, errorDSN, c.credsFile); err != nil {
+			return errors.Wrap(err, "in -error-dsn")
+		}
+	}
+
+	accessMap, err := parseAccess(access)
+	if err != nil {
+		return errors.Wrap(err, "in -access")
+	}
+
+	rl := &reloader{
+		config:   config,
+		headings: c.headingsFile,
+		gcs:      c.gcs,
+		flags:    settings{exts: mediaExts(), access: accessMap, verbose: verbose},
+	}
+	st, err := rl.loadSettings(ctx)
+	if err != nil {
+		return errors.Wrap(err, "in -config")
+	}
+	setMediaExts(st.exts)
+
+	ipf, err := newIPFilter(allowCIDR, denyCIDR)
+	if err != nil {
+		return err
+	}
 
-	case cert, ok = <-certCh:
-		if !ok {
-			return fmt.Errorf("cert command exited before producing a certificate")
+	cors, err := parseCORSOrigins(corsOrigins)
+	if err != nil {
+		return errors.Wrap(err, "in -cors-origins")
+	}
+
+	proxies, err := parsePrefixes(trustedProxies)
+	if err != nil {
+		return errors.Wrap(err, "in -trusted-proxies")
+	}
+
+	if debugAddr != "" {
+		if err := checkDebugAddr(debugAddr); err != nil {
+			return errors.Wrap(err, "in -debug-listen")
 		}
 	}
 
-	for {
-		newCertPtr, err := s.serveHelper2(ctx, certCh, cert)
+	if otlpEndpoint != "" {
+		shutdown, err := setupTracing(ctx, otlpEndpoint)
 		if err != nil {
-			return errors.Wrap(err, "serving with certificate")
+			return errors.Wrap(err, "in -otlp-endpoint")
 		}
-		cert = *newCertPtr
+		defer func() {
+			if err := shutdown(context.WithoutCancel(ctx)); err != nil {
+				log.Printf("Error flushing traces: %s", err)
+			}
+		}()
 	}
-}
 
-func (s *server) serveHelper2(outerCtx context.Context, certCh <-chan tls.Certificate, cert tls.Certificate) (*tls.Certificate, error) {
-	ctx, cancel := context.WithCancel(outerCtx)
-	defer cancel()
+	socket, err := activationListener()
+	if err != nil {
+		return err
+	}
 
-	errCh := make(chan error, 1)
+	grants := newGrantStore(c.bucket)
 
-	go func() {
-		errCh <- s.serveWithCert(ctx, &cert)
-		close(errCh)
-	}()
+	var accounts anyAuth
+	switch {
+	case username == "" || password == "":
+	case isPasswordHash(password):
+		if err := checkPasswordHashFormat(password); err != nil {
+			return errors.Wrap(err, "in -password")
+		}
+		accounts = append(accounts, usersAuth{users: newSingleUser(username, password)})
+	default:
+		accounts = append(accounts, basicAuth{username: username, password: password})
+	}
+	var users *userStore
+	if usersFile != "" {
+		if users, err = newUserStore(usersFile, grants); err != nil {
+			return err
+		}
+		accounts = append(accounts, usersAuth{users: users})
+	}
 
-	select {
-	case <-outerCtx.Done():
-		return nil, outerCtx.Err()
+	var oidcLogin *oidcLogin
+	if oidcIssuer != "" {
+		if oidcLogin, err = newOIDCLogin(ctx, oidcIssuer, oidcClientID, oidcClientSecret, oidcClaim, oidcAllow); err != nil {
+			return err
+		}
+		login = true
+	}
+	if login && len(accounts) == 0 && oidcLogin == nil {
+		return fmt.Errorf("-login requires -username and -password, -users, or -oidc-issuer")
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("-cert and -key must be used together")
+	}
+	if certFile != "" && certcmd != "" {
+		return fmt.Errorf("cannot use both -certcmd and -cert")
+	}
+
+	var acmeMgr *autocert.Manager
+	if acmeDomain != "" {
+		if certcmd != "" || certFile != "" {
+			return fmt.Errorf("cannot use -acme-domain with -certcmd or -cert")
+		}
+		if acmeMgr, err = newACMEManager(acmeDomain, acmeEmail, acmeCache, c.bucket); err != nil {
+			return err
+		}
+	}
 
-	case err := <-errCh: // TODO: can err be nil here?
-		if errors.Is(err, context.Canceled) && outerCtx.Err() == nil {
-			err = nil
+	var clientCAs *x509.CertPool
+	if clientCA != "" {
+		if certcmd == "" && certFile == "" && acmeMgr == nil {
+			return fmt.Errorf("-client-ca requires -certcmd, -cert, or -acme-domain")
 		}
-		return nil, errors.Wrap(err, "error from goroutine")
+		if clientCAs, err = loadClientCAs(clientCA); err != nil {
+			return err
+		}
+	}
 
-	case newCert, ok := <-certCh:
-		if !ok {
-			return nil, fmt.Errorf("cert command exited")
+	if redirectAddr != "" && certcmd == "" && certFile == "" && acmeMgr == nil {
+		return fmt.Errorf("-redirect requires -cert, -certcmd, or -acme-domain")
+	}
+	if autoFile != "" && sheetID != "" {
+		return fmt.Errorf("cannot use both -sheet and -auto")
+	}
+	if auditFile != "" && !audit {
+		return fmt.Errorf("-audit-file requires -audit")
+	}
+	var updateSrc writableSource
+	if ssupdateInterval > 0 {
+		var ok bool
+		if updateSrc, ok = meta.(writableSource); !ok {
+			return fmt.Errorf("-ssupdate-interval needs a Google spreadsheet, PostgreSQL database, or Airtable table for -sheet")
+		}
+	}
+	var tr *transcoder
+	if transcode > 0 {
+		if tr, err = newTranscoder(transcode); err != nil {
+			return err
 		}
+	}
 
-		cancel()
+	var auth authenticator = noAuth{}
+	switch {
+	case len(accounts) > 0 || oidcLogin != nil:
+		all := append(accounts,
+			tokenAuth{grants: grants},
+			shareAuth{grants: grants},
+			playlistAuth{grants: grants},
+		)
+		if login {
+			all = append(all, sessionAuth{grants: grants, valid: sessionValidator(accounts, oidcLogin)})
+		}
+		auth = all
+	case clientCAs != nil:
+		auth = clientCertAuth{}
+	}
 
-		err := <-errCh
+	s := &server{
+		access:       st.access,
+		reloader:     rl,
+		ipFilter:     ipf,
+		throttle:     newClientThrottle(reqRate, maxAuthFailures),
+		clientCAs:    clientCAs,
+		acme:         acmeMgr,
+		pin:          pin,
+		login:        login,
+		admins:       parseAdmins(admins, username),
+		oidc:         oidcLogin,
+		corsOrigins:  cors,
+		proxies:      proxies,
+		tracing:      otlpEndpoint != "",
+		drainTimeout: drainTimeout,
+		reusePort:    reusePort,
+		socket:       socket,
+		watchdog:     newWatchdog(alertLatency, alertErrors),
+		errReporter:  errReporter,
+		auth:         auth,
+		bucket:       c.bucket,
+		bucketName:   c.bucketName,
+		dirTemplate:  template.Must(template.New("").Parse(dirTemplate)),
+		listenAddr:   listenAddr,
+		pageSize:     pageSize,
+		kodiHosts:    kodiHosts,
+		webhooks:     hooks,
+		sheetID:      sheetID,
+		meta:         meta,
+		grants:       grants,
+		watched:      newWatchStore(c.bucket),
+		health:       newHealthCounters(),
+		sets:         sets,
+		genres:       genres,
+		years:        years,
+		letters:      letters,
+		ssvc:         c.ssvc,
+		stats:        newAccessStats(),
+		subdirs:      subdirs,
+		tls:          certcmd != "" || certFile != "" || acmeMgr != nil,
+		transcoder:   tr,
+		tv:           tv,
+		verbose:      st.verbose,
+	}
+
+	if autoFile != "" {
+		auto, err := newAutoMeta(autoFile, c.bucket)
 		if err != nil {
-			if errors.Is(err, context.Canceled) && outerCtx.Err() == nil {
-				err = nil
+			return errors.Wrap(err, "loading automatic metadata")
+		}
+		s.auto = auto
+	}
+
+	// All flags are checked; from here on the server starts its goroutines.
+
+	if users != nil {
+		go users.reloadOnHUP(ctx)
+	}
+	if s.auto != nil {
+		go s.runAuto(ctx)
+	}
+
+	if src, ok := s.meta.(versionedSource); ok {
+		go s.watchSource(ctx, src)
+	}
+
+	if len(kodiHosts) > 0 || len(hooks) > 0 {
+		go s.pollObjNames(ctx)
+	}
+	if len(hooks) > 0 && webhookErrors > 0 {
+		go s.watchStreamFailures(ctx, int64(webhookErrors))
+	}
+	if s.watchdog != nil {
+		go s.runWatchdog(ctx)
+	}
+	if s.errReporter != nil {
+		go s.errReporter.run(ctx)
+	}
+	if updateSrc != nil {
+		go s.autoUpdate(ctx, updateSrc, ssupdateInterval)
+	}
+
+	if s.throttle != nil {
+		go s.throttle.run(ctx)
+	}
+
+	if config != "" || c.headingsFile != "" {
+		go s.reloadOnHUP(ctx)
+	}
+
+	if audit {
+		s.audit = newAuditLog(auditFile, c.bucket)
+		go s.audit.run(ctx)
+	}
+
+	if s.transcoder != nil {
+		if err := s.startTranscoder(ctx); err != nil {
+			return err
+		}
+	}
+
+	if redirectAddr != "" {
+		go func() {
+			if err := s.runRedirect(ctx, redirectAddr); err != nil {
+				log.Printf("Error in HTTPS redirect server: %s", err)
 			}
+		}()
+	}
+
+	if debugAddr != "" {
+		go func() {
+			if err := s.runDebug(ctx, debugAddr); err != nil {
+				log.Printf("Error in debug server: %s", err)
+			}
+		}()
+	}
+
+	if dlnaAddr != "" {
+		go func() {
+			if err := s.runDLNA(ctx, dlnaAddr, c.bucketName); err != nil {
+				log.Printf("Error in DLNA server: %s", err)
+			}
+		}()
+	}
+
+	if err := s.grants.sync(ctx); err != nil {
+		return errors.Wrap(err, "loading grants")
+	}
+	go s.grants.run(ctx)
+
+	if err := s.watched.sync(ctx); err != nil {
+		return errors.Wrap(err, "loading watched states")
+	}
+	go s.watched.run(ctx)
+
+	expvar.Publish("tls", expvar.Func(s.tlsVars))
+	expvar.Publish("grants", expvar.Func(s.grants.vars))
+	expvar.Publish("health", expvar.Func(s.health.vars))
+	if s.watchdog != nil {
+		expvar.Publish("watchdog", expvar.Func(s.watchdog.vars))
+	}
+
+	if monitoringProject != "" {
+		msvc, err := monitoring.NewService(ctx, option.WithCredentialsFile(c.credsFile))
+		if err != nil {
+			return errors.Wrap(err, "creating monitoring service")
+		}
+		go s.exportMetrics(ctx, msvc, monitoringProject)
+	}
+
+	err = s.serveHelper(ctx, certcmd, certFile, keyFile)
+
+	ctx = context.WithoutCancel(ctx)
+
+	if saveErr := s.grants.sync(ctx); saveErr != nil {
+		log.Printf("Error saving grants: %s", saveErr)
+	}
+	if saveErr := s.watched.sync(ctx); saveErr != nil {
+		log.Printf("Error saving watched states: %s", saveErr)
+	}
+	if s.audit != nil {
+		if saveErr := s.audit.flush(ctx); saveErr != nil {
+			log.Printf("Error saving audit log: %s", saveErr)
 		}
+	}
 
-		return &newCert, errors.Wrap(err, "after canceling goroutine")
+	summary := s.sessionSummary()
+	log.Printf("Session summary: up %s, %d requests, %d bytes served, %d titles streamed, errors %v", summary.Uptime, summary.Requests, summary.BytesServed, summary.TitlesStreamed, summary.Errors)
+	if histErr := appendHistory(ctx, s.bucket, summary); histErr != nil {
+		log.Printf("Error saving session summary: %s", histErr)
 	}
+
+	return err
 }
 
-func (s *server) serveWithCert(ctx context.Context, cert *tls.Certificate) error {
-	var (
-		mux    = http.NewServeMux()
-		thumb  = mid.Err(s.handleThumb)
-		handle = mid.Err(s.handle)
-	)
-	if s.verbose {
-		thumb = mid.Log(thumb)
-		handle = mid.Log(handle)
+func (s *server) serveHelper(ctx context.Context, certcmd, certFile, keyFile string) error {
+	if s.acme != nil {
+		return s.listenAndServe(ctx, true)
+	}
+	if certFile != "" {
+		if err := s.loadCertFiles(ctx, certFile, keyFile); err != nil {
+			return err
+		}
+		go s.watchCertFiles(ctx, certFile, keyFile)
+		return s.listenAndServe(ctx, true)
+	}
+	if certcmd == "" {
+		return s.listenAndServe(ctx, false)
+	}
+
+	ready := make(chan struct{})
+	go s.runCertCmd(ctx, certcmd, ready)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+
+	case <-ready:
 	}
-	mux.Handle("/thumbs/", thumb)
-	mux.Handle("/", handle)
 
+	return s.listenAndServe(ctx, true)
+}
+
+// publicMux routes the requests to the server's main listener.
+// (The debug endpoints are on a separate listener; see debug.go.)
+func (s *server) publicMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	s.routeHealth(mux)
+	s.route(mux, "/admin/grants", s.handleGrants)
+	s.route(mux, "/pin", s.handlePIN)
+	if s.login {
+		s.routeLogin(mux)
+	}
+	s.route(mux, "/api/titles", s.handleAPITitles)
+	s.route(mux, "PATCH /api/titles/{rootname...}", s.handleAPITitlePatch)
+	s.route(mux, "/api/sections", s.handleAPISections)
+	s.route(mux, "GET /api/watched", s.handleAPIWatched)
+	s.route(mux, "GET /api/watched/{rootname...}", s.handleAPIWatchedTitle)
+	s.route(mux, "PUT /api/watched/{rootname...}", s.handleAPIWatchedTitle)
+	s.route(mux, "GET /api/dir/", s.handleAPIDir)
+	s.route(mux, "GET /api/search", s.handleAPISearch)
+	s.route(mux, "GET /search", s.handleSearch)
+	s.route(mux, "GET /gallery/", s.handleGallery)
+	s.route(mux, "GET /watch/{rootname...}", s.handleWatch)
+	s.route(mux, "POST /api/share/{rootname...}", s.handleShare)
+	if s.transcoder != nil {
+		s.route(mux, "GET /transcode/{rootname...}", s.handleTranscode)
+		s.route(mux, "GET /hls/{id}/{file}", s.handleHLS)
+	}
+	s.route(mux, "/export", s.handleExport)
+	s.route(mux, "/cast/", s.handleCast)
+	s.route(mux, "/playlist.m3u", s.handleLibraryPlaylist)
+	s.route(mux, "/thumbs/", s.handleThumb)
+	s.route(mux, "/actors/", s.handleHeadshot)
+	if s.tv {
+		s.route(mux, "/"+tvDir+"/", s.handleTV)
+	}
+	s.route(mux, "/", s.handle)
+	return mux
+}
+
+func (s *server) listenAndServe(ctx context.Context, useTLS bool) error {
 	h := &http.Server{
 		Addr:    s.listenAddr,
-		Handler: mux,
+		Handler: s.behindProxy(s.withHeaders(s.publicMux())),
 	}
-	if cert != nil {
-		h.TLSConfig = &tls.Config{Certificates: []tls.Certificate{*cert}}
+	if useTLS {
+		if s.acme != nil {
+			h.TLSConfig = s.acme.TLSConfig()
+		} else {
+			h.TLSConfig = &tls.Config{GetCertificate: s.getCertificate}
+			go s.refreshOCSP(ctx)
+		}
+		if s.clientCAs != nil {
+			h.TLSConfig.ClientCAs = s.clientCAs
+			h.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			if s.acme != nil {
+				// Let's Encrypt presents no client certificate in its TLS-ALPN-01 challenges.
+				challengeConfig := h.TLSConfig.Clone()
+				challengeConfig.ClientAuth = tls.NoClientCert
+				h.TLSConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+					if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+						return challengeConfig, nil
+					}
+					return nil, nil
+				}
+			}
+		}
+	}
+
+	ln := s.socket
+	if ln == nil {
+		var err error
+		if ln, err = s.listen(ctx, s.listenAddr); err != nil {
+			return err
+		}
 	}
 
 	errCh := make(chan error, 1)
 	go func() {
-		log.Printf("Listening on %s", s.listenAddr)
-		if cert != nil {
-			errCh <- h.ListenAndServeTLS("", "")
+		log.Printf("Listening on %s", ln.Addr())
+		if useTLS {
+			errCh <- h.ServeTLS(ln, "", "")
 		} else {
-			errCh <- h.ListenAndServe()
+			errCh <- h.Serve(ln)
 		}
 		close(errCh)
 	}()
 
-	ctxWithoutCancel := context.WithoutCancel(ctx)
-
 	select {
 	case <-ctx.Done():
 		log.Printf("Context canceled, shutting down server")
-		if err := h.Shutdown(ctxWithoutCancel); err != nil {
-			return errors.Wrap(err, "in Shutdown")
+		if err := s.drain(h); err != nil {
+			return err
 		}
 		err := <-errCh
 		if errors.Is(err, http.ErrServerClosed) {
 			return nil
 		}
-		return errors.Wrap(err, "in ListenAndServe")
+		return errors.Wrap(err, "in Serve")
 
 	case err := <-errCh:
 		if errors.Is(err, http.ErrServerClosed) {
 			return nil
 		}
-		return errors.Wrap(err, "in ListenAndServe")
+		return errors.Wrap(err, "in Serve")
 	}
 }
 
-func (c maincmd) ssupdate(ctx context.Context, htmldir, sheetID string, _ []string) error {
-	return updateSpreadsheet(ctx, c.ssvc, c.bucket, htmldir, sheetID)
+func (c maincmd) ssupdate(ctx context.Context, htmldir, sheetID string, headshots, refetch bool, scraperNames, omdbKey string, guess bool, scrapeInterval time.Duration, userAgent, proxy string, resumeFrom int, webhooks string, _ []string) error {
+	if omdbKey == "" {
+		omdbKey = os.Getenv("OMDB_API_KEY")
+	}
+	omdbKey, err := c.secrets.resolve(ctx, omdbKey)
+	if err != nil {
+		return errors.Wrap(err, "resolving -omdb-key")
+	}
+	logRedactor.addSecret(omdbKey)
+
+	if webhooks, err = c.secrets.resolve(ctx, webhooks); err != nil {
+		return errors.Wrap(err, "resolving -webhooks")
+	}
+	hooks, err := parseWebhooks(webhooks)
+	if err != nil {
+		return errors.Wrap(err, "in -webhooks")
+	}
+
+	transport, err := newOutboundTransport(userAgent, proxy)
+	if err != nil {
+		return err
+	}
+
+	meta, err := newMetadataSource(sheetID, c.ssvc, c.dsvc, c.gcs)
+	if err != nil {
+		return err
+	}
+	src, ok := meta.(writableSource)
+	if !ok {
+		return fmt.Errorf("ssupdate needs a Google spreadsheet, PostgreSQL database, or Airtable table, not %s", sheetID)
+	}
+
+	lockCtx, release, err := acquireUpdateLock(ctx, c.bucket)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	summary, err := updateSpreadsheet(lockCtx, src, c.bucket, htmldir, scraperNames, omdbKey, transport, scrapeInterval, headshots, refetch, guess, resumeFrom)
+	if err != nil && errors.Is(context.Cause(lockCtx), errUpdateLockLost) {
+		err = errUpdateLockLost
+	}
+	if len(hooks) > 0 {
+		sendWebhooks(context.WithoutCancel(ctx), hooks, ssupdateEvent(c.bucketName, summary, err))
+	}
+	return err
+}
+
+// How long ssupdate waits for a response from IMDb or another site
+// before giving up on the request (which may then be retried).
+const outboundResponseTimeout = time.Minute
+
+// newOutboundTransport returns the transport for ssupdate's requests to IMDb and other sites.
+// Requests time out after outboundResponseTimeout without response headers.
+// If userAgent is non-empty it replaces Go's default User-Agent header.
+// If proxyURL is non-empty, requests go through that proxy
+// (http, https, or socks5);
+// otherwise the proxy, if any, is taken from the environment.
+func newOutboundTransport(userAgent, proxyURL string) (http.RoundTripper, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = outboundResponseTimeout
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing proxy URL")
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+		if pw, ok := u.User.Password(); ok {
+			logRedactor.addSecret(pw)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	if userAgent == "" {
+		return t, nil
+	}
+	return &userAgentTransport{userAgent: userAgent, transport: t}, nil
 }
 
 func rootNamePrefix(rootName string) string {
@@ -244,36 +855,80 @@ func rootNamePrefix(rootName string) string {
 
 type (
 	movieInfo struct {
-		XMLName   xml.Name `xml:"movie"`
-		Title     string   `xml:"title,omitempty"`
-		SortTitle string   `xml:"sorttitle,omitempty"`
-		Year      int      `xml:"year,omitempty"`
-		Thumbs    []thumb  `xml:"thumb,omitempty"`
-		Directors []string `xml:"director,omitempty"`
-		Actors    []actor  `xml:"actor,omitempty"`
-		Runtime   int      `xml:"runtime,omitempty"`
-		Trailer   string   `xml:"trailer,omitempty"`
-		Outline   string   `xml:"outline,omitempty"`
-		Plot      string   `xml:"plot,omitempty"`
-		Tagline   string   `xml:"tagline,omitempty"`
-		Genre     string   `xml:"genre,omitempty"`
-		subdir    string
-		imdbID    string
+		XMLName   xml.Name  `xml:"movie"`
+		Title     string    `xml:"title,omitempty"`
+		OrigTitle string    `xml:"originaltitle,omitempty"`
+		SortTitle string    `xml:"sorttitle,omitempty"`
+		Year      int       `xml:"year,omitempty"`
+		Premiered string    `xml:"premiered,omitempty"`
+		DateAdded string    `xml:"dateadded,omitempty"`
+		Thumbs    []thumb   `xml:"thumb,omitempty"`
+		Directors []string  `xml:"director,omitempty"`
+		Actors    []actor   `xml:"actor,omitempty"`
+		Runtime   int       `xml:"runtime,omitempty"`
+		Trailer   string    `xml:"trailer,omitempty"`
+		Outline   string    `xml:"outline,omitempty"`
+		Plot      string    `xml:"plot,omitempty"`
+		Tagline   string    `xml:"tagline,omitempty"`
+		Set       *movieSet `xml:"set,omitempty"`
+		Ratings   []rating  `xml:"ratings>rating,omitempty"`
+		Top250    int       `xml:"top250,omitempty"`
+		Genre     string    `xml:"genre,omitempty"`
+		Countries []string  `xml:"country,omitempty"`
+		Studios   []string  `xml:"studio,omitempty"`
+		MPAA      string    `xml:"mpaa,omitempty"`
+		FileInfo  *fileInfo `xml:"fileinfo,omitempty"`
+
+		// These come from the watched state of the user requesting the .nfo file.
+		PlayCount  int          `xml:"playcount,omitempty"`
+		LastPlayed string       `xml:"lastplayed,omitempty"`
+		Resume     *resumePoint `xml:"resume,omitempty"`
+
+		subdir   string
+		imdbID   string
+		awards   string
+		filename string // as in the first column of the title's row
+		parts    string // glob matching the objects of a multi-part title
+		hidden   bool   // omitted from directory listings and not served
+		adult    bool   // listed and served only to requests that give the PIN (see pin.go)
 	}
 
 	thumb struct {
 		XMLName xml.Name `xml:"thumb"`
-		Aspect  string   `xml:"aspect,attr"`
+		Aspect  string   `xml:"aspect,attr,omitempty"`
 		Val     string   `xml:",chardata"`
 		origVal string
 	}
 
+	// movieSet is a collection of related titles, such as a film series.
+	movieSet struct {
+		Name string `xml:"name"`
+	}
+
+	// rating is a score from a source such as IMDb or Rotten Tomatoes.
+	rating struct {
+		Name    string  `xml:"name,attr"`
+		Max     int     `xml:"max,attr"`
+		Default bool    `xml:"default,attr,omitempty"`
+		Value   float64 `xml:"value"`
+	}
+
+	// fileInfo conveys audio-language hints.
+	// Kodi replaces it with real stream details when it plays the file.
+	fileInfo struct {
+		Audio []audioStream `xml:"streamdetails>audio"`
+	}
+
+	audioStream struct {
+		Language string `xml:"language"`
+	}
+
 	actor struct {
 		XMLName xml.Name `xml:"actor"`
 		Name    string   `xml:"name"`
 		Role    string   `xml:"role,omitempty"`
 		Order   int      `xml:"order"`
-		Thumb   thumb    `xml:"thumb,omitempty"`
+		Thumb   *thumb   `xml:"thumb,omitempty"`
 	}
 )
 
@@ -289,3 +944,61 @@ func (lt *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error)
 	}
 	return lt.transport.RoundTrip(req)
 }
+
+// retryTransport retries requests that fail with network errors,
+// 429 (Too Many Requests), or 5xx statuses,
+// with exponential backoff.
+// Only requests without bodies are retried.
+type retryTransport struct {
+	retries   int
+	backoff   time.Duration // before the first retry; doubled for each subsequent one
+	transport http.RoundTripper
+}
+
+func (rt *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay := rt.backoff
+	for try := 0; ; try++ {
+		resp, err := rt.transport.RoundTrip(req)
+		if try >= rt.retries || req.Body != nil || !retryable(resp, err) {
+			return resp, err
+		}
+
+		wait := delay
+		if err != nil {
+			log.Printf("Error getting %s (will retry in %s): %s", req.URL, wait, err)
+		} else {
+			if secs, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && secs > 0 {
+				wait = time.Duration(secs) * time.Second
+			}
+			log.Printf("Status %d getting %s (will retry in %s)", resp.StatusCode, req.URL, wait)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+type userAgentTransport struct {
+	userAgent string
+	transport http.RoundTripper
+}
+
+func (ut *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", ut.userAgent)
+	return ut.transport.RoundTrip(req)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOutboundTransport(t *testing.T) {
+	var gotUA, gotURI string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotUA, gotURI = req.Header.Get("User-Agent"), req.RequestURI
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name, userAgent string
+		proxy           bool
+		wantUA          string
+	}{
+		{name: "default", wantUA: "Go-http-client/1.1"},
+		{name: "user agent", userAgent: "kodigcs-test/1.0", wantUA: "kodigcs-test/1.0"},
+		{name: "proxy", userAgent: "kodigcs-test/1.0", proxy: true, wantUA: "kodigcs-test/1.0"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// With a proxy, requests for any host go to the test server,
+			// which sees the absolute URL.
+			target, proxyURL := srv.URL+"/title/tt0025878/", ""
+			if c.proxy {
+				target, proxyURL = "http://www.imdb.com/title/tt0025878/", srv.URL
+			}
+
+			rt, err := newOutboundTransport(c.userAgent, proxyURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			inner := rt
+			if ut, ok := rt.(*userAgentTransport); ok {
+				inner = ut.transport
+			}
+			if got := inner.(*http.Transport).ResponseHeaderTimeout; got != outboundResponseTimeout {
+				t.Errorf("got response header timeout %s, want %s", got, outboundResponseTimeout)
+			}
+
+			req, err := http.NewRequest("GET", target, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := (&http.Client{Transport: rt}).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if gotUA != c.wantUA {
+				t.Errorf("got User-Agent %q, want %q", gotUA, c.wantUA)
+			}
+			if c.proxy && gotURI != target {
+				t.Errorf("proxy got request for %q, want %q", gotURI, target)
+			}
+			if !c.proxy && gotURI != "/title/tt0025878/" {
+				t.Errorf("got request for %q", gotURI)
+			}
+			if req.Header.Get("User-Agent") != "" {
+				t.Error("caller's request modified")
+			}
+		})
+	}
+
+	for _, proxy := range []string{"ftp://proxy.example.com", "://bad"} {
+		if _, err := newOutboundTransport("", proxy); err == nil {
+			t.Errorf("got no error for proxy %q", proxy)
+		}
+	}
+	if _, err := newOutboundTransport("", "socks5://localhost:1080"); err != nil {
+		t.Errorf("socks5 proxy: %s", err)
+	}
+}
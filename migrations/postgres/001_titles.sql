@@ -0,0 +1,25 @@
+-- Title metadata, one row per title.
+-- The cells of a row are keyed by lowercase metadata spreadsheet heading.
+CREATE TABLE titles (
+  filename TEXT PRIMARY KEY,
+  cells JSONB NOT NULL DEFAULT '{}',
+  updated TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- A counter bumped by every change to titles,
+-- so that servers can cheaply tell when to reload.
+CREATE TABLE metadata_version (
+  n BIGINT NOT NULL
+);
+INSERT INTO metadata_version (n) VALUES (0);
+
+CREATE FUNCTION bump_metadata_version() RETURNS trigger AS $$
+BEGIN
+  UPDATE metadata_version SET n = n + 1;
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER titles_changed
+  AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON titles
+  FOR EACH STATEMENT EXECUTE FUNCTION bump_metadata_version();
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+)
+
+// healthCounters count events that bear on the health of the server.
+type healthCounters struct {
+	start time.Time
+
+	requests       atomic.Int64
+	errors         atomic.Int64 // responses with a 5xx status
+	clientErrors   atomic.Int64 // responses with a 4xx status
+	streamFailures atomic.Int64 // failures serving media objects (see isStreamFailure)
+	quotaErrors    atomic.Int64 // errors from Google APIs due to rate limits or quotas
+
+	// When reloading the metadata spreadsheet started failing (in Unix nanoseconds),
+	// or zero if the last reload succeeded.
+	sheetFailingSince atomic.Int64
+}
+
+func newHealthCounters() *healthCounters {
+	return &healthCounters{start: time.Now()}
+}
+
+// observe counts a request and classifies the error, if any, that handling it produced.
+func (h *healthCounters) observe(err error) {
+	h.requests.Add(1)
+	if err == nil {
+		return
+	}
+	if code := errorCode(err); code >= 500 {
+		h.errors.Add(1)
+	} else if code >= 400 {
+		h.clientErrors.Add(1)
+	}
+	if isQuotaErr(err) {
+		h.quotaErrors.Add(1)
+	}
+}
+
+// errorCode is the HTTP status that mid.Err responds with for err.
+func errorCode(err error) int {
+	var codeErr mid.CodeErr
+	if errors.As(err, &codeErr) {
+		return codeErr.C
+	}
+	return http.StatusInternalServerError
+}
+
+// isStreamFailure tells whether err, from serving a media object,
+// is a failure of the server or of GCS,
+// as opposed to a canceled request
+// or a client error such as a missing object or an unsatisfiable range.
+func isStreamFailure(err error) bool {
+	return err != nil && !errors.Is(err, context.Canceled) && errorCode(err) >= 500
+}
+
+func isQuotaErr(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	for _, item := range apiErr.Errors {
+		switch item.Reason {
+		case "rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded":
+			return true
+		}
+	}
+	return false
+}
+
+// sheetLoaded records the outcome of an attempt to reload the metadata spreadsheet.
+func (h *healthCounters) sheetLoaded(err error) {
+	if err == nil {
+		h.sheetFailingSince.Store(0)
+		return
+	}
+	h.sheetFailingSince.CompareAndSwap(0, time.Now().UnixNano())
+}
+
+// sheetStaleness is how long reloading the metadata spreadsheet has been failing.
+func (h *healthCounters) sheetStaleness() time.Duration {
+	since := h.sheetFailingSince.Load()
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
+func (h *healthCounters) vars() any {
+	return map[string]int64{
+		"requests":        h.requests.Load(),
+		"errors":          h.errors.Load(),
+		"stream_failures": h.streamFailures.Load(),
+		"quota_errors":    h.quotaErrors.Load(),
+		"sheet_staleness": int64(h.sheetStaleness().Seconds()),
+	}
+}
+
+// Custom metrics exported to Cloud Monitoring.
+const (
+	metricPrefix         = "custom.googleapis.com/kodigcs/"
+	metricRequests       = metricPrefix + "requests"
+	metricErrors         = metricPrefix + "errors"
+	metricStreamFailures = metricPrefix + "stream_failures"
+	metricQuotaErrors    = metricPrefix + "quota_errors"
+	metricSheetStaleness = metricPrefix + "sheet_staleness"
+)
+
+const monitoringInterval = time.Minute
+
+// exportMetrics writes the server's health metrics to Cloud Monitoring in the given project
+// every monitoringInterval,
+// until the context is canceled.
+func (s *server) exportMetrics(ctx context.Context, msvc *monitoring.Service, project string) {
+	ticker := time.NewTicker(monitoringInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			if err := s.writeMetrics(ctx, msvc, project); err != nil {
+				log.Printf("Error exporting metrics: %s", err)
+			}
+		}
+	}
+}
+
+// metricInstance is the instance label of the server's time series,
+// distinguishing them from those of other servers exporting to the same project
+// (and from those of an earlier run of this one):
+// the host name and the time the server started.
+func (s *server) metricInstance() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + "@" + s.health.start.UTC().Format("20060102T150405Z")
+}
+
+func (s *server) writeMetrics(ctx context.Context, msvc *monitoring.Service, project string) error {
+	var (
+		now      = time.Now()
+		start    = s.health.start.Format(time.RFC3339Nano)
+		end      = now.Format(time.RFC3339Nano)
+		resource = &monitoring.MonitoredResource{
+			Type:   "global",
+			Labels: map[string]string{"project_id": project},
+		}
+		labels = map[string]string{"listen": s.listenAddr, "instance": s.metricInstance()}
+	)
+
+	cumulative := func(metricType string, val int64) *monitoring.TimeSeries {
+		return &monitoring.TimeSeries{
+			Metric:     &monitoring.Metric{Type: metricType, Labels: labels},
+			Resource:   resource,
+			MetricKind: "CUMULATIVE",
+			ValueType:  "INT64",
+			Points: []*monitoring.Point{{
+				Interval: &monitoring.TimeInterval{StartTime: start, EndTime: end},
+				Value:    &monitoring.TypedValue{Int64Value: &val},
+			}},
+		}
+	}
+
+	series := []*monitoring.TimeSeries{
+		cumulative(metricRequests, s.health.requests.Load()),
+		cumulative(metricErrors, s.health.errors.Load()),
+		cumulative(metricStreamFailures, s.health.streamFailures.Load()),
+		cumulative(metricQuotaErrors, s.health.quotaErrors.Load()),
+	}
+
+	if s.sheetID != "" {
+		staleness := s.health.sheetStaleness().Seconds()
+		series = append(series, &monitoring.TimeSeries{
+			Metric:     &monitoring.Metric{Type: metricSheetStaleness, Labels: labels},
+			Resource:   resource,
+			MetricKind: "GAUGE",
+			ValueType:  "DOUBLE",
+			Unit:       "s",
+			Points: []*monitoring.Point{{
+				Interval: &monitoring.TimeInterval{EndTime: end},
+				Value:    &monitoring.TypedValue{DoubleValue: &staleness},
+			}},
+		})
+	}
+
+	_, err := msvc.Projects.TimeSeries.Create("projects/"+project, &monitoring.CreateTimeSeriesRequest{TimeSeries: series}).Context(ctx).Do()
+	return errors.Wrap(err, "writing time series")
+}
+
+// alertPolicies are the policies created by the alerts subcommand.
+var alertPolicies = []struct {
+	name, doc string
+	threshold *monitoring.MetricThreshold
+}{
+	{
+		name: "kodigcs error rate",
+		doc:  "More than 5% of kodigcs requests are failing with server errors.",
+		threshold: &monitoring.MetricThreshold{
+			Filter:                  fmt.Sprintf(`metric.type = "%s" AND resource.type = "global"`, metricErrors),
+			DenominatorFilter:       fmt.Sprintf(`metric.type = "%s" AND resource.type = "global"`, metricRequests),
+			Aggregations:            []*monitoring.Aggregation{{AlignmentPeriod: "300s", PerSeriesAligner: "ALIGN_DELTA"}},
+			DenominatorAggregations: []*monitoring.Aggregation{{AlignmentPeriod: "300s", PerSeriesAligner: "ALIGN_DELTA"}},
+			Comparison:              "COMPARISON_GT",
+			ThresholdValue:          0.05,
+			Duration:                "300s",
+		},
+	},
+	{
+		name: "kodigcs stream failures",
+		doc:  "kodigcs is failing to stream media from the bucket.",
+		threshold: &monitoring.MetricThreshold{
+			Filter:       fmt.Sprintf(`metric.type = "%s" AND resource.type = "global"`, metricStreamFailures),
+			Aggregations: []*monitoring.Aggregation{{AlignmentPeriod: "300s", PerSeriesAligner: "ALIGN_DELTA"}},
+			Comparison:   "COMPARISON_GT",
+			Duration:     "0s",
+		},
+	},
+	{
+		name: "kodigcs quota errors",
+		doc:  "kodigcs is hitting rate limits or quotas in Google APIs (Cloud Storage or Sheets).",
+		threshold: &monitoring.MetricThreshold{
+			Filter:       fmt.Sprintf(`metric.type = "%s" AND resource.type = "global"`, metricQuotaErrors),
+			Aggregations: []*monitoring.Aggregation{{AlignmentPeriod: "300s", PerSeriesAligner: "ALIGN_DELTA"}},
+			Comparison:   "COMPARISON_GT",
+			Duration:     "0s",
+		},
+	},
+	{
+		name: "kodigcs stale metadata",
+		doc:  "kodigcs has been unable to reload its metadata spreadsheet for over an hour.",
+		threshold: &monitoring.MetricThreshold{
+			Filter:         fmt.Sprintf(`metric.type = "%s" AND resource.type = "global"`, metricSheetStaleness),
+			Aggregations:   []*monitoring.Aggregation{{AlignmentPeriod: "300s", PerSeriesAligner: "ALIGN_MIN"}},
+			Comparison:     "COMPARISON_GT",
+			ThresholdValue: 3600,
+			Duration:       "900s",
+		},
+	},
+}
+
+func (c maincmd) alerts(ctx context.Context, project, channel string, _ []string) error {
+	if project == "" {
+		return fmt.Errorf("must specify -project")
+	}
+
+	msvc, err := monitoring.NewService(ctx, option.WithCredentialsFile(c.credsFile))
+	if err != nil {
+		return errors.Wrap(err, "creating monitoring service")
+	}
+
+	projName := "projects/" + project
+
+	existing := make(map[string]bool)
+	err = msvc.Projects.AlertPolicies.List(projName).Pages(ctx, func(resp *monitoring.ListAlertPoliciesResponse) error {
+		for _, p := range resp.AlertPolicies {
+			existing[p.DisplayName] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "listing alert policies")
+	}
+
+	for _, p := range alertPolicies {
+		if existing[p.name] {
+			log.Printf("Alert policy %q already exists, skipping", p.name)
+			continue
+		}
+
+		policy := &monitoring.AlertPolicy{
+			DisplayName: p.name,
+			Combiner:    "OR",
+			Conditions: []*monitoring.Condition{{
+				DisplayName:        p.name,
+				ConditionThreshold: p.threshold,
+			}},
+			Documentation: &monitoring.Documentation{
+				Content:  p.doc,
+				MimeType: "text/markdown",
+			},
+		}
+		if channel != "" {
+			policy.NotificationChannels = []string{channel}
+		}
+
+		if _, err := msvc.Projects.AlertPolicies.Create(projName, policy).Context(ctx).Do(); err != nil {
+			return errors.Wrapf(err, "creating alert policy %q", p.name)
+		}
+		log.Printf("Created alert policy %q", p.name)
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type point struct {
+		kind, valueType string
+		value           float64
+	}
+
+	cases := []struct {
+		name         string
+		sheetID      string
+		failingSince time.Duration // before now, or zero
+		want         map[string]point
+	}{{
+		name: "no spreadsheet",
+		want: map[string]point{
+			metricRequests:       {"CUMULATIVE", "INT64", 10},
+			metricErrors:         {"CUMULATIVE", "INT64", 2},
+			metricStreamFailures: {"CUMULATIVE", "INT64", 1},
+			metricQuotaErrors:    {"CUMULATIVE", "INT64", 1},
+		},
+	}, {
+		name:    "spreadsheet reloading",
+		sheetID: "sheet",
+		want: map[string]point{
+			metricRequests:       {"CUMULATIVE", "INT64", 10},
+			metricErrors:         {"CUMULATIVE", "INT64", 2},
+			metricStreamFailures: {"CUMULATIVE", "INT64", 1},
+			metricQuotaErrors:    {"CUMULATIVE", "INT64", 1},
+			metricSheetStaleness: {"GAUGE", "DOUBLE", 0},
+		},
+	}, {
+		name:         "spreadsheet failing",
+		sheetID:      "sheet",
+		failingSince: 2 * time.Hour,
+		want: map[string]point{
+			metricRequests:       {"CUMULATIVE", "INT64", 10},
+			metricErrors:         {"CUMULATIVE", "INT64", 2},
+			metricStreamFailures: {"CUMULATIVE", "INT64", 1},
+			metricQuotaErrors:    {"CUMULATIVE", "INT64", 1},
+			metricSheetStaleness: {"GAUGE", "DOUBLE", 7200},
+		},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got monitoring.CreateTimeSeriesRequest
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.Method != "POST" || req.URL.Path != "/v3/projects/proj/timeSeries" {
+					http.Error(w, "unexpected "+req.Method+" "+req.URL.Path, http.StatusBadRequest)
+					return
+				}
+				if err := json.NewDecoder(req.Body).Decode(&got); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				w.Write([]byte("{}"))
+			}))
+			defer srv.Close()
+
+			msvc, err := monitoring.NewService(context.Background(), option.WithEndpoint(srv.URL+"/"), option.WithoutAuthentication())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			s := &server{
+				health:     &healthCounters{start: start},
+				listenAddr: ":1549",
+				sheetID:    c.sheetID,
+			}
+			for i := 0; i < 10; i++ {
+				s.health.observe(nil)
+			}
+			s.health.errors.Add(2)
+			s.health.streamFailures.Add(1)
+			s.health.quotaErrors.Add(1)
+			if c.failingSince > 0 {
+				s.health.sheetFailingSince.Store(time.Now().Add(-c.failingSince).UnixNano())
+			}
+
+			if err := s.writeMetrics(context.Background(), msvc, "proj"); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.TimeSeries) != len(c.want) {
+				t.Fatalf("got %d time series, want %d", len(got.TimeSeries), len(c.want))
+			}
+			wantInstance := host + "@20240501T120000Z"
+			for _, ts := range got.TimeSeries {
+				want, ok := c.want[ts.Metric.Type]
+				if !ok {
+					t.Errorf("unexpected time series %s", ts.Metric.Type)
+					continue
+				}
+				if ts.MetricKind != want.kind || ts.ValueType != want.valueType {
+					t.Errorf("%s: got %s %s, want %s %s", ts.Metric.Type, ts.MetricKind, ts.ValueType, want.kind, want.valueType)
+				}
+				if l := ts.Metric.Labels; l["listen"] != ":1549" || l["instance"] != wantInstance {
+					t.Errorf("%s: got labels %v", ts.Metric.Type, l)
+				}
+				if ts.Resource.Type != "global" || ts.Resource.Labels["project_id"] != "proj" {
+					t.Errorf("%s: got resource %+v", ts.Metric.Type, ts.Resource)
+				}
+				if len(ts.Points) != 1 {
+					t.Fatalf("%s: got %d points", ts.Metric.Type, len(ts.Points))
+				}
+				p := ts.Points[0]
+				var val float64
+				switch want.valueType {
+				case "INT64":
+					val = float64(*p.Value.Int64Value)
+					if p.Interval.StartTime != start.Format(time.RFC3339Nano) {
+						t.Errorf("%s: got start time %s", ts.Metric.Type, p.Interval.StartTime)
+					}
+				case "DOUBLE":
+					val = *p.Value.DoubleValue
+				}
+				if val < want.value || val > want.value+60 { // staleness grows as the test runs
+					t.Errorf("%s: got %v, want %v", ts.Metric.Type, val, want.value)
+				}
+			}
+		})
+	}
+}
+
+func TestAlertPolicies(t *testing.T) {
+	// fires tells whether a threshold condition is met
+	// by an aligned value of its metric
+	// (and of its denominator metric, for a ratio).
+	fires := func(th *monitoring.MetricThreshold, val, denom float64) bool {
+		if th.DenominatorFilter != "" {
+			if denom == 0 {
+				return false
+			}
+			val /= denom
+		}
+		switch th.Comparison {
+		case "COMPARISON_GT":
+			return val > th.ThresholdValue
+		case "COMPARISON_LT":
+			return val < th.ThresholdValue
+		}
+		t.Fatalf("unexpected comparison %s", th.Comparison)
+		return false
+	}
+
+	policies := make(map[string]*monitoring.MetricThreshold)
+	for _, p := range alertPolicies {
+		policies[p.name] = p.threshold
+	}
+
+	cases := []struct {
+		policy     string
+		metric     string
+		val, denom float64 // per alignment period
+		want       bool
+	}{
+		{policy: "kodigcs error rate", metric: metricErrors, val: 6, denom: 100, want: true},
+		{policy: "kodigcs error rate", metric: metricErrors, val: 5, denom: 100},
+		{policy: "kodigcs error rate", metric: metricErrors, val: 0, denom: 0},
+		{policy: "kodigcs stream failures", metric: metricStreamFailures, val: 1, want: true},
+		{policy: "kodigcs stream failures", metric: metricStreamFailures, val: 0},
+		{policy: "kodigcs quota errors", metric: metricQuotaErrors, val: 3, want: true},
+		{policy: "kodigcs quota errors", metric: metricQuotaErrors, val: 0},
+		{policy: "kodigcs stale metadata", metric: metricSheetStaleness, val: 7200, want: true},
+		{policy: "kodigcs stale metadata", metric: metricSheetStaleness, val: 600},
+	}
+	for _, c := range cases {
+		th, ok := policies[c.policy]
+		if !ok {
+			t.Fatalf("no policy %q", c.policy)
+		}
+		if !strings.Contains(th.Filter, `metric.type = "`+c.metric+`"`) {
+			t.Errorf("%s: filter %s is not on %s", c.policy, th.Filter, c.metric)
+		}
+		if c.denom > 0 && !strings.Contains(th.DenominatorFilter, `metric.type = "`+metricRequests+`"`) {
+			t.Errorf("%s: denominator filter %s is not on %s", c.policy, th.DenominatorFilter, metricRequests)
+		}
+		if got := fires(th, c.val, c.denom); got != c.want {
+			t.Errorf("%s with %v/%v: got firing %v, want %v", c.policy, c.val, c.denom, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// With serve -oidc-issuer,
+// browsers log in through an OpenID Connect identity provider
+// (such as Google, Authentik, Keycloak, or Authelia)
+// instead of, or as well as, with a password (see login.go).
+// The login form sends the browser to the provider,
+// which sends it back to /login/callback
+// with a code that the server exchanges for an ID token.
+// The principal is a claim from the token (by default the email address),
+// which must be among those in -oidc-allow if that is given.
+// The browser then gets a session cookie,
+// as with a password login.
+// This is only for browsers;
+// Kodi and other clients use Basic Auth or tokens.
+
+const (
+	oidcCookie   = "kodigcs_oidc"
+	oidcStateTTL = 10 * time.Minute
+)
+
+// oidcLogin is the server's OpenID Connect client.
+type oidcLogin struct {
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	clientID string
+	secret   string
+	claim    string         // the claim naming the principal
+	allow    set.Of[string] // principals, and @DOMAIN for any email address in a domain; empty means anyone
+}
+
+// newOIDCLogin discovers the provider's configuration from issuer.
+func newOIDCLogin(ctx context.Context, issuer, clientID, clientSecret, claim, allow string) (*oidcLogin, error) {
+	if clientID == "" {
+		return nil, fmt.Errorf("-oidc-issuer requires -oidc-client-id")
+	}
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "discovering OpenID Connect provider %s", issuer)
+	}
+	if claim == "" {
+		claim = "email"
+	}
+	allowed := set.New[string]()
+	for _, a := range strings.Split(allow, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			allowed.Add(strings.ToLower(a))
+		}
+	}
+	return &oidcLogin{
+		provider: provider,
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+		clientID: clientID,
+		secret:   clientSecret,
+		claim:    claim,
+		allow:    allowed,
+	}, nil
+}
+
+// config is the OAuth2 configuration for a login through the server at base (see server.baseURL).
+func (o *oidcLogin) config(base *url.URL) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     o.clientID,
+		ClientSecret: o.secret,
+		Endpoint:     o.provider.Endpoint(),
+		RedirectURL:  base.JoinPath("login", "callback").String(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+}
+
+// allowed tells whether the principal name may log in.
+func (o *oidcLogin) allowed(name string) bool {
+	if len(o.allow) == 0 {
+		return true
+	}
+	name = strings.ToLower(name)
+	if o.allow.Has(name) {
+		return true
+	}
+	if _, domain, ok := strings.Cut(name, "@"); ok {
+		return o.allow.Has("@" + domain)
+	}
+	return false
+}
+
+// principal returns the principal named by the token's claim.
+func (o *oidcLogin) principal(tok *oidc.IDToken) (string, error) {
+	var claims map[string]any
+	if err := tok.Claims(&claims); err != nil {
+		return "", errors.Wrap(err, "decoding claims")
+	}
+	name, _ := claims[o.claim].(string)
+	if name == "" {
+		return "", fmt.Errorf("no %s claim in ID token for %s", o.claim, tok.Subject)
+	}
+	if o.claim == "email" {
+		if verified, ok := claims["email_verified"].(bool); ok && !verified {
+			return "", fmt.Errorf("unverified email address %s", name)
+		}
+	}
+	return name, nil
+}
+
+// oidcState is what the server remembers, in a cookie, between sending a browser to the provider and its return.
+type oidcState struct {
+	state, nonce, verifier, ret string
+}
+
+func (st oidcState) encode() string {
+	v := url.Values{"s": {st.state}, "n": {st.nonce}, "v": {st.verifier}, "r": {st.ret}}
+	return base64.RawURLEncoding.EncodeToString([]byte(v.Encode()))
+}
+
+func decodeOIDCState(s string) (oidcState, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return oidcState{}, errors.Wrap(err, "decoding login state")
+	}
+	v, err := url.ParseQuery(string(b))
+	if err != nil {
+		return oidcState{}, errors.Wrap(err, "parsing login state")
+	}
+	return oidcState{state: v.Get("s"), nonce: v.Get("n"), verifier: v.Get("v"), ret: v.Get("r")}, nil
+}
+
+// handleOIDCLogin serves /login/oidc,
+// sending the browser to the provider.
+func (s *server) handleOIDCLogin(w http.ResponseWriter, req *http.Request) error {
+	st := oidcState{
+		state:    oauth2.GenerateVerifier(),
+		nonce:    oauth2.GenerateVerifier(),
+		verifier: oauth2.GenerateVerifier(),
+		ret:      localReturn(req, "/gallery/"),
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    st.encode(),
+		Path:     "/login/",
+		MaxAge:   int(oidcStateTTL / time.Second),
+		Secure:   s.requestScheme(req) == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode, // sent on the provider's redirect back
+	})
+	u := s.oidc.config(s.baseURL(req)).AuthCodeURL(st.state, oidc.Nonce(st.nonce), oauth2.S256ChallengeOption(st.verifier))
+	http.Redirect(w, req, u, http.StatusFound)
+	return nil
+}
+
+// handleOIDCCallback serves /login/callback,
+// where the provider sends the browser back,
+// and logs it in.
+func (s *server) handleOIDCCallback(w http.ResponseWriter, req *http.Request) error {
+	cookie, err := req.Cookie(oidcCookie)
+	if err != nil {
+		return mid.CodeErr{
+			C:   http.StatusBadRequest,
+			Err: fmt.Errorf("no login in progress (or it took too long)"),
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/login/", MaxAge: -1})
+
+	st, err := decodeOIDCState(cookie.Value)
+	if err != nil {
+		return mid.CodeErr{C: http.StatusBadRequest, Err: err}
+	}
+
+	q := req.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return mid.CodeErr{
+			C:   http.StatusUnauthorized,
+			Err: fmt.Errorf("login failed: %s %s", e, q.Get("error_description")),
+		}
+	}
+	if st.state == "" || q.Get("state") != st.state {
+		return mid.CodeErr{
+			C:   http.StatusBadRequest,
+			Err: fmt.Errorf("login state mismatch"),
+		}
+	}
+
+	ctx := req.Context()
+	tok, err := s.oidc.config(s.baseURL(req)).Exchange(ctx, q.Get("code"), oauth2.VerifierOption(st.verifier))
+	if err != nil {
+		return mid.CodeErr{
+			C:   http.StatusUnauthorized,
+			Err: errors.Wrap(err, "exchanging login code"),
+		}
+	}
+	rawIDToken, _ := tok.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return mid.CodeErr{
+			C:   http.StatusUnauthorized,
+			Err: fmt.Errorf("no ID token from provider"),
+		}
+	}
+	idToken, err := s.oidc.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return mid.CodeErr{
+			C:   http.StatusUnauthorized,
+			Err: errors.Wrap(err, "verifying ID token"),
+		}
+	}
+	if idToken.Nonce != st.nonce {
+		return mid.CodeErr{
+			C:   http.StatusUnauthorized,
+			Err: fmt.Errorf("ID token nonce mismatch"),
+		}
+	}
+
+	name, err := s.oidc.principal(idToken)
+	if err != nil {
+		return mid.CodeErr{C: http.StatusUnauthorized, Err: err}
+	}
+	if !s.oidc.allowed(name) {
+		log.Printf("Unauthorized access attempt from %s (single sign-on as %s)", req.RemoteAddr, name)
+		return mid.CodeErr{
+			C:   http.StatusForbidden,
+			Err: fmt.Errorf("%s may not use this server", name),
+		}
+	}
+
+	if err := s.startSession(w, req, name); err != nil {
+		return err
+	}
+	http.Redirect(w, req, st.ret, http.StatusSeeOther)
+	return nil
+}
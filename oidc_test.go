@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+// fakeProvider is a minimal OpenID Connect provider for tests.
+type fakeProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	nonce string
+	email string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		mid.RespondJSON(w, map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, req *http.Request) {
+		mid.RespondJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		if req.FormValue("code") != "good-code" || req.FormValue("code_verifier") == "" {
+			http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		mid.RespondJSON(w, map[string]any{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"id_token":     p.idToken(t),
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeProvider) idToken(t *testing.T) string {
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	now := time.Now()
+	signed := enc(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"}) + "." + enc(map[string]any{
+		"iss":            p.URL,
+		"aud":            "kodigcs",
+		"sub":            "12345",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          p.nonce,
+		"email":          p.email,
+		"email_verified": true,
+	})
+	h := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDC(t *testing.T) {
+	p := newFakeProvider(t)
+	o, err := newOIDCLogin(context.Background(), p.URL, "kodigcs", "shh", "", "bob@example.com, @family.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{
+		grants: &grantStore{grants: map[string]*grant{}, known: set.New[string](), deleted: set.New[string]()},
+		login:  true,
+		oidc:   o,
+	}
+	s.auth = anyAuth{sessionAuth{grants: s.grants}}
+
+	// Log in as email, returning the session cookie, or the status if the login fails.
+	login := func(email, code string) (*http.Cookie, int) {
+		req := httptest.NewRequest("GET", "/login/oidc?return=%2Fsearch", nil)
+		rec := httptest.NewRecorder()
+		mid.Err(s.handleOIDCLogin).ServeHTTP(rec, req)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("got status %d starting login", rec.Code)
+		}
+		authURL, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := authURL.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("redirect_uri") != "http://example.com/login/callback" {
+			t.Errorf("got authorization URL %s", authURL)
+		}
+		p.nonce, p.email = q.Get("nonce"), email
+
+		req = httptest.NewRequest("GET", "/login/callback?"+url.Values{"state": {q.Get("state")}, "code": {code}}.Encode(), nil)
+		for _, c := range rec.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		rec = httptest.NewRecorder()
+		mid.Err(s.handleOIDCCallback).ServeHTTP(rec, req)
+		if rec.Code != http.StatusSeeOther {
+			return nil, rec.Code
+		}
+		if loc := rec.Header().Get("Location"); loc != "/search" {
+			t.Errorf("got location %q after login", loc)
+		}
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == loginCookie {
+				return c, rec.Code
+			}
+		}
+		t.Fatal("no session cookie")
+		return nil, 0
+	}
+
+	for _, email := range []string{"bob@example.com", "carol@family.example"} {
+		cookie, code := login(email, "good-code")
+		if cookie == nil {
+			t.Errorf("got status %d logging in as %s", code, email)
+			continue
+		}
+		req := httptest.NewRequest("GET", "/gallery/", nil)
+		req.AddCookie(cookie)
+		if name, err := s.auth.authenticate(httptest.NewRecorder(), req); err != nil || name != email {
+			t.Errorf("with the session cookie for %s, got %q, %v", email, name, err)
+		}
+	}
+
+	if _, code := login("mallory@example.com", "good-code"); code != http.StatusForbidden {
+		t.Errorf("got status %d for a user not allowed", code)
+	}
+	if _, code := login("bob@example.com", "bad-code"); code != http.StatusUnauthorized {
+		t.Errorf("got status %d for a bad code", code)
+	}
+
+	// A callback without the state cookie is refused.
+	req := httptest.NewRequest("GET", "/login/callback?state=x&code=good-code", nil)
+	rec := httptest.NewRecorder()
+	mid.Err(s.handleOIDCCallback).ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for a callback without a login in progress", rec.Code)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobg/errors"
+	"golang.org/x/time/rate"
+)
+
+// omdbScraper is the Scraper for the OMDb API (omdbapi.com),
+// which requires an API key.
+// Its IDs are IMDb's.
+// It is the source of Rotten Tomatoes and Metacritic scores.
+type omdbScraper struct {
+	cl  *http.Client
+	key string
+}
+
+func init() {
+	registerScraper("omdb", func(conf scraperConfig) (Scraper, error) {
+		if conf.omdbKey == "" {
+			return nil, fmt.Errorf("the omdb scraper needs an API key (-omdb-key)")
+		}
+		transport := conf.transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		cl := &http.Client{
+			Transport: &limitedTransport{
+				limiter:   rate.NewLimiter(rate.Every(100*time.Millisecond), 1),
+				transport: transport,
+			},
+		}
+		return &omdbScraper{cl: cl, key: conf.omdbKey}, nil
+	})
+}
+
+func (*omdbScraper) Name() string { return "omdb" }
+
+type omdbTitle struct {
+	Title    string `json:"Title"`
+	Year     string `json:"Year"`
+	Rated    string `json:"Rated"`
+	Released string `json:"Released"` // e.g. "29 Jun 1934"
+	Runtime  string `json:"Runtime"`  // e.g. "91 min"
+	Genre    string `json:"Genre"`
+	Director string `json:"Director"`
+	Actors   string `json:"Actors"`
+	Plot     string `json:"Plot"`
+	Language string `json:"Language"`
+	Country  string `json:"Country"`
+	Poster   string `json:"Poster"`
+	Ratings  []struct {
+		Source string `json:"Source"`
+		Value  string `json:"Value"`
+	} `json:"Ratings"`
+	IMDbID     string `json:"imdbID"`
+	Production string `json:"Production"`
+	Awards     string `json:"Awards"`
+	Response   string `json:"Response"`
+	Error      string `json:"Error"`
+}
+
+func (s *omdbScraper) get(ctx context.Context, params url.Values, result any) error {
+	params.Set("apikey", s.key)
+	u := "https://www.omdbapi.com/?" + params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return errors.Wrap(err, "building OMDb request")
+	}
+	resp, err := s.cl.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "querying OMDb")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d (%s) from OMDb", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(result), "decoding OMDb response")
+}
+
+func (s *omdbScraper) LookupByID(ctx context.Context, id string) (*titleInfo, error) {
+	id = parseIMDbID(id)
+	if !imdbIDRE.MatchString(id) {
+		return nil, errNotFound
+	}
+
+	var t omdbTitle
+	if err := s.get(ctx, url.Values{"i": {id}, "plot": {"full"}}, &t); err != nil {
+		return nil, err
+	}
+	if t.Response != "True" {
+		if strings.Contains(t.Error, "not found") {
+			return nil, errNotFound
+		}
+		return nil, fmt.Errorf("OMDb error: %s", t.Error)
+	}
+
+	return t.titleInfo(), nil
+}
+
+var omdbRuntimeRE = regexp.MustCompile(`^(\d+) min`)
+
+func (t *omdbTitle) titleInfo() *titleInfo {
+	na := func(s string) string {
+		if s == "N/A" {
+			return ""
+		}
+		return s
+	}
+	list := func(s string) []string {
+		if s = na(s); s == "" {
+			return nil
+		}
+		var result []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+		return result
+	}
+
+	info := &titleInfo{
+		ID:            t.IMDbID,
+		Name:          na(t.Title),
+		Image:         na(t.Poster),
+		ContentRating: na(t.Rated),
+		Genres:        list(t.Genre),
+		Actors:        list(t.Actors),
+		Directors:     list(t.Director),
+		Countries:     list(t.Country),
+		Languages:     list(t.Language),
+		Studios:       list(t.Production),
+		Summary:       na(t.Plot),
+		Awards:        na(t.Awards),
+	}
+	if released, err := time.Parse("02 Jan 2006", t.Released); err == nil {
+		info.DatePublished = released.Format(time.DateOnly)
+	}
+	if m := omdbRuntimeRE.FindStringSubmatch(t.Runtime); m != nil {
+		info.RuntimeMins, _ = strconv.Atoi(m[1])
+	}
+
+	for _, r := range t.Ratings {
+		var (
+			name string
+			val  float64
+			err  error
+		)
+		switch r.Source {
+		case "Internet Movie Database":
+			name = ratingIMDb
+			val, err = strconv.ParseFloat(strings.TrimSuffix(r.Value, "/10"), 64)
+		case "Rotten Tomatoes":
+			name = ratingRottenTomatoes
+			val, err = strconv.ParseFloat(strings.TrimSuffix(r.Value, "%"), 64)
+		case "Metacritic":
+			name = ratingMetacritic
+			val, err = strconv.ParseFloat(strings.TrimSuffix(r.Value, "/100"), 64)
+		default:
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if info.Ratings == nil {
+			info.Ratings = make(map[string]float64)
+		}
+		info.Ratings[name] = val
+	}
+
+	return info
+}
+
+func (s *omdbScraper) SearchByTitle(ctx context.Context, title string, year int) ([]searchResult, error) {
+	params := url.Values{"s": {title}, "type": {"movie"}}
+	if year > 0 {
+		params.Set("y", strconv.Itoa(year))
+	}
+
+	var resp struct {
+		Search []struct {
+			Title  string `json:"Title"`
+			Year   string `json:"Year"`
+			IMDbID string `json:"imdbID"`
+		} `json:"Search"`
+		Response string `json:"Response"`
+		Error    string `json:"Error"`
+	}
+	if err := s.get(ctx, params, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Response != "True" {
+		if strings.Contains(resp.Error, "not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("OMDb error: %s", resp.Error)
+	}
+
+	var results []searchResult
+	for _, item := range resp.Search {
+		r := searchResult{ID: item.IMDbID, Title: item.Title}
+		if len(item.Year) >= 4 {
+			r.Year, _ = strconv.Atoi(item.Year[:4])
+		}
+		results = append(results, r)
+	}
+	return filterByYear(results, year), nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// hostTransport sends every request to the server at its URL instead.
+type hostTransport struct {
+	url *url.URL
+}
+
+func (t hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.url.Scheme, t.url.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOMDbLookupByID(t *testing.T) {
+	// Canned OMDb responses, keyed by IMDb ID.
+	responses := map[string]string{
+		"tt0025878": `{
+			"Title": "The Thin Man", "Year": "1934", "Rated": "Passed", "Released": "29 Jun 1934",
+			"Runtime": "91 min", "Genre": "Comedy, Crime, Mystery", "Director": "W.S. Van Dyke",
+			"Actors": "William Powell, Myrna Loy, Maureen O'Sullivan",
+			"Plot": "Nick and Nora Charles investigate a murder.", "Language": "English",
+			"Country": "United States", "Awards": "Nominated for 4 Oscars. 3 wins & 6 nominations total",
+			"Poster": "https://m.media-amazon.com/images/M/thinman.jpg",
+			"Ratings": [
+				{"Source": "Internet Movie Database", "Value": "7.9/10"},
+				{"Source": "Rotten Tomatoes", "Value": "98%"},
+				{"Source": "Metacritic", "Value": "86/100"},
+				{"Source": "Somewhere Else", "Value": "5 stars"}
+			],
+			"imdbID": "tt0025878", "Production": "N/A", "Response": "True"
+		}`,
+		"tt0000001": `{
+			"Title": "Carmencita", "Year": "1894", "Rated": "N/A", "Released": "N/A",
+			"Runtime": "N/A", "Genre": "N/A", "Director": "N/A", "Actors": "N/A", "Plot": "N/A",
+			"Language": "N/A", "Country": "N/A", "Awards": "N/A", "Poster": "N/A",
+			"Ratings": [{"Source": "Rotten Tomatoes", "Value": "N/A"}],
+			"imdbID": "tt0000001", "Production": "N/A", "Response": "True"
+		}`,
+		"tt0000002": `{
+			"Title": "Le clown et ses chiens", "Released": "01 Oct 1892", "Runtime": "5 min (director's cut)",
+			"Genre": "Animation,,Short", "imdbID": "tt0000002", "Response": "True"
+		}`,
+		"tt9999999": `{"Response": "False", "Error": "Movie not found!"}`,
+		"tt8888888": `{"Response": "False", "Error": "Request limit reached!"}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if q.Get("apikey") != "key" {
+			http.Error(w, `{"Response": "False", "Error": "Invalid API key!"}`, http.StatusUnauthorized)
+			return
+		}
+		resp, ok := responses[q.Get("i")]
+		if !ok {
+			http.Error(w, "unexpected ID", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(resp))
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	s, err := newScrapers("omdb", scraperConfig{omdbKey: "key", transport: hostTransport{url: u}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name         string
+		id           string
+		want         *titleInfo
+		wantNotFound bool
+		wantErr      bool
+	}{{
+		name: "full",
+		id:   "tt0025878",
+		want: &titleInfo{
+			ID:            "tt0025878",
+			Name:          "The Thin Man",
+			Image:         "https://m.media-amazon.com/images/M/thinman.jpg",
+			DatePublished: "1934-06-29",
+			ContentRating: "Passed",
+			Genres:        []string{"Comedy", "Crime", "Mystery"},
+			Actors:        []string{"William Powell", "Myrna Loy", "Maureen O'Sullivan"},
+			Directors:     []string{"W.S. Van Dyke"},
+			Countries:     []string{"United States"},
+			Languages:     []string{"English"},
+			RuntimeMins:   91,
+			Summary:       "Nick and Nora Charles investigate a murder.",
+			Awards:        "Nominated for 4 Oscars. 3 wins & 6 nominations total",
+			Ratings: map[string]float64{
+				ratingIMDb:           7.9,
+				ratingRottenTomatoes: 98,
+				ratingMetacritic:     86,
+			},
+		},
+	}, {
+		name: "all N/A",
+		id:   "tt0000001",
+		want: &titleInfo{ID: "tt0000001", Name: "Carmencita"},
+	}, {
+		name: "odd formats",
+		id:   "https://www.imdb.com/title/tt0000002/",
+		want: &titleInfo{
+			ID:            "tt0000002",
+			Name:          "Le clown et ses chiens",
+			DatePublished: "1892-10-01",
+			Genres:        []string{"Animation", "Short"},
+			RuntimeMins:   5,
+		},
+	}, {
+		name:         "not found",
+		id:           "tt9999999",
+		wantNotFound: true,
+	}, {
+		name:         "not an IMDb ID",
+		id:           "the-thin-man",
+		wantNotFound: true,
+	}, {
+		name:    "OMDb error",
+		id:      "tt8888888",
+		wantErr: true,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := s.LookupByID(context.Background(), c.id)
+			switch {
+			case c.wantNotFound:
+				if err != errNotFound {
+					t.Errorf("got error %v, want errNotFound", err)
+				}
+			case c.wantErr:
+				if err == nil || err == errNotFound {
+					t.Errorf("got error %v, want an OMDb error", err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case !reflect.DeepEqual(got, c.want):
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
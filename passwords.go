@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bobg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Passwords may be stored as hashes,
+// either bcrypt ($2a$..., $2b$..., or $2y$..., as from "htpasswd -nB")
+// or Argon2id in the PHC string format
+// ($argon2id$v=19$m=MEMORY,t=TIME,p=THREADS$SALT$HASH, as from "argon2 SALT -id -e").
+
+// The environment variable that may hold the -password of serve.
+const passwordEnvVar = "KODIGCS_PASSWORD"
+
+// isPasswordHash tells whether s looks like a password hash rather than a password.
+func isPasswordHash(s string) bool {
+	return strings.HasPrefix(s, "$2") || strings.HasPrefix(s, "$argon2id$")
+}
+
+// checkPasswordHashFormat returns an error if hash is not a well-formed password hash.
+func checkPasswordHashFormat(hash string) error {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		_, _, _, err := parseArgon2id(hash)
+		return err
+	}
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return errors.Wrap(err, "not a bcrypt or Argon2id hash")
+	}
+	return nil
+}
+
+// passwordMatches tells whether password is the one hashed in hash.
+func passwordMatches(hash, password string) bool {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+type argon2Params struct {
+	memory  uint32 // in KiB
+	time    uint32
+	threads uint8
+}
+
+// parseArgon2id parses an Argon2id hash in the PHC string format.
+func parseArgon2id(hash string) (params argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("malformed Argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported Argon2id version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, errors.Wrapf(err, "parsing Argon2id parameters %q", parts[3])
+	}
+	if params.time == 0 || params.threads == 0 {
+		return params, nil, nil, fmt.Errorf("bad Argon2id parameters %q", parts[3])
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, errors.Wrap(err, "decoding Argon2id salt")
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, errors.Wrap(err, "decoding Argon2id hash")
+	}
+	if len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("empty Argon2id hash")
+	}
+	return params, salt, key, nil
+}
+
+// servePassword determines the password (or password hash) for serve,
+// from the -password flag,
+// the file named by -password-file,
+// or the environment variable $KODIGCS_PASSWORD,
+// the latter two keeping it out of the process's command line
+// (which is visible to other users of the host, and in /debug/vars).
+func servePassword(flag, file string) (string, error) {
+	if file != "" {
+		if flag != "" {
+			return "", fmt.Errorf("cannot use both -password and -password-file")
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", errors.Wrapf(err, "reading password file %s", file)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+	if flag != "" {
+		return flag, nil
+	}
+	return os.Getenv(passwordEnvVar), nil
+}
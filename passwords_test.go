@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashes(t *testing.T) {
+	bhash, err := bcrypt.GenerateFromPassword([]byte("asta"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := []byte("saltsaltsalt")
+	key := argon2.IDKey([]byte("asta"), salt, 1, 64, 1, 32)
+	ahash := fmt.Sprintf("$argon2id$v=19$m=64,t=1,p=1$%s$%s", base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	for _, hash := range []string{string(bhash), ahash} {
+		if !isPasswordHash(hash) {
+			t.Errorf("%s is not recognized as a hash", hash)
+		}
+		if err := checkPasswordHashFormat(hash); err != nil {
+			t.Errorf("%s: %s", hash, err)
+		}
+		if !passwordMatches(hash, "asta") {
+			t.Errorf("%s does not match its password", hash)
+		}
+		if passwordMatches(hash, "martini") {
+			t.Errorf("%s matches the wrong password", hash)
+		}
+
+		auth := usersAuth{users: newSingleUser("nora", hash)}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth("nora", "asta")
+		if name, err := auth.authenticate(httptest.NewRecorder(), req); err != nil || name != "nora" {
+			t.Errorf("got %q, %v authenticating with %s", name, err, hash)
+		}
+	}
+
+	for _, bad := range []string{"asta", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$c2FsdA", "$2a$10$short"} {
+		if err := checkPasswordHashFormat(bad); err == nil {
+			t.Errorf("accepted malformed hash %q", bad)
+		}
+	}
+}
+
+func TestServePassword(t *testing.T) {
+	t.Setenv(passwordEnvVar, "from env")
+
+	if got, err := servePassword("from flag", ""); err != nil || got != "from flag" {
+		t.Errorf("got %q, %v with -password", got, err)
+	}
+	if got, err := servePassword("", ""); err != nil || got != "from env" {
+		t.Errorf("got %q, %v from $%s", got, err, passwordEnvVar)
+	}
+
+	file := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(file, []byte("from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := servePassword("", file); err != nil || got != "from file" {
+		t.Errorf("got %q, %v with -password-file", got, err)
+	}
+	if _, err := servePassword("from flag", file); err == nil {
+		t.Error("accepted both -password and -password-file")
+	}
+}
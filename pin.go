@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// With serve -pin,
+// titles flagged in the metadata's adult column
+// are left out of listings, searches, and playlists,
+// and are not served,
+// unless the request gives the PIN.
+// A browser gives it once, at /pin,
+// and gets a session cookie that unlocks those titles for pinSessionTTL;
+// other clients send it with each request in the pinHeader header.
+// This is enforced by the server,
+// so unlike a Kodi profile lock it can't be sidestepped by switching profiles
+// or by pointing another client at the server.
+
+const (
+	pinHeader     = "X-Kodigcs-PIN"
+	pinCookie     = "kodigcs_pin"
+	pinSessionTTL = 4 * time.Hour
+
+	// The grant subject of a PIN session.
+	pinSubject = "pin"
+
+	// How many wrong PINs from one principal within authFailureWindow
+	// before no more are accepted from it for lockoutDuration.
+	// This is separate from the per-client limit on failed logins (see throttle.go)
+	// because the requests giving a PIN already carry good credentials.
+	maxPINFailures = 5
+)
+
+type pinKeyType struct{}
+
+var pinKey pinKeyType
+
+// pinUnlocked tells whether the request whose context this is gave the PIN.
+func pinUnlocked(ctx context.Context) bool {
+	ok, _ := ctx.Value(pinKey).(bool)
+	return ok
+}
+
+// pinGuard limits guesses at the PIN,
+// separately for each principal
+// (or, for requests without one, each client address),
+// so that no one can lock everyone else out.
+type pinGuard struct {
+	mu       sync.Mutex
+	guessers map[string]*pinGuesser // keyed by pinGuardKey
+}
+
+type pinGuesser struct {
+	failures    []time.Time // within authFailureWindow
+	lockedUntil time.Time
+}
+
+// pinGuardKey is the key in pinGuard of a request from the principal who.
+func pinGuardKey(req *http.Request, who string) string {
+	if who != "" {
+		return "principal:" + who
+	}
+	if addr, ok := remoteAddr(req); ok {
+		return "addr:" + addr.String()
+	}
+	return "addr:" + req.RemoteAddr
+}
+
+// check returns an error if no PIN may be tried now by the guesser with the given key.
+func (g *pinGuard) check(key string, now time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if gr, ok := g.guessers[key]; ok && now.Before(gr.lockedUntil) {
+		return mid.CodeErr{
+			C:   http.StatusTooManyRequests,
+			Err: fmt.Errorf("too many wrong PINs, try again after %s", gr.lockedUntil.Format(time.Kitchen)),
+		}
+	}
+	return nil
+}
+
+// failed records a wrong PIN from the guesser with the given key,
+// returning true if that begins a lockout.
+// It also forgets guessers with no recent failures and no lockout.
+func (g *pinGuard) failed(key string, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.guessers == nil {
+		g.guessers = make(map[string]*pinGuesser)
+	}
+	for k, gr := range g.guessers {
+		gr.failures = slices.DeleteFunc(gr.failures, func(t time.Time) bool { return now.Sub(t) >= authFailureWindow })
+		if len(gr.failures) == 0 && !now.Before(gr.lockedUntil) {
+			delete(g.guessers, k)
+		}
+	}
+
+	gr, ok := g.guessers[key]
+	if !ok {
+		gr = &pinGuesser{}
+		g.guessers[key] = gr
+	}
+	gr.failures = append(gr.failures, now)
+	if len(gr.failures) < maxPINFailures {
+		return false
+	}
+	gr.failures = nil
+	gr.lockedUntil = now.Add(lockoutDuration)
+	return true
+}
+
+// tryPIN compares a PIN given in a request by the principal who with the server's,
+// returning an error if it is wrong or if guessing is locked out.
+func (s *server) tryPIN(req *http.Request, who, pin string) error {
+	var (
+		now = time.Now()
+		key = pinGuardKey(req, who)
+	)
+	if err := s.pinGuard.check(key, now); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(pin), []byte(s.pin)) == 1 {
+		return nil
+	}
+	if s.pinGuard.failed(key, now) {
+		log.Printf("Refusing PINs from %s for %s after %d wrong ones", key, lockoutDuration, maxPINFailures)
+	}
+	return mid.CodeErr{
+		C:   http.StatusForbidden,
+		Err: fmt.Errorf("wrong PIN from %s", who),
+	}
+}
+
+// checkPIN tells whether the request from the principal who unlocks adult titles,
+// either with the pinHeader header
+// (which must be right if it is present)
+// or with the cookie of a PIN session belonging to who.
+func (s *server) checkPIN(req *http.Request, who string) (bool, error) {
+	if s.pin == "" {
+		return true, nil
+	}
+	if pin := req.Header.Get(pinHeader); pin != "" {
+		if err := s.tryPIN(req, who, pin); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	cookie, err := req.Cookie(pinCookie)
+	if err != nil {
+		return false, nil
+	}
+	g, ok := s.grants.lookup(grantSession, cookie.Value)
+	return ok && g.Subject == pinSubject && g.Name == who, nil
+}
+
+// handlePIN serves /pin,
+// a form for giving the PIN (or locking adult titles again),
+// and handles its submission.
+func (s *server) handlePIN(w http.ResponseWriter, req *http.Request) error {
+	if s.pin == "" {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no PIN configured"),
+		}
+	}
+
+	ret := localReturn(req, "/gallery/")
+
+	if req.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		return pinTemplate.Execute(w, pinPage{Unlocked: pinUnlocked(req.Context()), Return: ret})
+	}
+
+	if err := checkSameOrigin(req); err != nil {
+		return err
+	}
+
+	if req.FormValue("action") == "lock" {
+		if cookie, err := req.Cookie(pinCookie); err == nil {
+			if g, ok := s.grants.lookup(grantSession, cookie.Value); ok && g.Subject == pinSubject {
+				s.grants.revoke(g.ID)
+			}
+		}
+		http.SetCookie(w, &http.Cookie{Name: pinCookie, Path: "/", MaxAge: -1})
+		http.Redirect(w, req, ret, http.StatusSeeOther)
+		return nil
+	}
+
+	who := principal(req.Context())
+	if err := s.tryPIN(req, who, req.FormValue("pin")); err != nil {
+		return err
+	}
+
+	secret, g, err := s.grants.issue(grantSession, who, pinSubject, pinSessionTTL)
+	if err != nil {
+		return errors.Wrap(err, "issuing PIN session")
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     pinCookie,
+		Value:    secret,
+		Path:     "/",
+		Expires:  g.Expires,
+		Secure:   s.requestScheme(req) == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, req, ret, http.StatusSeeOther)
+	return nil
+}
+
+type pinPage struct {
+	Unlocked bool
+	Return   string
+}
+
+var pinTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+ <head>
+  <title>PIN</title>
+ </head>
+ <body>
+  <form method="POST" action="/pin">
+   <input type="hidden" name="return" value="{{ .Return }}">
+   {{ if .Unlocked }}
+    <p>Adult titles are unlocked.</p>
+    <button type="submit" name="action" value="lock">Lock</button>
+   {{ else }}
+    <label>PIN <input type="password" name="pin" inputmode="numeric" autocomplete="off" autofocus></label>
+    <button type="submit">Unlock</button>
+   {{ end }}
+  </form>
+ </body>
+</html>
+`))
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+func TestPIN(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		objNames:     set.New("Top Hat.mp4", "Late Show.mp4"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"Top Hat":   {Title: "Top Hat"},
+			"Late Show": {Title: "Late Show", adult: true},
+		},
+		infoMapTime: now,
+		dirTemplate: template.Must(template.New("").Parse(dirTemplate)),
+		grants:      &grantStore{grants: map[string]*grant{}, known: set.New[string](), deleted: set.New[string]()},
+		pin:         "8642",
+	}
+
+	get := func(path string, prep func(*http.Request)) (int, string) {
+		req := httptest.NewRequest("GET", path, nil)
+		if prep != nil {
+			prep(req)
+		}
+		rec := httptest.NewRecorder()
+		mid.Err(s.authed(s.handle)).ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+	withHeader := func(pin string) func(*http.Request) {
+		return func(req *http.Request) { req.Header.Set(pinHeader, pin) }
+	}
+
+	nfo := "/" + url.PathEscape(rootNamePrefix("Late Show")+"Late Show.nfo")
+
+	code, body := get("/", nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d for the root", code)
+	}
+	if !strings.Contains(body, "Top Hat.mp4") || strings.Contains(body, "Late Show.mp4") {
+		t.Errorf("without the PIN, got root listing %s", body)
+	}
+	if code, _ := get(nfo, nil); code != http.StatusForbidden {
+		t.Errorf("without the PIN, got status %d for the NFO", code)
+	}
+
+	code, body = get("/", withHeader("8642"))
+	if code != http.StatusOK || !strings.Contains(body, "Late Show.mp4") {
+		t.Errorf("with the PIN header, got status %d and root listing %s", code, body)
+	}
+	if code, _ := get(nfo, withHeader("8642")); code != http.StatusOK {
+		t.Errorf("with the PIN header, got status %d for the NFO", code)
+	}
+	if code, _ := get("/", withHeader("1111")); code != http.StatusForbidden {
+		t.Errorf("with a wrong PIN header, got status %d", code)
+	}
+
+	// Unlock with the form and use the session cookie.
+	req := httptest.NewRequest("POST", "/pin", strings.NewReader(url.Values{"pin": {"8642"}, "return": {"/"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	mid.Err(s.authed(s.handlePIN)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/" {
+		t.Fatalf("got status %d and location %q for the PIN form", rec.Code, rec.Header().Get("Location"))
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == pinCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("got PIN cookie %v", cookie)
+	}
+	if code, _ := get(nfo, func(req *http.Request) { req.AddCookie(cookie) }); code != http.StatusOK {
+		t.Errorf("with the PIN cookie, got status %d for the NFO", code)
+	}
+	if code, _ := get(nfo, func(req *http.Request) { req.AddCookie(&http.Cookie{Name: pinCookie, Value: "bogus"}) }); code != http.StatusForbidden {
+		t.Errorf("with a bogus PIN cookie, got status %d for the NFO", code)
+	}
+
+	// Too many wrong PINs lock out even the right one.
+	for i := 1; i < maxPINFailures; i++ {
+		get("/", withHeader("0000"))
+	}
+	if code, _ := get("/", withHeader("8642")); code != http.StatusTooManyRequests {
+		t.Errorf("after %d wrong PINs, got status %d", maxPINFailures, code)
+	}
+
+	// But not from another address.
+	code, _ = get("/", func(req *http.Request) {
+		req.RemoteAddr = "198.51.100.7:1234"
+		req.Header.Set(pinHeader, "8642")
+	})
+	if code != http.StatusOK {
+		t.Errorf("after another client's wrong PINs, got status %d", code)
+	}
+}
+
+func TestPINGuard(t *testing.T) {
+	var (
+		g   pinGuard
+		now = time.Now()
+	)
+
+	for i := 0; i < maxPINFailures-1; i++ {
+		if g.failed("principal:nick", now) {
+			t.Fatalf("locked out after %d wrong PINs", i+1)
+		}
+	}
+	if !g.failed("principal:nick", now) {
+		t.Fatalf("not locked out after %d wrong PINs", maxPINFailures)
+	}
+
+	cases := []struct {
+		name string
+		key  string
+		at   time.Duration // after now
+		want int           // status, or 0 for no error
+	}{
+		{name: "locked out", key: "principal:nick", want: http.StatusTooManyRequests},
+		{name: "another principal", key: "principal:nora"},
+		{name: "same name as an address", key: "addr:nick"},
+		{name: "after the lockout", key: "principal:nick", at: lockoutDuration + time.Second},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := g.check(c.key, now.Add(c.at))
+			if got := errorCode(err); err != nil && got != c.want || err == nil && c.want != 0 {
+				t.Errorf("got %v, want status %d", err, c.want)
+			}
+		})
+	}
+
+	// Once their failures and lockouts are over, guessers are forgotten.
+	g.failed("principal:nora", now.Add(lockoutDuration+authFailureWindow))
+	if len(g.guessers) != 1 {
+		t.Errorf("got %d guessers, want 1", len(g.guessers))
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+// The extensions of media objects that web browsers can play.
+// Whether a browser can play an .mkv file depends on its codecs
+// (H.264 or VP9 video with AAC or Opus audio usually works).
+var browserExts = set.New(".m4v", ".mkv", ".mp4", ".webm")
+
+// watchPath is the path of the player page for the title with the given root name.
+func watchPath(rootName string) string {
+	return (&url.URL{Path: "/watch/" + rootName}).EscapedPath()
+}
+
+// playerPart is a media object of a title on its player page.
+type playerPart struct {
+	Src, Label string
+}
+
+// playerTrack is a WebVTT subtitle file for a title on its player page.
+type playerTrack struct {
+	Src, Lang, Label string
+}
+
+// handleWatch serves the player page of a title:
+// its poster and plot,
+// and (if it's in a format browsers can play)
+// a <video> element streaming it from the server,
+// starting from the resume point of the requesting user, if there is one.
+// With -transcode, a title in another format is streamed through ffmpeg (see handleTranscode).
+// A multi-part title plays its parts in turn.
+func (s *server) handleWatch(w http.ResponseWriter, req *http.Request) error {
+	var (
+		ctx      = req.Context()
+		rootName = req.PathValue("rootname")
+	)
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	info, ok := s.infoMap[rootName]
+	objName, hasObj := s.mediaObjName(rootName)
+	if !hasObj || info.hidden || s.isEpisode(objName) || s.isExtra(objName) {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no title %s", rootName),
+		}
+	}
+	if err := s.titleAccessErr(ctx, rootName); err != nil {
+		return err
+	}
+	if !ok {
+		info = movieInfo{Title: rootName}
+	}
+
+	var objNames []string
+	if parts := s.partsOf(info); len(parts) > 0 {
+		objNames = parts
+	} else if !s.isDiscObj(objName) {
+		objNames = []string{objName}
+	}
+
+	var (
+		parts    []playerPart
+		playable = len(objNames) > 0
+	)
+	for i, objName := range objNames {
+		ext := filepath.Ext(objName)
+		if !browserExts.Has(strings.ToLower(ext)) {
+			playable = false
+		}
+		part := playerPart{
+			Src:   withShare(req, "/"+url.PathEscape(rootNamePrefix(strings.TrimSuffix(objName, ext))+objName)),
+			Label: "Download",
+		}
+		if len(objNames) > 1 {
+			part.Label = fmt.Sprintf("Part %d", i+1)
+		}
+		parts = append(parts, part)
+	}
+
+	// With -transcode, ffmpeg can convert a single object that browsers can't play.
+	// (But not for a share link, which does not cover the HLS stream.)
+	transcoding := !playable && s.transcoder != nil && len(objNames) == 1 && !req.URL.Query().Has("share")
+	if transcoding {
+		parts[0].Src = (&url.URL{Path: "/transcode/" + rootName}).EscapedPath()
+		playable = true
+	}
+
+	var tracks []playerTrack
+	for _, sub := range s.indexTitleFiles().subtitles[rootName] {
+		if !strings.EqualFold(filepath.Ext(sub), ".vtt") {
+			continue
+		}
+		tags := strings.TrimPrefix(strings.TrimSuffix(sub, filepath.Ext(sub)), rootName)
+		tags = strings.TrimPrefix(tags, ".")
+		lang, _, _ := strings.Cut(tags, ".")
+		label := tags
+		if label == "" {
+			label = "Subtitles"
+		}
+		tracks = append(tracks, playerTrack{
+			Src:   withShare(req, "/"+url.PathEscape(rootNamePrefix(rootName)+sub)),
+			Lang:  lang,
+			Label: label,
+		})
+	}
+
+	var start float64
+	if s.watched != nil && !transcoding {
+		if st, ok := s.watched.get(principal(ctx), rootName); ok {
+			start = st.Resume
+		}
+	}
+
+	data := struct {
+		Title       string
+		Year        int
+		Tagline     string
+		Plot        string
+		Genres      []string
+		Poster      string
+		Parts       []playerPart
+		Tracks      []playerTrack
+		Playable    bool
+		Transcoding bool
+		Start       float64
+	}{
+		Title:       displayTitle(rootName, info),
+		Year:        info.Year,
+		Tagline:     info.Tagline,
+		Plot:        info.Plot,
+		Genres:      genresOf(info),
+		Poster:      withShare(req, s.galleryPoster(rootName, info)),
+		Parts:       parts,
+		Tracks:      tracks,
+		Playable:    playable,
+		Transcoding: transcoding,
+		Start:       start,
+	}
+	buf := new(bytes.Buffer)
+	if err := playerTemplate.Execute(buf, data); err != nil {
+		return errors.Wrap(err, "rendering player page")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return s.serveGenerated(w, req, "index.html", buf.Bytes())
+}
+
+var playerTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+ <head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{ .Title }}</title>
+  <style>
+   body { font-family: sans-serif; margin: 1em; background: #111; color: #eee; }
+   a { color: #9cf; }
+   video { width: 100%; max-height: 80vh; background: #000; }
+   .about { display: flex; gap: 1em; margin-top: 1em; }
+   .about img { width: 10em; align-self: flex-start; border-radius: 4px; }
+   .meta { color: #aaa; }
+  </style>
+ </head>
+ <body>
+  {{ if .Playable }}
+   <video id="player" controls autoplay preload="metadata"{{ with .Poster }} poster="{{ . }}"{{ end }}
+    src="{{ (index .Parts 0).Src }}{{ with .Start }}#t={{ . }}{{ end }}">
+    {{ range .Tracks }}
+     <track kind="subtitles" src="{{ .Src }}"{{ with .Lang }} srclang="{{ . }}"{{ end }} label="{{ .Label }}">
+    {{ end }}
+   </video>
+   {{ if .Transcoding }}<p class="meta">This title is being converted for your browser, which may take a moment to start.</p>{{ end }}
+   {{ if gt (len .Parts) 1 }}
+    <script>
+     const parts = [{{ range .Parts }}{{ .Src }},{{ end }}];
+     const player = document.getElementById("player");
+     let part = 0;
+     player.addEventListener("ended", () => {
+       if (++part < parts.length) {
+         player.src = parts[part];
+         player.play();
+       }
+     });
+    </script>
+   {{ end }}
+  {{ else }}
+   <p>This title can’t be played in a web browser.
+   {{ range .Parts }}<a href="{{ .Src }}">{{ .Label }}</a> {{ end }}</p>
+  {{ end }}
+  <div class="about">
+   {{ with .Poster }}<img src="{{ . }}" alt="">{{ end }}
+   <div>
+    <h1>{{ .Title }}{{ with .Year }} ({{ . }}){{ end }}</h1>
+    {{ with .Genres }}<p class="meta">{{ range $i, $g := . }}{{ if $i }}, {{ end }}{{ $g }}{{ end }}</p>{{ end }}
+    {{ with .Tagline }}<p><i>{{ . }}</i></p>{{ end }}
+    {{ with .Plot }}<p>{{ . }}</p>{{ end }}
+    <p><a href="/gallery/">Library</a></p>
+   </div>
+  </div>
+ </body>
+</html>
+`))
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestWatch(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		objNames: set.New(
+			"The Thin Man.mp4",
+			"The Thin Man.en.vtt",
+			"The Thin Man.srt",
+			"Metropolis.iso",
+			"Napoleon/part1.m4v",
+			"Napoleon/part2.m4v",
+			"Secret.mp4",
+		),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"The Thin Man": {Title: "The Thin Man", Year: 1934, Plot: "Nick and Nora investigate."},
+			"Napoleon":     {Title: "Napoléon", parts: "Napoleon/part*.m4v"},
+			"Secret":       {hidden: true},
+		},
+		infoMapTime: now,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /watch/{rootname...}", func(w http.ResponseWriter, req *http.Request) {
+		if err := s.handleWatch(w, req); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+	})
+	watch := func(rootName string) (int, string) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", watchPath(rootName), nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	code, body := watch("The Thin Man")
+	if code != http.StatusOK {
+		t.Fatalf("got status %d for The Thin Man", code)
+	}
+	for _, want := range []string{
+		`<video id="player"`,
+		`src="/` + rootNamePrefix("The Thin Man") + `The%20Thin%20Man.mp4"`,
+		`<track kind="subtitles" src="/` + rootNamePrefix("The Thin Man") + `The%20Thin%20Man.en.vtt" srclang="en" label="en">`,
+		`Nick and Nora investigate.`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("player page lacks %s", want)
+		}
+	}
+	if strings.Contains(body, ".srt") {
+		t.Error("player page offers .srt subtitles")
+	}
+
+	_, body = watch("Metropolis")
+	if strings.Contains(body, "<video") || !strings.Contains(body, "can’t be played") {
+		t.Error("player page offers to play an ISO image")
+	}
+
+	_, body = watch("Napoleon")
+	for _, want := range []string{
+		`src="/` + rootNamePrefix("Napoleon/part1") + `Napoleon%2Fpart1.m4v"`,
+		`"/` + rootNamePrefix("Napoleon/part2") + `Napoleon%2Fpart2.m4v"`,
+		`Napoléon`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("player page for a multi-part title lacks %s", want)
+		}
+	}
+
+	if code, _ := watch("Secret"); code != http.StatusNotFound {
+		t.Errorf("got status %d for a hidden title", code)
+	}
+	if code, _ := watch("Casablanca"); code != http.StatusNotFound {
+		t.Errorf("got status %d for a missing title", code)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bobg/errors"
+)
+
+// How long the stream URLs in a library playlist remain valid.
+const playlistTTL = 7 * 24 * time.Hour
+
+// handleLibraryPlaylist serves /playlist.m3u,
+// an M3U playlist of the whole library
+// (or of the titles in one subdir, with ?subdir=,
+// or in one genre, with ?genre=)
+// for players like VLC that know nothing of Kodi.
+//
+// Such players cannot authenticate,
+// so when the server requires it
+// the stream URLs carry the secret of a playlist grant.
+func (s *server) handleLibraryPlaylist(w http.ResponseWriter, req *http.Request) error {
+	ctx := req.Context()
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	var (
+		q      = req.URL.Query()
+		subdir = q.Get("subdir")
+		genre  = q.Get("genre")
+	)
+
+	var query string
+	if _, open := s.auth.(noAuth); s.auth != nil && !open {
+		secret, _, err := s.grants.issue(grantPlaylist, "playlist:"+principal(ctx), "", playlistTTL)
+		if err != nil {
+			return errors.Wrap(err, "issuing playlist grant")
+		}
+		query = "?" + url.Values{"playlist": {secret}}.Encode()
+	}
+
+	base := s.baseURL(req)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "#EXTM3U")
+	for _, e := range s.playlistEntries(ctx, subdir, genre) {
+		fmt.Fprintf(buf, "#EXTINF:-1,%s\n", e.label)
+		fmt.Fprintln(buf, base.JoinPath(rootNamePrefix(e.rootName)+e.objName).String()+query)
+	}
+
+	w.Header().Set("Content-Type", "audio/x-mpegurl")
+	w.Header().Set("Cache-Control", "no-store") // the grant in each fetch is new
+	return s.serveGenerated(w, req, "playlist.m3u", buf.Bytes())
+}
+
+type playlistEntry struct {
+	label, rootName, objName string
+}
+
+// playlistEntries lists the media objects of the titles visible to the requester
+// in the given subdir and genre (either of which may be empty, meaning all),
+// in title order,
+// with a title's parts in sequence.
+// The caller must hold s.mu.
+func (s *server) playlistEntries(ctx context.Context, subdir, genre string) []playlistEntry {
+	include := func(info movieInfo) bool {
+		if info.hidden || !s.mayView(ctx, info) {
+			return false
+		}
+		if subdir != "" && info.subdir != subdir {
+			return false
+		}
+		if genre != "" && !hasGenre(info, genre) {
+			return false
+		}
+		return true
+	}
+
+	type title struct {
+		sortKey, rootName string
+		entries           []playlistEntry
+	}
+	var titles []title
+
+	add := func(rootName string, info movieInfo, entries []playlistEntry) {
+		titles = append(titles, title{
+			sortKey:  titleSortKey(displayTitle(rootName, info), info),
+			rootName: rootName,
+			entries:  entries,
+		})
+	}
+
+	allParts := s.indexParts()
+	grouped := allParts.grouped()
+	for rootName, parts := range allParts {
+		info := s.infoMap[rootName]
+		if !include(info) {
+			continue
+		}
+		label := playlistLabel(rootName, info)
+		var entries []playlistEntry
+		for i, part := range parts {
+			entries = append(entries, playlistEntry{
+				label:    fmt.Sprintf("%s (%d)", label, i+1),
+				rootName: strings.TrimSuffix(part, filepath.Ext(part)),
+				objName:  part,
+			})
+		}
+		add(rootName, info, entries)
+	}
+
+	s.objNames.Each(func(objName string) {
+		if grouped.Has(objName) || s.isEpisode(objName) || s.isExtra(objName) || s.isDiscObj(objName) {
+			return
+		}
+		ext := filepath.Ext(objName)
+		if !isMediaExt(ext) {
+			return
+		}
+		rootName := strings.TrimSuffix(objName, ext)
+		info, ok := s.infoMap[rootName]
+		if !ok && (subdir != "" || genre != "") {
+			return
+		}
+		if !include(info) {
+			return
+		}
+		add(rootName, info, []playlistEntry{{
+			label:    playlistLabel(rootName, info),
+			rootName: rootName,
+			objName:  objName,
+		}})
+	})
+
+	sort.Slice(titles, func(i, j int) bool {
+		if titles[i].sortKey != titles[j].sortKey {
+			return titles[i].sortKey < titles[j].sortKey
+		}
+		return titles[i].rootName < titles[j].rootName
+	})
+
+	var result []playlistEntry
+	for _, t := range titles {
+		result = append(result, t.entries...)
+	}
+	return result
+}
+
+// playlistLabel is the title, with year, shown for a title in a playlist.
+func playlistLabel(rootName string, info movieInfo) string {
+	title := displayTitle(rootName, info)
+	if info.Year > 0 {
+		title = fmt.Sprintf("%s (%d)", title, info.Year)
+	}
+	// A line break would end the #EXTINF line.
+	return strings.Join(strings.Fields(title), " ")
+}
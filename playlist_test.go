@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestHandleLibraryPlaylist(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		objNames:     set.New("The Thin Man.iso", "Top Hat.mp4", "Hidden.mp4"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"The Thin Man": {Title: "The Thin Man", SortTitle: "thin man", Year: 1934, Genre: "Comedy; Mystery"},
+			"Top Hat":      {Title: "Top Hat", SortTitle: "top hat", Genre: "Musical", subdir: "Musicals"},
+			"Hidden":       {hidden: true},
+		},
+		infoMapTime: now,
+		grants: &grantStore{
+			grants:  make(map[string]*grant),
+			known:   set.New[string](),
+			deleted: set.New[string](),
+		},
+		auth: basicAuth{username: "u", password: "p"},
+	}
+
+	rec := httptest.NewRecorder()
+	if err := s.handleLibraryPlaylist(rec, httptest.NewRequest("GET", "http://example.com/playlist.m3u", nil)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 5 || lines[0] != "#EXTM3U" || lines[1] != "#EXTINF:-1,The Thin Man (1934)" || lines[3] != "#EXTINF:-1,Top Hat" {
+		t.Fatalf("got playlist %q", lines)
+	}
+
+	u, err := url.Parse(lines[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/" + rootNamePrefix("The Thin Man") + "The Thin Man.iso"; u.Host != "example.com" || u.Path != want {
+		t.Errorf("got URL %s, want path %s", u, want)
+	}
+	secret := u.Query().Get("playlist")
+	if _, ok := s.grants.lookup(grantPlaylist, secret); !ok {
+		t.Errorf("no playlist grant for %s", u)
+	}
+
+	auth := playlistAuth{grants: s.grants}
+	if _, err := auth.authenticate(rec, httptest.NewRequest("GET", lines[2], nil)); err != nil {
+		t.Errorf("stream URL does not authenticate: %s", err)
+	}
+	if _, err := auth.authenticate(rec, httptest.NewRequest("GET", "/api/titles?playlist="+secret, nil)); err == nil {
+		t.Error("playlist link authenticates for the API")
+	}
+
+	rec = httptest.NewRecorder()
+	if err := s.handleLibraryPlaylist(rec, httptest.NewRequest("GET", "/playlist.m3u?genre=musical", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "Top Hat") || strings.Contains(body, "Thin Man") {
+		t.Errorf("got genre playlist %q", body)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	_ "github.com/lib/pq"
+)
+
+// The schema of a PostgreSQL metadata database,
+// as a sequence of migrations named NNN_DESCRIPTION.sql.
+//
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// Key of the advisory lock that serializes migrations
+// among servers sharing a database.
+const postgresMigrationLock = 0x6b6f6469 // "kodi"
+
+// postgresSource is a metadataSource for a PostgreSQL database,
+// which lets several servers share their metadata.
+// The database's schema is created or updated as needed
+// the first time it is used.
+type postgresSource struct {
+	db *sql.DB
+
+	mu       sync.Mutex
+	migrated bool
+}
+
+func newPostgresSource(dbURL string) (*postgresSource, error) {
+	if u, err := url.Parse(dbURL); err == nil {
+		if pw, ok := u.User.Password(); ok {
+			logRedactor.addSecret(pw)
+		}
+	}
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening database")
+	}
+	return &postgresSource{db: db}, nil
+}
+
+// migrate applies any migrations the database lacks.
+func (s *postgresSource) migrate(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.migrated {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", postgresMigrationLock); err != nil {
+		return errors.Wrap(err, "locking database for migration")
+	}
+	if _, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied TIMESTAMPTZ NOT NULL DEFAULT now())"); err != nil {
+		return errors.Wrap(err, "creating schema_migrations")
+	}
+
+	applied := set.New[int]()
+	rows, err := tx.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return errors.Wrap(err, "reading schema_migrations")
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "scanning schema_migrations")
+		}
+		applied.Add(version)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "reading schema_migrations")
+	}
+
+	names, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return errors.Wrap(err, "listing migrations")
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(path.Base(name), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return fmt.Errorf("malformed migration name %s", name)
+		}
+		if applied.Has(version) {
+			continue
+		}
+
+		script, err := postgresMigrations.ReadFile(name)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", name)
+		}
+		if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+			return errors.Wrapf(err, "applying %s", name)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+			return errors.Wrapf(err, "recording %s", name)
+		}
+		log.Printf("Applied database migration %s", path.Base(name))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing migrations")
+	}
+	s.migrated = true
+	return nil
+}
+
+func (s *postgresSource) rows(ctx context.Context) ([][]interface{}, error) {
+	if err := s.migrate(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT filename, cells FROM titles ORDER BY filename")
+	if err != nil {
+		return nil, errors.Wrap(err, "querying titles")
+	}
+	defer rows.Close()
+
+	var titles []map[string]any
+	for rows.Next() {
+		var (
+			filename string
+			cells    []byte
+		)
+		if err := rows.Scan(&filename, &cells); err != nil {
+			return nil, errors.Wrap(err, "scanning titles")
+		}
+		var t map[string]any
+		if err := json.Unmarshal(cells, &t); err != nil {
+			return nil, errors.Wrapf(err, "decoding cells of %s", filename)
+		}
+		if t == nil {
+			t = make(map[string]any)
+		}
+		t["filename"] = filename
+		titles = append(titles, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading titles")
+	}
+
+	return manifestRows(titles), nil
+}
+
+// version is a counter that the database bumps with every change to its titles.
+func (s *postgresSource) version(ctx context.Context) (int64, error) {
+	if err := s.migrate(ctx); err != nil {
+		return 0, err
+	}
+	var n int64
+	err := s.db.QueryRowContext(ctx, "SELECT n FROM metadata_version").Scan(&n)
+	return n, errors.Wrap(err, "querying metadata version")
+}
+
+// load adds or replaces the given titles,
+// keyed by filename,
+// in a single transaction.
+func (s *postgresSource) load(ctx context.Context, titles map[string]map[string]string) error {
+	if err := s.migrate(ctx); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	for filename, cells := range titles {
+		j, err := json.Marshal(cells)
+		if err != nil {
+			return errors.Wrapf(err, "encoding cells of %s", filename)
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO titles (filename, cells) VALUES ($1, $2)
+			ON CONFLICT (filename) DO UPDATE SET cells = EXCLUDED.cells, updated = now()
+		`, filename, j)
+		if err != nil {
+			return errors.Wrapf(err, "storing %s", filename)
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "committing")
+}
+
+func (s *postgresSource) getCells(ctx context.Context, filename string) (map[string]string, error) {
+	if err := s.migrate(ctx); err != nil {
+		return nil, err
+	}
+	var j []byte
+	err := s.db.QueryRowContext(ctx, "SELECT cells FROM titles WHERE filename = $1", filename).Scan(&j)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "querying %s", filename)
+	}
+	var t map[string]any
+	if err := json.Unmarshal(j, &t); err != nil {
+		return nil, errors.Wrapf(err, "decoding cells of %s", filename)
+	}
+	cells := make(map[string]string)
+	for heading, v := range t {
+		if val := manifestValue(v); val != "" {
+			cells[heading] = val
+		}
+	}
+	return cells, nil
+}
+
+func (s *postgresSource) setCells(ctx context.Context, filename string, cells map[string]string) error {
+	if err := s.migrate(ctx); err != nil {
+		return err
+	}
+	j, err := json.Marshal(cells)
+	if err != nil {
+		return errors.Wrapf(err, "encoding cells of %s", filename)
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO titles (filename, cells) VALUES ($1, $2)
+		ON CONFLICT (filename) DO UPDATE SET cells = titles.cells || EXCLUDED.cells, updated = now()
+	`, filename, j)
+	return errors.Wrapf(err, "updating %s", filename)
+}
+
+func (c maincmd) dbload(ctx context.Context, dbURL, sheetID string, _ []string) error {
+	if !strings.HasPrefix(dbURL, "postgres://") && !strings.HasPrefix(dbURL, "postgresql://") {
+		return fmt.Errorf("must specify -db as a postgres:// URL")
+	}
+	if sheetID == "" {
+		return fmt.Errorf("must specify -sheet")
+	}
+
+	pg, err := newPostgresSource(dbURL)
+	if err != nil {
+		return err
+	}
+	defer pg.db.Close()
+
+	src, err := newMetadataSource(sheetID, c.ssvc, c.dsvc, c.gcs)
+	if err != nil {
+		return err
+	}
+
+	titles := make(map[string]map[string]string)
+	err = handleSheet(ctx, src, func(_ int, headings []string, name string, row []interface{}) error {
+		titles[name] = rowCells(headings, row)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := pg.load(ctx, titles); err != nil {
+		return err
+	}
+	log.Printf("Loaded %d title(s)", len(titles))
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPostgresMigrations(t *testing.T) {
+	names, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatal("no migrations")
+	}
+	sort.Strings(names)
+
+	// Versions must be 1, 2, 3, ... in name order.
+	for i, name := range names {
+		prefix, _, _ := strings.Cut(path.Base(name), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			t.Errorf("malformed migration name %s", name)
+			continue
+		}
+		if version != i+1 {
+			t.Errorf("migration %s has version %d, want %d", name, version, i+1)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// With serve -trusted-proxies,
+// requests arriving from one of the given addresses
+// (a reverse proxy such as Caddy or nginx)
+// are taken to be on behalf of the client named in their Forwarded or X-Forwarded-For header,
+// so that logs, rate limits (see throttle.go), address filters (see ipfilter.go), and the audit log (see audit.go)
+// see the real client address.
+// Their Forwarded or X-Forwarded-Proto and -Host headers
+// likewise determine the scheme and host of the absolute URLs the server generates.
+// Those headers are ignored in requests from anywhere else,
+// since any client can send them.
+
+type forwardedProtoKeyType struct{}
+
+var forwardedProtoKey forwardedProtoKeyType
+
+// isTrustedProxy tells whether addr is one of the server's trusted proxies.
+func (s *server) isTrustedProxy(addr netip.Addr) bool {
+	return slices.ContainsFunc(s.proxies, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
+
+// behindProxy wraps the server's handler
+// so that a request from a trusted proxy has the remote address, host, and scheme
+// of the original request.
+func (s *server) behindProxy(h http.Handler) http.Handler {
+	if len(s.proxies) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		peer, ok := remoteAddr(req)
+		if !ok || !s.isTrustedProxy(peer) {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		fwd := parseForwarded(req.Header)
+
+		ctx := req.Context()
+		if fwd.proto == "http" || fwd.proto == "https" {
+			ctx = context.WithValue(ctx, forwardedProtoKey, fwd.proto)
+		}
+		req = req.WithContext(ctx)
+
+		// The client is the last address not that of a trusted proxy,
+		// since each proxy appends the address it got the request from
+		// and only the trusted ones can be believed.
+		for i := len(fwd.chain) - 1; i >= 0; i-- {
+			addr := fwd.chain[i]
+			req.RemoteAddr = addr.String()
+			if !s.isTrustedProxy(addr) {
+				break
+			}
+		}
+		if fwd.host != "" {
+			req.Host = fwd.host
+		}
+
+		h.ServeHTTP(w, req)
+	})
+}
+
+// forwarded is the information a proxy passes along about the original request.
+type forwarded struct {
+	chain       []netip.Addr // client first, then each proxy before the last
+	proto, host string
+}
+
+// parseForwarded parses the standard Forwarded header (RFC 7239) if it's present,
+// or else the X-Forwarded-For, -Proto, and -Host headers.
+// Addresses that are obfuscated or unknown end the chain there.
+func parseForwarded(hdr http.Header) forwarded {
+	var result forwarded
+
+	if values := hdr.Values("Forwarded"); len(values) > 0 {
+		var elems []string
+		for _, v := range values {
+			elems = append(elems, strings.Split(v, ",")...)
+		}
+		for _, elem := range elems {
+			for _, pair := range strings.Split(elem, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				v = strings.Trim(v, `"`)
+				switch strings.ToLower(k) {
+				case "for":
+					addr, ok := parseForwardedAddr(v)
+					if !ok {
+						result.chain = nil
+						continue
+					}
+					result.chain = append(result.chain, addr)
+				case "proto":
+					result.proto = strings.ToLower(v)
+				case "host":
+					result.host = v
+				}
+			}
+		}
+		return result
+	}
+
+	for _, v := range hdr.Values("X-Forwarded-For") {
+		for _, item := range strings.Split(v, ",") {
+			addr, ok := parseForwardedAddr(strings.TrimSpace(item))
+			if !ok {
+				result.chain = nil
+				continue
+			}
+			result.chain = append(result.chain, addr)
+		}
+	}
+	result.proto = strings.ToLower(strings.TrimSpace(firstValue(hdr.Get("X-Forwarded-Proto"))))
+	result.host = strings.TrimSpace(firstValue(hdr.Get("X-Forwarded-Host")))
+	return result
+}
+
+// parseForwardedAddr parses an address in a Forwarded or X-Forwarded-For header,
+// which may have a port and, if IPv6, brackets.
+func parseForwardedAddr(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// firstValue is the first of a comma-separated list of values.
+func firstValue(s string) string {
+	v, _, _ := strings.Cut(s, ",")
+	return v
+}
+
+// requestScheme is the scheme, http or https, by which the client reached the server:
+// that reported by a trusted proxy,
+// or else that of the server's listener.
+func (s *server) requestScheme(req *http.Request) string {
+	if proto, ok := req.Context().Value(forwardedProtoKey).(string); ok {
+		return proto
+	}
+	if s.tls {
+		return "https"
+	}
+	return "http"
+}
+
+// baseURL is the absolute URL of the server's root as the client reached it,
+// for building the absolute URLs in playlists, share links, and the like.
+func (s *server) baseURL(req *http.Request) *url.URL {
+	return &url.URL{Scheme: s.requestScheme(req), Host: req.Host, Path: "/"}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBehindProxy(t *testing.T) {
+	proxies, err := parsePrefixes("10.0.0.1, 192.168.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{proxies: proxies}
+
+	var gotAddr, gotBase string
+	h := s.behindProxy(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		addr, _ := remoteAddr(req)
+		gotAddr = addr.String()
+		gotBase = s.baseURL(req).String()
+	}))
+
+	cases := []struct {
+		name     string
+		peer     string
+		hdr      map[string]string
+		wantAddr string
+		wantBase string
+	}{{
+		name:     "direct",
+		peer:     "203.0.113.9:1234",
+		wantAddr: "203.0.113.9",
+		wantBase: "http://example.com/",
+	}, {
+		name: "untrusted peer",
+		peer: "203.0.113.9:1234",
+		hdr: map[string]string{
+			"X-Forwarded-For":   "198.51.100.7",
+			"X-Forwarded-Proto": "https",
+			"X-Forwarded-Host":  "media.example.org",
+		},
+		wantAddr: "203.0.113.9",
+		wantBase: "http://example.com/",
+	}, {
+		name: "trusted peer",
+		peer: "10.0.0.1:1234",
+		hdr: map[string]string{
+			"X-Forwarded-For":   "198.51.100.7",
+			"X-Forwarded-Proto": "https",
+			"X-Forwarded-Host":  "media.example.org",
+		},
+		wantAddr: "198.51.100.7",
+		wantBase: "https://media.example.org/",
+	}, {
+		name: "spoofed chain",
+		peer: "10.0.0.1:1234",
+		hdr: map[string]string{
+			"X-Forwarded-For": "1.2.3.4, 198.51.100.7, 192.168.1.1",
+		},
+		wantAddr: "198.51.100.7",
+		wantBase: "http://example.com/",
+	}, {
+		name: "all trusted",
+		peer: "10.0.0.1:1234",
+		hdr: map[string]string{
+			"X-Forwarded-For": "192.168.1.1",
+		},
+		wantAddr: "192.168.1.1",
+		wantBase: "http://example.com/",
+	}, {
+		name: "forwarded",
+		peer: "10.0.0.1:1234",
+		hdr: map[string]string{
+			"Forwarded":       `for=198.51.100.7;proto=https;host=media.example.org, for="[2001:db8::1]:4711"`,
+			"X-Forwarded-For": "1.2.3.4",
+		},
+		wantAddr: "2001:db8::1",
+		wantBase: "https://media.example.org/",
+	}, {
+		name: "unknown",
+		peer: "10.0.0.1:1234",
+		hdr: map[string]string{
+			"Forwarded": "for=unknown",
+		},
+		wantAddr: "10.0.0.1",
+		wantBase: "http://example.com/",
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/playlist.m3u", nil)
+			req.RemoteAddr = tc.peer
+			for k, v := range tc.hdr {
+				req.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if gotAddr != tc.wantAddr {
+				t.Errorf("got address %s, want %s", gotAddr, tc.wantAddr)
+			}
+			if gotBase != tc.wantBase {
+				t.Errorf("got base URL %s, want %s", gotBase, tc.wantBase)
+			}
+		})
+	}
+}
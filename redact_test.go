@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	const secret = "hunter22"
+
+	cases := []string{
+		"Authorization: Basic " + secret,
+		"authorization: Bearer " + secret,
+		"map[Accept:[*/*] Authorization:[Basic " + secret + "]]",
+		"GET /thumbs/foo.jpg?token=" + secret + "&w=200",
+		"https://storage.googleapis.com/b/o?X-Goog-Algorithm=GOOG4-RSA-SHA256&X-Goog-Credential=" + secret + "&X-Goog-Signature=" + secret,
+		"https://user:" + secret + "@example.com/path",
+		`["kodigcs","serve","-password","` + secret + `"]`,
+		"-password=" + secret,
+		"the password is " + secret + ".",
+	}
+
+	r := &redactor{}
+	r.addSecret(secret)
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case_%02d", i+1), func(t *testing.T) {
+			got := r.redact(c)
+			if strings.Contains(got, secret) {
+				t.Errorf("secret leaked in %s", got)
+			}
+			if !strings.Contains(got, redacted) {
+				t.Errorf("nothing redacted in %s", got)
+			}
+		})
+	}
+
+	t.Run("logger", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		r.w = buf
+		logger := log.New(r, "", 0)
+		logger.Printf("Unauthorized access attempt (password %s)", secret)
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("secret leaked in %s", buf.String())
+		}
+	})
+
+	t.Run("innocuous", func(t *testing.T) {
+		const msg = "Serving The Thin Man.iso: 1234 bytes in 5s"
+		if got := r.redact(msg); got != msg {
+			t.Errorf("got %s, want %s", got, msg)
+		}
+	})
+}
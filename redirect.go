@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bobg/errors"
+)
+
+// With serve -redirect,
+// a TLS server also listens for plain HTTP at a second address,
+// answering every request with a permanent redirect to the same URL with https,
+// so that a client configured with an http:// URL gets a clear pointer to the right one
+// instead of a confusing failure.
+// With -acme-domain,
+// that listener also answers Let's Encrypt's HTTP-01 challenges (see acme.go).
+
+// handleRedirect redirects a request to the same URL on the TLS listener.
+func (s *server) handleRedirect(w http.ResponseWriter, req *http.Request) {
+	hostname := strings.Trim(req.Host, "[]") // an IPv6 address without a port is bracketed
+	if h, _, err := net.SplitHostPort(req.Host); err == nil {
+		hostname = h
+	}
+	port := "443"
+	if _, p, err := net.SplitHostPort(s.listenAddr); err == nil && p != "" && p != "https" {
+		port = p
+	}
+	host := net.JoinHostPort(hostname, port)
+	if port == "443" {
+		host = strings.TrimSuffix(host, ":443")
+	}
+
+	u := url.URL{
+		Scheme:   "https",
+		Host:     host,
+		Path:     req.URL.Path,
+		RawPath:  req.URL.RawPath,
+		RawQuery: req.URL.RawQuery,
+	}
+	http.Redirect(w, req, u.String(), http.StatusMovedPermanently)
+}
+
+// runRedirect serves the plain-HTTP redirect listener at addr until the context is canceled.
+func (s *server) runRedirect(ctx context.Context, addr string) error {
+	var h http.Handler = http.HandlerFunc(s.handleRedirect)
+	if s.acme != nil {
+		h = s.acme.HTTPHandler(h)
+	}
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: h,
+	}
+
+	ln, err := s.listen(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Redirecting HTTP on %s to HTTPS", addr)
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(srv.Shutdown(context.WithoutCancel(ctx)), "in Shutdown")
+	case err := <-errCh:
+		return errors.Wrap(err, "in Serve")
+	}
+}
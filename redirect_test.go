@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirect(t *testing.T) {
+	cases := []struct {
+		listen, target, want string
+	}{
+		{listen: ":1549", target: "http://media.example.com/Top%20Hat.nfo?x=1", want: "https://media.example.com:1549/Top%20Hat.nfo?x=1"},
+		{listen: ":1549", target: "http://media.example.com:80/", want: "https://media.example.com:1549/"},
+		{listen: ":443", target: "http://media.example.com/gallery/", want: "https://media.example.com/gallery/"},
+		{listen: "[::]:8443", target: "http://[2001:db8::1]/", want: "https://[2001:db8::1]:8443/"},
+		{listen: ":https", target: "http://[2001:db8::1]:80/", want: "https://[2001:db8::1]/"},
+	}
+	for _, c := range cases {
+		t.Run(c.target, func(t *testing.T) {
+			s := &server{listenAddr: c.listen}
+			rec := httptest.NewRecorder()
+			s.handleRedirect(rec, httptest.NewRequest("GET", c.target, nil))
+			if rec.Code != http.StatusMovedPermanently {
+				t.Errorf("got status %d, want %d", rec.Code, http.StatusMovedPermanently)
+			}
+			if got := rec.Header().Get("Location"); got != c.want {
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
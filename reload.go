@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+	"gopkg.in/yaml.v3"
+)
+
+// With serve -config,
+// some of the server's settings come from a YAML or JSON file
+// (local or gs://)
+// as well as from flags:
+//
+//	exts: [mkv, mp4, avi]  # as with -exts
+//	access:                # as with -access
+//	  kids: [alice, bob, kodi-kids]
+//	  private: [alice]
+//	verbose: true          # as with -verbose
+//
+// A setting missing from the file keeps its flag's value.
+//
+// When the server gets SIGHUP,
+// it rereads that file and the -headings file,
+// as well as the -users file (see users.go),
+// and applies the changes without restarting,
+// so streams in progress carry on.
+// If a file can't be read,
+// the server logs the error and keeps its current settings.
+
+// settings are the server settings that can change without a restart.
+type settings struct {
+	exts    set.Of[string]
+	access  map[string]set.Of[string]
+	verbose bool
+}
+
+// configFile is the format of the -config file.
+type configFile struct {
+	Exts    []string            `yaml:"exts"`
+	Access  map[string][]string `yaml:"access"`
+	Verbose *bool               `yaml:"verbose"`
+}
+
+// reloader rereads the server's configuration files.
+type reloader struct {
+	config   string // from serve -config, or ""
+	headings string // from the top-level -headings flag, or ""
+	gcs      *storage.Client
+	flags    settings // the settings from flags, for those the config file doesn't give
+}
+
+// loadSettings reads the -config file,
+// with settings it doesn't give taken from flags.
+func (r *reloader) loadSettings(ctx context.Context) (settings, error) {
+	result := r.flags
+	if r.config == "" {
+		return result, nil
+	}
+
+	f, err := sourceFile{name: r.config, gcs: r.gcs}.open(ctx)
+	if err != nil {
+		return settings{}, err
+	}
+	defer f.Close()
+
+	var cf configFile
+	if err := yaml.NewDecoder(f).Decode(&cf); err != nil {
+		return settings{}, errors.Wrapf(err, "parsing %s", r.config)
+	}
+
+	if cf.Exts != nil {
+		result.exts = parseMediaExts(strings.Join(cf.Exts, ","))
+		if result.exts.Len() == 0 {
+			return settings{}, fmt.Errorf("no extensions in exts in %s", r.config)
+		}
+	}
+	if cf.Access != nil {
+		result.access = make(map[string]set.Of[string])
+		for subdir, users := range cf.Access {
+			subdir = strings.Trim(strings.TrimSpace(subdir), "/")
+			if subdir == "" {
+				return settings{}, fmt.Errorf("empty subdirectory in access in %s", r.config)
+			}
+			result.access[subdir] = set.New(users...)
+		}
+	}
+	if cf.Verbose != nil {
+		result.verbose = *cf.Verbose
+	}
+
+	return result, nil
+}
+
+// reload rereads the configuration files and applies them.
+// Nothing changes if any of them can't be read.
+func (s *server) reload(ctx context.Context) error {
+	r := s.reloader
+
+	var headings *headingMap
+	if r.headings != "" {
+		h, err := loadHeadingMap(ctx, r.headings, r.gcs)
+		if err != nil {
+			return errors.Wrap(err, "loading heading map")
+		}
+		headings = &h
+	}
+
+	st, err := r.loadSettings(ctx)
+	if err != nil {
+		return errors.Wrap(err, "loading configuration")
+	}
+
+	stale := false
+	if headings != nil {
+		currentHeadings.Store(headings)
+		stale = true
+	}
+	if !st.exts.Equal(mediaExts()) {
+		setMediaExts(st.exts)
+		stale = true
+	}
+	s.applySettings(st)
+
+	if stale {
+		// Reload the metadata on the next request that needs it.
+		s.mu.Lock()
+		s.infoMapTime = time.Time{}
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// applySettings applies the settings protected by s.cfgMu.
+func (s *server) applySettings(st settings) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+
+	s.access = st.access
+	s.verbose = st.verbose
+}
+
+// reloadOnHUP calls reload whenever the process gets SIGHUP,
+// until the context is canceled.
+func (s *server) reloadOnHUP(ctx context.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			if err := s.reload(ctx); err != nil {
+				log.Printf("Error reloading configuration: %s", err)
+				continue
+			}
+			log.Print("Reloaded configuration")
+		}
+	}
+}
+
+// isVerbose tells whether the server is logging requests and streams,
+// which serve -config can change.
+func (s *server) isVerbose() bool {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.verbose
+}
+
+// logged wraps a handler so that it logs requests while the server is verbose.
+func (s *server) logged(h http.Handler) http.Handler {
+	logged := mid.Log(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if s.isVerbose() {
+			logged.ServeHTTP(w, req)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestReload(t *testing.T) {
+	saved := mediaExts()
+	defer setMediaExts(saved)
+
+	var (
+		ctx     = context.Background()
+		config  = filepath.Join(t.TempDir(), "config.yaml")
+		flagExt = parseMediaExts("mkv")
+	)
+	setMediaExts(flagExt)
+
+	write := func(content string) {
+		if err := os.WriteFile(config, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+exts: [mkv, avi]
+access:
+  /kids/: [alice, kodi-kids]
+verbose: true
+`)
+
+	s := &server{
+		access:      map[string]set.Of[string]{"private": set.New("alice")},
+		infoMapTime: time.Now(),
+		reloader: &reloader{
+			config: config,
+			flags: settings{
+				exts:   flagExt,
+				access: map[string]set.Of[string]{"private": set.New("alice")},
+			},
+		},
+	}
+
+	if err := s.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !isMediaExt(".AVI") {
+		t.Error("avi is not a media extension after reload")
+	}
+	if s.mayAccess("bob", "kids") || !s.mayAccess("kodi-kids", "kids/cartoons") || !s.mayAccess("bob", "private") {
+		t.Errorf("got access rules %v", s.accessRules())
+	}
+	if !s.isVerbose() {
+		t.Error("not verbose after reload")
+	}
+	if !s.infoMapTime.IsZero() {
+		t.Error("metadata not marked stale after the extensions changed")
+	}
+
+	// A bad file changes nothing.
+	write("exts: [")
+	if err := s.reload(ctx); err == nil {
+		t.Error("no error reloading a malformed file")
+	}
+	if !isMediaExt(".avi") || !s.isVerbose() {
+		t.Error("settings changed by a malformed file")
+	}
+
+	// Settings missing from the file revert to the flags.
+	write("verbose: false\n")
+	if err := s.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if isMediaExt(".avi") || s.mayAccess("bob", "private") || !s.mayAccess("bob", "kids") || s.isVerbose() {
+		t.Errorf("got extensions %v, access rules %v, verbose %v", mediaExts().Slice(), s.accessRules(), s.isVerbose())
+	}
+}
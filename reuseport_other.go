@@ -0,0 +1,13 @@
+//go:build !unix || solaris
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+func reusePortControl(_, _ string, _ syscall.RawConn) error {
+	return fmt.Errorf("-reuseport is not supported on %s", runtime.GOOS)
+}
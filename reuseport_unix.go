@@ -0,0 +1,21 @@
+//go:build unix && !solaris
+
+package main
+
+import (
+	"syscall"
+
+	"github.com/bobg/errors"
+	"golang.org/x/sys/unix"
+)
+
+func reusePortControl(_, _ string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(sockErr, "setting SO_REUSEPORT")
+}
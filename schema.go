@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"google.golang.org/api/iterator"
+)
+
+// knownHeadings are the metadata spreadsheet headings that kodigcs understands
+// (other than that of the first column, the filename),
+// as in server.parseInfoRow and updateEpisodes.
+var knownHeadings = set.New(
+	"title", "originaltitle", "sort", "year", "premiered",
+	"banner", "clearart", "clearlogo", "discart", "landscape", "poster",
+	"directors", "actors", "actorthumbs", "runtime", "trailer",
+	"outline", "plot", "tagline", "genre", "mpaa", "country", "studio", "language",
+	"set", "imdbrating", "rottentomatoes", "metacritic", "top250", "awards",
+	"subdir", "hidden", "enabled", "adult", "pin", "parts", "imdbid", "season",
+)
+
+// A schemaProblem is something wrong with a row of the metadata spreadsheet.
+type schemaProblem struct {
+	row int // as numbered in the spreadsheet, starting at 1 for the headings
+	msg string
+}
+
+func (c maincmd) schema(ctx context.Context, sheetID string, _ []string) error {
+	if sheetID == "" {
+		return fmt.Errorf("must specify -sheet")
+	}
+	meta, err := newMetadataSource(sheetID, c.ssvc, c.dsvc, c.gcs)
+	if err != nil {
+		return err
+	}
+
+	objNames, err := listObjNames(ctx, c.bucket)
+	if err != nil {
+		return err
+	}
+
+	problems, err := checkSchema(ctx, meta, objNames)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Printf("row %d: %s\n", p.row, p.msg)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problem(s)", len(problems))
+	}
+	fmt.Println("No problems found")
+	return nil
+}
+
+func listObjNames(ctx context.Context, bucket *storage.BucketHandle) (set.Of[string], error) {
+	objNames := set.New[string]()
+	iter := bucket.Objects(ctx, nil)
+	for {
+		attrs, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			return objNames, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "iterating over bucket")
+		}
+		objNames.Add(attrs.Name)
+	}
+}
+
+// checkSchema finds problems in the metadata from src:
+// unknown headings,
+// duplicate rows for the same title,
+// rows naming no object in objNames,
+// and values that cannot be parsed.
+// Problems are sorted by row.
+func checkSchema(ctx context.Context, src metadataSource, objNames set.Of[string]) ([]schemaProblem, error) {
+	var (
+		problems []schemaProblem
+		seen     = make(map[string]int) // root name -> row
+		checked  bool                   // whether the headings have been checked
+	)
+
+	err := handleSheet(ctx, src, func(rownum int, headings []string, name string, row []interface{}) error {
+		rownum++ // the spreadsheet's numbering
+
+		problem := func(format string, args ...any) {
+			problems = append(problems, schemaProblem{row: rownum, msg: fmt.Sprintf(format, args...)})
+		}
+
+		if !checked {
+			for j, heading := range headings {
+				if j > 0 && heading != "" && !knownHeadings.Has(heading) {
+					problems = append(problems, schemaProblem{row: 1, msg: fmt.Sprintf("unknown heading %q in column %s", heading, colName(j))})
+				}
+			}
+			checked = true
+		}
+
+		rootName := strings.TrimSuffix(name, filepath.Ext(name))
+		if first, ok := seen[rootName]; ok {
+			problem("duplicate of row %d (%s)", first, rootName)
+		} else {
+			seen[rootName] = rownum
+		}
+
+		var parts string
+		for j, rawval := range row {
+			if j == 0 || j >= len(headings) {
+				continue
+			}
+			val, ok := rawval.(string)
+			if !ok || val == "" {
+				continue
+			}
+
+			heading := headings[j]
+			switch heading {
+			case "year", "runtime", "top250", "season":
+				if _, err := strconv.Atoi(val); err != nil {
+					problem("cannot parse %s %q", heading, val)
+				}
+
+			case "premiered":
+				if _, err := time.Parse(time.DateOnly, val); err != nil {
+					problem("cannot parse premiere date %q, want YYYY-MM-DD", val)
+				}
+
+			case "imdbrating", "rottentomatoes", "metacritic":
+				if _, err := strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64); err != nil {
+					problem("cannot parse %s rating %q", heading, val)
+				}
+
+			case "trailer":
+				if _, err := parseTrailer(val); err != nil {
+					problem("cannot parse trailer URL %q: %s", val, err)
+				}
+
+			case "imdbid":
+				if !imdbIDRE.MatchString(parseIMDbID(val)) {
+					problem("malformed IMDb ID %q", val)
+				}
+
+			case "parts":
+				parts = val
+			}
+		}
+
+		if parts == "" {
+			if !objNames.Has(name) && !hasVersions(objNames, strings.TrimSuffix(name, filepath.Ext(name))) {
+				problem("no object named %s", name)
+			}
+			return nil
+		}
+
+		if _, err := filepath.Match(parts, ""); err != nil {
+			problem("bad parts pattern %q: %s", parts, err)
+			return nil
+		}
+		var matched bool
+		for objName := range objNames {
+			if ok, _ := filepath.Match(parts, objName); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			problem("parts pattern %q matches no objects", parts)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].row < problems[j].row })
+	return problems, nil
+}
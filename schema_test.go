@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestCheckSchema(t *testing.T) {
+	const contents = `Filename,Title,Year,Runtime,Trailer,IMDbID,Parts,Colour
+The Thin Man.iso,The Thin Man,1934,91,https://youtu.be/abc,tt0025878,,
+After the Thin Man.iso,,nineteen36,,https://vimeo.com/123,,,blue
+The Thin Man.mkv,The Thin Man again,,1h31m,,0025878x,,
+Box Set.m3u,Box Set,,,,,Box Set Disc *.iso,
+Missing.iso,Missing,,,,,,
+`
+	path := filepath.Join(t.TempDir(), "titles.csv")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := newMetadataSource(path, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objNames := set.New("The Thin Man.iso", "After the Thin Man.iso", "The Thin Man.mkv", "Box Set Disc 1.iso")
+
+	problems, err := checkSchema(context.Background(), src, objNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []schemaProblem{
+		{row: 1, msg: `unknown heading "colour" in column H`},
+		{row: 3, msg: `cannot parse year "nineteen36"`},
+		{row: 3, msg: `cannot parse trailer URL "https://vimeo.com/123": not a YouTube link`},
+		{row: 4, msg: `duplicate of row 2 (The Thin Man)`},
+		{row: 4, msg: `cannot parse runtime "1h31m"`},
+		{row: 4, msg: `malformed IMDb ID "0025878x"`},
+		{row: 6, msg: `no object named Missing.iso`},
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("got %v, want %v", problems, want)
+	}
+}
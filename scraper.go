@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+)
+
+// A Scraper gets title metadata from an online database.
+// Scrapers are registered by name with registerScraper
+// and chosen with ssupdate's -scrapers flag.
+type Scraper interface {
+	// Name is the name under which the scraper is registered.
+	Name() string
+
+	// LookupByID gets the info for the title with the given ID.
+	// Scrapers should accept IMDb IDs,
+	// which is what the IMDbID column holds,
+	// if they can map them to their own IDs.
+	// A scraper that cannot find the title returns errNotFound.
+	LookupByID(ctx context.Context, id string) (*titleInfo, error)
+
+	// SearchByTitle finds titles with the given name,
+	// released in the given year if it is nonzero.
+	// The IDs of the results are suitable for LookupByID.
+	SearchByTitle(ctx context.Context, title string, year int) ([]searchResult, error)
+}
+
+var errNotFound = errors.New("not found")
+
+// titleInfo is what a Scraper knows about a title.
+type titleInfo struct {
+	ID            string // in the scraper's own namespace
+	Name          string
+	AlternateName string // the original title, for titles that are localized
+	Image         string // poster URL
+	Description   string
+	DatePublished string // YYYY-MM-DD
+	ContentRating string
+
+	Genres    []string
+	Actors    []string
+	Directors []string
+	Countries []string
+	Languages []string
+	Studios   []string
+	Roles     map[string]string // actor name -> character name
+	Headshots map[string]string // actor name -> image URL
+
+	RuntimeMins int
+	Summary     string
+	Tagline     string
+
+	Top250 int    // position in IMDb's Top 250 chart, or zero
+	Awards string // a summary, e.g. "Won 3 Oscars. 10 wins & 20 nominations total"
+
+	// Ratings maps the name of a rating (ratingIMDb etc.) to its value.
+	Ratings map[string]float64
+}
+
+// Names of ratings.
+// These are the names Kodi uses in the <ratings> of an NFO file.
+const (
+	ratingIMDb           = "imdb"
+	ratingRottenTomatoes = "tomatometerallcritics"
+	ratingMetacritic     = "metacritic"
+)
+
+// ratingColumns maps the spreadsheet column for each rating to the rating's name.
+var ratingColumns = map[string]string{
+	"imdbrating":     ratingIMDb,
+	"rottentomatoes": ratingRottenTomatoes,
+	"metacritic":     ratingMetacritic,
+}
+
+// ratingMax gives the maximum value of each rating.
+var ratingMax = map[string]int{
+	ratingIMDb:           10,
+	ratingRottenTomatoes: 100,
+	ratingMetacritic:     100,
+}
+
+// fill sets the empty fields of info from other.
+func (info *titleInfo) fill(other *titleInfo) {
+	fillString := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	fillStrings := func(dst *[]string, src []string) {
+		if len(*dst) == 0 {
+			*dst = src
+		}
+	}
+	fillMap := func(dst *map[string]string, src map[string]string) {
+		if len(*dst) == 0 {
+			*dst = src
+		}
+	}
+
+	fillString(&info.ID, other.ID)
+	fillString(&info.Name, other.Name)
+	fillString(&info.AlternateName, other.AlternateName)
+	fillString(&info.Image, other.Image)
+	fillString(&info.Description, other.Description)
+	fillString(&info.DatePublished, other.DatePublished)
+	fillString(&info.ContentRating, other.ContentRating)
+	fillStrings(&info.Genres, other.Genres)
+	fillStrings(&info.Actors, other.Actors)
+	fillStrings(&info.Directors, other.Directors)
+	fillStrings(&info.Countries, other.Countries)
+	fillStrings(&info.Languages, other.Languages)
+	fillStrings(&info.Studios, other.Studios)
+	fillMap(&info.Roles, other.Roles)
+	fillMap(&info.Headshots, other.Headshots)
+	if info.RuntimeMins == 0 {
+		info.RuntimeMins = other.RuntimeMins
+	}
+	fillString(&info.Summary, other.Summary)
+	fillString(&info.Tagline, other.Tagline)
+	if info.Top250 == 0 {
+		info.Top250 = other.Top250
+	}
+	fillString(&info.Awards, other.Awards)
+	for name, val := range other.Ratings {
+		if _, ok := info.Ratings[name]; ok {
+			continue
+		}
+		if info.Ratings == nil {
+			info.Ratings = make(map[string]float64)
+		}
+		info.Ratings[name] = val
+	}
+}
+
+// searchResult is a title found by Scraper.SearchByTitle.
+type searchResult struct {
+	ID    string
+	Title string
+	Year  int
+}
+
+// filterByYear returns the results released in the given year,
+// or all of them if year is zero.
+func filterByYear(results []searchResult, year int) []searchResult {
+	if year == 0 {
+		return results
+	}
+	var filtered []searchResult
+	for _, r := range results {
+		if r.Year == year {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// scraperConfig is what the constructor of a Scraper gets to work with.
+type scraperConfig struct {
+	cl      *http.Client          // rate-limited client for scraping
+	bucket  *storage.BucketHandle // for caching, may be nil
+	refetch bool                  // ignore cached pages
+	omdbKey string                // OMDb API key
+
+	// The transport for scrapers that need their own rate limit.
+	// If nil, http.DefaultTransport is used.
+	transport http.RoundTripper
+}
+
+var scraperRegistry = make(map[string]func(scraperConfig) (Scraper, error))
+
+// registerScraper makes a Scraper available under the given name.
+// It is meant to be called from init functions.
+func registerScraper(name string, newScraper func(scraperConfig) (Scraper, error)) {
+	scraperRegistry[name] = newScraper
+}
+
+// newScrapers creates the scrapers named in a comma-separated list,
+// chaining them in that order.
+func newScrapers(names string, conf scraperConfig) (scraperChain, error) {
+	var chain scraperChain
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		newScraper, ok := scraperRegistry[name]
+		if !ok {
+			var known []string
+			for k := range scraperRegistry {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown scraper %s (known scrapers: %s)", name, strings.Join(known, ", "))
+		}
+		s, err := newScraper(conf)
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating scraper %s", name)
+		}
+		chain = append(chain, s)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no scrapers")
+	}
+	return chain, nil
+}
+
+// scraperChain is a Scraper that consults several others.
+// LookupByID takes the info from the first scraper that finds the title,
+// filling in any missing fields from the later ones.
+// SearchByTitle returns the results of the first scraper that has any.
+type scraperChain []Scraper
+
+func (c scraperChain) Name() string {
+	var names []string
+	for _, s := range c {
+		names = append(names, s.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (c scraperChain) LookupByID(ctx context.Context, id string) (*titleInfo, error) {
+	var result *titleInfo
+	for _, s := range c {
+		info, err := s.LookupByID(ctx, id)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "looking up %s with %s", id, s.Name())
+		}
+		if result == nil {
+			result = info
+		} else {
+			result.fill(info)
+		}
+	}
+	if result == nil {
+		return nil, errNotFound
+	}
+	return result, nil
+}
+
+func (c scraperChain) SearchByTitle(ctx context.Context, title string, year int) ([]searchResult, error) {
+	for _, s := range c {
+		results, err := s.SearchByTitle(ctx, title, year)
+		if err != nil {
+			return nil, errors.Wrapf(err, "searching for %s with %s", title, s.Name())
+		}
+		if len(results) > 0 {
+			return results, nil
+		}
+	}
+	return nil, nil
+}
+
+// cells renders the info as the values of metadata spreadsheet columns,
+// keyed by lowercase column heading.
+// Columns for which there is no info are absent.
+func (info *titleInfo) cells() map[string]string {
+	cells := make(map[string]string)
+	put := func(heading, val string) {
+		if val != "" {
+			cells[heading] = val
+		}
+	}
+
+	var actors, pairs []string
+	for _, a := range info.Actors {
+		actors = append(actors, formatActor(a, info.Roles[a]))
+		if u := info.Headshots[a]; u != "" {
+			pairs = append(pairs, a+"="+u)
+		}
+	}
+	put("actors", strings.Join(actors, "; "))
+	put("actorthumbs", strings.Join(pairs, "; "))
+	put("directors", strings.Join(info.Directors, "; "))
+	put("genre", strings.Join(info.Genres, "; "))
+	put("poster", info.Image)
+
+	if parts := strings.Split(info.DatePublished, "-"); len(parts) == 3 {
+		put("year", parts[0])
+	}
+	if _, err := time.Parse(time.DateOnly, info.DatePublished); err == nil {
+		put("premiered", info.DatePublished)
+	}
+
+	put("plot", info.Summary)
+	if info.Summary != "" {
+		put("outline", firstSentence(info.Summary))
+	}
+	put("tagline", info.Tagline)
+	if info.Top250 > 0 {
+		put("top250", strconv.Itoa(info.Top250))
+	}
+	put("awards", info.Awards)
+	if info.RuntimeMins > 0 {
+		put("runtime", strconv.Itoa(info.RuntimeMins))
+	}
+	if info.AlternateName != info.Name {
+		put("originaltitle", info.AlternateName)
+	}
+	put("country", strings.Join(info.Countries, "; "))
+	put("language", strings.Join(info.Languages, "; "))
+	put("studio", strings.Join(info.Studios, "; "))
+	put("mpaa", info.ContentRating)
+
+	for heading, name := range ratingColumns {
+		if val, ok := info.Ratings[name]; ok {
+			put(heading, strconv.FormatFloat(val, 'f', -1, 64))
+		}
+	}
+
+	return cells
+}
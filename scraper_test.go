@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// fixtureTransport serves files from testdata/imdb in place of IMDb.
+type fixtureTransport struct{}
+
+func (fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var filename string
+	switch req.URL.Path {
+	case "/find/":
+		filename = "find.html"
+	default:
+		filename = filepath.Base(req.URL.Path) + ".html"
+	}
+	f, err := os.Open(filepath.Join("testdata", "imdb", filename))
+	if err != nil {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: http.NoBody, Request: req}, nil
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: f, Request: req}, nil
+}
+
+func fixtureScrapers(t *testing.T, names string) scraperChain {
+	chain, err := newScrapers(names, scraperConfig{cl: &http.Client{Transport: fixtureTransport{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+func TestIMDbLookupByID(t *testing.T) {
+	s := fixtureScrapers(t, "imdb")
+
+	info, err := s.LookupByID(context.Background(), "https://www.imdb.com/title/tt0025878/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &titleInfo{
+		ID:            "tt0025878",
+		Name:          "The Thin Man",
+		Image:         "https://m.media-amazon.com/images/M/thinman.jpg",
+		Description:   "Former detective Nick Charles and his wealthy wife Nora investigate a murder case.",
+		DatePublished: "1934-06-29",
+		ContentRating: "Passed",
+		Genres:        []string{"Comedy", "Crime", "Mystery"},
+		Actors:        []string{"William Powell", "Myrna Loy"},
+		Directors:     []string{"W.S. Van Dyke"},
+		Countries:     []string{"United States"},
+		Languages:     []string{"English"},
+		Studios:       []string{"Metro-Goldwyn-Mayer (MGM)"},
+		Roles:         map[string]string{"William Powell": "Nick Charles", "Myrna Loy": "Nora Charles"},
+		Headshots:     map[string]string{"William Powell": "https://m.media-amazon.com/images/M/powell.jpg"},
+		RuntimeMins:   91,
+		Summary:       "A husband and wife detective team takes on the search for a missing inventor and almost get killed for their efforts.",
+		Tagline:       "A laugh tops every thrill!",
+		Awards:        "Nominated for 4 Oscars. 3 wins & 6 nominations total",
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+
+	if _, err := s.LookupByID(context.Background(), "not-an-id"); err != errNotFound {
+		t.Errorf("got error %v for bad ID, want errNotFound", err)
+	}
+}
+
+func TestIMDbSearchByTitle(t *testing.T) {
+	s := fixtureScrapers(t, "imdb")
+
+	results, err := s.SearchByTitle(context.Background(), "The Thin Man", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []searchResult{
+		{ID: "tt0025878", Title: "The Thin Man", Year: 1934},
+		{ID: "tt0040860", Title: "The Thin Man", Year: 1957},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %+v, want %+v", results, want)
+	}
+
+	results, err = s.SearchByTitle(context.Background(), "The Thin Man", 1934)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != "tt0025878" {
+		t.Errorf("got %+v, want just tt0025878", results)
+	}
+}
+
+// stubScraper is a Scraper with canned info, for testing chains.
+type stubScraper struct {
+	info *titleInfo
+}
+
+func (stubScraper) Name() string { return "stub" }
+
+func (s stubScraper) LookupByID(context.Context, string) (*titleInfo, error) {
+	if s.info == nil {
+		return nil, errNotFound
+	}
+	info := *s.info
+	return &info, nil
+}
+
+func (stubScraper) SearchByTitle(context.Context, string, int) ([]searchResult, error) {
+	return nil, nil
+}
+
+func TestScraperChain(t *testing.T) {
+	chain := scraperChain{
+		stubScraper{},
+		stubScraper{info: &titleInfo{Name: "First", RuntimeMins: 90}},
+		stubScraper{info: &titleInfo{Name: "Second", Tagline: "Filled in", RuntimeMins: 100}},
+	}
+	info, err := chain.LookupByID(context.Background(), "tt1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &titleInfo{Name: "First", Tagline: "Filled in", RuntimeMins: 90}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+
+	if _, err := (scraperChain{stubScraper{}}).LookupByID(context.Background(), "tt1"); err != errNotFound {
+		t.Errorf("got error %v, want errNotFound", err)
+	}
+}
+
+func TestBestGuess(t *testing.T) {
+	results := []searchResult{
+		{ID: "tt0025878", Title: "The Thin Man", Year: 1934},
+		{ID: "tt0033152", Title: "Shadow of the Thin Man", Year: 1941},
+		{ID: "tt0000001", Title: "Thin Man", Year: 1990},
+	}
+
+	cases := []struct {
+		title    string
+		year     int
+		wantID   string
+		wantConf bool
+	}{
+		{title: "The Thin Man", year: 1934, wantID: "tt0025878", wantConf: true},
+		{title: "the thin man", year: 1935, wantID: "tt0025878", wantConf: true},
+		{title: "Shadow of the Thin Man", wantID: "tt0033152", wantConf: true},
+		{title: "The Thin Man", wantConf: false}, // two equally good matches
+		{title: "After the Thin Man", year: 1936, wantConf: false},
+	}
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			best, conf := bestGuess(results, c.title, c.year)
+			if got := conf >= guessThreshold; got != c.wantConf {
+				t.Fatalf("got confidence %.2f (best %s), want confident=%v", conf, best.ID, c.wantConf)
+			}
+			if c.wantConf && best.ID != c.wantID {
+				t.Errorf("got %s, want %s", best.ID, c.wantID)
+			}
+		})
+	}
+}
+
+// flakyTransport fails with 503 until it has been called n times.
+type flakyTransport struct {
+	n, calls int
+}
+
+func (f *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.calls++
+	if f.calls < f.n {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody, Request: req}, nil
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestRetryTransport(t *testing.T) {
+	cases := []struct {
+		n, retries int
+		wantStatus int
+		wantCalls  int
+	}{
+		{n: 1, retries: 3, wantStatus: http.StatusOK, wantCalls: 1},
+		{n: 3, retries: 3, wantStatus: http.StatusOK, wantCalls: 3},
+		{n: 5, retries: 3, wantStatus: http.StatusServiceUnavailable, wantCalls: 4},
+	}
+	for _, c := range cases {
+		flaky := &flakyTransport{n: c.n}
+		cl := &http.Client{Transport: &retryTransport{retries: c.retries, backoff: time.Millisecond, transport: flaky}}
+		resp, err := cl.Get("https://example.com/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.wantStatus {
+			t.Errorf("n=%d: got status %d, want %d", c.n, resp.StatusCode, c.wantStatus)
+		}
+		if flaky.calls != c.wantCalls {
+			t.Errorf("n=%d: got %d calls, want %d", c.n, flaky.calls, c.wantCalls)
+		}
+	}
+}
+
+func TestPlotSection(t *testing.T) {
+	extract := `The Thin Man is a 1934 American pre-Code comedy-mystery film.
+
+== Plot ==
+Nick Charles, a retired detective, and his wife Nora are in New York.
+
+=== Investigation ===
+Nick reluctantly takes the case.
+
+
+== Cast ==
+William Powell as Nick Charles`
+
+	const want = "Nick Charles, a retired detective, and his wife Nora are in New York.\n\nNick reluctantly takes the case."
+	if got := plotSection(extract); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := plotSection("A film.\n\n== Cast ==\nSomeone"); got != "" {
+		t.Errorf("got %q, want empty string", got)
+	}
+}
+
+func TestGetAwards(t *testing.T) {
+	const page = `<html><body>
+<a href="/chart/top/?ref_=tt_awd">Top rated movie #61</a>
+<ul><li data-testid="award_information"><a href="/title/tt0000001/awards/">Won 3 Oscars</a><span class="ipc-metadata-list-item__list-content-item">10 wins &amp; 20 nominations total</span></li></ul>
+</body></html>`
+
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	top250, awards, err := getAwards(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top250 != 61 {
+		t.Errorf("got Top 250 position %d, want 61", top250)
+	}
+	if want := "Won 3 Oscars. 10 wins & 20 nominations total"; awards != want {
+		t.Errorf("got awards %q, want %q", awards, want)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// The default number of results of a search.
+const searchLimit = 50
+
+// searchHit is a title matching a search, for JSON clients.
+// Paths are relative to the server's root.
+type searchHit struct {
+	Root  string  `json:"root"`
+	Path  string  `json:"path"` // the media object, the playlist of a multi-part title, or the folder of a disc
+	NFO   string  `json:"nfo"`
+	Title string  `json:"title"`
+	Year  int     `json:"year,omitempty"`
+	Match string  `json:"match"` // what matched, e.g. "title" or "actor: William Powell"
+	Score float64 `json:"score"` // from 0 to 1
+
+	sortKey string
+}
+
+// Weights of matches on the different fields of a title.
+// A match on the title counts for more than a match on a name in the cast.
+const (
+	searchTitleWeight    = 1.0
+	searchDirectorWeight = 0.8
+	searchActorWeight    = 0.7
+)
+
+// handleSearch serves an HTML page of the titles matching the query in the q parameter.
+func (s *server) handleSearch(w http.ResponseWriter, req *http.Request) error {
+	q := req.FormValue("q")
+	hits, err := s.searchRequest(req, q)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return searchTemplate.Execute(w, struct {
+		Query string
+		Hits  []searchHit
+	}{
+		Query: q,
+		Hits:  hits,
+	})
+}
+
+// handleAPISearch is like handleSearch but serves JSON.
+func (s *server) handleAPISearch(w http.ResponseWriter, req *http.Request) error {
+	hits, err := s.searchRequest(req, req.FormValue("q"))
+	if err != nil {
+		return err
+	}
+	if hits == nil {
+		hits = []searchHit{}
+	}
+	return mid.RespondJSON(w, hits)
+}
+
+// searchRequest runs the search for query
+// with the limit in the request's limit parameter, if any.
+func (s *server) searchRequest(req *http.Request, query string) ([]searchHit, error) {
+	limit := searchLimit
+	if l := req.FormValue("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			return nil, mid.CodeErr{
+				C:   http.StatusBadRequest,
+				Err: fmt.Errorf("bad limit %q", l),
+			}
+		}
+		limit = n
+	}
+
+	ctx := req.Context()
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return nil, errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return nil, errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.search(ctx, query, limit), nil
+}
+
+// search returns up to limit titles matching query
+// that are visible to the requester,
+// best match first.
+// The caller must hold s.mu.
+func (s *server) search(ctx context.Context, query string, limit int) []searchHit {
+	qwords := searchWords(query)
+	if len(qwords) == 0 {
+		return nil
+	}
+
+	parts := s.indexParts()
+	grouped := parts.grouped()
+
+	var hits []searchHit
+
+	add := func(rootName, name string) {
+		info := s.infoMap[rootName]
+		if info.hidden || !s.mayView(ctx, info) {
+			return
+		}
+		title := displayTitle(rootName, info)
+
+		var (
+			best  float64
+			match string
+		)
+		try := func(text string, weight float64, desc string) {
+			if score := weight * matchScore(qwords, text); score > best {
+				best, match = score, desc
+			}
+		}
+		try(title, searchTitleWeight, "title")
+		try(info.OrigTitle, searchTitleWeight, "original title")
+		try(info.SortTitle, searchTitleWeight, "sort title")
+		try(rootName, searchTitleWeight, "filename")
+		for _, d := range info.Directors {
+			try(d, searchDirectorWeight, "director: "+d)
+		}
+		for _, a := range info.Actors {
+			try(a.Name, searchActorWeight, "actor: "+a.Name)
+		}
+		if best == 0 {
+			return
+		}
+
+		prefix := rootNamePrefix(rootName)
+		hits = append(hits, searchHit{
+			Root:    rootName,
+			Path:    escapeItem(prefix + name),
+			NFO:     url.PathEscape(prefix + rootName + ".nfo"),
+			Title:   title,
+			Year:    info.Year,
+			Match:   match,
+			Score:   best,
+			sortKey: titleSortKey(title, info),
+		})
+	}
+
+	s.objNames.Each(func(objName string) {
+		if grouped.Has(objName) || s.isEpisode(objName) || s.isExtra(objName) || s.isDiscObj(objName) {
+			return
+		}
+		ext := filepath.Ext(objName)
+		if !isMediaExt(ext) {
+			return
+		}
+		add(strings.TrimSuffix(objName, ext), objName)
+	})
+	for rootName := range parts {
+		add(rootName, rootName+".m3u")
+	}
+	for _, rootName := range s.discTitles() {
+		add(rootName, rootName+"/")
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].sortKey != hits[j].sortKey {
+			return hits[i].sortKey < hits[j].sortKey
+		}
+		return hits[i].Root < hits[j].Root
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// searchWords splits text into lowercase words for searching,
+// ignoring punctuation.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchScore says how well text matches the words of a query, from 0 to 1.
+// Every word of the query must match some word of the text,
+// exactly (best),
+// as a prefix,
+// or with a typo or two,
+// so "thin man", "thi ma", and "tihn man" all match "The Thin Man".
+func matchScore(qwords []string, text string) float64 {
+	twords := searchWords(text)
+	if len(twords) == 0 {
+		return 0
+	}
+
+	var total float64
+	for _, qw := range qwords {
+		var best float64
+		for _, tw := range twords {
+			if score := wordScore(qw, tw); score > best {
+				best = score
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	score := total / float64(len(qwords))
+
+	// Prefer texts with fewer words besides the matching ones,
+	// so "Top Hat" ranks above "The Top Hat Revue" for "top hat".
+	if extra := len(twords) - len(qwords); extra > 0 {
+		score *= 1 - 0.02*float64(min(extra, 10))
+	}
+	return score
+}
+
+func wordScore(qw, tw string) float64 {
+	switch {
+	case qw == tw:
+		return 1
+	case strings.HasPrefix(tw, qw):
+		return 0.9
+	}
+
+	// Allow one typo in words of four letters or more,
+	// and two in words of eight or more.
+	n := len([]rune(qw))
+	maxDist := 0
+	switch {
+	case n >= 8:
+		maxDist = 2
+	case n >= 4:
+		maxDist = 1
+	}
+	if maxDist == 0 {
+		return 0
+	}
+	d := editDistance(qw, tw)
+	if d > maxDist {
+		// Maybe the typo is in a prefix of the word.
+		if tr := []rune(tw); len(tr) > n {
+			d = editDistance(qw, string(tr[:n]))
+			if d > maxDist {
+				return 0
+			}
+			return 0.8 - 0.1*float64(d)
+		}
+		return 0
+	}
+	return 0.9 - 0.1*float64(d)
+}
+
+// editDistance is the Damerau-Levenshtein (optimal string alignment) distance between a and b,
+// counting a transposition of adjacent letters as one edit.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	d := make([][]int, len(ar)+1)
+	for i := range d {
+		d[i] = make([]int, len(br)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ar[i-1] == br[j-2] && ar[i-2] == br[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ar)][len(br)]
+}
+
+var searchTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+ <head>
+  <title>Search{{ with .Query }}: {{ . }}{{ end }}</title>
+ </head>
+ <body>
+  <form action="/search">
+   <input type="search" name="q" value="{{ .Query }}" autofocus>
+   <input type="submit" value="Search">
+  </form>
+  {{ if .Query }}
+   {{ if .Hits }}
+    <ul>
+     {{ range .Hits }}
+      <li>
+       <a href="/{{ .Path }}">{{ .Title }}{{ with .Year }} ({{ . }}){{ end }}</a>
+       {{ if ne .Match "title" }}<i>{{ .Match }}</i>{{ end }}
+      </li>
+     {{ end }}
+    </ul>
+   {{ else }}
+    <p>No titles match.</p>
+   {{ end }}
+  {{ end }}
+ </body>
+</html>
+`))
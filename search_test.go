@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestSearch(t *testing.T) {
+	s := &server{
+		objNames: set.New("The Thin Man.iso", "Top Hat.mp4", "The Top Hat Revue.mp4", "Secret.mp4"),
+		infoMap: map[string]movieInfo{
+			"The Thin Man": {
+				Title:     "The Thin Man",
+				Year:      1934,
+				Directors: []string{"W.S. Van Dyke"},
+				Actors:    []actor{{Name: "William Powell"}, {Name: "Myrna Loy"}},
+			},
+			"Top Hat":           {Title: "Top Hat", Year: 1935, Actors: []actor{{Name: "Fred Astaire"}}},
+			"The Top Hat Revue": {Title: "The Top Hat Revue"},
+			"Secret":            {Title: "Secret Thin Man", hidden: true},
+		},
+	}
+
+	cases := []struct {
+		q     string
+		roots []string
+		match string
+	}{
+		{q: "thin man", roots: []string{"The Thin Man"}, match: "title"},
+		{q: "tihn", roots: []string{"The Thin Man"}, match: "title"},
+		{q: "top hat", roots: []string{"Top Hat", "The Top Hat Revue"}, match: "title"},
+		{q: "powel", roots: []string{"The Thin Man"}, match: "actor: William Powell"},
+		{q: "astarie", roots: []string{"Top Hat"}, match: "actor: Fred Astaire"},
+		{q: "van dyke", roots: []string{"The Thin Man"}, match: "director: W.S. Van Dyke"},
+		{q: "casablanca"},
+		{q: "  "},
+	}
+	for _, c := range cases {
+		hits := s.search(context.Background(), c.q, searchLimit)
+		if len(hits) != len(c.roots) {
+			t.Errorf("%q: got %+v, want %v", c.q, hits, c.roots)
+			continue
+		}
+		for i, h := range hits {
+			if h.Root != c.roots[i] {
+				t.Errorf("%q: hit %d is %s, want %s", c.q, i, h.Root, c.roots[i])
+			}
+		}
+		if len(hits) > 0 && hits[0].Match != c.match {
+			t.Errorf("%q: got match %q, want %q", c.q, hits[0].Match, c.match)
+		}
+	}
+
+	s.objNamesTime, s.infoMapTime = time.Now(), time.Now()
+	rec := httptest.NewRecorder()
+	if err := s.handleSearch(rec, httptest.NewRequest("GET", "/search?q=thin", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if want := `href="/` + rootNamePrefix("The Thin Man") + `The%20Thin%20Man.iso"`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("search page lacks %s:\n%s", want, rec.Body)
+	}
+}
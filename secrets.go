@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/bobg/errors"
+	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
+)
+
+// Wherever kodigcs takes a secret
+// (a Basic Auth username or password, the serve -pin, an OMDb or Airtable key),
+// it may instead be given a reference to a secret in Google Secret Manager,
+// which is fetched at startup with the service account's credentials.
+// That keeps the secret itself out of command lines, environment variables, and systemd unit files.
+// A reference has one of these forms:
+//
+//	sm://SECRET
+//	sm://SECRET#VERSION
+//	sm://PROJECT/SECRET
+//	sm://PROJECT/SECRET#VERSION
+//	sm://projects/PROJECT/secrets/SECRET/versions/VERSION
+//
+// where PROJECT defaults to the project of the service account
+// and VERSION defaults to latest.
+
+const secretRefPrefix = "sm://"
+
+// The environment variables that may hold secret references.
+var secretEnvVars = []string{passwordEnvVar, "OMDB_API_KEY", "AIRTABLE_API_KEY"}
+
+// secretResolver fetches the secrets named by secret references.
+// Its Secret Manager client is created only when first needed,
+// so that the API need not be enabled for those who don't use it.
+type secretResolver struct {
+	credsFile string
+
+	once    sync.Once
+	svc     *secretmanager.Service
+	project string
+	err     error
+}
+
+func newSecretResolver(credsFile string) *secretResolver {
+	return &secretResolver{credsFile: credsFile}
+}
+
+func isSecretRef(s string) bool {
+	return strings.HasPrefix(s, secretRefPrefix)
+}
+
+// resolve returns val,
+// or, if it is a secret reference,
+// the secret it refers to
+// (with any trailing newline removed).
+func (r *secretResolver) resolve(ctx context.Context, val string) (string, error) {
+	if !isSecretRef(val) {
+		return val, nil
+	}
+
+	r.once.Do(func() {
+		r.svc, r.err = secretmanager.NewService(ctx, option.WithCredentialsFile(r.credsFile), option.WithScopes(secretmanager.CloudPlatformScope))
+		if r.err != nil {
+			r.err = errors.Wrap(r.err, "creating Secret Manager service")
+			return
+		}
+		r.project = credsProject(r.credsFile)
+	})
+	if r.err != nil {
+		return "", r.err
+	}
+
+	name, err := secretVersionName(val, r.project)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.svc.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return "", errors.Wrapf(err, "accessing %s", name)
+	}
+	if resp.Payload == nil {
+		return "", fmt.Errorf("no payload in %s", name)
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", errors.Wrapf(err, "decoding %s", name)
+	}
+
+	secret := strings.TrimRight(string(data), "\r\n")
+	logRedactor.addSecret(secret)
+	return secret, nil
+}
+
+// resolveEnv replaces the secret references in the values of secretEnvVars
+// with the secrets they refer to.
+func (r *secretResolver) resolveEnv(ctx context.Context) error {
+	for _, v := range secretEnvVars {
+		val := os.Getenv(v)
+		if !isSecretRef(val) {
+			continue
+		}
+		secret, err := r.resolve(ctx, val)
+		if err != nil {
+			return errors.Wrapf(err, "resolving $%s", v)
+		}
+		if err := os.Setenv(v, secret); err != nil {
+			return errors.Wrapf(err, "setting $%s", v)
+		}
+	}
+	return nil
+}
+
+// secretVersionName turns a secret reference into the resource name of a secret version,
+// using defaultProject if the reference names none.
+func secretVersionName(ref, defaultProject string) (string, error) {
+	rest := strings.TrimPrefix(ref, secretRefPrefix)
+	if strings.HasPrefix(rest, "projects/") {
+		parts := strings.Split(rest, "/")
+		switch {
+		case len(parts) == 4 && parts[2] == "secrets" && parts[1] != "" && parts[3] != "":
+			return rest + "/versions/latest", nil
+		case len(parts) == 6 && parts[2] == "secrets" && parts[4] == "versions" && parts[1] != "" && parts[3] != "" && parts[5] != "":
+			return rest, nil
+		}
+		return "", fmt.Errorf("malformed secret reference %s", ref)
+	}
+
+	rest, version, ok := strings.Cut(rest, "#")
+	if !ok {
+		version = "latest"
+	}
+	project, secret, ok := strings.Cut(rest, "/")
+	if !ok {
+		project, secret = defaultProject, rest
+	}
+	switch {
+	case secret == "" || version == "" || strings.Contains(secret, "/"):
+		return "", fmt.Errorf("malformed secret reference %s", ref)
+	case project == "":
+		return "", fmt.Errorf("no project in secret reference %s, and none in the credentials file", ref)
+	}
+	return fmt.Sprintf("projects/%s/secrets/%s/versions/%s", project, secret, version), nil
+}
+
+// credsProject returns the project of the service account in the credentials file,
+// or "" if it can't be determined.
+func credsProject(credsFile string) string {
+	content, err := os.ReadFile(credsFile)
+	if err != nil {
+		return ""
+	}
+	var creds struct {
+		ProjectID string `json:"project_id"`
+	}
+	if err := json.Unmarshal(content, &creds); err != nil {
+		return ""
+	}
+	return creds.ProjectID
+}
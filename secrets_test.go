@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretVersionName(t *testing.T) {
+	cases := []struct {
+		ref, project, want string
+	}{
+		{ref: "sm://kodigcs-password", project: "home", want: "projects/home/secrets/kodigcs-password/versions/latest"},
+		{ref: "sm://kodigcs-password#3", project: "home", want: "projects/home/secrets/kodigcs-password/versions/3"},
+		{ref: "sm://other/omdb", project: "home", want: "projects/other/secrets/omdb/versions/latest"},
+		{ref: "sm://other/omdb#2", want: "projects/other/secrets/omdb/versions/2"},
+		{ref: "sm://projects/other/secrets/omdb", want: "projects/other/secrets/omdb/versions/latest"},
+		{ref: "sm://projects/other/secrets/omdb/versions/5", want: "projects/other/secrets/omdb/versions/5"},
+		{ref: "sm://omdb"},
+		{ref: "sm://", project: "home"},
+		{ref: "sm://a/b/c", project: "home"},
+		{ref: "sm://omdb#", project: "home"},
+		{ref: "sm://projects/other/omdb"},
+	}
+	for _, c := range cases {
+		t.Run(c.ref, func(t *testing.T) {
+			got, err := secretVersionName(c.ref, c.project)
+			if c.want == "" {
+				if err == nil {
+					t.Errorf("got %s, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSecretResolver(t *testing.T) {
+	credsFile := filepath.Join(t.TempDir(), "creds.json")
+	if err := os.WriteFile(credsFile, []byte(`{"type": "service_account", "project_id": "home"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := credsProject(credsFile); got != "home" {
+		t.Errorf("got project %q, want home", got)
+	}
+
+	// Values that aren't references are returned as is,
+	// without creating a Secret Manager client.
+	r := newSecretResolver(credsFile)
+	got, err := r.resolve(context.Background(), "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hunter2" || r.svc != nil {
+		t.Errorf("got %q (client %v)", got, r.svc)
+	}
+
+	t.Setenv("OMDB_API_KEY", "plain")
+	if err := r.resolveEnv(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("OMDB_API_KEY"); got != "plain" {
+		t.Errorf("got $OMDB_API_KEY %q", got)
+	}
+}
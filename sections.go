@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+	"google.golang.org/api/sheets/v4"
+)
+
+// sectionsTab is the metadata spreadsheet tab defining homepage sections.
+// Each row has a Section name and the Filename of a title pinned to it.
+// Sections and their titles appear in the order of the rows.
+const sectionsTab = "Sections"
+
+// The virtual directory under which homepage sections are listed.
+const sectionsDir = "sections"
+
+// homeSection is a named list of pinned titles,
+// such as "Halloween picks" or "New this month."
+type homeSection struct {
+	Name   string
+	Titles []string // root names
+}
+
+// readSections reads the homepage sections from the metadata spreadsheet.
+// It is not an error for the spreadsheet to have no Sections tab.
+func readSections(ctx context.Context, ssvc *sheets.SpreadsheetsService, sheetID string) ([]homeSection, error) {
+	ok, err := hasTab(ctx, ssvc, sheetID, sectionsTab)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	resp, err := ssvc.Values.Get(sheetID, quoteTab(sectionsTab)).Context(ctx).Do()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s tab", sectionsTab)
+	}
+	return parseSections(resp.Values)
+}
+
+// parseSections parses the rows of the Sections tab, headings first.
+// Rows with the same Section name are grouped,
+// in the order each section first appears.
+func parseSections(values [][]interface{}) ([]homeSection, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	sectionCol, filenameCol := -1, -1
+	for j, rawheading := range values[0] {
+		heading, _ := rawheading.(string)
+		switch strings.ToLower(strings.TrimSpace(heading)) {
+		case "section":
+			sectionCol = j
+		case "filename":
+			filenameCol = j
+		}
+	}
+	if sectionCol < 0 || filenameCol < 0 {
+		return nil, fmt.Errorf("%s tab needs Section and Filename columns", sectionsTab)
+	}
+
+	var (
+		sections []homeSection
+		index    = make(map[string]int) // section name -> index in sections
+	)
+	for _, row := range values[1:] {
+		if sectionCol >= len(row) || filenameCol >= len(row) {
+			continue
+		}
+		name, _ := row[sectionCol].(string)
+		filename, _ := row[filenameCol].(string)
+		name, filename = strings.TrimSpace(name), strings.TrimSpace(filename)
+		if name == "" || filename == "" {
+			continue
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(sections)
+			index[name] = i
+			sections = append(sections, homeSection{Name: name})
+		}
+		sections[i].Titles = append(sections[i].Titles, strings.TrimSuffix(filename, filepath.Ext(filename)))
+	}
+
+	return sections, nil
+}
+
+// handleSectionDir serves the list of homepage sections (when name is empty)
+// or the titles pinned to one section, in order.
+func (s *server) handleSectionDir(w http.ResponseWriter, req *http.Request, name string) error {
+	log.Printf("serving section directory \"%s\"", name)
+
+	ctx := req.Context()
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if name == "" {
+		var items []template.URL
+		for _, sec := range s.sections {
+			items = append(items, template.URL(url.PathEscape(virtualDirName(sec.Name))+"/"))
+		}
+		return s.writeDir(w, req, items)
+	}
+
+	for _, sec := range s.sections {
+		if virtualDirName(sec.Name) == name {
+			return s.writeDir(w, req, s.sectionItems(sec))
+		}
+	}
+
+	return mid.CodeErr{
+		C:   http.StatusNotFound,
+		Err: fmt.Errorf("no section %s", name),
+	}
+}
+
+// sectionItems returns the directory entries for the titles pinned to a section.
+// The caller must hold s.mu.
+func (s *server) sectionItems(sec homeSection) []template.URL {
+	objNames := make(map[string]string) // root name -> media object name
+	for objName := range s.objNames {
+		ext := filepath.Ext(objName)
+		if isMediaExt(ext) {
+			objNames[strings.TrimSuffix(objName, ext)] = objName
+		}
+	}
+
+	var (
+		items []template.URL
+		files = s.indexTitleFiles()
+		parts = s.indexParts()
+	)
+	for _, rootName := range sec.Titles {
+		prefix := rootNamePrefix(rootName)
+		info, ok := s.infoMap[rootName]
+		if info.hidden {
+			continue
+		}
+		if _, isParts := parts[rootName]; isParts {
+			items = append(items, template.URL(prefix+rootName+".m3u"), template.URL(prefix+rootName+".nfo"))
+			items = append(items, s.titleExtras(rootName, info, files)...)
+			continue
+		}
+		objName, ok := objNames[rootName]
+		if !ok {
+			log.Printf("Section %s: no object for %s", sec.Name, rootName)
+			continue
+		}
+		items = append(items, template.URL(prefix+objName), template.URL(prefix+rootName+".nfo"))
+		items = append(items, s.titleExtras(rootName, info, files)...)
+	}
+	return items
+}
+
+// apiSection describes a homepage section for JSON clients of the server.
+type apiSection struct {
+	Name   string   `json:"name"`
+	Path   string   `json:"path"`   // the section's virtual folder
+	Titles []string `json:"titles"` // root names, as in apiTitle.Root
+}
+
+// handleAPISections serves the homepage sections as JSON.
+func (s *server) handleAPISections(w http.ResponseWriter, req *http.Request) error {
+	if err := s.ensureInfoMap(req.Context()); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sections := []apiSection{}
+	for _, sec := range s.sections {
+		sections = append(sections, apiSection{
+			Name:   sec.Name,
+			Path:   sectionsDir + "/" + url.PathEscape(virtualDirName(sec.Name)) + "/",
+			Titles: sec.Titles,
+		})
+	}
+	return mid.RespondJSON(w, sections)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestParseSections(t *testing.T) {
+	cases := []struct {
+		name    string
+		values  [][]interface{}
+		want    []homeSection
+		wantErr bool
+	}{{
+		name: "empty",
+	}, {
+		name:   "headings only",
+		values: [][]interface{}{{"Section", "Filename"}},
+	}, {
+		name:    "missing column",
+		values:  [][]interface{}{{"Section", "Title"}, {"Halloween", "Dracula.mkv"}},
+		wantErr: true,
+	}, {
+		name: "grouped in order of first appearance",
+		values: [][]interface{}{
+			{"Filename", " section "},
+			{"Dracula.mkv", "Halloween"},
+			{"Top Hat.mp4", "New this month"},
+			{"Frankenstein.mkv", "Halloween"},
+		},
+		want: []homeSection{
+			{Name: "Halloween", Titles: []string{"Dracula", "Frankenstein"}},
+			{Name: "New this month", Titles: []string{"Top Hat"}},
+		},
+	}, {
+		name: "blank and short rows skipped",
+		values: [][]interface{}{
+			{"Section", "Notes", "Filename"},
+			{"Halloween"},
+			{"", "", "Dracula.mkv"},
+			{"Halloween", "", "  "},
+			{" Halloween ", "", " The Wolf Man.mkv "},
+		},
+		want: []homeSection{
+			{Name: "Halloween", Titles: []string{"The Wolf Man"}},
+		},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseSections(c.values)
+			if c.wantErr {
+				if err == nil {
+					t.Error("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSectionDirs(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		dirTemplate:  template.Must(template.New("").Parse(dirTemplate)),
+		objNames:     set.New("Dracula.mkv", "Frankenstein.mkv", "Top Hat.mp4", "Freaks.mkv"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"Dracula":      {Title: "Dracula"},
+			"Frankenstein": {Title: "Frankenstein"},
+			"Top Hat":      {Title: "Top Hat"},
+			"Freaks":       {Title: "Freaks", hidden: true},
+		},
+		infoMapTime: now,
+		sections: []homeSection{
+			{Name: "Halloween/Horror", Titles: []string{"Frankenstein", "Freaks", "Nosferatu", "Dracula"}},
+			{Name: "New this month", Titles: []string{"Top Hat"}},
+		},
+	}
+
+	check := func(path string, want ...string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := s.handleAPIDir(rec, httptest.NewRequest("GET", "/api/dir/"+path, nil)); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		var got apiDir
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Entries) != len(want) {
+			t.Fatalf("%s: got %+v, want %v", path, got.Entries, want)
+		}
+		for i, e := range got.Entries {
+			if e.Name != want[i] {
+				t.Errorf("%s: entry %d is %s, want %s", path, i, e.Name, want[i])
+			}
+		}
+	}
+
+	check("sections/", "Halloween-Horror", "New this month")
+
+	// Titles keep the spreadsheet's order;
+	// hidden titles and titles with no object are left out.
+	var (
+		frankenstein = rootNamePrefix("Frankenstein")
+		dracula      = rootNamePrefix("Dracula")
+	)
+	check("sections/Halloween-Horror/", frankenstein+"Frankenstein.mkv", frankenstein+"Frankenstein.nfo", dracula+"Dracula.mkv", dracula+"Dracula.nfo")
+
+	rec := httptest.NewRecorder()
+	if err := s.handleAPISections(rec, httptest.NewRequest("GET", "/api/sections", nil)); err != nil {
+		t.Fatal(err)
+	}
+	var got []apiSection
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []apiSection{
+		{Name: "Halloween/Horror", Path: "sections/Halloween-Horror/", Titles: []string{"Frankenstein", "Freaks", "Nosferatu", "Dracula"}},
+		{Name: "New this month", Path: "sections/New%20this%20month/", Titles: []string{"Top Hat"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := s.handleAPIDir(rec, httptest.NewRequest("GET", "/api/dir/sections/Thanksgiving/", nil)); errorCode(err) != 404 {
+		t.Errorf("got error %v for an unknown section, want 404", err)
+	}
+}
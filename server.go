@@ -1,33 +1,115 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"html/template"
+	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/bobg/go-generics/v4/set"
+	"golang.org/x/crypto/acme/autocert"
 	"google.golang.org/api/sheets/v4"
 )
 
+// objHead is what a response to a HEAD request for an object says about it.
+type objHead struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
 type server struct {
-	ssvc   *sheets.SpreadsheetsService
-	bucket *storage.BucketHandle
+	ssvc       *sheets.SpreadsheetsService
+	bucket     *storage.BucketHandle
+	bucketName string
 
 	sheetID string
+	meta    metadataSource // from sheetID
 
 	dirTemplate *template.Template
 
-	listenAddr         string
-	username, password string
+	listenAddr string
+	pageSize   int
+	kodiHosts  []string // JSON-RPC endpoints of Kodi boxes to notify of changes
+	webhooks   []webhook
+	auth       authenticator
+
+	stats   *accessStats
+	grants  *grantStore
+	watched *watchStore
+	health  *healthCounters
+	auto    *autoMeta // for the sheet-free mode, or nil
+
+	transcoder *transcoder // for serve -transcode, or nil
+
+	cfgMu       sync.RWMutex              // protects access and verbose, which reloadConfig can change
+	access      map[string]set.Of[string] // subdir -> users allowed in it, from serve -access (see access.go)
+	reloader    *reloader                 // for serve -config and SIGHUP (see reload.go)
+	ipFilter    *ipFilter                 // from serve -allow-cidr and -deny-cidr, or nil
+	throttle    *clientThrottle           // from serve -rate and -max-auth-failures, or nil
+	clientCAs   *x509.CertPool            // from serve -client-ca, or nil
+	acme        *autocert.Manager         // from serve -acme-domain, or nil
+	pin         string                    // from serve -pin (see pin.go)
+	corsOrigins set.Of[string]            // from serve -cors-origins (see headers.go)
+	audit       *auditLog                 // from serve -audit, or nil
+	login       bool                      // from serve -login (see login.go)
+	admins      set.Of[string]            // from serve -admins (see admin.go)
+	oidc        *oidcLogin                // from serve -oidc-issuer, or nil
+	proxies     []netip.Prefix            // from serve -trusted-proxies (see proxy.go)
+	pinGuard    pinGuard
+	readiness   readiness // see health.go
+
+	// See drain.go.
+	drainTimeout time.Duration // from serve -drain-timeout
+	draining     atomic.Bool
+	streams      atomic.Int64 // in progress
+
+	watchdog    *watchdog      // from serve -alert-latency and -alert-errors, or nil
+	errReporter *errorReporter // from serve -error-dsn, or nil (see errreport.go)
+
+	// See handoff.go.
+	reusePort bool         // from serve -reuseport
+	socket    net.Listener // from systemd socket activation, or nil
 
 	subdirs bool
-	verbose bool
+	sets    bool
+	genres  bool
+	years   bool
+	letters bool
+	tv      bool
+	verbose bool // protected by cfgMu
 	tls     bool
+	tracing bool // from serve -otlp-endpoint (see tracing.go)
+
+	// The attributes of the objects in the bucket as of the last loadObjNames,
+	// for answering HEAD requests without consulting GCS.
+	// This is replaced, not modified, when reloaded,
+	// and needs no lock.
+	objHeads atomic.Pointer[map[string]objHead]
+
+	certMu          sync.RWMutex // protects the following cert* fields
+	cert            *tls.Certificate
+	certStatus      *certStatus
+	certInstalled   time.Time
+	certCmdRestarts int
 
 	mu           sync.RWMutex // protects all of the following
 	objNames     set.Of[string]
+	objCreated   map[string]time.Time
+	objSize      map[string]int64
+	storageBytes int64 // the total size of the objects in the bucket
 	objNamesTime time.Time
 	infoMap      map[string]movieInfo
+	sections     []homeSection
+	series       map[string]*tvSeries // from the Series tab, keyed by show folder
 	infoMapTime  time.Time
+
+	// Whether watchSource is reloading the info map when the metadata changes,
+	// so that it goes stale only after watchedStaleTime.
+	sourceWatched bool
 }
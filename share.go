@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+const (
+	// How long a share link lasts if the request for it doesn't say.
+	defaultShareTTL = 72 * time.Hour
+
+	// The longest a share link may last.
+	maxShareTTL = 30 * 24 * time.Hour
+)
+
+// shareResponse is the response to a request for a share link.
+type shareResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// handleShare issues a share link for a title,
+// in response to a POST to /api/share/ROOTNAME
+// with an optional ttl parameter (a duration, such as 48h).
+// The link is to the title's player page (see handleWatch),
+// which anyone with the link can use,
+// without credentials,
+// until it expires or is revoked at /admin/grants.
+func (s *server) handleShare(w http.ResponseWriter, req *http.Request) error {
+	if err := checkSameOrigin(req); err != nil {
+		return err
+	}
+
+	var (
+		ctx      = req.Context()
+		rootName = req.PathValue("rootname")
+		ttl      = defaultShareTTL
+	)
+	if t := req.FormValue("ttl"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 || d > maxShareTTL {
+			return mid.CodeErr{
+				C:   http.StatusBadRequest,
+				Err: fmt.Errorf("bad ttl %q (want a duration up to %s)", t, maxShareTTL),
+			}
+		}
+		ttl = d
+	}
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	info := s.infoMap[rootName]
+	objName, ok := s.mediaObjName(rootName)
+	ok = ok && !info.hidden && !s.isEpisode(objName) && !s.isExtra(objName)
+	// A share link covers the files named for its title,
+	// which the parts of a multi-part title and the contents of a disc folder are not.
+	single := info.parts == "" && !s.isDiscObj(objName)
+	accessErr := s.titleAccessErr(ctx, rootName)
+	s.mu.RUnlock()
+
+	if !ok {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no title %s", rootName),
+		}
+	}
+	if accessErr != nil {
+		return accessErr
+	}
+	if !single {
+		return mid.CodeErr{
+			C:   http.StatusNotImplemented,
+			Err: fmt.Errorf("cannot share %s, which is not a single object", rootName),
+		}
+	}
+
+	secret, g, err := s.grants.issue(grantShare, "share:"+principal(ctx), rootName, ttl)
+	if err != nil {
+		return errors.Wrap(err, "issuing share link")
+	}
+	log.Printf("%s shared %s until %s (grant %s)", principal(ctx), rootName, g.Expires.Format(time.RFC3339), g.ID)
+
+	u := s.baseURL(req).JoinPath("watch", rootName)
+	u.RawQuery = url.Values{"share": {secret}}.Encode()
+	return mid.RespondJSON(w, shareResponse{URL: u.String(), Expires: g.Expires})
+}
+
+// withShare adds the share parameter of req, if any, to the server-relative URL src,
+// so that the player page of a share link can fetch the title's files.
+func withShare(req *http.Request, src string) string {
+	secret := req.URL.Query().Get("share")
+	if secret == "" || !strings.HasPrefix(src, "/") {
+		return src
+	}
+	sep := "?"
+	if strings.Contains(src, "?") {
+		sep = "&"
+	}
+	return src + sep + url.Values{"share": {secret}}.Encode()
+}
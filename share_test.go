@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+func TestShare(t *testing.T) {
+	now := time.Now()
+	grants := &grantStore{
+		grants:  make(map[string]*grant),
+		known:   set.New[string](),
+		deleted: set.New[string](),
+	}
+	s := &server{
+		objNames:     set.New("Top Hat.mp4", "Top Hat.en.vtt", "Swing Time.mp4", "Napoleon/part1.m4v"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"Top Hat":  {Title: "Top Hat", Year: 1935},
+			"Napoleon": {Title: "Napoléon", parts: "Napoleon/part*.m4v"},
+		},
+		infoMapTime: now,
+		grants:      grants,
+		auth: anyAuth{
+			basicAuth{username: "alice", password: "secret"},
+			shareAuth{grants: grants},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /watch/{rootname...}", mid.Err(s.authed(s.handleWatch)))
+	mux.Handle("POST /api/share/{rootname...}", mid.Err(s.authed(s.handleShare)))
+	do := func(method, target string, creds bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if creds {
+			req.SetBasicAuth("alice", "secret")
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("POST", "/api/share/Top%20Hat", false); rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d sharing without credentials", rec.Code)
+	}
+	if rec := do("POST", "/api/share/Top%20Hat?ttl=1000h", true); rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for a long ttl", rec.Code)
+	}
+	if rec := do("POST", "/api/share/Napoleon", true); rec.Code != http.StatusNotImplemented {
+		t.Errorf("got status %d sharing a multi-part title", rec.Code)
+	}
+
+	rec := do("POST", "/api/share/Top%20Hat?ttl=48h", true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d sharing Top Hat", rec.Code)
+	}
+	var resp shareResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(resp.Expires); d < 47*time.Hour || d > 48*time.Hour {
+		t.Errorf("link expires in %s, want 48h", d)
+	}
+	u, err := url.Parse(resp.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/watch/Top Hat" || !u.Query().Has("share") {
+		t.Fatalf("got share URL %s", resp.URL)
+	}
+
+	rec = do("GET", u.RequestURI(), false)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d for the shared player page", rec.Code)
+	}
+
+	// The page's media and subtitles carry the share link too.
+	srcs := regexp.MustCompile(`src="([^"]*)"`).FindAllStringSubmatch(rec.Body.String(), -1)
+	if len(srcs) < 2 {
+		t.Fatalf("got %d srcs on the player page", len(srcs))
+	}
+	for _, m := range srcs {
+		src := html.UnescapeString(m[1])
+		if !strings.Contains(src, "share=") {
+			t.Errorf("%s lacks the share link", src)
+			continue
+		}
+		req := httptest.NewRequest("GET", src, nil)
+		if _, err := s.auth.authenticate(httptest.NewRecorder(), req); err != nil {
+			t.Errorf("share link does not cover %s: %s", src, err)
+		}
+	}
+
+	other := "/watch/Swing%20Time?" + u.RawQuery
+	if rec := do("GET", other, false); rec.Code == http.StatusOK {
+		t.Errorf("got status %d using the share link for another title", rec.Code)
+	}
+}
@@ -8,8 +8,11 @@ import (
 	"log"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -17,29 +20,28 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/bobg/errors"
 	"golang.org/x/time/rate"
-	"google.golang.org/api/sheets/v4"
 )
 
-func handleSheet(sheetsSvc *sheets.SpreadsheetsService, sheetID string, f func(rownum int, headings []string, name string, row []interface{}) error) error {
-	resp, err := sheetsSvc.Values.Get(sheetID, "Sheet1!A:Z").Do()
+func handleSheet(ctx context.Context, src metadataSource, f func(rownum int, headings []string, name string, row []interface{}) error) error {
+	values, err := src.rows(ctx)
 	if err != nil {
-		return errors.Wrap(err, "reading spreadsheet data")
+		return err
 	}
-	if len(resp.Values) < 2 {
-		return fmt.Errorf("got %d spreadsheet row(s), want 2 or more", len(resp.Values))
+	if len(values) < 2 {
+		return fmt.Errorf("got %d spreadsheet row(s), want 2 or more", len(values))
 	}
 
 	var headings []string
-	for _, rawheading := range resp.Values[0] {
+	for _, rawheading := range values[0] {
 		if heading, ok := rawheading.(string); ok {
-			headings = append(headings, strings.ToLower(heading))
+			headings = append(headings, headingAliases().canonical(heading))
 		} else {
 			headings = append(headings, "")
 		}
 	}
 
-	for i := 1; i < len(resp.Values); i++ {
-		row := resp.Values[i]
+	for i := 1; i < len(values); i++ {
+		row := values[i]
 		if len(row) < 2 {
 			continue
 		}
@@ -58,36 +60,114 @@ func handleSheet(sheetsSvc *sheets.SpreadsheetsService, sheetID string, f func(r
 	return nil
 }
 
-func updateSpreadsheet(ctx context.Context, ssvc *sheets.SpreadsheetsService, bucket *storage.BucketHandle, htmldir, sheetID string) error {
+// Retries of failed requests while scraping.
+const (
+	defaultScrapeInterval = 10 * time.Second
+
+	scrapeRetries = 3
+	scrapeBackoff = 5 * time.Second
+)
+
+// updateSummary tallies what updateSpreadsheet did.
+type updateSummary struct {
+	rows    int   // rows examined
+	updated int   // rows with cells filled in
+	failed  []int // spreadsheet row numbers
+}
+
+func updateSpreadsheet(ctx context.Context, src writableSource, bucket *storage.BucketHandle, htmldir, scraperNames, omdbKey string, transport http.RoundTripper, scrapeInterval time.Duration, mirrorHeadshots, refetch, guess bool, resumeFrom int) (summary updateSummary, err error) {
 	var (
-		httpLimiter = rate.NewLimiter(rate.Every(10*time.Second), 1)
+		httpLimiter = rate.NewLimiter(rate.Every(scrapeInterval), 1)
+		imgLimiter  = rate.NewLimiter(rate.Every(time.Second), 1)
 		ssLimiter   = rate.NewLimiter(rate.Every(time.Second), 1)
 	)
 
 	cl := &http.Client{
-		Transport: &limitedTransport{
-			limiter:   httpLimiter,
-			transport: http.DefaultTransport,
+		Transport: &retryTransport{
+			retries: scrapeRetries,
+			backoff: scrapeBackoff,
+			transport: &limitedTransport{
+				limiter:   httpLimiter,
+				transport: transport,
+			},
+		},
+	}
+
+	fetcher := &imdbFetcher{cl: cl, bucket: bucket, refetch: refetch}
+
+	scrapers, err := newScrapers(scraperNames, scraperConfig{cl: cl, bucket: bucket, refetch: refetch, omdbKey: omdbKey, transport: &retryTransport{retries: scrapeRetries, backoff: scrapeBackoff, transport: transport}})
+	if err != nil {
+		return summary, err
+	}
+
+	// Headshots come from an image CDN, not from IMDb proper,
+	// and there are many of them per title,
+	// so they get a more permissive limiter.
+	imgcl := &http.Client{
+		Transport: &retryTransport{
+			retries: scrapeRetries,
+			backoff: scrapeBackoff,
+			transport: &limitedTransport{
+				limiter:   imgLimiter,
+				transport: transport,
+			},
 		},
 	}
 
-	ssSet := func(cell, val string) error {
+	var extraHeadings []string // columns added to a Google spreadsheet during the run
+
+	// setCells fills in cells of a title that are still empty.
+	// Scraping a title takes a while,
+	// during which someone may edit the metadata,
+	// so the title's cells are reread first,
+	// and any that are no longer empty are left alone.
+	// The return value is the cells that were set.
+	setCells := func(rownum int, headings []string, name string, cells map[string]string) (map[string]string, error) {
 		if err := ssLimiter.Wait(ctx); err != nil {
-			return errors.Wrap(err, "waiting for ssLimiter")
+			return nil, errors.Wrap(err, "waiting for ssLimiter")
+		}
+
+		ss, isSheet := src.(sheetsSource)
+
+		var current map[string]string
+		if isSheet {
+			// Cheaper than ss.getCells, which must find the row.
+			headings = slices.Concat(headings, extraHeadings)
+			row, err := ss.readRow(ctx, rownum)
+			if err != nil {
+				return nil, err
+			}
+			if len(row) == 0 || row[0] != name {
+				return nil, fmt.Errorf("row %d no longer holds %s (rows added or removed during the run?)", rownum+1, name)
+			}
+			current = rowCells(headings, row)
+		} else {
+			var err error
+			current, err = src.getCells(ctx, name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "rereading %s", name)
+			}
+		}
+
+		cells = unfilledCells(name, cells, current)
+		if len(cells) == 0 {
+			return nil, nil
 		}
-		vr := &sheets.ValueRange{
-			Range:  cell,
-			Values: [][]interface{}{{val}},
+
+		if isSheet {
+			// Cheaper than ss.setCells, which must find the row.
+			added, err := ss.writeRow(ctx, rownum, headings, name, cells)
+			extraHeadings = append(extraHeadings, added...)
+			return cells, err
 		}
-		_, err := ssvc.Values.Update(sheetID, cell, vr).Context(ctx).ValueInputOption("RAW").Do()
-		return errors.Wrap(err, "updating cell %s in spreadsheet")
+		return cells, src.setCells(ctx, name, cells)
 	}
 
-	return handleSheet(ssvc, sheetID, func(rownum int, headings []string, name string, row []interface{}) error {
+	updateRow := func(rownum int, headings []string, name string, row []interface{}) error {
 		var needLookup bool
 		for j, heading := range headings {
 			switch heading {
-			case "actors", "directors", "genre", "poster", "year", "plot", "runtime":
+			case "actors", "actorthumbs", "directors", "genre", "poster", "year", "premiered", "plot", "outline", "tagline", "runtime", "mpaa", "country", "language", "studio", "imdbrating", "rottentomatoes", "metacritic", "top250", "awards":
 				if j >= len(row) {
 					needLookup = true
 				} else {
@@ -108,7 +188,7 @@ func updateSpreadsheet(ctx context.Context, ssvc *sheets.SpreadsheetsService, bu
 		}
 
 		var (
-			info *imdbInfo
+			info *titleInfo
 			err  error
 		)
 
@@ -132,32 +212,81 @@ func updateSpreadsheet(ctx context.Context, ssvc *sheets.SpreadsheetsService, bu
 		}
 
 		if info == nil {
-			var id string
+			var (
+				id, title string
+				year      int
+			)
 			for j, heading := range headings {
 				if j >= len(row) {
 					break
 				}
-				if heading != "imdbid" {
-					continue
-				}
 				val, ok := row[j].(string)
 				if !ok {
-					return nil
+					continue
+				}
+				val = strings.TrimSpace(val)
+				switch heading {
+				case "imdbid":
+					id = parseIMDbID(val)
+				case "title":
+					title = val
+				case "year":
+					year, _ = strconv.Atoi(val)
+				}
+			}
+			if id == "" && guess {
+				if title == "" {
+					title = strings.TrimSuffix(name, filepath.Ext(name))
+				}
+				id, err = guessID(ctx, scrapers, name, title, year)
+				if err != nil {
+					return err
+				}
+				if id != "" {
+					// This adds an IMDbID column if there isn't one.
+					written, err := setCells(rownum, headings, name, map[string]string{"imdbid": id})
+					if err != nil {
+						return errors.Wrapf(err, "setting IMDb ID of %s to %s", name, id)
+					}
+					if written["imdbid"] == "" {
+						// Someone else supplied an ID meanwhile; leave the row for the next run.
+						return nil
+					}
 				}
-				id = parseIMDbID(val)
 			}
 			if id == "" {
 				return nil
 			}
 
-			log.Printf("Getting IMDb info for %s...", name)
+			log.Printf("Getting info for %s (row %d)...", name, rownum+1)
 
-			info, err = parseIMDbPage(cl, id)
+			info, err = scrapers.LookupByID(ctx, id)
+			if errors.Is(err, errNotFound) {
+				log.Printf("No info found for %s (id %s)", name, id)
+				return nil
+			}
 			if err != nil {
-				return errors.Wrapf(err, "getting IMDb info for %s (id %s)", name, id)
+				return errors.Wrapf(err, "getting info for %s (id %s)", name, id)
 			}
 		}
 
+		if mirrorHeadshots {
+			for _, a := range info.Actors {
+				u := info.Headshots[a]
+				if u == "" {
+					continue
+				}
+				objName := headshotObjName(a, urlExt(u))
+				if err := uploadURL(ctx, bucket.Object(objName), imgcl, u, false); err != nil {
+					return errors.Wrapf(err, "mirroring headshot for %s", a)
+				}
+			}
+		}
+
+		var (
+			cells   = info.cells()
+			updates = make(map[string]string)
+		)
 		for j, heading := range headings {
 			if j == 0 {
 				continue
@@ -175,69 +304,77 @@ func updateSpreadsheet(ctx context.Context, ssvc *sheets.SpreadsheetsService, bu
 				continue
 			}
 
-			cell := cellName(rownum, j)
-
-			switch heading {
-			case "actors":
-				newval := strings.Join(info.Actors, "; ")
-				err = ssSet(cell, newval)
-				if err != nil {
-					return errors.Wrapf(err, "setting %s to %s", cell, newval)
-				}
-
-			case "directors":
-				newval := strings.Join(info.Directors, "; ")
-				err = ssSet(cell, newval)
-				if err != nil {
-					return errors.Wrapf(err, "setting %s to %s", cell, newval)
-				}
-
-			case "genre":
-				newval := strings.Join(info.Genres, "; ")
-				err = ssSet(cell, newval)
-				if err != nil {
-					return errors.Wrapf(err, "setting %s to %s", cell, newval)
-				}
+			if newval := cells[heading]; newval != "" {
+				updates[heading] = newval
+			}
+		}
+		if len(updates) == 0 {
+			return nil
+		}
 
-			case "poster":
-				if info.Image == "" {
-					continue
-				}
-				if err = ssSet(cell, info.Image); err != nil {
-					return errors.Wrapf(err, "setting %s to %s", cell, info.Image)
-				}
-				if err = uploadPoster(ctx, bucket, cl, info.Image, name, false); err != nil {
-					return errors.Wrapf(err, "uploading poster for %s", name)
-				}
+		updates, err = setCells(rownum, headings, name, updates)
+		if err != nil {
+			return errors.Wrapf(err, "updating %s", name)
+		}
+		if len(updates) > 0 {
+			summary.updated++
+		}
+		if poster := updates["poster"]; poster != "" {
+			if err := uploadPoster(ctx, bucket, cl, poster, name, false); err != nil {
+				return errors.Wrapf(err, "uploading poster for %s", name)
+			}
+		}
 
-			case "year":
-				parts := strings.Split(info.DatePublished, "-")
-				if len(parts) != 3 {
-					continue
-				}
-				err = ssSet(cell, parts[0])
-				if err != nil {
-					return errors.Wrapf(err, "setting %s to %s", cell, parts[0])
-				}
+		return nil
+	}
 
-			case "plot":
-				err = ssSet(cell, info.Summary)
-				if err != nil {
-					return errors.Wrapf(err, "setting %s to plot summary", cell)
-				}
+	err = handleSheet(ctx, src, func(rownum int, headings []string, name string, row []interface{}) error {
+		if rownum+1 < resumeFrom {
+			return nil
+		}
+		summary.rows++
 
-			case "runtime":
-				if info.RuntimeMins > 0 {
-					err = ssSet(cell, strconv.Itoa(info.RuntimeMins))
-					if err != nil {
-						return errors.Wrapf(err, "setting %s to runtime of %d", cell, info.RuntimeMins)
-					}
-				}
-			}
+		err := updateRow(rownum, headings, name, row)
+		if err == nil || ctx.Err() != nil {
+			return err
 		}
 
+		// Don't let one bad row stop a long run.
+		log.Printf("Error in row %d (%s), continuing: %s", rownum+1, name, err)
+		summary.failed = append(summary.failed, rownum+1)
 		return nil
 	})
+	if err != nil {
+		return summary, err
+	}
+
+	if ss, ok := src.(sheetsSource); ok {
+		// Only a Google spreadsheet can have a Series tab.
+		if err := updateEpisodes(ctx, ss, fetcher, ssLimiter, htmldir); err != nil {
+			return summary, err
+		}
+	}
+
+	if len(summary.failed) > 0 {
+		return summary, fmt.Errorf("%d row(s) failed: %v", len(summary.failed), summary.failed)
+	}
+	return summary, nil
+}
+
+// unfilledCells returns the members of cells that are empty in current,
+// the current cells of the named title.
+// Any others are logged as conflicts.
+func unfilledCells(name string, cells, current map[string]string) map[string]string {
+	result := make(map[string]string)
+	for heading, val := range cells {
+		cur := strings.TrimSpace(current[heading])
+		if cur == "" {
+			result[heading] = val
+		} else if cur != val {
+			log.Printf("Conflict in %s of %s: not replacing %q with %q", heading, name, cur, val)
+		}
+	}
+	return result
 }
 
 func uploadPoster(ctx context.Context, bucket *storage.BucketHandle, cl *http.Client, url, name string, force bool) error {
@@ -254,6 +391,16 @@ func uploadPoster(ctx context.Context, bucket *storage.BucketHandle, cl *http.Cl
 		return nil
 	}
 
+	log.Printf("Uploading poster for %s...", name)
+
+	return uploadURL(ctx, obj, cl, url, force)
+}
+
+// uploadURL copies the content at url into the given object,
+// unless the object already exists and force is false.
+func uploadURL(ctx context.Context, obj *storage.ObjectHandle, cl *http.Client, url string, force bool) error {
+	objName := obj.ObjectName()
+
 	if !force {
 		// Does obj already exist?
 		_, err := obj.Attrs(ctx)
@@ -280,8 +427,6 @@ func uploadPoster(ctx context.Context, bucket *storage.BucketHandle, cl *http.Cl
 		return fmt.Errorf("getting %s: %s", url, resp.Status)
 	}
 
-	log.Printf("Uploading poster for %s...", name)
-
 	w := obj.NewWriter(ctx)
 	defer w.Close()
 
@@ -299,6 +444,20 @@ func uploadPoster(ctx context.Context, bucket *storage.BucketHandle, cl *http.Cl
 	return errors.Wrap(err, "closing GCS writer")
 }
 
+// headshotObjName is the name of the bucket object holding a mirrored headshot of the given actor.
+func headshotObjName(actor, ext string) string {
+	return "actors/" + strings.ReplaceAll(actor, "/", "-") + ext
+}
+
+// urlExt is the extension of the path in the URL u,
+// ignoring any query or fragment.
+func urlExt(u string) string {
+	if parsed, err := url.Parse(u); err == nil {
+		return path.Ext(parsed.Path)
+	}
+	return filepath.Ext(u)
+}
+
 // Row and col are both zero-based.
 func cellName(row, col int) string {
 	return fmt.Sprintf("%s%d", colName(col), row+1)
@@ -310,3 +469,12 @@ func colName(col int) string {
 	}
 	return colName(col/26-1) + colName(col%26)
 }
+
+// colIndex is the inverse of colName.
+func colIndex(name string) int {
+	var col int
+	for _, c := range name {
+		col = col*26 + int(c-'A') + 1
+	}
+	return col - 1
+}
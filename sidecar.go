@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+)
+
+// A sidecar is an object in the bucket named ROOTNAME.kodigcs.json
+// whose fields override the metadata spreadsheet row for the title with that root name,
+// as in
+//
+//	{"title": "The Thin Man", "subdir": "Mysteries", "poster": "https://...", "hidden": true}
+//
+// The fields are metadata spreadsheet headings,
+// with values as in a manifest (see manifestSource).
+const sidecarSuffix = ".kodigcs.json"
+
+// readSidecars reads the sidecars among the given object names,
+// returning their fields keyed by the root name of their titles
+// and then by lowercase heading.
+// Sidecars that cannot be read are logged and skipped.
+func readSidecars(ctx context.Context, bucket *storage.BucketHandle, objNames set.Of[string]) map[string]map[string]string {
+	result := make(map[string]map[string]string)
+	for objName := range objNames {
+		rootName, ok := strings.CutSuffix(objName, sidecarSuffix)
+		if !ok {
+			continue
+		}
+		fields, err := readSidecar(ctx, bucket.Object(objName))
+		if err != nil {
+			log.Printf("Error reading sidecar %s: %s", objName, err)
+			continue
+		}
+		result[rootName] = fields
+	}
+	return result
+}
+
+func readSidecar(ctx context.Context, obj *storage.ObjectHandle) (map[string]string, error) {
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening")
+	}
+	defer r.Close()
+
+	var raw map[string]any
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, errors.Wrap(err, "decoding")
+	}
+
+	fields := make(map[string]string)
+	for k, v := range raw {
+		k = headingAliases().canonical(k)
+		if k == "filename" {
+			continue
+		}
+		fields[k] = manifestValue(v)
+	}
+	return fields, nil
+}
+
+// applySidecar returns the headings and row of a metadata spreadsheet row
+// with the cells in fields replaced or, if their columns are absent, added.
+// It does not modify its arguments.
+func applySidecar(headings []string, row []interface{}, fields map[string]string) ([]string, []interface{}) {
+	headings = append([]string(nil), headings...)
+	row = append([]interface{}(nil), row...)
+
+	index := make(map[string]int)
+	for i, h := range headings {
+		index[h] = i
+	}
+	for heading, val := range fields {
+		i, ok := index[heading]
+		if !ok {
+			i = len(headings)
+			headings = append(headings, heading)
+			index[heading] = i
+		}
+		for len(row) <= i {
+			row = append(row, "")
+		}
+		row[i] = val
+	}
+	return headings, row
+}
+
+// sidecarOnly returns the names of the media objects
+// whose titles have sidecars but are not in the info map,
+// so that a sidecar can supply a title's metadata by itself.
+// The caller must hold s.mu.
+func (s *server) sidecarOnly(sidecars map[string]map[string]string) []string {
+	var result []string
+	for objName := range s.objNames {
+		ext := filepath.Ext(objName)
+		if !isMediaExt(ext) || s.isExtra(objName) || s.isDiscObj(objName) {
+			continue
+		}
+		rootName := strings.TrimSuffix(objName, ext)
+		if _, ok := s.infoMap[rootName]; ok {
+			continue
+		}
+		if _, ok := sidecars[rootName]; !ok {
+			continue
+		}
+		result = append(result, objName)
+	}
+	return result
+}
+
+// isTrue tells whether a metadata spreadsheet cell holds a true value,
+// such as "true", "yes", or "x".
+func isTrue(val string) bool {
+	if b, err := strconv.ParseBool(val); err == nil {
+		return b
+	}
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "yes", "y", "x":
+		return true
+	}
+	return false
+}
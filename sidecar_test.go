@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplySidecar(t *testing.T) {
+	var (
+		headings = []string{"filename", "title", "subdir"}
+		row      = []interface{}{"thin man.iso", "Thin Man"}
+		fields   = map[string]string{
+			"title":  "The Thin Man",
+			"subdir": "Mysteries",
+			"poster": "https://example.com/thinman.jpg",
+			"hidden": "true",
+		}
+	)
+
+	gotHeadings, gotRow := applySidecar(headings, row, fields)
+
+	if want := []string{"filename", "title", "subdir"}; !reflect.DeepEqual(headings, want) {
+		t.Errorf("headings modified: got %v, want %v", headings, want)
+	}
+	if want := []interface{}{"thin man.iso", "Thin Man"}; !reflect.DeepEqual(row, want) {
+		t.Errorf("row modified: got %v, want %v", row, want)
+	}
+
+	s := &server{listenAddr: "example.com"}
+	rootName, info := s.parseInfoRow(gotHeadings, "thin man.iso", gotRow)
+	if rootName != "thin man" {
+		t.Errorf("got root name %q, want %q", rootName, "thin man")
+	}
+	if info.Title != "The Thin Man" {
+		t.Errorf("got title %q, want %q", info.Title, "The Thin Man")
+	}
+	if info.subdir != "Mysteries" {
+		t.Errorf("got subdir %q, want %q", info.subdir, "Mysteries")
+	}
+	if !info.hidden {
+		t.Error("got hidden false, want true")
+	}
+	if len(info.Thumbs) != 1 || info.Thumbs[0].Aspect != "poster" || info.Thumbs[0].origVal != fields["poster"] {
+		t.Errorf("got thumbs %+v, want one poster", info.Thumbs)
+	}
+}
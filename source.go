@@ -0,0 +1,583 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/sheets/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// A metadataSource supplies the rows of the title metadata table.
+// The first row is the headings,
+// and the first column is the filename of each title.
+type metadataSource interface {
+	rows(ctx context.Context) ([][]interface{}, error)
+}
+
+// A versionedSource is a metadataSource that can cheaply tell when its contents change.
+type versionedSource interface {
+	metadataSource
+	version(ctx context.Context) (int64, error)
+}
+
+// A writableSource is a metadataSource whose titles can be changed.
+type writableSource interface {
+	metadataSource
+
+	// getCells returns the non-empty cells of the title with the given filename,
+	// keyed by lowercase heading,
+	// or nil if there is no such title.
+	getCells(ctx context.Context, filename string) (map[string]string, error)
+
+	// setCells sets cells of the title with the given filename,
+	// keyed by lowercase heading,
+	// adding the title and any missing columns as needed.
+	// An empty value clears a cell.
+	setCells(ctx context.Context, filename string, cells map[string]string) error
+}
+
+// newMetadataSource returns the source named by the -sheet flag:
+// a manifest file (.json or .yaml) or a CSV or TSV file,
+// either of which may be a local path or a gs://BUCKET/OBJECT URL,
+// a PostgreSQL database given by its postgres:// URL,
+// an Airtable table given as airtable://BASEID/TABLE,
+// or else a Google spreadsheet (see parseSheetSpec).
+func newMetadataSource(sheet string, ssvc *sheets.SpreadsheetsService, dsvc *drive.FilesService, gcs *storage.Client) (metadataSource, error) {
+	f := sourceFile{name: sheet, gcs: gcs}
+	switch strings.ToLower(filepath.Ext(sheet)) {
+	case ".json", ".yaml", ".yml":
+		return manifestSource{f}, nil
+	case ".csv", ".tsv":
+		return csvSource{f}, nil
+	}
+	if strings.HasPrefix(sheet, "gs://") {
+		return csvSource{f}, nil
+	}
+	if strings.HasPrefix(sheet, "airtable://") {
+		at, err := newAirtableSource(sheet)
+		if err != nil {
+			return nil, err
+		}
+		return at, nil
+	}
+	if strings.HasPrefix(sheet, "postgres://") || strings.HasPrefix(sheet, "postgresql://") {
+		pg, err := newPostgresSource(sheet)
+		if err != nil {
+			return nil, err
+		}
+		return pg, nil
+	}
+	src, err := parseSheetSpec(sheet, ssvc)
+	if err != nil {
+		return nil, err
+	}
+	src.dsvc = dsvc
+	return src, nil
+}
+
+// sourceFile is a local file or, if its name is a gs://BUCKET/OBJECT URL,
+// an object in Google Cloud Storage.
+type sourceFile struct {
+	name string
+	gcs  *storage.Client
+	obj  *storage.ObjectHandle // if set, the object to use instead of name
+}
+
+func (f sourceFile) object() (*storage.ObjectHandle, bool, error) {
+	if f.obj != nil {
+		return f.obj, true, nil
+	}
+	rest, ok := strings.CutPrefix(f.name, "gs://")
+	if !ok {
+		return nil, false, nil
+	}
+	bucket, obj, ok := strings.Cut(rest, "/")
+	if !ok {
+		return nil, false, fmt.Errorf("malformed URL %s, want gs://BUCKET/OBJECT", f.name)
+	}
+	return f.gcs.Bucket(bucket).Object(obj), true, nil
+}
+
+func (f sourceFile) open(ctx context.Context) (io.ReadCloser, error) {
+	obj, ok, err := f.object()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		r, err := obj.NewReader(ctx)
+		return r, errors.Wrapf(err, "opening %s", f.name)
+	}
+	r, err := os.Open(f.name)
+	return r, errors.Wrapf(err, "opening %s", f.name)
+}
+
+// version is the object's generation number,
+// or the local file's modification time.
+func (f sourceFile) version(ctx context.Context) (int64, error) {
+	obj, ok, err := f.object()
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		attrs, err := obj.Attrs(ctx)
+		if err != nil {
+			return 0, errors.Wrapf(err, "getting attrs of %s", f.name)
+		}
+		return attrs.Generation, nil
+	}
+	fi, err := os.Stat(f.name)
+	if err != nil {
+		return 0, errors.Wrapf(err, "statting %s", f.name)
+	}
+	return fi.ModTime().UnixNano(), nil
+}
+
+// sheetsSource is a metadataSource for a tab of a Google spreadsheet.
+type sheetsSource struct {
+	ssvc    *sheets.SpreadsheetsService
+	dsvc    *drive.FilesService // for version, may be nil
+	sheetID string
+	tab     string
+	cols    string // a range of columns, such as C:AZ, or "" for all of the tab's columns
+}
+
+// The tab of the metadata spreadsheet, if -sheet does not name one.
+const defaultTab = "Sheet1"
+
+var colsRE = regexp.MustCompile(`^[A-Z]+:[A-Z]+$`)
+
+// parseSheetSpec parses the -sheet value for a Google spreadsheet:
+// its ID,
+// optionally followed by !TAB,
+// the name of the tab holding the metadata (default Sheet1),
+// and by !COLUMNS,
+// the range of the tab's columns to use,
+// such as C:AZ (default all of them),
+// as in 1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms!Movies!A:AZ.
+func parseSheetSpec(spec string, ssvc *sheets.SpreadsheetsService) (sheetsSource, error) {
+	src := sheetsSource{ssvc: ssvc, sheetID: spec, tab: defaultTab}
+
+	parts := strings.SplitN(spec, "!", 3)
+	if len(parts) > 1 {
+		src.sheetID = parts[0]
+		if parts[1] != "" {
+			src.tab = parts[1]
+		}
+	}
+	if len(parts) > 2 {
+		src.cols = strings.ToUpper(parts[2])
+		if !colsRE.MatchString(src.cols) {
+			return sheetsSource{}, fmt.Errorf("malformed column range %s in %s, want e.g. A:AZ", parts[2], spec)
+		}
+	}
+	return src, nil
+}
+
+func (s sheetsSource) rows(ctx context.Context) ([][]interface{}, error) {
+	// Without an explicit column range,
+	// naming just the tab gets all of its columns,
+	// however many there are.
+	r := quoteTab(s.tab)
+	if s.cols != "" {
+		r += "!" + s.cols
+	}
+	resp, err := s.ssvc.Values.Get(s.sheetID, r).Context(ctx).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading spreadsheet data")
+	}
+	return resp.Values, nil
+}
+
+// version is the spreadsheet's version number in Google Drive,
+// which increases with every change to the spreadsheet.
+func (s sheetsSource) version(ctx context.Context) (int64, error) {
+	if s.dsvc == nil {
+		return 0, fmt.Errorf("no Drive service")
+	}
+	f, err := s.dsvc.Get(s.sheetID).Fields("version").Context(ctx).Do()
+	if err != nil {
+		return 0, errors.Wrap(err, "getting spreadsheet version")
+	}
+	return f.Version, nil
+}
+
+func (s sheetsSource) getCells(ctx context.Context, filename string) (map[string]string, error) {
+	values, err := s.rows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	var headings []string
+	for _, rawheading := range values[0] {
+		heading, _ := rawheading.(string)
+		headings = append(headings, headingAliases().canonical(heading))
+	}
+
+	for _, row := range values[1:] {
+		if len(row) > 0 && row[0] == filename {
+			return rowCells(headings, row), nil
+		}
+	}
+	return nil, nil
+}
+
+// readRow reads the row of the table with the given (zero-based) number.
+func (s sheetsSource) readRow(ctx context.Context, rownum int) ([]interface{}, error) {
+	r := fmt.Sprintf("%s!%d:%d", quoteTab(s.tab), rownum+1, rownum+1)
+	if first, last, ok := strings.Cut(s.cols, ":"); ok {
+		r = fmt.Sprintf("%s!%s%d:%s%d", quoteTab(s.tab), first, rownum+1, last, rownum+1)
+	}
+	resp, err := s.ssvc.Values.Get(s.sheetID, r).Context(ctx).Do()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading spreadsheet row %d", rownum+1)
+	}
+	if len(resp.Values) == 0 {
+		return nil, nil
+	}
+	return resp.Values[0], nil
+}
+
+// rowCells returns the non-empty cells of a row (other than the filename),
+// keyed by heading.
+func rowCells(headings []string, row []interface{}) map[string]string {
+	cells := make(map[string]string)
+	for j, rawval := range row {
+		if j == 0 || j >= len(headings) || headings[j] == "" {
+			continue
+		}
+		if val, ok := rawval.(string); ok && strings.TrimSpace(val) != "" {
+			cells[headings[j]] = val
+		}
+	}
+	return cells
+}
+
+func (s sheetsSource) setCells(ctx context.Context, filename string, cells map[string]string) error {
+	values, err := s.rows(ctx)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("no headings in spreadsheet")
+	}
+
+	var headings []string
+	for _, rawheading := range values[0] {
+		heading, _ := rawheading.(string)
+		headings = append(headings, headingAliases().canonical(heading))
+	}
+
+	rownum := slices.IndexFunc(values, func(row []interface{}) bool {
+		return len(row) > 0 && row[0] == filename
+	})
+	if rownum < 1 {
+		rownum = len(values) // a new row
+	}
+
+	_, err = s.writeRow(ctx, rownum, headings, filename, cells)
+	return err
+}
+
+// writeRow sets cells of the row with the given (zero-based) number,
+// given the spreadsheet's headings.
+// Columns for headings not among them are added after the others,
+// and their headings returned.
+func (s sheetsSource) writeRow(ctx context.Context, rownum int, headings []string, filename string, cells map[string]string) ([]string, error) {
+	var (
+		data  []*sheets.ValueRange
+		added []string
+	)
+	put := func(row, col int, val string) {
+		data = append(data, &sheets.ValueRange{Range: s.cell(row, col), Values: [][]interface{}{{val}}})
+	}
+
+	put(rownum, 0, filename)
+
+	var keys []string
+	for heading := range cells {
+		keys = append(keys, heading)
+	}
+	sort.Strings(keys)
+
+	for _, heading := range keys {
+		col := slices.Index(headings, heading)
+		if col < 1 {
+			col = len(headings) + len(added)
+			added = append(added, heading)
+			put(0, col, displayHeading(heading))
+		}
+		put(rownum, col, cells[heading])
+	}
+
+	req := &sheets.BatchUpdateValuesRequest{ValueInputOption: "RAW", Data: data}
+	_, err := s.ssvc.Values.BatchUpdate(s.sheetID, req).Context(ctx).Do()
+	return added, errors.Wrapf(err, "updating spreadsheet row %d (%s)", rownum+1, filename)
+}
+
+// displayHeading is the heading to give a new column for the given lowercase heading,
+// which is its name in the -headings map if it has one.
+func displayHeading(heading string) string {
+	if h, ok := headingAliases().display[heading]; ok {
+		return h
+	}
+	switch heading {
+	case "imdbid":
+		return "IMDbID"
+	case "mpaa":
+		return "MPAA"
+	}
+	if heading == "" {
+		return ""
+	}
+	return strings.ToUpper(heading[:1]) + heading[1:]
+}
+
+// cell is the name, in the Sheets API's A1 notation, of the cell in the given row and column of the table.
+// Both are zero-based,
+// and the column counts from the first in s.cols.
+func (s sheetsSource) cell(row, col int) string {
+	if first, _, ok := strings.Cut(s.cols, ":"); ok {
+		col += colIndex(first)
+	}
+	return quoteTab(s.tab) + "!" + cellName(row, col)
+}
+
+// quoteTab quotes the name of a spreadsheet tab for use in A1 notation.
+func quoteTab(tab string) string {
+	return "'" + strings.ReplaceAll(tab, "'", "''") + "'"
+}
+
+// csvSource is a metadataSource for a CSV file,
+// or a TSV file if its name ends in .tsv.
+type csvSource struct {
+	sourceFile
+}
+
+func (s csvSource) rows(ctx context.Context) ([][]interface{}, error) {
+	r, err := s.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	cr := csv.NewReader(r)
+	if strings.EqualFold(filepath.Ext(s.name), ".tsv") {
+		cr.Comma = '\t'
+		cr.LazyQuotes = true
+	}
+	cr.FieldsPerRecord = -1
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", s.name)
+	}
+
+	var rows [][]interface{}
+	for _, rec := range records {
+		row := make([]interface{}, 0, len(rec))
+		for _, field := range rec {
+			row = append(row, field)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// manifestSource is a metadataSource for a manifest file:
+// a YAML (or JSON) list of titles,
+// each a map from metadata spreadsheet heading to value,
+// as in
+//
+//   - filename: The Thin Man.iso
+//     year: 1934
+//     actors: [William Powell (Nick Charles), Myrna Loy (Nora Charles)]
+//
+// A list value stands for a semicolon-separated list.
+type manifestSource struct {
+	sourceFile
+}
+
+func (s manifestSource) rows(ctx context.Context) ([][]interface{}, error) {
+	r, err := s.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var titles []map[string]any
+	if err := yaml.NewDecoder(r).Decode(&titles); err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.Wrapf(err, "parsing %s", s.name)
+	}
+	return manifestRows(titles), nil
+}
+
+// manifestRows turns a list of titles,
+// each a map from heading to value,
+// into the rows of a metadata table.
+func manifestRows(titles []map[string]any) [][]interface{} {
+	// The headings are "filename" followed by the union of the titles' other keys.
+	headings := []string{"filename"}
+	seen := set.New("filename")
+	for _, t := range titles {
+		var keys []string
+		for k := range t {
+			if k = strings.ToLower(k); !seen.Has(k) {
+				keys = append(keys, k)
+				seen.Add(k)
+			}
+		}
+		sort.Strings(keys)
+		headings = append(headings, keys...)
+	}
+	index := make(map[string]int)
+	for i, h := range headings {
+		index[h] = i
+	}
+
+	rows := [][]interface{}{make([]interface{}, len(headings))}
+	for i, h := range headings {
+		rows[0][i] = h
+	}
+	for _, t := range titles {
+		row := make([]interface{}, len(headings))
+		for i := range row {
+			row[i] = ""
+		}
+		for k, v := range t {
+			row[index[strings.ToLower(k)]] = manifestValue(v)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// manifestValue renders a manifest value as a metadata spreadsheet cell.
+func manifestValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []any:
+		var items []string
+		for _, item := range v {
+			items = append(items, manifestValue(item))
+		}
+		return strings.Join(items, "; ")
+	case map[string]any:
+		// E.g. actorthumbs: {William Powell: https://...}
+		var pairs []string
+		for k, val := range v {
+			pairs = append(pairs, k+"="+manifestValue(val))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, "; ")
+	case time.Time:
+		// YAML parses unquoted dates, as in premiered: 1934-06-29.
+		return v.Format(time.DateOnly)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+const (
+	// How often to check a versionedSource for changes.
+	sourceWatchInterval = 30 * time.Second
+
+	// How long before reloading a watched source's info map even without changes,
+	// to pick up changes to sidecars and the like.
+	watchedStaleTime = time.Hour
+)
+
+// watchSource reloads the info map whenever the metadata source changes,
+// until the context is canceled.
+// While it can check the source's version,
+// the info map isn't otherwise reloaded until watchedStaleTime has passed.
+func (s *server) watchSource(ctx context.Context, src versionedSource) {
+	last, err := src.version(ctx)
+	if err != nil {
+		log.Printf("Error checking metadata version, falling back to periodic reloads: %s", err)
+	}
+	s.setSourceWatched(err == nil)
+	failing := err != nil // log only the first of a run of errors
+
+	ticker := time.NewTicker(sourceWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			v, err := src.version(ctx)
+			s.setSourceWatched(err == nil)
+			if err != nil {
+				if !failing {
+					log.Printf("Error checking metadata version, falling back to periodic reloads: %s", err)
+				}
+				failing = true
+				continue
+			}
+			failing = false
+			if v == last {
+				continue
+			}
+			last = v
+
+			log.Print("Metadata changed, reloading")
+
+			s.mu.Lock()
+			s.infoMapTime = time.Time{}
+			s.mu.Unlock()
+
+			if err := s.ensureInfoMap(ctx); err != nil {
+				log.Printf("Error reloading metadata: %s", err)
+			}
+		}
+	}
+}
+
+func (s *server) setSourceWatched(watched bool) {
+	s.mu.Lock()
+	s.sourceWatched = watched
+	s.mu.Unlock()
+}
+
+// Names of manifest objects that the server looks for in the bucket
+// when it has no -sheet.
+var defaultManifests = []string{"metadata.yaml", "metadata.yml", "metadata.json"}
+
+// findManifest returns a source for the first of defaultManifests in the bucket,
+// and its name,
+// or nil if there is none.
+func findManifest(ctx context.Context, bucket *storage.BucketHandle) (metadataSource, string, error) {
+	for _, name := range defaultManifests {
+		obj := bucket.Object(name)
+		_, err := obj.Attrs(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "looking for %s", name)
+		}
+		return manifestSource{sourceFile{name: name, obj: obj}}, name, nil
+	}
+	return nil, "", nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCSVSource(t *testing.T) {
+	dir := t.TempDir()
+
+	cases := []struct {
+		filename, contents string
+	}{
+		{filename: "titles.csv", contents: "Filename,Title,Year\n\"The Thin Man.iso\",\"The Thin Man\",1934\nbare.iso\n"},
+		{filename: "titles.tsv", contents: "Filename\tTitle\tYear\nThe Thin Man.iso\tThe Thin Man\t1934\nbare.iso\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.filename, func(t *testing.T) {
+			path := filepath.Join(dir, c.filename)
+			if err := os.WriteFile(path, []byte(c.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			src, err := newMetadataSource(path, nil, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := src.(csvSource); !ok {
+				t.Fatalf("got %T, want csvSource", src)
+			}
+
+			var got [][]interface{}
+			err = handleSheet(context.Background(), src, func(_ int, headings []string, name string, row []interface{}) error {
+				if want := []string{"filename", "title", "year"}; !reflect.DeepEqual(headings, want) {
+					t.Errorf("got headings %v, want %v", headings, want)
+				}
+				got = append(got, row)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := [][]interface{}{{"The Thin Man.iso", "The Thin Man", "1934"}}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestManifestSource(t *testing.T) {
+	const manifest = `
+- filename: The Thin Man.iso
+  year: 1934
+  premiered: 1934-06-29
+  actors: [William Powell (Nick Charles), Myrna Loy (Nora Charles)]
+- filename: After the Thin Man.iso
+  Set: The Thin Man
+`
+
+	path := filepath.Join(t.TempDir(), "metadata.yaml")
+	if err := os.WriteFile(path, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := newMetadataSource(path, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := src.(manifestSource); !ok {
+		t.Fatalf("got %T, want manifestSource", src)
+	}
+
+	got := make(map[string]map[string]string)
+	err = handleSheet(context.Background(), src, func(_ int, headings []string, name string, row []interface{}) error {
+		cells := make(map[string]string)
+		for j, heading := range headings {
+			if val := row[j].(string); j > 0 && val != "" {
+				cells[heading] = val
+			}
+		}
+		got[name] = cells
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]map[string]string{
+		"The Thin Man.iso": {
+			"year":      "1934",
+			"premiered": "1934-06-29",
+			"actors":    "William Powell (Nick Charles); Myrna Loy (Nora Charles)",
+		},
+		"After the Thin Man.iso": {
+			"set": "The Thin Man",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseSheetSpec(t *testing.T) {
+	cases := []struct {
+		spec, wantID, wantTab, wantCols, wantCell string
+		wantErr                                   bool
+	}{
+		{spec: "abc", wantID: "abc", wantTab: "Sheet1", wantCell: "'Sheet1'!C5"},
+		{spec: "abc!Movies", wantID: "abc", wantTab: "Movies", wantCell: "'Movies'!C5"},
+		{spec: "abc!Bob's Movies!c:az", wantID: "abc", wantTab: "Bob's Movies", wantCols: "C:AZ", wantCell: "'Bob''s Movies'!E5"},
+		{spec: "abc!!A:ZZ", wantID: "abc", wantTab: "Sheet1", wantCols: "A:ZZ", wantCell: "'Sheet1'!C5"},
+		{spec: "abc!Movies!A1:Z", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.spec, func(t *testing.T) {
+			src, err := parseSheetSpec(c.spec, nil)
+			if c.wantErr {
+				if err == nil {
+					t.Error("got no error, want one")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if src.sheetID != c.wantID || src.tab != c.wantTab || src.cols != c.wantCols {
+				t.Errorf("got (%s, %s, %s), want (%s, %s, %s)", src.sheetID, src.tab, src.cols, c.wantID, c.wantTab, c.wantCols)
+			}
+			if got := src.cell(4, 2); got != c.wantCell {
+				t.Errorf("got cell %s, want %s", got, c.wantCell)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/bobg/errors"
+)
+
+type (
+	kodiSources struct {
+		XMLName xml.Name     `xml:"sources"`
+		Video   []kodiSource `xml:"video>source"`
+	}
+
+	kodiSource struct {
+		Name         string   `xml:"name"`
+		Path         kodiPath `xml:"path"`
+		AllowSharing bool     `xml:"allowsharing"`
+	}
+
+	kodiPasswords struct {
+		XMLName xml.Name           `xml:"passwords"`
+		Paths   []kodiPasswordPath `xml:"path"`
+	}
+
+	kodiPasswordPath struct {
+		From kodiPath `xml:"from"`
+		To   kodiPath `xml:"to"`
+	}
+
+	kodiPath struct {
+		PathVersion int    `xml:"pathversion,attr"`
+		Val         string `xml:",chardata"`
+	}
+)
+
+// sources writes the entries for the server
+// in Kodi's sources.xml and (with a username) passwords.xml files
+// to standard output.
+func (c maincmd) sources(ctx context.Context, serverURL string, dav bool, username, password, subdirs string, tv bool, name string, _ []string) error {
+	if serverURL == "" {
+		return fmt.Errorf("must specify -url")
+	}
+	if name == "" {
+		name = "kodigcs (" + c.bucketName + ")"
+	}
+
+	username, err := c.secrets.resolve(ctx, username)
+	if err != nil {
+		return errors.Wrap(err, "resolving -username")
+	}
+	password, err = c.secrets.resolve(ctx, password)
+	if err != nil {
+		return errors.Wrap(err, "resolving -password")
+	}
+
+	var dirs []string
+	for _, sd := range strings.Split(subdirs, ",") {
+		if sd = strings.Trim(strings.TrimSpace(sd), "/"); sd != "" {
+			dirs = append(dirs, sd)
+		}
+	}
+
+	return writeKodiSources(os.Stdout, serverURL, dav, username, password, name, dirs, tv)
+}
+
+// writeKodiSources writes the sources.xml and passwords.xml entries
+// for a server at serverURL.
+// There is a source for the top-level folder,
+// one for each of subdirs,
+// and, if tv is true,
+// one for the tv/ folder.
+func writeKodiSources(w io.Writer, serverURL string, dav bool, username, password, name string, subdirs []string, tv bool) error {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %s", serverURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL %s has no host", serverURL)
+	}
+	if dav {
+		switch u.Scheme {
+		case "http":
+			u.Scheme = "dav"
+		case "https":
+			u.Scheme = "davs"
+		}
+	}
+	u.User = nil
+	u.Path = "/"
+	u.RawQuery, u.Fragment = "", ""
+
+	type folder struct{ name, path string }
+	folders := []folder{{name: name, path: ""}}
+	for _, sd := range subdirs {
+		folders = append(folders, folder{name: name + " - " + sd, path: sd})
+	}
+	if tv {
+		folders = append(folders, folder{name: name + " - TV", path: tvDir})
+	}
+
+	var (
+		sources   kodiSources
+		passwords kodiPasswords
+	)
+	for _, f := range folders {
+		fu := *u // Kodi wants the trailing slash of a source's path.
+		if f.path != "" {
+			fu.Path = "/" + f.path + "/"
+		}
+		sources.Video = append(sources.Video, kodiSource{
+			Name:         f.name,
+			Path:         kodiPath{PathVersion: 1, Val: fu.String()},
+			AllowSharing: true,
+		})
+		if username != "" {
+			to := fu
+			to.User = url.UserPassword(username, password)
+			passwords.Paths = append(passwords.Paths, kodiPasswordPath{
+				From: kodiPath{PathVersion: 1, Val: fu.String()},
+				To:   kodiPath{PathVersion: 1, Val: to.String()},
+			})
+		}
+	}
+
+	fmt.Fprintln(w, "<!-- Add the <source> elements to the <video> section of sources.xml. -->")
+	if err := writeKodiXML(w, sources); err != nil {
+		return errors.Wrap(err, "writing sources.xml")
+	}
+	if username == "" {
+		return nil
+	}
+	fmt.Fprintln(w, "\n<!-- Add the <path> elements to passwords.xml. -->")
+	return errors.Wrap(writeKodiXML(w, passwords), "writing passwords.xml")
+}
+
+func writeKodiXML(w io.Writer, v any) error {
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
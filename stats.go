@@ -0,0 +1,342 @@
+package main
+
+import (
+	"container/list"
+	"io"
+	"maps"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+const (
+	// Reads of media objects are tracked in chunks of this size.
+	statsChunkSize = 4 << 20
+
+	// Number of days of working-set history to keep.
+	statsDays = 7
+)
+
+// Cache sizes for which hit rates are simulated.
+var simCacheSizes = []int64{1 << 30, 4 << 30, 16 << 30, 64 << 30, 256 << 30}
+
+// accessStats tracks which parts of which objects are read,
+// in order to size a cache.
+// kodigcs reads straight from GCS today;
+// these numbers say what a cache in front of GCS would accomplish.
+type accessStats struct {
+	mu   sync.Mutex
+	days map[string]set.Of[chunkKey] // "2006-01-02" -> chunks read that day
+	sims []*lruSim
+	runs []int64 // lengths of contiguous reads, most recent last
+
+	streamed    set.Of[string]   // objects read since the server started
+	objBytes    map[string]int64 // bytes read from each object since the server started
+	bytesServed atomic.Int64
+}
+
+type chunkKey struct {
+	obj   string
+	chunk int64
+}
+
+func newAccessStats() *accessStats {
+	a := &accessStats{
+		days:     make(map[string]set.Of[chunkKey]),
+		streamed: set.New[string](),
+		objBytes: make(map[string]int64),
+	}
+	for _, size := range simCacheSizes {
+		a.sims = append(a.sims, newLRUSim(size))
+	}
+	return a
+}
+
+func (a *accessStats) access(key chunkKey) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	day := time.Now().Format(time.DateOnly)
+	chunks, ok := a.days[day]
+	if !ok {
+		chunks = set.New[chunkKey]()
+		a.days[day] = chunks
+
+		cutoff := time.Now().AddDate(0, 0, -statsDays).Format(time.DateOnly)
+		for d := range a.days {
+			if d < cutoff {
+				delete(a.days, d)
+			}
+		}
+	}
+	chunks.Add(key)
+	a.streamed.Add(key.obj)
+
+	for _, sim := range a.sims {
+		sim.access(key)
+	}
+}
+
+// served records n bytes read from obj.
+func (a *accessStats) served(obj string, n int64) {
+	a.bytesServed.Add(n)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.objBytes[obj] += n
+}
+
+// objectBytes returns a copy of the bytes read from each object.
+func (a *accessStats) objectBytes() map[string]int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return maps.Clone(a.objBytes)
+}
+
+const maxRuns = 1000
+
+func (a *accessStats) streamedCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.streamed.Len()
+}
+
+func (a *accessStats) addRun(n int64) {
+	if n == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.runs = append(a.runs, n)
+	if len(a.runs) > maxRuns {
+		a.runs = a.runs[len(a.runs)-maxRuns:]
+	}
+}
+
+type cacheReport struct {
+	// WorkingSet maps each day to the number of distinct bytes read that day.
+	WorkingSet map[string]int64 `json:"working_set"`
+
+	// HitRates gives the hit rate an LRU cache of each size would have had
+	// since the server started.
+	HitRates []simResult `json:"hit_rates"`
+
+	// RecommendedCacheSize is the smallest simulated size
+	// whose hit rate is within 95% of the best simulated one.
+	RecommendedCacheSize int64 `json:"recommended_cache_size,omitempty"`
+
+	// MedianRun is the median length of a contiguous read from an object.
+	// It is a reasonable read-ahead window.
+	MedianRun int64 `json:"median_run,omitempty"`
+}
+
+type simResult struct {
+	CacheSize int64   `json:"cache_size"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+func (a *accessStats) report() cacheReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r := cacheReport{WorkingSet: make(map[string]int64)}
+	for day, chunks := range a.days {
+		r.WorkingSet[day] = int64(chunks.Len()) * statsChunkSize
+	}
+
+	var best float64
+	for _, sim := range a.sims {
+		res := simResult{CacheSize: sim.capacity * statsChunkSize}
+		if total := sim.hits + sim.misses; total > 0 {
+			res.HitRate = float64(sim.hits) / float64(total)
+		}
+		if res.HitRate > best {
+			best = res.HitRate
+		}
+		r.HitRates = append(r.HitRates, res)
+	}
+	if best > 0 {
+		for _, res := range r.HitRates {
+			if res.HitRate >= 0.95*best {
+				r.RecommendedCacheSize = res.CacheSize
+				break
+			}
+		}
+	}
+
+	if len(a.runs) > 0 {
+		runs := append([]int64(nil), a.runs...)
+		sort.Slice(runs, func(i, j int) bool { return runs[i] < runs[j] })
+		r.MedianRun = runs[len(runs)/2]
+	}
+
+	return r
+}
+
+// lruSim simulates an LRU cache of chunks.
+type lruSim struct {
+	capacity     int64 // in chunks
+	ll           *list.List
+	elems        map[chunkKey]*list.Element
+	hits, misses int64
+}
+
+func newLRUSim(size int64) *lruSim {
+	return &lruSim{
+		capacity: size / statsChunkSize,
+		ll:       list.New(),
+		elems:    make(map[chunkKey]*list.Element),
+	}
+}
+
+func (l *lruSim) access(key chunkKey) {
+	if el, ok := l.elems[key]; ok {
+		l.hits++
+		l.ll.MoveToFront(el)
+		return
+	}
+	l.misses++
+	l.elems[key] = l.ll.PushFront(key)
+	if int64(l.ll.Len()) > l.capacity {
+		oldest := l.ll.Back()
+		l.ll.Remove(oldest)
+		delete(l.elems, oldest.Value.(chunkKey))
+	}
+}
+
+// trackingReader wraps the reader for an object being served,
+// reporting the chunks read to an accessStats.
+type trackingReader struct {
+	r     io.ReadSeeker
+	obj   string
+	stats *accessStats
+
+	pos       int64
+	lastChunk int64
+	runStart  int64
+}
+
+func newTrackingReader(r io.ReadSeeker, obj string, stats *accessStats) *trackingReader {
+	return &trackingReader{r: r, obj: obj, stats: stats, lastChunk: -1}
+}
+
+func (t *trackingReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		first, last := t.pos/statsChunkSize, (t.pos+int64(n)-1)/statsChunkSize
+		for c := first; c <= last; c++ {
+			if c != t.lastChunk {
+				t.stats.access(chunkKey{obj: t.obj, chunk: c})
+				t.lastChunk = c
+			}
+		}
+		t.pos += int64(n)
+		t.stats.served(t.obj, int64(n))
+	}
+	return n, err
+}
+
+func (t *trackingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := t.r.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	if pos != t.pos {
+		t.stats.addRun(t.pos - t.runStart)
+		t.runStart = pos
+	}
+	t.pos = pos
+	return pos, nil
+}
+
+// Close records the final contiguous read.
+func (t *trackingReader) Close() error {
+	t.stats.addRun(t.pos - t.runStart)
+	return nil
+}
+
+// Score bands for the library report,
+// as percentages of each rating's maximum.
+var scoreBands = []struct {
+	name string
+	min  float64
+}{
+	{"80-100", 80},
+	{"60-79", 60},
+	{"40-59", 40},
+	{"0-39", 0},
+}
+
+const unrated = "unrated"
+
+// libraryReport maps each rating name to the number of titles in each score band.
+type libraryReport map[string]map[string]int
+
+// libraryReport tallies the titles in the library by score band.
+// The caller must hold s.mu.
+func (s *server) libraryReport() libraryReport {
+	if len(s.infoMap) == 0 {
+		return nil
+	}
+
+	r := make(libraryReport)
+	for name := range ratingMax {
+		r[name] = make(map[string]int)
+	}
+	for _, info := range s.infoMap {
+		rated := set.New[string]()
+		for _, rt := range info.Ratings {
+			if rt.Max == 0 || rated.Has(rt.Name) {
+				continue
+			}
+			rated.Add(rt.Name)
+			if r[rt.Name] == nil {
+				r[rt.Name] = make(map[string]int)
+			}
+			pct := 100 * rt.Value / float64(rt.Max)
+			for _, band := range scoreBands {
+				if pct >= band.min {
+					r[rt.Name][band.name]++
+					break
+				}
+			}
+		}
+		for name, bands := range r {
+			if !rated.Has(name) {
+				bands[unrated]++
+			}
+		}
+	}
+	return r
+}
+
+type statsReport struct {
+	Cache   cacheReport   `json:"cache"`
+	Library libraryReport `json:"library,omitempty"`
+}
+
+// handleStats serves statistics about the server's operation.
+func (s *server) handleStats(w http.ResponseWriter, req *http.Request) error {
+	if err := s.ensureInfoMap(req.Context()); err != nil {
+		return errors.Wrap(err, "in ensureInfoMap")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return mid.RespondJSON(w, statsReport{
+		Cache:   s.stats.report(),
+		Library: s.libraryReport(),
+	})
+}
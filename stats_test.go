@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLRUSim(t *testing.T) {
+	sim := newLRUSim(2 * statsChunkSize)
+	key := func(c int64) chunkKey { return chunkKey{obj: "Top Hat.mkv", chunk: c} }
+
+	// 0 and 1 fill the cache; 0 is a hit and becomes most recent;
+	// 2 evicts 1, the least recently used; then 0 hits and 1 misses.
+	for _, c := range []int64{0, 1, 0, 2, 0, 1} {
+		sim.access(key(c))
+	}
+	if sim.hits != 2 || sim.misses != 4 {
+		t.Errorf("got %d hits, %d misses; want 2, 4", sim.hits, sim.misses)
+	}
+	if sim.ll.Len() != 2 || len(sim.elems) != 2 {
+		t.Errorf("got %d chunks in the cache (%d indexed), want 2", sim.ll.Len(), len(sim.elems))
+	}
+	if _, ok := sim.elems[key(2)]; ok {
+		t.Error("chunk 2 not evicted")
+	}
+}
+
+func TestCacheReport(t *testing.T) {
+	a := newAccessStats()
+	a.sims = []*lruSim{newLRUSim(2 * statsChunkSize), newLRUSim(4 * statsChunkSize), newLRUSim(8 * statsChunkSize)}
+
+	// Cycling through three chunks thrashes a two-chunk cache
+	// but hits in anything bigger after the first pass.
+	for i := 0; i < 10; i++ {
+		a.access(chunkKey{obj: "Top Hat.mkv", chunk: int64(i % 3)})
+	}
+	for _, n := range []int64{5, 1, 100} {
+		a.addRun(n)
+	}
+
+	r := a.report()
+	if got := r.WorkingSet[time.Now().Format(time.DateOnly)]; got != 3*statsChunkSize {
+		t.Errorf("got working set %d, want %d", got, 3*statsChunkSize)
+	}
+	wantRates := []float64{0, 0.7, 0.7}
+	for i, res := range r.HitRates {
+		if res.HitRate != wantRates[i] {
+			t.Errorf("got hit rate %v for %d bytes, want %v", res.HitRate, res.CacheSize, wantRates[i])
+		}
+	}
+	if r.RecommendedCacheSize != 4*statsChunkSize {
+		t.Errorf("got recommended size %d, want %d", r.RecommendedCacheSize, 4*statsChunkSize)
+	}
+	if r.MedianRun != 5 {
+		t.Errorf("got median run %d, want 5", r.MedianRun)
+	}
+}
+
+func TestTrackingReader(t *testing.T) {
+	var (
+		a       = newAccessStats()
+		content = make([]byte, 2*statsChunkSize+100)
+		tr      = newTrackingReader(bytes.NewReader(content), "Top Hat.mkv", a)
+	)
+
+	// Read the first chunk and a bit, crossing into the second.
+	if _, err := io.ReadFull(tr, make([]byte, statsChunkSize+10)); err != nil {
+		t.Fatal(err)
+	}
+	// Jump to the last 50 bytes, in the third chunk.
+	if _, err := tr.Seek(-50, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, tr); err != nil {
+		t.Fatal(err)
+	}
+	tr.Close()
+
+	if got, want := a.bytesServed.Load(), int64(statsChunkSize+60); got != want {
+		t.Errorf("got %d bytes served, want %d", got, want)
+	}
+	if got := a.objectBytes()["Top Hat.mkv"]; got != statsChunkSize+60 {
+		t.Errorf("got %d bytes for the object", got)
+	}
+	if got := a.streamedCount(); got != 1 {
+		t.Errorf("got %d objects streamed, want 1", got)
+	}
+	chunks := a.days[time.Now().Format(time.DateOnly)]
+	for _, c := range []int64{0, 1, 2} {
+		if !chunks.Has(chunkKey{obj: "Top Hat.mkv", chunk: c}) {
+			t.Errorf("chunk %d not recorded", c)
+		}
+	}
+	// Each chunk counts once, however many reads it takes.
+	if got := a.sims[0].misses + a.sims[0].hits; got != 3 {
+		t.Errorf("got %d chunk accesses, want 3", got)
+	}
+	if want := []int64{statsChunkSize + 10, 50}; len(a.runs) != 2 || a.runs[0] != want[0] || a.runs[1] != want[1] {
+		t.Errorf("got runs %v, want %v", a.runs, want)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+// subtitleTypes maps the extensions of subtitle files to their MIME types.
+// A subtitle file in the bucket named like a title's media object,
+// but with one of these extensions and any number of dot-separated tags before it
+// (as in "The Thin Man.srt", "The Thin Man.en.srt", or "The Thin Man.en.forced.srt"),
+// is listed beside the title's media object,
+// with the same hash prefix,
+// so that Kodi finds it.
+var subtitleTypes = map[string]string{
+	".ass": "text/x-ssa",
+	".idx": "text/plain",
+	".smi": "application/smil",
+	".srt": "application/x-subrip",
+	".ssa": "text/x-ssa",
+	".sub": "text/plain",
+	".vtt": "text/vtt",
+}
+
+func isSubtitleExt(ext string) bool {
+	_, ok := subtitleTypes[strings.ToLower(ext)]
+	return ok
+}
+
+// subtitleRoots returns the root names of the titles
+// that the named subtitle file might belong to,
+// longest first:
+// "The Thin Man.en.srt" might belong to "The Thin Man.en" or "The Thin Man".
+// It returns nil if objName is not a subtitle file.
+func subtitleRoots(objName string) []string {
+	ext := filepath.Ext(objName)
+	if !isSubtitleExt(ext) {
+		return nil
+	}
+	var (
+		dir, base = filepath.Split(strings.TrimSuffix(objName, ext))
+		result    []string
+	)
+	for base != "" {
+		result = append(result, dir+base)
+		i := strings.LastIndex(base, ".")
+		if i < 0 {
+			break
+		}
+		base = base[:i]
+	}
+	return result
+}
+
+// titleFiles indexes the objects in the bucket that go with titles,
+// for listing them beside the titles' media objects.
+type titleFiles struct {
+	subtitles map[string][]string // root name -> subtitle objects, in order
+	hasExtras set.Of[string]      // root names of titles with ROOT-extras/ folders
+}
+
+// indexTitleFiles returns the titleFiles for the bucket.
+// The caller must hold s.mu.
+func (s *server) indexTitleFiles() titleFiles {
+	result := titleFiles{
+		subtitles: make(map[string][]string),
+		hasExtras: set.New[string](),
+	}
+	for objName := range s.objNames {
+		for _, rootName := range subtitleRoots(objName) {
+			if _, ok := s.mediaObjName(rootName); ok {
+				result.subtitles[rootName] = append(result.subtitles[rootName], objName)
+				break
+			}
+		}
+		if rootName, ok := strings.CutSuffix(path.Dir(objName), extrasSuffix); ok {
+			result.hasExtras.Add(rootName)
+		}
+	}
+	for _, objNames := range result.subtitles {
+		sort.Strings(objNames)
+	}
+	return result
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestSubtitles(t *testing.T) {
+	s := &server{
+		objNames: set.New(
+			"The Thin Man.mp4",
+			"The Thin Man.srt",
+			"The Thin Man.en.forced.srt",
+			"The Thin Man.de.vtt",
+			"The Thin Man.txt",
+			"Orphan.en.srt",
+		),
+	}
+
+	items := s.titleItems(func(movieInfo, bool) bool { return true })
+	prefix := rootNamePrefix("The Thin Man")
+	want := []string{
+		prefix + "The Thin Man.mp4",
+		prefix + "The Thin Man.nfo",
+		prefix + "The Thin Man.de.vtt",
+		prefix + "The Thin Man.en.forced.srt",
+		prefix + "The Thin Man.srt",
+	}
+	if len(items) != len(want) {
+		t.Fatalf("got %v, want %v", items, want)
+	}
+	for i, item := range items {
+		if string(item) != want[i] {
+			t.Errorf("item %d: got %s, want %s", i, item, want[i])
+		}
+	}
+
+	for _, item := range want[2:] {
+		if _, objName := parsePath(item); objName != item {
+			t.Errorf("parsePath(%q) gives object %q", item, objName)
+		}
+	}
+
+	if got := objMIMEType("The Thin Man.en.forced.srt"); got != "application/x-subrip" {
+		t.Errorf("got MIME type %s for .srt", got)
+	}
+}
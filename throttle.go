@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+	"golang.org/x/time/rate"
+)
+
+// The server sits on the public internet and gets probed constantly,
+// so it limits the rate of requests from each client address,
+// and locks out an address for a while after repeated failed logins.
+//
+// Streaming requests (for media objects and HLS segments) are not rate-limited,
+// since a player makes many of them.
+
+const (
+	// The window in which failed logins from an address are counted.
+	authFailureWindow = 15 * time.Minute
+
+	// How long an address is locked out after too many failed logins.
+	lockoutDuration = 15 * time.Minute
+
+	// How long a client's state is kept after its last request.
+	clientIdleTime = time.Hour
+)
+
+// clientThrottle tracks the request rate and failed logins of each client address.
+type clientThrottle struct {
+	rate        rate.Limit // requests per second per address, or 0 for no limit
+	burst       int
+	maxFailures int // failed logins before a lockout, or 0 for no lockouts
+
+	mu      sync.Mutex
+	clients map[netip.Addr]*clientState
+}
+
+type clientState struct {
+	limiter     *rate.Limiter
+	failures    int
+	firstFail   time.Time // of the failures in the current window
+	lockedUntil time.Time
+	lastSeen    time.Time
+}
+
+// newClientThrottle returns a clientThrottle allowing reqsPerSec non-streaming requests per second
+// (with bursts of up to 5 seconds' worth)
+// and maxFailures failed logins per authFailureWindow.
+// It returns nil if both are zero.
+func newClientThrottle(reqsPerSec float64, maxFailures int) *clientThrottle {
+	if reqsPerSec <= 0 && maxFailures <= 0 {
+		return nil
+	}
+	t := &clientThrottle{
+		maxFailures: max(maxFailures, 0),
+		clients:     make(map[netip.Addr]*clientState),
+	}
+	if reqsPerSec > 0 {
+		t.rate = rate.Limit(reqsPerSec)
+		t.burst = max(1, int(5*reqsPerSec))
+	}
+	return t
+}
+
+// client returns the state of the client at addr, creating it if necessary.
+// The caller must hold t.mu.
+func (t *clientThrottle) client(addr netip.Addr, now time.Time) *clientState {
+	c, ok := t.clients[addr]
+	if !ok {
+		c = &clientState{}
+		if t.rate > 0 {
+			c.limiter = rate.NewLimiter(t.rate, t.burst)
+		}
+		t.clients[addr] = c
+	}
+	c.lastSeen = now
+	return c
+}
+
+// admit decides whether to let a request from addr proceed.
+// If not, it returns how long the client should wait before trying again.
+func (t *clientThrottle) admit(addr netip.Addr, streaming bool, now time.Time) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := t.client(addr, now)
+	if now.Before(c.lockedUntil) {
+		return false, c.lockedUntil.Sub(now)
+	}
+	if streaming || c.limiter == nil {
+		return true, 0
+	}
+	r := c.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// authFailed records a failed login from addr
+// and tells whether it caused a lockout.
+func (t *clientThrottle) authFailed(addr netip.Addr, now time.Time) bool {
+	if t.maxFailures == 0 {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := t.client(addr, now)
+	if now.Sub(c.firstFail) > authFailureWindow {
+		c.failures, c.firstFail = 0, now
+	}
+	c.failures++
+	if c.failures < t.maxFailures {
+		return false
+	}
+	c.failures = 0
+	c.lockedUntil = now.Add(lockoutDuration)
+	return true
+}
+
+// authSucceeded clears the failed logins of addr.
+func (t *clientThrottle) authSucceeded(addr netip.Addr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c, ok := t.clients[addr]; ok {
+		c.failures = 0
+	}
+}
+
+// prune forgets the clients that have been idle for clientIdleTime and are not locked out.
+func (t *clientThrottle) prune(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for addr, c := range t.clients {
+		if now.Sub(c.lastSeen) > clientIdleTime && now.After(c.lockedUntil) {
+			delete(t.clients, addr)
+		}
+	}
+}
+
+// run prunes idle clients periodically until the context is canceled.
+func (t *clientThrottle) run(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.prune(now)
+		}
+	}
+}
+
+// isStreamingRequest tells whether a request is for media,
+// which a player fetches in many range requests.
+func isStreamingRequest(req *http.Request) bool {
+	p := req.URL.Path
+	return strings.HasPrefix(p, "/hls/") || isMediaExt(path.Ext(p))
+}
+
+// hasCredentials tells whether a request carries credentials of any kind,
+// so that its failing authentication counts as a failed login
+// (and not as the first request of a client that hasn't yet been asked for them).
+func hasCredentials(req *http.Request) bool {
+	if req.Header.Get("Authorization") != "" {
+		return true
+	}
+	q := req.URL.Query()
+	return q.Has("token") || q.Has("share") || q.Has("playlist")
+}
+
+// throttled wraps a handler so that it refuses requests from clients
+// that exceed the request rate or are locked out,
+// and records their failed logins.
+// It goes outside authed.
+func (s *server) throttled(f func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	t := s.throttle
+	if t == nil {
+		return f
+	}
+	return func(w http.ResponseWriter, req *http.Request) error {
+		addr, ok := remoteAddr(req)
+		if !ok {
+			return f(w, req)
+		}
+
+		if ok, wait := t.admit(addr, isStreamingRequest(req), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			return mid.CodeErr{
+				C:   http.StatusTooManyRequests,
+				Err: fmt.Errorf("too many requests from %s", addr),
+			}
+		}
+
+		err := f(w, req)
+		if !hasCredentials(req) {
+			return err
+		}
+
+		var codeErr mid.CodeErr
+		if errors.As(err, &codeErr) && codeErr.C == http.StatusUnauthorized {
+			if t.authFailed(addr, time.Now()) {
+				log.Printf("Locking out %s for %s after %d failed logins", addr, lockoutDuration, t.maxFailures)
+			}
+		} else {
+			t.authSucceeded(addr)
+		}
+		return err
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/bobg/mid"
+)
+
+func TestThrottle(t *testing.T) {
+	if newClientThrottle(0, 0) != nil {
+		t.Error("got a throttle with no limits")
+	}
+
+	var (
+		th   = newClientThrottle(1, 3)
+		addr = netip.MustParseAddr("203.0.113.9")
+		now  = time.Now()
+	)
+
+	// The burst is 5 requests; streaming requests don't count.
+	for i := 0; i < 5; i++ {
+		if ok, _ := th.admit(addr, false, now); !ok {
+			t.Fatalf("request %d refused", i+1)
+		}
+	}
+	if ok, _ := th.admit(addr, true, now); !ok {
+		t.Error("streaming request refused")
+	}
+	if ok, wait := th.admit(addr, false, now); ok || wait <= 0 {
+		t.Errorf("got %v, %s for a request over the limit", ok, wait)
+	}
+	now = now.Add(time.Second)
+	if ok, _ := th.admit(addr, false, now); !ok {
+		t.Error("request refused after waiting")
+	}
+
+	// Failures reset on success and after the window.
+	th.authFailed(addr, now)
+	th.authFailed(addr, now)
+	th.authSucceeded(addr)
+	th.authFailed(addr, now)
+	th.authFailed(addr, now)
+	now = now.Add(authFailureWindow + time.Second)
+	if th.authFailed(addr, now) || th.authFailed(addr, now) {
+		t.Fatal("locked out too soon")
+	}
+	if !th.authFailed(addr, now) {
+		t.Fatal("not locked out")
+	}
+	if ok, wait := th.admit(addr, true, now); ok || wait != lockoutDuration {
+		t.Errorf("got %v, %s for a locked-out client", ok, wait)
+	}
+	if ok, _ := th.admit(netip.MustParseAddr("203.0.113.10"), false, now); !ok {
+		t.Error("another client refused")
+	}
+	now = now.Add(lockoutDuration)
+	if ok, _ := th.admit(addr, false, now); !ok {
+		t.Error("request refused after the lockout")
+	}
+
+	now = now.Add(clientIdleTime + time.Second)
+	th.prune(now)
+	if len(th.clients) != 0 {
+		t.Errorf("%d clients left after pruning", len(th.clients))
+	}
+}
+
+func TestThrottled(t *testing.T) {
+	s := &server{
+		auth:     basicAuth{username: "alice", password: "secret"},
+		throttle: newClientThrottle(0, 2),
+	}
+	h := mid.Err(s.throttled(s.authed(func(http.ResponseWriter, *http.Request) error { return nil })))
+
+	try := func(password string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		if password != "" {
+			req.SetBasicAuth("alice", password)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Requests without credentials are not failed logins.
+	for i := 0; i < 3; i++ {
+		if code := try(""); code != http.StatusUnauthorized {
+			t.Fatalf("got status %d without credentials", code)
+		}
+	}
+	if code := try("secret"); code != http.StatusNoContent {
+		t.Fatalf("got status %d with the right password", code)
+	}
+	try("wrong")
+	try("wrong")
+	if code := try("secret"); code != http.StatusTooManyRequests {
+		t.Errorf("got status %d when locked out", code)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "image/gif" // register GIF decoding
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// Resized thumbs are kept in the bucket under this prefix,
+	// so each size of each image is made only once.
+	thumbCachePrefix = "kodigcs/thumbs/"
+
+	maxThumbDim   = 4096
+	maxThumbBytes = 20 << 20
+)
+
+// handleResizedThumb serves the thumb at /thumbs/PATH
+// scaled down to fit within the width and height given as ?w= and ?h=
+// (either of which may be omitted),
+// preserving its aspect ratio.
+// Images are never scaled up.
+func (s *server) handleResizedThumb(w http.ResponseWriter, req *http.Request, path string) error {
+	var (
+		ctx  = req.Context()
+		q    = req.URL.Query()
+		dims [2]int
+	)
+	for i, param := range []string{"w", "h"} {
+		if !q.Has(param) {
+			continue
+		}
+		n, err := strconv.Atoi(q.Get(param))
+		if err != nil || n < 1 || n > maxThumbDim {
+			return mid.CodeErr{
+				C:   http.StatusBadRequest,
+				Err: fmt.Errorf("bad %s %q (want 1 to %d)", param, q.Get(param), maxThumbDim),
+			}
+		}
+		dims[i] = n
+	}
+
+	s.mu.RLock()
+	hidden := s.hiddenObj(path)
+	objName, origURL, err := s.thumbSource(path)
+	var etag string
+	if objName != "" {
+		if heads := s.objHeads.Load(); heads != nil {
+			etag = (*heads)[objName].etag
+		}
+	}
+	s.mu.RUnlock()
+
+	if hidden {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("/thumbs/%s is hidden", path),
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// The cached copy is named for the source image and the requested size,
+	// so a changed source gets a new copy.
+	source := "url:" + origURL
+	if objName != "" {
+		source = "obj:" + objName + ":" + etag
+	}
+	var (
+		sum    = sha256.Sum256([]byte(source))
+		asPNG  = strings.EqualFold(filepath.Ext(path), ".png")
+		outExt = ".jpg"
+	)
+	if asPNG {
+		outExt = ".png"
+	}
+	cacheName := fmt.Sprintf("%s%x-%dx%d%s", thumbCachePrefix, sum[:16], dims[0], dims[1], outExt)
+
+	err = s.serveObj(ctx, w, req, cacheName, "/thumbs/"+path, false)
+	if !errors.Is(err, storage.ErrObjectNotExist) {
+		return errors.Wrap(err, "serving resized thumb")
+	}
+
+	var src io.ReadCloser
+	if objName != "" {
+		src, err = s.bucket.Object(objName).NewReader(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", objName)
+		}
+	} else {
+		src, err = fetchImage(req, origURL)
+		if err != nil {
+			return err
+		}
+	}
+	defer src.Close()
+
+	content, err := resizeImage(io.LimitReader(src, maxThumbBytes), dims[0], dims[1], asPNG)
+	if err != nil {
+		return errors.Wrapf(err, "resizing /thumbs/%s", path)
+	}
+
+	contentType := objMIMEType(cacheName)
+
+	cw := s.bucket.Object(cacheName).NewWriter(ctx)
+	cw.ContentType = contentType
+	if _, err := cw.Write(content); err != nil {
+		log.Printf("Error caching resized thumb %s: %s", cacheName, err)
+	} else if err := cw.Close(); err != nil {
+		log.Printf("Error caching resized thumb %s: %s", cacheName, err)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, req, path, time.Now(), bytes.NewReader(content))
+	return nil
+}
+
+func fetchImage(req *http.Request, u string) (io.ReadCloser, error) {
+	r, err := http.NewRequestWithContext(req.Context(), "GET", u, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "building request for %s", u)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching %s", u)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, mid.CodeErr{
+			C:   http.StatusBadGateway,
+			Err: fmt.Errorf("status %d fetching %s", resp.StatusCode, u),
+		}
+	}
+	return resp.Body, nil
+}
+
+// resizeImage scales the image in r down to fit within width and height
+// (either of which may be 0, meaning no limit),
+// returning it as a PNG
+// (keeping any transparency, as in clear logos)
+// or a JPEG.
+func resizeImage(r io.Reader, width, height int, asPNG bool) ([]byte, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding image")
+	}
+
+	b := img.Bounds()
+	scale := 1.0
+	if width > 0 && b.Dx() > width {
+		scale = float64(width) / float64(b.Dx())
+	}
+	if height > 0 && float64(b.Dy())*scale > float64(height) {
+		scale = float64(height) / float64(b.Dy())
+	}
+	if scale < 1 {
+		dst := image.NewRGBA(image.Rect(0, 0, max(1, int(float64(b.Dx())*scale+0.5)), max(1, int(float64(b.Dy())*scale+0.5))))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+		img = dst
+	}
+
+	buf := new(bytes.Buffer)
+	if asPNG {
+		err = png.Encode(buf, img)
+		return buf.Bytes(), errors.Wrap(err, "encoding PNG")
+	}
+	err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 85})
+	return buf.Bytes(), errors.Wrap(err, "encoding JPEG")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+func TestResizeImage(t *testing.T) {
+	src := new(bytes.Buffer)
+	if err := png.Encode(src, image.NewRGBA(image.Rect(0, 0, 100, 50))); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		w, h, wantW, wantH int
+	}{
+		{w: 40, wantW: 40, wantH: 20},
+		{h: 10, wantW: 20, wantH: 10},
+		{w: 40, h: 10, wantW: 20, wantH: 10},
+		{w: 400, wantW: 100, wantH: 50}, // never scaled up
+	}
+	for _, c := range cases {
+		for _, asPNG := range []bool{false, true} {
+			out, err := resizeImage(bytes.NewReader(src.Bytes()), c.w, c.h, asPNG)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantFormat := map[bool]string{false: "jpeg", true: "png"}[asPNG]; format != wantFormat {
+				t.Errorf("got format %s, want %s", format, wantFormat)
+			}
+			if cfg.Width != c.wantW || cfg.Height != c.wantH {
+				t.Errorf("w=%d h=%d: got %dx%d, want %dx%d", c.w, c.h, cfg.Width, cfg.Height, c.wantW, c.wantH)
+			}
+		}
+	}
+}
+
+func TestHandleThumb(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		objNames: set.New(
+			"Top Hat.mp4",
+			"Top Hat.jpg",
+			"Swing Time.mp4",
+			"notes.jpg",
+			"kodigcs/acme/acme_account+key",
+			"kodigcs/grants.json",
+			"imdb-cache/tt0027125.html",
+		),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"Top Hat":    {Title: "Top Hat"},
+			"Swing Time": {Title: "Swing Time", Thumbs: []thumb{{Aspect: "poster", Val: "/thumbs/Swing%20Time.jpg", origVal: "https://example.com/swing.jpg"}}},
+		},
+		infoMapTime: now,
+	}
+
+	s.mu.RLock()
+	objName, _, err := s.thumbSource("Top Hat.jpg")
+	s.mu.RUnlock()
+	if err != nil || objName != "Top Hat.jpg" {
+		t.Errorf("got %q, %v for a title's poster", objName, err)
+	}
+
+	cases := map[string]int{
+		"/thumbs/Swing%20Time.jpg":                  http.StatusFound,
+		"/thumbs/kodigcs/acme/acme_account+key":     http.StatusNotFound,
+		"/thumbs/kodigcs%2Facme%2Facme_account+key": http.StatusNotFound,
+		"/thumbs/kodigcs/grants.json":               http.StatusNotFound,
+		"/thumbs/imdb-cache/tt0027125.html":         http.StatusNotFound,
+		"/thumbs/Top%20Hat.mp4":                     http.StatusNotFound, // not an image
+		"/thumbs/notes.jpg":                         http.StatusNotFound, // not a title's
+		"/thumbs/Swing%20Time.jpg?w=not-a-number":   http.StatusBadRequest,
+	}
+	for path, want := range cases {
+		rec := httptest.NewRecorder()
+		mid.Err(s.handleThumb).ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: got status %d, want %d", path, rec.Code, want)
+		}
+	}
+}
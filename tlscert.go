@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bobg/certs"
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+	"golang.org/x/crypto/ocsp"
+)
+
+// How often to refresh the OCSP staple of the certificate being served.
+const ocspRefreshInterval = 12 * time.Hour
+
+// certStatus is the result of checking a TLS certificate.
+// It is served as JSON at /debug/tls.
+type certStatus struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	ExpiresIn string    `json:"expires_in"`
+
+	// Chain is the subjects of the certificates supplied with the leaf,
+	// leaf first.
+	Chain []string `json:"chain"`
+
+	// Fetched is the subjects of intermediate certificates
+	// that were missing from the supplied chain
+	// and were fetched via the leaf's Authority Information Access extension.
+	Fetched []string `json:"fetched,omitempty"`
+
+	VerifyError string `json:"verify_error,omitempty"`
+
+	OCSPServer     string    `json:"ocsp_server,omitempty"`
+	OCSPStatus     string    `json:"ocsp_status,omitempty"`
+	OCSPNextUpdate time.Time `json:"ocsp_next_update,omitempty"`
+	OCSPError      string    `json:"ocsp_error,omitempty"`
+
+	Problems []string  `json:"problems,omitempty"`
+	Checked  time.Time `json:"checked"`
+}
+
+// prepareCert checks a certificate,
+// completes its chain if intermediates are missing,
+// and staples an OCSP response to it if possible.
+// The certificate is modified in place.
+// Problems are reported in the result rather than as errors,
+// so that a server with a flawed certificate can still run and report on it.
+func prepareCert(ctx context.Context, cert *tls.Certificate) (*certStatus, error) {
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("empty certificate")
+	}
+
+	chain := make([]*x509.Certificate, 0, len(cert.Certificate))
+	for i, der := range cert.Certificate {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing certificate %d in chain", i)
+		}
+		chain = append(chain, c)
+	}
+	leaf := chain[0]
+	cert.Leaf = leaf
+
+	status := &certStatus{
+		Subject:   leaf.Subject.String(),
+		Issuer:    leaf.Issuer.String(),
+		DNSNames:  leaf.DNSNames,
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+		ExpiresIn: time.Until(leaf.NotAfter).Round(time.Minute).String(),
+		Checked:   time.Now(),
+	}
+	for _, c := range chain {
+		status.Chain = append(status.Chain, c.Subject.String())
+	}
+
+	now := time.Now()
+	if now.After(leaf.NotAfter) {
+		status.Problems = append(status.Problems, "certificate has expired")
+	} else if leaf.NotAfter.Sub(now) < 7*24*time.Hour {
+		status.Problems = append(status.Problems, "certificate expires within a week")
+	}
+	if now.Before(leaf.NotBefore) {
+		status.Problems = append(status.Problems, "certificate is not yet valid")
+	}
+
+	// Complete the chain, following AIA issuer URLs from the last certificate supplied.
+	for i := 0; i < 5; i++ {
+		last := chain[len(chain)-1]
+		if isSelfSigned(last) || len(last.IssuingCertificateURL) == 0 {
+			break
+		}
+		if _, err := last.Verify(x509.VerifyOptions{CurrentTime: now}); err == nil {
+			// Last cert chains to a system root.
+			break
+		}
+		issuer, err := fetchIssuer(ctx, last)
+		if err != nil {
+			status.Problems = append(status.Problems, fmt.Sprintf("fetching issuer of %s: %s", last.Subject, err))
+			break
+		}
+		if err := last.CheckSignatureFrom(issuer); err != nil {
+			status.Problems = append(status.Problems, fmt.Sprintf("certificate fetched as the issuer of %s did not issue it", last.Subject))
+			break
+		}
+		if isSelfSigned(issuer) {
+			// Don't send roots.
+			break
+		}
+		chain = append(chain, issuer)
+		cert.Certificate = append(cert.Certificate, issuer.Raw)
+		status.Fetched = append(status.Fetched, issuer.Subject.String())
+	}
+	if len(status.Fetched) > 0 {
+		status.Problems = append(status.Problems, "supplied chain was incomplete (missing intermediates were fetched)")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Intermediates: intermediates, CurrentTime: now}); err != nil {
+		status.VerifyError = err.Error()
+		status.Problems = append(status.Problems, "chain does not verify")
+	}
+
+	if len(leaf.OCSPServer) > 0 && len(chain) > 1 {
+		status.OCSPServer = leaf.OCSPServer[0]
+		resp, raw, err := fetchOCSP(ctx, leaf, chain[1])
+		if err != nil {
+			status.OCSPError = err.Error()
+			status.Problems = append(status.Problems, "no OCSP staple")
+		} else {
+			cert.OCSPStaple = raw
+			status.OCSPNextUpdate = resp.NextUpdate
+			switch resp.Status {
+			case ocsp.Good:
+				status.OCSPStatus = "good"
+			case ocsp.Revoked:
+				status.OCSPStatus = "revoked"
+				status.Problems = append(status.Problems, "certificate has been revoked")
+			default:
+				status.OCSPStatus = "unknown"
+			}
+		}
+	}
+
+	return status, nil
+}
+
+func isSelfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil
+}
+
+func fetchIssuer(ctx context.Context, c *x509.Certificate) (*x509.Certificate, error) {
+	u := c.IssuingCertificateURL[0]
+	body, err := httpGetBytes(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := x509.ParseCertificate(body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing certificate from %s", u)
+	}
+	return issuer, nil
+}
+
+func fetchOCSP(ctx context.Context, leaf, issuer *x509.Certificate) (*ocsp.Response, []byte, error) {
+	reqBytes, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "creating OCSP request")
+	}
+
+	u := leaf.OCSPServer[0]
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "creating request for %s", u)
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "posting to %s", u)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("posting to %s: %s", u, resp.Status)
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "reading response from %s", u)
+	}
+
+	parsed, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parsing OCSP response")
+	}
+	return parsed, raw, nil
+}
+
+func httpGetBytes(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating request for %s", u)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting %s", u)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting %s: %s", u, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// setCert installs the certificate for the server to present,
+// after checking and preparing it with prepareCert.
+// A certificate that is unparseable, expired, or not yet valid is rejected,
+// leaving the previous certificate (if any) in place.
+func (s *server) setCert(ctx context.Context, cert tls.Certificate) error {
+	status, err := prepareCert(ctx, &cert)
+	if err != nil {
+		return err
+	}
+	for _, p := range status.Problems {
+		log.Printf("TLS certificate problem: %s", p)
+	}
+	now := time.Now()
+	if now.After(status.NotAfter) || now.Before(status.NotBefore) {
+		return fmt.Errorf("certificate is valid only from %s to %s", status.NotBefore, status.NotAfter)
+	}
+
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	if s.cert == nil || !bytes.Equal(cert.Certificate[0], s.cert.Certificate[0]) {
+		s.certInstalled = now
+	}
+	s.cert, s.certStatus = &cert, status
+	return nil
+}
+
+// Bounds on the delay before relaunching the cert command.
+const (
+	minCertCmdDelay = time.Second
+	maxCertCmdDelay = 5 * time.Minute
+)
+
+// certCmdBackoff is the delay before relaunching the cert command
+// after a run that lasted ran,
+// given the delay before that run
+// (zero if it was the first).
+// The delay doubles with each consecutive failure, up to maxCertCmdDelay,
+// and starts over after a run that lasted longer than that.
+func certCmdBackoff(prev, ran time.Duration) time.Duration {
+	if prev == 0 || ran > maxCertCmdDelay {
+		return minCertCmdDelay
+	}
+	return min(2*prev, maxCertCmdDelay)
+}
+
+// runCertCmd runs the cert command,
+// installing each valid certificate it produces.
+// If the command exits,
+// the server keeps serving with the last good certificate
+// while the command is relaunched after a delay
+// that grows with each consecutive failure.
+// The ready channel is closed when the first certificate is installed.
+// This continues until the context is canceled.
+func (s *server) runCertCmd(ctx context.Context, certcmd string, ready chan<- struct{}) {
+	var (
+		once   sync.Once
+		onCert = func() { once.Do(func() { close(ready) }) }
+		delay  time.Duration
+	)
+
+	for {
+		started := time.Now()
+		err := s.runCertCmdOnce(ctx, certcmd, onCert)
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.certMu.Lock()
+		s.certCmdRestarts++
+		s.certMu.Unlock()
+
+		delay = certCmdBackoff(delay, time.Since(started))
+		log.Printf("Cert command exited (%v), relaunching in %s", err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (s *server) runCertCmdOnce(ctx context.Context, certcmd string, onCert func()) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	certCh, wait, err := certs.FromCommand(ctx, certcmd)
+	if err != nil {
+		return errors.Wrap(err, "launching cert command")
+	}
+	defer func() {
+		// The channel may have closed because of a bad certificate while the command is still running.
+		// Stop it before waiting for it.
+		cancel()
+		err = errors.Join(err, wait())
+	}()
+
+	for cert := range certCh {
+		if err := s.setCert(ctx, cert); err != nil {
+			log.Printf("Rejecting certificate from cert command: %s", err)
+			continue
+		}
+		onCert()
+	}
+
+	return nil
+}
+
+// tlsVars reports certificate age and expiry for expvar.
+func (s *server) tlsVars() any {
+	s.certMu.RLock()
+	defer s.certMu.RUnlock()
+
+	result := map[string]any{
+		"certcmd_restarts": s.certCmdRestarts,
+	}
+	if s.certStatus != nil {
+		result["not_before"] = s.certStatus.NotBefore
+		result["not_after"] = s.certStatus.NotAfter
+		result["expires_in_secs"] = int64(time.Until(s.certStatus.NotAfter) / time.Second)
+		result["installed"] = s.certInstalled
+		result["age_secs"] = int64(time.Since(s.certInstalled) / time.Second)
+	}
+	return result
+}
+
+// getCertificate is for use as tls.Config.GetCertificate.
+func (s *server) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.certMu.RLock()
+	defer s.certMu.RUnlock()
+
+	if s.cert == nil {
+		return nil, fmt.Errorf("no certificate")
+	}
+	return s.cert, nil
+}
+
+// refreshOCSP periodically re-prepares the current certificate,
+// renewing its OCSP staple,
+// until the context is canceled.
+func (s *server) refreshOCSP(ctx context.Context) {
+	ticker := time.NewTicker(ocspRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			s.certMu.RLock()
+			cert := s.cert
+			s.certMu.RUnlock()
+
+			if cert == nil {
+				continue
+			}
+			if err := s.setCert(ctx, *cert); err != nil {
+				log.Printf("Error refreshing certificate: %s", err)
+			}
+		}
+	}
+}
+
+// handleTLS reports on the certificate being served.
+func (s *server) handleTLS(w http.ResponseWriter, req *http.Request) error {
+	s.certMu.RLock()
+	defer s.certMu.RUnlock()
+
+	if s.certStatus == nil {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("not serving TLS"),
+		}
+	}
+
+	status := *s.certStatus
+	status.ExpiresIn = time.Until(status.NotAfter).Round(time.Minute).String()
+	return mid.RespondJSON(w, status)
+}
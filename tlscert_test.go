@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/certs"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testCA is a certificate authority for tests:
+// a self-signed root and an intermediate signed by it,
+// with a server publishing both (for AIA chain completion)
+// and answering OCSP requests for the certificates the intermediate issues.
+type testCA struct {
+	root, inter       *x509.Certificate
+	rootKey, interKey *ecdsa.PrivateKey
+	url               string
+	revoked           bool // whether OCSP says the leaf is revoked
+}
+
+func newTestCA(t *testing.T) *testCA {
+	ca := new(testCA)
+	ca.root, ca.rootKey = newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	ca.inter, ca.interKey = newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, ca.root, ca.rootKey)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/inter.der":
+			w.Write(ca.inter.Raw)
+
+		case "/root.der":
+			w.Write(ca.root.Raw)
+
+		case "/ocsp":
+			body, _ := io.ReadAll(req.Body)
+			ocspReq, err := ocsp.ParseRequest(body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			tmpl := ocsp.Response{
+				Status:       ocsp.Good,
+				SerialNumber: ocspReq.SerialNumber,
+				ThisUpdate:   time.Now().Add(-time.Hour),
+				NextUpdate:   time.Now().Add(24 * time.Hour),
+			}
+			if ca.revoked {
+				tmpl.Status, tmpl.RevokedAt = ocsp.Revoked, time.Now().Add(-time.Hour)
+			}
+			resp, err := ocsp.CreateResponse(ca.inter, ca.inter, tmpl, ca.interKey)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(resp)
+
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	ca.url = srv.URL
+	return ca
+}
+
+// leaf returns a key pair for media.example.com issued by the intermediate,
+// valid between the given times,
+// with the intermediate's URL and the OCSP server in it,
+// and with no chain after the leaf.
+func (ca *testCA) leaf(t *testing.T, notBefore, notAfter time.Time) tls.Certificate {
+	c, key := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "media.example.com"},
+		DNSNames:              []string{"media.example.com"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IssuingCertificateURL: []string{ca.url + "/inter.der"},
+		OCSPServer:            []string{ca.url + "/ocsp"},
+	}, ca.inter, ca.interKey)
+	return tls.Certificate{Certificate: [][]byte{c.Raw}, PrivateKey: key}
+}
+
+// newTestCert creates a certificate from tmpl signed by parent,
+// or self-signed if parent is nil.
+// Unless tmpl says otherwise, it is valid from an hour ago to a month from now.
+func newTestCert(t *testing.T, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = serial
+	if tmpl.NotBefore.IsZero() {
+		tmpl.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if tmpl.NotAfter.IsZero() {
+		tmpl.NotAfter = time.Now().AddDate(0, 1, 0)
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, key
+}
+
+func TestPrepareCert(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ca  = newTestCA(t)
+	)
+
+	cert := ca.leaf(t, time.Now().Add(-time.Hour), time.Now().AddDate(0, 1, 0))
+	status, err := prepareCert(ctx, &cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The missing intermediate is fetched and appended, but not the root.
+	if len(cert.Certificate) != 2 || string(cert.Certificate[1]) != string(ca.inter.Raw) {
+		t.Errorf("got a chain of %d certificates, want the leaf and the intermediate", len(cert.Certificate))
+	}
+	if want := []string{ca.inter.Subject.String()}; !slices.Equal(status.Fetched, want) {
+		t.Errorf("got fetched %v, want %v", status.Fetched, want)
+	}
+	if !slices.Contains(status.Problems, "supplied chain was incomplete (missing intermediates were fetched)") {
+		t.Errorf("incomplete chain not reported in %v", status.Problems)
+	}
+	if cert.Leaf == nil || cert.Leaf.Subject.CommonName != "media.example.com" {
+		t.Error("leaf not set")
+	}
+
+	// The test root is not a system root.
+	if status.VerifyError == "" {
+		t.Error("chain to an unknown root verified")
+	}
+
+	if status.OCSPStatus != "good" || len(cert.OCSPStaple) == 0 {
+		t.Errorf("got OCSP status %q (error %q), %d-byte staple", status.OCSPStatus, status.OCSPError, len(cert.OCSPStaple))
+	}
+
+	// A supplied chain is used as is.
+	cert = ca.leaf(t, time.Now().Add(-time.Hour), time.Now().AddDate(0, 1, 0))
+	cert.Certificate = append(cert.Certificate, ca.inter.Raw)
+	if status, err = prepareCert(ctx, &cert); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Fetched) != 0 || len(cert.Certificate) != 2 {
+		t.Errorf("fetched %v for a complete chain", status.Fetched)
+	}
+
+	// A certificate fetched via AIA that did not issue the last one in the chain is not used.
+	wrongAIA, key := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "media.example.com"},
+		IssuingCertificateURL: []string{ca.url + "/root.der"},
+	}, ca.inter, ca.interKey)
+	cert = tls.Certificate{Certificate: [][]byte{wrongAIA.Raw}, PrivateKey: key}
+	if status, err = prepareCert(ctx, &cert); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Fetched) != 0 || len(cert.Certificate) != 1 {
+		t.Errorf("fetched %v from the wrong AIA URL", status.Fetched)
+	}
+	if want := "certificate fetched as the issuer of CN=media.example.com did not issue it"; !slices.Contains(status.Problems, want) {
+		t.Errorf("%q not reported in %v", want, status.Problems)
+	}
+
+	ca.revoked = true
+	cert = ca.leaf(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if status, err = prepareCert(ctx, &cert); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"certificate has been revoked", "certificate expires within a week"} {
+		if !slices.Contains(status.Problems, want) {
+			t.Errorf("%q not reported in %v", want, status.Problems)
+		}
+	}
+
+	// Bad leaves.
+	if _, err := prepareCert(ctx, &tls.Certificate{}); err == nil {
+		t.Error("no error for an empty certificate")
+	}
+	if _, err := prepareCert(ctx, &tls.Certificate{Certificate: [][]byte{[]byte("not DER")}}); err == nil {
+		t.Error("no error for an unparseable certificate")
+	}
+}
+
+func TestSetCert(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ca  = newTestCA(t)
+		s   = new(server)
+	)
+
+	rec := httptest.NewRecorder()
+	if err := s.handleTLS(rec, httptest.NewRequest("GET", "/debug/tls", nil)); errorCode(err) != http.StatusNotFound {
+		t.Errorf("got %v from /debug/tls without a certificate", err)
+	}
+
+	good := ca.leaf(t, time.Now().Add(-time.Hour), time.Now().AddDate(0, 1, 0))
+	if err := s.setCert(ctx, good); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, cert := range map[string]tls.Certificate{
+		"expired":          ca.leaf(t, time.Now().AddDate(0, -1, 0), time.Now().Add(-time.Hour)),
+		"not yet valid":    ca.leaf(t, time.Now().Add(time.Hour), time.Now().AddDate(0, 1, 0)),
+		"unparseable leaf": {Certificate: [][]byte{[]byte("not DER")}},
+	} {
+		if err := s.setCert(ctx, cert); err == nil {
+			t.Errorf("installed a certificate that is %s", name)
+		}
+	}
+	got, err := s.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Certificate[0]) != string(good.Certificate[0]) {
+		t.Error("good certificate replaced by a bad one")
+	}
+
+	rec = httptest.NewRecorder()
+	if err := s.handleTLS(rec, httptest.NewRequest("GET", "/debug/tls", nil)); err != nil {
+		t.Fatal(err)
+	}
+	var status certStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Subject != "CN=media.example.com" || len(status.Chain) != 1 || len(status.Fetched) != 1 || status.OCSPStatus != "good" {
+		t.Errorf("got status %+v", status)
+	}
+}
+
+func TestCertCmdBackoff(t *testing.T) {
+	var (
+		delay time.Duration
+		got   []time.Duration
+	)
+	for _, ran := range []time.Duration{0, time.Second, 0, 0, 0, 0, 0, 0, 0, 0, time.Hour, 0} {
+		delay = certCmdBackoff(delay, ran)
+		got = append(got, delay)
+	}
+	want := []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second, 64 * time.Second, 128 * time.Second, 256 * time.Second,
+		maxCertCmdDelay, // capped
+		time.Second,     // after a long run
+		2 * time.Second,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got delays %v, want %v", got, want)
+	}
+}
+
+func TestRunCertCmd(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ca  = newTestCA(t)
+		dir = t.TempDir()
+	)
+
+	// certCmd returns a cert command printing the given key pairs as certs.FromCommand expects.
+	certCmd := func(name string, pairs ...tls.Certificate) string {
+		var buf strings.Builder
+		for _, pair := range pairs {
+			keyDER, err := x509.MarshalECPrivateKey(pair.PrivateKey.(*ecdsa.PrivateKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+			json.NewEncoder(&buf).Encode(certs.X509KeyPair{
+				CertPEMBlock: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pair.Certificate[0]}),
+				KeyPEMBlock:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+			})
+		}
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(buf.String()), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return "cat " + file
+	}
+
+	var (
+		good       = ca.leaf(t, time.Now().Add(-time.Hour), time.Now().AddDate(0, 1, 0))
+		expired    = ca.leaf(t, time.Now().AddDate(0, -1, 0), time.Now().Add(-time.Hour))
+		mismatched = ca.leaf(t, time.Now().Add(-time.Hour), time.Now().AddDate(0, 1, 0))
+	)
+	mismatched.PrivateKey = good.PrivateKey
+
+	installed := func(s *server) []byte {
+		s.certMu.RLock()
+		defer s.certMu.RUnlock()
+		if s.cert == nil {
+			return nil
+		}
+		return s.cert.Certificate[0]
+	}
+
+	// An expired certificate is skipped;
+	// a certificate with the wrong key ends the run.
+	s := new(server)
+	var n int
+	err := s.runCertCmdOnce(ctx, certCmd("run1", good, expired, mismatched, good), func() { n++ })
+	if err == nil || !strings.Contains(err.Error(), "creating key pair") {
+		t.Errorf("got error %v for a mismatched key", err)
+	}
+	if n != 1 || string(installed(s)) != string(good.Certificate[0]) {
+		t.Errorf("got %d certificates installed, want just the good one", n)
+	}
+
+	// The command is relaunched after it exits,
+	// and the first certificate makes the server ready.
+	s = new(server)
+	var (
+		ready             = make(chan struct{})
+		done              = make(chan struct{})
+		cancelCtx, cancel = context.WithCancel(ctx)
+	)
+	go func() {
+		s.runCertCmd(cancelCtx, certCmd("run2", good), ready)
+		close(done)
+	}()
+	select {
+	case <-ready:
+	case <-time.After(10 * time.Second):
+		t.Fatal("not ready")
+	}
+	if string(installed(s)) != string(good.Certificate[0]) {
+		t.Error("certificate not installed")
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if s.tlsVars().(map[string]any)["certcmd_restarts"].(int) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cert command not relaunched")
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("runCertCmd did not stop")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/subcmd/v2"
+)
+
+// Tokens are grants of kind grantToken.
+// A client presents one as a bearer token,
+// in an "Authorization: Bearer TOKEN" header,
+// or, for clients that can't set headers,
+// in a token query parameter, as in /some/path?token=TOKEN.
+// Each token has a name,
+// which is the principal its requests authenticate as,
+// so each device can have its own token,
+// and revoking one leaves the others working.
+
+type tokencmd struct {
+	maincmd
+}
+
+func (c maincmd) token(ctx context.Context, args []string) error {
+	return subcmd.Run(ctx, tokencmd{c}, args)
+}
+
+func (c tokencmd) Subcmds() subcmd.Map {
+	return subcmd.Commands(
+		"issue", c.issue, "issue a token for a device or tool and print it", subcmd.Params(
+			"-name", subcmd.String, "", "name of the device or tool the token is for",
+			"-ttl", subcmd.Duration, time.Duration(0), "lifetime of the token (0 means no expiry)",
+		),
+		"list", c.list, "list the unexpired tokens", nil,
+		"revoke", c.revoke, "revoke tokens, given by ID or name", nil,
+	)
+}
+
+// issue issues a token and prints its secret,
+// which is not stored anywhere and cannot be shown again.
+func (c tokencmd) issue(ctx context.Context, name string, ttl time.Duration, _ []string) error {
+	if name == "" {
+		return fmt.Errorf("must specify -name")
+	}
+
+	grants := newGrantStore(c.bucket)
+	if err := grants.sync(ctx); err != nil {
+		return errors.Wrap(err, "loading grants")
+	}
+	token, g, err := grants.issue(grantToken, name, "", ttl)
+	if err != nil {
+		return errors.Wrap(err, "issuing token")
+	}
+	if err := grants.sync(ctx); err != nil {
+		return errors.Wrap(err, "saving grants")
+	}
+
+	fmt.Fprintf(os.Stderr, "Issued token %s for %s\n", g.ID, name)
+	fmt.Println(token)
+	return nil
+}
+
+func (c tokencmd) list(ctx context.Context, _ []string) error {
+	grants := newGrantStore(c.bucket)
+	if err := grants.sync(ctx); err != nil {
+		return errors.Wrap(err, "loading grants")
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tCREATED\tEXPIRES\tLAST USED")
+	for _, g := range grants.list() {
+		if g.Kind != grantToken {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", g.ID, g.Name, formatGrantTime(g.Created), formatGrantTime(g.Expires), formatGrantTime(g.LastUsed))
+	}
+	return tw.Flush()
+}
+
+func formatGrantTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// revoke revokes each token given by ID,
+// or all the tokens with a given name.
+// A running server stops accepting them within a minute.
+func (c tokencmd) revoke(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("must give token IDs or names")
+	}
+
+	grants := newGrantStore(c.bucket)
+	if err := grants.sync(ctx); err != nil {
+		return errors.Wrap(err, "loading grants")
+	}
+
+	ids := tokensNamed(grants.list(), args)
+	if len(ids) == 0 {
+		return fmt.Errorf("no tokens match")
+	}
+	for _, id := range ids {
+		grants.revoke(id)
+	}
+	if err := grants.sync(ctx); err != nil {
+		return errors.Wrap(err, "saving grants")
+	}
+
+	fmt.Fprintf(os.Stderr, "Revoked %d token(s)\n", len(ids))
+	return nil
+}
+
+// tokensNamed returns the IDs of the tokens among grants
+// whose IDs or names are among args.
+func tokensNamed(grants []grant, args []string) []string {
+	var ids []string
+	for _, g := range grants {
+		if g.Kind != grantToken {
+			continue
+		}
+		for _, arg := range args {
+			if g.ID == arg || g.Name == arg {
+				ids = append(ids, g.ID)
+				break
+			}
+		}
+	}
+	return ids
+}
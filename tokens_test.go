@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestTokens(t *testing.T) {
+	grants := &grantStore{
+		grants:  make(map[string]*grant),
+		known:   set.New[string](),
+		deleted: set.New[string](),
+	}
+	phone, g, err := grants.issue(grantToken, "phone", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := grants.issue(grantToken, "laptop", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	share, _, err := grants.issue(grantShare, "phone", "The Thin Man", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := tokenAuth{grants: grants}
+	try := func(target, bearer string) (string, error) {
+		req := httptest.NewRequest("GET", target, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		return auth.authenticate(httptest.NewRecorder(), req)
+	}
+
+	if name, err := try("/", phone); err != nil || name != "phone" {
+		t.Errorf("got %q, %v with a bearer token", name, err)
+	}
+	if name, err := try("/?token="+phone, ""); err != nil || name != "phone" {
+		t.Errorf("got %q, %v with a token parameter", name, err)
+	}
+	if _, err := try("/", ""); err == nil {
+		t.Error("got in with no token")
+	}
+	if _, err := try("/?token="+share, ""); err == nil {
+		t.Error("got in with a share secret as a token")
+	}
+
+	ids := tokensNamed(grants.list(), []string{"phone"})
+	if len(ids) != 1 || ids[0] != g.ID {
+		t.Fatalf("got %v for the phone's tokens, want [%s]", ids, g.ID)
+	}
+	if got := tokensNamed(grants.list(), []string{g.ID, "laptop"}); len(got) != 2 {
+		t.Errorf("got %v for an ID and a name", got)
+	}
+	grants.revoke(ids[0])
+	if _, err := try("/?token="+phone, ""); err == nil {
+		t.Error("got in with a revoked token")
+	}
+}
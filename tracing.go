@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bobg/errors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// With serve -otlp-endpoint,
+// the server records OpenTelemetry spans
+// for each request (named by its route),
+// for loading the list of bucket objects and the metadata,
+// and for reading media objects from GCS,
+// and exports them with OTLP over HTTP to a collector
+// (such as the OpenTelemetry Collector, Jaeger, or Grafana Tempo).
+// The standard OTEL_* environment variables
+// (such as OTEL_EXPORTER_OTLP_HEADERS, OTEL_SERVICE_NAME, and OTEL_TRACES_SAMPLER)
+// also apply.
+// Without -otlp-endpoint,
+// spans are not recorded.
+
+// tracer makes the server's spans.
+// Until setupTracing installs a provider,
+// it makes spans that record nothing.
+var tracer = otel.Tracer("github.com/bobg/kodigcs")
+
+// setupTracing installs a tracer provider exporting to the OTLP/HTTP collector at endpoint,
+// such as http://localhost:4318.
+// It returns a function that flushes and stops the exporter.
+func setupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	exp, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, errors.Wrap(err, "creating OTLP exporter")
+	}
+	res, err := resource.New(
+		ctx,
+		resource.WithAttributes(semconv.ServiceName("kodigcs")),
+		resource.WithFromEnv(), // after the default service name, so that OTEL_SERVICE_NAME overrides it
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "describing tracing resource")
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp.Shutdown, nil
+}
+
+// traced wraps a handler, if tracing is enabled,
+// so that each request has a span named by its route pattern.
+func (s *server) traced(pattern string, h http.Handler) http.Handler {
+	if !s.tracing {
+		return h
+	}
+	return otelhttp.NewHandler(h, pattern)
+}
+
+// endSpan ends a span,
+// recording err in it if that's not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedReader wraps the reader of a media object
+// to measure how long reading it from GCS takes,
+// apart from the time spent writing it to the client.
+type tracedReader struct {
+	r       io.ReadSeeker
+	span    trace.Span
+	n       int64
+	elapsed time.Duration
+	first   bool          // whether the first byte has been read
+	toFirst time.Duration // how long reading the first byte took
+}
+
+func newTracedReader(r io.ReadSeeker, span trace.Span) *tracedReader {
+	return &tracedReader{r: r, span: span}
+}
+
+func (t *tracedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	t.elapsed += time.Since(start)
+	if n > 0 && !t.first {
+		t.first, t.toFirst = true, t.elapsed
+		t.span.AddEvent("first byte")
+	}
+	t.n += int64(n)
+	return n, err
+}
+
+func (t *tracedReader) Seek(offset int64, whence int) (int64, error) {
+	return t.r.Seek(offset, whence)
+}
+
+// Close records the totals in the span.
+func (t *tracedReader) Close() error {
+	t.span.SetAttributes(
+		attribute.Int64("gcs.bytes_read", t.n),
+		attribute.Int64("gcs.read_ms", t.elapsed.Milliseconds()),
+	)
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prev)
+
+	s := &server{tracing: true}
+	h := s.traced("GET /video/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, span := tracer.Start(req.Context(), "serveObj")
+		r := newTracedReader(strings.NewReader("0123456789"), span)
+		io.Copy(w, r)
+		r.Close()
+		span.End()
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/video/Top%20Hat.mkv", nil))
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	inner, outer := spans[0], spans[1]
+	if outer.Name() != "GET /video/" {
+		t.Errorf("got request span name %q", outer.Name())
+	}
+	if inner.Parent().SpanID() != outer.SpanContext().SpanID() {
+		t.Error("object span is not a child of the request span")
+	}
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range inner.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if got := attrs["gcs.bytes_read"].AsInt64(); got != 10 {
+		t.Errorf("got gcs.bytes_read %d, want 10", got)
+	}
+	if events := inner.Events(); len(events) != 1 || events[0].Name != "first byte" {
+		t.Errorf("got events %v", events)
+	}
+
+	// Without -otlp-endpoint, requests have no spans.
+	h = (&server{}).traced("GET /", http.NotFoundHandler())
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if n := len(rec.Ended()); n != 2 {
+		t.Errorf("got %d spans with tracing off, want still 2", n)
+	}
+}
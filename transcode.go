@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// With serve -transcode N,
+// a title in a format that a client can't play
+// (such as an ISO image, or an .mkv file with codecs a browser doesn't support)
+// can be played from /transcode/ROOT,
+// which has ffmpeg convert it to HLS (HTTP Live Streaming)
+// in a temporary directory of its own,
+// remuxing H.264 video as is and transcoding anything else.
+// At most N ffmpeg processes run at once,
+// and one that no client has fetched from for a while is stopped
+// and its directory removed.
+//
+// ffmpeg reads the title from a listener on the loopback interface,
+// with range requests,
+// except that an ISO image is copied into the temporary directory first,
+// since ffmpeg can read a DVD image only from a file
+// (with its dvdvideo demuxer, in ffmpeg 7.1 and later).
+
+const (
+	// How long a transcode may go unfetched before it is stopped.
+	transcodeIdleTime = 2 * time.Minute
+
+	// How long a request for the HLS playlist waits for ffmpeg to write it.
+	transcodeWaitTime = 30 * time.Second
+
+	hlsPlaylistName = "index.m3u8"
+)
+
+// transcoder runs and keeps track of ffmpeg processes for serve -transcode.
+type transcoder struct {
+	ffmpeg, ffprobe string // paths of the programs; ffprobe may be empty
+	max             int    // maximum number of simultaneous sessions
+	inputAddr       string // of the loopback listener from which ffmpeg reads
+
+	mu       sync.Mutex
+	sessions map[string]*transcodeSession // by ID
+	inputs   map[string]string            // secret path on the loopback listener -> object name
+}
+
+// transcodeSession is one ffmpeg process converting one title for one user.
+type transcodeSession struct {
+	id, user, rootName string
+	dir                string // where ffmpeg writes the HLS playlist and segments
+	input              string // key in transcoder.inputs
+	cancel             context.CancelFunc
+	done               chan struct{} // closed when ffmpeg exits
+	err                error         // why ffmpeg exited, once done is closed
+	lastUsed           time.Time     // protected by transcoder.mu
+}
+
+// newTranscoder finds ffmpeg (and, if it can, ffprobe) in $PATH.
+func newTranscoder(max int) (*transcoder, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, errors.Wrap(err, "finding ffmpeg for -transcode")
+	}
+	ffprobe, _ := exec.LookPath("ffprobe") // without it, everything is transcoded
+	return &transcoder{
+		ffmpeg:   ffmpeg,
+		ffprobe:  ffprobe,
+		max:      max,
+		sessions: make(map[string]*transcodeSession),
+		inputs:   make(map[string]string),
+	}, nil
+}
+
+// startTranscoder starts the loopback listener from which ffmpeg reads the bucket's objects
+// and a goroutine stopping idle transcodes.
+// When the context is canceled,
+// all transcodes are stopped.
+func (s *server) startTranscoder(ctx context.Context) error {
+	tr := s.transcoder
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return errors.Wrap(err, "listening for ffmpeg")
+	}
+	tr.inputAddr = ln.Addr().String()
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			tr.mu.Lock()
+			objName, ok := tr.inputs[strings.TrimPrefix(req.URL.Path, "/")]
+			tr.mu.Unlock()
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			if err := s.serveObj(req.Context(), w, req, objName, req.URL.Path, false); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("Error serving %s to ffmpeg: %s", objName, err)
+			}
+		}),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go srv.Serve(ln)
+
+	go func() {
+		ticker := time.NewTicker(transcodeIdleTime / 4)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				srv.Close()
+				tr.stopIdle(0)
+				return
+
+			case <-ticker.C:
+				tr.stopIdle(transcodeIdleTime)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// stopIdle stops the sessions that have gone unfetched for at least the given time.
+func (tr *transcoder) stopIdle(idle time.Duration) {
+	tr.mu.Lock()
+	var stale []*transcodeSession
+	for id, sess := range tr.sessions {
+		if time.Since(sess.lastUsed) >= idle {
+			stale = append(stale, sess)
+			delete(tr.sessions, id)
+			delete(tr.inputs, sess.input)
+		}
+	}
+	tr.mu.Unlock()
+
+	for _, sess := range stale {
+		sess.stop()
+	}
+}
+
+// stop kills the session's ffmpeg process, if it is still running,
+// and removes its directory.
+func (sess *transcodeSession) stop() {
+	sess.cancel()
+	<-sess.done
+	if err := os.RemoveAll(sess.dir); err != nil {
+		log.Printf("Error removing %s: %s", sess.dir, err)
+	}
+	log.Printf("Stopped transcoding %s for %q", sess.rootName, sess.user)
+}
+
+// failed tells whether the session's ffmpeg has exited with an error.
+func (sess *transcodeSession) failed() bool {
+	select {
+	case <-sess.done:
+		return sess.err != nil
+	default:
+		return false
+	}
+}
+
+// handleTranscode starts (or finds) the requesting user's transcode of the title at /transcode/ROOT
+// and redirects to its HLS playlist.
+func (s *server) handleTranscode(w http.ResponseWriter, req *http.Request) error {
+	var (
+		ctx      = req.Context()
+		rootName = req.PathValue("rootname")
+		user     = principal(ctx)
+	)
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	info := s.infoMap[rootName]
+	objName, ok := s.mediaObjName(rootName)
+	ok = ok && !info.hidden && !s.isEpisode(objName) && !s.isExtra(objName)
+	var whole bool // whether the title is the single object objName
+	if ok {
+		whole = info.parts == "" && !s.isDiscObj(objName)
+	}
+	accessErr := s.titleAccessErr(ctx, rootName)
+	s.mu.RUnlock()
+
+	if !ok {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no title %s", rootName),
+		}
+	}
+	if accessErr != nil {
+		return accessErr
+	}
+	if !whole {
+		return mid.CodeErr{
+			C:   http.StatusNotImplemented,
+			Err: fmt.Errorf("cannot transcode %s, which is not a single object", rootName),
+		}
+	}
+
+	sess, err := s.transcodeSession(user, rootName, objName)
+	if err != nil {
+		return err
+	}
+	s.auditStream(req, objName)
+	http.Redirect(w, req, "/hls/"+sess.id+"/"+hlsPlaylistName, http.StatusFound)
+	return nil
+}
+
+// transcodeSession returns the user's running transcode of a title,
+// starting it if need be.
+// If the maximum number of sessions are already running,
+// the least recently fetched one is stopped to make room,
+// unless it was fetched in the last minute.
+func (s *server) transcodeSession(user, rootName, objName string) (*transcodeSession, error) {
+	tr := s.transcoder
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	var lru *transcodeSession
+	for id, sess := range tr.sessions {
+		if sess.user == user && sess.rootName == rootName {
+			if !sess.failed() {
+				sess.lastUsed = time.Now()
+				return sess, nil
+			}
+			// Try again.
+			delete(tr.sessions, id)
+			delete(tr.inputs, sess.input)
+			go sess.stop()
+			continue
+		}
+		if lru == nil || sess.lastUsed.Before(lru.lastUsed) {
+			lru = sess
+		}
+	}
+	if len(tr.sessions) >= tr.max {
+		if lru == nil || time.Since(lru.lastUsed) < time.Minute {
+			return nil, mid.CodeErr{
+				C:   http.StatusServiceUnavailable,
+				Err: fmt.Errorf("already running %d transcodes", len(tr.sessions)),
+			}
+		}
+		delete(tr.sessions, lru.id)
+		delete(tr.inputs, lru.input)
+		go lru.stop()
+	}
+
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return nil, errors.Wrap(err, "generating session ID")
+	}
+	id := hex.EncodeToString(buf[:8])
+	input := hex.EncodeToString(buf[8:])
+
+	dir, err := os.MkdirTemp("", "kodigcs-transcode-")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating transcode directory")
+	}
+
+	// The process outlives the request that starts it.
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sess := &transcodeSession{
+		id:       id,
+		user:     user,
+		rootName: rootName,
+		dir:      dir,
+		input:    input,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		lastUsed: time.Now(),
+	}
+	tr.sessions[id] = sess
+	tr.inputs[input] = objName
+
+	go func() {
+		defer close(sess.done)
+		sess.err = s.runTranscode(ctx, sess, objName)
+		if sess.err != nil && ctx.Err() == nil {
+			log.Printf("Error transcoding %s: %s", rootName, sess.err)
+		}
+	}()
+
+	log.Printf("Transcoding %s for %q in %s", rootName, user, dir)
+	return sess, nil
+}
+
+// runTranscode runs ffmpeg for a session,
+// first copying the title into the session's directory if it's an ISO image.
+func (s *server) runTranscode(ctx context.Context, sess *transcodeSession, objName string) error {
+	tr := s.transcoder
+
+	var (
+		input = "http://" + tr.inputAddr + "/" + sess.input
+		iso   = strings.EqualFold(filepath.Ext(objName), ".iso")
+	)
+	if iso {
+		input = filepath.Join(sess.dir, "input.iso")
+		if err := s.copyObjToFile(ctx, objName, input); err != nil {
+			return err
+		}
+	}
+
+	copyVideo := !iso && probeVideoCodec(ctx, tr.ffprobe, input) == "h264"
+
+	cmd := exec.CommandContext(ctx, tr.ffmpeg, ffmpegArgs(input, sess.dir, iso, copyVideo)...)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "running ffmpeg: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// copyObjToFile copies the named object in the bucket to a local file.
+func (s *server) copyObjToFile(ctx context.Context, objName, filename string) error {
+	r, err := s.bucket.Object(objName).NewReader(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", objName)
+	}
+	defer r.Close()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "creating %s", filename)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "copying %s to %s", objName, filename)
+	}
+	return errors.Wrapf(f.Close(), "closing %s", filename)
+}
+
+// probeVideoCodec returns the name of the codec of the first video stream of input
+// (such as "h264" or "hevc"),
+// or "" if it can't tell.
+func probeVideoCodec(ctx context.Context, ffprobe, input string) string {
+	if ffprobe == "" {
+		return ""
+	}
+	out, err := exec.CommandContext(ctx, ffprobe,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=codec_name",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		input,
+	).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// ffmpegArgs are the arguments for ffmpeg to convert input to HLS in dir,
+// copying H.264 video if copyVideo is true
+// and converting audio to stereo AAC,
+// which every HLS client can play.
+func ffmpegArgs(input, dir string, iso, copyVideo bool) []string {
+	args := []string{"-nostdin", "-hide_banner", "-loglevel", "error"}
+	if iso {
+		args = append(args, "-f", "dvdvideo")
+	}
+	args = append(args, "-i", input, "-map", "0:v:0", "-map", "0:a:0?")
+	if copyVideo {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "21", "-pix_fmt", "yuv420p")
+	}
+	return append(args,
+		"-c:a", "aac", "-ac", "2", "-b:a", "192k",
+		"-f", "hls",
+		"-hls_time", "6",
+		"-hls_playlist_type", "event",
+		"-hls_segment_filename", filepath.Join(dir, "seg%05d.ts"),
+		filepath.Join(dir, hlsPlaylistName),
+	)
+}
+
+// handleHLS serves the HLS playlist or a segment of a transcode,
+// at /hls/ID/FILE.
+// A request for the playlist waits for ffmpeg to write it.
+func (s *server) handleHLS(w http.ResponseWriter, req *http.Request) error {
+	var (
+		tr   = s.transcoder
+		id   = req.PathValue("id")
+		file = req.PathValue("file")
+	)
+
+	tr.mu.Lock()
+	sess, ok := tr.sessions[id]
+	if ok {
+		sess.lastUsed = time.Now()
+	}
+	tr.mu.Unlock()
+
+	if !ok || file != filepath.Base(file) || (file != hlsPlaylistName && !strings.HasSuffix(file, ".ts")) {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no transcode file %s/%s", id, file),
+		}
+	}
+
+	filename := filepath.Join(sess.dir, file)
+	if file == hlsPlaylistName {
+		if err := waitForFile(req.Context(), filename, sess.done); err != nil {
+			if sess.failed() {
+				return errors.Wrapf(sess.err, "transcoding %s", sess.rootName)
+			}
+			return mid.CodeErr{
+				C:   http.StatusServiceUnavailable,
+				Err: errors.Wrapf(err, "waiting for transcode of %s", sess.rootName),
+			}
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "video/mp2t")
+	}
+
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no transcode file %s/%s", id, file),
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "opening %s", filename)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "statting %s", filename)
+	}
+	http.ServeContent(w, req, file, fi.ModTime(), f)
+	return nil
+}
+
+// waitForFile waits for the named file to exist,
+// for up to transcodeWaitTime,
+// or until done is closed.
+func waitForFile(ctx context.Context, filename string, done <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(ctx, transcodeWaitTime)
+	defer cancel()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if _, err := os.Stat(filename); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			if _, err := os.Stat(filename); err == nil {
+				return nil
+			}
+			return fmt.Errorf("ffmpeg exited without writing %s", filepath.Base(filename))
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+func TestFFmpegArgs(t *testing.T) {
+	args := strings.Join(ffmpegArgs("http://127.0.0.1:1234/x", "/tmp/t", false, true), " ")
+	for _, want := range []string{"-i http://127.0.0.1:1234/x", "-c:v copy", "-c:a aac", "-f hls", "/tmp/t/seg%05d.ts /tmp/t/index.m3u8"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args %q lack %q", args, want)
+		}
+	}
+	args = strings.Join(ffmpegArgs("/tmp/t/input.iso", "/tmp/t", true, false), " ")
+	for _, want := range []string{"-f dvdvideo -i /tmp/t/input.iso", "-c:v libx264"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args %q lack %q", args, want)
+		}
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script standing in for ffmpeg")
+	}
+
+	// A stand-in for ffmpeg that writes a playlist to its last argument
+	// and runs until it's killed.
+	ffmpeg := filepath.Join(t.TempDir(), "ffmpeg")
+	script := "#!/bin/sh\nfor last; do :; done\nprintf '#EXTM3U\\n' > \"$last\"\nexec sleep 60\n"
+	if err := os.WriteFile(ffmpeg, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	s := &server{
+		objNames:     set.New("Metropolis.mkv", "Napoleon/part1.mkv", "Napoleon/part2.mkv"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"Napoleon": {Title: "Napoléon", parts: "Napoleon/part*.mkv"},
+		},
+		infoMapTime: now,
+		transcoder: &transcoder{
+			ffmpeg:   ffmpeg,
+			max:      1,
+			sessions: make(map[string]*transcodeSession),
+			inputs:   make(map[string]string),
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.startTranscoder(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /transcode/{rootname...}", mid.Err(s.handleTranscode))
+	mux.Handle("GET /hls/{id}/{file}", mid.Err(s.handleHLS))
+	get := func(p, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", p, nil)
+		req = req.WithContext(context.WithValue(req.Context(), principalKey, user))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/transcode/Metropolis", "alice")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("got status %d starting a transcode: %s", rec.Code, rec.Body)
+	}
+	playlist := rec.Header().Get("Location")
+
+	rec = get(playlist, "alice")
+	if rec.Code != http.StatusOK || rec.Body.String() != "#EXTM3U\n" {
+		t.Fatalf("got status %d and %q for the playlist", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/vnd.apple.mpegurl" {
+		t.Errorf("got content type %s for the playlist", got)
+	}
+
+	if rec := get("/transcode/Metropolis", "alice"); rec.Header().Get("Location") != playlist {
+		t.Error("a second request started a second transcode")
+	}
+	if rec := get("/transcode/Metropolis", "bob"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d exceeding the limit on transcodes", rec.Code)
+	}
+	if rec := get("/transcode/Napoleon", "alice"); rec.Code != http.StatusNotImplemented {
+		t.Errorf("got status %d transcoding a multi-part title", rec.Code)
+	}
+	if rec := get(strings.Replace(playlist, "index.m3u8", "..%2Fx.ts", 1), "alice"); rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d for a path outside the transcode", rec.Code)
+	}
+
+	var dir string
+	for _, sess := range s.transcoder.sessions {
+		dir = sess.dir
+	}
+	s.transcoder.stopIdle(0)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("transcode directory %s remains after stopping", dir)
+	}
+	if rec := get(playlist, "alice"); rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d for the playlist of a stopped transcode", rec.Code)
+	}
+}
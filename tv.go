@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+	"google.golang.org/api/sheets/v4"
+)
+
+// With serve -tv,
+// episodes of TV shows,
+// stored in the bucket as SHOW/Season NN/FILE
+// (where FILE names the episode as S01E03 or 1x03, as in "S01E03.mkv"),
+// are served under the virtual directory tv/
+// instead of among the movies,
+// for a Kodi source whose content is TV shows.
+// Each show's folder has a tvshow.nfo file,
+// and each episode an .nfo file of <episodedetails>.
+// Their metadata comes from the Series tab of the metadata spreadsheet.
+
+// The virtual directory under which TV shows are listed, in -tv mode.
+const tvDir = "tv"
+
+// seriesTab is the metadata spreadsheet tab describing TV shows and their episodes.
+// Each row has a Show, the name of the show's folder in the bucket.
+// A row with a Season and Episode describes that episode,
+// and a row without describes the show.
+const seriesTab = "Series"
+
+var (
+	seasonDirRE = regexp.MustCompile(`(?i)^(?:season|series|staffel|saison)[ ._-]*(\d+)$`)
+	episodeRE   = regexp.MustCompile(`(?i)(?:\bs(\d+)[ ._-]*e(\d+))|(?:\b(\d+)x(\d+)\b)`)
+)
+
+type (
+	tvShowInfo struct {
+		XMLName   xml.Name `xml:"tvshow"`
+		Title     string   `xml:"title,omitempty"`
+		Year      int      `xml:"year,omitempty"`
+		Premiered string   `xml:"premiered,omitempty"`
+		Plot      string   `xml:"plot,omitempty"`
+		Genre     string   `xml:"genre,omitempty"`
+		MPAA      string   `xml:"mpaa,omitempty"`
+		Studios   []string `xml:"studio,omitempty"`
+		Thumbs    []thumb  `xml:"thumb,omitempty"`
+		imdbID    string
+	}
+
+	tvEpisodeInfo struct {
+		XMLName xml.Name `xml:"episodedetails"`
+		Title   string   `xml:"title,omitempty"`
+		Show    string   `xml:"showtitle,omitempty"`
+		Season  int      `xml:"season"`
+		Episode int      `xml:"episode"`
+		Aired   string   `xml:"aired,omitempty"`
+		Plot    string   `xml:"plot,omitempty"`
+		imdbID  string
+	}
+
+	// tvSeries is the metadata of a TV show from the Series tab.
+	tvSeries struct {
+		show     tvShowInfo
+		episodes map[[2]int]tvEpisodeInfo // keyed by season and episode
+	}
+)
+
+// parseEpisodeObj parses the name of an episode's media object,
+// SHOW/SEASONDIR/FILE,
+// returning the show's folder name and the season and episode numbers.
+// The season comes from FILE if it has one,
+// otherwise from SEASONDIR.
+// "Specials" is season 0.
+func parseEpisodeObj(objName string) (show string, season, episode int, ok bool) {
+	parts := strings.Split(objName, "/")
+	if len(parts) != 3 || parts[0] == "" || !isMediaExt(path.Ext(parts[2])) {
+		return "", 0, 0, false
+	}
+
+	season = -1
+	if strings.EqualFold(parts[1], "specials") {
+		season = 0
+	} else if m := seasonDirRE.FindStringSubmatch(parts[1]); m != nil {
+		season, _ = strconv.Atoi(m[1])
+	}
+	if season < 0 {
+		return "", 0, 0, false
+	}
+
+	m := episodeRE.FindStringSubmatch(parts[2])
+	if m == nil {
+		return "", 0, 0, false
+	}
+	s, e := m[1], m[2]
+	if s == "" {
+		s, e = m[3], m[4]
+	}
+	season, _ = strconv.Atoi(s)
+	episode, _ = strconv.Atoi(e)
+
+	return parts[0], season, episode, true
+}
+
+// isEpisode tells whether the named object is served as a TV episode,
+// and therefore not as a movie.
+func (s *server) isEpisode(objName string) bool {
+	if !s.tv {
+		return false
+	}
+	_, _, _, ok := parseEpisodeObj(objName)
+	return ok
+}
+
+// readSeries reads the TV show metadata from the metadata spreadsheet,
+// keyed by show folder name.
+// It is not an error for the spreadsheet to have no Series tab.
+func readSeries(ctx context.Context, ssvc *sheets.SpreadsheetsService, sheetID string) (map[string]*tvSeries, error) {
+	ok, err := hasTab(ctx, ssvc, sheetID, seriesTab)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	resp, err := ssvc.Values.Get(sheetID, quoteTab(seriesTab)).Context(ctx).Do()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s tab", seriesTab)
+	}
+	return parseSeries(resp.Values)
+}
+
+// seriesHeadings parses the headings row of the Series tab,
+// returning the canonical headings.
+func seriesHeadings(row []interface{}) ([]string, error) {
+	var headings []string
+	for _, rawheading := range row {
+		heading, _ := rawheading.(string)
+		headings = append(headings, headingAliases().canonical(strings.TrimSpace(heading)))
+	}
+	if !slices.Contains(headings, "show") {
+		return nil, fmt.Errorf("%s tab needs a Show column", seriesTab)
+	}
+	return headings, nil
+}
+
+// parseSeries parses the rows of the Series tab, headings first.
+func parseSeries(values [][]interface{}) (map[string]*tvSeries, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	headings, err := seriesHeadings(values[0])
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*tvSeries)
+	get := func(name string) *tvSeries {
+		ser, ok := result[name]
+		if !ok {
+			ser = &tvSeries{show: tvShowInfo{Title: name}, episodes: make(map[[2]int]tvEpisodeInfo)}
+			result[name] = ser
+		}
+		return ser
+	}
+
+	for _, row := range values[1:] {
+		cells := make(map[string]string)
+		for j, rawval := range row {
+			if j >= len(headings) {
+				break
+			}
+			if val, ok := rawval.(string); ok {
+				if val = strings.TrimSpace(val); val != "" {
+					cells[headings[j]] = val
+				}
+			}
+		}
+		name := cells["show"]
+		if name == "" {
+			continue
+		}
+		ser := get(name)
+
+		if cells["season"] != "" && cells["episode"] != "" {
+			season, err1 := strconv.Atoi(cells["season"])
+			episode, err2 := strconv.Atoi(cells["episode"])
+			if err1 != nil || err2 != nil {
+				log.Printf("%s tab: cannot parse season %q and episode %q of %s", seriesTab, cells["season"], cells["episode"], name)
+				continue
+			}
+			aired := cells["aired"]
+			if aired == "" {
+				aired = cells["premiered"]
+			}
+			ser.episodes[[2]int{season, episode}] = tvEpisodeInfo{
+				Title:   cells["title"],
+				Season:  season,
+				Episode: episode,
+				Aired:   aired,
+				Plot:    cells["plot"],
+				imdbID:  parseIMDbID(cells["imdbid"]),
+			}
+			continue
+		}
+
+		show := &ser.show
+		if t := cells["title"]; t != "" {
+			show.Title = t
+		}
+		show.Year, _ = strconv.Atoi(cells["year"])
+		show.Premiered = cells["premiered"]
+		show.Plot = cells["plot"]
+		show.Genre = cells["genre"]
+		show.MPAA = cells["mpaa"]
+		if studio := cells["studio"]; studio != "" {
+			show.Studios = splitsemi(studio)
+		}
+		if poster := cells["poster"]; poster != "" {
+			show.Thumbs = []thumb{{Aspect: "poster", Val: poster, origVal: poster}}
+		}
+		show.imdbID = parseIMDbID(cells["imdbid"])
+	}
+
+	return result, nil
+}
+
+// episodeObj is the show, season, and episode of an episode's media object.
+type episodeObj struct {
+	show            string
+	season, episode int
+}
+
+// handleTV serves the tv/ virtual directory and everything under it.
+func (s *server) handleTV(w http.ResponseWriter, req *http.Request) error {
+	if done, err := checkMethod(w, req); done || err != nil {
+		return err
+	}
+
+	p := strings.Trim(strings.TrimPrefix(strings.Trim(req.URL.Path, "/"), tvDir), "/")
+
+	ctx := req.Context()
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	stream, err := s.tvRespond(w, req, p)
+	s.mu.RUnlock()
+
+	if err != nil || !stream {
+		return err
+	}
+
+	// The lock isn't held while streaming.
+	s.auditStream(req, p)
+	err = s.serveObj(ctx, w, req, p, req.URL.Path, s.isVerbose())
+	if isStreamFailure(err) {
+		s.health.streamFailures.Add(1)
+	}
+	return errors.Wrap(err, "serving object")
+}
+
+// tvRespond answers a request for the path p under tv/,
+// except when p is an object to stream,
+// in which case it returns true.
+// The caller must hold s.mu.
+func (s *server) tvRespond(w http.ResponseWriter, req *http.Request, p string) (bool, error) {
+	var (
+		shows    = set.New[string]()
+		folders  = set.New[string]() // SHOW/SEASONDIR
+		episodes = make(map[string]episodeObj)
+	)
+	for objName := range s.objNames {
+		show, season, episode, ok := parseEpisodeObj(objName)
+		if !ok {
+			continue
+		}
+		shows.Add(show)
+		folders.Add(path.Dir(objName))
+		episodes[objName] = episodeObj{show: show, season: season, episode: episode}
+	}
+
+	propfindFile := func() error {
+		return writeMultistatus(w, davMultistatus{
+			Responses: []davResponse{s.davFileResponse(req.URL.EscapedPath(), path.Base(p), p)},
+		})
+	}
+
+	if p == "" {
+		var items []template.URL
+		for _, show := range sortedSet(shows) {
+			items = append(items, template.URL(show+"/"))
+		}
+		return false, s.writeObjDir(w, req, p, items)
+	}
+
+	if shows.Has(p) || folders.Has(p) {
+		var items []template.URL
+		if shows.Has(p) {
+			items = append(items, "tvshow.nfo")
+		}
+		for _, folder := range sortedSet(folders) {
+			if path.Dir(folder) == p {
+				items = append(items, template.URL(path.Base(folder)+"/"))
+			}
+		}
+		children := set.New[string]()
+		for objName := range s.objNames {
+			if path.Dir(objName) != p {
+				continue
+			}
+			children.Add(path.Base(objName))
+			if _, ok := episodes[objName]; ok {
+				children.Add(strings.TrimSuffix(path.Base(objName), path.Ext(objName)) + ".nfo")
+			}
+		}
+		for _, child := range sortedSet(children) {
+			items = append(items, template.URL(child))
+		}
+		return false, s.writeObjDir(w, req, p, items)
+	}
+
+	if isAPIDir(req) {
+		return false, mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("%s is not a directory", p),
+		}
+	}
+
+	if path.Base(p) == "tvshow.nfo" && shows.Has(path.Dir(p)) {
+		if req.Method == "PROPFIND" {
+			return false, propfindFile()
+		}
+		show := path.Dir(p)
+		info := tvShowInfo{Title: show}
+		if ser, ok := s.series[show]; ok {
+			info = ser.show
+		}
+		return false, s.writeNFO(w, req, p, info, info.imdbID)
+	}
+
+	if !s.objNames.Has(p) && path.Ext(p) == ".nfo" {
+		rootName := strings.TrimSuffix(p, ".nfo")
+		for objName, ep := range episodes {
+			if strings.TrimSuffix(objName, path.Ext(objName)) != rootName {
+				continue
+			}
+			if req.Method == "PROPFIND" {
+				return false, propfindFile()
+			}
+			info := tvEpisodeInfo{Show: ep.show, Season: ep.season, Episode: ep.episode}
+			if ser, ok := s.series[ep.show]; ok {
+				if e, ok := ser.episodes[[2]int{ep.season, ep.episode}]; ok {
+					info = e
+				}
+				info.Show = ser.show.Title
+			}
+			if info.Title == "" {
+				info.Title = fmt.Sprintf("Episode %d", ep.episode)
+			}
+			return false, s.writeNFO(w, req, p, info, info.imdbID)
+		}
+	}
+
+	if !s.objNames.Has(p) || !shows.Has(path.Dir(p)) && !folders.Has(path.Dir(p)) {
+		return false, mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no TV object %s", p),
+		}
+	}
+	if req.Method == "PROPFIND" {
+		return false, propfindFile()
+	}
+	return true, nil
+}
+
+// writeNFO serves info as the .nfo file at the path p under tv/.
+// The caller must hold s.mu.
+func (s *server) writeNFO(w http.ResponseWriter, req *http.Request, p string, info any, imdbID string) error {
+	b, err := nfoBytes(info, imdbID)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	return s.serveGenerated(w, req, p, b)
+}
+
+func sortedSet(s set.Of[string]) []string {
+	result := s.Slice()
+	sort.Slice(result, func(i, j int) bool { return naturalLess(result[i], result[j]) })
+	return result
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestParseEpisodeObj(t *testing.T) {
+	cases := []struct {
+		objName         string
+		show            string
+		season, episode int
+		ok              bool
+	}{{
+		objName: "The Show/Season 01/S01E03.mkv",
+		show:    "The Show", season: 1, episode: 3, ok: true,
+	}, {
+		objName: "The Show/Season 2/The Show - 2x10 - Pilot.m4v",
+		show:    "The Show", season: 2, episode: 10, ok: true,
+	}, {
+		objName: "The Show/Specials/s00e01.mp4",
+		show:    "The Show", season: 0, episode: 1, ok: true,
+	}, {
+		objName: "The Show/Season 01/S01E03.nfo",
+	}, {
+		objName: "The Show/Extras/S01E03.mp4",
+	}, {
+		objName: "The Show/Season 01/Behind the scenes.mp4",
+	}, {
+		objName: "Season 01/S01E03.mp4",
+	}, {
+		objName: "The Show/S01E03.mp4",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.objName, func(t *testing.T) {
+			show, season, episode, ok := parseEpisodeObj(c.objName)
+			if ok != c.ok {
+				t.Fatalf("got ok %v, want %v", ok, c.ok)
+			}
+			if !ok {
+				return
+			}
+			if show != c.show || season != c.season || episode != c.episode {
+				t.Errorf("got %q %d %d, want %q %d %d", show, season, episode, c.show, c.season, c.episode)
+			}
+		})
+	}
+}
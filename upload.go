@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"google.golang.org/api/iterator"
+)
+
+// An object with this metadata key is an alias:
+// it has no content of its own,
+// and the server serves the object named by the metadata value in its place.
+const aliasMetadataKey = "kodigcs-alias"
+
+// Ways of handling an upload whose content duplicates an existing object.
+const (
+	dupsAsk    = "ask"
+	dupsAlias  = "alias"
+	dupsSkip   = "skip"
+	dupsUpload = "upload"
+)
+
+func (c maincmd) upload(ctx context.Context, dups string, files []string) error {
+	switch dups {
+	case dupsAsk, dupsAlias, dupsSkip, dupsUpload:
+	default:
+		return fmt.Errorf("unknown -dups value %s", dups)
+	}
+
+	log.Print("Indexing bucket contents")
+
+	idx, err := indexBucket(ctx, c.bucket)
+	if err != nil {
+		return errors.Wrap(err, "indexing bucket")
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+
+	for _, file := range files {
+		if err := uploadFile(ctx, c.bucket, idx, file, dups, stdin); err != nil {
+			return errors.Wrapf(err, "uploading %s", file)
+		}
+	}
+
+	return nil
+}
+
+// contentKey identifies object content.
+// The MD5 hash is absent for composite objects,
+// so those are also indexed by CRC32C and size.
+type contentKey struct {
+	md5    string
+	crc32c uint32
+	size   int64
+}
+
+type bucketIndex struct {
+	bucket *storage.BucketHandle
+	byMD5  map[string]string     // MD5 -> object name
+	byCRC  map[contentKey]string // CRC32C and size (only) -> name of an object without an MD5 hash
+	names  map[string]contentKey
+}
+
+func newBucketIndex(bucket *storage.BucketHandle) *bucketIndex {
+	return &bucketIndex{
+		bucket: bucket,
+		byMD5:  make(map[string]string),
+		byCRC:  make(map[contentKey]string),
+		names:  make(map[string]contentKey),
+	}
+}
+
+func indexBucket(ctx context.Context, bucket *storage.BucketHandle) (*bucketIndex, error) {
+	idx := newBucketIndex(bucket)
+
+	iter := bucket.Objects(ctx, nil)
+	for {
+		attrs, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "iterating over bucket")
+		}
+		if attrs.Metadata[aliasMetadataKey] != "" {
+			continue
+		}
+
+		key := contentKey{md5: string(attrs.MD5), crc32c: attrs.CRC32C, size: attrs.Size}
+		idx.add(attrs.Name, key)
+	}
+
+	return idx, nil
+}
+
+func (idx *bucketIndex) add(name string, key contentKey) {
+	if key.md5 != "" {
+		idx.byMD5[key.md5] = name
+	} else {
+		idx.byCRC[contentKey{crc32c: key.crc32c, size: key.size}] = name
+	}
+	idx.names[name] = key
+}
+
+// find returns the name of an object with the content identified by key,
+// or "" if there is none.
+// A 32-bit CRC and a size are too weak to identify content on their own,
+// so an object without an MD5 hash matches only if its content,
+// which find reads, has the same MD5 hash as key.
+func (idx *bucketIndex) find(ctx context.Context, key contentKey) (string, error) {
+	if name, ok := idx.byMD5[key.md5]; ok {
+		return name, nil
+	}
+	crcKey := contentKey{crc32c: key.crc32c, size: key.size}
+	name, ok := idx.byCRC[crcKey]
+	if !ok {
+		return "", nil
+	}
+
+	r, err := idx.bucket.Object(name).NewReader(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading %s", name)
+	}
+	defer r.Close()
+	objKey, err := hashContent(r)
+	if err != nil {
+		return "", errors.Wrapf(err, "hashing %s", name)
+	}
+
+	delete(idx.byCRC, crcKey)
+	idx.add(name, objKey)
+
+	if objKey.md5 != key.md5 {
+		return "", nil
+	}
+	return name, nil
+}
+
+func uploadFile(ctx context.Context, bucket *storage.BucketHandle, idx *bucketIndex, file, dups string, stdin *bufio.Reader) error {
+	objName := filepath.Base(file)
+
+	log.Printf("Hashing %s", file)
+
+	key, err := hashFile(file)
+	if err != nil {
+		return errors.Wrap(err, "hashing")
+	}
+
+	if existing, ok := idx.names[objName]; ok && (existing.md5 == key.md5 || (existing.md5 == "" && existing.crc32c == key.crc32c && existing.size == key.size)) {
+		log.Printf("%s is already in the bucket", objName)
+		return nil
+	}
+
+	dup, err := idx.find(ctx, key)
+	if err != nil {
+		return errors.Wrap(err, "looking for duplicates")
+	}
+	if dup != "" && dup != objName {
+		action := dups
+		if action == dupsAsk {
+			fmt.Printf("%s is identical to existing object %s. Alias it instead of uploading it again? [Y/n] ", file, dup)
+			answer, err := stdin.ReadString('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				return errors.Wrap(err, "reading answer")
+			}
+			if answer = strings.TrimSpace(strings.ToLower(answer)); answer == "" || answer == "y" || answer == "yes" {
+				action = dupsAlias
+			} else {
+				action = dupsUpload
+			}
+		}
+
+		switch action {
+		case dupsSkip:
+			log.Printf("Skipping %s, identical to %s", file, dup)
+			return nil
+
+		case dupsAlias:
+			log.Printf("Aliasing %s to %s", objName, dup)
+			w := bucket.Object(objName).NewWriter(ctx)
+			w.Metadata = map[string]string{aliasMetadataKey: dup}
+			if err := w.Close(); err != nil {
+				return errors.Wrapf(err, "creating alias %s", objName)
+			}
+			return nil
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err, "opening")
+	}
+	defer f.Close()
+
+	log.Printf("Uploading %s", file)
+
+	w := bucket.Object(objName).NewWriter(ctx)
+	w.MD5 = []byte(key.md5)
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return errors.Wrap(err, "copying to GCS")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "closing GCS writer")
+	}
+
+	idx.add(objName, key)
+	return nil
+}
+
+func hashFile(file string) (contentKey, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return contentKey{}, err
+	}
+	defer f.Close()
+	return hashContent(f)
+}
+
+func hashContent(r io.Reader) (contentKey, error) {
+	var (
+		md5hash = md5.New()
+		crchash = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	)
+	n, err := io.Copy(io.MultiWriter(md5hash, crchash), r)
+	if err != nil {
+		return contentKey{}, err
+	}
+
+	return contentKey{
+		md5:    string(md5hash.Sum(nil)),
+		crc32c: crchash.Sum32(),
+		size:   n,
+	}, nil
+}
+
+// resolveAlias returns the object that obj is an alias for, and its attributes,
+// or obj itself and the given attributes if it is not an alias.
+func resolveAlias(ctx context.Context, bucket *storage.BucketHandle, obj *storage.ObjectHandle, attrs *storage.ObjectAttrs) (*storage.ObjectHandle, *storage.ObjectAttrs, error) {
+	target := attrs.Metadata[aliasMetadataKey]
+	if target == "" {
+		return obj, attrs, nil
+	}
+	targetObj := bucket.Object(target)
+	targetAttrs, err := targetObj.Attrs(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "getting attrs for %s (alias target of %s)", target, attrs.Name)
+	}
+	return targetObj, targetAttrs, nil
+}
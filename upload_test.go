@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestBucketIndexFind(t *testing.T) {
+	ctx := context.Background()
+
+	hash := func(s string) contentKey {
+		key, err := hashContent(bytes.NewReader([]byte(s)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	content := "The Thin Man"
+	key := hash(content)
+	crcOnly := contentKey{crc32c: key.crc32c, size: key.size}
+
+	cases := []struct {
+		name string
+		objs map[string]string     // object name -> content in the bucket
+		keys map[string]contentKey // object name -> indexed key
+		want string
+	}{{
+		name: "md5 match",
+		keys: map[string]contentKey{"a.mkv": key},
+		want: "a.mkv",
+	}, {
+		name: "no match",
+		keys: map[string]contentKey{"a.mkv": hash("After the Thin Man")},
+	}, {
+		name: "composite, same content",
+		objs: map[string]string{"a.mkv": content},
+		keys: map[string]contentKey{"a.mkv": crcOnly},
+		want: "a.mkv",
+	}, {
+		// As if the CRC32C and size collided.
+		name: "composite, different content",
+		objs: map[string]string{"a.mkv": "The Thin Mab"},
+		keys: map[string]contentKey{"a.mkv": crcOnly},
+	}, {
+		// Likewise, for an object whose MD5 hash is known.
+		name: "crc match, md5 mismatch",
+		keys: map[string]contentKey{"a.mkv": {md5: hash("x").md5, crc32c: key.crc32c, size: key.size}},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gcs, bucket := newFakeGCS(t)
+			for name, content := range c.objs {
+				gcs.put(name, []byte(content))
+			}
+			idx := newBucketIndex(bucket)
+			for name, key := range c.keys {
+				idx.add(name, key)
+			}
+
+			got, err := idx.find(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+
+			// Once read, a composite object is indexed by its MD5 hash.
+			if len(c.objs) > 0 {
+				if k := idx.names["a.mkv"]; k.md5 != hash(c.objs["a.mkv"]).md5 {
+					t.Error("composite object not indexed by MD5 after reading")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+// A users file, given to serve -users,
+// lists accounts that may use the server,
+// each with its own password,
+// so that each member of a household and each Kodi box can have its own credentials
+// (and its own watched states; see watched.go).
+//
+// It is either in htpasswd format,
+// lines of USERNAME:HASH
+// (with blank lines and lines beginning with # ignored),
+// as written by "htpasswd -B",
+// or a JSON object mapping usernames to hashes.
+// The hashes are bcrypt or Argon2id hashes (see passwords.go).
+//
+// The file is read at startup and again when the server gets SIGHUP.
+// Rereading it revokes the grants of any accounts it no longer lists
+// (see grantStore.revokeNames).
+
+// userStore holds the accounts from a users file.
+type userStore struct {
+	file   string      // or "" for the single account of -username and a hashed -password
+	grants *grantStore // if not nil, where to revoke the grants of removed accounts
+
+	mu     sync.RWMutex
+	hashes map[string][]byte // username -> password hash
+
+	// Checking a password hash is deliberately slow,
+	// too slow to do for every request from a Kodi box scanning a library,
+	// so credentials that have been checked once are remembered here.
+	// The key is the SHA-256 hash of the username, password, and password hash,
+	// so a changed password does not match.
+	checked map[[sha256.Size]byte]bool
+}
+
+func newUserStore(file string, grants *grantStore) (*userStore, error) {
+	us := &userStore{file: file, grants: grants}
+	if err := us.reload(); err != nil {
+		return nil, err
+	}
+	return us, nil
+}
+
+// newSingleUser returns a userStore with just one account.
+func newSingleUser(username, hash string) *userStore {
+	return &userStore{
+		hashes:  map[string][]byte{username: []byte(hash)},
+		checked: make(map[[sha256.Size]byte]bool),
+	}
+}
+
+// reload rereads the users file,
+// revoking the grants of accounts that are no longer in it.
+// On error the accounts are unchanged.
+func (us *userStore) reload() error {
+	content, err := os.ReadFile(us.file)
+	if err != nil {
+		return errors.Wrapf(err, "reading users file %s", us.file)
+	}
+	hashes, err := parseUsers(content)
+	if err != nil {
+		return errors.Wrapf(err, "parsing users file %s", us.file)
+	}
+
+	removed := set.New[string]()
+	us.mu.Lock()
+	for username := range us.hashes {
+		if _, ok := hashes[username]; !ok {
+			removed.Add(username)
+		}
+	}
+	us.hashes = hashes
+	us.checked = make(map[[sha256.Size]byte]bool)
+	us.mu.Unlock()
+
+	log.Printf("Loaded %d user(s) from %s", len(hashes), us.file)
+
+	if us.grants != nil && removed.Len() > 0 {
+		n := us.grants.revokeNames(removed)
+		log.Printf("Revoked %d grant(s) of %d removed user(s)", n, removed.Len())
+	}
+	return nil
+}
+
+// parseUsers parses the contents of a users file.
+func parseUsers(content []byte) (map[string][]byte, error) {
+	result := make(map[string][]byte)
+
+	add := func(username, hash string) error {
+		if username == "" {
+			return fmt.Errorf("empty username")
+		}
+		if _, ok := result[username]; ok {
+			return fmt.Errorf("duplicate username %s", username)
+		}
+		if err := checkPasswordHashFormat(hash); err != nil {
+			return errors.Wrapf(err, "password hash for %s", username)
+		}
+		result[username] = []byte(hash)
+		return nil
+	}
+
+	if trimmed := bytes.TrimSpace(content); bytes.HasPrefix(trimmed, []byte("{")) {
+		var m map[string]string
+		if err := json.Unmarshal(trimmed, &m); err != nil {
+			return nil, errors.Wrap(err, "decoding JSON")
+		}
+		for username, hash := range m {
+			if err := add(username, hash); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(content))
+	for lineNum := 1; sc.Scan(); lineNum++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want USERNAME:HASH", lineNum)
+		}
+		if err := add(username, hash); err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNum)
+		}
+	}
+	return result, errors.Wrap(sc.Err(), "scanning")
+}
+
+// check tells whether password is the password of the named user.
+func (us *userStore) check(username, password string) bool {
+	us.mu.RLock()
+	hash, ok := us.hashes[username]
+	if !ok {
+		us.mu.RUnlock()
+		return false
+	}
+	key := sha256.Sum256([]byte(username + "\x00" + password + "\x00" + string(hash)))
+	checked := us.checked[key]
+	us.mu.RUnlock()
+
+	if checked {
+		return true
+	}
+	if !passwordMatches(string(hash), password) {
+		return false
+	}
+
+	us.mu.Lock()
+	if bytes.Equal(hash, us.hashes[username]) { // not changed by a reload meanwhile
+		us.checked[key] = true
+	}
+	us.mu.Unlock()
+	return true
+}
+
+// has tells whether there is an account with the given username.
+func (us *userStore) has(username string) bool {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+	_, ok := us.hashes[username]
+	return ok
+}
+
+// reloadOnHUP rereads the users file whenever the process gets SIGHUP,
+// until the context is canceled.
+func (us *userStore) reloadOnHUP(ctx context.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			if err := us.reload(); err != nil {
+				log.Printf("Error reloading users: %s", err)
+			}
+		}
+	}
+}
+
+// usersAuth requires HTTP Basic Auth with the username and password of an account in a users file.
+type usersAuth struct {
+	users *userStore
+}
+
+func (a usersAuth) authenticate(w http.ResponseWriter, req *http.Request) (string, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", basicRealm)
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	if !a.checkPassword(username, password) {
+		log.Printf("Unauthorized access attempt from %s (username %s)", req.RemoteAddr, username)
+		return "", mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	return username, nil
+}
+
+func (a usersAuth) checkPassword(username, password string) bool {
+	return a.users.check(username, password)
+}
+
+func (a usersAuth) hasAccount(username string) bool {
+	return a.users.has(username)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUsers(t *testing.T) {
+	hash := func(password string) string {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(h)
+	}
+
+	file := filepath.Join(t.TempDir(), "users")
+	write := func(content string) {
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(fmt.Sprintf("# The household\nnora:%s\n\nnick:%s\n", hash("asta"), hash("martini")))
+
+	us, err := newUserStore(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := usersAuth{users: us}
+	try := func(username, password string) (string, error) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(username, password)
+		return auth.authenticate(httptest.NewRecorder(), req)
+	}
+
+	for i := 0; i < 2; i++ { // the second time, from the cache
+		if name, err := try("nora", "asta"); err != nil || name != "nora" {
+			t.Errorf("got %q, %v for nora", name, err)
+		}
+	}
+	if _, err := try("nick", "asta"); err == nil {
+		t.Error("nick got in with nora's password")
+	}
+	if _, err := try("asta", "asta"); err == nil {
+		t.Error("unknown user got in")
+	}
+
+	write(fmt.Sprintf(`{"nora": %q}`, hash("dog")))
+	if err := us.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := try("nora", "asta"); err == nil {
+		t.Error("nora got in with her old password")
+	}
+	if _, err := try("nora", "dog"); err != nil {
+		t.Errorf("nora's new password failed: %s", err)
+	}
+	if _, err := try("nick", "martini"); err == nil {
+		t.Error("nick got in after removal")
+	}
+
+	write("nora:plaintext\n")
+	if err := us.reload(); err == nil {
+		t.Error("accepted a plaintext password")
+	}
+	if _, err := try("nora", "dog"); err != nil {
+		t.Errorf("a failed reload changed the accounts: %s", err)
+	}
+}
+
+func TestUsersReloadRevokes(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("asta"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "users")
+	write := func(usernames ...string) {
+		var content string
+		for _, username := range usernames {
+			content += username + ":" + string(hash) + "\n"
+		}
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("nora", "nick")
+
+	grants := &grantStore{grants: map[string]*grant{}, known: set.New[string](), deleted: set.New[string]()}
+	issue := func(kind, name, subject string) string {
+		secret, _, err := grants.issue(kind, name, subject, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return secret
+	}
+	var (
+		session  = issue(grantSession, "nick", loginSubject)
+		pin      = issue(grantSession, "nick", pinSubject)
+		token    = issue(grantToken, "nick", "")
+		share    = issue(grantShare, "share:nick", "The Thin Man")
+		playlist = issue(grantPlaylist, "playlist:nick", "")
+		kept     = issue(grantSession, "nora", loginSubject)
+		nickel   = issue(grantShare, "share:nickel", "The Thin Man")
+	)
+
+	us, err := newUserStore(file, grants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants.list()) != 7 {
+		t.Fatalf("got %d grants after the first load, want 7", len(grants.list()))
+	}
+
+	write("nora")
+	if err := us.reload(); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{session, pin, token, share, playlist} {
+		for _, kind := range []string{grantSession, grantToken, grantShare, grantPlaylist} {
+			if g, ok := grants.lookup(kind, secret); ok {
+				t.Errorf("grant %s (%s for %s) of a removed user not revoked", g.ID, g.Kind, g.Name)
+			}
+		}
+	}
+	if _, ok := grants.lookup(grantSession, kept); !ok {
+		t.Error("grant of a remaining user revoked")
+	}
+	if _, ok := grants.lookup(grantShare, nickel); !ok {
+		t.Error("grant of a user with a similar name revoked")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+// A title may have several versions
+// (theatrical and extended cuts, 1080p and 4K encodings),
+// stored as objects named ROOT {VERSION}.EXT,
+// e.g. "Blade Runner {Final Cut}.mkv" and "Blade Runner {4K}.mkv".
+// A version with no metadata of its own takes the metadata of its title, ROOT,
+// so every version gets the same .nfo file,
+// with the same title and IMDb ID.
+// That is how Kodi 21 recognizes versions of one movie when scanning,
+// offering them in its version picker rather than listing the movie several times.
+// The versions are listed together, after the title's own object, if any.
+
+var versionRE = regexp.MustCompile(`^(.*\S)\s*\{([^{}]+)\}$`)
+
+// parseVersion splits the root name of a version of a title, ROOT {VERSION},
+// into ROOT and VERSION.
+func parseVersion(rootName string) (base, version string, ok bool) {
+	m := versionRE.FindStringSubmatch(rootName)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], strings.TrimSpace(m[2]), true
+}
+
+// addVersionInfo adds to the info map an entry for each version of a title
+// that lacks one of its own,
+// copied from the entry for the title.
+// The caller must hold s.mu for writing.
+func (s *server) addVersionInfo() {
+	s.objNames.Each(func(objName string) {
+		ext := filepath.Ext(objName)
+		if !isMediaExt(ext) {
+			return
+		}
+		rootName := strings.TrimSuffix(objName, ext)
+		if _, ok := s.infoMap[rootName]; ok {
+			return
+		}
+		base, _, ok := parseVersion(rootName)
+		if !ok {
+			return
+		}
+		info, ok := s.infoMap[base]
+		if !ok || info.parts != "" {
+			return
+		}
+		info.filename = objName
+		s.infoMap[rootName] = info
+	})
+}
+
+// hasVersions tells whether objNames includes a version of the title with the given root name.
+func hasVersions(objNames set.Of[string], rootName string) bool {
+	for objName := range objNames {
+		if base, _, ok := parseVersion(strings.TrimSuffix(objName, filepath.Ext(objName))); ok && base == rootName && isMediaExt(filepath.Ext(objName)) {
+			return true
+		}
+	}
+	return false
+}
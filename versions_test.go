@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestParseVersion(t *testing.T) {
+	cases := []struct {
+		rootName, base, version string
+		ok                      bool
+	}{
+		{rootName: "Blade Runner {Final Cut}", base: "Blade Runner", version: "Final Cut", ok: true},
+		{rootName: "classics/Metropolis{4K}", base: "classics/Metropolis", version: "4K", ok: true},
+		{rootName: "Blade Runner"},
+		{rootName: "{Final Cut}"},
+		{rootName: "Blade Runner {Final Cut} extra"},
+	}
+	for _, c := range cases {
+		t.Run(c.rootName, func(t *testing.T) {
+			base, version, ok := parseVersion(c.rootName)
+			if base != c.base || version != c.version || ok != c.ok {
+				t.Errorf("got %q, %q, %v; want %q, %q, %v", base, version, ok, c.base, c.version, c.ok)
+			}
+		})
+	}
+}
+
+func TestVersions(t *testing.T) {
+	s := &server{
+		objNames: set.New(
+			"Blade Runner.mkv",
+			"Blade Runner {Final Cut}.mkv",
+			"Blade Runner {Workprint}.mkv",
+			"Alien {Director's Cut}.mkv",
+			"Zardoz {4K}.mkv",
+		),
+		infoMap: map[string]movieInfo{
+			"Blade Runner":             {Title: "Blade Runner", Year: 1982, imdbID: "tt0083658"},
+			"Blade Runner {Workprint}": {Title: "Blade Runner", Plot: "The workprint."},
+			"Alien":                    {Title: "Alien", Year: 1979, filename: "Alien.mkv"},
+		},
+	}
+	s.addVersionInfo()
+
+	if info := s.infoMap["Blade Runner {Final Cut}"]; info.Title != "Blade Runner" || info.imdbID != "tt0083658" || info.filename != "Blade Runner {Final Cut}.mkv" {
+		t.Errorf("got %+v for the final cut", info)
+	}
+	if info := s.infoMap["Blade Runner {Workprint}"]; info.Plot != "The workprint." || info.imdbID != "" {
+		t.Errorf("got %+v for the workprint, want its own metadata", info)
+	}
+	if info := s.infoMap["Alien {Director's Cut}"]; info.Year != 1979 {
+		t.Errorf("got %+v for the director's cut", info)
+	}
+	if _, ok := s.infoMap["Zardoz {4K}"]; ok {
+		t.Error("got info for a version of a title with none")
+	}
+
+	var got []string
+	for _, g := range s.titleGroups(func(movieInfo, bool) bool { return true }) {
+		got = append(got, g.rootName)
+	}
+	want := []string{"Alien {Director's Cut}", "Blade Runner", "Blade Runner {Final Cut}", "Blade Runner {Workprint}", "Zardoz {4K}"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	if !hasVersions(s.objNames, "Alien") {
+		t.Error("Alien should have versions")
+	}
+	if hasVersions(s.objNames, "Metropolis") {
+		t.Error("Metropolis should have no versions")
+	}
+	if title, year := parseSceneName("Blade Runner (1982) {Final Cut}"); title != "Blade Runner" || year != 1982 {
+		t.Errorf("got %q, %d from a version's name", title, year)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+// The watchdog tracks the latency and error rate of each kind of request
+// (streams, thumbnails, .nfo files, directories, and API calls)
+// and how long GCS takes to start delivering an object,
+// over windows of watchdogWindow.
+// At the end of each window it raises an alert
+// (in the log, and to any -webhooks)
+// when a kind of request is failing with server errors more often than -alert-errors,
+// or when its median latency,
+// or that of GCS reads,
+// exceeds -alert-latency;
+// and it says so when the trouble has cleared.
+// Streams are judged only by their errors,
+// since how long one takes depends on the viewer.
+
+const (
+	watchdogWindow = 5 * time.Minute
+
+	// The fewest requests of a kind in a window from which to judge it.
+	watchdogMinRequests = 5
+
+	// The most latencies of a kind to keep in a window.
+	watchdogMaxSamples = 10000
+)
+
+// Kinds of request.
+const (
+	kindStream = "stream"
+	kindThumb  = "thumb"
+	kindNFO    = "nfo"
+	kindDir    = "dir"
+	kindAPI    = "api"
+	kindOther  = "other"
+	kindGCS    = "gcs" // not a request: the time to the first byte of an object from GCS
+)
+
+// requestKind classifies a request for the watchdog.
+func requestKind(req *http.Request) string {
+	p := req.URL.Path
+	switch ext := strings.ToLower(path.Ext(p)); {
+	case isStreamingRequest(req):
+		return kindStream
+	case strings.HasPrefix(p, "/thumbs/") || strings.HasPrefix(p, "/actors/") || slices.Contains(artworkExts, ext):
+		return kindThumb
+	case ext == ".nfo":
+		return kindNFO
+	case strings.HasPrefix(p, "/api/"):
+		return kindAPI
+	case strings.HasSuffix(p, "/"):
+		return kindDir
+	default:
+		return kindOther
+	}
+}
+
+type watchdog struct {
+	latency   time.Duration // from -alert-latency, or 0
+	errorRate float64       // from -alert-errors, or 0
+
+	mu     sync.Mutex
+	window map[string]*kindStats // the current window, by kind
+	last   map[string]kindSummary
+	firing set.Of[string] // alert keys
+}
+
+// kindStats are the observations of one kind of request in a window.
+type kindStats struct {
+	requests, errors int64
+	latencies        []time.Duration
+}
+
+// kindSummary summarizes a kindStats, for /debug/vars.
+type kindSummary struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+	P50      int64 `json:"p50_ms,omitempty"`
+	P95      int64 `json:"p95_ms,omitempty"`
+}
+
+func newWatchdog(latency time.Duration, errorRate float64) *watchdog {
+	if latency <= 0 && errorRate <= 0 {
+		return nil
+	}
+	return &watchdog{
+		latency:   latency,
+		errorRate: errorRate,
+		window:    make(map[string]*kindStats),
+		firing:    set.New[string](),
+	}
+}
+
+// observe records a request of the given kind,
+// which took d and failed with a server error if failed is true.
+func (w *watchdog) observe(kind string, d time.Duration, failed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ks := w.window[kind]
+	if ks == nil {
+		ks = &kindStats{}
+		w.window[kind] = ks
+	}
+	ks.requests++
+	if failed {
+		ks.errors++
+	}
+	if kind != kindStream && len(ks.latencies) < watchdogMaxSamples {
+		ks.latencies = append(ks.latencies, d)
+	}
+}
+
+// gcsFirstByte records how long GCS took to deliver the first byte of an object.
+func (w *watchdog) gcsFirstByte(d time.Duration) {
+	w.observe(kindGCS, d, false)
+}
+
+// check ends the current window
+// and returns the alerts that it raises and clears.
+func (w *watchdog) check() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var msgs []string
+	update := func(key string, firing bool, alert, cleared string) {
+		switch {
+		case firing && !w.firing.Has(key):
+			w.firing.Add(key)
+			msgs = append(msgs, alert)
+		case !firing && w.firing.Has(key):
+			w.firing.Del(key)
+			msgs = append(msgs, cleared)
+		}
+	}
+
+	w.last = make(map[string]kindSummary)
+	for _, kind := range slices.Sorted(maps.Keys(w.window)) {
+		ks := w.window[kind]
+		sum := kindSummary{Requests: ks.requests, Errors: ks.errors}
+		var p50 time.Duration
+		if len(ks.latencies) > 0 {
+			slices.Sort(ks.latencies)
+			p50 = ks.latencies[len(ks.latencies)/2]
+			sum.P50 = p50.Milliseconds()
+			sum.P95 = ks.latencies[len(ks.latencies)*95/100].Milliseconds()
+		}
+		w.last[kind] = sum
+
+		if ks.requests < watchdogMinRequests {
+			// Too few to judge; leave any alert as it is.
+			continue
+		}
+
+		what := kind + " requests"
+		if kind == kindGCS {
+			what = "GCS reads"
+		} else if w.errorRate > 0 {
+			update("errors:"+kind, float64(ks.errors) >= w.errorRate*float64(ks.requests),
+				fmt.Sprintf("%s failing: %d of %d in the last %s", what, ks.errors, ks.requests, watchdogWindow),
+				fmt.Sprintf("%s no longer failing: %d of %d in the last %s", what, ks.errors, ks.requests, watchdogWindow))
+		}
+		if w.latency > 0 && len(ks.latencies) > 0 {
+			update("latency:"+kind, p50 > w.latency,
+				fmt.Sprintf("%s slow: median %s in the last %s", what, p50.Round(time.Millisecond), watchdogWindow),
+				fmt.Sprintf("%s no longer slow: median %s in the last %s", what, p50.Round(time.Millisecond), watchdogWindow))
+		}
+	}
+
+	w.window = make(map[string]*kindStats)
+	return msgs
+}
+
+func (w *watchdog) vars() any {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return map[string]any{
+		"last_window": w.last,
+		"firing":      slices.Sorted(w.firing.All()),
+	}
+}
+
+// runWatchdog checks s.watchdog every watchdogWindow,
+// logging its alerts and sending them to the -webhooks,
+// until the context is canceled.
+func (s *server) runWatchdog(ctx context.Context) {
+	ticker := time.NewTicker(watchdogWindow)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			for _, msg := range s.watchdog.check() {
+				log.Printf("Alert: %s", msg)
+				if len(s.webhooks) > 0 {
+					sendWebhooks(ctx, s.webhooks, webhookEvent{Event: eventAlert, Text: msg})
+				}
+			}
+		}
+	}
+}
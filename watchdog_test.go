@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestKind(t *testing.T) {
+	cases := map[string]string{
+		"/Top%20Hat.mkv":        kindStream,
+		"/hls/abc/index.m3u8":   kindStream,
+		"/thumbs/Top%20Hat.jpg": kindThumb,
+		"/Top%20Hat-fanart.jpg": kindThumb,
+		"/Top%20Hat.nfo":        kindNFO,
+		"/api/titles":           kindAPI,
+		"/kids/":                kindDir,
+		"/playlist.m3u":         kindOther,
+	}
+	for p, want := range cases {
+		if got := requestKind(httptest.NewRequest("GET", p, nil)); got != want {
+			t.Errorf("%s: got kind %s, want %s", p, got, want)
+		}
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	w := newWatchdog(time.Second, 0.5)
+
+	for i := 0; i < 10; i++ {
+		w.observe(kindThumb, 10*time.Millisecond, i < 6)
+		w.observe(kindNFO, 2*time.Second, false)
+		w.gcsFirstByte(50 * time.Millisecond)
+	}
+	w.observe(kindDir, 5*time.Second, true) // too few to judge
+
+	msgs := w.check()
+	if len(msgs) != 2 || !strings.HasPrefix(msgs[0], "nfo requests slow") || !strings.HasPrefix(msgs[1], "thumb requests failing: 6 of 10") {
+		t.Fatalf("got alerts %q", msgs)
+	}
+
+	// Still failing: no new alerts.
+	for i := 0; i < 10; i++ {
+		w.observe(kindThumb, 10*time.Millisecond, true)
+	}
+	if msgs := w.check(); len(msgs) != 0 {
+		t.Errorf("got repeated alerts %q", msgs)
+	}
+
+	// Recovered.
+	for i := 0; i < 10; i++ {
+		w.observe(kindThumb, 10*time.Millisecond, false)
+		w.observe(kindNFO, 10*time.Millisecond, false)
+	}
+	if msgs := w.check(); len(msgs) != 2 || !strings.Contains(msgs[0], "no longer slow") || !strings.Contains(msgs[1], "no longer failing") {
+		t.Errorf("got alerts %q after recovering", msgs)
+	}
+}
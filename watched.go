@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// The bucket object in which watched states are persisted.
+const watchedObjName = "kodigcs/watched.json"
+
+const watchedSyncInterval = time.Minute
+
+// watchState is what is known about one user's viewing of one title,
+// for Kodi to pick up from the title's .nfo file
+// (so that it survives reinstalling Kodi
+// and is shared among a user's devices).
+type watchState struct {
+	PlayCount  int       `json:"playcount"`
+	LastPlayed time.Time `json:"lastplayed"`
+	Resume     float64   `json:"resume,omitempty"` // seconds into the title at which to resume, or zero
+	Total      float64   `json:"total,omitempty"`  // length of the title in seconds, if known
+	Updated    time.Time `json:"updated"`
+}
+
+// resumePoint is the <resume> element of an .nfo file.
+type resumePoint struct {
+	Position float64 `xml:"position"`
+	Total    float64 `xml:"total"`
+}
+
+// watchStore holds the watched state of each title for each user
+// (named by the principal that authenticates their requests),
+// persisting them to the bucket.
+// Changes made elsewhere since the last sync are merged,
+// the most recently updated state of a title winning.
+type watchStore struct {
+	obj *storage.ObjectHandle
+
+	mu     sync.Mutex
+	states map[string]map[string]watchState // user -> root name -> state
+	dirty  bool
+}
+
+func newWatchStore(bucket *storage.BucketHandle) *watchStore {
+	return &watchStore{
+		obj:    bucket.Object(watchedObjName),
+		states: make(map[string]map[string]watchState),
+	}
+}
+
+// get returns the watched state of a title for a user.
+func (ws *watchStore) get(user, rootName string) (watchState, bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	st, ok := ws.states[user][rootName]
+	return st, ok
+}
+
+// forUser returns the watched states of all titles for a user.
+func (ws *watchStore) forUser(user string) map[string]watchState {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	result := make(map[string]watchState)
+	for rootName, st := range ws.states[user] {
+		result[rootName] = st
+	}
+	return result
+}
+
+// set records the watched state of a title for a user.
+func (ws *watchStore) set(user, rootName string, st watchState) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	st.Updated = time.Now()
+	m, ok := ws.states[user]
+	if !ok {
+		m = make(map[string]watchState)
+		ws.states[user] = m
+	}
+	m[rootName] = st
+	ws.dirty = true
+}
+
+// sync merges the watched states in the bucket object with those in memory
+// and writes the result back if it differs.
+func (ws *watchStore) sync(ctx context.Context) error {
+	for tries := 0; ; tries++ {
+		var (
+			remote map[string]map[string]watchState
+			cond   = storage.Conditions{DoesNotExist: true}
+		)
+		r, err := ws.obj.NewReader(ctx)
+		switch {
+		case errors.Is(err, storage.ErrObjectNotExist):
+		case err != nil:
+			return errors.Wrapf(err, "reading %s", watchedObjName)
+		default:
+			err = json.NewDecoder(r).Decode(&remote)
+			r.Close()
+			if err != nil {
+				return errors.Wrapf(err, "decoding %s", watchedObjName)
+			}
+			cond = storage.Conditions{GenerationMatch: r.Attrs.Generation}
+		}
+
+		ws.mu.Lock()
+
+		changed := ws.dirty
+		for user, titles := range remote {
+			m, ok := ws.states[user]
+			if !ok {
+				m = make(map[string]watchState)
+				ws.states[user] = m
+			}
+			for rootName, st := range titles {
+				if local, ok := m[rootName]; !ok || st.Updated.After(local.Updated) {
+					m[rootName] = st
+				}
+			}
+		}
+
+		if changed {
+			buf, err := json.Marshal(ws.states)
+			if err != nil {
+				ws.mu.Unlock()
+				return errors.Wrap(err, "encoding watched states")
+			}
+
+			w := ws.obj.If(cond).NewWriter(ctx)
+			w.ContentType = "application/json"
+			_, err = w.Write(buf)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				ws.mu.Unlock()
+				if tries < 3 && isPreconditionFailed(err) {
+					continue
+				}
+				return errors.Wrapf(err, "writing %s", watchedObjName)
+			}
+		}
+
+		ws.dirty = false
+		ws.mu.Unlock()
+		return nil
+	}
+}
+
+// run periodically syncs with the bucket,
+// until the context is canceled.
+// The caller should sync once more after that.
+func (ws *watchStore) run(ctx context.Context) {
+	ticker := time.NewTicker(watchedSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			if err := ws.sync(ctx); err != nil {
+				log.Printf("Error syncing watched states: %s", err)
+			}
+		}
+	}
+}
+
+// nfoFields sets the watched-state fields of a title's .nfo file from st.
+func (st watchState) nfoFields(info *movieInfo) {
+	info.PlayCount = st.PlayCount
+	if !st.LastPlayed.IsZero() {
+		info.LastPlayed = st.LastPlayed.Local().Format(time.DateTime)
+	}
+	if st.Resume > 0 {
+		info.Resume = &resumePoint{Position: st.Resume, Total: st.Total}
+	}
+}
+
+// handleAPIWatched serves the watched states of all titles
+// for the user making the request,
+// as JSON keyed by root name.
+func (s *server) handleAPIWatched(w http.ResponseWriter, req *http.Request) error {
+	return mid.RespondJSON(w, s.watched.forUser(principal(req.Context())))
+}
+
+// handleAPIWatchedTitle serves (for GET)
+// or sets (for PUT, from a JSON watchState)
+// the watched state of one title
+// for the user making the request.
+// A PUT with an empty object marks the title unwatched.
+func (s *server) handleAPIWatchedTitle(w http.ResponseWriter, req *http.Request) error {
+	var (
+		ctx      = req.Context()
+		rootName = req.PathValue("rootname")
+		user     = principal(ctx)
+	)
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	_, ok := s.mediaObjName(rootName)
+	if info, infoOK := s.infoMap[rootName]; infoOK {
+		ok = ok && !info.hidden
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no title %s", rootName),
+		}
+	}
+
+	if req.Method == http.MethodPut {
+		var st watchState
+		if err := json.NewDecoder(req.Body).Decode(&st); err != nil {
+			return mid.CodeErr{
+				C:   http.StatusBadRequest,
+				Err: errors.Wrap(err, "decoding request body"),
+			}
+		}
+		if st.PlayCount < 0 || st.Resume < 0 || st.Total < 0 {
+			return mid.CodeErr{
+				C:   http.StatusBadRequest,
+				Err: fmt.Errorf("negative playcount, resume, or total"),
+			}
+		}
+		if st.PlayCount > 0 && st.LastPlayed.IsZero() {
+			st.LastPlayed = time.Now()
+		}
+		s.watched.set(user, rootName, st)
+	}
+
+	st, _ := s.watched.get(user, rootName)
+	return mid.RespondJSON(w, st)
+}
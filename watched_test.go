@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestWatched(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		objNames:     set.New("The Thin Man.iso"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"The Thin Man": {Title: "The Thin Man"},
+		},
+		infoMapTime: now,
+		watched:     &watchStore{states: make(map[string]map[string]watchState)},
+	}
+
+	as := func(user, method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, strings.ReplaceAll(path, " ", "%20"), strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), principalKey, user))
+		req.SetPathValue("rootname", strings.TrimPrefix(path, "/api/watched/"))
+		rec := httptest.NewRecorder()
+		var err error
+		switch {
+		case path == "/api/watched":
+			err = s.handleAPIWatched(rec, req)
+		case strings.HasSuffix(path, ".nfo"):
+			err = s.handleNFO(rec, req, strings.TrimPrefix(path, "/"))
+		default:
+			err = s.handleAPIWatchedTitle(rec, req)
+		}
+		if err != nil {
+			t.Fatalf("%s %s as %q: %s", method, path, user, err)
+		}
+		return rec
+	}
+
+	as("nick", "PUT", "/api/watched/The Thin Man", `{"playcount": 1, "resume": 1234.5, "total": 5460}`)
+
+	var got map[string]watchState
+	if err := json.Unmarshal(as("nick", "GET", "/api/watched", "").Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if st := got["The Thin Man"]; st.PlayCount != 1 || st.Resume != 1234.5 || st.LastPlayed.IsZero() {
+		t.Errorf("got state %+v", st)
+	}
+
+	nfo := as("nick", "GET", "/The Thin Man.nfo", "").Body.String()
+	for _, want := range []string{"<playcount>1</playcount>", "<lastplayed>", "<position>1234.5</position>", "<total>5460</total>"} {
+		if !strings.Contains(nfo, want) {
+			t.Errorf("NFO lacks %s:\n%s", want, nfo)
+		}
+	}
+
+	if nfo := as("nora", "GET", "/The Thin Man.nfo", "").Body.String(); strings.Contains(nfo, "playcount") {
+		t.Errorf("another user's NFO has a play count:\n%s", nfo)
+	}
+
+	req := httptest.NewRequest("GET", "/api/watched/Casablanca", nil)
+	req.SetPathValue("rootname", "Casablanca")
+	if err := s.handleAPIWatchedTitle(httptest.NewRecorder(), req); err == nil {
+		t.Error("got no error for an unknown title")
+	}
+}
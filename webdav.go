@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/mid"
+)
+
+// The server speaks enough WebDAV (RFC 4918) for Kodi's dav:// and davs:// sources:
+// OPTIONS,
+// and PROPFIND with a depth of 0 or 1
+// on the same virtual tree of directories, media, .nfo files, and playlists
+// that GET serves.
+// Everything is read-only.
+
+const davMethods = "OPTIONS, GET, HEAD, PROPFIND"
+
+type (
+	davMultistatus struct {
+		XMLName   xml.Name      `xml:"D:multistatus"`
+		XMLNS     string        `xml:"xmlns:D,attr"`
+		Responses []davResponse `xml:"D:response"`
+	}
+
+	davResponse struct {
+		Href     string      `xml:"D:href"`
+		Propstat davPropstat `xml:"D:propstat"`
+	}
+
+	davPropstat struct {
+		Prop   davProp `xml:"D:prop"`
+		Status string  `xml:"D:status"`
+	}
+
+	davProp struct {
+		DisplayName   string          `xml:"D:displayname"`
+		ResourceType  davResourceType `xml:"D:resourcetype"`
+		ContentLength int64           `xml:"D:getcontentlength,omitempty"`
+		ContentType   string          `xml:"D:getcontenttype,omitempty"`
+		LastModified  string          `xml:"D:getlastmodified,omitempty"`
+	}
+
+	davResourceType struct {
+		Collection *struct{} `xml:"D:collection"`
+	}
+)
+
+// handleOptions answers an OPTIONS request,
+// advertising WebDAV class 1 compliance.
+func handleOptions(w http.ResponseWriter) {
+	w.Header().Set("DAV", "1")
+	w.Header().Set("Allow", davMethods)
+	w.Header().Set("MS-Author-Via", "DAV")
+}
+
+// checkMethod checks the method of a request for the virtual tree,
+// answering it if it is OPTIONS
+// (in which case done is true)
+// and returning an error if it is not one of davMethods.
+func checkMethod(w http.ResponseWriter, req *http.Request) (done bool, err error) {
+	switch req.Method {
+	case "GET", "HEAD", "PROPFIND":
+		return false, nil
+	case "OPTIONS":
+		handleOptions(w)
+		return true, nil
+	default:
+		w.Header().Set("Allow", davMethods)
+		return false, mid.CodeErr{
+			C:   http.StatusMethodNotAllowed,
+			Err: fmt.Errorf("method %s not allowed", req.Method),
+		}
+	}
+}
+
+// writeDir writes a directory listing with the given items:
+// a PROPFIND response for a PROPFIND request,
+// an HTML page otherwise.
+// The caller must hold s.mu.
+func (s *server) writeDir(w http.ResponseWriter, req *http.Request, items []template.URL) error {
+	if req.Method == "PROPFIND" {
+		return s.propfindDir(w, req, items, unprefixed)
+	}
+	items, page, err := s.paginate(req, items)
+	if err != nil {
+		return err
+	}
+	if isAPIDir(req) {
+		return s.respondDirJSON(w, req, items, page, unprefixed)
+	}
+	return s.writeDirHTML(w, req, s.dirEntries(items, unprefixed), page)
+}
+
+// writeObjDir writes a listing of a directory
+// whose file items stand for the objects (or .nfo files) named dir/ITEM,
+// without hash prefixes,
+// as in the tv/ folder.
+// The caller must hold s.mu.
+func (s *server) writeObjDir(w http.ResponseWriter, req *http.Request, dir string, items []template.URL) error {
+	objName := func(item string) string { return path.Join(dir, item) }
+	if req.Method == "PROPFIND" {
+		return s.propfindDir(w, req, items, objName)
+	}
+	items, page, err := s.paginate(req, items)
+	if err != nil {
+		return err
+	}
+	if isAPIDir(req) {
+		return s.respondDirJSON(w, req, items, page, objName)
+	}
+	return s.writeDirHTML(w, req, s.dirEntries(items, nil), page)
+}
+
+// The default value of the -pagesize flag.
+const defaultPageSize = 500
+
+// dirPage says which page of a directory listing a response is.
+type dirPage struct {
+	Page, Pages int
+	Prev, Next  int // zero if none
+}
+
+// paginate returns the items on the page of a directory listing
+// given by the request's page parameter,
+// with as many items per page as the pagesize parameter or the -pagesize flag says.
+// With no page parameter it returns all the items and a nil page,
+// since Kodi cannot follow links from one page to the next.
+func (s *server) paginate(req *http.Request, items []template.URL) ([]template.URL, *dirPage, error) {
+	q := req.URL.Query()
+	if q.Get("page") == "" {
+		return items, nil, nil
+	}
+
+	n, err := strconv.Atoi(q.Get("page"))
+	if err != nil || n < 1 {
+		return nil, nil, mid.CodeErr{
+			C:   http.StatusBadRequest,
+			Err: fmt.Errorf("bad page %q", q.Get("page")),
+		}
+	}
+	size := s.pageSize
+	if ps := q.Get("pagesize"); ps != "" {
+		size, err = strconv.Atoi(ps)
+		if err != nil || size < 1 {
+			return nil, nil, mid.CodeErr{
+				C:   http.StatusBadRequest,
+				Err: fmt.Errorf("bad page size %q", ps),
+			}
+		}
+	}
+	if size < 1 {
+		size = defaultPageSize
+	}
+
+	page := &dirPage{Page: n, Pages: max(1, (len(items)+size-1)/size)}
+	if n > page.Pages {
+		return nil, nil, mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no page %d of %d", n, page.Pages),
+		}
+	}
+	if n > 1 {
+		page.Prev = n - 1
+	}
+	if n < page.Pages {
+		page.Next = n + 1
+	}
+	return items[(n-1)*size : min(n*size, len(items))], page, nil
+}
+
+// writeDirHTML writes the HTML listing of a directory,
+// or of one page of it if page is not nil.
+// The caller must hold s.mu.
+func (s *server) writeDirHTML(w http.ResponseWriter, req *http.Request, entries []dirEntry, page *dirPage) error {
+	if isGallery(req) {
+		return s.writeGallery(w, req, entries, page)
+	}
+	data := struct {
+		Entries []dirEntry
+		Page    *dirPage
+	}{
+		Entries: entries,
+		Page:    page,
+	}
+	buf := new(bytes.Buffer)
+	if err := s.dirTemplate.Execute(buf, data); err != nil {
+		return errors.Wrap(err, "rendering directory listing")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return s.serveGenerated(w, req, "index.html", buf.Bytes())
+}
+
+// unprefixed is the name of the object (or .nfo or .m3u file) listed as the given directory item,
+// which is the item without its hash prefix.
+func unprefixed(item string) string {
+	if _, objName := parsePath(item); objName != "" {
+		return objName[8:]
+	}
+	return item
+}
+
+// propfindDir answers a PROPFIND request for a directory with the given items.
+// The objName function maps the name of a file item
+// to the name of the object
+// (or .nfo or .m3u file)
+// that it stands for.
+// The caller must hold s.mu.
+func (s *server) propfindDir(w http.ResponseWriter, req *http.Request, items []template.URL, objName func(string) string) error {
+	base := req.URL.EscapedPath()
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+
+	ms := davMultistatus{
+		Responses: []davResponse{davDirResponse(base, strings.Trim(req.URL.Path, "/"))},
+	}
+
+	depth, err := davDepth(req)
+	if err != nil {
+		return err
+	}
+	if depth > 0 {
+		for _, item := range items {
+			name := string(item)
+			if dir, ok := strings.CutSuffix(name, "/"); ok {
+				// Some directory items are escaped and some are not.
+				if u, err := url.PathUnescape(dir); err == nil {
+					dir = u
+				}
+				ms.Responses = append(ms.Responses, davDirResponse(base+url.PathEscape(dir)+"/", dir))
+				continue
+			}
+			ms.Responses = append(ms.Responses, s.davFileResponse(base+url.PathEscape(name), name, objName(name)))
+		}
+	}
+
+	return writeMultistatus(w, ms)
+}
+
+// propfindFile answers a PROPFIND request for one of the items of a directory
+// (whose name, without the hash prefix, is objName).
+// The caller must hold s.mu.
+func (s *server) propfindFile(w http.ResponseWriter, req *http.Request, objName string) error {
+	var (
+		ext      = filepath.Ext(objName)
+		rootName = strings.TrimSuffix(objName, ext)
+	)
+	switch ext {
+	case ".nfo", ".m3u":
+		if _, ok := s.mediaObjName(rootName); !ok {
+			return mid.CodeErr{C: http.StatusNotFound, Err: fmt.Errorf("no title %s", rootName)}
+		}
+	default:
+		if _, ok := s.artworkItem(objName); !ok && !s.objNames.Has(objName) {
+			return mid.CodeErr{C: http.StatusNotFound, Err: fmt.Errorf("no object %s", objName)}
+		}
+	}
+
+	if _, err := davDepth(req); err != nil {
+		return err
+	}
+
+	path := req.URL.EscapedPath()
+	return writeMultistatus(w, davMultistatus{
+		Responses: []davResponse{s.davFileResponse(path, filepath.Base(req.URL.Path), objName)},
+	})
+}
+
+// davDepth parses the Depth header of a PROPFIND request.
+// A depth of infinity (the default) is treated as 1,
+// which is all that Kodi asks for.
+func davDepth(req *http.Request) (int, error) {
+	switch req.Header.Get("Depth") {
+	case "0":
+		return 0, nil
+	case "1", "", "infinity":
+		return 1, nil
+	default:
+		return 0, mid.CodeErr{
+			C:   http.StatusBadRequest,
+			Err: fmt.Errorf("bad Depth header %q", req.Header.Get("Depth")),
+		}
+	}
+}
+
+func davDirResponse(href, name string) davResponse {
+	return davResponse{
+		Href: href,
+		Propstat: davPropstat{
+			Prop: davProp{
+				DisplayName:  filepath.Base("/" + name),
+				ResourceType: davResourceType{Collection: &struct{}{}},
+			},
+			Status: "HTTP/1.1 200 OK",
+		},
+	}
+}
+
+// davFileResponse describes a directory item named name,
+// standing for the object (or .nfo or .m3u file) objName.
+// The caller must hold s.mu.
+func (s *server) davFileResponse(href, name, objName string) davResponse {
+	prop := davProp{DisplayName: name}
+
+	var (
+		ext      = filepath.Ext(objName)
+		rootName = strings.TrimSuffix(objName, ext)
+		modTime  time.Time
+	)
+	switch ext {
+	case ".nfo":
+		prop.ContentType = "application/xml"
+		if mediaObj, ok := s.mediaObjName(rootName); ok {
+			modTime = s.objCreated[mediaObj]
+		}
+	case ".m3u":
+		prop.ContentType = "audio/x-mpegurl"
+		if mediaObj, ok := s.mediaObjName(rootName); ok {
+			modTime = s.objCreated[mediaObj]
+		}
+	default:
+		prop.ContentLength = s.objSize[objName]
+		modTime = s.objCreated[objName]
+	}
+	if !modTime.IsZero() {
+		prop.LastModified = modTime.UTC().Format(http.TimeFormat)
+	}
+
+	return davResponse{
+		Href: href,
+		Propstat: davPropstat{
+			Prop:   prop,
+			Status: "HTTP/1.1 200 OK",
+		},
+	}
+}
+
+func writeMultistatus(w http.ResponseWriter, ms davMultistatus) error {
+	ms.XMLNS = "DAV:"
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(xml.Header))
+	return errors.Wrap(xml.NewEncoder(w).Encode(ms), "writing XML")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestPropfindDir(t *testing.T) {
+	var (
+		created = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		prefix  = rootNamePrefix("The Thin Man")
+		s       = &server{
+			objNames:   set.New("The Thin Man.iso"),
+			objSize:    map[string]int64{"The Thin Man.iso": 12345},
+			objCreated: map[string]time.Time{"The Thin Man.iso": created},
+		}
+		items = []template.URL{
+			template.URL(prefix + "The Thin Man.iso"),
+			template.URL(prefix + "The Thin Man.nfo"),
+			template.URL("sets/"),
+		}
+	)
+
+	for _, depth := range []string{"0", "1"} {
+		t.Run("depth_"+depth, func(t *testing.T) {
+			req := httptest.NewRequest("PROPFIND", "/", nil)
+			req.Header.Set("Depth", depth)
+			rec := httptest.NewRecorder()
+
+			if err := s.writeDir(rec, req, items); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusMultiStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, http.StatusMultiStatus)
+			}
+
+			var got struct {
+				Responses []struct {
+					Href          string    `xml:"href"`
+					Collection    *struct{} `xml:"propstat>prop>resourcetype>collection"`
+					ContentLength int64     `xml:"propstat>prop>getcontentlength"`
+					LastModified  string    `xml:"propstat>prop>getlastmodified"`
+				} `xml:"response"`
+			}
+			if err := xml.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			wantLen := 1
+			if depth == "1" {
+				wantLen = 4
+			}
+			if len(got.Responses) != wantLen {
+				t.Fatalf("got %d responses, want %d", len(got.Responses), wantLen)
+			}
+			if r := got.Responses[0]; r.Href != "/" || r.Collection == nil {
+				t.Errorf("got first response %+v, want the collection /", r)
+			}
+			if depth == "0" {
+				return
+			}
+
+			if r := got.Responses[1]; r.Href != "/"+prefix+"The%20Thin%20Man.iso" || r.Collection != nil || r.ContentLength != 12345 || r.LastModified != created.Format(http.TimeFormat) {
+				t.Errorf("got media response %+v", r)
+			}
+			if r := got.Responses[3]; r.Href != "/sets/" || r.Collection == nil {
+				t.Errorf("got sets response %+v", r)
+			}
+		})
+	}
+}
+
+func TestConditionalDir(t *testing.T) {
+	s := &server{
+		dirTemplate:  template.Must(template.New("").Parse(dirTemplate)),
+		objNames:     set.New("The Thin Man.iso"),
+		objNamesTime: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	items := []template.URL{template.URL(rootNamePrefix("The Thin Man") + "The Thin Man.iso")}
+
+	rec := httptest.NewRecorder()
+	if err := s.writeDir(rec, httptest.NewRequest("GET", "/", nil), items); err != nil {
+		t.Fatal(err)
+	}
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got status %d and ETag %q", rec.Code, etag)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	if err := s.writeDir(rec, req, items); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNotModified)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-Modified-Since", s.objNamesTime.Format(http.TimeFormat))
+	rec = httptest.NewRecorder()
+	if err := s.writeDir(rec, req, items); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNotModified)
+	}
+}
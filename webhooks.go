@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+// With -webhooks URL,...,
+// the server posts a message to those URLs
+// when it notices new titles in the bucket
+// and when streaming errors exceed the -webhook-errors threshold;
+// and ssupdate posts a summary when it finishes.
+// Slack and Discord webhook URLs get messages in those services' formats;
+// any other URL gets a JSON object (see webhookEvent).
+
+const (
+	// How long to wait for a webhook to answer.
+	webhookTimeout = 30 * time.Second
+
+	// The period over which streaming errors are counted for -webhook-errors.
+	webhookErrorWindow = 10 * time.Minute
+
+	// The most titles to name in a message.
+	webhookMaxTitles = 20
+)
+
+type webhookKind int
+
+const (
+	webhookJSON webhookKind = iota
+	webhookSlack
+	webhookDiscord
+)
+
+type webhook struct {
+	url  string
+	kind webhookKind
+}
+
+// Webhook event types.
+const (
+	eventTitlesAdded  = "titles_added"
+	eventSSUpdate     = "ssupdate"
+	eventStreamErrors = "stream_errors"
+	eventAlert        = "alert" // from the watchdog (see watchdog.go)
+)
+
+// webhookEvent is what a generic webhook receives.
+type webhookEvent struct {
+	Event  string    `json:"event"`
+	Text   string    `json:"text"`
+	Titles []string  `json:"titles,omitempty"` // for titles_added
+	Errors int64     `json:"errors,omitempty"` // for stream_errors
+	Failed bool      `json:"failed,omitempty"` // for ssupdate
+	Time   time.Time `json:"time"`
+}
+
+// parseWebhooks parses the value of the -webhooks flag,
+// a comma-separated list of URLs.
+// Since webhook URLs usually embed a token,
+// they are kept out of the log.
+func parseWebhooks(spec string) ([]webhook, error) {
+	var result []webhook
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("bad webhook URL %s", s)
+		}
+		logRedactor.addSecret(s)
+
+		kind := webhookJSON
+		switch host := u.Hostname(); {
+		case host == "hooks.slack.com":
+			kind = webhookSlack
+		case (host == "discord.com" || host == "discordapp.com") && strings.HasPrefix(u.Path, "/api/webhooks/"):
+			kind = webhookDiscord
+		}
+		result = append(result, webhook{url: s, kind: kind})
+	}
+	return result, nil
+}
+
+// payload is the body to post to the webhook for ev.
+func (h webhook) payload(ev webhookEvent) any {
+	switch h.kind {
+	case webhookSlack:
+		return map[string]string{"text": ev.Text}
+	case webhookDiscord:
+		text := ev.Text
+		if len(text) > 2000 { // Discord's limit
+			text = text[:1999] + "…"
+		}
+		return map[string]string{"content": text}
+	default:
+		return ev
+	}
+}
+
+func (h webhook) send(ctx context.Context, ev webhookEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(h.payload(ev))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// sendWebhooks posts ev to each of hooks.
+// Errors are logged: a webhook that is down should not trouble its caller.
+func sendWebhooks(ctx context.Context, hooks []webhook, ev webhookEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	for _, h := range hooks {
+		if err := h.send(ctx, ev); err != nil {
+			log.Printf("Error sending %s webhook: %s", ev.Event, err)
+		}
+	}
+}
+
+// newTitles returns the media objects in after that are not in before, sorted.
+func newTitles(before, after set.Of[string]) []string {
+	var result []string
+	for objName := range after {
+		if isMediaExt(filepath.Ext(objName)) && !before.Has(objName) {
+			result = append(result, objName)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// titlesAddedEvent is the webhook event announcing new titles in the bucket.
+func titlesAddedEvent(bucketName string, titles []string) webhookEvent {
+	names := titles
+	if len(names) > webhookMaxTitles {
+		names = names[:webhookMaxTitles]
+	}
+	text := fmt.Sprintf("%d new title(s) in gs://%s: %s", len(titles), bucketName, strings.Join(names, ", "))
+	if n := len(titles) - len(names); n > 0 {
+		text += fmt.Sprintf(", and %d more", n)
+	}
+	return webhookEvent{Event: eventTitlesAdded, Text: text, Titles: titles}
+}
+
+// watchStreamFailures posts to the -webhooks
+// whenever threshold or more streaming errors happen within webhookErrorWindow,
+// until the context is canceled.
+func (s *server) watchStreamFailures(ctx context.Context, threshold int64) {
+	ticker := time.NewTicker(webhookErrorWindow)
+	defer ticker.Stop()
+
+	last := s.health.streamFailures.Load()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			n := s.health.streamFailures.Load()
+			if failures := n - last; failures >= threshold {
+				sendWebhooks(ctx, s.webhooks, webhookEvent{
+					Event:  eventStreamErrors,
+					Text:   fmt.Sprintf("%d streaming error(s) from gs://%s in the last %s", failures, s.bucketName, webhookErrorWindow),
+					Errors: failures,
+				})
+			}
+			last = n
+		}
+	}
+}
+
+// ssupdateEvent is the webhook event summarizing an ssupdate run,
+// which ended with err.
+func ssupdateEvent(bucketName string, summary updateSummary, err error) webhookEvent {
+	text := fmt.Sprintf("ssupdate for gs://%s filled in %d of %d row(s)", bucketName, summary.updated, summary.rows)
+	if err != nil {
+		text = fmt.Sprintf("ssupdate for gs://%s failed after filling in %d of %d row(s): %s", bucketName, summary.updated, summary.rows, err)
+	}
+	return webhookEvent{Event: eventSSUpdate, Text: text, Failed: err != nil}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestParseWebhooks(t *testing.T) {
+	hooks, err := parseWebhooks("https://hooks.slack.com/services/T0/B0/x, https://discord.com/api/webhooks/1/y,https://example.com/hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []webhookKind{webhookSlack, webhookDiscord, webhookJSON}
+	if len(hooks) != len(want) {
+		t.Fatalf("got %d webhooks, want %d", len(hooks), len(want))
+	}
+	for i, h := range hooks {
+		if h.kind != want[i] {
+			t.Errorf("%s: got kind %d, want %d", h.url, h.kind, want[i])
+		}
+	}
+
+	if _, err := parseWebhooks("hooks.slack.com/services/T0/B0/x"); err == nil {
+		t.Error("no error for a URL without a scheme")
+	}
+}
+
+func TestSendWebhooks(t *testing.T) {
+	var got []webhookEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var ev webhookEvent
+		if err := json.NewDecoder(req.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		got = append(got, ev)
+	}))
+	defer srv.Close()
+
+	hooks, err := parseWebhooks(srv.URL + "/hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := set.New("The Thin Man.iso", "Top Hat.mp4")
+	after := set.New("The Thin Man.iso", "Top Hat.mp4", "Swing Time.mp4", "Swing Time.jpg", "Follow the Fleet.mkv")
+	titles := newTitles(before, after)
+	if want := []string{"Follow the Fleet.mkv", "Swing Time.mp4"}; !reflect.DeepEqual(titles, want) {
+		t.Fatalf("got new titles %v, want %v", titles, want)
+	}
+
+	ctx := context.Background()
+	sendWebhooks(ctx, hooks, titlesAddedEvent("media", titles))
+	sendWebhooks(ctx, hooks, ssupdateEvent("media", updateSummary{rows: 10, updated: 3, failed: []int{7}}, errors.New("1 row(s) failed: [7]")))
+
+	if len(got) != 2 {
+		t.Fatalf("got %d events, want 2", len(got))
+	}
+	if ev := got[0]; ev.Event != eventTitlesAdded || !reflect.DeepEqual(ev.Titles, titles) || !strings.Contains(ev.Text, "Swing Time.mp4") {
+		t.Errorf("got %+v", ev)
+	}
+	if ev := got[1]; ev.Event != eventSSUpdate || !ev.Failed || !strings.Contains(ev.Text, "3 of 10") {
+		t.Errorf("got %+v", ev)
+	}
+
+	slack := webhook{kind: webhookSlack}.payload(got[0])
+	if m, ok := slack.(map[string]string); !ok || m["text"] != got[0].Text {
+		t.Errorf("got Slack payload %v", slack)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"golang.org/x/time/rate"
+)
+
+// wikipediaScraper is the Scraper for English Wikipedia,
+// which it reaches through Wikidata's record of each title's IMDb ID.
+// It supplies only plot summaries,
+// which for older titles are often fuller than IMDb's,
+// so it belongs after another scraper in a chain,
+// as in -scrapers imdb,wikipedia.
+type wikipediaScraper struct {
+	cl *http.Client
+}
+
+func init() {
+	registerScraper("wikipedia", func(conf scraperConfig) (Scraper, error) {
+		transport := conf.transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		cl := &http.Client{
+			Transport: &limitedTransport{
+				limiter:   rate.NewLimiter(rate.Every(time.Second), 1),
+				transport: transport,
+			},
+		}
+		return &wikipediaScraper{cl: cl}, nil
+	})
+}
+
+func (*wikipediaScraper) Name() string { return "wikipedia" }
+
+// Wikimedia asks API clients to identify themselves.
+const wikimediaUserAgent = "kodigcs (https://github.com/bobg/kodigcs)"
+
+func (s *wikipediaScraper) get(ctx context.Context, apiURL string, params url.Values, result any) error {
+	params.Set("format", "json")
+	u := apiURL + "?" + params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return errors.Wrapf(err, "building request to GET %s", u)
+	}
+	req.Header.Set("User-Agent", wikimediaUserAgent)
+
+	resp, err := s.cl.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "getting %s", u)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d (%s) getting %s", resp.StatusCode, http.StatusText(resp.StatusCode), u)
+	}
+	return errors.Wrapf(json.NewDecoder(resp.Body).Decode(result), "decoding %s", u)
+}
+
+const (
+	wikidataAPI  = "https://www.wikidata.org/w/api.php"
+	wikipediaAPI = "https://en.wikipedia.org/w/api.php"
+)
+
+func (s *wikipediaScraper) LookupByID(ctx context.Context, id string) (*titleInfo, error) {
+	id = parseIMDbID(id)
+	if !imdbIDRE.MatchString(id) {
+		return nil, errNotFound
+	}
+
+	// Find the Wikidata item with this IMDb ID (property P345).
+	var search struct {
+		Query struct {
+			Search []struct {
+				Title string `json:"title"`
+			} `json:"search"`
+		} `json:"query"`
+	}
+	err := s.get(ctx, wikidataAPI, url.Values{"action": {"query"}, "list": {"search"}, "srsearch": {"haswbstatement:P345=" + id}}, &search)
+	if err != nil {
+		return nil, err
+	}
+	if len(search.Query.Search) == 0 {
+		return nil, errNotFound
+	}
+	item := search.Query.Search[0].Title
+
+	// Find the item's English Wikipedia article.
+	var entities struct {
+		Entities map[string]struct {
+			Sitelinks map[string]struct {
+				Title string `json:"title"`
+			} `json:"sitelinks"`
+		} `json:"entities"`
+	}
+	err = s.get(ctx, wikidataAPI, url.Values{"action": {"wbgetentities"}, "ids": {item}, "props": {"sitelinks"}, "sitefilter": {"enwiki"}}, &entities)
+	if err != nil {
+		return nil, err
+	}
+	article := entities.Entities[item].Sitelinks["enwiki"].Title
+	if article == "" {
+		return nil, errNotFound
+	}
+
+	// Get the article's text and find its plot section.
+	var extracts struct {
+		Query struct {
+			Pages []struct {
+				Extract string `json:"extract"`
+			} `json:"pages"`
+		} `json:"query"`
+	}
+	err = s.get(ctx, wikipediaAPI, url.Values{"action": {"query"}, "prop": {"extracts"}, "explaintext": {"1"}, "exsectionformat": {"wiki"}, "titles": {article}, "formatversion": {"2"}}, &extracts)
+	if err != nil {
+		return nil, err
+	}
+	if len(extracts.Query.Pages) == 0 {
+		return nil, errNotFound
+	}
+	plot := plotSection(extracts.Query.Pages[0].Extract)
+	if plot == "" {
+		return nil, errNotFound
+	}
+
+	return &titleInfo{ID: id, Summary: plot}, nil
+}
+
+// SearchByTitle is not supported.
+func (*wikipediaScraper) SearchByTitle(context.Context, string, int) ([]searchResult, error) {
+	return nil, nil
+}
+
+// Headings of the sections of Wikipedia articles that summarize a film's plot.
+var plotHeadings = set.New("plot", "plot summary", "synopsis", "story", "premise")
+
+// plotSection returns the text of the plot section
+// of the plain-text extract of a Wikipedia article
+// (in which top-level section headings look like "== Plot =="),
+// or the empty string if there is none.
+func plotSection(extract string) string {
+	var (
+		paras  []string
+		inPlot bool
+	)
+	for _, line := range strings.Split(extract, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "==") {
+			if strings.HasPrefix(line, "===") {
+				continue // a subsection heading
+			}
+			if inPlot {
+				break
+			}
+			heading := strings.ToLower(strings.TrimSpace(strings.Trim(line, "=")))
+			inPlot = plotHeadings.Has(heading)
+			continue
+		}
+		if inPlot && line != "" {
+			paras = append(paras, line)
+		}
+	}
+	return strings.Join(paras, "\n\n")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/go-generics/v4/set"
+	"github.com/bobg/mid"
+)
+
+// The virtual directory under which titles are listed by decade and by year, in -years mode.
+const yearsDir = "years"
+
+// yearDirs returns the names of the virtual folders under yearsDir
+// in which a title from the given year is listed:
+// its decade (e.g. 1970s) and the year itself (e.g. 1977).
+func yearDirs(year int) []string {
+	if year <= 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("%ds", year/10*10), strconv.Itoa(year)}
+}
+
+// yearDirLess orders the folders under yearsDir chronologically,
+// with each decade just before its years.
+func yearDirLess(a, b string) bool {
+	aDecade, aOK := strings.CutSuffix(a, "s")
+	bDecade, bOK := strings.CutSuffix(b, "s")
+	aNum, _ := strconv.Atoi(aDecade)
+	bNum, _ := strconv.Atoi(bDecade)
+	if aNum != bNum {
+		return aNum < bNum
+	}
+	return aOK && !bOK
+}
+
+// hasYears tells whether any visible title has a year.
+// The caller must hold s.mu.
+func (s *server) hasYears() bool {
+	for _, info := range s.infoMap {
+		if !info.hidden && info.Year > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// handleYearDir serves the list of decades and years (when name is empty)
+// or the titles from one decade or year.
+// Like the genre folders,
+// these list titles that are also listed in their usual folders.
+func (s *server) handleYearDir(w http.ResponseWriter, req *http.Request, name string) error {
+	log.Printf("serving year directory \"%s\"", name)
+
+	ctx := req.Context()
+
+	if err := s.ensureObjNames(ctx); err != nil {
+		return errors.Wrap(err, "getting obj names")
+	}
+	if err := s.ensureInfoMap(ctx); err != nil {
+		return errors.Wrap(err, "getting info map")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if name == "" {
+		names := set.New[string]()
+		for _, info := range s.infoMap {
+			if !info.hidden {
+				names.Add(yearDirs(info.Year)...)
+			}
+		}
+		sorted := names.Slice()
+		sort.Slice(sorted, func(i, j int) bool { return yearDirLess(sorted[i], sorted[j]) })
+		var items []template.URL
+		for _, name := range sorted {
+			items = append(items, template.URL(name+"/"))
+		}
+		return s.writeDir(w, req, items)
+	}
+
+	items := s.titleItems(s.accessible(ctx, func(info movieInfo, ok bool) bool {
+		for _, dir := range yearDirs(info.Year) {
+			if dir == name {
+				return true
+			}
+		}
+		return false
+	}))
+	if len(items) == 0 {
+		return mid.CodeErr{
+			C:   http.StatusNotFound,
+			Err: fmt.Errorf("no titles from %s", name),
+		}
+	}
+
+	return s.writeDir(w, req, items)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bobg/go-generics/v4/set"
+)
+
+func TestYearDirs(t *testing.T) {
+	now := time.Now()
+	s := &server{
+		dirTemplate:  template.Must(template.New("").Parse(dirTemplate)),
+		objNames:     set.New("The Thin Man.iso", "Top Hat.mp4", "Annie Hall.mp4", "Untitled.mp4"),
+		objNamesTime: now,
+		infoMap: map[string]movieInfo{
+			"The Thin Man": {Title: "The Thin Man", Year: 1934},
+			"Top Hat":      {Title: "Top Hat", Year: 1935},
+			"Annie Hall":   {Title: "Annie Hall", Year: 1977},
+		},
+		infoMapTime: now,
+		years:       true,
+	}
+
+	check := func(path string, want ...string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := s.handleAPIDir(rec, httptest.NewRequest("GET", "/api/dir/"+path, nil)); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		var got apiDir
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Entries) != len(want) {
+			t.Fatalf("%s: got %+v, want %v", path, got.Entries, want)
+		}
+		for i, e := range got.Entries {
+			if e.Name != want[i] {
+				t.Errorf("%s: entry %d is %s, want %s", path, i, e.Name, want[i])
+			}
+		}
+	}
+
+	check("years/", "1930s", "1934", "1935", "1970s", "1977")
+
+	var (
+		thinMan = rootNamePrefix("The Thin Man")
+		topHat  = rootNamePrefix("Top Hat")
+	)
+	check("years/1930s/", thinMan+"The Thin Man.iso", thinMan+"The Thin Man.nfo", topHat+"Top Hat.mp4", topHat+"Top Hat.nfo")
+	check("years/1935/", topHat+"Top Hat.mp4", topHat+"Top Hat.nfo")
+}